@@ -0,0 +1,212 @@
+use crate::{
+    audit::{AuditEntry, AuditQuery},
+    crash::Crash,
+    error::AuditError,
+    job::{JobRecord, JobStatus, Progress},
+    model::Language,
+    problem::Problem,
+    response::TaskResponse,
+    toolchain::Readiness,
+    AppState,
+};
+use axum::{
+    extract::{Path, Query, State},
+    http::StatusCode,
+    response::{IntoResponse, Response},
+    Json,
+};
+use serde::{Deserialize, Serialize};
+use serde_json::Value;
+use tracing::info;
+use uuid::Uuid;
+
+/// A job which is queued or running, as it is listed to admins.
+#[derive(Serialize)]
+#[serde(rename_all = "camelCase")]
+pub struct ActiveJob {
+    id: Uuid,
+    status: JobStatus,
+    language: Language,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    exercise_id: Option<String>,
+    /// When the job was accepted, in milliseconds since the unix epoch.
+    submitted_at: u64,
+    /// When a worker began checking the submission, in milliseconds since the unix epoch.
+    #[serde(skip_serializing_if = "Option::is_none")]
+    started_at: Option<u64>,
+    /// The latest progress of the job, e.g. the test case which is running.
+    progress: Option<Progress>,
+}
+
+impl From<JobRecord> for ActiveJob {
+    fn from(mut record: JobRecord) -> Self {
+        let submission = &record.submission;
+
+        Self {
+            id: record.id,
+            status: record.status,
+            language: Language::deserialize(&submission["language"]).unwrap_or_default(),
+            exercise_id: submission["exerciseId"].as_str().map(str::to_string),
+            submitted_at: record.submitted_at,
+            started_at: record.started_at,
+            progress: record.progress.pop(),
+        }
+    }
+}
+
+/// The state of the worker pool, as it is shown to admins.
+#[derive(Serialize)]
+#[serde(rename_all = "camelCase")]
+pub struct Workers {
+    workers: usize,
+    /// The number of workers which are checking a submission.
+    active: usize,
+    /// The number of admitted jobs which are waiting for a worker.
+    queued: usize,
+    queue_size: usize,
+    /// Whether submissions are rejected for maintenance, while the admitted jobs are still checked.
+    paused: bool,
+    /// Whether submissions are rejected, as the disk is running low on space.
+    low_disk_space: bool,
+    shutting_down: bool,
+}
+
+impl Workers {
+    fn of(state: &AppState) -> Self {
+        Self {
+            workers: state.pool.worker_count(),
+            active: state.pool.active_workers(),
+            queued: state.pool.queue_depth(),
+            queue_size: state.pool.queue_size(),
+            paused: state.pool.is_paused(),
+            low_disk_space: state.pool.is_low_on_disk_space(),
+            shutting_down: state.pool.is_shutting_down(),
+        }
+    }
+}
+
+/// Responds with every job which is queued or running, oldest first.
+pub async fn jobs(State(state): State<AppState>) -> Json<Vec<ActiveJob>> {
+    Json(
+        state
+            .jobs
+            .active()
+            .into_iter()
+            .map(ActiveJob::from)
+            .collect(),
+    )
+}
+
+/// Responds with the panic the latest judgment of a job crashed with, along with its backtrace.
+pub async fn crash(
+    State(state): State<AppState>,
+    Path(id): Path<Uuid>,
+) -> Result<Json<Crash>, Response> {
+    let record = state
+        .jobs
+        .record(id)
+        .ok_or_else(|| TaskResponse::NotFound.into_response())?;
+
+    record.crash.map(|crash| Json(*crash)).ok_or_else(|| {
+        Problem::new(StatusCode::NOT_FOUND, "notCrashed", "the job did not crash").into_response()
+    })
+}
+
+/// Responds with the state of the worker pool.
+pub async fn workers(State(state): State<AppState>) -> Json<Workers> {
+    Json(Workers::of(&state))
+}
+
+/// Responds with the config mozart is running with, along with the settings which were reloaded since it started, where
+/// the secrets are redacted.
+pub async fn config(State(state): State<AppState>) -> Json<Value> {
+    Json(state.tenants.named(None).config.redacted())
+}
+
+/// Which languages to preheat, as a comma separated list of their names.
+#[derive(Deserialize)]
+pub struct PreheatQuery {
+    lang: Option<String>,
+}
+
+/// Preheats the toolchains of the languages in the background, or of every supported language unless they are given,
+/// like the toolchains which are preheated on startup, responding with how far the toolchain of every language got.
+///
+/// A toolchain which is still heating is not preheated again.
+pub async fn preheat(
+    State(state): State<AppState>,
+    Query(query): Query<PreheatQuery>,
+) -> Result<(StatusCode, Json<Vec<Readiness>>), Problem> {
+    let languages = match &query.lang {
+        None => Language::ALL
+            .into_iter()
+            .filter(Language::is_supported)
+            .collect(),
+        Some(names) => names
+            .split(',')
+            .map(str::trim)
+            .map(|name| {
+                Language::ALL
+                    .into_iter()
+                    .find(|language| language.as_str() == name)
+                    .filter(Language::is_supported)
+                    .ok_or_else(|| {
+                        Problem::new(
+                            StatusCode::BAD_REQUEST,
+                            "unsupportedLanguage",
+                            format!("the language {name} is not supported by this build"),
+                        )
+                    })
+            })
+            .collect::<Result<Vec<Language>, Problem>>()?,
+    };
+
+    for language in languages {
+        if !state.toolchains.start_heating(language) {
+            continue;
+        }
+        info!(%language, "preheating toolchain");
+        state.warm.preheat(language);
+        let (toolchains, config) = (state.toolchains.clone(), state.config.clone());
+        // a toolchain which does not have its pinned version is failed, which is reported by the readiness checks
+        tokio::spawn(async move {
+            let _ = toolchains.preheat(language, &config).await;
+        });
+    }
+
+    Ok((StatusCode::ACCEPTED, Json(state.toolchains.readiness())))
+}
+
+/// Responds with the latest judgments in the audit log which match the query, newest first.
+pub async fn audit(
+    State(state): State<AppState>,
+    Query(query): Query<AuditQuery>,
+) -> Result<Json<Vec<AuditEntry>>, AuditError> {
+    let audit = state.audit.ok_or(AuditError::Disabled)?;
+    let entries = tokio::task::spawn_blocking(move || audit.query(&query))
+        .await
+        .expect("querying the audit log should not panic")?;
+
+    Ok(Json(entries))
+}
+
+/// Rejects every submission until intake is resumed, without stopping the jobs which were admitted before, responding
+/// with the state of the worker pool.
+///
+/// Pausing is not persisted, so mozart accepts submissions again once it is restarted.
+pub async fn pause(State(state): State<AppState>) -> Json<Workers> {
+    if state.pool.pause() {
+        info!("paused intake of submissions");
+    }
+
+    Json(Workers::of(&state))
+}
+
+/// Accepts submissions again after intake was paused, responding with the state of the worker pool.
+pub async fn resume(State(state): State<AppState>) -> Json<Workers> {
+    if state.pool.resume() {
+        info!("resumed intake of submissions");
+    }
+
+    Json(Workers::of(&state))
+}
@@ -0,0 +1,21 @@
+//! The types of the requests and responses of the HTTP API, as they are sent over the wire, so clients written in Rust
+//! can build submissions and read results with the same types the server uses, rather than mirroring them.
+//!
+//! Every type is re-exported from the module it is defined in, which is where it is documented, and serializes to the
+//! JSON which `openapi.json` describes, except for the [`TaskResponse`] the task endpoints respond with, whose bodies
+//! are the [`JobRecord`], [`JobStatus`], and [`SubmitResponse`] it holds.
+
+pub use crate::{
+    job::{JobRecord, JobStatus, Progress},
+    model::{
+        Analysis, Benchmark, BenchmarkResult, Checker, CompileRequest, CompileResult,
+        DecidingFailure, Diagnostic, Encoding, FailureKind, Hook, HookResult, HookResults,
+        Interactor, Language, Message, NetworkPolicy, Parameter, Priority, Reproduction,
+        RuntimeErrorCause, Severity, Speaker, Submission, SubmissionResult, TestCase,
+        TestCaseFailureReason, TestCaseResult, TestCaseTiming, TestCaseTranscript, TestOutput,
+        TestResult, Timings, Transcript, Verdict, VerdictMode, VerdictPolicy,
+    },
+    response::{Invalid, JobResponse, Quota, SubmitResponse, TaskResponse},
+    revision::Revision,
+    score::{GroupScore, Scoring, TestGroup},
+};
@@ -0,0 +1,217 @@
+use crate::{callback::hmac_sha256, config::Config, error::StoreError};
+use reqwest::{Client, Method, StatusCode, Url};
+use sha2::{Digest, Sha256};
+use std::{
+    fmt::Write,
+    future::Future,
+    thread,
+    time::{Duration, SystemTime, UNIX_EPOCH},
+};
+use uuid::Uuid;
+
+/// How long a single request to the archive may take.
+const TIMEOUT: Duration = Duration::from_secs(30);
+
+/// The service requests to an S3-compatible object storage are signed for.
+const SERVICE: &str = "s3";
+
+/// Compacted records of jobs whose retention has passed, kept in a bucket of an S3-compatible object storage as one
+/// JSON object per job, named by the id of the job.
+///
+/// Every request is signed with AWS signature version 4, which every S3-compatible object storage accepts.
+pub struct Archive {
+    /// The URL of the bucket in path style, to which the name of an object is appended.
+    bucket: Url,
+    region: String,
+    access_key: String,
+    secret_key: String,
+}
+
+impl Archive {
+    /// Creates the configured archive, if results should be archived at all.
+    pub fn from_config(config: &Config) -> Option<Self> {
+        let mut bucket = Url::parse(config.archive_url.as_ref()?).ok()?;
+        if !bucket.path().ends_with('/') {
+            bucket.set_path(&format!("{}/", bucket.path()));
+        }
+
+        Some(Self {
+            bucket,
+            region: config.archive_region.clone(),
+            access_key: config.archive_access_key.clone()?,
+            secret_key: config.archive_secret_key.clone()?,
+        })
+    }
+
+    /// Stores the record of a job, replacing an earlier record of the same job.
+    pub fn put(&self, id: Uuid, body: Vec<u8>) -> Result<(), StoreError> {
+        match self.request(Method::PUT, id, body)? {
+            (status, _) if status.is_success() => Ok(()),
+            (status, _) => Err(StoreError::Archive(format!(
+                "storing {id} failed with {status}"
+            ))),
+        }
+    }
+
+    /// Fetches the record of a job, which is `None` if the job was never archived.
+    pub fn get(&self, id: Uuid) -> Result<Option<Vec<u8>>, StoreError> {
+        match self.request(Method::GET, id, Vec::new())? {
+            (status, body) if status.is_success() => Ok(Some(body)),
+            (StatusCode::NOT_FOUND, _) => Ok(None),
+            (status, _) => Err(StoreError::Archive(format!(
+                "fetching {id} failed with {status}"
+            ))),
+        }
+    }
+
+    /// Sends a signed request for the object of the job, responding with its status and body.
+    fn request(
+        &self,
+        method: Method,
+        id: Uuid,
+        body: Vec<u8>,
+    ) -> Result<(StatusCode, Vec<u8>), StoreError> {
+        let url = self
+            .bucket
+            .join(&format!("{id}.json"))
+            .map_err(|err| StoreError::Archive(err.to_string()))?;
+        let timestamp = SystemTime::now()
+            .duration_since(UNIX_EPOCH)
+            .map_or(0, |since| since.as_secs());
+        let payload_hash = hex(&Sha256::digest(&body));
+        let date = amz_date(timestamp);
+        let authorization = self.authorization(&method, &url, &payload_hash, &date);
+
+        blocking(async move {
+            let client = Client::builder().timeout(TIMEOUT).build()?;
+            let response = client
+                .request(method, url)
+                .header("x-amz-content-sha256", payload_hash)
+                .header("x-amz-date", date)
+                .header("authorization", authorization)
+                .body(body)
+                .send()
+                .await?;
+            let status = response.status();
+
+            Ok::<_, reqwest::Error>((status, response.bytes().await?.to_vec()))
+        })
+        .map_err(|err| StoreError::Archive(err.to_string()))
+    }
+
+    /// Creates the authorization header of a request, which signs its method, path, host, date, and payload.
+    fn authorization(&self, method: &Method, url: &Url, payload_hash: &str, date: &str) -> String {
+        let host = match url.port() {
+            Some(port) => format!("{}:{port}", url.host_str().unwrap_or_default()),
+            None => url.host_str().unwrap_or_default().to_string(),
+        };
+        let signed_headers = "host;x-amz-content-sha256;x-amz-date";
+        let canonical_request = format!(
+            "{method}\n{}\n\nhost:{host}\nx-amz-content-sha256:{payload_hash}\nx-amz-date:{date}\n\n{signed_headers}\n{payload_hash}",
+            url.path()
+        );
+
+        let day = &date[..8];
+        let scope = format!("{day}/{}/{SERVICE}/aws4_request", self.region);
+        let string_to_sign = format!(
+            "AWS4-HMAC-SHA256\n{date}\n{scope}\n{}",
+            hex(&Sha256::digest(canonical_request.as_bytes()))
+        );
+        let key = signing_key(&self.secret_key, day, &self.region, SERVICE);
+        let signature = hex(&hmac_sha256(&key, string_to_sign.as_bytes()));
+
+        format!(
+            "AWS4-HMAC-SHA256 Credential={}/{scope}, SignedHeaders={signed_headers}, Signature={signature}",
+            self.access_key
+        )
+    }
+}
+
+/// Runs a request to completion on a runtime of its own, as the store is used synchronously, even from within the
+/// runtime of the server.
+fn blocking<T: Send>(request: impl Future<Output = T> + Send) -> T {
+    thread::scope(|scope| {
+        scope
+            .spawn(|| {
+                tokio::runtime::Builder::new_current_thread()
+                    .enable_all()
+                    .build()
+                    .expect("a runtime for the archive should build")
+                    .block_on(request)
+            })
+            .join()
+            .expect("a request to the archive should never panic")
+    })
+}
+
+/// Derives the key a request made on the day is signed with, which is scoped to the region and service.
+fn signing_key(secret_key: &str, day: &str, region: &str, service: &str) -> [u8; 32] {
+    let key = hmac_sha256(format!("AWS4{secret_key}").as_bytes(), day.as_bytes());
+    let key = hmac_sha256(&key, region.as_bytes());
+    let key = hmac_sha256(&key, service.as_bytes());
+
+    hmac_sha256(&key, b"aws4_request")
+}
+
+/// Formats the unix time in seconds as the basic ISO 8601 UTC time of a signed request, e.g. `20130524T000000Z`.
+fn amz_date(timestamp: u64) -> String {
+    let (days, seconds) = (timestamp / 86400, timestamp % 86400);
+
+    // the civil date of the days since the unix epoch, with years starting in march so leap days come last
+    let days = days as i64 + 719_468;
+    let era = days.div_euclid(146_097);
+    let day_of_era = days.rem_euclid(146_097);
+    let year_of_era =
+        (day_of_era - day_of_era / 1460 + day_of_era / 36524 - day_of_era / 146_096) / 365;
+    let day_of_year = day_of_era - (365 * year_of_era + year_of_era / 4 - year_of_era / 100);
+    let shifted_month = (5 * day_of_year + 2) / 153;
+    let day = day_of_year - (153 * shifted_month + 2) / 5 + 1;
+    let month = if shifted_month < 10 {
+        shifted_month + 3
+    } else {
+        shifted_month - 9
+    };
+    let year = year_of_era + era * 400 + i64::from(month <= 2);
+
+    format!(
+        "{year:04}{month:02}{day:02}T{:02}{:02}{:02}Z",
+        seconds / 3600,
+        seconds / 60 % 60,
+        seconds % 60
+    )
+}
+
+fn hex(bytes: &[u8]) -> String {
+    bytes.iter().fold(String::new(), |mut hex, byte| {
+        let _ = write!(hex, "{byte:02x}");
+        hex
+    })
+}
+
+#[cfg(test)]
+mod signature {
+    use super::{amz_date, hex, signing_key};
+
+    #[test]
+    fn signing_key_example() {
+        // the example of deriving a signing key in the documentation of AWS signature version 4
+        let actual = signing_key(
+            "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+            "20120215",
+            "us-east-1",
+            "iam",
+        );
+
+        assert_eq!(
+            hex(&actual),
+            "f4780e2d9f65fa895f9c67b32ce1baf0b0d8a43505a000a1a9e090d414db404d"
+        );
+    }
+
+    #[test]
+    fn dates() {
+        assert_eq!(amz_date(0), "19700101T000000Z");
+        assert_eq!(amz_date(1_369_353_600), "20130524T000000Z");
+        assert_eq!(amz_date(951_782_400 + 3661), "20000229T010101Z");
+    }
+}
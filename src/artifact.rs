@@ -0,0 +1,162 @@
+use std::{
+    fs::{self, File},
+    io::{self, Write},
+    os::unix::fs::PermissionsExt,
+    path::Path,
+};
+
+/// The size of a block of a tar archive, which headers and the contents of files are padded to.
+const BLOCK: usize = 512;
+
+/// Archives the artifacts of a compilation in the workspace as a tar file, replacing an earlier archive of the same job.
+///
+/// Nothing is archived if there are no artifacts, as for interpreted languages, so an earlier archive is only removed.
+/// The archive is written to a temporary file first, so a crash never leaves a partial archive behind.
+pub fn export(workspace: &Path, artifacts: &[String], archive: &Path) -> io::Result<()> {
+    if artifacts.is_empty() {
+        return match fs::remove_file(archive) {
+            Err(err) if err.kind() != io::ErrorKind::NotFound => Err(err),
+            _ => Ok(()),
+        };
+    }
+    if let Some(parent) = archive.parent() {
+        fs::create_dir_all(parent)?;
+    }
+
+    let temporary = archive.with_extension("tar.tmp");
+    let mut file = File::create(&temporary)?;
+    for artifact in artifacts {
+        let path = workspace.join(artifact);
+        let mode = fs::metadata(&path)?.permissions().mode() & 0o777;
+        let contents = fs::read(&path)?;
+        file.write_all(&header(artifact, mode, contents.len() as u64)?)?;
+        file.write_all(&contents)?;
+        file.write_all(&vec![0; padding(contents.len())])?;
+    }
+    // an archive ends with two empty blocks
+    file.write_all(&[0; 2 * BLOCK])?;
+    file.sync_all()?;
+
+    fs::rename(&temporary, archive)
+}
+
+/// Creates the ustar header of a regular file, whose path is split into a prefix and a name if it is long.
+fn header(path: &str, mode: u32, size: u64) -> io::Result<[u8; BLOCK]> {
+    let too_long = || io::Error::new(io::ErrorKind::InvalidInput, format!("{path} is too long"));
+    let (prefix, name) = match path.len() {
+        0..=100 => ("", path),
+        _ => {
+            let split = path[..path.len().min(156)]
+                .rfind('/')
+                .filter(|split| path.len() - split - 1 <= 100)
+                .ok_or_else(too_long)?;
+            (&path[..split], &path[split + 1..])
+        }
+    };
+
+    let mut header = [0; BLOCK];
+    header[..name.len()].copy_from_slice(name.as_bytes());
+    octal(&mut header[100..108], u64::from(mode));
+    octal(&mut header[108..116], 0);
+    octal(&mut header[116..124], 0);
+    octal(&mut header[124..136], size);
+    octal(&mut header[136..148], 0);
+    header[156] = b'0';
+    header[257..263].copy_from_slice(b"ustar\0");
+    header[263..265].copy_from_slice(b"00");
+    header[345..345 + prefix.len()].copy_from_slice(prefix.as_bytes());
+
+    // the checksum is computed with its own field taken as spaces
+    header[148..156].fill(b' ');
+    let checksum: u64 = header.iter().map(|&byte| u64::from(byte)).sum();
+    octal(&mut header[148..155], checksum);
+
+    Ok(header)
+}
+
+/// Writes a number as zero-padded octal digits terminated by a NUL, filling the field.
+fn octal(field: &mut [u8], value: u64) {
+    let width = field.len() - 1;
+    let digits = format!("{value:0width$o}");
+    field[..width].copy_from_slice(&digits.as_bytes()[digits.len() - width..]);
+    field[width] = 0;
+}
+
+fn padding(len: usize) -> usize {
+    (BLOCK - len % BLOCK) % BLOCK
+}
+
+#[cfg(test)]
+mod tar {
+    use super::{export, header, BLOCK};
+    use std::{env, fs, path::PathBuf};
+    use uuid::Uuid;
+
+    fn dir() -> PathBuf {
+        let dir = env::temp_dir().join(format!("artifact-{}", Uuid::new_v4()));
+        fs::create_dir_all(dir.join("pkg")).unwrap();
+        dir
+    }
+
+    #[test]
+    fn archives_nested_artifacts() {
+        let dir = dir();
+        fs::write(dir.join("Main.class"), b"main").unwrap();
+        fs::write(dir.join("pkg/Helper.class"), vec![7; 600]).unwrap();
+        let archive = dir.join("out/job.tar");
+
+        export(
+            &dir,
+            &[String::from("Main.class"), String::from("pkg/Helper.class")],
+            &archive,
+        )
+        .expect("the artifacts should be archived");
+        let actual = fs::read(&archive).unwrap();
+
+        // a header and a block of contents, a header and two blocks of contents, and the two empty blocks
+        assert_eq!(actual.len(), 7 * BLOCK);
+        assert_eq!(&actual[..10], b"Main.class");
+        assert_eq!(&actual[124..135], b"00000000004");
+        assert_eq!(&actual[BLOCK..BLOCK + 4], b"main");
+        assert_eq!(&actual[2 * BLOCK..2 * BLOCK + 16], b"pkg/Helper.class");
+        assert_eq!(&actual[2 * BLOCK + 257..2 * BLOCK + 262], b"ustar");
+        assert!(actual[5 * BLOCK..].iter().all(|&byte| byte == 0));
+        fs::remove_dir_all(dir).unwrap();
+    }
+
+    #[test]
+    fn removes_archive_without_artifacts() {
+        let dir = dir();
+        let archive = dir.join("job.tar");
+        fs::write(&archive, b"earlier").unwrap();
+
+        export(&dir, &[], &archive).expect("nothing should be archived");
+
+        assert!(!archive.exists());
+        assert!(export(&dir, &[], &archive).is_ok());
+        fs::remove_dir_all(dir).unwrap();
+    }
+
+    #[test]
+    fn checksums() {
+        let actual = header("solution", 0o755, 1024).unwrap();
+
+        // the checksum of a header is the sum of its bytes, with the checksum itself taken as spaces
+        let mut blanked = actual;
+        blanked[148..156].fill(b' ');
+        let expected: u64 = blanked.iter().map(|&byte| u64::from(byte)).sum();
+        assert_eq!(&actual[148..155], format!("{expected:06o}\0").as_bytes());
+        assert_eq!(&actual[100..108], b"0000755\0");
+    }
+
+    #[test]
+    fn long_paths() {
+        let long = format!("{}/{}", "a".repeat(120), "b".repeat(90));
+
+        let actual = header(&long, 0o644, 0).unwrap();
+
+        assert_eq!(&actual[..90], "b".repeat(90).as_bytes());
+        assert_eq!(&actual[345..465], "a".repeat(120).as_bytes());
+        assert!(header(&"c".repeat(120), 0o644, 0).is_err());
+    }
+}
@@ -0,0 +1,489 @@
+use crate::{
+    config::Config,
+    error::AuditError,
+    job::{JobRecord, JobStatus},
+    model::{Language, Verdict},
+    problem::Problem,
+    response::SubmitResponse,
+    toolchain::Toolchains,
+};
+use axum::{
+    async_trait,
+    extract::{ConnectInfo, FromRequestParts},
+    http::{header::AUTHORIZATION, request::Parts, StatusCode},
+    response::{IntoResponse, Response},
+};
+use serde::{Deserialize, Serialize};
+use sha2::{Digest, Sha256};
+use std::{
+    convert::Infallible,
+    fs::{self, File},
+    io::{BufRead, BufReader, ErrorKind, Write},
+    net::{IpAddr, SocketAddr},
+    path::PathBuf,
+    sync::{Arc, Mutex},
+    time::{SystemTime, UNIX_EPOCH},
+};
+use tracing::error;
+use uuid::Uuid;
+
+/// The number of entries a query responds with, if it does not ask for a number.
+const DEFAULT_LIMIT: usize = 100;
+
+/// The maximum number of entries a query responds with.
+const MAX_LIMIT: usize = 1000;
+
+/// The interface a submission was made through.
+#[derive(Serialize, Deserialize, Clone, Copy, PartialEq, Debug)]
+#[serde(rename_all = "lowercase")]
+pub enum Interface {
+    Http,
+    Grpc,
+    Broker,
+}
+
+/// Who made a submission, and from where.
+#[derive(Serialize, Deserialize, Clone, PartialEq, Debug)]
+pub struct Submitter {
+    pub interface: Interface,
+    /// The fingerprint of the bearer token the submission was made with, i.e. the first 16 hex digits of its SHA-256,
+    /// which tells the callers apart without revealing their tokens, and is left out if it was made without one.
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub token: Option<String>,
+    /// The address of the peer the submission was sent by, which is that of the proxy if mozart runs behind one, and is
+    /// left out of submissions from a broker.
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub address: Option<IpAddr>,
+}
+
+impl Submitter {
+    /// Identifies the submitter by the value of the `Authorization` header of its request, and its peer address.
+    pub fn new(
+        interface: Interface,
+        authorization: Option<&str>,
+        peer: Option<SocketAddr>,
+    ) -> Self {
+        Self {
+            interface,
+            token: authorization.and_then(crate::auth::bearer).map(fingerprint),
+            address: peer.map(|peer| peer.ip()),
+        }
+    }
+}
+
+#[async_trait]
+impl<S> FromRequestParts<S> for Submitter
+where
+    S: Send + Sync,
+{
+    type Rejection = Infallible;
+
+    async fn from_request_parts(parts: &mut Parts, _: &S) -> Result<Self, Self::Rejection> {
+        let authorization = parts
+            .headers
+            .get(AUTHORIZATION)
+            .and_then(|value| value.to_str().ok());
+        let peer = parts
+            .extensions
+            .get::<ConnectInfo<SocketAddr>>()
+            .map(|ConnectInfo(peer)| *peer);
+
+        Ok(Self::new(Interface::Http, authorization, peer))
+    }
+}
+
+fn fingerprint(token: &str) -> String {
+    Sha256::digest(token.as_bytes())[..8]
+        .iter()
+        .map(|byte| format!("{byte:02x}"))
+        .collect()
+}
+
+/// What the test cases of a checked submission took.
+#[derive(Serialize, Deserialize, Clone, PartialEq, Debug)]
+#[serde(rename_all = "camelCase")]
+pub struct Usage {
+    pub test_cases: usize,
+    /// The wall-clock runtime of every test case, in milliseconds.
+    pub runtime: u64,
+    /// The CPU time of every test case in milliseconds, which is their runtime where it could not be measured.
+    pub cpu_time: u64,
+    /// The most memory any test case took in bytes, which is left out if it could not be measured.
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub memory: Option<u64>,
+}
+
+/// A judgment as it is recorded in the audit log.
+#[derive(Serialize, Deserialize, Clone, PartialEq, Debug)]
+#[serde(rename_all = "camelCase")]
+pub struct AuditEntry {
+    /// When the judgment was done, in milliseconds since the unix epoch.
+    pub judged_at: u64,
+    /// The job which was judged, which is left out for submissions which were checked synchronously.
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub task: Option<Uuid>,
+    /// The version of the result of the job, which increases every time it is rejudged.
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub version: Option<u32>,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub tenant: Option<String>,
+    /// Who made the submission, which is left out of the jobs which were submitted before it was recorded.
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub submitter: Option<Submitter>,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub exercise_id: Option<String>,
+    pub language: Language,
+    /// The version of the toolchain the submission asked for, or else the version of the toolchain of the language,
+    /// which is null if it could not be detected.
+    pub toolchain: Option<String>,
+    /// The docker image the submission was checked in, which is left out if it was checked on the host, or not at all.
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub image: Option<String>,
+    pub status: JobStatus,
+    /// The verdict of the submission, which is left out unless it was checked.
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub verdict: Option<Verdict>,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub score: Option<f64>,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub usage: Option<Usage>,
+}
+
+/// A judgment which is done, which is recorded in the audit log along with the toolchain it was made with.
+pub struct Judgment<'a> {
+    task: Option<Uuid>,
+    version: Option<u32>,
+    tenant: Option<&'a str>,
+    submitter: Option<&'a Submitter>,
+    exercise_id: Option<&'a str>,
+    language: Language,
+    status: JobStatus,
+    result: Option<&'a SubmitResponse>,
+}
+
+impl<'a> Judgment<'a> {
+    /// Creates the judgment of a submission in the language which was checked synchronously, without a job.
+    pub fn synchronous(
+        tenant: Option<&'a str>,
+        submitter: &'a Submitter,
+        language: Language,
+        exercise_id: Option<&'a str>,
+        result: &'a SubmitResponse,
+    ) -> Self {
+        Self {
+            task: None,
+            version: None,
+            tenant,
+            submitter: Some(submitter),
+            exercise_id,
+            language,
+            status: JobStatus::of(result),
+            result: Some(result),
+        }
+    }
+}
+
+impl<'a> From<&'a JobRecord> for Judgment<'a> {
+    fn from(record: &'a JobRecord) -> Self {
+        Self {
+            task: Some(record.id),
+            version: Some(record.version),
+            tenant: record.tenant.as_deref(),
+            submitter: record.submitter.as_ref(),
+            exercise_id: record.submission["exerciseId"].as_str(),
+            language: Language::deserialize(&record.submission["language"]).unwrap_or_default(),
+            status: record.status,
+            result: record.result.as_ref(),
+        }
+    }
+}
+
+/// Which entries of the audit log are responded with.
+#[derive(Deserialize, Default)]
+pub struct AuditQuery {
+    tenant: Option<String>,
+    /// Only responds with the judgments of submissions to the exercise.
+    exercise: Option<String>,
+    task: Option<Uuid>,
+    /// Only responds with the judgments of submissions made with the token of the fingerprint.
+    token: Option<String>,
+    address: Option<IpAddr>,
+    /// Only responds with the judgments done at or after the time, in milliseconds since the unix epoch.
+    since: Option<u64>,
+    /// Only responds with the judgments done before the time, in milliseconds since the unix epoch.
+    until: Option<u64>,
+    /// How many of the latest matching entries are responded with at most.
+    limit: Option<usize>,
+}
+
+impl AuditQuery {
+    fn matches(&self, entry: &AuditEntry) -> bool {
+        let submitter = entry.submitter.as_ref();
+
+        (self.tenant.is_none() || self.tenant == entry.tenant)
+            && (self.exercise.is_none() || self.exercise == entry.exercise_id)
+            && (self.task.is_none() || self.task == entry.task)
+            && (self.token.is_none() || self.token == submitter.and_then(|s| s.token.clone()))
+            && (self.address.is_none() || self.address == submitter.and_then(|s| s.address))
+            && self.since.is_none_or(|since| entry.judged_at >= since)
+            && self.until.is_none_or(|until| entry.judged_at < until)
+    }
+}
+
+/// An append-only log of every judgment, as lines of JSON in a file, so it can be reviewed who submitted what, from
+/// where, how it was judged, and with which toolchain, e.g. when the integrity of an exam is in question.
+///
+/// Every entry is appended with a single write, and the file is opened for every entry, so it can be rotated while
+/// mozart is running. The replicas of a cluster each keep their own log, of the judgments they made.
+pub struct AuditLog {
+    path: PathBuf,
+    toolchains: Arc<Toolchains>,
+    /// Held while an entry is appended, so entries of judgments which are done at once are never interleaved.
+    appending: Mutex<()>,
+}
+
+impl AuditLog {
+    pub fn new(path: PathBuf, toolchains: Arc<Toolchains>) -> Self {
+        Self {
+            path,
+            toolchains,
+            appending: Mutex::default(),
+        }
+    }
+
+    /// Creates the configured audit log, if judgments should be recorded at all.
+    pub fn from_config(config: &Config, toolchains: Arc<Toolchains>) -> Option<Self> {
+        Some(Self::new(config.audit_file.clone()?, toolchains))
+    }
+
+    /// Appends the judgment to the log, where a judgment which cannot be appended is only logged, as the result was
+    /// made either way.
+    pub fn record(&self, judgment: Judgment) {
+        let checked = match judgment.result {
+            Some(SubmitResponse::Checked(result)) => Some(result),
+            _ => None,
+        };
+        let reproduction = checked.and_then(|result| result.reproduction.as_deref());
+        let entry = AuditEntry {
+            judged_at: now(),
+            task: judgment.task,
+            version: judgment.version,
+            tenant: judgment.tenant.map(str::to_string),
+            submitter: judgment.submitter.cloned(),
+            exercise_id: judgment.exercise_id.map(str::to_string),
+            language: judgment.language,
+            toolchain: reproduction
+                .and_then(|reproduction| reproduction.toolchain_version.clone())
+                .or_else(|| self.toolchains.version(judgment.language)),
+            image: reproduction.and_then(|reproduction| reproduction.image.clone()),
+            status: judgment.status,
+            verdict: checked.map(|result| result.verdict),
+            score: checked.map(|result| result.score),
+            usage: checked.map(|result| Usage {
+                test_cases: result.test_case_results.len(),
+                runtime: result.test_case_results.iter().map(|r| r.runtime).sum(),
+                cpu_time: result.cpu_time(),
+                memory: result
+                    .test_case_results
+                    .iter()
+                    .filter_map(|r| r.memory)
+                    .max(),
+            }),
+        };
+
+        if let Err(err) = self.append(&entry) {
+            error!(%err, task = ?entry.task, "failed to record the judgment in the audit log");
+        }
+    }
+
+    fn append(&self, entry: &AuditEntry) -> Result<(), AuditError> {
+        let mut line = serde_json::to_vec(entry).expect("an audit entry should always serialize");
+        line.push(b'\n');
+
+        let _appending = self.appending.lock().expect("audit log lock poisoned");
+        if let Some(dir) = self.path.parent().filter(|dir| !dir.as_os_str().is_empty()) {
+            fs::create_dir_all(dir).map_err(|err| AuditError::Io(err.to_string()))?;
+        }
+        File::options()
+            .create(true)
+            .append(true)
+            .open(&self.path)
+            .and_then(|mut file| {
+                file.write_all(&line)?;
+                file.sync_data()
+            })
+            .map_err(|err| AuditError::Io(err.to_string()))
+    }
+
+    /// Gets the latest entries matching the query, newest first.
+    ///
+    /// A line which is not an entry, like one which was cut off by a crash, is skipped. This reads the whole log, so it
+    /// should not be called on the runtime.
+    pub fn query(&self, query: &AuditQuery) -> Result<Vec<AuditEntry>, AuditError> {
+        let file = match File::open(&self.path) {
+            Ok(file) => file,
+            Err(err) if err.kind() == ErrorKind::NotFound => return Ok(Vec::new()),
+            Err(err) => return Err(AuditError::Io(err.to_string())),
+        };
+
+        let mut entries = Vec::new();
+        for line in BufReader::new(file).lines() {
+            let line = line.map_err(|err| AuditError::Io(err.to_string()))?;
+            match serde_json::from_str::<AuditEntry>(&line) {
+                Ok(entry) if query.matches(&entry) => entries.push(entry),
+                _ => {}
+            }
+        }
+
+        let limit = query.limit.unwrap_or(DEFAULT_LIMIT).clamp(1, MAX_LIMIT);
+        entries.reverse();
+        entries.truncate(limit);
+
+        Ok(entries)
+    }
+}
+
+/// Gets the current time in milliseconds since the unix epoch.
+fn now() -> u64 {
+    SystemTime::now()
+        .duration_since(UNIX_EPOCH)
+        .map_or(0, |since| since.as_millis() as u64)
+}
+
+impl IntoResponse for AuditError {
+    fn into_response(self) -> Response {
+        match self {
+            AuditError::Disabled => {
+                Problem::new(StatusCode::NOT_FOUND, "auditDisabled", self.to_string())
+                    .into_response()
+            }
+            AuditError::Io(_) => Problem::new(
+                StatusCode::INTERNAL_SERVER_ERROR,
+                "internal",
+                "an internal error occured",
+            )
+            .into_response(),
+        }
+    }
+}
+
+#[cfg(test)]
+mod log {
+    use super::{AuditLog, AuditQuery, Interface, Judgment, Submitter};
+    use crate::{
+        job::{JobStatus, JobStore},
+        model::{
+            Submission, SubmissionResult, TestCaseFailureReason, TestCaseResult, TestResult,
+            Verdict,
+        },
+        response::SubmitResponse,
+    };
+    use std::{collections::BTreeMap, env, fs, net::SocketAddr, sync::Arc};
+    use uuid::Uuid;
+
+    fn test_case_result(id: u64, runtime: u64, memory: u64) -> TestCaseResult {
+        TestCaseResult {
+            id,
+            name: None,
+            test_result: TestResult::Failure(TestCaseFailureReason::RuntimeError),
+            hidden: false,
+            stderr: String::new(),
+            runtime,
+            memory: Some(memory),
+            user_time: Some(runtime / 2),
+            system_time: None,
+            cause: None,
+            benchmark: None,
+            attachments: BTreeMap::new(),
+        }
+    }
+
+    #[test]
+    fn fingerprints_tokens() {
+        let peer = SocketAddr::from(([10, 0, 0, 7], 51234));
+
+        let actual = Submitter::new(Interface::Http, Some("Bearer secret"), Some(peer));
+        let anonymous = Submitter::new(Interface::Broker, None, None);
+
+        assert_eq!(actual.token.as_deref(), Some("2bb80d537b1da3e3"));
+        assert_eq!(actual.address, Some(peer.ip()));
+        assert_eq!((anonymous.token, anonymous.address), (None, None));
+    }
+
+    #[test]
+    fn records_judgments() {
+        let path = env::temp_dir().join(format!("mozart-audit-{}/audit.log", Uuid::new_v4()));
+        let audit = Arc::new(AuditLog::new(path.clone(), Arc::default()));
+        let jobs = JobStore::default().audited(audit.clone());
+        let submission: Submission = serde_json::from_str(
+            r#"{"language": "python", "exerciseId": "sum", "solution": "", "testCases": []}"#,
+        )
+        .unwrap();
+        let submitter = Submitter::new(
+            Interface::Http,
+            Some("Bearer secret"),
+            Some(SocketAddr::from(([10, 0, 0, 7], 51234))),
+        );
+
+        let id = jobs.create(Some("course"), 0, &submission);
+        jobs.attribute(id, submitter.clone());
+        jobs.finish(
+            id,
+            SubmitResponse::Checked(SubmissionResult::checked(
+                String::new(),
+                Box::new([test_case_result(0, 10, 2048), test_case_result(1, 30, 1024)]),
+            )),
+        );
+        let cancelled = jobs.create(None, 0, &submission);
+        jobs.finish(cancelled, SubmitResponse::Cancelled);
+        audit.record(Judgment::synchronous(
+            None,
+            &submitter,
+            submission.language,
+            submission.exercise_id.as_deref(),
+            &SubmitResponse::TimedOut,
+        ));
+        // a line cut off by a crash is skipped
+        fs::write(
+            &path,
+            [fs::read(&path).unwrap(), b"{\"judgedAt\": 1".to_vec()].concat(),
+        )
+        .unwrap();
+
+        let all = audit.query(&AuditQuery::default()).unwrap();
+        let course = audit
+            .query(&AuditQuery {
+                tenant: Some(String::from("course")),
+                ..AuditQuery::default()
+            })
+            .unwrap();
+        let by_token = audit
+            .query(&AuditQuery {
+                token: submitter.token.clone(),
+                limit: Some(1),
+                ..AuditQuery::default()
+            })
+            .unwrap();
+        let _ = fs::remove_dir_all(path.parent().unwrap());
+
+        assert_eq!(all.len(), 3);
+        assert_eq!(all[1].task, Some(cancelled));
+        assert_eq!(all[1].status, JobStatus::Cancelled);
+        assert_eq!(all[1].submitter, None);
+        assert_eq!(course.len(), 1);
+        let entry = &course[0];
+        assert_eq!((entry.task, entry.version), (Some(id), Some(1)));
+        assert_eq!(entry.submitter.as_ref(), Some(&submitter));
+        assert_eq!(entry.exercise_id.as_deref(), Some("sum"));
+        assert_eq!(entry.verdict, Some(Verdict::Failure));
+        let usage = entry.usage.as_ref().unwrap();
+        assert_eq!(
+            (usage.test_cases, usage.runtime, usage.cpu_time),
+            (2, 40, 20)
+        );
+        assert_eq!(usage.memory, Some(2048));
+        assert_eq!(by_token.len(), 1);
+        assert_eq!(by_token[0].task, None);
+        assert_eq!(by_token[0].status, JobStatus::Failed);
+    }
+}
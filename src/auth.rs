@@ -0,0 +1,416 @@
+use crate::{config::Config, problem::Problem};
+use axum::{
+    extract::{Request, State},
+    http::{header, StatusCode},
+    middleware::Next,
+    response::{IntoResponse, Response},
+};
+use std::{
+    collections::HashSet,
+    fs,
+    path::PathBuf,
+    sync::{Arc, RwLock},
+    time::{Duration, Instant, SystemTime},
+};
+use tracing::warn;
+
+/// How often the token file is checked for having changed, so requests do not each wait on the file system.
+const RELOAD_INTERVAL: Duration = Duration::from_secs(1);
+
+/// The bearer tokens which are allowed to use the judging endpoints.
+///
+/// Tokens are given in the config, or in a token file with one token per line, which is read again once it changed,
+/// so tokens can be rotated without restarting mozart. If neither is given, authentication is disabled.
+pub struct Tokens {
+    configured: RwLock<HashSet<String>>,
+    file: Option<PathBuf>,
+    from_file: RwLock<FileTokens>,
+    /// How long the token file is trusted to be unchanged after it was last checked.
+    reload_interval: Duration,
+}
+
+#[derive(Default)]
+struct FileTokens {
+    /// The modification time and size of the file when it was last read, which identify its version.
+    version: Option<(SystemTime, u64)>,
+    /// When the file was last checked for having changed.
+    checked: Option<Instant>,
+    tokens: HashSet<String>,
+}
+
+/// The reason a request was not authenticated.
+#[derive(Debug, PartialEq)]
+pub enum AuthError {
+    /// The request has no bearer token.
+    Missing,
+
+    /// The bearer token of the request is not allowed.
+    Forbidden,
+}
+
+impl Tokens {
+    /// Creates the tokens from the config, reading the token file if one is configured.
+    ///
+    /// The tokens of every tenant are allowed as well.
+    pub fn new(config: &Config) -> Self {
+        let tenant_tokens = config.tenants.values().flat_map(|tenant| &tenant.tokens);
+        let tokens = Self {
+            configured: RwLock::new(config.tokens.iter().chain(tenant_tokens).cloned().collect()),
+            file: config.token_file.clone(),
+            from_file: RwLock::default(),
+            reload_interval: RELOAD_INTERVAL,
+        };
+        tokens.reload();
+
+        tokens
+    }
+
+    /// Creates the tokens which are allowed to use the admin endpoints, which are only ever given in the config.
+    pub fn admin(config: &Config) -> Self {
+        Self {
+            configured: RwLock::new(config.admin_tokens.iter().cloned().collect()),
+            file: None,
+            from_file: RwLock::default(),
+            reload_interval: RELOAD_INTERVAL,
+        }
+    }
+
+    /// Creates the tokens of trusted callers, who are shown the details of hidden test cases.
+    pub fn reveal(config: &Config) -> Self {
+        Self {
+            configured: RwLock::new(config.reveal_tokens.iter().cloned().collect()),
+            file: None,
+            from_file: RwLock::default(),
+            reload_interval: RELOAD_INTERVAL,
+        }
+    }
+
+    /// Creates the tokens of live exams, whose submissions may be judged at exam priority.
+    pub fn exam(config: &Config) -> Self {
+        Self {
+            configured: RwLock::new(config.exam_tokens.iter().cloned().collect()),
+            file: None,
+            from_file: RwLock::default(),
+            reload_interval: RELOAD_INTERVAL,
+        }
+    }
+
+    /// Replaces the tokens given in the config with those of a reloaded config, while the token file stays the same.
+    pub fn reconfigure(&self, reloaded: Tokens) {
+        *self
+            .configured
+            .write()
+            .expect("token lock should not be poisoned") = reloaded
+            .configured
+            .into_inner()
+            .expect("token lock should not be poisoned");
+    }
+
+    /// Whether the value of an `Authorization` header has one of the tokens, where no one is trusted if no tokens are
+    /// configured.
+    pub fn trusts(&self, authorization: Option<&str>) -> bool {
+        self.is_enabled() && self.authenticate(authorization).is_ok()
+    }
+
+    /// Whether any tokens are configured at all, as authentication is disabled otherwise.
+    pub fn is_enabled(&self) -> bool {
+        !self
+            .configured
+            .read()
+            .expect("token lock should not be poisoned")
+            .is_empty()
+            || self.file.is_some()
+    }
+
+    /// Checks the value of an `Authorization` header, if the request has one.
+    pub fn authenticate(&self, authorization: Option<&str>) -> Result<(), AuthError> {
+        if !self.is_enabled() {
+            return Ok(());
+        }
+
+        let token = authorization.and_then(bearer).ok_or(AuthError::Missing)?;
+
+        self.reload();
+        let configured = self
+            .configured
+            .read()
+            .expect("token lock should not be poisoned");
+        let from_file = self
+            .from_file
+            .read()
+            .expect("token lock should not be poisoned");
+
+        // every token is compared, so the time taken does not reveal which tokens exist
+        let allowed = configured
+            .iter()
+            .chain(&from_file.tokens)
+            .fold(false, |allowed, candidate| {
+                constant_time_eq(candidate.as_bytes(), token.as_bytes()) | allowed
+            });
+
+        if allowed {
+            Ok(())
+        } else {
+            Err(AuthError::Forbidden)
+        }
+    }
+
+    /// Reads the token file again if it changed since it was last read, which is checked at most once per reload
+    /// interval, as the metadata of the file would otherwise be read for every request.
+    ///
+    /// If the file cannot be read, the tokens read before are kept, so a file which is being replaced does not lock
+    /// everyone out in the meantime.
+    fn reload(&self) {
+        let Some(file) = &self.file else {
+            return;
+        };
+
+        let is_fresh = |from_file: &FileTokens| {
+            from_file
+                .checked
+                .is_some_and(|checked| checked.elapsed() < self.reload_interval)
+        };
+        if is_fresh(
+            &self
+                .from_file
+                .read()
+                .expect("token lock should not be poisoned"),
+        ) {
+            return;
+        }
+        {
+            // only one of the requests finding the file stale checks it, while the others keep the tokens they have
+            let mut from_file = self
+                .from_file
+                .write()
+                .expect("token lock should not be poisoned");
+            if is_fresh(&from_file) {
+                return;
+            }
+            from_file.checked = Some(Instant::now());
+        }
+
+        let version = fs::metadata(file)
+            .ok()
+            .and_then(|metadata| Some((metadata.modified().ok()?, metadata.len())));
+        let Some(version) = version else {
+            warn!(path = %file.display(), "failed to read token file, keeping previous tokens");
+            return;
+        };
+        let current = self
+            .from_file
+            .read()
+            .expect("token lock should not be poisoned")
+            .version;
+        if current == Some(version) {
+            return;
+        }
+
+        match fs::read_to_string(file) {
+            Ok(contents) => {
+                let mut from_file = self
+                    .from_file
+                    .write()
+                    .expect("token lock should not be poisoned");
+                from_file.version = Some(version);
+                from_file.tokens = parse_token_file(&contents);
+            }
+            Err(err) => warn!(%err, path = %file.display(), "failed to read token file"),
+        }
+    }
+}
+
+/// Parses a token file, where every line is a token, except for empty lines and comments starting with `#`.
+fn parse_token_file(contents: &str) -> HashSet<String> {
+    contents
+        .lines()
+        .map(str::trim)
+        .filter(|line| !line.is_empty() && !line.starts_with('#'))
+        .map(str::to_string)
+        .collect()
+}
+
+/// Gets the bearer token of the value of an `Authorization` header, if it has one.
+pub fn bearer(authorization: &str) -> Option<&str> {
+    authorization
+        .split_once(' ')
+        .filter(|(scheme, _)| scheme.eq_ignore_ascii_case("bearer"))
+        .map(|(_, token)| token.trim())
+        .filter(|token| !token.is_empty())
+}
+
+/// Compares two byte strings in time which only depends on their lengths.
+pub fn constant_time_eq(a: &[u8], b: &[u8]) -> bool {
+    a.len() == b.len() && a.iter().zip(b).fold(0, |diff, (a, b)| diff | (a ^ b)) == 0
+}
+
+/// Rejects requests without an allowed bearer token, when authentication is enabled.
+pub async fn require_token(
+    State(tokens): State<Arc<Tokens>>,
+    request: Request,
+    next: Next,
+) -> Result<Response, AuthError> {
+    let authorization = request
+        .headers()
+        .get(header::AUTHORIZATION)
+        .and_then(|value| value.to_str().ok());
+
+    if let Err(err) = tokens.authenticate(authorization) {
+        warn!(reason = ?err, "rejected unauthenticated request");
+        return Err(err);
+    }
+
+    Ok(next.run(request).await)
+}
+
+/// Rejects requests without an allowed admin token, where every request is rejected if no admin tokens are configured,
+/// rather than the admin endpoints being open to everyone.
+pub async fn require_admin_token(
+    State(tokens): State<Arc<Tokens>>,
+    request: Request,
+    next: Next,
+) -> Result<Response, AuthError> {
+    if !tokens.is_enabled() {
+        warn!("rejected admin request, as no admin tokens are configured");
+        return Err(AuthError::Forbidden);
+    }
+
+    require_token(State(tokens), request, next).await
+}
+
+impl IntoResponse for AuthError {
+    fn into_response(self) -> Response {
+        match self {
+            AuthError::Missing => (
+                [(header::WWW_AUTHENTICATE, "Bearer")],
+                Problem::new(
+                    StatusCode::UNAUTHORIZED,
+                    "unauthorized",
+                    "the request requires a bearer token",
+                ),
+            )
+                .into_response(),
+            AuthError::Forbidden => Problem::new(
+                StatusCode::FORBIDDEN,
+                "forbidden",
+                "the bearer token is not allowed",
+            )
+            .into_response(),
+        }
+    }
+}
+
+#[cfg(test)]
+mod authenticate {
+    use super::{AuthError, Tokens};
+    use crate::config::Config;
+    use std::{env, fs, thread, time::Duration};
+    use uuid::Uuid;
+
+    fn configured(tokens: &[&str]) -> Tokens {
+        Tokens::new(&Config {
+            tokens: tokens.iter().map(|token| token.to_string()).collect(),
+            ..Config::default()
+        })
+    }
+
+    #[test]
+    fn disabled() {
+        let tokens = configured(&[]);
+
+        assert_eq!(tokens.authenticate(None), Ok(()));
+    }
+
+    #[test]
+    fn missing() {
+        let tokens = configured(&["secret"]);
+
+        assert_eq!(tokens.authenticate(None), Err(AuthError::Missing));
+        assert_eq!(
+            tokens.authenticate(Some("Basic c2VjcmV0")),
+            Err(AuthError::Missing)
+        );
+    }
+
+    #[test]
+    fn allowed() {
+        let tokens = configured(&["secret", "other"]);
+
+        assert_eq!(tokens.authenticate(Some("Bearer secret")), Ok(()));
+        assert_eq!(tokens.authenticate(Some("bearer other")), Ok(()));
+        assert_eq!(
+            tokens.authenticate(Some("Bearer secre")),
+            Err(AuthError::Forbidden)
+        );
+    }
+
+    #[test]
+    fn trusted() {
+        let reveal = Tokens::reveal(&Config {
+            reveal_tokens: vec![String::from("grader")],
+            ..Config::default()
+        });
+
+        assert!(reveal.trusts(Some("Bearer grader")));
+        assert!(!reveal.trusts(Some("Bearer student")));
+        assert!(!reveal.trusts(None));
+        assert!(!Tokens::reveal(&Config::default()).trusts(None));
+    }
+
+    #[test]
+    fn reloads_file() {
+        let path = env::temp_dir().join(format!("tokens-{}", Uuid::new_v4()));
+        fs::write(&path, "# students\nfirst\n\n").expect("failed to write token file");
+        let tokens = Tokens {
+            reload_interval: Duration::ZERO,
+            ..Tokens::new(&Config {
+                token_file: Some(path.clone()),
+                ..Config::default()
+            })
+        };
+
+        let before = tokens.authenticate(Some("Bearer first"));
+        fs::write(&path, "second\nthird\n").expect("failed to write token file");
+        let replaced = tokens.authenticate(Some("Bearer first"));
+        let added = tokens.authenticate(Some("Bearer third"));
+        fs::remove_file(&path).expect("failed to remove token file");
+        let removed = tokens.authenticate(Some("Bearer third"));
+
+        assert_eq!(before, Ok(()));
+        assert_eq!(replaced, Err(AuthError::Forbidden));
+        assert_eq!(added, Ok(()));
+        assert_eq!(removed, Ok(()));
+    }
+
+    #[test]
+    fn checks_file_once_per_interval() {
+        let path = env::temp_dir().join(format!("tokens-{}", Uuid::new_v4()));
+        fs::write(
+            &path, "first
+",
+        )
+        .expect("failed to write token file");
+        let tokens = Tokens {
+            reload_interval: Duration::from_millis(200),
+            ..Tokens::new(&Config {
+                token_file: Some(path.clone()),
+                ..Config::default()
+            })
+        };
+
+        fs::write(
+            &path,
+            "second
+third
+",
+        )
+        .expect("failed to write token file");
+        let unchecked = tokens.authenticate(Some("Bearer first"));
+        thread::sleep(Duration::from_millis(250));
+        let checked = tokens.authenticate(Some("Bearer first"));
+        fs::remove_file(&path).expect("failed to remove token file");
+
+        assert_eq!(unchecked, Ok(()));
+        assert_eq!(checked, Err(AuthError::Forbidden));
+    }
+}
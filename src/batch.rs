@@ -0,0 +1,174 @@
+use crate::{
+    job::{JobRecord, JobStatus},
+    model::Verdict,
+    response::SubmitResponse,
+};
+use serde::Serialize;
+use uuid::Uuid;
+
+/// The status of every submission of a batch, along with a summary of the batch so far.
+#[derive(Serialize)]
+pub struct BatchReport {
+    id: Uuid,
+    /// The jobs of the submissions, ordered as they were submitted.
+    tasks: Vec<BatchTask>,
+    summary: Summary,
+}
+
+/// The status of a single submission of a batch.
+#[derive(Serialize)]
+struct BatchTask {
+    id: Uuid,
+    status: JobStatus,
+    /// The verdict of the submission, which is left out unless it has been checked.
+    #[serde(skip_serializing_if = "Option::is_none")]
+    verdict: Option<Verdict>,
+}
+
+#[derive(Serialize, Debug, PartialEq)]
+#[serde(rename_all = "camelCase")]
+struct Summary {
+    total: usize,
+    /// The number of submissions which are done, including those which could not be checked.
+    done: usize,
+    passed: usize,
+    /// The share of the checked submissions which passed, which is null until a submission has been checked.
+    pass_rate: Option<f64>,
+    /// The average runtime of the checked submissions in milliseconds, where the runtime of a submission is the
+    /// total runtime of its test cases, which is null until a submission has been checked.
+    average_runtime: Option<f64>,
+}
+
+impl BatchReport {
+    /// Summarizes the jobs of the batch, which are ordered as they were submitted.
+    pub fn new(id: Uuid, records: &[JobRecord]) -> Self {
+        let tasks: Vec<BatchTask> = records
+            .iter()
+            .map(|record| BatchTask {
+                id: record.id,
+                status: record.status,
+                verdict: match &record.result {
+                    Some(SubmitResponse::Checked(result)) => Some(result.verdict),
+                    _ => None,
+                },
+            })
+            .collect();
+
+        let runtimes: Vec<u64> = records
+            .iter()
+            .filter_map(|record| match &record.result {
+                Some(SubmitResponse::Checked(result)) => Some(
+                    result
+                        .test_case_results
+                        .iter()
+                        .map(|test_case| test_case.runtime)
+                        .sum(),
+                ),
+                _ => None,
+            })
+            .collect();
+        let checked = runtimes.len();
+        let passed = tasks
+            .iter()
+            .filter(|task| task.verdict == Some(Verdict::Pass))
+            .count();
+        let average = |total: f64| Some(total / checked as f64).filter(|_| checked > 0);
+
+        let summary = Summary {
+            total: tasks.len(),
+            done: tasks.iter().filter(|task| task.status.is_done()).count(),
+            passed,
+            pass_rate: average(passed as f64),
+            average_runtime: average(runtimes.iter().sum::<u64>() as f64),
+        };
+
+        Self { id, tasks, summary }
+    }
+}
+
+#[cfg(test)]
+mod summary {
+    use super::{BatchReport, Summary};
+    use crate::{
+        job::{BatchEntry, JobStore},
+        model::{Submission, SubmissionResult, TestCaseResult, TestResult},
+        response::SubmitResponse,
+    };
+    use std::collections::BTreeMap;
+    use uuid::Uuid;
+
+    fn submission() -> Submission {
+        serde_json::from_str(r#"{"solution": "", "testCases": []}"#).unwrap()
+    }
+
+    fn checked(runtimes: &[u64], test_result: TestResult) -> SubmitResponse {
+        let test_case_results = runtimes
+            .iter()
+            .enumerate()
+            .map(|(id, runtime)| TestCaseResult {
+                id: id as u64,
+                name: None,
+                test_result: test_result.clone(),
+                hidden: false,
+                stderr: String::new(),
+                runtime: *runtime,
+                memory: None,
+                user_time: None,
+                system_time: None,
+                cause: None,
+                benchmark: None,
+                attachments: BTreeMap::new(),
+            })
+            .collect();
+
+        SubmitResponse::Checked(SubmissionResult::checked(String::new(), test_case_results))
+    }
+
+    #[test]
+    fn aggregates_checked_submissions() {
+        let jobs = JobStore::default();
+        let batch_id = Uuid::new_v4();
+        let results = [
+            Some(checked(&[10, 20], TestResult::Pass)),
+            Some(checked(&[50], TestResult::Unknown)),
+            Some(SubmitResponse::Internal),
+            None,
+        ];
+        for (index, result) in results.into_iter().enumerate() {
+            let id = jobs.create_in_batch(
+                None,
+                0,
+                &submission(),
+                BatchEntry {
+                    id: batch_id,
+                    index,
+                },
+            );
+            if let Some(result) = result {
+                jobs.finish(id, result);
+            }
+        }
+
+        let actual = BatchReport::new(batch_id, &jobs.batch_jobs(batch_id));
+
+        assert_eq!(
+            actual.summary,
+            Summary {
+                total: 4,
+                done: 3,
+                passed: 1,
+                pass_rate: Some(0.5),
+                average_runtime: Some(40.0),
+            }
+        );
+        assert_eq!(actual.tasks[2].verdict, None);
+    }
+
+    #[test]
+    fn nothing_checked() {
+        let actual = BatchReport::new(Uuid::new_v4(), &[]);
+
+        assert_eq!(actual.summary.pass_rate, None);
+        assert_eq!(actual.summary.average_runtime, None);
+    }
+}
@@ -0,0 +1,347 @@
+use crate::{
+    config::Config,
+    error::{BlobError, CheckError},
+    exercise::Exercises,
+    job::{JobStatus, JobStore},
+    problem::Problem,
+    response::{Quota, SubmitResponse},
+};
+use axum::{
+    http::StatusCode,
+    response::{IntoResponse, Response},
+};
+use base64::{engine::general_purpose::STANDARD, Engine};
+use serde::Serialize;
+use serde_json::Value;
+use sha2::{Digest, Sha256};
+use std::{
+    collections::{BTreeMap, HashSet},
+    fs::{self, File},
+    io::ErrorKind,
+    os::unix::fs::PermissionsExt,
+    path::{Path, PathBuf},
+    time::{Duration, SystemTime},
+};
+use tracing::info;
+use uuid::Uuid;
+
+/// How often the blobs nothing refers to are collected.
+const INTERVAL: Duration = Duration::from_secs(60 * 60);
+
+/// A blob as it was uploaded, which submissions refer to by its hash.
+#[derive(Serialize)]
+pub struct Uploaded {
+    pub hash: String,
+    pub size: usize,
+}
+
+/// The inputs many submissions share, e.g. datasets or starter code, which are uploaded once and stored by the SHA-256
+/// of their contents, so uploading the same contents again stores nothing new.
+pub struct Blobs {
+    dir: PathBuf,
+}
+
+impl Blobs {
+    pub fn new(dir: PathBuf) -> Self {
+        Self { dir }
+    }
+
+    /// Stores a blob by the hash of its contents, and returns the hash along with whether the blob did not exist yet.
+    ///
+    /// Uploading a blob which exists already renews it instead, so it is not collected right before it is referred to.
+    /// Whether a new blob fits is only asked once it is known to be new, as storing a blob again takes up no room.
+    pub fn put(
+        &self,
+        contents: &[u8],
+        fits: impl FnOnce() -> bool,
+    ) -> Result<(String, bool), BlobError> {
+        let hash = hex(&Sha256::digest(contents));
+        let path = self.dir.join(&hash);
+        if path.exists() {
+            File::options()
+                .append(true)
+                .open(&path)
+                .and_then(|file| file.set_modified(SystemTime::now()))
+                .map_err(|err| BlobError::Io(err.to_string()))?;
+            return Ok((hash, false));
+        }
+
+        if !fits() {
+            return Err(BlobError::QuotaExceeded);
+        }
+        // the same blob may be uploaded twice at once, so every upload is written to a temporary file of its own
+        let temporary = self.dir.join(format!(".{}.tmp", Uuid::new_v4()));
+        fs::create_dir_all(&self.dir)
+            .and_then(|_| fs::write(&temporary, contents))
+            .and_then(|_| fs::rename(&temporary, &path))
+            .map_err(|err| BlobError::Io(err.to_string()))?;
+
+        Ok((hash, true))
+    }
+
+    /// Resolves the blobs of a submission into files of its solution at their paths, so they are laid out, compiled,
+    /// and restricted like every other file.
+    pub fn resolve(
+        &self,
+        blobs: &BTreeMap<String, String>,
+        files: &mut BTreeMap<String, String>,
+    ) -> Result<(), CheckError> {
+        for (path, hash) in blobs {
+            if !is_valid_hash(hash) {
+                return Err(CheckError::MissingBlob(hash.clone()));
+            }
+            match fs::read(self.dir.join(hash)) {
+                Ok(contents) => files.insert(path.clone(), STANDARD.encode(contents)),
+                Err(err) if err.kind() == ErrorKind::NotFound => {
+                    return Err(CheckError::MissingBlob(hash.clone()))
+                }
+                Err(_) => return Err(CheckError::IOInteraction),
+            };
+        }
+
+        Ok(())
+    }
+
+    /// Removes every blob which is not referred to, and which has not been uploaded for the ttl, returning how many
+    /// were removed.
+    ///
+    /// Only files named by a hash are considered blobs, so the directories of tenants and temporary files are left
+    /// alone.
+    pub fn collect(&self, referenced: &HashSet<String>, ttl: Duration) -> usize {
+        let Ok(entries) = fs::read_dir(&self.dir) else {
+            return 0;
+        };
+
+        let now = SystemTime::now();
+        let mut removed = 0;
+        for entry in entries.flatten() {
+            let Some(name) = entry.file_name().to_str().map(str::to_string) else {
+                continue;
+            };
+            let age = entry
+                .metadata()
+                .and_then(|metadata| metadata.modified())
+                .ok()
+                .and_then(|modified| now.duration_since(modified).ok())
+                .unwrap_or_default();
+
+            if is_valid_hash(&name)
+                && !referenced.contains(&name)
+                && age >= ttl
+                && fs::remove_file(entry.path()).is_ok()
+            {
+                removed += 1;
+            }
+        }
+
+        removed
+    }
+}
+
+impl From<&Config> for Blobs {
+    fn from(config: &Config) -> Self {
+        Self::new(config.blob_dir())
+    }
+}
+
+fn hex(bytes: &[u8]) -> String {
+    bytes.iter().map(|byte| format!("{byte:02x}")).collect()
+}
+
+/// Whether the hash can refer to a blob, i.e. a SHA-256 encoded as 64 lowercase hex digits, which is also its file
+/// name.
+pub fn is_valid_hash(hash: &str) -> bool {
+    hash.len() == 64 && hash.bytes().all(|c| matches!(c, b'0'..=b'9' | b'a'..=b'f'))
+}
+
+/// Makes the blobs laid out in a workspace read-only, as they are shared with every other submission referring to them.
+pub fn protect<'a>(
+    workspace: &Path,
+    paths: impl IntoIterator<Item = &'a String>,
+) -> Result<(), CheckError> {
+    for path in paths {
+        if fs::set_permissions(workspace.join(path), fs::Permissions::from_mode(0o444)).is_err() {
+            return Err(CheckError::IOInteraction);
+        }
+    }
+
+    Ok(())
+}
+
+/// Gets the hashes of the blobs the JSON of a submission, or of an exercise, refers to.
+fn references(submission: &Value) -> impl Iterator<Item = String> + '_ {
+    submission["blobs"]
+        .as_object()
+        .into_iter()
+        .flatten()
+        .filter_map(|(_, hash)| hash.as_str().map(str::to_string))
+}
+
+/// Periodically collects the blobs of every namespace in the background, which neither an exercise of the namespace,
+/// nor a job which is queued or running refers to, once they have not been uploaded for the `blob_ttl`.
+pub fn start(config: &Config, jobs: JobStore) {
+    let ttl = config.blob_ttl();
+    let configs: Vec<Config> = std::iter::once(config.clone())
+        .chain(config.tenants.keys().map(|name| config.tenant(name)))
+        .collect();
+
+    tokio::spawn(async move {
+        let mut interval = tokio::time::interval(INTERVAL);
+        loop {
+            interval.tick().await;
+            let jobs = jobs.clone();
+            let configs = configs.clone();
+            let removed = tokio::task::spawn_blocking(move || {
+                // a finished job is never checked again, unless it is rejudged from its exercise
+                let pending: HashSet<String> = jobs
+                    .records()
+                    .iter()
+                    .filter(|record| {
+                        matches!(record.status, JobStatus::Queued | JobStatus::Running)
+                    })
+                    .flat_map(|record| references(&record.submission).collect::<Vec<_>>())
+                    .collect();
+
+                configs
+                    .iter()
+                    .map(|config| {
+                        let mut referenced = pending.clone();
+                        for exercise in Exercises::from(config).all() {
+                            referenced.extend(references(&exercise));
+                        }
+                        Blobs::from(config).collect(&referenced, ttl)
+                    })
+                    .sum::<usize>()
+            })
+            .await
+            .unwrap_or_default();
+            if removed > 0 {
+                info!(removed, "collected unreferenced blobs");
+            }
+        }
+    });
+}
+
+impl IntoResponse for BlobError {
+    fn into_response(self) -> Response {
+        match self {
+            BlobError::TooLarge => Problem::new(
+                StatusCode::PAYLOAD_TOO_LARGE,
+                "payloadTooLarge",
+                "the blob is larger than the fixture size limit",
+            )
+            .into_response(),
+            BlobError::QuotaExceeded => {
+                SubmitResponse::QuotaExceeded(Quota::Storage).into_response()
+            }
+            BlobError::Io(_) => Problem::new(
+                StatusCode::INTERNAL_SERVER_ERROR,
+                "internal",
+                "an internal error occured",
+            )
+            .into_response(),
+        }
+    }
+}
+
+#[cfg(test)]
+mod blobs {
+    use super::{is_valid_hash, protect, Blobs};
+    use crate::error::{BlobError, CheckError};
+    use std::{
+        collections::{BTreeMap, HashSet},
+        env, fs,
+        path::PathBuf,
+        time::Duration,
+    };
+    use uuid::Uuid;
+
+    /// The SHA-256 of `1 2 3`.
+    const HASH: &str = "7c8f5059290305cec8323d79521f0353c9ac308b60cb4c1976340d0ce4a121d5";
+
+    /// The SHA-256 of nothing, which is never uploaded.
+    const EMPTY: &str = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855";
+
+    fn dir(name: &str) -> PathBuf {
+        env::temp_dir().join(format!("mozart-{name}-{}", Uuid::new_v4()))
+    }
+
+    #[test]
+    fn hashes() {
+        assert!(is_valid_hash(HASH));
+        assert!(!is_valid_hash(&HASH.to_uppercase()));
+        assert!(!is_valid_hash(&HASH[1..]));
+        assert!(!is_valid_hash(&format!("../{}", &HASH[3..])));
+    }
+
+    #[test]
+    fn deduplicates() {
+        let blobs = Blobs::new(dir("blobs"));
+
+        let (hash, created) = blobs.put(b"1 2 3", || true).unwrap();
+        let (again, created_again) = blobs.put(b"1 2 3", || false).unwrap();
+        let full = blobs.put(b"4 5 6", || false);
+        let stored = fs::read_dir(&blobs.dir).unwrap().count();
+        let _ = fs::remove_dir_all(&blobs.dir);
+
+        assert_eq!(hash, HASH);
+        assert_eq!(again, HASH);
+        assert!(created);
+        assert!(!created_again);
+        assert_eq!(stored, 1);
+        assert!(matches!(full, Err(BlobError::QuotaExceeded)));
+    }
+
+    #[test]
+    fn resolves_into_files() {
+        let blobs = Blobs::new(dir("blobs"));
+        let workspace = dir("workspace");
+        fs::create_dir(&workspace).unwrap();
+        let (hash, _) = blobs.put(b"1 2 3", || true).unwrap();
+        let mut files = BTreeMap::new();
+
+        let actual = blobs.resolve(
+            &BTreeMap::from([(String::from("data/input.txt"), hash.clone())]),
+            &mut files,
+        );
+        let missing = blobs.resolve(
+            &BTreeMap::from([(String::from("other.txt"), EMPTY.to_string())]),
+            &mut BTreeMap::new(),
+        );
+        fs::write(workspace.join("input.txt"), "1 2 3").unwrap();
+        let protected = protect(&workspace, [&String::from("input.txt")]);
+        let readonly = fs::metadata(workspace.join("input.txt"))
+            .unwrap()
+            .permissions()
+            .readonly();
+        let _ = fs::remove_dir_all(&blobs.dir);
+        let _ = fs::remove_dir_all(&workspace);
+
+        assert!(actual.is_ok());
+        assert_eq!(files["data/input.txt"], "MSAyIDM=");
+        assert!(matches!(missing, Err(CheckError::MissingBlob(hash)) if hash == EMPTY));
+        assert!(protected.is_ok());
+        assert!(readonly);
+    }
+
+    #[test]
+    fn collects_unreferenced() {
+        let blobs = Blobs::new(dir("blobs"));
+        let (referenced, _) = blobs.put(b"referenced", || true).unwrap();
+        let (unreferenced, _) = blobs.put(b"unreferenced", || true).unwrap();
+        fs::create_dir_all(blobs.dir.join(".tenants/course")).unwrap();
+
+        let young = blobs.collect(&HashSet::new(), Duration::from_secs(60 * 60));
+        let actual = blobs.collect(&HashSet::from([referenced.clone()]), Duration::ZERO);
+        let remaining = (
+            blobs.dir.join(&referenced).exists(),
+            blobs.dir.join(&unreferenced).exists(),
+            blobs.dir.join(".tenants").exists(),
+        );
+        let _ = fs::remove_dir_all(&blobs.dir);
+
+        assert_eq!(young, 0);
+        assert_eq!(actual, 1);
+        assert_eq!(remaining, (true, false, true));
+    }
+}
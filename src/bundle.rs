@@ -0,0 +1,273 @@
+use crate::{files, job::JobRecord, model::Language, response::SubmitResponse};
+use serde::{Deserialize, Serialize};
+
+/// The checksums of every byte, by which the CRC-32 of the contents of a zip entry is computed a byte at a time.
+const CRC_TABLE: [u32; 256] = crc_table();
+
+/// The DOS date every entry is dated with, i.e. the first of January 1980, so the same judgment is always bundled into
+/// the same bytes.
+const DATE: u16 = 1 << 5 | 1;
+
+/// The flag of an entry whose name is encoded as UTF-8.
+const UTF8: u16 = 1 << 11;
+
+const fn crc_table() -> [u32; 256] {
+    let mut table = [0; 256];
+    let mut byte = 0;
+    while byte < 256 {
+        let mut crc = byte as u32;
+        let mut bit = 0;
+        while bit < 8 {
+            crc = match crc & 1 {
+                1 => 0xedb8_8320 ^ (crc >> 1),
+                _ => crc >> 1,
+            };
+            bit += 1;
+        }
+        table[byte] = crc;
+        byte += 1;
+    }
+
+    table
+}
+
+fn crc32(contents: &[u8]) -> u32 {
+    !contents.iter().fold(!0, |crc, &byte| {
+        CRC_TABLE[((crc ^ u32::from(byte)) & 0xff) as usize] ^ (crc >> 8)
+    })
+}
+
+/// A zip archive being written, whose entries are stored without compression, as judgments are small.
+#[derive(Default)]
+struct Zip {
+    bytes: Vec<u8>,
+    central_directory: Vec<u8>,
+    entries: u16,
+}
+
+impl Zip {
+    /// Adds a file to the archive, along with its entry in the central directory.
+    fn add(&mut self, name: &str, contents: &[u8]) {
+        let offset = self.bytes.len() as u32;
+        let crc = crc32(contents);
+        let size = contents.len() as u32;
+
+        // the fields the local header and the central directory share, from the flags up to the length of the name
+        let mut fields = Vec::with_capacity(26);
+        fields.extend(UTF8.to_le_bytes());
+        fields.extend(0u16.to_le_bytes()); // stored
+        fields.extend(0u16.to_le_bytes()); // midnight
+        fields.extend(DATE.to_le_bytes());
+        fields.extend(crc.to_le_bytes());
+        fields.extend(size.to_le_bytes());
+        fields.extend(size.to_le_bytes());
+        fields.extend((name.len() as u16).to_le_bytes());
+        fields.extend(0u16.to_le_bytes()); // no extra field
+
+        self.bytes.extend(0x0403_4b50u32.to_le_bytes());
+        self.bytes.extend(20u16.to_le_bytes());
+        self.bytes.extend(&fields);
+        self.bytes.extend(name.as_bytes());
+        self.bytes.extend(contents);
+
+        self.central_directory.extend(0x0201_4b50u32.to_le_bytes());
+        // made on unix, so the external attributes are the mode of the file
+        self.central_directory
+            .extend((3u16 << 8 | 20).to_le_bytes());
+        self.central_directory.extend(20u16.to_le_bytes());
+        self.central_directory.extend(&fields);
+        self.central_directory.extend(0u16.to_le_bytes()); // no comment
+        self.central_directory.extend(0u16.to_le_bytes()); // the first disk
+        self.central_directory.extend(0u16.to_le_bytes()); // binary
+        self.central_directory
+            .extend((0o100_644u32 << 16).to_le_bytes());
+        self.central_directory.extend(offset.to_le_bytes());
+        self.central_directory.extend(name.as_bytes());
+        self.entries += 1;
+    }
+
+    /// Ends the archive with its central directory.
+    fn finish(mut self) -> Vec<u8> {
+        let offset = self.bytes.len() as u32;
+        let size = self.central_directory.len() as u32;
+        self.bytes.append(&mut self.central_directory);
+
+        self.bytes.extend(0x0605_4b50u32.to_le_bytes());
+        self.bytes.extend(0u16.to_le_bytes());
+        self.bytes.extend(0u16.to_le_bytes());
+        self.bytes.extend(self.entries.to_le_bytes());
+        self.bytes.extend(self.entries.to_le_bytes());
+        self.bytes.extend(size.to_le_bytes());
+        self.bytes.extend(offset.to_le_bytes());
+        self.bytes.extend(0u16.to_le_bytes()); // no comment
+
+        self.bytes
+    }
+}
+
+/// Gets the name the solution is bundled as, which is that of a source file of its language.
+fn solution_name(language: Language) -> &'static str {
+    match language {
+        Language::Haskell => "solution.hs",
+        Language::Python => "solution.py",
+        Language::Go => "solution.go",
+        Language::C => "solution.c",
+        Language::Java => "Solution.java",
+        Language::Sql => "solution.sql",
+    }
+}
+
+fn json(value: &impl Serialize) -> Vec<u8> {
+    serde_json::to_vec_pretty(value).expect("a judgment should always serialize")
+}
+
+/// Bundles everything a judgment was made from, and what it resulted in, into a zip archive, so it can be inspected
+/// offline, e.g. when a grade is disputed.
+///
+/// The archive has the submission in `submission.json`, and its solution and files in `sources/`. Every test case is in
+/// `tests/{id}.json`, along with what the solution wrote to stderr in `tests/{id}.stderr`. The compiler output is in
+/// `compile.log`, and the result in `result.json`. Hidden test cases are left out unless the caller is trusted, so the
+/// record of an untrusted caller should be redacted already.
+pub fn bundle(record: &JobRecord, trusted: bool) -> Vec<u8> {
+    let mut zip = Zip::default();
+    let submission = &record.submission;
+    zip.add("submission.json", &json(submission));
+
+    let language = Language::deserialize(&submission["language"]).unwrap_or_default();
+    let solution = submission["solution"].as_str().unwrap_or_default();
+    zip.add(
+        &format!("sources/{}", solution_name(language)),
+        solution.as_bytes(),
+    );
+    for (path, contents) in submission["files"].as_object().into_iter().flatten() {
+        let contents = contents.as_str().unwrap_or_default();
+        // the files of a submission are validated to be base64 before it is accepted
+        let decoded = files::decode(contents).unwrap_or_else(|| contents.as_bytes().to_vec());
+        zip.add(&format!("sources/{path}"), &decoded);
+    }
+
+    let test_cases = submission["testCases"].as_array().into_iter().flatten();
+    for test_case in test_cases.filter(|test_case| trusted || test_case["hidden"] != true) {
+        zip.add(&format!("tests/{}.json", test_case["id"]), &json(test_case));
+    }
+
+    if let Some(SubmitResponse::Checked(result)) = &record.result {
+        for test_case_result in result
+            .test_case_results
+            .iter()
+            .filter(|test_case_result| !test_case_result.stderr.is_empty())
+        {
+            zip.add(
+                &format!("tests/{}.stderr", test_case_result.id),
+                test_case_result.stderr.as_bytes(),
+            );
+        }
+        zip.add("compile.log", result.compile_output.as_bytes());
+    }
+    zip.add("result.json", &json(&record.result));
+
+    zip.finish()
+}
+
+#[cfg(test)]
+mod archive {
+    use super::{bundle, crc32, Zip};
+    use crate::job::JobRecord;
+    use serde_json::json;
+    use uuid::Uuid;
+
+    /// Reads the names and contents of the entries of a zip archive from its central directory.
+    fn entries(zip: &[u8]) -> Vec<(String, Vec<u8>)> {
+        let u16_at = |at: usize| u16::from_le_bytes([zip[at], zip[at + 1]]) as usize;
+        let u32_at = |at: usize| u32::from_le_bytes(zip[at..at + 4].try_into().unwrap()) as usize;
+        let end = zip.len() - 22;
+        assert_eq!(u32_at(end), 0x0605_4b50);
+
+        let mut at = u32_at(end + 16);
+        (0..u16_at(end + 10))
+            .map(|_| {
+                assert_eq!(u32_at(at), 0x0201_4b50);
+                let (crc, size, name_length) = (u32_at(at + 16), u32_at(at + 24), u16_at(at + 28));
+                let name = String::from_utf8(zip[at + 46..at + 46 + name_length].to_vec()).unwrap();
+                let local = u32_at(at + 42);
+                assert_eq!(u32_at(local), 0x0403_4b50);
+                let start = local + 30 + u16_at(local + 26);
+                let contents = zip[start..start + size].to_vec();
+                assert_eq!(crc32(&contents) as usize, crc);
+                at += 46 + name_length;
+                (name, contents)
+            })
+            .collect()
+    }
+
+    #[test]
+    fn checksums() {
+        assert_eq!(crc32(b"123456789"), 0xcbf4_3926);
+        assert_eq!(crc32(b""), 0);
+    }
+
+    #[test]
+    fn empty_archive() {
+        let actual = Zip::default().finish();
+
+        assert_eq!(actual.len(), 22);
+        assert!(entries(&actual).is_empty());
+    }
+
+    #[test]
+    fn bundles_judgment() {
+        let record: JobRecord = serde_json::from_value(json!({
+            "id": Uuid::new_v4(),
+            "status": "finished",
+            "submission": {
+                "language": "python",
+                "solution": "def solution(x): return x",
+                "files": { "util/helper.py": "eCA9IDE=" },
+                "testCases": [
+                    { "id": 0, "inputParameters": [], "outputParameters": [] },
+                    { "id": 1, "inputParameters": [], "outputParameters": [], "hidden": true },
+                ],
+            },
+            "submittedAt": 1,
+            "startedAt": 2,
+            "finishedAt": 3,
+            "result": {
+                "kind": "checked",
+                "body": {
+                    "verdict": "failure",
+                    "compileOutput": "compiled",
+                    "testCaseResults": [{
+                        "id": 0,
+                        "testResult": "pass",
+                        "stderr": "warning",
+                        "runtime": 1,
+                    }],
+                    "score": 0.0,
+                },
+            },
+            "progress": [],
+        }))
+        .unwrap();
+
+        let untrusted = entries(&bundle(&record, false));
+        let trusted = entries(&bundle(&record, true));
+
+        let names: Vec<&str> = untrusted.iter().map(|(name, _)| name.as_str()).collect();
+        assert_eq!(
+            names,
+            [
+                "submission.json",
+                "sources/solution.py",
+                "sources/util/helper.py",
+                "tests/0.json",
+                "tests/0.stderr",
+                "compile.log",
+                "result.json",
+            ]
+        );
+        assert_eq!(untrusted[1].1, b"def solution(x): return x");
+        assert_eq!(untrusted[2].1, b"x = 1");
+        assert_eq!(untrusted[5].1, b"compiled");
+        assert!(trusted.iter().any(|(name, _)| name == "tests/1.json"));
+    }
+}
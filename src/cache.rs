@@ -0,0 +1,429 @@
+use crate::{metrics::METRICS, model::Language};
+use sha2::{Digest, Sha256};
+use std::{
+    collections::{BTreeMap, HashMap, HashSet},
+    fmt::Write,
+    fs,
+    path::{Path, PathBuf},
+    sync::{Mutex, MutexGuard},
+};
+use tracing::{debug, warn};
+
+/// The file in an entry holding the output of the compiler, next to the compiled artifacts.
+const COMPILE_OUTPUT_FILE: &str = ".compile_output";
+
+/// A content-addressed cache of compiled submissions, so identical submissions are only compiled once.
+///
+/// Every entry is a directory named by its key, containing the artifacts of the compilation, and is evicted when
+/// the cache would otherwise exceed its capacity, least recently used first.
+///
+/// The lock is only held to look up and update the index of entries, while copying happens outside of it, so
+/// compilations restored and stored at once do not wait for each other.
+pub struct CompileCache {
+    dir: PathBuf,
+    /// The capacity in bytes, where zero disables the cache.
+    capacity: u64,
+    state: Mutex<State>,
+}
+
+#[derive(Default)]
+struct State {
+    entries: HashMap<String, Entry>,
+    /// The total size in bytes of every entry.
+    size: u64,
+    /// Increases with every use of an entry, so the least recently used entry has the lowest tick.
+    clock: u64,
+    /// The keys of the entries being written, which are not in the index yet.
+    writing: HashSet<String>,
+}
+
+struct Entry {
+    size: u64,
+    last_used: u64,
+    /// The number of restores copying the entry, which is not evicted until they are done.
+    readers: usize,
+    /// Whether the entry failed to be restored, so it is removed once its last reader is done.
+    broken: bool,
+}
+
+impl CompileCache {
+    /// Creates a cache in the given directory, holding at most `capacity` bytes.
+    pub fn new(dir: PathBuf, capacity: u64) -> Self {
+        Self {
+            dir,
+            capacity,
+            state: Mutex::new(State::default()),
+        }
+    }
+
+    /// Removes every entry left behind by a previous run, whose compilers may have changed since.
+    pub fn clear(&self) {
+        *self.lock() = State::default();
+
+        if self.dir.exists() && fs::remove_dir_all(&self.dir).is_err() {
+            warn!(dir = %self.dir.display(), "failed to clear compile cache");
+        }
+    }
+
+    /// Computes the key of a compilation, which is the SHA-256 of everything the compiled artifacts depend on, including
+    /// the docker image of the toolchain, if it runs in docker at all.
+    pub fn key(
+        language: Language,
+        image: Option<&str>,
+        flags: &[&str],
+        code: &str,
+        files: &BTreeMap<String, String>,
+    ) -> String {
+        let mut hasher = Sha256::new();
+        // every part is terminated, so that moving bytes between parts changes the key
+        hasher.update(language.as_str());
+        hasher.update([0]);
+        hasher.update(image.unwrap_or_default());
+        hasher.update([0]);
+        for flag in flags {
+            hasher.update(flag);
+            hasher.update([0]);
+        }
+        hasher.update([0]);
+        hasher.update(code);
+        hasher.update([0]);
+        for (path, contents) in files {
+            hasher.update(path);
+            hasher.update([0]);
+            hasher.update(contents);
+            hasher.update([0]);
+        }
+
+        hasher
+            .finalize()
+            .iter()
+            .fold(String::new(), |mut key, byte| {
+                let _ = write!(key, "{byte:02x}");
+                key
+            })
+    }
+
+    /// Copies the artifacts of a cached compilation into the workspace, returning the output of the compiler.
+    ///
+    /// Returns `None` if the compilation is not cached, or the entry cannot be restored.
+    pub fn restore(&self, key: &str, workspace: &Path) -> Option<String> {
+        if self.capacity == 0 {
+            return None;
+        }
+
+        {
+            let mut state = self.lock();
+            state.clock += 1;
+            let clock = state.clock;
+            let Some(entry) = state.entries.get_mut(key).filter(|entry| !entry.broken) else {
+                METRICS.compile_cache_miss();
+                return None;
+            };
+            // the entry is read outside of the lock, so it must not be evicted halfway through
+            entry.readers += 1;
+            entry.last_used = clock;
+        }
+
+        let entry_dir = self.dir.join(key);
+        let copied = copy_entry(&entry_dir, workspace);
+
+        let mut state = self.lock();
+        // the entry is gone if the cache was cleared while restoring
+        if let Some(entry) = state.entries.get_mut(key) {
+            entry.readers -= 1;
+            if let Err(err) = &copied {
+                warn!(%err, key, "failed to restore cached compilation, evicting it");
+                entry.broken = true;
+            }
+            if entry.broken && entry.readers == 0 {
+                state.remove(&entry_dir, key);
+            }
+        }
+
+        match copied {
+            Ok(compile_output) => {
+                METRICS.compile_cache_hit();
+                Some(compile_output)
+            }
+            Err(_) => {
+                METRICS.compile_cache_miss();
+                None
+            }
+        }
+    }
+
+    /// Stores the artifacts of a successful compilation, which are files in the workspace, evicting the least
+    /// recently used entries to make room.
+    ///
+    /// Failing to cache a compilation is not an error, as the submission is simply compiled again next time.
+    pub fn store(&self, key: &str, workspace: &Path, artifacts: &[String], compile_output: &str) {
+        if self.capacity == 0 {
+            return;
+        }
+
+        {
+            let mut state = self.lock();
+            // another compilation of the same submission may be writing the entry already
+            if state.entries.contains_key(key) || !state.writing.insert(key.to_string()) {
+                return;
+            }
+        }
+
+        let entry_dir = self.dir.join(key);
+        let written = write_entry(&entry_dir, workspace, artifacts, compile_output);
+
+        let mut state = self.lock();
+        state.writing.remove(key);
+        let size = match written {
+            Ok(size) => size,
+            Err(err) => {
+                warn!(%err, key, "failed to cache compilation");
+                let _ = fs::remove_dir_all(&entry_dir);
+                return;
+            }
+        };
+        if size > self.capacity {
+            debug!(key, size, "compilation is too large to cache");
+            let _ = fs::remove_dir_all(&entry_dir);
+            return;
+        }
+
+        while state.size + size > self.capacity {
+            let Some(oldest) = state
+                .entries
+                .iter()
+                .filter(|(_, entry)| entry.readers == 0)
+                .min_by_key(|(_, entry)| entry.last_used)
+                .map(|(key, _)| key.clone())
+            else {
+                debug!(key, "cached compilations are being restored, not caching");
+                let _ = fs::remove_dir_all(&entry_dir);
+                return;
+            };
+            debug!(key = oldest, "evicting cached compilation");
+            state.remove(&self.dir.join(&oldest), &oldest);
+        }
+
+        state.clock += 1;
+        let last_used = state.clock;
+        state.size += size;
+        state.entries.insert(
+            key.to_string(),
+            Entry {
+                size,
+                last_used,
+                readers: 0,
+                broken: false,
+            },
+        );
+    }
+
+    fn lock(&self) -> MutexGuard<'_, State> {
+        self.state
+            .lock()
+            .expect("cache lock should not be poisoned")
+    }
+}
+
+impl State {
+    fn remove(&mut self, entry_dir: &Path, key: &str) {
+        if let Some(entry) = self.entries.remove(key) {
+            self.size -= entry.size;
+        }
+        let _ = fs::remove_dir_all(entry_dir);
+    }
+}
+
+/// Copies every artifact of an entry into the workspace, returning the output of the compiler.
+fn copy_entry(entry_dir: &Path, workspace: &Path) -> std::io::Result<String> {
+    copy_artifacts(entry_dir, workspace)?;
+
+    fs::read_to_string(entry_dir.join(COMPILE_OUTPUT_FILE))
+}
+
+/// Copies the artifacts within a directory of an entry into the same directory of the workspace, as artifacts may be
+/// nested, e.g. the classes of java packages.
+fn copy_artifacts(dir: &Path, workspace: &Path) -> std::io::Result<()> {
+    for file in fs::read_dir(dir)? {
+        let file = file?;
+        if file.file_type()?.is_dir() {
+            let nested = workspace.join(file.file_name());
+            fs::create_dir_all(&nested)?;
+            copy_artifacts(&file.path(), &nested)?;
+        } else if file.file_name() != COMPILE_OUTPUT_FILE {
+            fs::copy(file.path(), workspace.join(file.file_name()))?;
+        }
+    }
+
+    Ok(())
+}
+
+/// Writes an entry with the artifacts in the workspace, returning the size of the entry.
+fn write_entry(
+    entry_dir: &Path,
+    workspace: &Path,
+    artifacts: &[String],
+    compile_output: &str,
+) -> std::io::Result<u64> {
+    fs::create_dir_all(entry_dir)?;
+
+    let mut size = 0;
+    for artifact in artifacts {
+        let destination = entry_dir.join(artifact);
+        if let Some(parent) = destination.parent() {
+            fs::create_dir_all(parent)?;
+        }
+        size += fs::copy(workspace.join(artifact), destination)?;
+    }
+    fs::write(entry_dir.join(COMPILE_OUTPUT_FILE), compile_output)?;
+    size += compile_output.len() as u64;
+
+    Ok(size)
+}
+
+#[cfg(test)]
+mod lru {
+    use super::CompileCache;
+    use crate::model::Language;
+    use std::{collections::BTreeMap, env, fs, path::PathBuf};
+    use uuid::Uuid;
+
+    fn workspace(artifact: &[u8]) -> PathBuf {
+        let workspace = env::temp_dir().join(format!("cache-workspace-{}", Uuid::new_v4()));
+        fs::create_dir_all(&workspace).expect("failed to create workspace");
+        fs::write(workspace.join("test"), artifact).expect("failed to write artifact");
+
+        workspace
+    }
+
+    #[test]
+    fn key_depends_on_flags() {
+        let plain = CompileCache::key(Language::C, None, &[], "int main() {}", &BTreeMap::new());
+        let optimized = CompileCache::key(
+            Language::C,
+            None,
+            &["-O2"],
+            "int main() {}",
+            &BTreeMap::new(),
+        );
+
+        assert_eq!(plain.len(), 64);
+        assert_ne!(plain, optimized);
+    }
+
+    #[test]
+    fn key_depends_on_image() {
+        let key =
+            |image| CompileCache::key(Language::C, image, &[], "int main() {}", &BTreeMap::new());
+
+        assert_ne!(key(Some("gcc:13")), key(Some("gcc:14")));
+        assert_ne!(key(Some("gcc:14")), key(None));
+    }
+
+    #[test]
+    fn key_depends_on_files() {
+        let files =
+            |contents: &str| BTreeMap::from([(String::from("helper.c"), String::from(contents))]);
+
+        let before = CompileCache::key(Language::C, None, &[], "int main() {}", &files("aW50IHg7"));
+        let after = CompileCache::key(Language::C, None, &[], "int main() {}", &files("aW50IHk7"));
+
+        assert_ne!(before, after);
+    }
+
+    #[test]
+    fn restores_nested_artifacts() {
+        let dir = env::temp_dir().join(format!("cache-{}", Uuid::new_v4()));
+        let cache = CompileCache::new(dir.clone(), 1024);
+        let compiled = workspace(b"binary");
+        fs::create_dir(compiled.join("util")).expect("failed to create package");
+        fs::write(compiled.join("util/Helper.class"), b"class").expect("failed to write artifact");
+        let fresh = env::temp_dir().join(format!("cache-workspace-{}", Uuid::new_v4()));
+        fs::create_dir_all(&fresh).expect("failed to create workspace");
+
+        cache.store("a", &compiled, &["util/Helper.class".to_string()], "");
+        let actual = cache.restore("a", &fresh);
+
+        assert_eq!(actual.as_deref(), Some(""));
+        assert_eq!(fs::read(fresh.join("util/Helper.class")).unwrap(), b"class");
+        for dir in [dir, compiled, fresh] {
+            fs::remove_dir_all(dir).expect("failed to remove directory");
+        }
+    }
+
+    #[test]
+    fn restores_artifacts() {
+        let dir = env::temp_dir().join(format!("cache-{}", Uuid::new_v4()));
+        let cache = CompileCache::new(dir.clone(), 1024);
+        let compiled = workspace(b"binary");
+        let fresh = env::temp_dir().join(format!("cache-workspace-{}", Uuid::new_v4()));
+        fs::create_dir_all(&fresh).expect("failed to create workspace");
+
+        cache.store("a", &compiled, &["test".to_string()], "warning");
+        let actual = cache.restore("a", &fresh);
+
+        assert_eq!(actual.as_deref(), Some("warning"));
+        assert_eq!(fs::read(fresh.join("test")).unwrap(), b"binary");
+        for dir in [dir, compiled, fresh] {
+            fs::remove_dir_all(dir).expect("failed to remove directory");
+        }
+    }
+
+    #[test]
+    fn evicts_least_recently_used() {
+        let dir = env::temp_dir().join(format!("cache-{}", Uuid::new_v4()));
+        let cache = CompileCache::new(dir.clone(), 20);
+        let compiled = workspace(b"0123456789");
+        let artifacts = ["test".to_string()];
+
+        cache.store("a", &compiled, &artifacts, "");
+        cache.store("b", &compiled, &artifacts, "");
+        cache.restore("a", &compiled);
+        cache.store("c", &compiled, &artifacts, "");
+
+        assert!(cache.restore("a", &compiled).is_some());
+        assert!(cache.restore("b", &compiled).is_none());
+        assert!(cache.restore("c", &compiled).is_some());
+        assert!(!dir.join("b").exists());
+        for dir in [dir, compiled] {
+            fs::remove_dir_all(dir).expect("failed to remove directory");
+        }
+    }
+
+    #[test]
+    fn keeps_entries_being_restored() {
+        let dir = env::temp_dir().join(format!("cache-{}", Uuid::new_v4()));
+        let cache = CompileCache::new(dir.clone(), 20);
+        let compiled = workspace(b"0123456789");
+        let artifacts = ["test".to_string()];
+
+        cache.store("a", &compiled, &artifacts, "");
+        cache.store("b", &compiled, &artifacts, "");
+        // as if both entries were being copied by restores which have not finished yet
+        for entry in cache.lock().entries.values_mut() {
+            entry.readers = 1;
+        }
+        cache.store("c", &compiled, &artifacts, "");
+
+        assert!(dir.join("a").exists());
+        assert!(dir.join("b").exists());
+        assert!(!dir.join("c").exists());
+        assert!(!cache.lock().entries.contains_key("c"));
+        for dir in [dir, compiled] {
+            fs::remove_dir_all(dir).expect("failed to remove directory");
+        }
+    }
+
+    #[test]
+    fn disabled() {
+        let dir = env::temp_dir().join(format!("cache-{}", Uuid::new_v4()));
+        let cache = CompileCache::new(dir.clone(), 0);
+        let compiled = workspace(b"binary");
+
+        cache.store("a", &compiled, &["test".to_string()], "");
+
+        assert!(cache.restore("a", &compiled).is_none());
+        assert!(!dir.exists());
+        fs::remove_dir_all(compiled).expect("failed to remove directory");
+    }
+}
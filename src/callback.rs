@@ -0,0 +1,508 @@
+use crate::{
+    config::Config,
+    metrics::METRICS,
+    response::{JobResponse, SubmitResponse},
+    signing::{self, Signer},
+};
+use reqwest::{
+    dns::{Addrs, Name, Resolve, Resolving},
+    header, redirect, Client, StatusCode, Url,
+};
+use ring::hmac;
+use std::{
+    fmt::Write,
+    net::{IpAddr, SocketAddr},
+    sync::Arc,
+    time::{Duration, SystemTime, UNIX_EPOCH},
+};
+use tracing::{info, warn, Instrument};
+use uuid::Uuid;
+
+/// The header carrying the signature of a callback, which is `sha256=` followed by the hex encoded HMAC.
+pub const SIGNATURE_HEADER: &str = "x-mozart-signature";
+
+/// The header carrying the unix time in seconds at which a callback was signed, which is part of the signature.
+pub const TIMESTAMP_HEADER: &str = "x-mozart-timestamp";
+
+/// How long a single attempt to deliver a callback may take.
+const TIMEOUT: Duration = Duration::from_secs(10);
+
+/// The delay before the first retry, which doubles with every retry up to [`MAX_BACKOFF`].
+const INITIAL_BACKOFF: Duration = Duration::from_secs(1);
+
+const MAX_BACKOFF: Duration = Duration::from_secs(60);
+
+/// Delivers the results of jobs to the callback URLs of their submissions.
+pub struct Callbacks {
+    client: Client,
+    /// The secret every callback is signed with, which leaves callbacks unsigned if it is not configured.
+    secret: Option<String>,
+    /// The signer the body of every callback is signed with, as results are signed when they are responded with.
+    signer: Arc<Signer>,
+    attempts: u32,
+    /// The hosts which are trusted to resolve to any address, rather than only to public ones.
+    hosts: Arc<[String]>,
+}
+
+impl Callbacks {
+    pub fn from_config(config: &Config, signer: Arc<Signer>) -> Self {
+        let hosts: Arc<[String]> = config.callback_hosts.clone().into();
+        Self {
+            client: Client::builder()
+                .timeout(TIMEOUT)
+                // a redirect could lead to any address, which is not resolved by the resolver of the client
+                .redirect(redirect::Policy::none())
+                .dns_resolver(Arc::new(Resolver {
+                    hosts: hosts.clone(),
+                }))
+                .build()
+                .expect("the callback client should build"),
+            secret: config.callback_secret.clone(),
+            signer,
+            attempts: config.callback_attempts,
+            hosts,
+        }
+    }
+
+    /// Posts the response of a job to the URL in the background, retrying with exponential backoff until it is
+    /// accepted with a 2xx status code, or every attempt failed.
+    pub fn send(&self, url: String, id: Uuid, response: &SubmitResponse) {
+        let body = serde_json::to_vec(&JobResponse {
+            id: Some(id),
+            response,
+        })
+        .expect("a job response should always serialize");
+
+        // an address is connected to as it is, without being resolved
+        if let Some(address) = host(&url)
+            .filter(|host| !is_trusted(&self.hosts, host))
+            .and_then(|host| host.parse().ok())
+            .filter(|address| !is_public(*address))
+        {
+            METRICS.callback_failure();
+            warn!(task = %id, %address, "refused to send callback to an address which is not public");
+            return;
+        }
+
+        let client = self.client.clone();
+        let secret = self.secret.clone();
+        let signature = self
+            .signer
+            .sign(&body)
+            .map(|(kid, signature)| (kid.to_string(), signature));
+        let attempts = self.attempts;
+
+        tokio::spawn(
+            async move {
+                for attempt in 0..attempts {
+                    if attempt > 0 {
+                        tokio::time::sleep(backoff(attempt)).await;
+                    }
+
+                    let timestamp = SystemTime::now()
+                        .duration_since(UNIX_EPOCH)
+                        .map_or(0, |since| since.as_secs());
+                    let mut request = client
+                        .post(&url)
+                        .header(header::CONTENT_TYPE, "application/json")
+                        .header(TIMESTAMP_HEADER, timestamp)
+                        .body(body.clone());
+                    if let Some(secret) = &secret {
+                        request = request.header(SIGNATURE_HEADER, sign(secret, timestamp, &body));
+                    }
+                    if let Some((kid, signature)) = &signature {
+                        request = request
+                            .header(signing::KEY_ID_HEADER, kid)
+                            .header(signing::SIGNATURE_HEADER, signature);
+                    }
+
+                    match request.send().await {
+                        Ok(response) if response.status().is_success() => {
+                            METRICS.callback_delivered();
+                            info!(task = %id, attempt, "delivered callback");
+                            return;
+                        }
+                        Ok(response) if !is_retryable(response.status()) => {
+                            warn!(task = %id, status = response.status().as_u16(), "callback was rejected");
+                            break;
+                        }
+                        Ok(response) => {
+                            warn!(task = %id, attempt, status = response.status().as_u16(), "failed to deliver callback")
+                        }
+                        Err(err) => warn!(task = %id, attempt, %err, "failed to deliver callback"),
+                    }
+                }
+
+                METRICS.callback_failure();
+                warn!(task = %id, "gave up delivering callback");
+            }
+            .in_current_span(),
+        );
+    }
+}
+
+/// Resolves the hosts of callbacks, failing for a host which resolves to an address which is not public unless it is
+/// trusted, so a submission cannot have mozart post to the services of the network it runs in.
+struct Resolver {
+    hosts: Arc<[String]>,
+}
+
+impl Resolve for Resolver {
+    fn resolve(&self, name: Name) -> Resolving {
+        let host = name.as_str().to_string();
+        let trusted = is_trusted(&self.hosts, &host);
+
+        Box::pin(async move {
+            let addresses: Vec<SocketAddr> =
+                tokio::net::lookup_host((host.as_str(), 0)).await?.collect();
+            if let Some(address) = addresses
+                .iter()
+                .find(|address| !trusted && !is_public(address.ip()))
+            {
+                return Err(format!(
+                    "{host} resolves to {}, which is not a public address",
+                    address.ip()
+                )
+                .into());
+            }
+
+            Ok(Box::new(addresses.into_iter()) as Addrs)
+        })
+    }
+}
+
+/// Gets the lowercase host of a callback URL, without the brackets around an IPv6 address, unless it has none.
+pub fn host(url: &str) -> Option<String> {
+    let url = Url::parse(url).ok()?;
+    let host = url.host_str().filter(|host| !host.is_empty())?;
+    Some(
+        host.trim_start_matches('[')
+            .trim_end_matches(']')
+            .to_ascii_lowercase(),
+    )
+}
+
+/// Whether the host is one of the configured callback hosts.
+pub fn is_trusted(hosts: &[String], host: &str) -> bool {
+    hosts
+        .iter()
+        .any(|trusted| trusted.eq_ignore_ascii_case(host))
+}
+
+/// Whether an address is reachable from the internet, rather than being private, loopback, link-local, or otherwise
+/// reserved, like the metadata services of clouds at 169.254.169.254, fd00:ec2::254, and 100.100.100.200.
+fn is_public(address: IpAddr) -> bool {
+    match address {
+        IpAddr::V4(address) => {
+            let [first, second, ..] = address.octets();
+            !(address.is_private()
+                || address.is_loopback()
+                || address.is_link_local()
+                || address.is_unspecified()
+                || address.is_broadcast()
+                || address.is_documentation()
+                || first == 0
+                // the shared address space of carrier-grade NAT
+                || (first == 100 && second & 0xc0 == 64)
+                // the benchmarking networks
+                || (first == 198 && second & 0xfe == 18)
+                // multicast, and the addresses reserved for future use
+                || first >= 224)
+        }
+        IpAddr::V6(address) => match address.to_ipv4_mapped() {
+            Some(address) => is_public(IpAddr::V4(address)),
+            None => {
+                let first = address.segments()[0];
+                !(address.is_loopback()
+                    || address.is_unspecified()
+                    // unique local addresses
+                    || first & 0xfe00 == 0xfc00
+                    // link-local addresses
+                    || first & 0xffc0 == 0xfe80
+                    // multicast addresses
+                    || first & 0xff00 == 0xff00)
+            }
+        },
+    }
+}
+
+/// Whether a callback which was responded to with the status code may be accepted when it is sent again.
+///
+/// Other client errors would be rejected again, as the request is the same every time.
+fn is_retryable(status: StatusCode) -> bool {
+    status.is_server_error()
+        || status == StatusCode::REQUEST_TIMEOUT
+        || status == StatusCode::TOO_MANY_REQUESTS
+}
+
+/// Gets the delay before the given retry, counting the first attempt as 0.
+fn backoff(attempt: u32) -> Duration {
+    INITIAL_BACKOFF
+        .saturating_mul(2u32.saturating_pow(attempt - 1))
+        .min(MAX_BACKOFF)
+}
+
+/// Signs the timestamp and body of a callback, so the receiver can check it was sent by mozart, and recently.
+///
+/// The signature is the HMAC-SHA256 of the timestamp, a period, and the body.
+pub fn sign(secret: &str, timestamp: u64, body: &[u8]) -> String {
+    let message = [format!("{timestamp}.").as_bytes(), body].concat();
+    let mac = hmac_sha256(secret.as_bytes(), &message);
+
+    mac.iter()
+        .fold(String::from("sha256="), |mut signature, byte| {
+            let _ = write!(signature, "{byte:02x}");
+            signature
+        })
+}
+
+/// Computes the HMAC-SHA256 of the message with the key.
+pub fn hmac_sha256(key: &[u8], message: &[u8]) -> [u8; 32] {
+    hmac::sign(&hmac::Key::new(hmac::HMAC_SHA256, key), message)
+        .as_ref()
+        .try_into()
+        .expect("a HMAC-SHA256 is 32 bytes long")
+}
+
+#[cfg(test)]
+mod sign {
+    use super::{backoff, hmac_sha256, sign};
+    use std::time::Duration;
+
+    #[test]
+    fn hmac_test_vector() {
+        // test case 2 of RFC 4231
+        let actual = hmac_sha256(b"Jefe", b"what do ya want for nothing?");
+
+        assert_eq!(
+            actual
+                .iter()
+                .map(|byte| format!("{byte:02x}"))
+                .collect::<String>(),
+            "5bdcc146bf60754e6a042426089575c75a003f089d2739839dec58b964ec3843"
+        );
+    }
+
+    #[test]
+    fn long_key_is_hashed() {
+        // test case 6 of RFC 4231, whose key is longer than a block
+        let actual = hmac_sha256(
+            &[0xaa; 131],
+            b"Test Using Larger Than Block-Size Key - Hash Key First",
+        );
+
+        assert_eq!(
+            actual[..4],
+            [0x60, 0xe4, 0x31, 0x59],
+            "the mac should start with 60e43159"
+        );
+    }
+
+    #[test]
+    fn signs_timestamp_and_body() {
+        let actual = sign("Jefe", 1700000000, b"{}");
+
+        assert!(actual.starts_with("sha256="));
+        assert_eq!(actual.len(), "sha256=".len() + 64);
+        assert_ne!(actual, sign("Jefe", 1700000001, b"{}"));
+    }
+
+    #[test]
+    fn exponential_backoff() {
+        assert_eq!(backoff(1), Duration::from_secs(1));
+        assert_eq!(backoff(3), Duration::from_secs(4));
+        assert_eq!(backoff(10), Duration::from_secs(60));
+    }
+}
+
+#[cfg(test)]
+mod addresses {
+    use super::{host, is_public, is_trusted};
+
+    #[test]
+    fn public() {
+        for address in ["1.1.1.1", "93.184.216.34", "2606:4700::1111"] {
+            assert!(is_public(address.parse().unwrap()), "{address}");
+        }
+    }
+
+    #[test]
+    fn not_public() {
+        for address in [
+            "127.0.0.1",
+            "10.1.2.3",
+            "172.16.0.1",
+            "192.168.1.1",
+            "169.254.169.254",
+            "100.100.100.200",
+            "0.0.0.0",
+            "255.255.255.255",
+            "::1",
+            "::",
+            "fd00:ec2::254",
+            "fe80::1",
+            "::ffff:127.0.0.1",
+        ] {
+            assert!(!is_public(address.parse().unwrap()), "{address}");
+        }
+    }
+
+    #[test]
+    fn hosts() {
+        assert_eq!(
+            host("https://LMS.example.com:8443/results").as_deref(),
+            Some("lms.example.com")
+        );
+        assert_eq!(host("http://[::1]:80/").as_deref(), Some("::1"));
+        assert_eq!(host("not a url"), None);
+        assert!(is_trusted(
+            &[String::from("LMS.example.com")],
+            "lms.example.com"
+        ));
+        assert!(!is_trusted(&[], "lms.example.com"));
+    }
+}
+
+#[cfg(test)]
+mod delivery {
+    use super::{sign, Callbacks, SIGNATURE_HEADER, TIMESTAMP_HEADER};
+    use crate::{config::Config, response::SubmitResponse};
+    use serde_json::json;
+    use std::{sync::Arc, time::Duration};
+    use tokio::{
+        io::{AsyncReadExt, AsyncWriteExt},
+        net::TcpListener,
+    };
+    use uuid::Uuid;
+
+    /// A config trusting the loopback address every callback is received on.
+    fn config() -> Config {
+        Config {
+            callback_hosts: vec![String::from("127.0.0.1")],
+            ..Config::default()
+        }
+    }
+
+    /// Reads a single request, and gets its headers and body.
+    async fn receive(listener: &TcpListener, status: &str) -> (String, Vec<u8>) {
+        let (mut stream, _) = listener.accept().await.unwrap();
+        let mut request = Vec::new();
+        let mut buffer = [0; 4096];
+        let (head, body) = loop {
+            let read = stream.read(&mut buffer).await.unwrap();
+            request.extend_from_slice(&buffer[..read]);
+            let Some(end) = request.windows(4).position(|window| window == b"\r\n\r\n") else {
+                continue;
+            };
+            let head = String::from_utf8(request[..end].to_vec())
+                .unwrap()
+                .to_lowercase();
+            let length: usize = head
+                .lines()
+                .find_map(|line| line.strip_prefix("content-length: "))
+                .unwrap()
+                .parse()
+                .unwrap();
+            if request.len() >= end + 4 + length {
+                break (head, request[end + 4..end + 4 + length].to_vec());
+            }
+        };
+
+        let response =
+            format!("HTTP/1.1 {status}\r\ncontent-length: 0\r\nconnection: close\r\n\r\n");
+        stream.write_all(response.as_bytes()).await.unwrap();
+        (head, body)
+    }
+
+    fn header<'a>(head: &'a str, name: &str) -> &'a str {
+        head.lines()
+            .find_map(|line| line.strip_prefix(&format!("{name}: ")))
+            .unwrap()
+    }
+
+    #[tokio::test]
+    async fn signed() {
+        let listener = TcpListener::bind("127.0.0.1:0").await.unwrap();
+        let url = format!("http://{}/results", listener.local_addr().unwrap());
+        let callbacks = Callbacks::from_config(
+            &Config {
+                callback_secret: Some(String::from("secret")),
+                ..config()
+            },
+            Arc::default(),
+        );
+        let id = Uuid::new_v4();
+
+        callbacks.send(url, id, &SubmitResponse::Internal);
+        let (head, body) = receive(&listener, "200 OK").await;
+
+        let timestamp = header(&head, TIMESTAMP_HEADER).parse().unwrap();
+        assert_eq!(
+            header(&head, SIGNATURE_HEADER),
+            sign("secret", timestamp, &body)
+        );
+        assert_eq!(
+            serde_json::from_slice::<serde_json::Value>(&body).unwrap(),
+            json!({ "id": id, "kind": "internal" })
+        );
+    }
+
+    #[tokio::test]
+    async fn retried_on_server_error() {
+        let listener = TcpListener::bind("127.0.0.1:0").await.unwrap();
+        let url = format!("http://{}/results", listener.local_addr().unwrap());
+        let callbacks = Callbacks::from_config(&config(), Arc::default());
+
+        callbacks.send(url, Uuid::new_v4(), &SubmitResponse::Internal);
+        let (first, _) = receive(&listener, "503 Service Unavailable").await;
+        let (second, _) = receive(&listener, "200 OK").await;
+
+        assert!(
+            !first.contains(SIGNATURE_HEADER),
+            "unsigned without a secret"
+        );
+        assert!(second.starts_with("post /results"));
+    }
+
+    #[tokio::test]
+    async fn refused_for_loopback() {
+        let listener = TcpListener::bind("127.0.0.1:0").await.unwrap();
+        let port = listener.local_addr().unwrap().port();
+        let callbacks = Callbacks::from_config(
+            &Config {
+                callback_attempts: 1,
+                ..Config::default()
+            },
+            Arc::default(),
+        );
+
+        for url in [
+            format!("http://127.0.0.1:{port}/results"),
+            format!("http://localhost:{port}/results"),
+        ] {
+            callbacks.send(url, Uuid::new_v4(), &SubmitResponse::Internal);
+        }
+
+        let accepted = tokio::time::timeout(Duration::from_secs(1), listener.accept()).await;
+        assert!(accepted.is_err(), "no callback should be sent");
+    }
+
+    #[tokio::test]
+    async fn redirect_not_followed() {
+        let listener = TcpListener::bind("127.0.0.1:0").await.unwrap();
+        let target = TcpListener::bind("127.0.0.1:0").await.unwrap();
+        let url = format!("http://{}/results", listener.local_addr().unwrap());
+        let callbacks = Callbacks::from_config(&config(), Arc::default());
+
+        callbacks.send(url, Uuid::new_v4(), &SubmitResponse::Internal);
+        let (mut stream, _) = listener.accept().await.unwrap();
+        let response = format!(
+            "HTTP/1.1 307 Temporary Redirect\r\nlocation: http://{}/\r\ncontent-length: 0\r\nconnection: close\r\n\r\n",
+            target.local_addr().unwrap()
+        );
+        stream.write_all(response.as_bytes()).await.unwrap();
+
+        let accepted = tokio::time::timeout(Duration::from_secs(1), target.accept()).await;
+        assert!(accepted.is_err(), "the redirect should not be followed");
+    }
+}
@@ -0,0 +1,125 @@
+use std::{
+    pin::pin,
+    sync::{
+        atomic::{AtomicBool, Ordering},
+        Arc, OnceLock,
+    },
+    time::Instant,
+};
+use tokio::sync::Notify;
+
+/// A request to cancel a judgment, which is shared between the job and everything checking its submission.
+///
+/// A queued judgment waits for it along with a worker, while a running one is polled for it by the sandbox, which
+/// kills whatever it is executing once the judgment is cancelled.
+///
+/// A judgment with a deadline is cancelled once the deadline passes as well, so it can never take longer than that.
+#[derive(Clone, Default, Debug)]
+pub struct Cancellation(Arc<Inner>);
+
+#[derive(Default, Debug)]
+struct Inner {
+    cancelled: AtomicBool,
+    deadline: OnceLock<Instant>,
+    notify: Notify,
+}
+
+impl Cancellation {
+    /// Cancels the judgment, waking everything waiting for it to be cancelled.
+    pub fn cancel(&self) {
+        self.0.cancelled.store(true, Ordering::SeqCst);
+        self.0.notify.notify_waiters();
+    }
+
+    /// Cancels the judgment once the deadline passes, unless it already has a deadline, which is kept.
+    pub fn expire_at(&self, deadline: Instant) {
+        if self.0.deadline.set(deadline).is_ok() {
+            // the waiters have to wait for the deadline from now on
+            self.0.notify.notify_waiters();
+        }
+    }
+
+    pub fn is_cancelled(&self) -> bool {
+        self.0.cancelled.load(Ordering::SeqCst) || self.is_expired()
+    }
+
+    /// Checks whether the deadline of the judgment has passed, whether or not it was cancelled on request before.
+    pub fn is_expired(&self) -> bool {
+        self.0
+            .deadline
+            .get()
+            .is_some_and(|deadline| Instant::now() >= *deadline)
+    }
+
+    /// Waits until the judgment is cancelled, which completes right away if it already is.
+    pub async fn cancelled(&self) {
+        loop {
+            let mut notified = pin!(self.0.notify.notified());
+            // the waiter is registered before checking, so a cancellation in between is not missed
+            notified.as_mut().enable();
+            if self.is_cancelled() {
+                return;
+            }
+
+            match self.0.deadline.get() {
+                Some(deadline) => {
+                    let expired = tokio::time::sleep_until((*deadline).into());
+                    tokio::select! {
+                        _ = notified => {}
+                        _ = expired => return,
+                    }
+                }
+                None => notified.await,
+            }
+        }
+    }
+}
+
+#[cfg(test)]
+mod cancellation {
+    use super::Cancellation;
+    use std::time::{Duration, Instant};
+
+    #[tokio::test]
+    async fn wakes_waiter() {
+        let cancellation = Cancellation::default();
+        let waiter = tokio::spawn({
+            let cancellation = cancellation.clone();
+            async move { cancellation.cancelled().await }
+        });
+        tokio::task::yield_now().await;
+
+        cancellation.cancel();
+        let actual = tokio::time::timeout(Duration::from_secs(1), waiter).await;
+
+        assert!(actual.is_ok());
+        assert!(cancellation.is_cancelled());
+    }
+
+    #[tokio::test]
+    async fn already_cancelled() {
+        let cancellation = Cancellation::default();
+
+        cancellation.cancel();
+        let actual = tokio::time::timeout(Duration::from_secs(1), cancellation.cancelled()).await;
+
+        assert!(actual.is_ok());
+    }
+
+    #[tokio::test]
+    async fn expires() {
+        let cancellation = Cancellation::default();
+        let waiter = tokio::spawn({
+            let cancellation = cancellation.clone();
+            async move { cancellation.cancelled().await }
+        });
+        tokio::task::yield_now().await;
+
+        cancellation.expire_at(Instant::now() + Duration::from_millis(50));
+        let actual = tokio::time::timeout(Duration::from_secs(1), waiter).await;
+
+        assert!(actual.is_ok());
+        assert!(cancellation.is_cancelled());
+        assert!(cancellation.is_expired());
+    }
+}
@@ -0,0 +1,209 @@
+use mozart::{
+    config::Config,
+    judge::Judge,
+    model::{Submission, Verdict},
+};
+use serde_json::{Map, Value};
+use std::{fs, path::PathBuf, process::ExitCode};
+
+const USAGE: &str =
+    "usage: mozart judge [--lang <language>] --solution <file> --tests <file> [--<setting> <value>]...";
+
+/// The arguments of `mozart judge`, which judges a solution locally rather than serving, so exercise authors can
+/// validate their test cases without a server.
+#[derive(Debug, PartialEq)]
+struct JudgeArgs {
+    /// The language of the solution, which may also be given by the tests.
+    language: Option<String>,
+    solution: PathBuf,
+    /// The tests of the solution, which are either an array of test cases, or a submission without a solution, like
+    /// a registered exercise.
+    tests: PathBuf,
+    /// The remaining flags, which configure mozart like they do when serving.
+    config: Vec<String>,
+}
+
+/// Judges a solution against its tests with the same engine as the server, printing the result as JSON.
+///
+/// Exits with 0 if the solution passed, 1 if it failed or did not compile, and 2 if it could not be judged at all.
+pub fn judge(args: &[String]) -> ExitCode {
+    let args = match parse(args) {
+        Ok(args) => args,
+        Err(err) => {
+            eprintln!("{err}\n{USAGE}");
+            return ExitCode::from(2);
+        }
+    };
+    let submission = match submission(&args) {
+        Ok(submission) => submission,
+        Err(err) => {
+            eprintln!("{err}");
+            return ExitCode::from(2);
+        }
+    };
+    let config = match Config::load_from(args.config) {
+        Ok(config) => config,
+        Err(err) => {
+            eprintln!("{err}");
+            return ExitCode::from(2);
+        }
+    };
+
+    match Judge::new(config).check(submission).into_result() {
+        Ok(result) => {
+            println!(
+                "{}",
+                serde_json::to_string_pretty(&result).expect("a result should always serialize")
+            );
+            match result.verdict {
+                Verdict::Pass => ExitCode::SUCCESS,
+                _ => ExitCode::from(1),
+            }
+        }
+        Err(problem) => {
+            eprintln!(
+                "{}",
+                serde_json::to_string_pretty(&problem).expect("a problem should always serialize")
+            );
+            ExitCode::from(2)
+        }
+    }
+}
+
+fn parse(args: &[String]) -> Result<JudgeArgs, String> {
+    let (mut language, mut solution, mut tests) = (None, None, None);
+    let mut config = Vec::new();
+    let mut args = args.iter();
+
+    while let Some(arg) = args.next() {
+        let (flag, value) = match arg.split_once('=') {
+            Some((flag, value)) => (flag, Some(value.to_string())),
+            None => (arg.as_str(), None),
+        };
+        let target = match flag {
+            "--lang" => &mut language,
+            "--solution" => &mut solution,
+            "--tests" => &mut tests,
+            // the config reports what is wrong with any other argument
+            _ => {
+                config.push(arg.clone());
+                continue;
+            }
+        };
+        let value = match value {
+            Some(value) => value,
+            None => args
+                .next()
+                .cloned()
+                .ok_or_else(|| format!("the flag {flag} is missing a value"))?,
+        };
+        *target = Some(value);
+    }
+
+    Ok(JudgeArgs {
+        language,
+        solution: solution.ok_or("the flag --solution is required")?.into(),
+        tests: tests.ok_or("the flag --tests is required")?.into(),
+        config,
+    })
+}
+
+/// Creates the submission of the solution from its tests.
+fn submission(args: &JudgeArgs) -> Result<Submission, String> {
+    let read = |path: &PathBuf| {
+        fs::read_to_string(path).map_err(|err| format!("failed to read {}: {err}", path.display()))
+    };
+    let solution = read(&args.solution)?;
+    let tests: Value = serde_json::from_str(&read(&args.tests)?)
+        .map_err(|err| format!("{} is not valid JSON: {err}", args.tests.display()))?;
+
+    let mut submission = match tests {
+        Value::Array(test_cases) => {
+            Map::from_iter([(String::from("testCases"), Value::Array(test_cases))])
+        }
+        Value::Object(submission) => submission,
+        _ => {
+            return Err(format!(
+                "{} is neither an array of test cases nor a submission",
+                args.tests.display()
+            ))
+        }
+    };
+    submission.insert(String::from("solution"), Value::from(solution));
+    if let Some(language) = &args.language {
+        submission.insert(String::from("language"), Value::from(language.as_str()));
+    }
+
+    serde_json::from_value(Value::Object(submission))
+        .map_err(|err| format!("{} is not a valid submission: {err}", args.tests.display()))
+}
+
+#[cfg(test)]
+mod local {
+    use super::{parse, submission, JudgeArgs};
+    use mozart::model::Language;
+    use std::{env, fs, path::PathBuf};
+    use uuid::Uuid;
+
+    fn args(args: &[&str]) -> Vec<String> {
+        args.iter().map(|arg| arg.to_string()).collect()
+    }
+
+    #[test]
+    fn parses_flags() {
+        let actual = parse(&args(&[
+            "--lang=go",
+            "--solution",
+            "main.go",
+            "--workers",
+            "2",
+            "--tests",
+            "tests.json",
+        ]));
+
+        assert_eq!(
+            actual,
+            Ok(JudgeArgs {
+                language: Some(String::from("go")),
+                solution: PathBuf::from("main.go"),
+                tests: PathBuf::from("tests.json"),
+                config: args(&["--workers", "2"]),
+            })
+        );
+    }
+
+    #[test]
+    fn missing_flags() {
+        assert!(parse(&args(&["--solution", "main.go"])).is_err());
+        assert!(parse(&args(&["--tests", "tests.json", "--solution"])).is_err());
+    }
+
+    #[test]
+    fn submission_of_test_cases() {
+        let dir = env::temp_dir().join(format!("mozart-cli-{}", Uuid::new_v4()));
+        fs::create_dir_all(&dir).unwrap();
+        fs::write(dir.join("Solution.hs"), "solution x = x * 2").unwrap();
+        fs::write(
+            dir.join("tests.json"),
+            r#"[{
+                "id": 0,
+                "inputParameters": [{ "valueType": "int", "value": "21" }],
+                "outputParameters": [{ "valueType": "int", "value": "42" }]
+            }]"#,
+        )
+        .unwrap();
+
+        let actual = submission(&JudgeArgs {
+            language: Some(String::from("haskell")),
+            solution: dir.join("Solution.hs"),
+            tests: dir.join("tests.json"),
+            config: Vec::new(),
+        });
+        fs::remove_dir_all(&dir).unwrap();
+
+        let submission = actual.expect("the tests should be valid");
+        assert_eq!(submission.language, Language::Haskell);
+        assert_eq!(submission.solution, "solution x = x * 2");
+        assert_eq!(submission.test_cases.len(), 1);
+    }
+}
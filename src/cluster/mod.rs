@@ -0,0 +1,860 @@
+use crate::{
+    config::Config,
+    error::{ClusterError, StoreError},
+    job::{JobRecord, JobStore},
+    model::{Priority, Submission},
+    response::{Quota, SubmitResponse},
+    store::{exercise_of, Store},
+    AppState,
+};
+use redis::Reply;
+use std::{sync::Arc, time::Duration};
+use tracing::{info, info_span, warn, Instrument};
+use uuid::Uuid;
+
+mod redis;
+
+pub use redis::{Address, Client};
+
+/// The list of jobs of normal priority waiting for any replica, oldest first, where the jobs of the other priorities
+/// wait in lists of their own.
+const QUEUE: &str = "mozart:queue";
+
+/// The set of every replica with a heartbeat, where a replica whose heartbeat expired is left once its claims are
+/// recovered.
+const REPLICAS: &str = "mozart:replicas";
+
+/// The set of the ids of every persisted job.
+const JOB_IDS: &str = "mozart:job-ids";
+
+/// The prefix of the sets of the ids of the persisted jobs of every batch.
+const BATCH_JOB_IDS: &str = "mozart:batch-job-ids";
+
+/// The prefix of the sets of the ids of the persisted jobs of every exercise.
+const EXERCISE_JOB_IDS: &str = "mozart:exercise-job-ids";
+
+/// How often a replica looks for a job to claim, while it has a free worker.
+const CLAIM_INTERVAL: Duration = Duration::from_millis(250);
+
+/// How long a request to cancel a job is kept for the replica running it.
+const CANCEL_TTL: Duration = Duration::from_secs(24 * 60 * 60);
+
+/// The replicas of mozart sharing a Redis server, which hold a queue of jobs, and the records of every job.
+///
+/// A replica claims a job by atomically moving it from the queue into a list of its own claims, which it keeps until
+/// the job is done. Every replica keeps a heartbeat, which expires once it has not been renewed for the claim TTL, and
+/// every replica returns the claims of replicas whose heartbeat expired to the front of the queue, so the jobs of a
+/// replica which died are judged again by another, without any replica leading the others.
+pub struct Cluster {
+    client: Client,
+    replica: String,
+    claim_ttl: Duration,
+}
+
+impl Cluster {
+    /// Creates the configured cluster, if mozart runs as a replica of one at all.
+    pub fn from_config(config: &Config) -> Option<Self> {
+        let address = Address::parse(config.cluster_url.as_ref()?).ok()?;
+
+        Some(Self {
+            client: Client::new(address),
+            replica: config.replica_id(),
+            claim_ttl: config.claim_ttl(),
+        })
+    }
+
+    /// Queues a job of the priority for whichever replica has a free worker first.
+    pub fn enqueue(&self, id: Uuid, priority: Priority) -> Result<(), ClusterError> {
+        self.client
+            .command(&[
+                b"RPUSH",
+                queue_key(priority).as_bytes(),
+                id.to_string().as_bytes(),
+            ])
+            .map(drop)
+    }
+
+    /// Gets the number of jobs of every priority which are waiting for any replica.
+    pub fn queue_depth(&self) -> Result<usize, ClusterError> {
+        let mut depth = 0;
+        for priority in Priority::ALL {
+            depth += self
+                .client
+                .command(&[b"LLEN", queue_key(priority).as_bytes()])?
+                .integer()?;
+        }
+
+        Ok(usize::try_from(depth).unwrap_or_default())
+    }
+
+    /// Claims the oldest queued job of the highest priority which is at least `lowest` for this replica, if there is
+    /// one, along with its priority.
+    fn claim(&self, lowest: Priority) -> Result<Option<(Uuid, Priority)>, ClusterError> {
+        let claims = claims_key(&self.replica);
+        for priority in Priority::ALL.into_iter().filter(|p| *p >= lowest) {
+            let id = self
+                .client
+                .command(&[
+                    b"LMOVE",
+                    queue_key(priority).as_bytes(),
+                    claims.as_bytes(),
+                    b"LEFT",
+                    b"RIGHT",
+                ])?
+                .bulk()?;
+            if let Some(id) = id {
+                return Ok(Some((parse_id(&id)?, priority)));
+            }
+        }
+
+        Ok(None)
+    }
+
+    /// Returns the latest claim of this replica to the back of the queue of its priority, for another replica to
+    /// claim.
+    fn requeue_claimed(&self, priority: Priority) -> Result<(), ClusterError> {
+        let claims = claims_key(&self.replica);
+        self.client
+            .command(&[
+                b"LMOVE",
+                claims.as_bytes(),
+                queue_key(priority).as_bytes(),
+                b"RIGHT",
+                b"RIGHT",
+            ])
+            .map(drop)
+    }
+
+    /// Gives up the claim of this replica on a job which is done, along with any request to cancel it.
+    pub fn release(&self, id: Uuid) -> Result<(), ClusterError> {
+        let id = id.to_string();
+        let claims = claims_key(&self.replica);
+        self.client
+            .command(&[b"LREM", claims.as_bytes(), b"0", id.as_bytes()])?;
+        self.client
+            .command(&[b"DEL", cancel_key(&id).as_bytes()])
+            .map(drop)
+    }
+
+    /// Requests a job to be cancelled, returning whether it was still queued, in which case it is withdrawn from the
+    /// queue, and no replica ever judges it.
+    ///
+    /// A job which is running is cancelled by whichever replica runs it, once it notices the request.
+    pub fn cancel(&self, id: Uuid) -> Result<bool, ClusterError> {
+        let id = id.to_string();
+        let ttl = CANCEL_TTL.as_millis().to_string();
+        self.client.command(&[
+            b"SET",
+            cancel_key(&id).as_bytes(),
+            b"1",
+            b"PX",
+            ttl.as_bytes(),
+        ])?;
+        let mut withdrawn = 0;
+        for priority in Priority::ALL {
+            withdrawn += self
+                .client
+                .command(&[b"LREM", queue_key(priority).as_bytes(), b"0", id.as_bytes()])?
+                .integer()?;
+        }
+
+        Ok(withdrawn > 0)
+    }
+
+    /// Whether a job was requested to be cancelled.
+    fn is_cancelled(&self, id: Uuid) -> Result<bool, ClusterError> {
+        let id = id.to_string();
+        let exists = self
+            .client
+            .command(&[b"EXISTS", cancel_key(&id).as_bytes()])?
+            .integer()?;
+
+        Ok(exists > 0)
+    }
+
+    /// Renews the heartbeat of this replica, which tells the others that its claims are still being judged.
+    fn heartbeat(&self) -> Result<(), ClusterError> {
+        let ttl = self.claim_ttl.as_millis().to_string();
+        self.client
+            .command(&[b"SADD", REPLICAS.as_bytes(), self.replica.as_bytes()])?;
+        self.client
+            .command(&[
+                b"SET",
+                heartbeat_key(&self.replica).as_bytes(),
+                b"1",
+                b"PX",
+                ttl.as_bytes(),
+            ])
+            .map(drop)
+    }
+
+    /// Returns the claims of every replica whose heartbeat expired to the front of the queue, returning how many were
+    /// returned.
+    ///
+    /// Moving a claim is atomic, so replicas recovering the same claims at once never return a claim twice.
+    fn recover(&self) -> Result<usize, ClusterError> {
+        let mut recovered = 0;
+        for replica in self
+            .client
+            .command(&[b"SMEMBERS", REPLICAS.as_bytes()])?
+            .array()?
+        {
+            let Some(replica) = replica.bulk()? else {
+                continue;
+            };
+            let replica = String::from_utf8_lossy(&replica).into_owned();
+            let alive = self
+                .client
+                .command(&[b"EXISTS", heartbeat_key(&replica).as_bytes()])?
+                .integer()?;
+            if alive > 0 || replica == self.replica {
+                continue;
+            }
+
+            recovered += self.recover_claims(&replica)?;
+            self.client
+                .command(&[b"SREM", REPLICAS.as_bytes(), replica.as_bytes()])?;
+        }
+
+        Ok(recovered)
+    }
+
+    /// Returns every claim of the replica to the front of the queue of normal priority, oldest claim first, as the claims
+    /// do not keep the priority of their jobs.
+    fn recover_claims(&self, replica: &str) -> Result<usize, ClusterError> {
+        let claims = claims_key(replica);
+        let mut recovered = 0;
+        // the newest claim is moved first, so the oldest ends up at the very front
+        while let Reply::Bulk(Some(_)) = self.client.command(&[
+            b"LMOVE",
+            claims.as_bytes(),
+            QUEUE.as_bytes(),
+            b"RIGHT",
+            b"LEFT",
+        ])? {
+            recovered += 1;
+        }
+
+        Ok(recovered)
+    }
+}
+
+fn queue_key(priority: Priority) -> String {
+    match priority {
+        Priority::Normal => QUEUE.to_string(),
+        priority => format!("{QUEUE}:{}", priority.as_str()),
+    }
+}
+
+fn claims_key(replica: &str) -> String {
+    format!("mozart:claims:{replica}")
+}
+
+fn heartbeat_key(replica: &str) -> String {
+    format!("mozart:replicas:{replica}")
+}
+
+fn cancel_key(id: &str) -> String {
+    format!("mozart:cancel:{id}")
+}
+
+fn job_key(id: Uuid) -> String {
+    format!("mozart:jobs:{id}")
+}
+
+/// Gets the keys of the sets of the ids of persisted jobs which the job of the record belongs in.
+fn job_ids_keys(record: &JobRecord) -> Vec<String> {
+    let batch = record
+        .batch
+        .as_ref()
+        .map(|batch| format!("{BATCH_JOB_IDS}:{}", batch.id));
+    let exercise =
+        exercise_of(record).map(|exercise_id| format!("{EXERCISE_JOB_IDS}:{exercise_id}"));
+
+    std::iter::once(JOB_IDS.to_string())
+        .chain(batch)
+        .chain(exercise)
+        .collect()
+}
+
+fn parse_id(id: &[u8]) -> Result<Uuid, ClusterError> {
+    std::str::from_utf8(id)
+        .ok()
+        .and_then(|id| Uuid::parse_str(id).ok())
+        .ok_or_else(|| ClusterError::Protocol(String::from("a queued job has an invalid id")))
+}
+
+/// A store keeping every job in the Redis server of the cluster, so the jobs of every replica are visible to all of
+/// them.
+///
+/// Unlike the other stores, jobs are persisted once they are queued, and once they start running, besides once they
+/// are done.
+pub struct RedisStore {
+    client: Client,
+}
+
+impl RedisStore {
+    /// Creates the store of the configured cluster, if mozart runs as a replica of one at all.
+    pub fn from_config(config: &Config) -> Option<Self> {
+        let address = Address::parse(config.cluster_url.as_ref()?).ok()?;
+
+        Some(Self {
+            client: Client::new(address),
+        })
+    }
+}
+
+impl Store for RedisStore {
+    fn save(&self, record: &JobRecord) -> Result<(), StoreError> {
+        let json =
+            serde_json::to_vec(record).map_err(|err| StoreError::Corrupt(err.to_string()))?;
+
+        self.client
+            .command(&[b"SET", job_key(record.id).as_bytes(), &json])?;
+        for key in job_ids_keys(record) {
+            self.client
+                .command(&[b"SADD", key.as_bytes(), record.id.to_string().as_bytes()])?;
+        }
+
+        Ok(())
+    }
+
+    fn load(&self, id: Uuid) -> Result<Option<JobRecord>, StoreError> {
+        let Some(json) = self
+            .client
+            .command(&[b"GET", job_key(id).as_bytes()])?
+            .bulk()?
+        else {
+            return Ok(None);
+        };
+
+        serde_json::from_slice(&json)
+            .map(Some)
+            .map_err(|err| StoreError::Corrupt(err.to_string()))
+    }
+
+    fn all(&self) -> Result<Vec<JobRecord>, StoreError> {
+        self.members(JOB_IDS)
+    }
+
+    fn batch(&self, batch_id: Uuid) -> Result<Vec<JobRecord>, StoreError> {
+        self.members(&format!("{BATCH_JOB_IDS}:{batch_id}"))
+    }
+
+    fn exercise(&self, exercise_id: &str) -> Result<Vec<JobRecord>, StoreError> {
+        self.members(&format!("{EXERCISE_JOB_IDS}:{exercise_id}"))
+    }
+
+    fn check(&self) -> Result<(), StoreError> {
+        self.client.command(&[b"PING"])?;
+
+        Ok(())
+    }
+
+    fn retire(&self, record: &JobRecord) -> Result<(), StoreError> {
+        self.client
+            .command(&[b"DEL", job_key(record.id).as_bytes()])?;
+        for key in job_ids_keys(record) {
+            self.client
+                .command(&[b"SREM", key.as_bytes(), record.id.to_string().as_bytes()])?;
+        }
+
+        Ok(())
+    }
+}
+
+impl RedisStore {
+    /// Loads the record of every job whose id is in the set of the key, skipping the records which are corrupt.
+    fn members(&self, key: &str) -> Result<Vec<JobRecord>, StoreError> {
+        let ids = self
+            .client
+            .command(&[b"SMEMBERS", key.as_bytes()])?
+            .array()?;
+
+        let mut records = Vec::new();
+        for id in ids {
+            let Some(id) = id.bulk()? else {
+                continue;
+            };
+            let id = parse_id(&id)?;
+            // ids whose record is already gone belong to jobs which are being retired
+            match self.load(id) {
+                Ok(Some(record)) => records.push(record),
+                Ok(None) => {}
+                Err(StoreError::Corrupt(err)) => {
+                    warn!(%err, task = %id, "skipped a persisted job which cannot be loaded")
+                }
+                Err(err) => return Err(err),
+            }
+        }
+
+        Ok(records)
+    }
+}
+
+/// Takes part in the cluster in the background until mozart exits, claiming queued jobs whenever a worker is free.
+///
+/// The heartbeat of this replica is renewed three times per claim TTL, at which point the claims of replicas whose
+/// heartbeat expired are recovered, and the jobs running on this replica are checked for whether they were requested
+/// to be cancelled. The claims this replica had before it was restarted are recovered right away, as they are no longer
+/// being judged.
+pub fn start(state: AppState, cluster: Arc<Cluster>) {
+    let maintained = state.clone();
+    let maintaining = cluster.clone();
+    tokio::spawn(
+        async move {
+            let restarted = maintaining.clone();
+            match tokio::task::spawn_blocking(move || restarted.recover_claims(&restarted.replica))
+                .await
+            {
+                Ok(Ok(0)) => {}
+                Ok(Ok(recovered)) => info!(
+                    recovered,
+                    "requeued the jobs this replica had before it restarted"
+                ),
+                Ok(Err(err)) => {
+                    warn!(%err, "failed to recover the jobs this replica had before it restarted")
+                }
+                Err(_) => {}
+            }
+            let mut interval = tokio::time::interval(maintaining.claim_ttl / 3);
+            loop {
+                interval.tick().await;
+                let (jobs, cluster) = (maintained.jobs.clone(), maintaining.clone());
+                let _ = tokio::task::spawn_blocking(move || maintain(&cluster, &jobs)).await;
+            }
+        }
+        .instrument(info_span!("cluster")),
+    );
+
+    tokio::spawn(
+        async move {
+            let mut interval = tokio::time::interval(CLAIM_INTERVAL);
+            loop {
+                interval.tick().await;
+                // a job is only claimed once a worker is free for it, so jobs never wait on a busy replica
+                while let Some(lowest) = state
+                    .pool
+                    .available()
+                    .filter(|_| state.pool.queue_depth() == 0)
+                {
+                    let (claiming, cluster) = (state.clone(), cluster.clone());
+                    let claimed =
+                        tokio::task::spawn_blocking(move || claim(&claiming, &cluster, lowest))
+                            .await
+                            .unwrap_or_default();
+                    if !claimed {
+                        break;
+                    }
+                }
+            }
+        }
+        .instrument(info_span!("cluster")),
+    );
+}
+
+fn maintain(cluster: &Cluster, jobs: &JobStore) {
+    if let Err(err) = cluster.heartbeat() {
+        warn!(%err, "failed to renew the heartbeat of this replica");
+        return;
+    }
+    match cluster.recover() {
+        Ok(0) => {}
+        Ok(recovered) => info!(recovered, "requeued the jobs of replicas which stopped"),
+        Err(err) => warn!(%err, "failed to recover the jobs of replicas which stopped"),
+    }
+
+    for record in jobs.active() {
+        match cluster.is_cancelled(record.id) {
+            Ok(true) => {
+                info!(task = %record.id, "cancelling job on request of another replica");
+                let _ = jobs.cancel(record.id);
+            }
+            Ok(false) => {}
+            Err(err) => {
+                warn!(%err, task = %record.id, "failed to check whether a job was cancelled")
+            }
+        }
+    }
+}
+
+/// Claims the oldest queued job of the highest priority which is at least `lowest`, and judges it on this replica,
+/// returning whether a job was claimed.
+///
+/// A job whose tenant has as many jobs running on this replica as it may have is returned to the queue, so it waits
+/// for the other jobs of its tenant, while one whose tenant used up its CPU time for today is not judged at all.
+fn claim(state: &AppState, cluster: &Cluster, lowest: Priority) -> bool {
+    let Ok(admission) = state.pool.admit() else {
+        return false;
+    };
+    let (id, priority) = match cluster.claim(lowest) {
+        Ok(Some(claimed)) => claimed,
+        Ok(None) => return false,
+        Err(err) => {
+            warn!(%err, "failed to claim a job");
+            return false;
+        }
+    };
+
+    let Some(record) = state.jobs.record(id) else {
+        // the job was retired while it was queued
+        warn!(task = %id, "dropped a claimed job which is no longer persisted");
+        release(cluster, id);
+        return true;
+    };
+    let tenant = state.tenants.named(record.tenant.as_deref());
+    let permit = match tenant.admit() {
+        Ok(permit) => permit,
+        Err(Quota::Jobs) => {
+            if let Err(err) = cluster.requeue_claimed(priority) {
+                warn!(%err, task = %id, "failed to requeue a job, it is requeued once this replica restarts");
+            }
+            return false;
+        }
+        Err(quota) => {
+            state.jobs.adopt(record);
+            state.jobs.finish(id, SubmitResponse::QuotaExceeded(quota));
+            release(cluster, id);
+            return true;
+        }
+    };
+
+    let submission = serde_json::from_value::<Submission>(record.submission.clone());
+    state.jobs.adopt(record);
+    match submission {
+        Ok(submission) => {
+            info!(task = %id, "claimed job");
+            let admission = admission.with_priority(priority);
+            crate::spawn_job(state, &tenant, id, admission, permit, submission);
+        }
+        Err(err) => {
+            warn!(%err, task = %id, "failed to read the submission of a claimed job");
+            state.jobs.finish(id, SubmitResponse::Internal);
+            release(cluster, id);
+        }
+    }
+
+    true
+}
+
+fn release(cluster: &Cluster, id: Uuid) {
+    if let Err(err) = cluster.release(id) {
+        warn!(%err, task = %id, "failed to release a job, it is requeued once this replica restarts");
+    }
+}
+
+#[cfg(test)]
+mod replicas {
+    use super::{Address, Client, Cluster, RedisStore};
+    use crate::{
+        error::CancelError,
+        job::{JobStatus, JobStore},
+        model::{Priority, Submission},
+        response::SubmitResponse,
+        store::Store,
+    };
+    use std::{
+        collections::{HashMap, HashSet, VecDeque},
+        io::{BufRead, BufReader, Read, Write},
+        net::{TcpListener, TcpStream},
+        sync::{Arc, Mutex},
+        thread,
+        time::{Duration, Instant},
+    };
+    use uuid::Uuid;
+
+    /// What a key of the fake Redis server holds.
+    enum Value {
+        String(Vec<u8>, Option<Instant>),
+        List(VecDeque<Vec<u8>>),
+        Set(HashSet<Vec<u8>>),
+    }
+
+    /// Serves a Redis server which keeps its keys in memory, and only knows the commands of the cluster.
+    fn redis() -> String {
+        let listener = TcpListener::bind("127.0.0.1:0").unwrap();
+        let address = listener.local_addr().unwrap();
+        let keys = Arc::new(Mutex::new(HashMap::<Vec<u8>, Value>::new()));
+        thread::spawn(move || {
+            for stream in listener.incoming().flatten() {
+                let keys = keys.clone();
+                thread::spawn(move || serve(stream, &keys));
+            }
+        });
+
+        format!("redis://{address}")
+    }
+
+    fn serve(stream: TcpStream, keys: &Mutex<HashMap<Vec<u8>, Value>>) {
+        let mut reader = BufReader::new(stream.try_clone().unwrap());
+        let mut stream = stream;
+        loop {
+            let mut line = String::new();
+            if reader.read_line(&mut line).unwrap_or_default() == 0 {
+                return;
+            }
+            let count: usize = line.trim_end()[1..].parse().unwrap();
+            let mut args = Vec::with_capacity(count);
+            for _ in 0..count {
+                let mut line = String::new();
+                reader.read_line(&mut line).unwrap();
+                let mut arg = vec![0; line.trim_end()[1..].parse::<usize>().unwrap() + 2];
+                reader.read_exact(&mut arg).unwrap();
+                arg.truncate(arg.len() - 2);
+                args.push(arg);
+            }
+
+            let reply = execute(&mut keys.lock().unwrap(), &args);
+            stream.write_all(&reply).unwrap();
+        }
+    }
+
+    fn execute(keys: &mut HashMap<Vec<u8>, Value>, args: &[Vec<u8>]) -> Vec<u8> {
+        let integer = |integer: usize| format!(":{integer}\r\n").into_bytes();
+        let bulk = |bulk: Option<&[u8]>| match bulk {
+            Some(bulk) => [format!("${}\r\n", bulk.len()).as_bytes(), bulk, b"\r\n"].concat(),
+            None => b"$-1\r\n".to_vec(),
+        };
+        keys.retain(|_, value| {
+            !matches!(value, Value::String(_, Some(expiry)) if *expiry <= Instant::now())
+        });
+        macro_rules! list {
+            ($key:expr) => {
+                match keys
+                    .entry($key.clone())
+                    .or_insert_with(|| Value::List(VecDeque::new()))
+                {
+                    Value::List(list) => list,
+                    _ => return b"-WRONGTYPE\r\n".to_vec(),
+                }
+            };
+        }
+        macro_rules! set {
+            ($key:expr) => {
+                match keys
+                    .entry($key.clone())
+                    .or_insert_with(|| Value::Set(HashSet::new()))
+                {
+                    Value::Set(set) => set,
+                    _ => return b"-WRONGTYPE\r\n".to_vec(),
+                }
+            };
+        }
+
+        let reply = match (args[0].as_slice(), &args[1..]) {
+            (b"PING", []) => b"+PONG\r\n".to_vec(),
+            (b"SET", [key, value, rest @ ..]) => {
+                let expiry = match rest {
+                    [_, millis] => Some(
+                        Instant::now()
+                            + Duration::from_millis(
+                                String::from_utf8_lossy(millis).parse().unwrap(),
+                            ),
+                    ),
+                    _ => None,
+                };
+                keys.insert(key.clone(), Value::String(value.clone(), expiry));
+                b"+OK\r\n".to_vec()
+            }
+            (b"GET", [key]) => match keys.get(key) {
+                Some(Value::String(value, _)) => bulk(Some(value)),
+                _ => bulk(None),
+            },
+            (b"EXISTS", [key]) => integer(usize::from(keys.contains_key(key))),
+            (b"DEL", [key]) => integer(usize::from(keys.remove(key).is_some())),
+            (b"RPUSH", [key, value]) => {
+                let list = list!(key);
+                list.push_back(value.clone());
+                integer(list.len())
+            }
+            (b"LLEN", [key]) => integer(list!(key).len()),
+            (b"LREM", [key, _, value]) => {
+                let list = list!(key);
+                let before = list.len();
+                list.retain(|item| item != value);
+                integer(before - list.len())
+            }
+            (b"LMOVE", [source, destination, from, to]) => {
+                let item = match from.as_slice() {
+                    b"LEFT" => list!(source).pop_front(),
+                    _ => list!(source).pop_back(),
+                };
+                if let Some(item) = &item {
+                    match to.as_slice() {
+                        b"LEFT" => list!(destination).push_front(item.clone()),
+                        _ => list!(destination).push_back(item.clone()),
+                    }
+                }
+                bulk(item.as_deref())
+            }
+            (b"SADD", [key, member]) => integer(usize::from(set!(key).insert(member.clone()))),
+            (b"SREM", [key, member]) => integer(usize::from(set!(key).remove(member))),
+            (b"SMEMBERS", [key]) => {
+                let members: Vec<Vec<u8>> = set!(key).iter().cloned().collect();
+                let mut reply = format!("*{}\r\n", members.len()).into_bytes();
+                for member in members {
+                    reply.extend(bulk(Some(&member)));
+                }
+                reply
+            }
+            _ => b"-ERR unknown command\r\n".to_vec(),
+        };
+        // empty lists and sets do not exist in Redis
+        keys.retain(|_, value| match value {
+            Value::List(list) => !list.is_empty(),
+            Value::Set(set) => !set.is_empty(),
+            Value::String(..) => true,
+        });
+
+        reply
+    }
+
+    fn cluster(url: &str, replica: &str, claim_ttl: Duration) -> Cluster {
+        Cluster {
+            client: Client::new(Address::parse(url).unwrap()),
+            replica: replica.to_string(),
+            claim_ttl,
+        }
+    }
+
+    #[test]
+    fn claims_jobs_once() {
+        let url = redis();
+        let first = cluster(&url, "first", Duration::from_secs(30));
+        let second = cluster(&url, "second", Duration::from_secs(30));
+        let (a, b) = (Uuid::new_v4(), Uuid::new_v4());
+        first.enqueue(a, Priority::Normal).unwrap();
+        first.enqueue(b, Priority::Normal).unwrap();
+
+        assert_eq!(second.queue_depth().unwrap(), 2);
+        assert_eq!(
+            first.claim(Priority::Batch).unwrap(),
+            Some((a, Priority::Normal))
+        );
+        assert_eq!(
+            second.claim(Priority::Batch).unwrap(),
+            Some((b, Priority::Normal))
+        );
+        assert_eq!(first.claim(Priority::Batch).unwrap(), None);
+        assert_eq!(second.queue_depth().unwrap(), 0);
+    }
+
+    #[test]
+    fn claims_higher_priorities_first() {
+        let url = redis();
+        let cluster = cluster(&url, "replica", Duration::from_secs(30));
+        let (batch, normal, exam) = (Uuid::new_v4(), Uuid::new_v4(), Uuid::new_v4());
+        cluster.enqueue(batch, Priority::Batch).unwrap();
+        cluster.enqueue(normal, Priority::Normal).unwrap();
+        cluster.enqueue(exam, Priority::Exam).unwrap();
+
+        assert_eq!(cluster.queue_depth().unwrap(), 3);
+        assert_eq!(
+            cluster.claim(Priority::Batch).unwrap(),
+            Some((exam, Priority::Exam))
+        );
+        assert_eq!(cluster.claim(Priority::Exam).unwrap(), None);
+        assert_eq!(
+            cluster.claim(Priority::Batch).unwrap(),
+            Some((normal, Priority::Normal))
+        );
+        cluster.requeue_claimed(Priority::Normal).unwrap();
+        assert!(cluster.cancel(batch).unwrap());
+        assert_eq!(
+            cluster.claim(Priority::Batch).unwrap(),
+            Some((normal, Priority::Normal))
+        );
+        assert_eq!(cluster.claim(Priority::Batch).unwrap(), None);
+    }
+
+    #[test]
+    fn recovers_claims_of_stopped_replicas() {
+        let url = redis();
+        let stopped = cluster(&url, "stopped", Duration::from_millis(50));
+        let alive = cluster(&url, "alive", Duration::from_secs(30));
+        let (a, b, c) = (Uuid::new_v4(), Uuid::new_v4(), Uuid::new_v4());
+        for id in [a, b, c] {
+            stopped.enqueue(id, Priority::Normal).unwrap();
+        }
+        stopped.heartbeat().unwrap();
+        alive.heartbeat().unwrap();
+        stopped.claim(Priority::Batch).unwrap();
+        stopped.claim(Priority::Batch).unwrap();
+        alive.claim(Priority::Batch).unwrap();
+
+        assert_eq!(alive.recover().unwrap(), 0);
+        thread::sleep(Duration::from_millis(100));
+        alive.heartbeat().unwrap();
+
+        assert_eq!(alive.recover().unwrap(), 2);
+        assert_eq!(alive.recover().unwrap(), 0);
+        // the recovered claims are claimed again in the order they were queued, and released claims are gone
+        alive.release(c).unwrap();
+        assert_eq!(
+            alive.claim(Priority::Batch).unwrap(),
+            Some((a, Priority::Normal))
+        );
+        assert_eq!(
+            alive.claim(Priority::Batch).unwrap(),
+            Some((b, Priority::Normal))
+        );
+        assert_eq!(alive.recover_claims("alive").unwrap(), 2);
+    }
+
+    #[test]
+    fn cancels_queued_jobs() {
+        let url = redis();
+        let cluster = cluster(&url, "replica", Duration::from_secs(30));
+        let (queued, running) = (Uuid::new_v4(), Uuid::new_v4());
+        cluster.enqueue(running, Priority::Normal).unwrap();
+        cluster.enqueue(queued, Priority::Normal).unwrap();
+        cluster.claim(Priority::Batch).unwrap();
+
+        assert!(cluster.cancel(queued).unwrap());
+        assert!(!cluster.cancel(running).unwrap());
+        assert!(cluster.is_cancelled(running).unwrap());
+        assert_eq!(cluster.queue_depth().unwrap(), 0);
+        cluster.release(running).unwrap();
+        assert!(!cluster.is_cancelled(running).unwrap());
+    }
+
+    #[test]
+    fn shares_jobs_between_replicas() {
+        let url = redis();
+        let store = || {
+            Arc::new(RedisStore {
+                client: Client::new(Address::parse(&url).unwrap()),
+            }) as Arc<dyn Store>
+        };
+        let submitting = JobStore::new(Some(store())).shared();
+        let judging = JobStore::new(Some(store())).shared();
+        let cluster = cluster(&url, "replica", Duration::from_secs(30));
+        let submission: Submission =
+            serde_json::from_str(r#"{"solution": "solution = 5", "testCases": []}"#).unwrap();
+
+        let id = submitting.create(None, 0, &submission);
+        submitting
+            .hand_off(id, || Ok(cluster.enqueue(id, Priority::Normal)?))
+            .expect("the job should be handed off");
+        assert_eq!(submitting.status(id), Some(JobStatus::Queued));
+        assert!(submitting.active().is_empty());
+
+        let (claimed, _) = cluster
+            .claim(Priority::Batch)
+            .unwrap()
+            .expect("the job should be queued");
+        judging.adopt(
+            judging
+                .record(claimed)
+                .expect("the job should be persisted"),
+        );
+        judging.set_status(id, JobStatus::Running);
+        assert_eq!(submitting.status(id), Some(JobStatus::Running));
+        assert!(matches!(submitting.cancel(id), Err(CancelError::Elsewhere)));
+
+        judging.finish(id, SubmitResponse::Internal);
+        assert!(judging.active().is_empty());
+        assert_eq!(submitting.status(id), Some(JobStatus::Failed));
+        assert_eq!(store().all().map(|records| records.len()).ok(), Some(1));
+    }
+}
@@ -0,0 +1,178 @@
+use serde::{Deserialize, Serialize};
+
+/// The tolerance of [`Comparison::Float`], if the test case does not specify one.
+pub const DEFAULT_EPSILON: f64 = 1e-6;
+
+/// How the actual output of a test case is compared to the expected output.
+///
+/// Every test program first compares the values itself, which is the exact comparison. If they differ, the values
+/// as printed by the test program are compared again in the mode of the test case, so formatting differences do not
+/// fail a test case which allows them.
+#[derive(Deserialize, Serialize, Clone, Copy, PartialEq, Debug, Default)]
+pub enum Comparison {
+    /// The values must be equal.
+    #[default]
+    #[serde(rename = "exact")]
+    Exact,
+
+    /// The values must be equal, ignoring leading and trailing whitespace.
+    #[serde(rename = "trimmed")]
+    Trimmed,
+
+    /// The values must consist of the same whitespace separated tokens, regardless of the whitespace between them.
+    #[serde(rename = "tokens")]
+    Tokens,
+
+    /// Like [`Comparison::Tokens`], but numeric tokens only have to be equal within the epsilon of the test case.
+    #[serde(rename = "float")]
+    Float,
+
+    /// The values must consist of the same lines, regardless of their order, e.g. the rows of a sql query without an
+    /// `ORDER BY`.
+    #[serde(rename = "unordered")]
+    Unordered,
+}
+
+impl Comparison {
+    /// Compares the printed values of a test case which the test program found to differ.
+    ///
+    /// Quoted strings are compared by their contents, so different quotes and escapes of the languages do not matter.
+    pub fn matches(self, actual: &str, expected: &str, epsilon: f64) -> bool {
+        let actual = unquote(actual);
+        let expected = unquote(expected);
+
+        match self {
+            Comparison::Exact => false,
+            Comparison::Trimmed => actual.trim() == expected.trim(),
+            Comparison::Tokens => actual.split_whitespace().eq(expected.split_whitespace()),
+            Comparison::Float => {
+                let actual: Vec<&str> = numeric_tokens(&actual).collect();
+                let expected: Vec<&str> = numeric_tokens(&expected).collect();
+
+                actual.len() == expected.len()
+                    && actual
+                        .iter()
+                        .zip(&expected)
+                        .all(|(actual, expected)| float_eq(actual, expected, epsilon))
+            }
+            Comparison::Unordered => {
+                let mut actual: Vec<&str> = actual.lines().collect();
+                let mut expected: Vec<&str> = expected.lines().collect();
+                actual.sort_unstable();
+                expected.sort_unstable();
+
+                actual == expected
+            }
+        }
+    }
+}
+
+/// Splits a value into tokens, where the punctuation of tuples and lists also separates tokens.
+fn numeric_tokens(value: &str) -> impl Iterator<Item = &str> {
+    value
+        .split(|c: char| c.is_whitespace() || matches!(c, ',' | '(' | ')' | '[' | ']' | '{' | '}'))
+        .filter(|token| !token.is_empty())
+}
+
+/// Compares two tokens, which are equal within the epsilon if both are numbers, and otherwise must be the same.
+///
+/// The epsilon is both an absolute and a relative tolerance, so that large numbers are not held to more precision
+/// than a floating point number has.
+fn float_eq(actual: &str, expected: &str, epsilon: f64) -> bool {
+    match (actual.parse::<f64>(), expected.parse::<f64>()) {
+        (Ok(actual), Ok(expected)) => {
+            let difference = (actual - expected).abs();
+            actual == expected
+                || difference <= epsilon
+                || difference <= epsilon * expected.abs().max(actual.abs())
+        }
+        _ => actual == expected,
+    }
+}
+
+/// Removes the quotes of a string literal, resolving the common escape sequences, or returns the value unchanged if
+/// it is not quoted.
+pub fn unquote(value: &str) -> String {
+    let quoted = ['"', '\'']
+        .into_iter()
+        .find(|quote| value.len() >= 2 && value.starts_with(*quote) && value.ends_with(*quote));
+    let Some(quote) = quoted else {
+        return value.to_string();
+    };
+
+    let inner = &value[quote.len_utf8()..value.len() - quote.len_utf8()];
+    let mut unquoted = String::with_capacity(inner.len());
+    let mut chars = inner.chars();
+    while let Some(c) = chars.next() {
+        if c != '\\' {
+            unquoted.push(c);
+            continue;
+        }
+
+        match chars.next() {
+            Some('n') => unquoted.push('\n'),
+            Some('r') => unquoted.push('\r'),
+            Some('t') => unquoted.push('\t'),
+            Some(escaped) => unquoted.push(escaped),
+            None => unquoted.push('\\'),
+        }
+    }
+
+    unquoted
+}
+
+#[cfg(test)]
+mod matches {
+    use super::{Comparison, DEFAULT_EPSILON};
+
+    #[test]
+    fn exact() {
+        assert!(!Comparison::Exact.matches("5", "6", DEFAULT_EPSILON));
+    }
+
+    #[test]
+    fn trimmed() {
+        assert!(Comparison::Trimmed.matches(r#""hello ""#, r#""hello""#, DEFAULT_EPSILON));
+        assert!(Comparison::Trimmed.matches(r"'hello\n'", r#""hello""#, DEFAULT_EPSILON));
+        assert!(!Comparison::Trimmed.matches(
+            r#""hello  world""#,
+            r#""hello world""#,
+            DEFAULT_EPSILON
+        ));
+    }
+
+    #[test]
+    fn tokens() {
+        assert!(Comparison::Tokens.matches(
+            r#""hello  world""#,
+            r#""hello world""#,
+            DEFAULT_EPSILON
+        ));
+        assert!(Comparison::Tokens.matches(r#""1\t2\n3""#, r#""1 2 3""#, DEFAULT_EPSILON));
+        assert!(!Comparison::Tokens.matches(r#""1 2""#, r#""1 2 3""#, DEFAULT_EPSILON));
+    }
+
+    #[test]
+    fn float() {
+        assert!(Comparison::Float.matches("0.30000000000000004", "0.3", DEFAULT_EPSILON));
+        assert!(Comparison::Float.matches("(1.0000001,2.0)", "(1.0,2.0)", DEFAULT_EPSILON));
+        assert!(Comparison::Float.matches("1000000.5", "1000000.0", DEFAULT_EPSILON));
+        assert!(!Comparison::Float.matches("0.31", "0.3", DEFAULT_EPSILON));
+        assert!(Comparison::Float.matches("0.31", "0.3", 0.1));
+        assert!(!Comparison::Float.matches("abc", "abd", 1.0));
+    }
+
+    #[test]
+    fn unordered() {
+        assert!(Comparison::Unordered.matches(
+            r#""2 'bob'\n1 'alice'""#,
+            r#""1 'alice'\n2 'bob'""#,
+            DEFAULT_EPSILON
+        ));
+        assert!(!Comparison::Unordered.matches(
+            r#""1 'alice'\n1 'alice'""#,
+            r#""1 'alice'\n2 'bob'""#,
+            DEFAULT_EPSILON
+        ));
+    }
+}
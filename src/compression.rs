@@ -0,0 +1,221 @@
+use axum::{
+    body::{to_bytes, Body},
+    extract::{Request, State},
+    http::{header, HeaderMap, HeaderValue, Method, StatusCode},
+    middleware::Next,
+    response::{IntoResponse, Response},
+};
+use flate2::{write::GzEncoder, Compression};
+use std::io::Write;
+use tracing::error;
+
+/// The smallest body which is compressed, as compressing a smaller body saves next to nothing.
+const MIN_SIZE: usize = 1024;
+
+/// The level bodies are compressed with by zstd, which compresses about as well as gzip does by default, but faster.
+const ZSTD_LEVEL: i32 = 3;
+
+/// The content encodings responses are compressed with, the preferred one first.
+#[derive(Clone, Copy, PartialEq, Debug)]
+enum Encoding {
+    Zstd,
+    Gzip,
+}
+
+impl Encoding {
+    const ALL: [Encoding; 2] = [Encoding::Zstd, Encoding::Gzip];
+
+    fn as_str(self) -> &'static str {
+        match self {
+            Encoding::Zstd => "zstd",
+            Encoding::Gzip => "gzip",
+        }
+    }
+
+    fn compress(self, body: &[u8]) -> std::io::Result<Vec<u8>> {
+        match self {
+            Encoding::Zstd => zstd::bulk::compress(body, ZSTD_LEVEL),
+            Encoding::Gzip => {
+                let mut encoder = GzEncoder::new(Vec::new(), Compression::default());
+                encoder.write_all(body)?;
+                encoder.finish()
+            }
+        }
+    }
+}
+
+/// Gets the encoding the client prefers by the value of its `Accept-Encoding` header, where zstd is preferred over
+/// gzip if the client accepts both as much.
+///
+/// An encoding with a quality of 0 is not accepted, and `*` accepts every encoding which is not named otherwise.
+fn negotiate(accept_encoding: &str) -> Option<Encoding> {
+    let qualities: Vec<(&str, f32)> = accept_encoding
+        .split(',')
+        .filter_map(|coding| {
+            let mut parameters = coding.split(';').map(str::trim);
+            let name = parameters.next().filter(|name| !name.is_empty())?;
+            let quality = parameters
+                .find_map(|parameter| parameter.strip_prefix("q="))
+                .map_or(Some(1.0), |quality| quality.parse().ok())?;
+            Some((name, quality))
+        })
+        .collect();
+    let quality = |encoding: Encoding| {
+        let named = qualities
+            .iter()
+            .find(|(name, _)| name.eq_ignore_ascii_case(encoding.as_str()));
+        let wildcard = qualities.iter().find(|(name, _)| *name == "*");
+        named.or(wildcard).map_or(0.0, |(_, quality)| *quality)
+    };
+
+    // the first of the preferred encodings wins a tie, as the maximum is the last one
+    Encoding::ALL
+        .into_iter()
+        .rev()
+        .map(|encoding| (encoding, quality(encoding)))
+        .filter(|(_, quality)| *quality > 0.0)
+        .max_by(|(_, a), (_, b)| a.total_cmp(b))
+        .map(|(encoding, _)| encoding)
+}
+
+/// Whether a response with the headers may be compressed, which leaves out streamed events, as they are sent as they
+/// happen, and bodies which are encoded already.
+fn is_compressible(headers: &HeaderMap) -> bool {
+    let content_type = headers
+        .get(header::CONTENT_TYPE)
+        .and_then(|value| value.to_str().ok())
+        .unwrap_or_default();
+
+    !headers.contains_key(header::CONTENT_ENCODING)
+        && !content_type.starts_with("text/event-stream")
+        && (content_type.starts_with("text/")
+            || content_type.starts_with("application/json")
+            || content_type.starts_with("application/problem+json")
+            || content_type.starts_with("application/x-tar"))
+}
+
+/// Compresses the responses to clients which accept zstd or gzip, if compression is enabled, so that results with
+/// large outputs and diffs are cheaper to send.
+///
+/// Responses are compressed after they are signed, so a signature is always that of the body before it was compressed.
+pub async fn compress(State(enabled): State<bool>, request: Request, next: Next) -> Response {
+    let encoding = request
+        .headers()
+        .get(header::ACCEPT_ENCODING)
+        .and_then(|value| value.to_str().ok())
+        .and_then(negotiate)
+        .filter(|_| enabled && request.method() != Method::HEAD);
+    let response = next.run(request).await;
+    if !enabled || !is_compressible(response.headers()) {
+        return response;
+    }
+
+    let (mut parts, body) = response.into_parts();
+    // caches have to tell responses to clients accepting different encodings apart, whether this one is compressed
+    parts
+        .headers
+        .append(header::VARY, HeaderValue::from_static("accept-encoding"));
+    let Some(encoding) = encoding else {
+        return Response::from_parts(parts, body);
+    };
+    let body = match to_bytes(body, usize::MAX).await {
+        Ok(body) => body,
+        Err(err) => {
+            error!(%err, "failed to read the response to compress");
+            return StatusCode::INTERNAL_SERVER_ERROR.into_response();
+        }
+    };
+    if body.len() < MIN_SIZE {
+        return Response::from_parts(parts, Body::from(body));
+    }
+
+    match encoding.compress(&body) {
+        Ok(compressed) => {
+            parts.headers.remove(header::CONTENT_LENGTH);
+            parts.headers.insert(
+                header::CONTENT_ENCODING,
+                HeaderValue::from_static(encoding.as_str()),
+            );
+            Response::from_parts(parts, Body::from(compressed))
+        }
+        Err(err) => {
+            error!(%err, encoding = encoding.as_str(), "failed to compress the response");
+            Response::from_parts(parts, Body::from(body))
+        }
+    }
+}
+
+#[cfg(test)]
+mod negotiation {
+    use super::{compress, negotiate, Encoding};
+    use axum::{
+        body::{to_bytes, Body},
+        http::{header, Request, StatusCode},
+        middleware,
+        routing::get,
+        Router,
+    };
+    use std::io::Read;
+    use tower::ServiceExt;
+
+    #[test]
+    fn prefers_zstd() {
+        assert_eq!(negotiate("gzip, deflate, br, zstd"), Some(Encoding::Zstd));
+        assert_eq!(negotiate("gzip;q=1.0, zstd;q=0.5"), Some(Encoding::Gzip));
+        assert_eq!(negotiate("zstd;q=0, *"), Some(Encoding::Gzip));
+        assert_eq!(negotiate("*;q=0.1"), Some(Encoding::Zstd));
+        assert_eq!(negotiate("identity"), None);
+        assert_eq!(negotiate("gzip;q=0"), None);
+        assert_eq!(negotiate(""), None);
+    }
+
+    async fn respond(
+        enabled: bool,
+        accept_encoding: &str,
+        body: &'static str,
+    ) -> (StatusCode, Option<String>, Vec<u8>) {
+        let mozart = Router::new()
+            .route(
+                "/",
+                get(move || async move { ([(header::CONTENT_TYPE, "application/json")], body) }),
+            )
+            .layer(middleware::from_fn_with_state(enabled, compress));
+        let request = Request::builder()
+            .uri("/")
+            .header(header::ACCEPT_ENCODING, accept_encoding)
+            .body(Body::empty())
+            .unwrap();
+
+        let response = mozart.oneshot(request).await.unwrap();
+        let status = response.status();
+        let encoding = response
+            .headers()
+            .get(header::CONTENT_ENCODING)
+            .map(|value| value.to_str().unwrap().to_string());
+        let body = to_bytes(response.into_body(), usize::MAX).await.unwrap();
+
+        (status, encoding, body.to_vec())
+    }
+
+    #[tokio::test]
+    async fn compresses_large_bodies() {
+        let large: &'static str = "[\"wrong answer\"],".repeat(200).leak();
+
+        let (status, zstd, zstd_body) = respond(true, "zstd", large).await;
+        let (_, gzip, gzip_body) = respond(true, "gzip", large).await;
+        let (_, small, _) = respond(true, "zstd", "[]").await;
+        let (_, disabled, _) = respond(false, "zstd", large).await;
+
+        assert_eq!(status, StatusCode::OK);
+        assert_eq!(zstd.as_deref(), Some("zstd"));
+        assert_eq!(zstd::decode_all(&zstd_body[..]).unwrap(), large.as_bytes());
+        assert_eq!(gzip.as_deref(), Some("gzip"));
+        let mut decoded = String::new();
+        flate2::read::GzDecoder::new(&gzip_body[..])
+            .read_to_string(&mut decoded)
+            .unwrap();
+        assert_eq!(decoded, large);
+        assert_eq!(small, None);
+        assert_eq!(disabled, None);
+    }
+}
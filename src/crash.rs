@@ -0,0 +1,156 @@
+use crate::{metrics::METRICS, response::SubmitResponse};
+use axum::{
+    extract::Request,
+    middleware::Next,
+    response::{IntoResponse, Response},
+};
+use serde::{Deserialize, Serialize};
+use std::{
+    any::Any,
+    backtrace::Backtrace,
+    cell::{Cell, RefCell},
+    future::{self, Future},
+    panic::{self, AssertUnwindSafe},
+    pin::pin,
+    sync::Once,
+    task::Poll,
+};
+use tracing::error;
+
+/// Installs the panic hook which records the crashes of code run by [`catch`].
+static HOOK: Once = Once::new();
+
+thread_local! {
+    /// How many calls of [`catch`] the current thread is within.
+    static CATCHING: Cell<usize> = const { Cell::new(0) };
+
+    /// The crash the panic hook recorded for the innermost call of [`catch`] to take.
+    static CAUGHT: RefCell<Option<Crash>> = const { RefCell::new(None) };
+}
+
+/// A panic which was recovered from, along with where it happened.
+#[derive(Serialize, Deserialize, Clone, PartialEq, Debug)]
+#[serde(rename_all = "camelCase")]
+pub struct Crash {
+    /// The message the code panicked with.
+    pub message: String,
+    /// The file, line, and column the code panicked at.
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub location: Option<String>,
+    /// The backtrace of the thread which panicked, as it unwound.
+    pub backtrace: String,
+}
+
+impl Crash {
+    /// Panics again with this crash, so whoever catches it gets this crash instead of a new one.
+    pub fn resume(self) -> ! {
+        panic::resume_unwind(Box::new(self))
+    }
+}
+
+/// Gets the message of the payload of a panic, which is a string unless the code panicked with another value.
+fn message(payload: &(dyn Any + Send)) -> String {
+    payload
+        .downcast_ref::<&str>()
+        .map(|message| message.to_string())
+        .or_else(|| payload.downcast_ref::<String>().cloned())
+        .unwrap_or_else(|| String::from("panicked with a value which is not a string"))
+}
+
+/// Runs the code, recovering from it panicking instead of unwinding the thread.
+///
+/// The panic is recorded along with its backtrace instead of being printed, while panics outside of this function are
+/// still printed like they are by default.
+pub fn catch<T>(code: impl FnOnce() -> T) -> Result<T, Crash> {
+    HOOK.call_once(|| {
+        let previous = panic::take_hook();
+        panic::set_hook(Box::new(move |info| {
+            if CATCHING.get() == 0 {
+                return previous(info);
+            }
+            CAUGHT.set(Some(Crash {
+                message: message(info.payload()),
+                location: info.location().map(ToString::to_string),
+                backtrace: Backtrace::force_capture().to_string(),
+            }));
+        }));
+    });
+
+    CATCHING.set(CATCHING.get() + 1);
+    let result = panic::catch_unwind(AssertUnwindSafe(code));
+    CATCHING.set(CATCHING.get() - 1);
+
+    // a crash which was resumed was not recorded again, so the payload takes precedence
+    let caught = CAUGHT.take();
+    result.map_err(|payload| match payload.downcast::<Crash>() {
+        Ok(crash) => *crash,
+        Err(payload) => caught.unwrap_or_else(|| Crash {
+            message: message(payload.as_ref()),
+            location: None,
+            backtrace: String::new(),
+        }),
+    })
+}
+
+/// Responds with an internal error to a request whose handler panicked, instead of dropping the connection, and logs
+/// the crash along with its backtrace.
+pub async fn recover(request: Request, next: Next) -> Response {
+    let mut handled = pin!(next.run(request));
+    let handled = future::poll_fn(|context| match catch(|| handled.as_mut().poll(context)) {
+        Ok(Poll::Pending) => Poll::Pending,
+        Ok(Poll::Ready(response)) => Poll::Ready(Ok(response)),
+        Err(crash) => Poll::Ready(Err(crash)),
+    })
+    .await;
+
+    handled.unwrap_or_else(|crash| {
+        METRICS.panicked();
+        error!(
+            panic = %crash.message,
+            location = crash.location.as_deref(),
+            backtrace = %crash.backtrace,
+            "recovered from a panic while handling the request"
+        );
+        SubmitResponse::Internal.into_response()
+    })
+}
+
+#[cfg(test)]
+mod catching {
+    use super::{catch, Crash};
+
+    #[test]
+    fn recovers_from_panic() {
+        let actual = catch(|| -> u32 { panic!("malformed job {}", 7) }).unwrap_err();
+
+        assert_eq!(actual.message, "malformed job 7");
+        assert!(actual
+            .location
+            .is_some_and(|location| location.contains("crash.rs:")));
+        assert!(!actual.backtrace.is_empty());
+        assert_eq!(catch(|| 42), Ok(42));
+    }
+
+    #[test]
+    fn keeps_resumed_crash() {
+        let crash = catch::<()>(|| panic!("in a worker")).unwrap_err();
+
+        let actual = catch(|| crash.clone().resume()).unwrap_err();
+
+        assert_eq!(actual, crash);
+    }
+
+    #[test]
+    fn nested_crash() {
+        let actual = catch(|| catch::<()>(|| panic!("inner")).map_err(|crash| crash.message));
+
+        assert_eq!(actual, Ok(Err(String::from("inner"))));
+    }
+
+    #[test]
+    fn value_payload() {
+        let actual = catch::<()>(|| std::panic::panic_any(5)).unwrap_err();
+
+        assert!(matches!(actual, Crash { message, .. } if message.contains("not a string")));
+    }
+}
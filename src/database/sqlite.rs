@@ -0,0 +1,305 @@
+use super::Value;
+use crate::error::StoreError;
+use std::{
+    ffi::{c_char, c_int, c_void, CStr, CString},
+    os::unix::ffi::OsStrExt,
+    path::Path,
+    ptr,
+    sync::OnceLock,
+    time::Duration,
+};
+
+/// The names the SQLite library is looked up by, the first of which is the one distributions install.
+const LIBRARIES: &[&CStr] = &[c"libsqlite3.so.0", c"libsqlite3.so", c"libsqlite3.dylib"];
+
+/// How long a statement waits for another process writing to the database, before failing as it is busy.
+const BUSY_TIMEOUT: Duration = Duration::from_secs(5);
+
+const SQLITE_OK: c_int = 0;
+const SQLITE_ROW: c_int = 100;
+const SQLITE_DONE: c_int = 101;
+const SQLITE_OPEN_READWRITE: c_int = 0x2;
+const SQLITE_OPEN_CREATE: c_int = 0x4;
+const SQLITE_OPEN_FULLMUTEX: c_int = 0x10000;
+/// The destructor of a bound value telling SQLite to copy it, as it does not outlive the call.
+const SQLITE_TRANSIENT: isize = -1;
+
+type Database = c_void;
+type Statement = c_void;
+
+/// The signatures of the functions of the SQLite C interface which are called.
+type OpenV2 =
+    unsafe extern "C" fn(*const c_char, *mut *mut Database, c_int, *const c_char) -> c_int;
+type CloseV2 = unsafe extern "C" fn(*mut Database) -> c_int;
+type Errmsg = unsafe extern "C" fn(*mut Database) -> *const c_char;
+type BusyTimeout = unsafe extern "C" fn(*mut Database, c_int) -> c_int;
+type Exec = unsafe extern "C" fn(
+    *mut Database,
+    *const c_char,
+    *const c_void,
+    *mut c_void,
+    *mut *mut c_char,
+) -> c_int;
+type PrepareV2 = unsafe extern "C" fn(
+    *mut Database,
+    *const c_char,
+    c_int,
+    *mut *mut Statement,
+    *mut *const c_char,
+) -> c_int;
+type BindParameterIndex = unsafe extern "C" fn(*mut Statement, *const c_char) -> c_int;
+type BindText = unsafe extern "C" fn(*mut Statement, c_int, *const c_char, c_int, isize) -> c_int;
+type BindNull = unsafe extern "C" fn(*mut Statement, c_int) -> c_int;
+type Step = unsafe extern "C" fn(*mut Statement) -> c_int;
+type ColumnCount = unsafe extern "C" fn(*mut Statement) -> c_int;
+type ColumnText = unsafe extern "C" fn(*mut Statement, c_int) -> *const u8;
+type ColumnBytes = unsafe extern "C" fn(*mut Statement, c_int) -> c_int;
+type Finalize = unsafe extern "C" fn(*mut Statement) -> c_int;
+
+/// The functions of the SQLite library which are called, which is loaded once it is first needed, so mozart neither
+/// links nor requires it unless jobs are stored in SQLite.
+struct Library {
+    open_v2: OpenV2,
+    close_v2: CloseV2,
+    errmsg: Errmsg,
+    busy_timeout: BusyTimeout,
+    exec: Exec,
+    prepare_v2: PrepareV2,
+    bind_parameter_index: BindParameterIndex,
+    bind_text: BindText,
+    bind_null: BindNull,
+    step: Step,
+    column_count: ColumnCount,
+    column_text: ColumnText,
+    column_bytes: ColumnBytes,
+    finalize: Finalize,
+}
+
+impl Library {
+    /// Gets the library, loading it if it was not loaded yet.
+    fn get() -> Result<&'static Self, StoreError> {
+        static LIBRARY: OnceLock<Result<Library, String>> = OnceLock::new();
+
+        LIBRARY
+            .get_or_init(Self::load)
+            .as_ref()
+            .map_err(|err| StoreError::Io(err.clone()))
+    }
+
+    fn load() -> Result<Self, String> {
+        // SAFETY: the names are valid nul terminated strings, and a loaded library is never unloaded.
+        let handle = LIBRARIES
+            .iter()
+            .map(|name| unsafe { libc::dlopen(name.as_ptr(), libc::RTLD_NOW | libc::RTLD_LOCAL) })
+            .find(|handle| !handle.is_null())
+            .ok_or_else(|| String::from("the SQLite library libsqlite3 is not installed"))?;
+        let symbol = |name: &CStr| {
+            // SAFETY: the handle is a loaded library, and the name is a valid nul terminated string.
+            let symbol = unsafe { libc::dlsym(handle, name.as_ptr()) };
+            match symbol.is_null() {
+                true => Err(format!(
+                    "the SQLite library has no {}",
+                    name.to_string_lossy()
+                )),
+                false => Ok(symbol),
+            }
+        };
+
+        // SAFETY: every symbol is the function of the SQLite C interface of the same name, whose signature is the
+        // type of the field it is assigned to.
+        unsafe {
+            Ok(Self {
+                open_v2: std::mem::transmute::<*mut c_void, OpenV2>(symbol(c"sqlite3_open_v2")?),
+                close_v2: std::mem::transmute::<*mut c_void, CloseV2>(symbol(c"sqlite3_close_v2")?),
+                errmsg: std::mem::transmute::<*mut c_void, Errmsg>(symbol(c"sqlite3_errmsg")?),
+                busy_timeout: std::mem::transmute::<*mut c_void, BusyTimeout>(symbol(
+                    c"sqlite3_busy_timeout",
+                )?),
+                exec: std::mem::transmute::<*mut c_void, Exec>(symbol(c"sqlite3_exec")?),
+                prepare_v2: std::mem::transmute::<*mut c_void, PrepareV2>(symbol(
+                    c"sqlite3_prepare_v2",
+                )?),
+                bind_parameter_index: std::mem::transmute::<*mut c_void, BindParameterIndex>(
+                    symbol(c"sqlite3_bind_parameter_index")?,
+                ),
+                bind_text: std::mem::transmute::<*mut c_void, BindText>(symbol(
+                    c"sqlite3_bind_text",
+                )?),
+                bind_null: std::mem::transmute::<*mut c_void, BindNull>(symbol(
+                    c"sqlite3_bind_null",
+                )?),
+                step: std::mem::transmute::<*mut c_void, Step>(symbol(c"sqlite3_step")?),
+                column_count: std::mem::transmute::<*mut c_void, ColumnCount>(symbol(
+                    c"sqlite3_column_count",
+                )?),
+                column_text: std::mem::transmute::<*mut c_void, ColumnText>(symbol(
+                    c"sqlite3_column_text",
+                )?),
+                column_bytes: std::mem::transmute::<*mut c_void, ColumnBytes>(symbol(
+                    c"sqlite3_column_bytes",
+                )?),
+                finalize: std::mem::transmute::<*mut c_void, Finalize>(symbol(
+                    c"sqlite3_finalize",
+                )?),
+            })
+        }
+    }
+}
+
+/// A connection to a SQLite database file, which is created along with its schema if it does not exist.
+pub struct Connection {
+    library: &'static Library,
+    database: *mut Database,
+}
+
+// SAFETY: the connection is opened in the serialized threading mode of SQLite, in which it may be used by any thread.
+unsafe impl Send for Connection {}
+
+impl Connection {
+    pub fn open(path: &Path) -> Result<Self, StoreError> {
+        let library = Library::get()?;
+        let path = CString::new(path.as_os_str().as_bytes())
+            .map_err(|_| StoreError::Io(String::from("the database path contains a nul byte")))?;
+        let mut database = ptr::null_mut();
+
+        // SAFETY: the path is a valid nul terminated string, and the pointer to the handle outlives the call.
+        let opened = unsafe {
+            (library.open_v2)(
+                path.as_ptr(),
+                &mut database,
+                SQLITE_OPEN_READWRITE | SQLITE_OPEN_CREATE | SQLITE_OPEN_FULLMUTEX,
+                ptr::null(),
+            )
+        };
+        // a handle is returned even if opening failed, which is closed along with the connection
+        let connection = Self { library, database };
+        if opened != SQLITE_OK {
+            return Err(StoreError::Io(connection.error()));
+        }
+
+        // SAFETY: the handle is an open database.
+        unsafe { (library.busy_timeout)(database, BUSY_TIMEOUT.as_millis() as c_int) };
+        Ok(connection)
+    }
+
+    /// Gets the message of the error of the last call which failed.
+    fn error(&self) -> String {
+        if self.database.is_null() {
+            return String::from("out of memory");
+        }
+
+        // SAFETY: the handle is a database, whose message is a valid nul terminated string until the next call.
+        unsafe { CStr::from_ptr((self.library.errmsg)(self.database)) }
+            .to_string_lossy()
+            .into_owned()
+    }
+
+    /// Steps through every row of a prepared statement, binding the parameters first.
+    ///
+    /// # Safety
+    ///
+    /// The statement must be prepared on this connection, and not be finalized yet.
+    unsafe fn run(
+        &self,
+        statement: *mut Statement,
+        params: &[Value],
+    ) -> Result<Vec<Vec<Value>>, StoreError> {
+        let library = self.library;
+        for (index, param) in params.iter().enumerate() {
+            let name = CString::new(format!("${}", index + 1)).expect("a name has no nul byte");
+            // a parameter which is not in the statement has no index
+            let position = (library.bind_parameter_index)(statement, name.as_ptr());
+            if position == 0 {
+                continue;
+            }
+            let bound = match param {
+                Some(text) => (library.bind_text)(
+                    statement,
+                    position,
+                    text.as_ptr().cast(),
+                    text.len() as c_int,
+                    SQLITE_TRANSIENT,
+                ),
+                None => (library.bind_null)(statement, position),
+            };
+            if bound != SQLITE_OK {
+                return Err(StoreError::Database(self.error()));
+            }
+        }
+
+        let mut rows = Vec::new();
+        loop {
+            match (library.step)(statement) {
+                SQLITE_ROW => {
+                    let columns = (library.column_count)(statement);
+                    let row = (0..columns)
+                        .map(|column| {
+                            let text = (library.column_text)(statement, column);
+                            (!text.is_null()).then(|| {
+                                let len = (library.column_bytes)(statement, column) as usize;
+                                String::from_utf8_lossy(std::slice::from_raw_parts(text, len))
+                                    .into_owned()
+                            })
+                        })
+                        .collect();
+                    rows.push(row);
+                }
+                SQLITE_DONE => return Ok(rows),
+                _ => return Err(StoreError::Database(self.error())),
+            }
+        }
+    }
+}
+
+impl super::Connection for Connection {
+    fn query(&mut self, sql: &str, params: &[Value]) -> Result<Vec<Vec<Value>>, StoreError> {
+        let sql = CString::new(sql).expect("a statement has no nul byte");
+        let mut statement = ptr::null_mut();
+
+        // SAFETY: the statement is a valid nul terminated string, and the pointer to the handle outlives the call.
+        let prepared = unsafe {
+            (self.library.prepare_v2)(
+                self.database,
+                sql.as_ptr(),
+                -1,
+                &mut statement,
+                ptr::null_mut(),
+            )
+        };
+        if prepared != SQLITE_OK {
+            return Err(StoreError::Database(self.error()));
+        }
+
+        // SAFETY: the statement was just prepared on this connection, and is only finalized afterwards.
+        let rows = unsafe { self.run(statement, params) };
+        // SAFETY: the statement is prepared, and not used again.
+        unsafe { (self.library.finalize)(statement) };
+        rows
+    }
+
+    fn script(&mut self, sql: &str) -> Result<(), StoreError> {
+        let sql = CString::new(sql).expect("a script has no nul byte");
+
+        // SAFETY: the script is a valid nul terminated string, and no callback is given.
+        let executed = unsafe {
+            (self.library.exec)(
+                self.database,
+                sql.as_ptr(),
+                ptr::null(),
+                ptr::null_mut(),
+                ptr::null_mut(),
+            )
+        };
+        match executed {
+            SQLITE_OK => Ok(()),
+            _ => Err(StoreError::Database(self.error())),
+        }
+    }
+}
+
+impl Drop for Connection {
+    fn drop(&mut self) {
+        // SAFETY: the handle is a database which is not used again, where closing a null handle does nothing.
+        unsafe { (self.library.close_v2)(self.database) };
+    }
+}
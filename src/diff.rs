@@ -0,0 +1,330 @@
+use crate::compare::unquote;
+use std::fmt::Write;
+
+/// How many unchanged lines surround every change of a unified diff.
+const CONTEXT: usize = 3;
+
+/// The most cells the table of the longest common subsequence may have, beyond which the differing middle of both
+/// outputs is shown as replaced as a whole, rather than spending quadratic time and memory on it.
+const MAX_CELLS: usize = 1 << 22;
+
+/// An edit turning the expected output into the actual output, in the order they appear in the diff.
+#[derive(Clone, Copy, PartialEq, Debug)]
+enum Edit<'a> {
+    Equal(&'a str),
+    Delete(&'a str),
+    Insert(&'a str),
+}
+
+/// Creates the diff between the expected and the actual output of a wrong answer, of at most `limit` bytes.
+///
+/// Quoted strings are compared by their contents, like [`crate::compare::Comparison`] compares them. Outputs of a
+/// single line get a word diff in the format of `git diff --word-diff=plain`, where the removed words are marked as
+/// `[-removed-]` and the added words as `{+added+}`. Outputs of several lines get a unified diff, where the trailing
+/// whitespace of changed lines is shown as `·` for spaces and `→` for tabs, as lines which only differ by it would
+/// look the same otherwise.
+pub fn diff(expected: &str, actual: &str, limit: usize) -> String {
+    let expected = unquote(expected);
+    let actual = unquote(actual);
+
+    let diff = if expected.contains('\n') || actual.contains('\n') {
+        unified(&expected, &actual)
+    } else {
+        words(&expected, &actual)
+    };
+
+    truncate(diff, limit)
+}
+
+/// Counts the lines which were added to and removed from `old` to get `new`, e.g. between two versions of a file.
+///
+/// A line which changed counts as both removed and added.
+pub fn line_counts(old: &str, new: &str) -> (usize, usize) {
+    let old: Vec<&str> = old.lines().collect();
+    let new: Vec<&str> = new.lines().collect();
+
+    edits(&old, &new)
+        .iter()
+        .fold((0, 0), |(added, removed), edit| match edit {
+            Edit::Equal(_) => (added, removed),
+            Edit::Delete(_) => (added, removed + 1),
+            Edit::Insert(_) => (added + 1, removed),
+        })
+}
+
+/// Creates the word diff of two lines, where whitespace is a word as well, so changed whitespace is marked too.
+fn words(expected: &str, actual: &str) -> String {
+    let expected = tokens(expected);
+    let actual = tokens(actual);
+
+    let mut diff = String::new();
+    let edits = edits(&expected, &actual);
+    let mut edits = edits.iter().peekable();
+    while let Some(edit) = edits.next() {
+        match edit {
+            Edit::Equal(token) => diff.push_str(token),
+            Edit::Delete(token) => {
+                diff.push_str("[-");
+                diff.push_str(token);
+                while let Some(Edit::Delete(token)) = edits.peek() {
+                    diff.push_str(token);
+                    edits.next();
+                }
+                diff.push_str("-]");
+            }
+            Edit::Insert(token) => {
+                diff.push_str("{+");
+                diff.push_str(token);
+                while let Some(Edit::Insert(token)) = edits.peek() {
+                    diff.push_str(token);
+                    edits.next();
+                }
+                diff.push_str("+}");
+            }
+        }
+    }
+
+    diff
+}
+
+/// Splits a line into its words and the whitespace between them, which together are the whole line.
+fn tokens(line: &str) -> Vec<&str> {
+    let mut tokens = Vec::new();
+    let mut start = 0;
+    let mut whitespace = None;
+    for (index, c) in line.char_indices() {
+        if whitespace.is_some_and(|whitespace| whitespace != c.is_whitespace()) {
+            tokens.push(&line[start..index]);
+            start = index;
+        }
+        whitespace = Some(c.is_whitespace());
+    }
+    if start < line.len() {
+        tokens.push(&line[start..]);
+    }
+
+    tokens
+}
+
+/// Creates the unified diff of two outputs with [`CONTEXT`] lines of context, from `expected` to `actual`.
+fn unified(expected: &str, actual: &str) -> String {
+    let expected_lines: Vec<&str> = expected.split('\n').collect();
+    let actual_lines: Vec<&str> = actual.split('\n').collect();
+    let edits = edits(&expected_lines, &actual_lines);
+
+    let mut diff = String::from("--- expected\n+++ actual\n");
+    // the position of every edit in both outputs, counting from 1 like the hunk headers do
+    let mut positions = Vec::with_capacity(edits.len());
+    let (mut old, mut new) = (1, 1);
+    for edit in &edits {
+        positions.push((old, new));
+        match edit {
+            Edit::Equal(_) => (old, new) = (old + 1, new + 1),
+            Edit::Delete(_) => old += 1,
+            Edit::Insert(_) => new += 1,
+        }
+    }
+
+    let changed: Vec<usize> = (0..edits.len())
+        .filter(|&index| !matches!(edits[index], Edit::Equal(_)))
+        .collect();
+    let mut index = 0;
+    while index < changed.len() {
+        // a hunk extends as long as the next change is within the context of the previous one
+        let start = changed[index].saturating_sub(CONTEXT);
+        let mut end = changed[index];
+        while index < changed.len() && changed[index] <= end + 2 * CONTEXT {
+            end = changed[index];
+            index += 1;
+        }
+        let end = (end + CONTEXT + 1).min(edits.len());
+
+        let hunk = &edits[start..end];
+        let old_len = hunk
+            .iter()
+            .filter(|edit| !matches!(edit, Edit::Insert(_)))
+            .count();
+        let new_len = hunk
+            .iter()
+            .filter(|edit| !matches!(edit, Edit::Delete(_)))
+            .count();
+        let (old_start, new_start) = positions[start];
+        let _ = writeln!(
+            diff,
+            "@@ -{} +{} @@",
+            range(old_start, old_len),
+            range(new_start, new_len)
+        );
+        for edit in hunk {
+            let _ = match edit {
+                Edit::Equal(line) => writeln!(diff, " {line}"),
+                Edit::Delete(line) => writeln!(diff, "-{}", visible(line)),
+                Edit::Insert(line) => writeln!(diff, "+{}", visible(line)),
+            };
+        }
+    }
+
+    diff.truncate(diff.trim_end_matches('\n').len());
+    diff
+}
+
+/// Formats the range of a hunk header, which starts before the hunk if it is empty, like `diff -u` does.
+fn range(start: usize, len: usize) -> String {
+    match len {
+        0 => format!("{},0", start - 1),
+        1 => start.to_string(),
+        len => format!("{start},{len}"),
+    }
+}
+
+/// Shows the trailing whitespace of a changed line.
+fn visible(line: &str) -> String {
+    let content = line.trim_end_matches([' ', '\t']);
+    let mut visible = content.to_string();
+    for c in line[content.len()..].chars() {
+        visible.push(if c == '\t' { '→' } else { '·' });
+    }
+
+    visible
+}
+
+/// Finds the shortest edits from `expected` to `actual` by their longest common subsequence, removing before adding.
+fn edits<'a>(expected: &[&'a str], actual: &[&'a str]) -> Vec<Edit<'a>> {
+    let prefix = expected
+        .iter()
+        .zip(actual)
+        .take_while(|(expected, actual)| expected == actual)
+        .count();
+    let suffix = expected[prefix..]
+        .iter()
+        .rev()
+        .zip(actual[prefix..].iter().rev())
+        .take_while(|(expected, actual)| expected == actual)
+        .count();
+    let old = &expected[prefix..expected.len() - suffix];
+    let new = &actual[prefix..actual.len() - suffix];
+
+    let mut edits: Vec<Edit> = expected[..prefix]
+        .iter()
+        .map(|&line| Edit::Equal(line))
+        .collect();
+    if (old.len() + 1).saturating_mul(new.len() + 1) > MAX_CELLS {
+        edits.extend(old.iter().map(|&line| Edit::Delete(line)));
+        edits.extend(new.iter().map(|&line| Edit::Insert(line)));
+    } else {
+        // the length of the longest common subsequence of the suffixes starting at every pair of positions
+        let width = new.len() + 1;
+        let mut lengths = vec![0u32; (old.len() + 1) * width];
+        for i in (0..old.len()).rev() {
+            for j in (0..new.len()).rev() {
+                lengths[i * width + j] = match old[i] == new[j] {
+                    true => lengths[(i + 1) * width + j + 1] + 1,
+                    false => lengths[(i + 1) * width + j].max(lengths[i * width + j + 1]),
+                };
+            }
+        }
+
+        let (mut i, mut j) = (0, 0);
+        while i < old.len() || j < new.len() {
+            if i < old.len() && j < new.len() && old[i] == new[j] {
+                edits.push(Edit::Equal(old[i]));
+                (i, j) = (i + 1, j + 1);
+            } else if j == new.len()
+                || (i < old.len() && lengths[(i + 1) * width + j] >= lengths[i * width + j + 1])
+            {
+                edits.push(Edit::Delete(old[i]));
+                i += 1;
+            } else {
+                edits.push(Edit::Insert(new[j]));
+                j += 1;
+            }
+        }
+    }
+    edits.extend(
+        expected[expected.len() - suffix..]
+            .iter()
+            .map(|&line| Edit::Equal(line)),
+    );
+
+    edits
+}
+
+/// Keeps at most `limit` bytes of a diff, cut off on a character boundary, marking how many bytes were left out like
+/// the standard error of a test case.
+fn truncate(mut diff: String, limit: usize) -> String {
+    if diff.len() <= limit {
+        return diff;
+    }
+
+    let mut end = limit;
+    while !diff.is_char_boundary(end) {
+        end -= 1;
+    }
+    let truncated = diff.len() - end;
+    diff.truncate(end);
+    let _ = write!(diff, "\n[{truncated} more bytes were truncated]");
+
+    diff
+}
+
+#[cfg(test)]
+mod changes {
+    use super::{diff, line_counts};
+
+    #[test]
+    fn word_diff() {
+        assert_eq!(diff("1 2 3", "1 4 3", 1024), "1 [-2-]{+4+} 3");
+        assert_eq!(
+            diff("\"hello world\"", "\"hello  there world\"", 1024),
+            "hello{+  there+} world"
+        );
+    }
+
+    #[test]
+    fn trailing_whitespace() {
+        assert_eq!(diff("5", "5 ", 1024), "5{+ +}");
+        assert_eq!(
+            diff("\"a\\nb\"", "\"a \\nb\"", 1024),
+            "--- expected\n+++ actual\n@@ -1,2 +1,2 @@\n-a\n+a·\n b"
+        );
+    }
+
+    #[test]
+    fn unified_diff() {
+        let expected = "\"1\\n2\\n3\\n4\\n5\\n6\\n7\\n8\\n9\\n10\"";
+        let actual = "\"1\\n2\\n3\\n4\\n5\\n6\\n7\\n8\\nnine\\n10\\n11\"";
+
+        assert_eq!(
+            diff(expected, actual, 1024),
+            "--- expected\n+++ actual\n@@ -6,5 +6,6 @@\n 6\n 7\n 8\n-9\n+nine\n 10\n+11"
+        );
+    }
+
+    #[test]
+    fn separate_hunks() {
+        let expected = "\"a\\n1\\n2\\n3\\n4\\n5\\n6\\n7\\nb\"";
+        let actual = "\"A\\n1\\n2\\n3\\n4\\n5\\n6\\n7\\nB\"";
+
+        assert_eq!(
+            diff(expected, actual, 1024),
+            "--- expected\n+++ actual\n@@ -1,4 +1,4 @@\n-a\n+A\n 1\n 2\n 3\n@@ -6,4 +6,4 @@\n 5\n 6\n 7\n-b\n+B"
+        );
+    }
+
+    #[test]
+    fn counts_lines() {
+        assert_eq!(line_counts("a\nb\nc\n", "a\nB\nc\nd\n"), (2, 1));
+        assert_eq!(line_counts("", "a\nb"), (2, 0));
+        assert_eq!(line_counts("same", "same"), (0, 0));
+    }
+
+    #[test]
+    fn truncated() {
+        let actual = diff(&"x".repeat(100), &"y".repeat(100), 64);
+
+        assert_eq!(
+            actual,
+            format!("[-{}\n[144 more bytes were truncated]", "x".repeat(62))
+        );
+    }
+}
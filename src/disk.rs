@@ -0,0 +1,235 @@
+use crate::{cache::CompileCache, config::Config, janitor, metrics::header, pool::WorkerPool};
+use std::{
+    ffi::CString,
+    fmt::Write,
+    io,
+    os::unix::ffi::OsStrExt,
+    path::{Path, PathBuf},
+    sync::{
+        atomic::{AtomicU64, Ordering},
+        Arc,
+    },
+    time::Duration,
+};
+use tracing::{info, warn};
+
+/// How often the free space of the volumes is measured.
+const INTERVAL: Duration = Duration::from_secs(10);
+
+/// The number of bytes in a mebibyte.
+const MEBIBYTE: u64 = 1024 * 1024;
+
+/// Watches the free space of the volumes mozart writes to while judging, i.e. the workspaces and the job store, so
+/// submissions are rejected while one of them is almost full, rather than failing halfway through their judgment.
+pub struct DiskMonitor {
+    volumes: Vec<Volume>,
+    /// The pool which admits no jobs while a volume is low on space.
+    pool: WorkerPool,
+}
+
+/// A directory whose volume must keep some space free.
+struct Volume {
+    name: &'static str,
+    dir: PathBuf,
+    /// The free space in bytes below which the volume is low, where zero is never.
+    min_free: u64,
+    /// The free space in bytes as last measured.
+    free: AtomicU64,
+}
+
+impl DiskMonitor {
+    /// Creates a monitor of the workspace directory, and of the store directory if jobs are persisted, which stops the
+    /// pool from admitting jobs while one of them is low on space.
+    ///
+    /// Every volume is considered to have enough space until it is measured.
+    pub fn new(config: &Config, pool: WorkerPool) -> Self {
+        let mut volumes = vec![Volume::new(
+            "workspaces",
+            config.workspace_dir(),
+            config.workspace_min_free,
+        )];
+        if let Some(store_dir) = &config.store_dir {
+            volumes.push(Volume::new(
+                "store",
+                store_dir.clone(),
+                config.store_min_free,
+            ));
+        }
+
+        Self { volumes, pool }
+    }
+
+    /// Measures the free space of every volume, failing with why if one of them is low on space.
+    ///
+    /// A volume whose free space cannot be measured is not considered low, as it is rather missing, which the other
+    /// checks of the readiness tell.
+    pub fn check(&self) -> Result<(), String> {
+        let low: Vec<String> = self.volumes.iter().filter_map(Volume::measure).collect();
+        self.pool.set_low_disk_space(!low.is_empty());
+
+        match low.is_empty() {
+            true => Ok(()),
+            false => Err(low.join(", ")),
+        }
+    }
+
+    /// Renders the free space of every volume, and whether submissions are rejected for lack of it, in the Prometheus
+    /// text format.
+    pub fn render(&self, out: &mut String) {
+        header(
+            out,
+            "mozart_disk_free_bytes",
+            "The free space of the volumes mozart writes to, as last measured.",
+            "gauge",
+        );
+        // a volume which was never measured has no free space to tell
+        for volume in self
+            .volumes
+            .iter()
+            .filter(|volume| volume.free.load(Ordering::Relaxed) != u64::MAX)
+        {
+            let _ = writeln!(
+                out,
+                "mozart_disk_free_bytes{{volume=\"{}\"}} {}",
+                volume.name,
+                volume.free.load(Ordering::Relaxed)
+            );
+        }
+
+        header(
+            out,
+            "mozart_disk_low",
+            "Whether submissions are rejected, as a volume has less free space than its minimum.",
+            "gauge",
+        );
+        let _ = writeln!(
+            out,
+            "mozart_disk_low {}",
+            u8::from(self.pool.is_low_on_disk_space())
+        );
+    }
+}
+
+impl Volume {
+    fn new(name: &'static str, dir: PathBuf, min_free: u64) -> Self {
+        Self {
+            name,
+            dir,
+            min_free: min_free.saturating_mul(MEBIBYTE),
+            free: AtomicU64::new(u64::MAX),
+        }
+    }
+
+    /// Measures the free space of the volume, returning why it is low if it is.
+    fn measure(&self) -> Option<String> {
+        let free = free_space(&self.dir).ok()?;
+        self.free.store(free, Ordering::Relaxed);
+
+        (free < self.min_free).then(|| {
+            format!(
+                "only {} MiB are free for the {} in {}, below the minimum of {} MiB",
+                free / MEBIBYTE,
+                self.name,
+                self.dir.display(),
+                self.min_free / MEBIBYTE
+            )
+        })
+    }
+}
+
+/// Gets the space in bytes which unprivileged processes may still write to the volume of the directory, or of its
+/// closest ancestor which exists, as the directory itself is created lazily.
+fn free_space(dir: &Path) -> io::Result<u64> {
+    let dir = dir
+        .ancestors()
+        .find(|dir| dir.exists())
+        .unwrap_or(Path::new("/"));
+    let path = CString::new(dir.as_os_str().as_bytes())?;
+    // SAFETY: statvfs is a plain C struct, for which all zeroes is a valid value.
+    let mut stat = unsafe { std::mem::zeroed::<libc::statvfs>() };
+
+    // SAFETY: the path is a valid nul terminated string, and the struct outlives the call.
+    if unsafe { libc::statvfs(path.as_ptr(), &mut stat) } != 0 {
+        return Err(io::Error::last_os_error());
+    }
+
+    #[allow(clippy::unnecessary_cast)]
+    Ok((stat.f_bavail as u64).saturating_mul(stat.f_frsize as u64))
+}
+
+/// Measures the free space of the volumes periodically in the background.
+///
+/// Once a volume runs low, everything mozart can remove without losing a result is removed right away, i.e. the
+/// compile cache and the workspaces which are only retained for debugging, rather than waiting for the janitor.
+pub fn start(monitor: Arc<DiskMonitor>, cache: Arc<CompileCache>, config: Arc<Config>) {
+    tokio::spawn(async move {
+        let mut interval = tokio::time::interval(INTERVAL);
+        let mut was_low = false;
+        loop {
+            interval.tick().await;
+            let (monitor, cache, config) = (monitor.clone(), cache.clone(), config.clone());
+            let checked = tokio::task::spawn_blocking(move || {
+                let checked = monitor.check();
+                if checked.is_err() && !was_low {
+                    cache.clear();
+                    let removed = janitor::reclaim(&config);
+                    info!(
+                        removed,
+                        "cleared the compile cache and removed retained workspaces"
+                    );
+                }
+                checked
+            })
+            .await;
+
+            match checked {
+                Ok(Err(reason)) if !was_low => {
+                    warn!(%reason, "rejecting submissions until more disk space is free");
+                    was_low = true;
+                }
+                Ok(Ok(())) if was_low => {
+                    info!("enough disk space is free again, accepting submissions");
+                    was_low = false;
+                }
+                _ => {}
+            }
+        }
+    });
+}
+
+#[cfg(test)]
+mod monitoring {
+    use super::DiskMonitor;
+    use crate::{config::Config, pool::WorkerPool};
+    use std::{env, path::PathBuf};
+
+    fn monitor(workspace_min_free: u64) -> (DiskMonitor, WorkerPool) {
+        let pool = WorkerPool::new(1, 0);
+        let config = Config {
+            workspace_dir: Some(env::temp_dir().join("mozart-disk").join("workspaces")),
+            workspace_min_free,
+            store_dir: Some(PathBuf::from("/nonexistent/mozart/jobs")),
+            ..Config::default()
+        };
+
+        (DiskMonitor::new(&config, pool.clone()), pool)
+    }
+
+    #[test]
+    fn low_below_minimum() {
+        let (enough, enough_pool) = monitor(0);
+        let (low, low_pool) = monitor(u64::MAX / 1024 / 1024);
+
+        let enough_checked = enough.check();
+        let low_checked = low.check();
+        let mut rendered = String::new();
+        low.render(&mut rendered);
+
+        assert!(enough_checked.is_ok());
+        assert!(enough_pool.admit().is_ok());
+        assert!(low_checked.is_err_and(|reason| reason.contains("for the workspaces")));
+        assert!(low_pool.admit().is_err());
+        assert!(rendered.contains("mozart_disk_free_bytes{volume=\"store\"}"));
+        assert!(rendered.contains("mozart_disk_low 1"));
+    }
+}
@@ -1,3 +1,5 @@
+use crate::model::Language;
+use std::path::PathBuf;
 use thiserror::Error;
 
 pub const UUID_SHOULD_BE_VALID_STR: &str = "a uuid should always be valid utf8 encoding";
@@ -11,4 +13,549 @@ pub enum CheckError {
 
     #[error("an error occured during compilation: {0}")]
     Compilation(String),
+
+    /// The sandbox failed to execute a command, e.g. because a container could not be started.
+    #[error("an error occured in the sandbox")]
+    Sandbox,
+
+    /// A test case cannot be expressed in the language of the submission.
+    #[error("the test case is not supported: {0}")]
+    UnsupportedTestCase(String),
+
+    /// The checker or the interactor of the submission failed to compile, or failed to judge a test case.
+    #[error("{0}")]
+    Checker(String),
+
+    /// The setup script of the submission failed, so its test cases were not run.
+    #[error("{0}")]
+    Hook(String),
+
+    /// A test case refers to a fixture which has not been uploaded.
+    #[error("the fixture {0} does not exist")]
+    MissingFixture(String),
+
+    /// The submission refers to a blob which has not been uploaded, or which has been collected since.
+    #[error("the blob {0} does not exist")]
+    MissingBlob(String),
+
+    /// A verdict plugin failed to adjust the result, which is a fault of the server rather than of the submission.
+    #[error("{0}")]
+    Plugin(String),
+
+    /// The judgment was cancelled, so whatever was executing was killed.
+    #[error("the judgment was cancelled")]
+    Cancelled,
+}
+
+/// An error that occurs when a submission is structurally invalid, and therefore cannot be checked.
+#[derive(Debug, Error)]
+pub enum SubmissionError {
+    #[error("the language {0} is not supported")]
+    UnsupportedLanguage(Language),
+
+    #[error("the solution is empty")]
+    EmptySolution,
+
+    #[error("the submission contains no test cases")]
+    NoTestCases,
+
+    #[error("the submission contains more than {0} test cases")]
+    TooManyTestCases(usize),
+
+    /// The solution along with its other files exceeds the configured size limit.
+    #[error("the solution is larger than {0} bytes")]
+    SolutionTooLarge(usize),
+
+    #[error("the {0} script is empty")]
+    EmptyHook(&'static str),
+
+    /// The source of the checker, the interactor, or a script exceeds the size limit of solutions.
+    #[error("the {0} is larger than {1} bytes")]
+    SourceTooLarge(&'static str, usize),
+
+    #[error("the test case id {0} is used by more than one test case")]
+    DuplicateTestCaseId(u64),
+
+    #[error("the test case {0} has no output parameters")]
+    NoOutputParameters(u64),
+
+    #[error("the test case {0} has a weight of zero")]
+    ZeroWeight(u64),
+
+    #[error("the test case {0} has a weight above {1}")]
+    WeightTooHigh(u64, u32),
+
+    #[error("the test case {0} has an epsilon which is negative or not finite")]
+    InvalidEpsilon(u64),
+
+    #[error("the callback url {0} is not a http:// or https:// url")]
+    InvalidCallbackUrl(String),
+
+    #[error("the callback url {0} is not on a host callbacks may be sent to")]
+    CallbackNotAllowed(String),
+
+    #[error("the group {0} is declared more than once")]
+    DuplicateGroup(String),
+
+    #[error("the group {0} has a weight of zero")]
+    ZeroGroupWeight(String),
+
+    #[error("the group {0} has a weight above {1}")]
+    GroupWeightTooHigh(String, u32),
+
+    #[error("the group {0} contains no test cases")]
+    EmptyGroup(String),
+
+    #[error("the test case {0} belongs to the group {1}, which is not declared")]
+    UnknownGroup(u64, String),
+
+    #[error("solutions in {0} cannot be interactive")]
+    NotInteractive(Language),
+
+    #[error("the test case {0} refers to the fixture {1}, which is not a valid fixture id")]
+    InvalidFixtureId(u64, String),
+
+    /// The standard input of an interactive solution is the output of its interactor.
+    #[error("the test case {0} has a stdin fixture, but the submission is interactive")]
+    InteractiveStdin(u64),
+
+    /// The path is absolute, leaves the workspace, or is reserved by mozart.
+    #[error("the file {0} has a path which is not allowed")]
+    InvalidFilePath(String),
+
+    #[error("the contents of the file {0} are not valid base64")]
+    InvalidFileContents(String),
+
+    /// The name is not on the allowlist of the server, or is not a valid name, or the value contains a nul byte.
+    #[error("the test case {0} sets the environment variable {1}, which is not allowed")]
+    EnvNotAllowed(u64, String),
+
+    /// The endpoint is not a `host:port` pair, or its port is already used by another endpoint.
+    #[error("the endpoint {0} is not a host:port pair with a port of its own")]
+    InvalidEndpoint(String),
+
+    #[error("the network policy allows the endpoint {0}, which is not allowed")]
+    EndpointNotAllowed(String),
+
+    #[error("the judging profile {0} is not defined")]
+    UnknownProfile(String),
+
+    /// The versions which are available are listed, so the client can ask for one of them instead.
+    #[error("{0} has no toolchain version {1}, the available versions are: {}", listed(.2))]
+    UnknownVersion(Language, String, Vec<String>),
+
+    #[error("the submission stops after zero failures")]
+    ZeroMaxFailures,
+
+    #[error("the image digest {0} is not a sha256:... digest")]
+    InvalidImageDigest(String),
+
+    #[error("the benchmark runs every test case between 1 and {0} times, including its warmup")]
+    InvalidBenchmarkRuns(usize),
+
+    #[error("the benchmark refers to the test case {0}, which does not exist")]
+    UnknownBenchmarkTestCase(u64),
+
+    /// Submissions are only compared with the other submissions to their exercise.
+    #[error("the submission is fingerprinted, but belongs to no exercise")]
+    FingerprintWithoutExercise,
+
+    /// Only the conversation of a solution with its interactor is recorded.
+    #[error("the submission records a transcript, but has no interactor")]
+    TranscriptWithoutInteractor,
+
+    #[error("solutions in {0} cannot be judged by a test suite")]
+    NoTestFramework(Language),
+
+    #[error("the submission runs a test suite, but has no test files")]
+    NoTestFiles,
+
+    #[error("the test case {0} of the test suite is not named by a test")]
+    InvalidTestName(u64),
+
+    #[error("the {0} of {1} is above the maximum of {2}")]
+    LimitTooHigh(&'static str, u64, u64),
+
+    #[error("the blob at {0} is not referred to by the hex encoded SHA-256 of its contents")]
+    InvalidBlobHash(String),
+
+    /// A blob is laid out like a file of the solution, so the two cannot have the same path.
+    #[error("the blob at {0} has the path of a file of the solution")]
+    BlobReplacesFile(String),
+}
+
+impl SubmissionError {
+    /// Gets the machine readable code of the error, which clients can map to their own messages.
+    pub fn code(&self) -> &'static str {
+        match self {
+            SubmissionError::UnsupportedLanguage(_) => "unsupportedLanguage",
+            SubmissionError::EmptySolution => "emptySolution",
+            SubmissionError::NoTestCases => "noTestCases",
+            SubmissionError::TooManyTestCases(_) => "tooManyTestCases",
+            SubmissionError::SolutionTooLarge(_) => "solutionTooLarge",
+            SubmissionError::EmptyHook(_) => "emptyHook",
+            SubmissionError::SourceTooLarge(_, _) => "sourceTooLarge",
+            SubmissionError::DuplicateTestCaseId(_) => "duplicateTestCaseId",
+            SubmissionError::NoOutputParameters(_) => "noOutputParameters",
+            SubmissionError::ZeroWeight(_) => "zeroWeight",
+            SubmissionError::WeightTooHigh(_, _) => "weightTooHigh",
+            SubmissionError::InvalidEpsilon(_) => "invalidEpsilon",
+            SubmissionError::InvalidCallbackUrl(_) => "invalidCallbackUrl",
+            SubmissionError::CallbackNotAllowed(_) => "callbackNotAllowed",
+            SubmissionError::DuplicateGroup(_) => "duplicateGroup",
+            SubmissionError::ZeroGroupWeight(_) => "zeroGroupWeight",
+            SubmissionError::GroupWeightTooHigh(_, _) => "groupWeightTooHigh",
+            SubmissionError::EmptyGroup(_) => "emptyGroup",
+            SubmissionError::UnknownGroup(_, _) => "unknownGroup",
+            SubmissionError::NotInteractive(_) => "notInteractive",
+            SubmissionError::InvalidFixtureId(_, _) => "invalidFixtureId",
+            SubmissionError::InteractiveStdin(_) => "interactiveStdin",
+            SubmissionError::InvalidFilePath(_) => "invalidFilePath",
+            SubmissionError::InvalidFileContents(_) => "invalidFileContents",
+            SubmissionError::EnvNotAllowed(_, _) => "envNotAllowed",
+            SubmissionError::InvalidEndpoint(_) => "invalidEndpoint",
+            SubmissionError::EndpointNotAllowed(_) => "endpointNotAllowed",
+            SubmissionError::UnknownProfile(_) => "unknownProfile",
+            SubmissionError::UnknownVersion(_, _, _) => "unknownVersion",
+            SubmissionError::ZeroMaxFailures => "zeroMaxFailures",
+            SubmissionError::InvalidImageDigest(_) => "invalidImageDigest",
+            SubmissionError::InvalidBenchmarkRuns(_) => "invalidBenchmarkRuns",
+            SubmissionError::UnknownBenchmarkTestCase(_) => "unknownBenchmarkTestCase",
+            SubmissionError::FingerprintWithoutExercise => "fingerprintWithoutExercise",
+            SubmissionError::TranscriptWithoutInteractor => "transcriptWithoutInteractor",
+            SubmissionError::NoTestFramework(_) => "noTestFramework",
+            SubmissionError::NoTestFiles => "noTestFiles",
+            SubmissionError::InvalidTestName(_) => "invalidTestName",
+            SubmissionError::LimitTooHigh(_, _, _) => "limitTooHigh",
+            SubmissionError::InvalidBlobHash(_) => "invalidBlobHash",
+            SubmissionError::BlobReplacesFile(_) => "blobReplacesFile",
+        }
+    }
+}
+
+/// Lists the versions of a toolchain for an error, which tells if there are none.
+fn listed(versions: &[String]) -> String {
+    match versions {
+        [] => String::from("none"),
+        versions => versions.join(", "),
+    }
+}
+
+/// An error that occurs when test cases cannot be generated from a reference solution.
+#[derive(Debug, Error)]
+pub enum GenerateError {
+    #[error("the request contains no inputs")]
+    NoInputs,
+
+    #[error("the output type {0} is not supported for generating test cases")]
+    UnsupportedOutputType(String),
+
+    #[error("the reference solution {1} on input {0}")]
+    Failed(usize, &'static str),
+}
+
+impl GenerateError {
+    /// Gets the machine readable code of the error, which clients can map to their own messages.
+    pub fn code(&self) -> &'static str {
+        match self {
+            GenerateError::NoInputs => "noInputs",
+            GenerateError::UnsupportedOutputType(_) => "unsupportedOutputType",
+            GenerateError::Failed(_, _) => "referenceFailed",
+        }
+    }
+}
+
+/// An error that occurs when a job cannot be rejudged.
+#[derive(Debug, Error)]
+pub enum RejudgeError {
+    #[error("the job does not exist")]
+    NotFound,
+
+    /// The job is still queued or running, so it has no result to replace yet.
+    #[error("the job is not done yet")]
+    NotDone,
+
+    /// The replacing test cases do not fit into the submission.
+    #[error("the rejudged submission is malformed: {0}")]
+    Malformed(String),
+
+    #[error("the rejudged submission is invalid: {0}")]
+    Invalid(SubmissionError),
+}
+
+/// An error that occurs when a job cannot be cancelled.
+#[derive(Debug, Error)]
+pub enum CancelError {
+    #[error("the job does not exist")]
+    NotFound,
+
+    /// The job already has a result, which a cancellation would not change.
+    #[error("the job is already done")]
+    Done,
+
+    /// The job is queued on the cluster, or running on another replica, so only the cluster can cancel it.
+    #[error("the job is not on this replica")]
+    Elsewhere,
+}
+
+/// An error that occurs when a job cannot be persisted or loaded.
+#[derive(Debug, Error)]
+pub enum StoreError {
+    #[error("an error occured while accessing the store: {0}")]
+    Io(String),
+
+    /// A record cannot be converted to or from its persisted form.
+    #[error("a record of the store is corrupt: {0}")]
+    Corrupt(String),
+
+    /// The database of the store rejected a statement, or the connection to it.
+    #[error("the database of the store failed: {0}")]
+    Database(String),
+
+    /// The archive of the store failed to store or fetch a record.
+    #[error("an error occured while accessing the archive: {0}")]
+    Archive(String),
+
+    #[error(transparent)]
+    Cluster(#[from] ClusterError),
+}
+
+/// An error that occurs when a fixture cannot be stored or removed.
+#[derive(Debug, Error)]
+pub enum FixtureError {
+    #[error("the fixture id {0} is not valid")]
+    InvalidId(String),
+
+    #[error("the fixture does not exist")]
+    NotFound,
+
+    #[error("the fixture is larger than the fixture size limit")]
+    TooLarge,
+
+    #[error("the fixture would exceed the storage quota of the tenant")]
+    QuotaExceeded,
+
+    #[error("an error occured while accessing the fixtures: {0}")]
+    Io(String),
+}
+
+/// An error that occurs when a blob cannot be stored.
+#[derive(Debug, Error)]
+pub enum BlobError {
+    #[error("the blob is larger than the fixture size limit")]
+    TooLarge,
+
+    #[error("the blob would exceed the storage quota of the tenant")]
+    QuotaExceeded,
+
+    #[error("an error occured while accessing the blobs: {0}")]
+    Io(String),
+}
+
+/// An error that occurs when the audit log cannot be appended to, or queried.
+#[derive(Debug, Error)]
+pub enum AuditError {
+    #[error("no audit file is configured")]
+    Disabled,
+
+    #[error("an error occured while accessing the audit log: {0}")]
+    Io(String),
+}
+
+/// An error that occurs when an exercise cannot be registered, or submitted to.
+#[derive(Debug, Error)]
+pub enum ExerciseError {
+    #[error("the exercise id {0} is not valid")]
+    InvalidId(String),
+
+    #[error("the exercise does not exist")]
+    NotFound,
+
+    /// The exercise does not have the fields of a submission.
+    #[error("the exercise is not a valid submission: {0}")]
+    Malformed(String),
+
+    #[error("the exercise is not valid: {0}")]
+    Invalid(#[from] SubmissionError),
+
+    /// The exercise to validate has no solution to validate it with.
+    #[error("the exercise has no reference solution to validate it with")]
+    NoReferenceSolution,
+
+    #[error("the exercise would exceed the storage quota of the tenant")]
+    QuotaExceeded,
+
+    #[error("an error occured while accessing the exercises: {0}")]
+    Io(String),
+}
+
+/// An error that occurs when the fingerprints of submissions to an exercise cannot be stored, or compared.
+#[derive(Debug, Error)]
+pub enum SimilarityError {
+    #[error("the exercise id {0} is not valid")]
+    InvalidExerciseId(String),
+
+    /// The submission does not belong to the exercise, or the exercise does not fingerprint its submissions.
+    #[error("the submission has no fingerprints")]
+    NotFingerprinted,
+
+    /// Similar submissions are only shown to trusted callers, as they tell which submissions were copied from which.
+    #[error("the caller is not trusted")]
+    Untrusted,
+
+    #[error("an error occured while accessing the fingerprints: {0}")]
+    Io(String),
+}
+
+/// An error that occurs when listing jobs.
+#[derive(Debug, Error, PartialEq)]
+pub enum ListingError {
+    #[error("the page {0} is not the cursor of a page which was listed before")]
+    InvalidCursor(String),
+}
+
+/// An error that occurs when a protobuf message of the gRPC interface cannot be decoded.
+#[derive(Debug, Error, PartialEq)]
+pub enum DecodeError {
+    #[error("the message ends in the middle of a field")]
+    Truncated,
+
+    /// The field has a wire type which does not match its type, or which does not exist.
+    #[error("the field {0} has the unexpected wire type {1}")]
+    WireType(u32, u8),
+
+    #[error("the field {0} is not valid UTF-8")]
+    Utf8(u32),
+}
+
+/// An error that occurs while consuming submissions from a message broker.
+#[derive(Debug, Error)]
+pub enum QueueError {
+    #[error("the broker url {0} is not a valid amqp:// url")]
+    InvalidUrl(String),
+
+    #[error("an error occured on the connection to the broker: {0}")]
+    Io(String),
+
+    /// The broker sent something which is not valid in the protocol, or not expected at that point.
+    #[error("the broker violated the protocol: {0}")]
+    Protocol(String),
+
+    #[error("the broker closed the connection with {0}: {1}")]
+    Closed(u16, String),
+}
+
+/// An error that occurs while talking to the Redis server shared by the replicas of a cluster.
+#[derive(Debug, Error)]
+pub enum ClusterError {
+    #[error("the cluster url {0} is not a valid redis:// url")]
+    InvalidUrl(String),
+
+    #[error("an error occured on the connection to redis: {0}")]
+    Io(String),
+
+    /// Redis sent something which is not valid in the protocol, or not the reply the command has.
+    #[error("redis violated the protocol: {0}")]
+    Protocol(String),
+
+    #[error("redis rejected a command: {0}")]
+    Command(String),
+}
+
+/// An error that occurs while publishing the events of jobs to an event bus.
+#[derive(Debug, Error)]
+pub enum EventError {
+    #[error("the events url {0} is not log, or a valid redis:// or nats:// url")]
+    InvalidUrl(String),
+
+    #[error("an error occured on the connection to the event bus: {0}")]
+    Io(String),
+
+    /// The event bus sent something which is not valid in the protocol, or an error.
+    #[error("the event bus violated the protocol: {0}")]
+    Protocol(String),
+
+    #[error(transparent)]
+    Redis(#[from] ClusterError),
+}
+
+/// An error that occurs when the certificate of the TLS servers cannot be loaded.
+#[derive(Debug, Error)]
+pub enum TlsError {
+    #[error("failed to read {0}: {1}")]
+    Read(PathBuf, String),
+
+    #[error("{0} contains no certificate")]
+    NoCertificate(PathBuf),
+
+    #[error("{0} contains no private key")]
+    NoKey(PathBuf),
+
+    /// The certificate does not match its key, or a certificate authority of the clients is not valid.
+    #[error("the certificates are not valid: {0}")]
+    Invalid(String),
+}
+
+/// An error that occurs when a key results are signed with cannot be loaded.
+#[derive(Debug, Error)]
+pub enum SigningError {
+    #[error("failed to read {0}: {1}")]
+    Read(PathBuf, String),
+
+    #[error("{0} contains no Ed25519 key")]
+    NotEd25519(PathBuf),
+}
+
+/// An error that occurs when a verdict plugin cannot be loaded.
+#[derive(Debug, Error)]
+pub enum PluginError {
+    #[error("failed to read the verdict plugin {0}: {1}")]
+    Read(PathBuf, String),
+
+    #[error("the verdict plugin {0} is not an executable file")]
+    NotExecutable(PathBuf),
+}
+
+/// An error that occurs when the configuration cannot be loaded, or is invalid.
+#[derive(Debug, Error)]
+pub enum ConfigError {
+    #[error("failed to read the config file {0}: {1}")]
+    Read(PathBuf, String),
+
+    #[error("failed to parse the config file: {0}")]
+    Parse(String),
+
+    #[error("the setting {0} does not exist")]
+    UnknownSetting(String),
+
+    #[error("the value {value} is not valid for the setting {key}")]
+    InvalidValue { key: String, value: String },
+
+    #[error("the flag {0} is missing a value")]
+    MissingValue(String),
+
+    #[error("the argument {0} is not a flag")]
+    UnexpectedArgument(String),
+
+    #[error(
+        "the toolchain of {language} has the version {version}, but the version {pinned} is pinned"
+    )]
+    PinnedVersion {
+        language: Language,
+        version: String,
+        pinned: String,
+    },
+
+    /// The settings are inconsistent with each other, or out of range.
+    #[error("invalid configuration: {0}")]
+    Invalid(&'static str),
+}
+
+impl ConfigError {
+    pub fn invalid_value(key: &str, value: &str) -> Self {
+        Self::InvalidValue {
+            key: key.to_string(),
+            value: value.to_string(),
+        }
+    }
 }
@@ -0,0 +1,554 @@
+use crate::{
+    config::Config,
+    error::ExerciseError,
+    fixture,
+    model::{
+        is_test_file, FailureKind, Priority, Submission, SubmissionLimits, SubmissionResult,
+        TestCaseFailureReason, TestResult, Verdict,
+    },
+    problem::Problem,
+    response::{Quota, SubmitResponse},
+};
+use axum::{
+    http::StatusCode,
+    response::{IntoResponse, Response},
+};
+use serde::{Deserialize, Serialize};
+use serde_json::{Map, Value};
+use std::{collections::BTreeMap, fs, io::ErrorKind, path::PathBuf};
+use uuid::Uuid;
+
+/// The solution an exercise is validated with when it is registered, as it is only a submission without a solution.
+const PLACEHOLDER_SOLUTION: &str = "placeholder";
+
+/// How much of a limit the reference solution of an exercise may use in a test case, beyond which the limit is too
+/// tight for solutions which are correct but slower than it.
+const TIGHT: u64 = 2;
+
+/// What a student sends to submit a solution to a registered exercise.
+#[derive(Deserialize)]
+pub struct Attempt {
+    pub solution: String,
+    /// The other files of the solution, which cannot replace the files of the exercise.
+    #[serde(default)]
+    pub files: BTreeMap<String, String>,
+    #[serde(rename = "callbackUrl")]
+    pub callback_url: Option<String>,
+    #[serde(default)]
+    pub analyze: bool,
+    /// The judging profile of the attempt, which overrides the profile of the exercise.
+    pub profile: Option<String>,
+    /// The priority of the attempt, which overrides the priority of the exercise.
+    pub priority: Option<Priority>,
+    /// The job of the earlier attempt the result is compared with.
+    #[serde(rename = "previousTaskId")]
+    pub previous_task_id: Option<Uuid>,
+}
+
+/// The exercises which are registered ahead of the submissions to them, i.e. everything of a submission except the
+/// solution, so the test cases are not sent along with every solution.
+///
+/// Every exercise is kept as the JSON it was registered with, in a file named by its id.
+pub struct Exercises {
+    dir: PathBuf,
+}
+
+impl Exercises {
+    pub fn new(dir: PathBuf) -> Self {
+        Self { dir }
+    }
+
+    /// Stores an exercise once it is valid, replacing an earlier exercise with the same id, and returns whether it
+    /// did not exist yet.
+    pub fn put(
+        &self,
+        id: &str,
+        mut exercise: Map<String, Value>,
+        limits: &SubmissionLimits,
+    ) -> Result<bool, ExerciseError> {
+        let path = self.path(id)?;
+        // the id of the exercise is also the id submissions are rejudged by, so it cannot be given by the exercise
+        exercise.insert(String::from("exerciseId"), Value::from(id));
+        exercise.remove("solution");
+
+        let mut submission = exercise.clone();
+        submission.insert(String::from("solution"), Value::from(PLACEHOLDER_SOLUTION));
+        serde_json::from_value::<Submission>(Value::Object(submission))
+            .map_err(|err| ExerciseError::Malformed(err.to_string()))?
+            .validate(limits)?;
+
+        let created = !path.exists();
+        let contents =
+            serde_json::to_vec(&exercise).map_err(|err| ExerciseError::Io(err.to_string()))?;
+        // like fixtures, the exercise is written to a temporary file first, so a submission never reads a partial one
+        let temporary = self.dir.join(format!(".{id}.tmp"));
+        fs::create_dir_all(&self.dir)
+            .and_then(|_| fs::write(&temporary, contents))
+            .and_then(|_| fs::rename(&temporary, &path))
+            .map_err(|err| ExerciseError::Io(err.to_string()))?;
+
+        Ok(created)
+    }
+
+    pub fn delete(&self, id: &str) -> Result<(), ExerciseError> {
+        match fs::remove_file(self.path(id)?) {
+            Ok(()) => Ok(()),
+            Err(err) if err.kind() == ErrorKind::NotFound => Err(ExerciseError::NotFound),
+            Err(err) => Err(ExerciseError::Io(err.to_string())),
+        }
+    }
+
+    /// Creates the submission of an attempt at an exercise, which belongs to the exercise so it can be rejudged along
+    /// with the rest of it.
+    pub fn submission(&self, id: &str, attempt: Attempt) -> Result<Submission, ExerciseError> {
+        let contents = match fs::read(self.path(id)?) {
+            Ok(contents) => contents,
+            Err(err) if err.kind() == ErrorKind::NotFound => return Err(ExerciseError::NotFound),
+            Err(err) => return Err(ExerciseError::Io(err.to_string())),
+        };
+        let mut exercise: Map<String, Value> = serde_json::from_slice(&contents)
+            .map_err(|err| ExerciseError::Io(format!("corrupt exercise: {err}")))?;
+        exercise.insert(String::from("solution"), Value::from(attempt.solution));
+        let mut submission: Submission = serde_json::from_value(Value::Object(exercise))
+            .map_err(|err| ExerciseError::Io(format!("corrupt exercise: {err}")))?;
+
+        // the tests of a test suite are those of the exercise, so a solution cannot add tests of its own
+        let language = submission.language;
+        for (path, contents) in attempt
+            .files
+            .into_iter()
+            .filter(|(path, _)| !submission.test_suite || !is_test_file(language, path))
+        {
+            submission.files.entry(path).or_insert(contents);
+        }
+        submission.callback_url = attempt.callback_url.or(submission.callback_url);
+        submission.analyze |= attempt.analyze;
+        submission.profile = attempt.profile.or(submission.profile);
+        submission.priority = attempt.priority.or(submission.priority);
+        submission.previous_task_id = attempt.previous_task_id;
+
+        Ok(submission)
+    }
+
+    /// Reads every registered exercise, leaving out those which cannot be read.
+    pub fn all(&self) -> Vec<Value> {
+        let Ok(entries) = fs::read_dir(&self.dir) else {
+            return Vec::new();
+        };
+
+        entries
+            .flatten()
+            .filter(|entry| {
+                entry
+                    .path()
+                    .extension()
+                    .is_some_and(|extension| extension == "json")
+            })
+            .filter_map(|entry| fs::read(entry.path()).ok())
+            .filter_map(|contents| serde_json::from_slice(&contents).ok())
+            .collect()
+    }
+
+    fn path(&self, id: &str) -> Result<PathBuf, ExerciseError> {
+        match fixture::is_valid_id(id) {
+            true => Ok(self.dir.join(format!("{id}.json"))),
+            false => Err(ExerciseError::InvalidId(id.to_string())),
+        }
+    }
+}
+
+impl From<&Config> for Exercises {
+    fn from(config: &Config) -> Self {
+        Self::new(config.exercise_dir())
+    }
+}
+
+/// The limits the reference solution of an exercise is run with, by which its result shows which test cases are too
+/// tight.
+pub struct Reference {
+    /// The time limit of every test case in milliseconds, by its id.
+    time_limits: BTreeMap<u64, u64>,
+    /// The memory limit in mebibytes.
+    memory_limit: u64,
+}
+
+/// What validating an exercise with its reference solution found, which is not stored.
+#[derive(Serialize)]
+#[serde(rename_all = "camelCase")]
+pub struct Validation {
+    /// Whether the reference solution passed every test case well within its limits.
+    pub valid: bool,
+    verdict: Verdict,
+    #[serde(skip_serializing_if = "String::is_empty")]
+    compile_output: String,
+    /// The test cases which are malformed or too tight, in the order of the exercise.
+    test_cases: Vec<Finding>,
+}
+
+/// A test case of an exercise which its reference solution found to be wrong.
+#[derive(Serialize)]
+#[serde(rename_all = "camelCase")]
+struct Finding {
+    id: u64,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    name: Option<String>,
+    problem: TestCaseProblem,
+    /// Why the reference solution failed the test case, if it did.
+    #[serde(skip_serializing_if = "Option::is_none")]
+    reason: Option<FailureKind>,
+    detail: String,
+}
+
+#[derive(Serialize, PartialEq, Debug)]
+#[serde(rename_all = "camelCase")]
+enum TestCaseProblem {
+    /// The reference solution does not pass the test case, e.g. as its expected output is wrong.
+    Malformed,
+    /// The reference solution exceeds a limit of the test case, or comes close to it.
+    TooTight,
+}
+
+impl Reference {
+    /// Creates the submission of the reference solution of an exercise, which is the solution it is validated with.
+    ///
+    /// Every test case is run, even after one fails, and nothing of the judgment is kept, i.e. neither its artifacts
+    /// nor its fingerprints, and no callback is sent.
+    pub fn submission(
+        exercise: Map<String, Value>,
+        config: &Config,
+    ) -> Result<(Submission, Self), ExerciseError> {
+        if !exercise.get("solution").is_some_and(Value::is_string) {
+            return Err(ExerciseError::NoReferenceSolution);
+        }
+        let mut submission: Submission = serde_json::from_value(Value::Object(exercise))
+            .map_err(|err| ExerciseError::Malformed(err.to_string()))?;
+        submission.validate(&config.submission_limits())?;
+        submission.stop_on_first_fail = false;
+        submission.max_failures = None;
+        submission.profile = None;
+        submission.callback_url = None;
+        submission.exercise_id = None;
+        submission.export_artifact = false;
+        submission.record_transcript = false;
+        submission.fingerprint = false;
+
+        let reference = Self {
+            time_limits: submission
+                .test_cases
+                .iter()
+                .map(|test_case| {
+                    let time_limit = test_case.time_limit.or(submission.time_limit);
+                    (test_case.id, time_limit.unwrap_or(config.time_limit))
+                })
+                .collect(),
+            memory_limit: submission.memory_limit.unwrap_or(config.memory_limit),
+        };
+
+        Ok((submission, reference))
+    }
+
+    /// Finds the test cases of the exercise which the reference solution did not pass, or only passed using more than
+    /// half of a limit.
+    pub fn validate(&self, result: SubmissionResult) -> Validation {
+        let findings = result.test_case_results.iter().filter_map(|test_case_result| {
+            let time_limit = self.time_limits.get(&test_case_result.id).copied().unwrap_or_default();
+            let memory = test_case_result.memory.unwrap_or_default() / (1024 * 1024);
+            let (problem, reason, detail) = match &test_case_result.test_result {
+                TestResult::Pass if test_case_result.runtime * TIGHT > time_limit => (
+                    TestCaseProblem::TooTight,
+                    None,
+                    format!(
+                        "the reference solution ran for {} of the {time_limit} milliseconds of the time limit",
+                        test_case_result.runtime
+                    ),
+                ),
+                TestResult::Pass if memory * TIGHT > self.memory_limit => (
+                    TestCaseProblem::TooTight,
+                    None,
+                    format!(
+                        "the reference solution used {memory} of the {} mebibytes of the memory limit",
+                        self.memory_limit
+                    ),
+                ),
+                TestResult::Pass => return None,
+                TestResult::Unknown | TestResult::Skipped => (
+                    TestCaseProblem::Malformed,
+                    None,
+                    String::from("the reference solution was not run against the test case"),
+                ),
+                TestResult::Failure(reason) => {
+                    let kind = reason.kind();
+                    let detail = match reason {
+                        TestCaseFailureReason::WrongAnswer { actual, expected, .. } => format!(
+                            "the reference solution answered {actual}, while the test case expects {expected}"
+                        ),
+                        TestCaseFailureReason::TimeLimitExceeded => format!(
+                            "the reference solution exceeded the time limit of {time_limit} milliseconds"
+                        ),
+                        TestCaseFailureReason::MemoryLimitExceeded => format!(
+                            "the reference solution exceeded the memory limit of {} mebibytes",
+                            self.memory_limit
+                        ),
+                        _ => String::from("the reference solution failed the test case"),
+                    };
+                    let problem = match kind {
+                        FailureKind::TimeLimitExceeded
+                        | FailureKind::MemoryLimitExceeded
+                        | FailureKind::OutputLimitExceeded
+                        | FailureKind::IdlenessLimitExceeded => TestCaseProblem::TooTight,
+                        _ => TestCaseProblem::Malformed,
+                    };
+                    (problem, Some(kind), detail)
+                }
+            };
+
+            Some(Finding {
+                id: test_case_result.id,
+                name: test_case_result.name.clone(),
+                problem,
+                reason,
+                detail,
+            })
+        });
+        let test_cases: Vec<Finding> = findings.collect();
+
+        Validation {
+            valid: result.verdict == Verdict::Pass && test_cases.is_empty(),
+            verdict: result.verdict,
+            compile_output: match result.verdict {
+                Verdict::CompilationError => result.compile_output,
+                _ => String::new(),
+            },
+            test_cases,
+        }
+    }
+}
+
+impl IntoResponse for ExerciseError {
+    fn into_response(self) -> Response {
+        match self {
+            ExerciseError::InvalidId(_) => Problem::new(
+                StatusCode::BAD_REQUEST,
+                "invalidExerciseId",
+                "an exercise id consists of letters, digits, '-', '_', and '.', and does not start with '.'",
+            )
+            .into_response(),
+            ExerciseError::NotFound => Problem::new(
+                StatusCode::NOT_FOUND,
+                "notFound",
+                "the exercise does not exist",
+            )
+            .into_response(),
+            ExerciseError::Malformed(detail) => {
+                Problem::new(StatusCode::UNPROCESSABLE_ENTITY, "invalidPayload", detail)
+                    .into_response()
+            }
+            ExerciseError::Invalid(err) => SubmitResponse::InvalidSubmission(err.into()).into_response(),
+            ExerciseError::NoReferenceSolution => Problem::new(
+                StatusCode::UNPROCESSABLE_ENTITY,
+                "noReferenceSolution",
+                self.to_string(),
+            )
+            .into_response(),
+            ExerciseError::QuotaExceeded => {
+                SubmitResponse::QuotaExceeded(Quota::Storage).into_response()
+            }
+            ExerciseError::Io(_) => Problem::new(
+                StatusCode::INTERNAL_SERVER_ERROR,
+                "internal",
+                "an internal error occured",
+            )
+            .into_response(),
+        }
+    }
+}
+
+#[cfg(test)]
+mod exercises {
+    use super::{Attempt, Exercises, Reference};
+    use crate::{
+        config::Config,
+        error::ExerciseError,
+        model::{LimitCaps, SubmissionLimits, SubmissionResult},
+    };
+    use serde_json::{json, Map, Value};
+    use std::{collections::BTreeMap, env, fs};
+    use uuid::Uuid;
+
+    const LIMITS: SubmissionLimits = SubmissionLimits {
+        solution_size: 1024,
+        test_cases: 8,
+        allowed_env: Vec::new(),
+        allowed_endpoints: Vec::new(),
+        callback_hosts: Vec::new(),
+        profiles: Vec::new(),
+        versions: BTreeMap::new(),
+        caps: LimitCaps::UNCAPPED,
+    };
+
+    fn exercise() -> Map<String, Value> {
+        let Value::Object(exercise) = json!({
+            "language": "haskell",
+            "files": { "Helper.hs": "bW9kdWxlIEhlbHBlciB3aGVyZQo=" },
+            "testCases": [{
+                "id": 0,
+                "hidden": true,
+                "inputParameters": [{ "valueType": "int", "value": "2" }],
+                "outputParameters": [{ "valueType": "int", "value": "4" }]
+            }]
+        }) else {
+            unreachable!()
+        };
+
+        exercise
+    }
+
+    fn attempt(solution: &str) -> Attempt {
+        Attempt {
+            solution: solution.to_string(),
+            files: BTreeMap::from([
+                (String::from("Helper.hs"), String::from("LS0gbWluZQo=")),
+                (String::from("Mine.hs"), String::from("LS0gbWluZQo=")),
+            ]),
+            callback_url: None,
+            analyze: false,
+            profile: None,
+            priority: None,
+            previous_task_id: None,
+        }
+    }
+
+    #[test]
+    fn submission_of_attempt() {
+        let dir = env::temp_dir().join(format!("exercises-{}", Uuid::new_v4()));
+        let exercises = Exercises::new(dir.clone());
+
+        let created = exercises.put("double", exercise(), &LIMITS);
+        let replaced = exercises.put("double", exercise(), &LIMITS);
+        let submission = exercises.submission("double", attempt("solution x = 2 * x"));
+        fs::remove_dir_all(&dir).unwrap();
+
+        assert!(matches!(created, Ok(true)));
+        assert!(matches!(replaced, Ok(false)));
+        let submission = submission.expect("the exercise should exist");
+        assert_eq!(submission.solution, "solution x = 2 * x");
+        assert_eq!(submission.exercise_id.as_deref(), Some("double"));
+        assert_eq!(submission.test_cases.len(), 1);
+        assert!(submission.test_cases[0].hidden);
+        assert_eq!(
+            submission.files["Helper.hs"],
+            "bW9kdWxlIEhlbHBlciB3aGVyZQo="
+        );
+        assert_eq!(submission.files["Mine.hs"], "LS0gbWluZQo=");
+    }
+
+    #[test]
+    fn invalid_exercise() {
+        let dir = env::temp_dir().join(format!("exercises-{}", Uuid::new_v4()));
+        let exercises = Exercises::new(dir.clone());
+        let mut without_test_cases = exercise();
+        without_test_cases.insert(String::from("testCases"), json!([]));
+        let mut malformed = exercise();
+        malformed.insert(String::from("testCases"), json!("none"));
+
+        let invalid = exercises.put("double", without_test_cases, &LIMITS);
+        let malformed = exercises.put("double", malformed, &LIMITS);
+
+        assert!(matches!(invalid, Err(ExerciseError::Invalid(_))));
+        assert!(matches!(malformed, Err(ExerciseError::Malformed(_))));
+        assert!(!dir.exists(), "invalid exercises are not stored");
+    }
+
+    #[test]
+    fn unknown_exercise() {
+        let exercises =
+            Exercises::new(env::temp_dir().join(format!("exercises-{}", Uuid::new_v4())));
+
+        assert!(matches!(
+            exercises.submission("missing", attempt("solution = 5")),
+            Err(ExerciseError::NotFound)
+        ));
+        assert!(matches!(
+            exercises.delete("missing"),
+            Err(ExerciseError::NotFound)
+        ));
+        assert!(matches!(
+            exercises.delete("../double"),
+            Err(ExerciseError::InvalidId(_))
+        ));
+    }
+
+    #[test]
+    fn validates_with_reference_solution() {
+        let mut exercise = exercise();
+        exercise.insert(String::from("solution"), json!("solution x = 2 * x"));
+        exercise.insert(String::from("stopOnFirstFail"), json!(true));
+        exercise.insert(String::from("timeLimit"), json!(1000));
+        let test_cases: Vec<Value> = (0..5)
+            .map(|id| json!({ "id": id, "inputParameters": [], "outputParameters": [{ "valueType": "int", "value": "4" }] }))
+            .collect();
+        exercise.insert(String::from("testCases"), Value::from(test_cases));
+        let config = Config::default();
+        let result: SubmissionResult = serde_json::from_value(json!({
+            "verdict": "failure",
+            "compileOutput": "",
+            "testCaseResults": [
+                { "id": 0, "testResult": "pass", "stderr": "", "runtime": 100 },
+                { "id": 1, "testResult": "pass", "stderr": "", "runtime": 800 },
+                { "id": 2, "testResult": "pass", "stderr": "", "runtime": 100, "memory": 200 << 20 },
+                {
+                    "id": 3,
+                    "testResult": { "failure": { "wrongAnswer": {
+                        "inputParameters": [],
+                        "actual": "5",
+                        "expected": "4",
+                    } } },
+                    "stderr": "",
+                    "runtime": 100,
+                },
+                { "id": 4, "testResult": { "failure": "timeLimitExceeded" }, "stderr": "", "runtime": 1000 },
+            ],
+            "score": 20.0,
+        }))
+        .unwrap();
+
+        let (submission, reference) = Reference::submission(exercise, &config).unwrap();
+        let actual = serde_json::to_value(reference.validate(result)).unwrap();
+
+        assert!(!submission.stop_on_first_fail);
+        assert_eq!(actual["valid"], false);
+        assert_eq!(actual["compileOutput"], Value::Null);
+        let findings: Vec<(u64, &str)> = actual["testCases"]
+            .as_array()
+            .unwrap()
+            .iter()
+            .map(|finding| {
+                (
+                    finding["id"].as_u64().unwrap(),
+                    finding["problem"].as_str().unwrap(),
+                )
+            })
+            .collect();
+        assert_eq!(
+            findings,
+            [
+                (1, "tooTight"),
+                (2, "tooTight"),
+                (3, "malformed"),
+                (4, "tooTight")
+            ]
+        );
+        assert_eq!(
+            actual["testCases"][2]["detail"],
+            "the reference solution answered 5, while the test case expects 4"
+        );
+        assert_eq!(actual["testCases"][3]["reason"], "timeLimitExceeded");
+    }
+
+    #[test]
+    fn no_reference_solution() {
+        let actual = Reference::submission(exercise(), &Config::default());
+
+        assert!(matches!(actual, Err(ExerciseError::NoReferenceSolution)));
+    }
+}
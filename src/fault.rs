@@ -0,0 +1,267 @@
+#[cfg(any(test, feature = "faults"))]
+use std::{collections::BTreeMap, sync::Mutex};
+use std::{io, path::Path};
+#[cfg(any(test, feature = "faults"))]
+use uuid::Uuid;
+
+/// A failure of the judging pipeline which can be injected into judgments, so tests can verify their verdicts and
+/// their cleanup when the compiler, the sandbox, the disk, or the worker itself fails.
+///
+/// Faults can only be armed in test builds, or with the `faults` feature, without which no fault is ever injected.
+#[derive(Clone, Copy, PartialEq, Eq, Debug)]
+pub enum Fault {
+    /// The compiler hangs until it is killed at the compile timeout.
+    CompilerHang,
+    /// The sandbox fails to start, both for the compiler and for the test cases.
+    SandboxStart,
+    /// The disk of the workspace is full, so writing the test code fails.
+    DiskFull,
+    /// The worker panics once the files of the solution are in its workspace.
+    WorkerCrash,
+}
+
+/// The armed faults by the task whose judgment they are injected into, where `None` is every judgment.
+#[cfg(any(test, feature = "faults"))]
+static ARMED: Mutex<BTreeMap<Option<Uuid>, Vec<Fault>>> = Mutex::new(BTreeMap::new());
+
+/// Disarms its fault when dropped.
+#[cfg(any(test, feature = "faults"))]
+#[must_use = "the fault is disarmed when dropped"]
+pub struct Armed {
+    task: Option<Uuid>,
+    fault: Fault,
+}
+
+/// Injects the fault into every judgment until the returned guard is dropped.
+#[cfg(any(test, feature = "faults"))]
+#[cfg_attr(not(feature = "faults"), allow(dead_code))]
+pub fn arm(fault: Fault) -> Armed {
+    arm_in(None, fault)
+}
+
+/// Injects the fault into the judgment of the task only, so judgments which run at the same time are not affected.
+#[cfg(any(test, feature = "faults"))]
+pub fn arm_for(task: Uuid, fault: Fault) -> Armed {
+    arm_in(Some(task), fault)
+}
+
+#[cfg(any(test, feature = "faults"))]
+fn arm_in(task: Option<Uuid>, fault: Fault) -> Armed {
+    ARMED
+        .lock()
+        .expect("armed faults lock poisoned")
+        .entry(task)
+        .or_default()
+        .push(fault);
+
+    Armed { task, fault }
+}
+
+#[cfg(any(test, feature = "faults"))]
+impl Drop for Armed {
+    fn drop(&mut self) {
+        let mut armed = ARMED
+            .lock()
+            .unwrap_or_else(|poisoned| poisoned.into_inner());
+        if let Some(faults) = armed.get_mut(&self.task) {
+            if let Some(index) = faults.iter().position(|fault| *fault == self.fault) {
+                faults.remove(index);
+            }
+            if faults.is_empty() {
+                armed.remove(&self.task);
+            }
+        }
+    }
+}
+
+/// Whether the fault is injected into the judgment whose workspace the path is within, as workspaces are named by
+/// their task.
+#[cfg(any(test, feature = "faults"))]
+pub(crate) fn injected(path: &Path, fault: Fault) -> bool {
+    let armed = ARMED
+        .lock()
+        .unwrap_or_else(|poisoned| poisoned.into_inner());
+    if armed.is_empty() {
+        return false;
+    }
+    let tasks = path
+        .ancestors()
+        .filter_map(|dir| dir.file_name()?.to_str()?.parse::<Uuid>().ok())
+        .map(Some);
+
+    [None]
+        .into_iter()
+        .chain(tasks)
+        .filter_map(|task| armed.get(&task))
+        .any(|faults| faults.contains(&fault))
+}
+
+#[cfg(not(any(test, feature = "faults")))]
+#[inline(always)]
+pub(crate) fn injected(_path: &Path, _fault: Fault) -> bool {
+    false
+}
+
+/// Fails like writing to a full disk would, if [`Fault::DiskFull`] is injected into the judgment of the path.
+pub(crate) fn fill_disk(path: &Path) -> io::Result<()> {
+    match injected(path, Fault::DiskFull) {
+        true => Err(io::Error::from_raw_os_error(libc::ENOSPC)),
+        false => Ok(()),
+    }
+}
+
+#[cfg(test)]
+mod arming {
+    use super::{arm_for, injected, Fault};
+    use std::env;
+    use uuid::Uuid;
+
+    #[test]
+    fn scoped_to_task() {
+        let (task, other) = (Uuid::new_v4(), Uuid::new_v4());
+        let workspace = env::temp_dir().join(task.to_string());
+
+        let armed = arm_for(task, Fault::DiskFull);
+        let injected_while_armed = injected(&workspace.join("work-0"), Fault::DiskFull);
+        let other_fault = injected(&workspace, Fault::WorkerCrash);
+        let other_task = injected(&env::temp_dir().join(other.to_string()), Fault::DiskFull);
+        drop(armed);
+
+        assert!(injected_while_armed);
+        assert!(!other_fault);
+        assert!(!other_task);
+        assert!(!injected(&workspace, Fault::DiskFull));
+    }
+}
+
+#[cfg(test)]
+#[cfg(feature = "haskell")]
+mod chaos {
+    use super::{arm_for, Fault};
+    use crate::{
+        cache::CompileCache,
+        cancel::Cancellation,
+        config::Config,
+        crash,
+        judge::judge,
+        model::{Submission, Verdict},
+        plugin::VerdictPlugins,
+        response::SubmitResponse,
+        warm::WarmPool,
+    };
+    use serde_json::json;
+    use std::{env, fs, path::PathBuf};
+    use uuid::Uuid;
+
+    fn config() -> Config {
+        let work_dir = env::temp_dir().join(format!("mozart-chaos-{}", Uuid::new_v4()));
+        Config {
+            workspace_dir: Some(work_dir.join("workspaces")),
+            work_dir,
+            compile_timeout: 1,
+            ..Config::default()
+        }
+    }
+
+    fn submission() -> Submission {
+        serde_json::from_value(json!({
+            "language": "haskell",
+            "solution": "solution x = x",
+            "testCases": [{
+                "id": 0,
+                "inputParameters": [{ "valueType": "int", "value": "5" }],
+                "outputParameters": [{ "valueType": "int", "value": "5" }]
+            }]
+        }))
+        .unwrap()
+    }
+
+    /// Judges the submission as the task with the fault injected, and returns whether its workspace was removed.
+    fn judge_with(fault: Fault, config: &Config) -> (SubmitResponse, bool) {
+        let task = Uuid::new_v4();
+        let cache = CompileCache::new(config.compile_cache_dir(), 0);
+        let _armed = arm_for(task, fault);
+
+        let response = judge(
+            task,
+            submission(),
+            config,
+            &cache,
+            &WarmPool::default(),
+            &VerdictPlugins::default(),
+            &Cancellation::default(),
+            &|_| {},
+            None,
+        );
+
+        (response, !workspace(config, task).exists())
+    }
+
+    fn workspace(config: &Config, task: Uuid) -> PathBuf {
+        config.workspace_dir().join(task.to_string())
+    }
+
+    #[test]
+    fn compiler_hang() {
+        let config = config();
+
+        let (response, removed) = judge_with(Fault::CompilerHang, &config);
+        let _ = fs::remove_dir_all(&config.work_dir);
+
+        let SubmitResponse::Checked(result) = response else {
+            panic!("a hung compiler fails the compilation");
+        };
+        assert_eq!(result.verdict, Verdict::CompilationError);
+        assert!(result.compile_output.contains("longer than 1 seconds"));
+        assert!(removed);
+    }
+
+    #[test]
+    fn sandbox_start() {
+        let config = config();
+
+        let (response, removed) = judge_with(Fault::SandboxStart, &config);
+        let _ = fs::remove_dir_all(&config.work_dir);
+
+        assert!(matches!(response, SubmitResponse::Internal));
+        assert!(removed);
+    }
+
+    #[test]
+    fn disk_full() {
+        let config = config();
+
+        let (response, removed) = judge_with(Fault::DiskFull, &config);
+        let _ = fs::remove_dir_all(&config.work_dir);
+
+        assert!(matches!(response, SubmitResponse::Internal));
+        assert!(removed);
+    }
+
+    #[test]
+    fn worker_crash() {
+        let config = config();
+        let task = Uuid::new_v4();
+        let cache = CompileCache::new(config.compile_cache_dir(), 0);
+        let _armed = arm_for(task, Fault::WorkerCrash);
+
+        let crashed = crash::catch(|| {
+            judge(
+                task,
+                submission(),
+                &config,
+                &cache,
+                &WarmPool::default(),
+                &VerdictPlugins::default(),
+                &Cancellation::default(),
+                &|_| {},
+                None,
+            )
+        });
+        let removed = !workspace(&config, task).exists();
+        let _ = fs::remove_dir_all(&config.work_dir);
+
+        assert!(crashed.is_err_and(|crash| crash.message == "injected worker crash"));
+        assert!(removed);
+    }
+}
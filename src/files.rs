@@ -0,0 +1,92 @@
+use base64::{engine::general_purpose::STANDARD, Engine};
+use std::path::{Component, Path};
+
+/// The names in the workspace which mozart creates itself, so no file of a solution may be at or below them.
+///
+/// These are the test files and executables of every language and of its test suites, as well as the directories of the
+/// output, the fixtures, the working directories of test cases, the checker, the interactor, the setup and teardown
+/// scripts, and the build cache of go.
+pub const RESERVED: &[&str] = &[
+    "test",
+    "test.c",
+    "test.py",
+    "main.go",
+    "mozart_test.go",
+    "suite.test",
+    "solution.py",
+    "Solution.java",
+    "Test.hs",
+    "Test.java",
+    "output",
+    "fixtures",
+    "cases",
+    "checker",
+    "hooks",
+    "interactor",
+    ".gocache",
+];
+
+/// The longest path a file of a solution may have.
+const MAX_PATH_LEN: usize = 256;
+
+/// Whether the path can name a file of a solution, which must stay within the workspace.
+///
+/// A path is relative and consists only of normal components separated by `/`, so it can neither be absolute nor
+/// refer to a parent directory, and it may not be at or below a [`RESERVED`] name.
+pub fn is_valid_path(path: &str) -> bool {
+    let components: Vec<Component> = Path::new(path).components().collect();
+
+    !path.is_empty()
+        && path.len() <= MAX_PATH_LEN
+        && !path.contains('\\')
+        && !path.ends_with('/')
+        && !components.is_empty()
+        && components
+            .iter()
+            .all(|component| matches!(component, Component::Normal(_)))
+        && components
+            .first()
+            .and_then(|component| component.as_os_str().to_str())
+            .is_some_and(|first| !RESERVED.contains(&first))
+}
+
+/// Decodes the base64 contents of a file of a solution.
+pub fn decode(contents: &str) -> Option<Vec<u8>> {
+    STANDARD.decode(contents).ok()
+}
+
+/// Encodes an output in base64 like the files of a solution, as it is not text.
+pub fn encode(output: &[u8]) -> String {
+    STANDARD.encode(output)
+}
+
+#[cfg(test)]
+mod paths {
+    use super::is_valid_path;
+
+    #[test]
+    fn relative_paths() {
+        assert!(is_valid_path("helper.py"));
+        assert!(is_valid_path("util/strings.go"));
+        assert!(is_valid_path("go.mod"));
+        assert!(is_valid_path("tests.py"));
+    }
+
+    #[test]
+    fn traversal() {
+        assert!(!is_valid_path(""));
+        assert!(!is_valid_path("/etc/passwd"));
+        assert!(!is_valid_path("../escape.c"));
+        assert!(!is_valid_path("util/../../escape.c"));
+        assert!(!is_valid_path("./helper.py"));
+        assert!(!is_valid_path("util\\..\\escape.c"));
+        assert!(!is_valid_path("util/"));
+    }
+
+    #[test]
+    fn reserved_names() {
+        assert!(!is_valid_path("test.c"));
+        assert!(!is_valid_path("output/0"));
+        assert!(!is_valid_path("fixtures/input.txt"));
+    }
+}
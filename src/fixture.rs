@@ -0,0 +1,224 @@
+use crate::{
+    config::Config,
+    error::{CheckError, FixtureError},
+    problem::Problem,
+    response::{Quota, SubmitResponse},
+};
+use axum::{
+    http::StatusCode,
+    response::{IntoResponse, Response},
+};
+use std::{
+    collections::BTreeSet,
+    fs,
+    io::ErrorKind,
+    os::unix::fs::PermissionsExt,
+    path::{Path, PathBuf},
+};
+
+/// The directory of the fixtures of a submission, relative to its workspace.
+pub const FIXTURE_DIR: &str = "fixtures";
+
+/// The longest id a fixture may have.
+const MAX_ID_LEN: usize = 128;
+
+/// The files test cases refer to, which are uploaded ahead of the submissions using them.
+pub struct Fixtures {
+    dir: PathBuf,
+}
+
+impl Fixtures {
+    pub fn new(dir: PathBuf) -> Self {
+        Self { dir }
+    }
+
+    /// Stores a fixture, replacing an earlier fixture with the same id, and returns whether it did not exist yet.
+    pub fn put(&self, id: &str, contents: &[u8]) -> Result<bool, FixtureError> {
+        let path = self.path(id)?;
+        let created = !path.exists();
+
+        // the fixture is written to a temporary file first, so a submission never reads a partial fixture
+        let temporary = self.dir.join(format!(".{id}.tmp"));
+        fs::create_dir_all(&self.dir)
+            .and_then(|_| fs::write(&temporary, contents))
+            .and_then(|_| fs::rename(&temporary, &path))
+            .map_err(|err| FixtureError::Io(err.to_string()))?;
+
+        Ok(created)
+    }
+
+    pub fn delete(&self, id: &str) -> Result<(), FixtureError> {
+        match fs::remove_file(self.path(id)?) {
+            Ok(()) => Ok(()),
+            Err(err) if err.kind() == ErrorKind::NotFound => Err(FixtureError::NotFound),
+            Err(err) => Err(FixtureError::Io(err.to_string())),
+        }
+    }
+
+    /// Copies the fixtures with the given ids into the fixture directory of a workspace, as read-only files.
+    ///
+    /// The fixtures are copied rather than linked, so a program changing its copy cannot change the fixture itself.
+    pub fn provide<'a>(
+        &self,
+        ids: impl IntoIterator<Item = &'a str>,
+        workspace: &Path,
+    ) -> Result<(), CheckError> {
+        let ids: BTreeSet<&str> = ids.into_iter().collect();
+        if ids.is_empty() {
+            return Ok(());
+        }
+
+        let dir = workspace.join(FIXTURE_DIR);
+        if fs::create_dir(&dir).is_err() {
+            return Err(CheckError::IOInteraction);
+        }
+        for id in ids {
+            let Ok(source) = self.path(id) else {
+                return Err(CheckError::MissingFixture(id.to_string()));
+            };
+            let destination = dir.join(id);
+            match fs::copy(source, &destination) {
+                Ok(_) => {}
+                Err(err) if err.kind() == ErrorKind::NotFound => {
+                    return Err(CheckError::MissingFixture(id.to_string()))
+                }
+                Err(_) => return Err(CheckError::IOInteraction),
+            }
+            if fs::set_permissions(&destination, fs::Permissions::from_mode(0o444)).is_err() {
+                return Err(CheckError::IOInteraction);
+            }
+        }
+
+        Ok(())
+    }
+
+    fn path(&self, id: &str) -> Result<PathBuf, FixtureError> {
+        match is_valid_id(id) {
+            true => Ok(self.dir.join(id)),
+            false => Err(FixtureError::InvalidId(id.to_string())),
+        }
+    }
+}
+
+impl From<&Config> for Fixtures {
+    fn from(config: &Config) -> Self {
+        Self::new(config.fixture_dir())
+    }
+}
+
+/// Whether the id can name a fixture, which is also its file name.
+///
+/// Ids consist of ASCII letters, digits, `-`, `_`, and `.`, but may not start with a `.`, so they can never refer to a
+/// parent directory or collide with the temporary files of fixtures being written.
+pub fn is_valid_id(id: &str) -> bool {
+    !id.is_empty()
+        && id.len() <= MAX_ID_LEN
+        && !id.starts_with('.')
+        && id
+            .chars()
+            .all(|c| c.is_ascii_alphanumeric() || matches!(c, '-' | '_' | '.'))
+}
+
+impl IntoResponse for FixtureError {
+    fn into_response(self) -> Response {
+        match self {
+            FixtureError::InvalidId(_) => Problem::new(
+                StatusCode::BAD_REQUEST,
+                "invalidFixtureId",
+                "a fixture id consists of letters, digits, '-', '_', and '.', and does not start with '.'",
+            )
+            .into_response(),
+            FixtureError::NotFound => Problem::new(
+                StatusCode::NOT_FOUND,
+                "notFound",
+                "the fixture does not exist",
+            )
+            .into_response(),
+            FixtureError::TooLarge => Problem::new(
+                StatusCode::PAYLOAD_TOO_LARGE,
+                "payloadTooLarge",
+                "the fixture is larger than the fixture size limit",
+            )
+            .into_response(),
+            FixtureError::QuotaExceeded => {
+                SubmitResponse::QuotaExceeded(Quota::Storage).into_response()
+            }
+            FixtureError::Io(_) => Problem::new(
+                StatusCode::INTERNAL_SERVER_ERROR,
+                "internal",
+                "an internal error occured",
+            )
+            .into_response(),
+        }
+    }
+}
+
+#[cfg(test)]
+mod fixtures {
+    use super::{is_valid_id, Fixtures, FIXTURE_DIR};
+    use crate::error::{CheckError, FixtureError};
+    use std::{env, fs, path::PathBuf};
+    use uuid::Uuid;
+
+    fn dir(name: &str) -> PathBuf {
+        env::temp_dir().join(format!("mozart-{name}-{}", Uuid::new_v4()))
+    }
+
+    #[test]
+    fn ids() {
+        assert!(is_valid_id("graph-1.txt"));
+        assert!(!is_valid_id(""));
+        assert!(!is_valid_id(".hidden"));
+        assert!(!is_valid_id("../passwd"));
+        assert!(!is_valid_id("a/b"));
+        assert!(!is_valid_id(&"a".repeat(129)));
+    }
+
+    #[test]
+    fn put_replaces() {
+        let fixtures = Fixtures::new(dir("fixtures"));
+
+        assert!(fixtures.put("input.txt", b"first").unwrap());
+        assert!(!fixtures.put("input.txt", b"second").unwrap());
+        let actual = fs::read_to_string(fixtures.dir.join("input.txt")).unwrap();
+        fixtures.delete("input.txt").unwrap();
+
+        assert_eq!(actual, "second");
+        assert!(matches!(
+            fixtures.delete("input.txt"),
+            Err(FixtureError::NotFound)
+        ));
+        let _ = fs::remove_dir_all(&fixtures.dir);
+    }
+
+    #[test]
+    fn provides_read_only_copies() {
+        let fixtures = Fixtures::new(dir("fixtures"));
+        let workspace = dir("workspace");
+        fs::create_dir(&workspace).unwrap();
+        fixtures.put("input.txt", b"1 2 3").unwrap();
+
+        let actual = fixtures.provide(["input.txt", "input.txt"], &workspace);
+        let copy = workspace.join(FIXTURE_DIR).join("input.txt");
+        let contents = fs::read_to_string(&copy);
+        let readonly = fs::metadata(&copy).map(|metadata| metadata.permissions().readonly());
+        let _ = fs::remove_dir_all(&fixtures.dir);
+        let _ = fs::remove_dir_all(&workspace);
+
+        assert!(actual.is_ok());
+        assert_eq!(contents.unwrap(), "1 2 3");
+        assert!(readonly.unwrap());
+    }
+
+    #[test]
+    fn missing_fixture() {
+        let fixtures = Fixtures::new(dir("fixtures"));
+        let workspace = dir("workspace");
+        fs::create_dir(&workspace).unwrap();
+
+        let actual = fixtures.provide(["missing.txt"], &workspace);
+        let _ = fs::remove_dir_all(&workspace);
+
+        assert!(matches!(actual, Err(CheckError::MissingFixture(id)) if id == "missing.txt"));
+    }
+}
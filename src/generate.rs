@@ -0,0 +1,340 @@
+use crate::{
+    compare::{unquote, Comparison},
+    error::GenerateError,
+    model::{
+        Language, NetworkPolicy, Parameter, Submission, SubmissionResult, TestCase,
+        TestCaseFailureReason, TestResult,
+    },
+    score::Scoring,
+};
+use serde::{Deserialize, Serialize};
+use std::collections::BTreeMap;
+
+/// A request to generate test cases, by running a reference solution against inputs.
+#[derive(Deserialize)]
+pub struct GenerateRequest {
+    /// The language of the reference solution, which defaults to haskell.
+    #[serde(default)]
+    pub language: Language,
+    pub solution: String,
+    /// The value type of the single output parameter of the generated test cases.
+    #[serde(rename = "outputType")]
+    pub output_type: String,
+    pub inputs: Box<[Input]>,
+    /// The memory limit of the reference solution in mebibytes, which is capped by the server.
+    #[serde(rename = "memoryLimit")]
+    pub memory_limit: Option<u64>,
+}
+
+/// The inputs of a single test case to generate.
+#[derive(Deserialize)]
+pub struct Input {
+    pub name: Option<String>,
+    #[serde(rename = "inputParameters")]
+    pub input_parameters: Box<[Parameter]>,
+    /// The wall-clock time limit of the reference solution in milliseconds.
+    #[serde(rename = "timeLimit")]
+    pub time_limit: Option<u64>,
+}
+
+/// A test case expecting the output of the reference solution, which can be submitted as is.
+#[derive(Serialize)]
+pub struct GeneratedTestCase {
+    pub id: u64,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub name: Option<String>,
+    #[serde(rename = "inputParameters")]
+    pub input_parameters: Box<[Parameter]>,
+    #[serde(rename = "outputParameters")]
+    pub output_parameters: Box<[Parameter]>,
+}
+
+#[derive(Serialize)]
+pub struct GeneratedTestCases {
+    #[serde(rename = "testCases")]
+    pub test_cases: Vec<GeneratedTestCase>,
+}
+
+impl GenerateRequest {
+    /// Creates a submission of the reference solution, with a test case for every input.
+    ///
+    /// Every test case expects a placeholder of the output type, so the test program prints the actual output of the
+    /// reference solution whenever it differs from the placeholder.
+    pub fn submission(&self) -> Result<Submission, GenerateError> {
+        if self.inputs.is_empty() {
+            return Err(GenerateError::NoInputs);
+        }
+        let placeholder = placeholder(&self.output_type)
+            .ok_or_else(|| GenerateError::UnsupportedOutputType(self.output_type.clone()))?;
+
+        let test_cases = self
+            .inputs
+            .iter()
+            .enumerate()
+            .map(|(index, input)| TestCase {
+                id: index as u64,
+                name: input.name.clone(),
+                input_parameters: input.input_parameters.clone(),
+                output_parameters: Box::new([Parameter {
+                    value_type: self.output_type.clone(),
+                    value: placeholder.to_string(),
+                }]),
+                weight: 1,
+                hidden: false,
+                time_limit: input.time_limit,
+                comparison: Comparison::Exact,
+                epsilon: None,
+                group: None,
+                fixtures: Vec::new(),
+                stdin: None,
+                env: BTreeMap::new(),
+                work_dir: BTreeMap::new(),
+            })
+            .collect();
+
+        Ok(Submission {
+            language: self.language,
+            solution: self.solution.clone(),
+            files: BTreeMap::new(),
+            blobs: BTreeMap::new(),
+            test_cases,
+            memory_limit: self.memory_limit,
+            time_limit: None,
+            process_limit: None,
+            disk_limit: None,
+            checker: None,
+            exercise_id: None,
+            interactor: None,
+            callback_url: None,
+            analyze: false,
+            fingerprint: false,
+            groups: Vec::new(),
+            scoring: Scoring::default(),
+            network: NetworkPolicy::None,
+            profile: None,
+            toolchain_version: None,
+            stop_on_first_fail: false,
+            max_failures: None,
+            export_artifact: false,
+            record_transcript: false,
+            seed: None,
+            image_digest: None,
+            benchmark: None,
+            verdict_policy: None,
+            test_suite: false,
+            priority: None,
+            previous_task_id: None,
+            nondeterministic: false,
+            setup: None,
+            teardown: None,
+        })
+    }
+
+    /// Creates the test cases from the result of checking the submission of the reference solution.
+    ///
+    /// Fails if the reference solution did not produce an output for every input.
+    pub fn test_cases(
+        &self,
+        result: &SubmissionResult,
+    ) -> Result<GeneratedTestCases, GenerateError> {
+        let placeholder = placeholder(&self.output_type)
+            .ok_or_else(|| GenerateError::UnsupportedOutputType(self.output_type.clone()))?;
+
+        let test_cases = self
+            .inputs
+            .iter()
+            .zip(result.test_case_results.iter())
+            .enumerate()
+            .map(|(index, (input, test_case_result))| {
+                let value = match &test_case_result.test_result {
+                    TestResult::Pass => placeholder.to_string(),
+                    TestResult::Failure(TestCaseFailureReason::WrongAnswer {
+                        actual,
+                        encoding: None,
+                        ..
+                    }) => output_value(&self.output_type, actual),
+                    TestResult::Failure(reason) => {
+                        return Err(GenerateError::Failed(index, failure(reason)))
+                    }
+                    TestResult::Unknown | TestResult::Skipped => {
+                        return Err(GenerateError::Failed(index, "was not run"))
+                    }
+                };
+
+                Ok(GeneratedTestCase {
+                    id: index as u64,
+                    name: input.name.clone(),
+                    input_parameters: input.input_parameters.clone(),
+                    output_parameters: Box::new([Parameter {
+                        value_type: self.output_type.clone(),
+                        value,
+                    }]),
+                })
+            })
+            .collect::<Result<_, _>>()?;
+
+        Ok(GeneratedTestCases { test_cases })
+    }
+}
+
+/// Gets the placeholder output of a value type, which every language can express.
+fn placeholder(value_type: &str) -> Option<&'static str> {
+    match value_type {
+        "int" | "integer" => Some("0"),
+        "float" | "double" => Some("0.0"),
+        "bool" | "boolean" => Some("false"),
+        "string" => Some(""),
+        _ => None,
+    }
+}
+
+/// Converts an output as printed by a test program into the value of a parameter of the given type.
+fn output_value(value_type: &str, printed: &str) -> String {
+    match value_type {
+        // some languages print booleans capitalized
+        "bool" | "boolean" => printed.to_lowercase(),
+        "string" => unquote(printed),
+        _ => printed.to_string(),
+    }
+}
+
+fn failure(reason: &TestCaseFailureReason) -> &'static str {
+    match reason {
+        // generating only fails on a wrong answer whose output is not text
+        TestCaseFailureReason::WrongAnswer { .. } => "printed output which is not text",
+        TestCaseFailureReason::RuntimeError => "caused a runtime error",
+        TestCaseFailureReason::TimeLimitExceeded => "exceeded the time limit",
+        TestCaseFailureReason::MemoryLimitExceeded => "exceeded the memory limit",
+        TestCaseFailureReason::OutputLimitExceeded => "exceeded the disk limit",
+        TestCaseFailureReason::SecurityViolation => "made a forbidden system call",
+        TestCaseFailureReason::WrongInteraction => "was rejected by the interactor",
+        TestCaseFailureReason::IdlenessLimitExceeded => "exceeded the idle limit",
+        TestCaseFailureReason::TestFailed { .. } => "failed its test",
+    }
+}
+
+#[cfg(test)]
+mod test_cases {
+    use super::{GenerateRequest, Input};
+    use crate::{
+        error::GenerateError,
+        model::{
+            Language, Parameter, SubmissionResult, TestCaseFailureReason, TestCaseResult,
+            TestResult,
+        },
+    };
+    use std::collections::BTreeMap;
+
+    fn request(output_type: &str, inputs: usize) -> GenerateRequest {
+        GenerateRequest {
+            language: Language::Python,
+            solution: String::new(),
+            output_type: String::from(output_type),
+            inputs: (0..inputs)
+                .map(|index| Input {
+                    name: None,
+                    input_parameters: Box::new([Parameter {
+                        value_type: String::from("int"),
+                        value: index.to_string(),
+                    }]),
+                    time_limit: None,
+                })
+                .collect(),
+            memory_limit: None,
+        }
+    }
+
+    fn result(test_results: Vec<TestResult>) -> SubmissionResult {
+        let test_case_results = test_results
+            .into_iter()
+            .enumerate()
+            .map(|(index, test_result)| TestCaseResult {
+                id: index as u64,
+                name: None,
+                test_result,
+                hidden: false,
+                stderr: String::new(),
+                runtime: 0,
+                memory: None,
+                user_time: None,
+                system_time: None,
+                cause: None,
+                benchmark: None,
+                attachments: BTreeMap::new(),
+            })
+            .collect();
+
+        SubmissionResult::checked(String::new(), test_case_results)
+    }
+
+    fn wrong_answer(actual: &str) -> TestResult {
+        TestResult::Failure(TestCaseFailureReason::WrongAnswer {
+            input_parameters: Box::new([]),
+            actual: String::from(actual),
+            expected: String::new(),
+            diff: None,
+            encoding: None,
+        })
+    }
+
+    #[test]
+    fn unsupported_output_type() {
+        let request = request("list", 1);
+
+        let actual = request.submission();
+
+        assert!(matches!(
+            actual,
+            Err(GenerateError::UnsupportedOutputType(value_type)) if value_type == "list"
+        ));
+    }
+
+    #[test]
+    fn expects_placeholders() {
+        let request = request("int", 2);
+
+        let actual = request.submission().expect("the request should be valid");
+
+        assert_eq!(actual.test_cases.len(), 2);
+        assert_eq!(actual.test_cases[1].id, 1);
+        assert_eq!(actual.test_cases[1].output_parameters[0].value, "0");
+    }
+
+    #[test]
+    fn outputs_of_reference_solution() {
+        let request = request("string", 2);
+        let result = result(vec![TestResult::Pass, wrong_answer("'hello'")]);
+
+        let actual = request
+            .test_cases(&result)
+            .expect("every input has an output");
+
+        assert_eq!(actual.test_cases[0].output_parameters[0].value, "");
+        assert_eq!(actual.test_cases[1].output_parameters[0].value, "hello");
+    }
+
+    #[test]
+    fn capitalized_booleans() {
+        let request = request("bool", 1);
+        let result = result(vec![wrong_answer("True")]);
+
+        let actual = request
+            .test_cases(&result)
+            .expect("every input has an output");
+
+        assert_eq!(actual.test_cases[0].output_parameters[0].value, "true");
+    }
+
+    #[test]
+    fn failing_reference_solution() {
+        let request = request("int", 2);
+        let result = result(vec![
+            TestResult::Pass,
+            TestResult::Failure(TestCaseFailureReason::RuntimeError),
+        ]);
+
+        let actual = request.test_cases(&result);
+
+        assert!(matches!(actual, Err(GenerateError::Failed(1, _))));
+    }
+}
@@ -0,0 +1,630 @@
+use crate::{
+    audit::{Interface, Submitter},
+    auth::AuthError,
+    idempotency::IDEMPOTENCY_KEY_HEADER,
+    job::Progress,
+    logging::{MAX_REQUEST_ID_LEN, REQUEST_ID_HEADER},
+    metrics::METRICS,
+    model::{Priority, Submission},
+    ratelimit,
+    response::SubmitResponse,
+    server::tls,
+    tenant::Tenant,
+    AppState,
+};
+use bytes::{BufMut, Bytes, BytesMut};
+use h2::{
+    server::{self, SendResponse},
+    RecvStream, SendStream,
+};
+use http::{header, HeaderMap, HeaderValue, Method, Request, Response};
+use proto::Field;
+use serde_json::{json, Value};
+use std::{
+    net::SocketAddr,
+    time::{Duration, Instant},
+};
+use tokio::{
+    io::{AsyncRead, AsyncWrite},
+    net::TcpListener,
+};
+use tokio_rustls::TlsAcceptor;
+use tokio_stream::{wrappers::BroadcastStream, StreamExt};
+use tracing::{debug, info, info_span, warn, Instrument};
+use uuid::Uuid;
+
+mod proto;
+
+/// The prefix of the path of every method of the judge service, which is followed by the name of the method.
+const SERVICE_PATH: &str = "/mozart.Judge/";
+
+/// The largest request message which is accepted, which is the default of most gRPC implementations.
+const MAX_MESSAGE_LEN: usize = 4 * 1024 * 1024;
+
+/// The length of the prefix of every message, which is a compression flag followed by the length of the message.
+const PREFIX_LEN: usize = 5;
+
+/// The status codes of gRPC which are used by the judge service.
+#[derive(Clone, Copy, PartialEq, Debug)]
+enum Code {
+    Ok = 0,
+    Cancelled = 1,
+    InvalidArgument = 3,
+    DeadlineExceeded = 4,
+    NotFound = 5,
+    PermissionDenied = 7,
+    ResourceExhausted = 8,
+    Unimplemented = 12,
+    Internal = 13,
+    Unavailable = 14,
+    Unauthenticated = 16,
+}
+
+/// The status a call ends with, which is sent in the trailers of the response.
+#[derive(Debug)]
+struct Status {
+    code: Code,
+    message: String,
+}
+
+impl Status {
+    fn new(code: Code, message: impl Into<String>) -> Self {
+        Self {
+            code,
+            message: message.into(),
+        }
+    }
+}
+
+impl From<SubmitResponse> for Status {
+    /// Converts a response into the status with the same meaning as its HTTP status code, where a result is a success.
+    fn from(response: SubmitResponse) -> Self {
+        match response {
+            SubmitResponse::Checked(_) => Status::new(Code::Ok, ""),
+            SubmitResponse::InvalidSubmission(invalid) => {
+                Status::new(Code::InvalidArgument, invalid.detail)
+            }
+            SubmitResponse::Busy => Status::new(Code::ResourceExhausted, "the queue is full"),
+            SubmitResponse::Unavailable => {
+                Status::new(Code::Unavailable, "mozart is shutting down")
+            }
+            SubmitResponse::Paused => Status::new(Code::Unavailable, "mozart is paused"),
+            SubmitResponse::LowDiskSpace => {
+                Status::new(Code::Unavailable, "mozart is running low on disk space")
+            }
+            SubmitResponse::Cancelled => Status::new(Code::Cancelled, "the job was cancelled"),
+            SubmitResponse::TimedOut => {
+                Status::new(Code::DeadlineExceeded, "the judgment timed out")
+            }
+            SubmitResponse::QuotaExceeded(_) => Status::new(
+                Code::ResourceExhausted,
+                "the quota of the tenant is used up",
+            ),
+            SubmitResponse::Internal => Status::new(Code::Internal, "an internal error occured"),
+        }
+    }
+}
+
+/// The reply to a call, before it is framed.
+enum Reply {
+    Unary(Vec<u8>),
+    /// The progress of a job so far, followed by its progress to come.
+    Progress(Vec<Progress>, tokio::sync::broadcast::Receiver<Progress>),
+}
+
+/// Serves the judge service on the listener, until mozart exits.
+///
+/// Every connection is served in its own task, and every call in a task of its own. Connections are served over TLS if
+/// an acceptor is given, whose handshake has to complete within the timeout.
+pub async fn serve(
+    listener: TcpListener,
+    state: AppState,
+    tls: Option<TlsAcceptor>,
+    handshake_timeout: Option<Duration>,
+) {
+    loop {
+        let (socket, peer) = match listener.accept().await {
+            Ok(accepted) => accepted,
+            Err(err) => {
+                warn!(%err, "failed to accept gRPC connection");
+                continue;
+            }
+        };
+
+        let state = state.clone();
+        let tls = tls.clone();
+        tokio::spawn(async move {
+            let Some(acceptor) = tls else {
+                return serve_connection(socket, state, peer).await;
+            };
+            match tls::accept(&acceptor, socket, handshake_timeout).await {
+                Ok(stream) => serve_connection(stream, state, peer).await,
+                Err(err) => debug!(%err, %peer, "failed TLS handshake of gRPC connection"),
+            }
+        });
+    }
+}
+
+/// Serves the calls of a connection until it is closed.
+async fn serve_connection<S>(stream: S, state: AppState, peer: SocketAddr)
+where
+    S: AsyncRead + AsyncWrite + Unpin,
+{
+    let mut connection = match server::handshake(stream).await {
+        Ok(connection) => connection,
+        Err(err) => {
+            debug!(%err, %peer, "failed gRPC handshake");
+            return;
+        }
+    };
+
+    while let Some(call) = connection.accept().await {
+        match call {
+            Ok((request, respond)) => {
+                tokio::spawn(handle(state.clone(), peer, request, respond));
+            }
+            Err(err) => {
+                debug!(%err, %peer, "gRPC connection failed");
+                return;
+            }
+        }
+    }
+}
+
+/// Handles a single call within a span carrying its correlation id, like the HTTP requests.
+async fn handle(
+    state: AppState,
+    peer: SocketAddr,
+    request: Request<RecvStream>,
+    mut respond: SendResponse<Bytes>,
+) {
+    let request_id = request
+        .headers()
+        .get(REQUEST_ID_HEADER)
+        .and_then(|value| value.to_str().ok())
+        .filter(|id| !id.is_empty() && id.len() <= MAX_REQUEST_ID_LEN)
+        .map_or_else(|| Uuid::new_v4().to_string(), str::to_string);
+    let span = info_span!("request", request_id = %request_id);
+    let method = request.uri().path().to_string();
+    let started = Instant::now();
+
+    async {
+        let status = match call(&state, peer, request).await {
+            Ok(reply) => send(&mut respond, reply).await,
+            Err(status) => {
+                send_status(&mut respond, &status);
+                status
+            }
+        };
+        info!(
+            %method,
+            code = status.code as u8,
+            latency_ms = started.elapsed().as_millis() as u64,
+            "handled gRPC call"
+        );
+    }
+    .instrument(span)
+    .await
+}
+
+/// Authenticates a call, and dispatches it to the method it calls.
+async fn call(
+    state: &AppState,
+    peer: SocketAddr,
+    request: Request<RecvStream>,
+) -> Result<Reply, Status> {
+    let is_grpc = request
+        .headers()
+        .get(header::CONTENT_TYPE)
+        .and_then(|value| value.to_str().ok())
+        .is_some_and(|value| value.starts_with("application/grpc"));
+    if request.method() != Method::POST || !is_grpc {
+        return Err(Status::new(Code::Unimplemented, "not a gRPC call"));
+    }
+
+    let authorization = request
+        .headers()
+        .get(header::AUTHORIZATION)
+        .and_then(|value| value.to_str().ok())
+        .map(str::to_string);
+    let idempotency_key = request
+        .headers()
+        .get(IDEMPOTENCY_KEY_HEADER)
+        .map(|value| value.to_str().unwrap_or_default().to_string());
+    state
+        .tokens
+        .authenticate(authorization.as_deref())
+        .map_err(|err| match err {
+            AuthError::Missing => Status::new(Code::Unauthenticated, "a bearer token is required"),
+            AuthError::Forbidden => {
+                Status::new(Code::PermissionDenied, "the bearer token is not allowed")
+            }
+        })?;
+
+    let path = request.uri().path().to_string();
+    let method = path
+        .strip_prefix(SERVICE_PATH)
+        .ok_or_else(|| Status::new(Code::Unimplemented, format!("unknown service of {path}")))?;
+    let fields = match method {
+        "Submit" => proto::SUBMISSION,
+        "GetResult" | "StreamProgress" => proto::TASK_REQUEST,
+        _ => {
+            return Err(Status::new(
+                Code::Unimplemented,
+                format!("unknown method {method}"),
+            ))
+        }
+    };
+    let message = read_message(request.into_body(), fields).await?;
+    let tenant = state.tenants.identify(authorization.as_deref());
+
+    match method {
+        "Submit" => {
+            let client = ratelimit::client_of(&state.tokens, authorization.as_deref(), Some(peer));
+            if state.limiter.acquire(&client, Instant::now()).is_err() {
+                METRICS.rate_limited();
+                warn!("rejected rate limited submission");
+                return Err(Status::new(
+                    Code::ResourceExhausted,
+                    "too many submissions, retry later",
+                ));
+            }
+
+            let submitter = Submitter::new(Interface::Grpc, authorization.as_deref(), Some(peer));
+            let allowed = crate::allowed_priority(state, authorization.as_deref());
+            submit(
+                state,
+                &tenant,
+                submitter,
+                allowed,
+                message,
+                idempotency_key.as_deref(),
+            )
+        }
+        "GetResult" => {
+            let trusted = state.reveal_tokens.trusts(authorization.as_deref());
+            get_result(state, &tenant, &message, trusted)
+        }
+        _ => stream_progress(state, &tenant, &message),
+    }
+}
+
+fn submit(
+    state: &AppState,
+    tenant: &Tenant,
+    submitter: Submitter,
+    allowed: Priority,
+    message: Value,
+    idempotency_key: Option<&str>,
+) -> Result<Reply, Status> {
+    let mut submission: Submission = serde_json::from_value(message)
+        .map_err(|err| Status::new(Code::InvalidArgument, err.to_string()))?;
+    submission.prioritize(allowed, allowed);
+
+    let (id, queue_position) =
+        crate::accept_task(state, tenant, submitter, submission, idempotency_key)
+            .map_err(Status::from)?;
+
+    Ok(Reply::Unary(proto::encode(
+        &json!({ "id": id, "queuePosition": queue_position }),
+        proto::TASK,
+    )))
+}
+
+/// Gets the result of a job of the tenant, whose hidden test cases are redacted unless the caller is trusted.
+fn get_result(
+    state: &AppState,
+    tenant: &Tenant,
+    message: &Value,
+    trusted: bool,
+) -> Result<Reply, Status> {
+    let mut record = state
+        .jobs
+        .record(task_id(message)?)
+        .filter(|record| tenant.owns(record))
+        .ok_or_else(|| Status::new(Code::NotFound, "the job does not exist"))?;
+    if !trusted {
+        record.redact();
+    }
+
+    let result = match record.result {
+        Some(SubmitResponse::Checked(result)) => proto::submission_result(&result),
+        // a job which is done without a result fails the call, like the HTTP endpoint
+        Some(response) => return Err(Status::from(response)),
+        None => Value::Null,
+    };
+
+    Ok(Reply::Unary(proto::encode(
+        &json!({ "status": record.status, "result": result }),
+        proto::TASK_RESULT,
+    )))
+}
+
+/// Streams the progress of a job of the tenant.
+fn stream_progress(state: &AppState, tenant: &Tenant, message: &Value) -> Result<Reply, Status> {
+    let id = task_id(message)?;
+    if state
+        .jobs
+        .record(id)
+        .is_some_and(|record| !tenant.owns(&record))
+    {
+        return Err(Status::new(Code::NotFound, "the job does not exist"));
+    }
+    let (history, receiver) = state
+        .jobs
+        .subscribe(id)
+        .ok_or_else(|| Status::new(Code::NotFound, "the job does not exist"))?;
+
+    Ok(Reply::Progress(history, receiver))
+}
+
+fn task_id(message: &Value) -> Result<Uuid, Status> {
+    message["id"]
+        .as_str()
+        .and_then(|id| Uuid::parse_str(id).ok())
+        .ok_or_else(|| Status::new(Code::InvalidArgument, "the id is not a valid uuid"))
+}
+
+/// Reads the single message of a request, and decodes it into its JSON.
+async fn read_message(mut body: RecvStream, fields: &[Field]) -> Result<Value, Status> {
+    let mut bytes = BytesMut::new();
+    while let Some(chunk) = body.data().await {
+        let chunk = chunk.map_err(|err| Status::new(Code::Internal, err.to_string()))?;
+        let _ = body.flow_control().release_capacity(chunk.len());
+        if bytes.len() + chunk.len() > PREFIX_LEN + MAX_MESSAGE_LEN {
+            return Err(Status::new(
+                Code::ResourceExhausted,
+                "the message is too large",
+            ));
+        }
+        bytes.extend_from_slice(&chunk);
+    }
+
+    if bytes.len() < PREFIX_LEN {
+        return Err(Status::new(
+            Code::InvalidArgument,
+            "the request has no message",
+        ));
+    }
+    // compression is never negotiated, as no encodings are accepted
+    if bytes[0] != 0 {
+        return Err(Status::new(
+            Code::Unimplemented,
+            "compressed messages are not supported",
+        ));
+    }
+    let len = u32::from_be_bytes([bytes[1], bytes[2], bytes[3], bytes[4]]) as usize;
+    let message = bytes
+        .get(PREFIX_LEN..PREFIX_LEN + len)
+        .ok_or_else(|| Status::new(Code::InvalidArgument, "the message is truncated"))?;
+
+    proto::decode(message, fields)
+        .map_err(|err| Status::new(Code::InvalidArgument, err.to_string()))
+}
+
+/// Sends the reply, followed by the status of the call in the trailers.
+///
+/// A stream of progress ends once the job is done, or when the client goes away.
+async fn send(respond: &mut SendResponse<Bytes>, reply: Reply) -> Status {
+    let response = Response::builder()
+        .header(header::CONTENT_TYPE, "application/grpc")
+        .body(())
+        .expect("the response should be valid");
+    let Ok(mut stream) = respond.send_response(response, false) else {
+        return Status::new(Code::Unavailable, "the client went away");
+    };
+
+    let sent = match reply {
+        Reply::Unary(message) => send_message(&mut stream, &message),
+        Reply::Progress(history, receiver) => {
+            // a subscriber which falls too far behind skips the events it missed
+            let mut events = tokio_stream::iter(history)
+                .chain(BroadcastStream::new(receiver).filter_map(Result::ok));
+            let mut sent = Ok(());
+            while let Some(progress) = events.next().await {
+                let value =
+                    serde_json::to_value(&progress).expect("progress should always serialize");
+                sent = send_message(&mut stream, &proto::encode(&value, proto::PROGRESS));
+                if sent.is_err() || matches!(progress, Progress::Finished { .. }) {
+                    break;
+                }
+            }
+            sent
+        }
+    };
+    if sent.is_err() {
+        return Status::new(Code::Unavailable, "the client went away");
+    }
+
+    let status = Status::new(Code::Ok, "");
+    let _ = stream.send_trailers(trailers(&status));
+    status
+}
+
+fn send_message(stream: &mut SendStream<Bytes>, message: &[u8]) -> Result<(), h2::Error> {
+    let mut frame = BytesMut::with_capacity(PREFIX_LEN + message.len());
+    frame.put_u8(0);
+    frame.put_u32(message.len() as u32);
+    frame.put_slice(message);
+
+    stream.send_data(frame.freeze(), false)
+}
+
+/// Responds to a failed call with only headers, which carry the status in place of trailers.
+fn send_status(respond: &mut SendResponse<Bytes>, status: &Status) {
+    let mut response = Response::builder()
+        .header(header::CONTENT_TYPE, "application/grpc")
+        .body(())
+        .expect("the response should be valid");
+    response.headers_mut().extend(trailers(status));
+
+    let _ = respond.send_response(response, true);
+}
+
+fn trailers(status: &Status) -> HeaderMap {
+    let mut trailers = HeaderMap::new();
+    trailers.insert("grpc-status", HeaderValue::from(status.code as u16));
+    if !status.message.is_empty() {
+        let message = percent_encode(&status.message);
+        trailers.insert(
+            "grpc-message",
+            HeaderValue::from_str(&message).expect("a percent-encoded message is a valid header"),
+        );
+    }
+
+    trailers
+}
+
+/// Percent-encodes a status message, as required for the `grpc-message` trailer.
+fn percent_encode(message: &str) -> String {
+    message
+        .bytes()
+        .map(|byte| match byte {
+            b' '..=b'~' if byte != b'%' => (byte as char).to_string(),
+            _ => format!("%{byte:02X}"),
+        })
+        .collect()
+}
+
+#[cfg(test)]
+mod judge {
+    use super::{proto, serve};
+    use crate::{
+        config::Config,
+        response::{Invalid, SubmitResponse},
+        AppState,
+    };
+    use bytes::{BufMut, Bytes, BytesMut};
+    use http::{header, HeaderMap, Method, Request};
+    use serde_json::{json, Value};
+    use tokio::net::{TcpListener, TcpStream};
+    use uuid::Uuid;
+
+    /// Serves the judge service on an unused port, returning the address it serves on.
+    async fn server(state: AppState) -> String {
+        let listener = TcpListener::bind("127.0.0.1:0").await.unwrap();
+        let address = listener.local_addr().unwrap().to_string();
+        tokio::spawn(serve(listener, state, None, None));
+
+        address
+    }
+
+    /// Calls a method of the judge service, returning the messages of the reply and the status trailers.
+    async fn call(address: &str, method: &str, message: &[u8]) -> (Vec<Bytes>, HeaderMap) {
+        let socket = TcpStream::connect(address).await.unwrap();
+        let (client, connection) = h2::client::handshake(socket).await.unwrap();
+        tokio::spawn(connection);
+        let mut client = client.ready().await.unwrap();
+
+        let request = Request::builder()
+            .method(Method::POST)
+            .uri(format!("http://{address}/mozart.Judge/{method}"))
+            .header(header::CONTENT_TYPE, "application/grpc")
+            .body(())
+            .unwrap();
+        let (response, mut stream) = client.send_request(request, false).unwrap();
+        let mut frame = BytesMut::new();
+        frame.put_u8(0);
+        frame.put_u32(message.len() as u32);
+        frame.put_slice(message);
+        stream.send_data(frame.freeze(), true).unwrap();
+
+        let response = response.await.unwrap();
+        let headers = response.headers().clone();
+        let mut body = response.into_body();
+        let mut bytes = BytesMut::new();
+        while let Some(chunk) = body.data().await {
+            bytes.extend_from_slice(&chunk.unwrap());
+        }
+        let trailers = body.trailers().await.unwrap().unwrap_or(headers);
+
+        let mut messages = Vec::new();
+        let mut bytes = bytes.freeze();
+        while bytes.len() >= 5 {
+            let len = u32::from_be_bytes([bytes[1], bytes[2], bytes[3], bytes[4]]) as usize;
+            messages.push(bytes.slice(5..5 + len));
+            bytes = bytes.slice(5 + len..);
+        }
+
+        (messages, trailers)
+    }
+
+    fn task_request(id: Uuid) -> Vec<u8> {
+        proto::encode(&json!({ "id": id }), proto::TASK_REQUEST)
+    }
+
+    #[tokio::test]
+    async fn invalid_submission() {
+        let address = server(AppState::new(Config::default())).await;
+        let message = proto::encode(
+            &json!({ "solution": "solution = 5", "testCases": [] }),
+            proto::SUBMISSION,
+        );
+
+        let (messages, trailers) = call(&address, "Submit", &message).await;
+
+        assert!(messages.is_empty());
+        assert_eq!(trailers["grpc-status"], "3");
+        assert_eq!(
+            trailers["grpc-message"],
+            "the submission contains no test cases"
+        );
+    }
+
+    #[tokio::test]
+    async fn unknown_job() {
+        let address = server(AppState::new(Config::default())).await;
+
+        let (_, trailers) = call(&address, "GetResult", &task_request(Uuid::new_v4())).await;
+
+        assert_eq!(trailers["grpc-status"], "5");
+    }
+
+    #[tokio::test]
+    async fn unknown_method() {
+        let address = server(AppState::new(Config::default())).await;
+
+        let (_, trailers) = call(&address, "Rejudge", &[]).await;
+
+        assert_eq!(trailers["grpc-status"], "12");
+    }
+
+    #[tokio::test]
+    async fn missing_token() {
+        let address = server(AppState::new(Config {
+            tokens: vec!["secret".to_string()],
+            ..Config::default()
+        }))
+        .await;
+
+        let (_, trailers) = call(&address, "GetResult", &task_request(Uuid::new_v4())).await;
+
+        assert_eq!(trailers["grpc-status"], "16");
+    }
+
+    #[tokio::test]
+    async fn result_and_progress_of_finished_job() {
+        let state = AppState::new(Config::default());
+        let submission = serde_json::from_str(r#"{"solution": "", "testCases": []}"#).unwrap();
+        let id = state.jobs.create(None, 0, &submission);
+        state.jobs.finish(
+            id,
+            SubmitResponse::InvalidSubmission(Invalid::new("noTestCases", "invalid")),
+        );
+        let address = server(state).await;
+
+        let (_, result) = call(&address, "GetResult", &task_request(id)).await;
+        let (messages, progress) = call(&address, "StreamProgress", &task_request(id)).await;
+
+        assert_eq!(result["grpc-status"], "3");
+        assert_eq!(progress["grpc-status"], "0");
+        let events: Vec<Value> = messages
+            .iter()
+            .map(|message| proto::decode(message, proto::PROGRESS).unwrap())
+            .collect();
+        assert_eq!(events.len(), 2);
+        assert_eq!(events[0]["event"], "queued");
+        assert_eq!(events[1]["event"], "finished");
+        assert_eq!(events[1]["status"], "finished");
+    }
+}
@@ -0,0 +1,595 @@
+use crate::{
+    error::DecodeError,
+    files,
+    model::{SubmissionResult, TestCaseFailureReason, TestResult},
+};
+use base64::{engine::general_purpose::STANDARD, Engine};
+use serde_json::{json, Map, Value};
+
+const VARINT: u8 = 0;
+const FIXED64: u8 = 1;
+const LENGTH_DELIMITED: u8 = 2;
+const FIXED32: u8 = 5;
+
+/// A field of a message in `proto/judge.proto`, named by the key of the same field in the JSON of the HTTP interface.
+///
+/// Messages are converted to and from their JSON, so both interfaces share the same types, defaults and validation.
+pub struct Field {
+    number: u32,
+    name: &'static str,
+    kind: Kind,
+    repeated: bool,
+    /// Whether the field is present in the JSON even if it is left out of the message, with its default value.
+    required: bool,
+}
+
+#[derive(Clone, Copy)]
+enum Kind {
+    String,
+    Uint,
+    Bool,
+    Double,
+    /// Bytes, which are base64 in the JSON like the files of a solution.
+    Bytes,
+    Message(&'static [Field]),
+    /// A map, whose entries are messages with a `key` and a `value` on the wire, and an object in the JSON.
+    Map(&'static [Field]),
+}
+
+const fn field(number: u32, name: &'static str, kind: Kind) -> Field {
+    Field {
+        number,
+        name,
+        kind,
+        repeated: false,
+        required: false,
+    }
+}
+
+const fn required(number: u32, name: &'static str, kind: Kind) -> Field {
+    Field {
+        required: true,
+        ..field(number, name, kind)
+    }
+}
+
+const fn repeated(number: u32, name: &'static str, fields: &'static [Field]) -> Field {
+    Field {
+        repeated: true,
+        ..field(number, name, Kind::Message(fields))
+    }
+}
+
+pub const SUBMISSION: &[Field] = &[
+    field(1, "language", Kind::String),
+    required(2, "solution", Kind::String),
+    repeated(3, "testCases", TEST_CASE),
+    field(4, "memoryLimit", Kind::Uint),
+    field(5, "checker", Kind::Message(CHECKER)),
+    field(6, "exerciseId", Kind::String),
+    field(7, "analyze", Kind::Bool),
+    repeated(8, "groups", TEST_GROUP),
+    field(9, "scoring", Kind::String),
+    field(10, "interactor", Kind::Message(INTERACTOR)),
+    field(11, "files", Kind::Map(FILE)),
+    field(12, "timeLimit", Kind::Uint),
+    field(13, "processLimit", Kind::Uint),
+    field(14, "diskLimit", Kind::Uint),
+];
+
+const FILE: &[Field] = &[
+    required(1, "key", Kind::String),
+    required(2, "value", Kind::Bytes),
+];
+
+const TEST_CASE: &[Field] = &[
+    required(1, "id", Kind::Uint),
+    field(2, "name", Kind::String),
+    repeated(3, "inputParameters", PARAMETER),
+    repeated(4, "outputParameters", PARAMETER),
+    field(5, "weight", Kind::Uint),
+    field(6, "hidden", Kind::Bool),
+    field(7, "timeLimit", Kind::Uint),
+    field(8, "comparison", Kind::String),
+    field(9, "epsilon", Kind::Double),
+    field(10, "group", Kind::String),
+    Field {
+        repeated: true,
+        ..field(11, "fixtures", Kind::String)
+    },
+    field(12, "stdin", Kind::String),
+    field(13, "env", Kind::Map(ENV)),
+    field(14, "workDir", Kind::Map(FILE)),
+];
+
+const ENV: &[Field] = &[
+    required(1, "key", Kind::String),
+    required(2, "value", Kind::String),
+];
+
+const TEST_GROUP: &[Field] = &[
+    field(1, "name", Kind::String),
+    field(2, "weight", Kind::Uint),
+];
+
+const PARAMETER: &[Field] = &[
+    required(1, "valueType", Kind::String),
+    required(2, "value", Kind::String),
+];
+
+const CHECKER: &[Field] = &[
+    field(1, "language", Kind::String),
+    required(2, "source", Kind::String),
+];
+
+const INTERACTOR: &[Field] = &[
+    field(1, "language", Kind::String),
+    required(2, "source", Kind::String),
+];
+
+pub const TASK: &[Field] = &[
+    field(1, "id", Kind::String),
+    field(2, "queuePosition", Kind::Uint),
+];
+
+pub const TASK_REQUEST: &[Field] = &[required(1, "id", Kind::String)];
+
+pub const TASK_RESULT: &[Field] = &[
+    field(1, "status", Kind::String),
+    field(2, "result", Kind::Message(SUBMISSION_RESULT)),
+];
+
+const SUBMISSION_RESULT: &[Field] = &[
+    field(1, "verdict", Kind::String),
+    field(2, "compileOutput", Kind::String),
+    repeated(3, "testCaseResults", TEST_CASE_RESULT),
+    field(4, "analysis", Kind::Message(ANALYSIS)),
+    field(5, "score", Kind::Double),
+    repeated(6, "groups", GROUP_SCORE),
+    repeated(7, "diagnostics", DIAGNOSTIC),
+];
+
+const TEST_CASE_RESULT: &[Field] = &[
+    field(1, "id", Kind::Uint),
+    field(2, "name", Kind::String),
+    field(3, "testResult", Kind::String),
+    field(4, "actual", Kind::String),
+    field(5, "expected", Kind::String),
+    field(6, "stderr", Kind::String),
+    field(7, "runtime", Kind::Uint),
+    field(8, "memory", Kind::Uint),
+    field(9, "userTime", Kind::Uint),
+    field(10, "systemTime", Kind::Uint),
+    field(11, "hidden", Kind::Bool),
+    field(12, "diff", Kind::String),
+    field(13, "cause", Kind::String),
+    field(14, "message", Kind::String),
+    field(15, "trace", Kind::String),
+    field(16, "encoding", Kind::String),
+];
+
+const GROUP_SCORE: &[Field] = &[
+    field(1, "name", Kind::String),
+    field(2, "score", Kind::Double),
+];
+
+const ANALYSIS: &[Field] = &[
+    field(1, "linter", Kind::String),
+    repeated(2, "diagnostics", DIAGNOSTIC),
+];
+
+const DIAGNOSTIC: &[Field] = &[
+    field(1, "severity", Kind::String),
+    field(2, "line", Kind::Uint),
+    field(3, "column", Kind::Uint),
+    field(4, "message", Kind::String),
+    field(5, "file", Kind::String),
+];
+
+pub const PROGRESS: &[Field] = &[
+    field(1, "event", Kind::String),
+    field(2, "position", Kind::Uint),
+    field(3, "cached", Kind::Bool),
+    field(4, "index", Kind::Uint),
+    field(5, "total", Kind::Uint),
+    field(6, "id", Kind::Uint),
+    field(7, "passed", Kind::Bool),
+    field(8, "status", Kind::String),
+];
+
+/// Decodes a message into its JSON, skipping fields which are not part of the message.
+pub fn decode(mut bytes: &[u8], fields: &[Field]) -> Result<Value, DecodeError> {
+    let mut object = Map::new();
+
+    while !bytes.is_empty() {
+        let key = varint(&mut bytes)?;
+        let (number, wire_type) = ((key >> 3) as u32, (key & 7) as u8);
+        let wire = match wire_type {
+            VARINT => Wire::Varint(varint(&mut bytes)?),
+            FIXED64 => Wire::Fixed(take(&mut bytes, 8)?),
+            LENGTH_DELIMITED => {
+                let len = varint(&mut bytes)? as usize;
+                Wire::Bytes(take(&mut bytes, len)?)
+            }
+            FIXED32 => Wire::Fixed(take(&mut bytes, 4)?),
+            _ => return Err(DecodeError::WireType(number, wire_type)),
+        };
+
+        let Some(field) = fields.iter().find(|field| field.number == number) else {
+            continue;
+        };
+        let value = match (field.kind, wire) {
+            (Kind::String, Wire::Bytes(bytes)) => std::str::from_utf8(bytes)
+                .map(Value::from)
+                .map_err(|_| DecodeError::Utf8(number))?,
+            (Kind::Uint, Wire::Varint(value)) => Value::from(value),
+            (Kind::Bool, Wire::Varint(value)) => Value::from(value != 0),
+            (Kind::Double, Wire::Fixed(bytes)) if bytes.len() == 8 => Value::from(
+                f64::from_le_bytes(bytes.try_into().expect("the length was checked")),
+            ),
+            (Kind::Bytes, Wire::Bytes(bytes)) => Value::from(STANDARD.encode(bytes)),
+            (Kind::Message(fields) | Kind::Map(fields), Wire::Bytes(bytes)) => {
+                decode(bytes, fields)?
+            }
+            _ => return Err(DecodeError::WireType(number, wire_type)),
+        };
+
+        if let Kind::Map(_) = field.kind {
+            match object
+                .entry(field.name)
+                .or_insert_with(|| Value::Object(Map::new()))
+            {
+                Value::Object(entries) => {
+                    let key = value["key"].as_str().unwrap_or_default().to_string();
+                    entries.insert(key, value["value"].clone());
+                }
+                _ => unreachable!("maps are always objects"),
+            }
+        } else if field.repeated {
+            match object
+                .entry(field.name)
+                .or_insert_with(|| Value::Array(Vec::new()))
+            {
+                Value::Array(values) => values.push(value),
+                _ => unreachable!("repeated fields are always arrays"),
+            }
+        } else {
+            // the last occurence of a field wins, like in every other protobuf implementation
+            object.insert(field.name.to_string(), value);
+        }
+    }
+
+    for field in fields {
+        if object.contains_key(field.name) {
+            continue;
+        }
+        let default = match field.kind {
+            _ if field.repeated => Value::Array(Vec::new()),
+            _ if !field.required => continue,
+            Kind::String => Value::from(""),
+            Kind::Uint => Value::from(0),
+            Kind::Bool => Value::from(false),
+            Kind::Double => Value::from(0.0),
+            Kind::Bytes => Value::from(""),
+            Kind::Message(fields) => decode(&[], fields)?,
+            Kind::Map(_) => Value::Object(Map::new()),
+        };
+        object.insert(field.name.to_string(), default);
+    }
+
+    Ok(Value::Object(object))
+}
+
+/// Encodes the JSON of a message, leaving out fields which are missing or have their default value.
+pub fn encode(value: &Value, fields: &[Field]) -> Vec<u8> {
+    let mut bytes = Vec::new();
+
+    for field in fields {
+        let entries;
+        let values = match &value[field.name] {
+            Value::Array(values) if field.repeated => values.iter().collect(),
+            Value::Object(map) if matches!(field.kind, Kind::Map(_)) => {
+                entries = map
+                    .iter()
+                    .map(|(key, value)| json!({ "key": key, "value": value }))
+                    .collect::<Vec<_>>();
+                entries.iter().collect()
+            }
+            Value::Null => Vec::new(),
+            value => vec![value],
+        };
+
+        for value in values {
+            match field.kind {
+                Kind::String => match value.as_str() {
+                    Some(value) if !value.is_empty() => {
+                        write_key(&mut bytes, field.number, LENGTH_DELIMITED);
+                        write_varint(&mut bytes, value.len() as u64);
+                        bytes.extend_from_slice(value.as_bytes());
+                    }
+                    _ => {}
+                },
+                Kind::Uint => match value.as_u64() {
+                    Some(value) if value != 0 => {
+                        write_key(&mut bytes, field.number, VARINT);
+                        write_varint(&mut bytes, value);
+                    }
+                    _ => {}
+                },
+                Kind::Bool => {
+                    if value.as_bool() == Some(true) {
+                        write_key(&mut bytes, field.number, VARINT);
+                        write_varint(&mut bytes, 1);
+                    }
+                }
+                Kind::Double => match value.as_f64() {
+                    Some(value) if value != 0.0 => {
+                        write_key(&mut bytes, field.number, FIXED64);
+                        bytes.extend_from_slice(&value.to_le_bytes());
+                    }
+                    _ => {}
+                },
+                Kind::Bytes => match value.as_str().and_then(files::decode) {
+                    Some(value) if !value.is_empty() => {
+                        write_key(&mut bytes, field.number, LENGTH_DELIMITED);
+                        write_varint(&mut bytes, value.len() as u64);
+                        bytes.extend(value);
+                    }
+                    _ => {}
+                },
+                Kind::Message(fields) | Kind::Map(fields) => {
+                    let message = encode(value, fields);
+                    write_key(&mut bytes, field.number, LENGTH_DELIMITED);
+                    write_varint(&mut bytes, message.len() as u64);
+                    bytes.extend(message);
+                }
+            }
+        }
+    }
+
+    bytes
+}
+
+/// Converts a result into the JSON of its message, where the result of every test case is a single string.
+pub fn submission_result(result: &SubmissionResult) -> Value {
+    let test_case_results: Vec<_> = result
+        .test_case_results
+        .iter()
+        .map(|test_case_result| {
+            let (test_result, actual, expected) = match &test_case_result.test_result {
+                TestResult::Pass => ("pass", "", ""),
+                TestResult::Unknown => ("unknown", "", ""),
+                TestResult::Skipped => ("skipped", "", ""),
+                TestResult::Failure(TestCaseFailureReason::WrongAnswer {
+                    actual,
+                    expected,
+                    ..
+                }) => ("wrongAnswer", actual.as_str(), expected.as_str()),
+                TestResult::Failure(TestCaseFailureReason::RuntimeError) => {
+                    ("runtimeError", "", "")
+                }
+                TestResult::Failure(TestCaseFailureReason::TimeLimitExceeded) => {
+                    ("timeLimitExceeded", "", "")
+                }
+                TestResult::Failure(TestCaseFailureReason::MemoryLimitExceeded) => {
+                    ("memoryLimitExceeded", "", "")
+                }
+                TestResult::Failure(TestCaseFailureReason::OutputLimitExceeded) => {
+                    ("outputLimitExceeded", "", "")
+                }
+                TestResult::Failure(TestCaseFailureReason::SecurityViolation) => {
+                    ("securityViolation", "", "")
+                }
+                TestResult::Failure(TestCaseFailureReason::WrongInteraction) => {
+                    ("wrongInteraction", "", "")
+                }
+                TestResult::Failure(TestCaseFailureReason::IdlenessLimitExceeded) => {
+                    ("idlenessLimitExceeded", "", "")
+                }
+                TestResult::Failure(TestCaseFailureReason::TestFailed { output, .. }) => {
+                    ("testFailed", output.as_str(), "")
+                }
+            };
+
+            let (diff, encoding) = match &test_case_result.test_result {
+                TestResult::Failure(TestCaseFailureReason::WrongAnswer {
+                    diff, encoding, ..
+                }) => (diff.as_deref(), *encoding),
+                _ => (None, None),
+            };
+            let (message, trace) = match &test_case_result.test_result {
+                TestResult::Failure(TestCaseFailureReason::TestFailed {
+                    message, trace, ..
+                }) => (message.as_deref(), trace.as_deref()),
+                _ => (None, None),
+            };
+
+            json!({
+                "id": test_case_result.id,
+                "name": test_case_result.name,
+                "testResult": test_result,
+                "actual": actual,
+                "expected": expected,
+                "diff": diff,
+                "encoding": encoding,
+                "stderr": test_case_result.stderr,
+                "runtime": test_case_result.runtime,
+                "memory": test_case_result.memory,
+                "userTime": test_case_result.user_time,
+                "systemTime": test_case_result.system_time,
+                "hidden": test_case_result.hidden,
+                "cause": test_case_result.cause,
+                "message": message,
+                "trace": trace,
+            })
+        })
+        .collect();
+
+    json!({
+        "verdict": result.verdict,
+        "compileOutput": result.compile_output,
+        "testCaseResults": test_case_results,
+        "analysis": result.analysis,
+        "score": result.score,
+        "groups": result.groups,
+        "diagnostics": result.diagnostics,
+    })
+}
+
+/// The payload of a field on the wire, before it is interpreted by the type of the field.
+enum Wire<'a> {
+    Varint(u64),
+    Fixed(&'a [u8]),
+    Bytes(&'a [u8]),
+}
+
+fn varint(bytes: &mut &[u8]) -> Result<u64, DecodeError> {
+    let mut value = 0;
+
+    for shift in (0..64).step_by(7) {
+        let (&byte, rest) = bytes.split_first().ok_or(DecodeError::Truncated)?;
+        *bytes = rest;
+        value |= u64::from(byte & 0x7f) << shift;
+        if byte & 0x80 == 0 {
+            return Ok(value);
+        }
+    }
+
+    // a varint is never longer than 10 bytes
+    Err(DecodeError::Truncated)
+}
+
+fn take<'a>(bytes: &mut &'a [u8], len: usize) -> Result<&'a [u8], DecodeError> {
+    if bytes.len() < len {
+        return Err(DecodeError::Truncated);
+    }
+    let (taken, rest) = bytes.split_at(len);
+    *bytes = rest;
+
+    Ok(taken)
+}
+
+fn write_key(bytes: &mut Vec<u8>, number: u32, wire_type: u8) {
+    write_varint(bytes, u64::from(number) << 3 | u64::from(wire_type));
+}
+
+fn write_varint(bytes: &mut Vec<u8>, mut value: u64) {
+    while value >= 0x80 {
+        bytes.push(value as u8 | 0x80);
+        value >>= 7;
+    }
+    bytes.push(value as u8);
+}
+
+#[cfg(test)]
+mod codec {
+    use super::{decode, encode, submission_result, SUBMISSION, SUBMISSION_RESULT, TASK};
+    use crate::{
+        error::DecodeError,
+        model::{
+            Encoding, Submission, SubmissionResult, TestCaseFailureReason, TestCaseResult,
+            TestResult,
+        },
+    };
+    use serde_json::json;
+    use std::collections::BTreeMap;
+
+    #[test]
+    fn submission_round_trip() {
+        let expected = json!({
+            "language": "python",
+            "solution": "def solution(): return 5",
+            "testCases": [{
+                "id": 3,
+                "inputParameters": [],
+                "outputParameters": [{ "valueType": "int", "value": "5" }],
+                "hidden": true,
+                "epsilon": 0.5,
+                "fixtures": ["graph.txt"]
+            }],
+            "memoryLimit": 128,
+            "groups": [],
+            "files": { "helper.py": "ZGVmIGhlbHAoKTogcGFzcwo=", "util/__init__.py": "" }
+        });
+
+        let actual = decode(&encode(&expected, SUBMISSION), SUBMISSION).unwrap();
+
+        assert_eq!(actual, expected);
+        assert!(serde_json::from_value::<Submission>(actual).is_ok());
+    }
+
+    #[test]
+    fn encoded_wrong_answer() {
+        let result = SubmissionResult::checked(
+            String::new(),
+            Box::new([TestCaseResult {
+                id: 0,
+                name: None,
+                test_result: TestResult::Failure(TestCaseFailureReason::WrongAnswer {
+                    input_parameters: Box::new([]),
+                    actual: String::from("AP8="),
+                    expected: String::from("AAE="),
+                    diff: None,
+                    encoding: Some(Encoding::Base64),
+                }),
+                hidden: false,
+                stderr: String::new(),
+                runtime: 5,
+                memory: None,
+                user_time: None,
+                system_time: None,
+                cause: None,
+                benchmark: None,
+                attachments: BTreeMap::new(),
+            }]),
+        );
+
+        let message = submission_result(&result);
+        let actual = decode(&encode(&message, SUBMISSION_RESULT), SUBMISSION_RESULT).unwrap();
+
+        let test_case_result = &actual["testCaseResults"][0];
+        assert_eq!(test_case_result["testResult"], "wrongAnswer");
+        assert_eq!(test_case_result["actual"], "AP8=");
+        assert_eq!(test_case_result["encoding"], "base64");
+    }
+
+    #[test]
+    fn defaults_of_left_out_fields() {
+        let actual = decode(&[], SUBMISSION).unwrap();
+
+        assert_eq!(
+            actual,
+            json!({ "solution": "", "testCases": [], "groups": [] })
+        );
+    }
+
+    #[test]
+    fn skips_unknown_fields() {
+        // field 15 is a varint and field 16 is a string, neither of which is part of a task
+        let bytes = [0x78, 0x01, 0x82, 0x01, 0x01, b'x', 0x10, 0x07];
+
+        let actual = decode(&bytes, TASK).unwrap();
+
+        assert_eq!(actual, json!({ "queuePosition": 7 }));
+    }
+
+    #[test]
+    fn truncated() {
+        // the string claims a length of 5, but only has 2 bytes
+        let bytes = [0x0a, 0x05, b'h', b'i'];
+
+        let actual = decode(&bytes, TASK);
+
+        assert_eq!(actual, Err(DecodeError::Truncated));
+    }
+
+    #[test]
+    fn unexpected_wire_type() {
+        // the id of a task is a string, but is given as a varint
+        let bytes = [0x08, 0x01];
+
+        let actual = decode(&bytes, TASK);
+
+        assert_eq!(actual, Err(DecodeError::WireType(1, 0)));
+    }
+}
@@ -0,0 +1,155 @@
+use crate::{config::Config, toolchain, AppState};
+use serde::Serialize;
+use std::{collections::HashMap, fs, process::Stdio, time::Duration};
+use tokio::{process::Command, task, time};
+use uuid::Uuid;
+
+/// How long the sandbox runtime may take to respond, before it is considered unreachable.
+const SANDBOX_TIMEOUT: Duration = Duration::from_secs(5);
+
+/// Whether mozart can check submissions right now, along with the individual checks it depends on.
+#[derive(Serialize)]
+pub struct Readiness {
+    pub ready: bool,
+    checks: Vec<Check>,
+    /// How far preheating the toolchain of every supported language got, which is only informational, as a cold
+    /// toolchain still checks submissions, if slower at first.
+    languages: Vec<toolchain::Readiness>,
+}
+
+/// A single dependency of checking submissions.
+#[derive(Serialize)]
+struct Check {
+    name: &'static str,
+    ok: bool,
+    /// Why the check failed, which is left out if it did not.
+    #[serde(skip_serializing_if = "Option::is_none")]
+    error: Option<String>,
+}
+
+impl Check {
+    fn new(name: &'static str, result: Result<(), String>) -> Self {
+        Self {
+            name,
+            ok: result.is_ok(),
+            error: result.err(),
+        }
+    }
+}
+
+/// Checks whether submissions which are accepted now would be checked, rather than failing or waiting indefinitely.
+///
+/// The store is only checked if one is configured, and the toolchains only if they are tested on startup.
+pub async fn readiness(state: &AppState) -> Readiness {
+    let mut checks = vec![
+        Check::new("sandbox", sandbox(&state.config).await),
+        Check::new("workspace", workspace(state).await),
+        Check::new("queue", queue(state)),
+        Check::new("disk", disk(state).await),
+    ];
+    if let Some(store) = state.jobs.store() {
+        let result = task::spawn_blocking(move || store.check())
+            .await
+            .map_err(|err| err.to_string())
+            .and_then(|result| result.map_err(|err| err.to_string()));
+        checks.push(Check::new("store", result));
+    }
+    if state.config.self_test {
+        checks.push(Check::new("toolchains", state.toolchains.check()));
+    }
+
+    Readiness {
+        ready: checks.iter().all(|check| check.ok),
+        checks,
+        languages: state.toolchains.readiness(),
+    }
+}
+
+/// Checks that the runtime executing commands is reachable, which is always the case on the host.
+///
+/// A docker runtime has to be registered with the daemon as well, except for containerd shims like
+/// `io.containerd.kata-fc.v2`, which docker only looks for once a container is started.
+async fn sandbox(config: &Config) -> Result<(), String> {
+    if !config.sandbox.is_docker() {
+        return Ok(());
+    }
+
+    // only the client is available if the daemon is unreachable, in which case docker exits with an error
+    docker(&["version", "--format", "{{.Server.Version}}"]).await?;
+
+    let Some(runtime) = config
+        .sandbox_runtime()
+        .filter(|runtime| !runtime.starts_with("io.containerd."))
+    else {
+        return Ok(());
+    };
+    let runtimes = docker(&["info", "--format", "{{json .Runtimes}}"]).await?;
+    let runtimes: HashMap<String, serde_json::Value> =
+        serde_json::from_slice(&runtimes).unwrap_or_default();
+
+    match runtimes.contains_key(runtime) {
+        true => Ok(()),
+        false => Err(format!("the docker runtime {runtime} is not registered")),
+    }
+}
+
+/// Runs docker with the arguments, returning its standard output if it succeeded.
+async fn docker(args: &[&str]) -> Result<Vec<u8>, String> {
+    let output = Command::new("docker")
+        .args(args)
+        .stdin(Stdio::null())
+        .stdout(Stdio::piped())
+        .stderr(Stdio::null())
+        .kill_on_drop(true)
+        .output();
+
+    match time::timeout(SANDBOX_TIMEOUT, output).await {
+        Ok(Ok(output)) if output.status.success() => Ok(output.stdout),
+        Ok(Ok(output)) => Err(format!(
+            "the docker daemon is unreachable: {}",
+            output.status
+        )),
+        Ok(Err(err)) => Err(format!("failed to run docker: {err}")),
+        Err(_) => Err(String::from("the docker daemon did not respond in time")),
+    }
+}
+
+/// Checks that workspaces can be created, by writing and removing a file in the workspace directory.
+async fn workspace(state: &AppState) -> Result<(), String> {
+    let dir = state.config.workspace_dir();
+
+    task::spawn_blocking(move || {
+        let probe = dir.join(format!(".ready-{}", Uuid::new_v4()));
+        fs::create_dir_all(&dir)
+            .and_then(|_| fs::write(&probe, []))
+            .and_then(|_| fs::remove_file(&probe))
+            .map_err(|err| format!("{} is not writable: {err}", dir.display()))
+    })
+    .await
+    .map_err(|err| err.to_string())?
+}
+
+/// Checks that the volumes mozart writes to have enough free space, which stops or resumes admitting submissions as
+/// the background monitor would.
+async fn disk(state: &AppState) -> Result<(), String> {
+    let disk = state.disk.clone();
+
+    task::spawn_blocking(move || disk.check())
+        .await
+        .map_err(|err| err.to_string())?
+}
+
+/// Checks that the worker pool admits submissions, as it is not full, paused, or shutting down.
+fn queue(state: &AppState) -> Result<(), String> {
+    if state.pool.is_shutting_down() {
+        return Err(String::from("shutting down"));
+    }
+    if state.pool.is_paused() {
+        return Err(String::from("paused for maintenance"));
+    }
+    if state.pool.is_saturated() {
+        return Err(String::from("the queue is full"));
+    }
+
+    Ok(())
+}
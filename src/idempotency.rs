@@ -0,0 +1,214 @@
+use crate::{
+    config::Config,
+    model::Submission,
+    response::{Invalid, SubmitResponse},
+};
+use sha2::{Digest, Sha256};
+use std::{
+    collections::HashMap,
+    sync::Mutex,
+    time::{Duration, Instant},
+};
+use uuid::Uuid;
+
+/// The header a client sends with a submission, so that retrying the submission does not check it again.
+pub const IDEMPOTENCY_KEY_HEADER: &str = "idempotency-key";
+
+/// The longest idempotency key which is accepted.
+const MAX_KEY_LEN: usize = 255;
+
+/// The number of keys above which expired keys are dropped, to bound the memory of the keys.
+const PRUNE_THRESHOLD: usize = 1024;
+
+/// The idempotency keys of accepted submissions, each referring to the job it was first used for until it expires.
+pub struct IdempotencyKeys {
+    /// How long a key refers to its job, where zero ignores every key.
+    window: Duration,
+    keys: Mutex<HashMap<String, Key>>,
+}
+
+struct Key {
+    id: Uuid,
+    queue_position: usize,
+    /// The digest of the submission the key was first used for, so the key cannot be reused for another submission.
+    fingerprint: [u8; 32],
+    created: Instant,
+}
+
+/// A submission which was accepted under an idempotency key.
+#[derive(Debug, PartialEq)]
+pub enum Idempotent {
+    /// The key was not used before, so a new job was created with the given id and queue position.
+    Accepted(Uuid, usize),
+
+    /// The key was used before, for the job with the given id and the queue position it was accepted with.
+    Replayed(Uuid, usize),
+}
+
+impl IdempotencyKeys {
+    pub fn new(window: Duration) -> Self {
+        Self {
+            window,
+            keys: Mutex::default(),
+        }
+    }
+
+    /// Creates the keys configured by the idempotency window.
+    pub fn from_config(config: &Config) -> Self {
+        Self::new(config.idempotency_window())
+    }
+
+    /// Accepts the submission under the key, replaying the job the key was first used for if it has not expired, and
+    /// otherwise creating a job with `accept`.
+    ///
+    /// The keys are locked while accepting, so a retry which arrives while the first attempt is being accepted waits
+    /// for it rather than creating a job of its own. A key is only used once its job is created, so a submission which
+    /// is rejected can be retried under the same key.
+    pub fn accept(
+        &self,
+        key: &str,
+        submission: Submission,
+        now: Instant,
+        accept: impl FnOnce(Submission) -> Result<(Uuid, usize), SubmitResponse>,
+    ) -> Result<Idempotent, SubmitResponse> {
+        if self.window.is_zero() {
+            return accept(submission)
+                .map(|(id, queue_position)| Idempotent::Accepted(id, queue_position));
+        }
+        if !is_valid_key(key) {
+            return Err(SubmitResponse::InvalidSubmission(Invalid::new(
+                "invalidIdempotencyKey",
+                format!("the {IDEMPOTENCY_KEY_HEADER} header must be between 1 and {MAX_KEY_LEN} visible ASCII characters"),
+            )));
+        }
+
+        let fingerprint = fingerprint(&submission);
+        let mut keys = self
+            .keys
+            .lock()
+            .expect("idempotency lock should not be poisoned");
+
+        if let Some(used) = keys.get(key).filter(|used| !self.expired(used, now)) {
+            if used.fingerprint != fingerprint {
+                return Err(SubmitResponse::InvalidSubmission(Invalid::new(
+                    "idempotencyKeyReused",
+                    "the idempotency key was already used for a different submission",
+                )));
+            }
+
+            return Ok(Idempotent::Replayed(used.id, used.queue_position));
+        }
+
+        let (id, queue_position) = accept(submission)?;
+        if keys.len() >= PRUNE_THRESHOLD {
+            keys.retain(|_, used| !self.expired(used, now));
+        }
+        keys.insert(
+            key.to_string(),
+            Key {
+                id,
+                queue_position,
+                fingerprint,
+                created: now,
+            },
+        );
+
+        Ok(Idempotent::Accepted(id, queue_position))
+    }
+
+    fn expired(&self, key: &Key, now: Instant) -> bool {
+        now.saturating_duration_since(key.created) >= self.window
+    }
+}
+
+/// Whether the key can be used, which is a short string of visible ASCII characters, like a UUID.
+fn is_valid_key(key: &str) -> bool {
+    !key.is_empty() && key.len() <= MAX_KEY_LEN && key.bytes().all(|byte| byte.is_ascii_graphic())
+}
+
+/// Gets the digest of the submission as it would be persisted with its job.
+fn fingerprint(submission: &Submission) -> [u8; 32] {
+    let json = serde_json::to_vec(submission).unwrap_or_default();
+
+    Sha256::digest(json).into()
+}
+
+#[cfg(test)]
+mod keys {
+    use super::{IdempotencyKeys, Idempotent};
+    use crate::{model::Submission, response::SubmitResponse};
+    use std::time::{Duration, Instant};
+    use uuid::Uuid;
+
+    fn submission(solution: &str) -> Submission {
+        serde_json::from_value(serde_json::json!({ "solution": solution, "testCases": [] }))
+            .unwrap()
+    }
+
+    #[test]
+    fn replays_job() {
+        let keys = IdempotencyKeys::new(Duration::from_secs(60));
+        let now = Instant::now();
+        let id = Uuid::new_v4();
+
+        let first = keys.accept("retry", submission("a"), now, |_| Ok((id, 3)));
+        let second = keys.accept("retry", submission("a"), now, |_| {
+            panic!("a replayed submission is not accepted again")
+        });
+
+        assert_eq!(first.ok(), Some(Idempotent::Accepted(id, 3)));
+        assert_eq!(second.ok(), Some(Idempotent::Replayed(id, 3)));
+    }
+
+    #[test]
+    fn expires() {
+        let keys = IdempotencyKeys::new(Duration::from_secs(60));
+        let now = Instant::now();
+        let (first, second) = (Uuid::new_v4(), Uuid::new_v4());
+
+        let _ = keys.accept("retry", submission("a"), now, |_| Ok((first, 0)));
+        let actual = keys.accept(
+            "retry",
+            submission("a"),
+            now + Duration::from_secs(60),
+            |_| Ok((second, 0)),
+        );
+
+        assert_eq!(actual.ok(), Some(Idempotent::Accepted(second, 0)));
+    }
+
+    #[test]
+    fn different_submission() {
+        let keys = IdempotencyKeys::new(Duration::from_secs(60));
+        let now = Instant::now();
+
+        let _ = keys.accept("retry", submission("a"), now, |_| Ok((Uuid::new_v4(), 0)));
+        let actual = keys.accept("retry", submission("b"), now, |_| Ok((Uuid::new_v4(), 0)));
+
+        assert!(matches!(actual, Err(SubmitResponse::InvalidSubmission(_))));
+    }
+
+    #[test]
+    fn rejected_submission_is_not_kept() {
+        let keys = IdempotencyKeys::new(Duration::from_secs(60));
+        let now = Instant::now();
+        let id = Uuid::new_v4();
+
+        let first = keys.accept("retry", submission("a"), now, |_| Err(SubmitResponse::Busy));
+        let second = keys.accept("retry", submission("a"), now, |_| Ok((id, 0)));
+
+        assert!(matches!(first, Err(SubmitResponse::Busy)));
+        assert_eq!(second.ok(), Some(Idempotent::Accepted(id, 0)));
+    }
+
+    #[test]
+    fn invalid_key() {
+        let keys = IdempotencyKeys::new(Duration::from_secs(60));
+
+        let actual = keys.accept("with space", submission("a"), Instant::now(), |_| {
+            Ok((Uuid::new_v4(), 0))
+        });
+
+        assert!(matches!(actual, Err(SubmitResponse::InvalidSubmission(_))));
+    }
+}
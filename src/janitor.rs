@@ -0,0 +1,153 @@
+use crate::{config::Config, workspace};
+use std::{
+    fs,
+    path::{Path, PathBuf},
+    time::{Duration, SystemTime},
+};
+use tracing::info;
+use uuid::Uuid;
+
+/// How often the janitor looks for workspaces to remove.
+const INTERVAL: Duration = Duration::from_secs(60);
+
+/// Removes every workspace in the parent directory which is older than `max_age`, returning how many were removed.
+///
+/// Only directories named by a uuid are considered workspaces, everything else is left alone.
+pub fn sweep(parent: &Path, max_age: Duration) -> usize {
+    let Ok(entries) = fs::read_dir(parent) else {
+        return 0;
+    };
+
+    let now = SystemTime::now();
+    let mut removed = 0;
+    for entry in entries.flatten() {
+        let is_workspace = entry
+            .file_name()
+            .to_str()
+            .is_some_and(|name| Uuid::parse_str(name).is_ok());
+        let Ok(metadata) = entry.metadata() else {
+            continue;
+        };
+        let age = metadata
+            .modified()
+            .ok()
+            .and_then(|modified| now.duration_since(modified).ok())
+            .unwrap_or_default();
+
+        if is_workspace
+            && metadata.is_dir()
+            && age >= max_age
+            && workspace::remove_left_behind(&entry.path()).is_ok()
+        {
+            removed += 1;
+        }
+    }
+
+    removed
+}
+
+/// Removes every workspace left behind by a previous run, then periodically removes workspaces in the background.
+///
+/// Workspaces are removed once they are older than the retention if it is set, and otherwise once they are orphaned.
+/// With a separate workspace directory, only orphaned workspaces are left in it, as retained ones are kept in the
+/// `work_dir`, which is also where the retained workspaces of tenants are kept.
+pub async fn start(config: &Config) {
+    let parents = parents(
+        config,
+        config
+            .workspace_retention()
+            .unwrap_or_else(|| config.workspace_ttl()),
+    );
+
+    // no submissions are being checked yet, so every workspace is left over
+    let startup_parents = parents.clone();
+    let removed = tokio::task::spawn_blocking(move || {
+        startup_parents
+            .iter()
+            .map(|(parent, _)| sweep(parent, Duration::ZERO))
+            .sum::<usize>()
+    })
+    .await
+    .unwrap_or_default();
+    if removed > 0 {
+        info!(removed, "removed left over workspaces");
+    }
+
+    tokio::spawn(async move {
+        let mut interval = tokio::time::interval(INTERVAL);
+        loop {
+            interval.tick().await;
+            let parents = parents.clone();
+            let removed = tokio::task::spawn_blocking(move || {
+                parents
+                    .iter()
+                    .map(|(parent, max_age)| sweep(parent, *max_age))
+                    .sum::<usize>()
+            })
+            .await
+            .unwrap_or_default();
+            if removed > 0 {
+                info!(removed, "removed expired workspaces");
+            }
+        }
+    });
+}
+
+/// Removes every workspace which is older than the workspace TTL right away, including those which are retained for
+/// debugging for longer, to free up space when the disk runs low, returning how many were removed.
+pub fn reclaim(config: &Config) -> usize {
+    parents(config, config.workspace_ttl())
+        .iter()
+        .map(|(parent, max_age)| sweep(parent, *max_age))
+        .sum()
+}
+
+/// Gets the directories workspaces are kept in, along with how old their workspaces may get, where the workspaces in
+/// the `work_dir` may get as old as the given retention.
+fn parents(config: &Config, retention: Duration) -> Vec<(PathBuf, Duration)> {
+    let mut parents = vec![(config.work_dir.clone(), retention)];
+    if config.workspace_dir() != config.work_dir {
+        parents.push((config.workspace_dir(), config.workspace_ttl()));
+    }
+    for name in config.tenants.keys() {
+        parents.push((config.tenant(name).workspace_dir(), config.workspace_ttl()));
+    }
+
+    parents
+}
+
+#[cfg(test)]
+mod sweep {
+    use super::sweep;
+    use std::{env, fs, time::Duration};
+    use uuid::Uuid;
+
+    #[test]
+    fn only_workspaces() {
+        let parent = env::temp_dir().join(format!("janitor-{}", Uuid::new_v4()));
+        let workspace = parent.join(Uuid::new_v4().to_string());
+        let other = parent.join("other");
+        fs::create_dir_all(&workspace).expect("failed to create workspace");
+        fs::create_dir_all(&other).expect("failed to create other directory");
+
+        let actual = sweep(&parent, Duration::ZERO);
+
+        assert_eq!(actual, 1);
+        assert!(!workspace.exists());
+        assert!(other.exists());
+        fs::remove_dir_all(parent).expect("failed to remove parent");
+    }
+
+    #[test]
+    fn young_workspaces() {
+        let parent = env::temp_dir().join(format!("janitor-{}", Uuid::new_v4()));
+        let workspace = parent.join(Uuid::new_v4().to_string());
+        fs::create_dir_all(&workspace).expect("failed to create workspace");
+
+        let actual = sweep(&parent, Duration::from_secs(60 * 60));
+
+        assert_eq!(actual, 0);
+        assert!(workspace.exists());
+        fs::remove_dir_all(parent).expect("failed to remove parent");
+    }
+}
@@ -0,0 +1,1384 @@
+use crate::{
+    audit::{AuditLog, Judgment, Submitter},
+    cancel::Cancellation,
+    crash::Crash,
+    error::{CancelError, RejudgeError, StoreError},
+    events::{Event, EventKind, Events},
+    model::{Submission, SubmissionLimits},
+    response::SubmitResponse,
+    revision::Revision,
+    store::Store,
+};
+use serde::{Deserialize, Serialize};
+use std::{
+    collections::{HashMap, HashSet},
+    sync::{Arc, Mutex},
+    time::{Duration, SystemTime, UNIX_EPOCH},
+};
+use tokio::sync::broadcast::{self, Receiver, Sender};
+use tracing::warn;
+use uuid::Uuid;
+
+/// The number of progress events a slow subscriber may fall behind, before it misses events.
+const PROGRESS_CAPACITY: usize = 64;
+
+/// The lifecycle status of an asynchronous job.
+#[derive(Serialize, Deserialize, Clone, Copy, PartialEq, Debug)]
+pub enum JobStatus {
+    /// The job has been accepted, but checking has not begun yet.
+    #[serde(rename = "queued")]
+    Queued,
+
+    /// The submission is currently being checked.
+    #[serde(rename = "running")]
+    Running,
+
+    /// The submission has been checked, and a result is available.
+    #[serde(rename = "finished")]
+    Finished,
+
+    /// An internal error occured while checking the submission.
+    #[serde(rename = "failed")]
+    Failed,
+
+    /// The job was cancelled before the submission was checked.
+    #[serde(rename = "cancelled")]
+    Cancelled,
+}
+
+impl JobStatus {
+    /// Whether the job is done, so it has a result which no longer changes unless it is rejudged.
+    pub fn is_done(self) -> bool {
+        matches!(self, Self::Finished | Self::Failed | Self::Cancelled)
+    }
+
+    /// Gets the status of a job which is done with the result, which is [`JobStatus::Failed`] if the result is an
+    /// internal error, the judgment timed out, or the job was cancelled by shutting down, [`JobStatus::Cancelled`] if
+    /// the job was cancelled on request, and [`JobStatus::Finished`] otherwise.
+    pub fn of(result: &SubmitResponse) -> Self {
+        match result {
+            SubmitResponse::Internal | SubmitResponse::Unavailable | SubmitResponse::TimedOut => {
+                Self::Failed
+            }
+            SubmitResponse::Cancelled => Self::Cancelled,
+            _ => Self::Finished,
+        }
+    }
+}
+
+/// An event in the progress of checking a submission, which is streamed to subscribers of the job.
+#[derive(Serialize, Deserialize, Clone, Debug, PartialEq)]
+#[serde(tag = "event", rename_all = "camelCase")]
+pub enum Progress {
+    /// The job is waiting for a worker, behind the given number of jobs.
+    Queued { position: usize },
+
+    /// The submission is being compiled.
+    Compiling,
+
+    /// The submission has been compiled, or its compilation was restored from the cache.
+    Compiled { cached: bool },
+
+    /// The test case at the given one-based index of `total` test cases is running.
+    Running { index: usize, total: usize, id: u64 },
+
+    /// The test case at the given one-based index of `total` test cases has finished running.
+    Ran {
+        index: usize,
+        total: usize,
+        id: u64,
+        passed: bool,
+    },
+
+    /// The job is done, and its result is available.
+    Finished { status: JobStatus },
+}
+
+impl Progress {
+    /// Gets the name of the event, which is the same as its `event` field.
+    pub fn name(&self) -> &'static str {
+        match self {
+            Progress::Queued { .. } => "queued",
+            Progress::Compiling => "compiling",
+            Progress::Compiled { .. } => "compiled",
+            Progress::Running { .. } => "running",
+            Progress::Ran { .. } => "ran",
+            Progress::Finished { .. } => "finished",
+        }
+    }
+}
+
+/// Everything known about a job, which is persisted once the job is done.
+#[derive(Serialize, Deserialize, Clone)]
+#[serde(rename_all = "camelCase")]
+pub struct JobRecord {
+    pub id: Uuid,
+    pub status: JobStatus,
+    /// The submission as it was sent.
+    pub submission: serde_json::Value,
+    /// When the job was accepted, in milliseconds since the unix epoch.
+    pub submitted_at: u64,
+    /// When a worker began checking the submission, in milliseconds since the unix epoch.
+    pub started_at: Option<u64>,
+    /// When the job was done, in milliseconds since the unix epoch.
+    pub finished_at: Option<u64>,
+    pub result: Option<SubmitResponse>,
+    /// Every progress event so far, so that late subscribers see the whole progress.
+    pub progress: Vec<Progress>,
+    /// The version of the result, starting at 1, which increases every time the job is rejudged.
+    #[serde(default = "first_version")]
+    pub version: u32,
+    /// The results of earlier versions, oldest first.
+    #[serde(default)]
+    pub previous: Vec<PreviousResult>,
+    /// The batch the job was submitted in, if it was submitted in one.
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub batch: Option<BatchEntry>,
+    /// When the job was archived, in milliseconds since the unix epoch, after which its results are compacted.
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub archived_at: Option<u64>,
+    /// The tenant the job belongs to, which is the only one who can see it, if it belongs to one.
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub tenant: Option<String>,
+    /// The panic the latest judgment of the job crashed with, which only admins may see, if it crashed.
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub crash: Option<Box<Crash>>,
+    /// Who submitted the job, and from where, which is left out of the jobs submitted before it was recorded.
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub submitter: Option<Submitter>,
+}
+
+impl JobRecord {
+    /// Leaves out the details of the hidden test cases from the results, and their parameters from the submission.
+    pub fn redact(&mut self) {
+        let test_cases = self.submission["testCases"].as_array_mut();
+        for test_case in test_cases.into_iter().flatten() {
+            if test_case["hidden"] == true {
+                test_case["inputParameters"] = serde_json::json!([]);
+                test_case["outputParameters"] = serde_json::json!([]);
+                for field in ["env", "workDir"] {
+                    if let Some(map) = test_case.get_mut(field) {
+                        *map = serde_json::json!({});
+                    }
+                }
+            }
+        }
+
+        let previous = self
+            .previous
+            .iter_mut()
+            .map(|previous| &mut previous.result);
+        for result in std::iter::once(&mut self.result).chain(previous).flatten() {
+            result.redact();
+        }
+    }
+
+    /// Whether the submission has a hidden test case.
+    pub fn has_hidden_test_cases(&self) -> bool {
+        self.submission["testCases"]
+            .as_array()
+            .is_some_and(|test_cases| {
+                test_cases
+                    .iter()
+                    .any(|test_case| test_case["hidden"] == true)
+            })
+    }
+
+    /// Compacts the record to be archived, leaving out its progress and the outputs of the test cases of its results.
+    pub fn compact(&mut self) {
+        self.progress.clear();
+        let previous = self
+            .previous
+            .iter_mut()
+            .map(|previous| &mut previous.result);
+        for result in std::iter::once(&mut self.result).chain(previous).flatten() {
+            result.compact();
+        }
+        self.archived_at = Some(now());
+    }
+}
+
+/// The batch a job was submitted in, along with the position of its submission in the batch.
+#[derive(Serialize, Deserialize, Clone, Copy, PartialEq, Debug)]
+pub struct BatchEntry {
+    pub id: Uuid,
+    pub index: usize,
+}
+
+/// The result of a job before it was rejudged.
+#[derive(Serialize, Deserialize, Clone)]
+#[serde(rename_all = "camelCase")]
+pub struct PreviousResult {
+    pub version: u32,
+    pub finished_at: Option<u64>,
+    pub result: Option<SubmitResponse>,
+}
+
+fn first_version() -> u32 {
+    1
+}
+
+struct Job {
+    record: JobRecord,
+    /// The sender of progress events to subscribers, which is dropped once the job is done to end their streams.
+    subscribers: Option<Sender<Progress>>,
+    /// The cancellation of the current judgment of the job, which is replaced when it is rejudged.
+    cancellation: Cancellation,
+}
+
+/// A store of all asynchronous jobs, shared between request handlers.
+///
+/// Jobs are kept in memory, and persisted to the [`Store`] once they are done if there is one, so that their results
+/// outlive a restart. Jobs which are not in memory are looked up in the store.
+///
+/// A store shared by the replicas of a cluster keeps only the jobs running on this replica in memory, and persists jobs
+/// whenever their status changes, so every replica sees the current status of every job.
+#[derive(Clone, Default)]
+pub struct JobStore {
+    jobs: Arc<Mutex<HashMap<Uuid, Job>>>,
+    store: Option<Arc<dyn Store>>,
+    shared: bool,
+    /// The log every judgment of a job is recorded in once it is done, if there is one.
+    audit: Option<Arc<AuditLog>>,
+    /// The event bus the lifecycle events of jobs are published to, if there is one.
+    events: Option<Arc<Events>>,
+}
+
+impl JobStore {
+    /// Creates a job store which persists finished jobs to the given store.
+    pub fn new(store: Option<Arc<dyn Store>>) -> Self {
+        Self {
+            jobs: Arc::default(),
+            store,
+            shared: false,
+            audit: None,
+            events: None,
+        }
+    }
+
+    /// Shares the store with the other replicas of a cluster.
+    pub fn shared(mut self) -> Self {
+        self.shared = true;
+        self
+    }
+
+    /// Records every judgment of a job in the audit log once it is done.
+    pub fn audited(mut self, audit: Arc<AuditLog>) -> Self {
+        self.audit = Some(audit);
+        self
+    }
+
+    /// Publishes the lifecycle events of every job to the event bus.
+    pub fn publishing(mut self, events: Arc<Events>) -> Self {
+        self.events = Some(events);
+        self
+    }
+
+    /// Gets the store finished jobs are persisted to, if there is one.
+    pub fn store(&self) -> Option<Arc<dyn Store>> {
+        self.store.clone()
+    }
+
+    /// Registers a new job of the submission of the tenant, if it has one, with the [`JobStatus::Queued`] status behind
+    /// `position` jobs, returning its id.
+    pub fn create(&self, tenant: Option<&str>, position: usize, submission: &Submission) -> Uuid {
+        self.create_in(tenant, position, submission, None)
+    }
+
+    /// Registers a new job of a submission of a batch, like [`JobStore::create`].
+    pub fn create_in_batch(
+        &self,
+        tenant: Option<&str>,
+        position: usize,
+        submission: &Submission,
+        batch: BatchEntry,
+    ) -> Uuid {
+        self.create_in(tenant, position, submission, Some(batch))
+    }
+
+    fn create_in(
+        &self,
+        tenant: Option<&str>,
+        position: usize,
+        submission: &Submission,
+        batch: Option<BatchEntry>,
+    ) -> Uuid {
+        let id = Uuid::new_v4();
+        let batch_id = batch.as_ref().map(|batch| batch.id);
+        let job = Job {
+            record: JobRecord {
+                id,
+                status: JobStatus::Queued,
+                submission: serde_json::to_value(submission).unwrap_or_default(),
+                submitted_at: now(),
+                started_at: None,
+                finished_at: None,
+                result: None,
+                progress: vec![Progress::Queued { position }],
+                version: first_version(),
+                previous: Vec::new(),
+                batch,
+                archived_at: None,
+                tenant: tenant.map(str::to_string),
+                crash: None,
+                submitter: None,
+            },
+            subscribers: Some(broadcast::channel(PROGRESS_CAPACITY).0),
+            cancellation: Cancellation::default(),
+        };
+
+        self.jobs
+            .lock()
+            .expect("job store lock poisoned")
+            .insert(id, job);
+        self.publish(
+            id,
+            tenant,
+            EventKind::JobQueued {
+                position,
+                language: submission.language,
+                exercise_id: submission.exercise_id.clone(),
+                batch_id,
+            },
+        );
+
+        id
+    }
+
+    /// Sets the status of the job with the given id, where [`JobStatus::Running`] marks when the job started.
+    pub fn set_status(&self, id: Uuid, status: JobStatus) {
+        let record = {
+            let mut jobs = self.jobs.lock().expect("job store lock poisoned");
+            let Some(job) = jobs.get_mut(&id) else {
+                return;
+            };
+
+            job.record.status = status;
+            if status == JobStatus::Running {
+                job.record.started_at = Some(now());
+            }
+            job.record.clone()
+        };
+
+        if status == JobStatus::Running {
+            self.publish(id, record.tenant.as_deref(), EventKind::JobStarted);
+        }
+        if self.shared {
+            self.persist(&record);
+        }
+    }
+
+    /// Records who submitted the job, and from where, before it is checked.
+    pub fn attribute(&self, id: Uuid, submitter: Submitter) {
+        if let Some(job) = self
+            .jobs
+            .lock()
+            .expect("job store lock poisoned")
+            .get_mut(&id)
+        {
+            job.record.submitter = Some(submitter);
+        }
+    }
+
+    /// Marks the job as done, storing the result, persisting the job if there is a store, and recording the judgment
+    /// in the audit log if there is one.
+    ///
+    /// The status becomes that of the result, as given by [`JobStatus::of`].
+    pub fn finish(&self, id: Uuid, mut result: SubmitResponse) {
+        // the earlier attempt is looked up without holding the lock, as it may have to be loaded from the store
+        let revised = {
+            let jobs = self.jobs.lock().expect("job store lock poisoned");
+            jobs.get(&id)
+                .filter(|job| !job.record.submission["previousTaskId"].is_null())
+                .map(|job| (job.record.tenant.clone(), job.record.submission.clone()))
+        };
+        if let Some((tenant, submission)) = revised {
+            self.revise(tenant.as_deref(), &submission, &mut result);
+        }
+
+        let record = {
+            let mut jobs = self.jobs.lock().expect("job store lock poisoned");
+            let Some(job) = jobs.get_mut(&id) else {
+                return;
+            };
+
+            job.record.status = JobStatus::of(&result);
+            if let (Some(timings), Some(started_at)) = (result.timings(), job.record.started_at) {
+                timings.queued = started_at.saturating_sub(job.record.submitted_at);
+            }
+            job.record.result = Some(result);
+            job.record.finished_at = Some(now());
+            job.report(Progress::Finished {
+                status: job.record.status,
+            });
+            job.subscribers = None;
+
+            job.record.clone()
+        };
+
+        if let Some(audit) = &self.audit {
+            audit.record(Judgment::from(&record));
+        }
+        let checked = match &record.result {
+            Some(SubmitResponse::Checked(result)) => Some(result),
+            _ => None,
+        };
+        self.publish(
+            id,
+            record.tenant.as_deref(),
+            EventKind::JobCompleted {
+                status: record.status,
+                verdict: checked.map(|result| result.verdict),
+                score: checked.map(|result| result.score),
+            },
+        );
+        // the job is persisted outside of the lock, so other jobs are not blocked by the store
+        if self.persist(&record) && self.shared {
+            // a job which was rejudged in the meantime is no longer the job which was finished
+            let mut jobs = self.jobs.lock().expect("job store lock poisoned");
+            if jobs
+                .get(&id)
+                .is_some_and(|job| job.record.version == record.version)
+            {
+                jobs.remove(&id);
+            }
+        }
+    }
+
+    /// Compares the result of a submission, as it was sent, with the result of the earlier attempt its `previousTaskId`
+    /// names, if that attempt belongs to the same tenant and was checked.
+    ///
+    /// An attempt which is unknown, or was not checked, leaves the result as it is, as there is nothing to compare.
+    pub fn revise(
+        &self,
+        tenant: Option<&str>,
+        submission: &serde_json::Value,
+        result: &mut SubmitResponse,
+    ) {
+        let SubmitResponse::Checked(result) = result else {
+            return;
+        };
+        let Some(previous_id) = submission["previousTaskId"]
+            .as_str()
+            .and_then(|id| Uuid::parse_str(id).ok())
+        else {
+            return;
+        };
+        let Some(previous) = self
+            .record(previous_id)
+            .filter(|previous| previous.tenant.as_deref() == tenant)
+        else {
+            return;
+        };
+        if let Some(SubmitResponse::Checked(previous_result)) = &previous.result {
+            let revision = Revision::new(
+                previous_id,
+                (&previous.submission, previous_result),
+                (submission, result),
+            );
+            result.revision = Some(Box::new(revision));
+        }
+    }
+
+    /// Hands a queued job off to the replicas of a cluster, persisting it before it is enqueued, so whichever replica
+    /// claims it can load it, after which it is no longer kept in memory.
+    ///
+    /// A job which fails to be handed off is kept in memory.
+    pub fn hand_off(
+        &self,
+        id: Uuid,
+        enqueue: impl FnOnce() -> Result<(), StoreError>,
+    ) -> Result<(), StoreError> {
+        let record = self
+            .jobs
+            .lock()
+            .expect("job store lock poisoned")
+            .get(&id)
+            .map(|job| job.record.clone());
+        let (Some(record), Some(store)) = (record, &self.store) else {
+            return Ok(());
+        };
+
+        store.save(&record)?;
+        enqueue()?;
+        self.jobs
+            .lock()
+            .expect("job store lock poisoned")
+            .remove(&id);
+
+        Ok(())
+    }
+
+    /// Takes over a job which was claimed from the queue of a cluster, to be judged on this replica.
+    ///
+    /// A job which was running on a replica which stopped starts over, so its progress starts over too.
+    pub fn adopt(&self, mut record: JobRecord) {
+        if record.status == JobStatus::Running {
+            record.status = JobStatus::Queued;
+            record.started_at = None;
+            record.progress = vec![Progress::Queued { position: 0 }];
+        }
+
+        self.jobs.lock().expect("job store lock poisoned").insert(
+            record.id,
+            Job {
+                record,
+                subscribers: Some(broadcast::channel(PROGRESS_CAPACITY).0),
+                cancellation: Cancellation::default(),
+            },
+        );
+    }
+
+    /// Gets the cancellation of the current judgment of the job with the given id.
+    ///
+    /// A job which does not exist is never cancelled.
+    pub fn cancellation(&self, id: Uuid) -> Cancellation {
+        self.jobs
+            .lock()
+            .expect("job store lock poisoned")
+            .get(&id)
+            .map(|job| job.cancellation.clone())
+            .unwrap_or_default()
+    }
+
+    /// Cancels the judgment of a job which is queued or running, which is done once its status is
+    /// [`JobStatus::Cancelled`].
+    ///
+    /// A persisted job is always done, so it cannot be cancelled, unless the store is shared, where a persisted job
+    /// which is not done is queued on the cluster, or running on another replica.
+    pub fn cancel(&self, id: Uuid) -> Result<(), CancelError> {
+        {
+            let jobs = self.jobs.lock().expect("job store lock poisoned");
+            if let Some(job) = jobs.get(&id) {
+                if job.record.status.is_done() {
+                    return Err(CancelError::Done);
+                }
+
+                job.cancellation.cancel();
+                return Ok(());
+            }
+        }
+
+        match self.persisted(id) {
+            Some(record) if self.shared && !record.status.is_done() => Err(CancelError::Elsewhere),
+            Some(_) => Err(CancelError::Done),
+            None => Err(CancelError::NotFound),
+        }
+    }
+
+    /// Records the panic the judgment of the job with the given id crashed with, which is persisted once it is finished.
+    pub fn crashed(&self, id: Uuid, crash: Crash) {
+        if let Some(job) = self
+            .jobs
+            .lock()
+            .expect("job store lock poisoned")
+            .get_mut(&id)
+        {
+            job.record.crash = Some(Box::new(crash));
+        }
+    }
+
+    /// Reports progress of the job with the given id to its subscribers, where a test case which finished is published
+    /// to the event bus as well.
+    pub fn report(&self, id: Uuid, progress: Progress) {
+        let tenant = {
+            let mut jobs = self.jobs.lock().expect("job store lock poisoned");
+            let Some(job) = jobs.get_mut(&id) else {
+                return;
+            };
+            job.report(progress.clone());
+            job.record.tenant.clone()
+        };
+
+        if let Progress::Ran {
+            index,
+            total,
+            id: test_case,
+            passed,
+        } = progress
+        {
+            self.publish(
+                id,
+                tenant.as_deref(),
+                EventKind::TestFinished {
+                    id: test_case,
+                    index,
+                    total,
+                    passed,
+                },
+            );
+        }
+    }
+
+    /// Publishes that the workspace of the job with the given id was removed, or kept for the janitor if it is
+    /// retained.
+    pub fn cleaned_up(&self, id: Uuid, retained: bool) {
+        let tenant = {
+            let jobs = self.jobs.lock().expect("job store lock poisoned");
+            let Some(job) = jobs.get(&id) else {
+                return;
+            };
+            job.record.tenant.clone()
+        };
+
+        self.publish(id, tenant.as_deref(), EventKind::CleanupDone { retained });
+    }
+
+    fn publish(&self, id: Uuid, tenant: Option<&str>, kind: EventKind) {
+        if let Some(events) = &self.events {
+            events.publish(Event::new(id, tenant, kind));
+        }
+    }
+
+    /// Subscribes to the progress of the job with the given id, if it exists.
+    ///
+    /// Returns the progress so far, and a receiver of the progress to come, which is closed once the job is done.
+    pub fn subscribe(&self, id: Uuid) -> Option<(Vec<Progress>, Receiver<Progress>)> {
+        {
+            let jobs = self.jobs.lock().expect("job store lock poisoned");
+            if let Some(job) = jobs.get(&id) {
+                // the sender of a finished job is gone, so a receiver of a new channel is closed right away
+                let receiver = match &job.subscribers {
+                    Some(subscribers) => subscribers.subscribe(),
+                    None => broadcast::channel(1).1,
+                };
+
+                return Some((job.record.progress.clone(), receiver));
+            }
+        }
+
+        // persisted jobs are done, so there is no progress to come
+        self.persisted(id)
+            .map(|record| (record.progress, broadcast::channel(1).1))
+    }
+
+    /// Gets the status of the job with the given id, if it exists.
+    pub fn status(&self, id: Uuid) -> Option<JobStatus> {
+        self.record(id).map(|record| record.status)
+    }
+
+    /// Gets the result of the job with the given id.
+    ///
+    /// The outer option is `None` if the job does not exist, the inner option is `None` if the job is not done yet.
+    pub fn result(&self, id: Uuid) -> Option<Option<SubmitResponse>> {
+        self.record(id).map(|record| record.result)
+    }
+
+    /// Queues a job which is done to be checked again behind `position` jobs, returning the submission to check.
+    ///
+    /// The test cases of the submission are replaced by `test_cases` if given, and the current result is kept as a
+    /// previous version. A persisted job is brought back into memory to be rejudged.
+    pub fn rejudge(
+        &self,
+        id: Uuid,
+        test_cases: Option<serde_json::Value>,
+        position: usize,
+        limits: &SubmissionLimits,
+    ) -> Result<Submission, RejudgeError> {
+        let in_memory = self
+            .jobs
+            .lock()
+            .expect("job store lock poisoned")
+            .contains_key(&id);
+        // the store is read outside of the lock, like it is written
+        let persisted = if in_memory { None } else { self.persisted(id) };
+
+        let mut jobs = self.jobs.lock().expect("job store lock poisoned");
+        if let Some(record) = persisted {
+            jobs.entry(id).or_insert(Job {
+                record,
+                subscribers: None,
+                cancellation: Cancellation::default(),
+            });
+        }
+        let job = jobs.get_mut(&id).ok_or(RejudgeError::NotFound)?;
+        if !job.record.status.is_done() {
+            return Err(RejudgeError::NotDone);
+        }
+
+        let mut value = job.record.submission.clone();
+        if let (Some(test_cases), Some(object)) = (test_cases, value.as_object_mut()) {
+            object.insert(String::from("testCases"), test_cases);
+        }
+        let submission: Submission = serde_json::from_value(value.clone())
+            .map_err(|err| RejudgeError::Malformed(err.to_string()))?;
+        submission.validate(limits).map_err(RejudgeError::Invalid)?;
+
+        let record = &mut job.record;
+        record.previous.push(PreviousResult {
+            version: record.version,
+            finished_at: record.finished_at,
+            result: record.result.take(),
+        });
+        record.version += 1;
+        record.status = JobStatus::Queued;
+        record.submission = value;
+        record.started_at = None;
+        record.finished_at = None;
+        record.progress = vec![Progress::Queued { position }];
+        record.archived_at = None;
+        record.crash = None;
+        job.subscribers = Some(broadcast::channel(PROGRESS_CAPACITY).0);
+        job.cancellation = Cancellation::default();
+        let (tenant, batch_id) = (
+            record.tenant.clone(),
+            record.batch.as_ref().map(|batch| batch.id),
+        );
+        drop(jobs);
+
+        self.publish(
+            id,
+            tenant.as_deref(),
+            EventKind::JobQueued {
+                position,
+                language: submission.language,
+                exercise_id: submission.exercise_id.clone(),
+                batch_id,
+            },
+        );
+
+        Ok(submission)
+    }
+
+    /// Gets the ids of every job of the exercise of the tenant, in memory or in the store.
+    pub fn exercise_jobs(&self, exercise_id: &str, tenant: Option<&str>) -> Vec<Uuid> {
+        let of_exercise = |record: &JobRecord| {
+            record.submission["exerciseId"] == exercise_id && record.tenant.as_deref() == tenant
+        };
+
+        let mut ids: Vec<Uuid> = self
+            .jobs
+            .lock()
+            .expect("job store lock poisoned")
+            .values()
+            .filter(|job| of_exercise(&job.record))
+            .map(|job| job.record.id)
+            .collect();
+
+        if let Some(store) = &self.store {
+            match store.exercise(exercise_id) {
+                Ok(records) => ids.extend(
+                    records
+                        .iter()
+                        .filter(|record| of_exercise(record) && !ids.contains(&record.id))
+                        .map(|record| record.id)
+                        .collect::<Vec<_>>(),
+                ),
+                Err(err) => warn!(%err, "failed to load persisted jobs"),
+            }
+        }
+
+        ids
+    }
+
+    /// Gets the records of every job of the batch, in memory or in the store, ordered as they were submitted.
+    pub fn batch_jobs(&self, batch_id: Uuid) -> Vec<JobRecord> {
+        let of_batch = |record: &JobRecord| record.batch.is_some_and(|batch| batch.id == batch_id);
+
+        let mut records: Vec<JobRecord> = self
+            .jobs
+            .lock()
+            .expect("job store lock poisoned")
+            .values()
+            .filter(|job| of_batch(&job.record))
+            .map(|job| job.record.clone())
+            .collect();
+
+        if let Some(store) = &self.store {
+            match store.batch(batch_id) {
+                Ok(persisted) => {
+                    let persisted: Vec<JobRecord> = persisted
+                        .into_iter()
+                        .filter(|record| {
+                            of_batch(record) && !records.iter().any(|other| other.id == record.id)
+                        })
+                        .collect();
+                    records.extend(persisted);
+                }
+                Err(err) => warn!(%err, "failed to load persisted jobs"),
+            }
+        }
+
+        records.sort_by_key(|record| record.batch.map(|batch| batch.index));
+        records
+    }
+
+    /// Gets the record of every job, in memory or in the store, where a job in memory may be newer than its persisted
+    /// record.
+    pub fn records(&self) -> Vec<JobRecord> {
+        let mut records: Vec<JobRecord> = self
+            .jobs
+            .lock()
+            .expect("job store lock poisoned")
+            .values()
+            .map(|job| job.record.clone())
+            .collect();
+
+        if let Some(store) = &self.store {
+            match store.all() {
+                Ok(persisted) => {
+                    let in_memory: HashSet<Uuid> = records.iter().map(|record| record.id).collect();
+                    records.extend(
+                        persisted
+                            .into_iter()
+                            .filter(|record| !in_memory.contains(&record.id)),
+                    );
+                }
+                Err(err) => warn!(%err, "failed to load persisted jobs"),
+            }
+        }
+
+        records
+    }
+
+    /// Gets the records of every job which is queued or running, oldest first.
+    pub fn active(&self) -> Vec<JobRecord> {
+        let mut records: Vec<JobRecord> = self
+            .jobs
+            .lock()
+            .expect("job store lock poisoned")
+            .values()
+            .filter(|job| !job.record.status.is_done())
+            .map(|job| job.record.clone())
+            .collect();
+        records.sort_by_key(|record| record.submitted_at);
+
+        records
+    }
+
+    /// Retires every job which has been done for longer than `max_age`, removing it from memory and from the store,
+    /// which archives it if it has an archive, and returns the ids of the retired jobs.
+    ///
+    /// A job which fails to be retired is kept as it is, so it is retired by a later call.
+    pub fn retire(&self, max_age: Duration) -> Vec<Uuid> {
+        let cutoff = now().saturating_sub(max_age.as_millis() as u64);
+        let expired = |record: &JobRecord| {
+            record.status.is_done() && record.finished_at.is_some_and(|at| at <= cutoff)
+        };
+
+        let mut records: Vec<JobRecord> = self
+            .jobs
+            .lock()
+            .expect("job store lock poisoned")
+            .values()
+            .filter(|job| expired(&job.record))
+            .map(|job| job.record.clone())
+            .collect();
+        // a job in memory may be newer than its persisted record, which is left to its own retirement
+        if let Some(store) = &self.store {
+            match store.all() {
+                Ok(persisted) => {
+                    let in_memory: HashSet<Uuid> = self
+                        .jobs
+                        .lock()
+                        .expect("job store lock poisoned")
+                        .keys()
+                        .copied()
+                        .collect();
+                    records.extend(
+                        persisted
+                            .into_iter()
+                            .filter(|record| expired(record) && !in_memory.contains(&record.id)),
+                    );
+                }
+                Err(err) => warn!(%err, "failed to load persisted jobs"),
+            }
+        }
+
+        let mut retired = Vec::new();
+        for record in records {
+            if let Some(store) = &self.store {
+                if let Err(err) = store.retire(&record) {
+                    warn!(%err, task = %record.id, "failed to retire job");
+                    continue;
+                }
+            }
+            // a job which was rejudged in the meantime is kept, as it is no longer the job which was retired
+            let mut jobs = self.jobs.lock().expect("job store lock poisoned");
+            if jobs
+                .get(&record.id)
+                .is_some_and(|job| job.record.version == record.version)
+            {
+                jobs.remove(&record.id);
+            }
+            retired.push(record.id);
+        }
+
+        retired
+    }
+
+    /// Gets the record of the job with the given id, from memory or from the store.
+    pub fn record(&self, id: Uuid) -> Option<JobRecord> {
+        let in_memory = self
+            .jobs
+            .lock()
+            .expect("job store lock poisoned")
+            .get(&id)
+            .map(|job| job.record.clone());
+
+        in_memory.or_else(|| self.persisted(id))
+    }
+
+    /// Persists a job if there is a store, returning whether it was persisted.
+    fn persist(&self, record: &JobRecord) -> bool {
+        let Some(store) = &self.store else {
+            return false;
+        };
+
+        match store.save(record) {
+            Ok(()) => true,
+            Err(err) => {
+                warn!(%err, task = %record.id, "failed to persist job");
+                false
+            }
+        }
+    }
+
+    /// Loads a job from the store, where a store which fails to load the job is treated as not having it.
+    fn persisted(&self, id: Uuid) -> Option<JobRecord> {
+        let store = self.store.as_ref()?;
+        match store.load(id) {
+            Ok(record) => record,
+            Err(err) => {
+                warn!(%err, task = %id, "failed to load persisted job");
+                None
+            }
+        }
+    }
+}
+
+/// Gets the current time in milliseconds since the unix epoch.
+fn now() -> u64 {
+    SystemTime::now()
+        .duration_since(UNIX_EPOCH)
+        .map_or(0, |since| since.as_millis() as u64)
+}
+
+impl Job {
+    fn report(&mut self, progress: Progress) {
+        self.record.progress.push(progress.clone());
+        if let Some(subscribers) = &self.subscribers {
+            // there may be no subscribers, in which case the event is only kept in the history
+            let _ = subscribers.send(progress);
+        }
+    }
+}
+
+#[cfg(test)]
+mod progress {
+    use super::{JobStatus, JobStore, Progress};
+    use crate::{
+        model::{Submission, SubmissionResult},
+        response::SubmitResponse,
+    };
+    use std::{thread, time::Duration};
+
+    fn submission() -> Submission {
+        serde_json::from_str(r#"{"solution": "", "testCases": []}"#).unwrap()
+    }
+
+    #[test]
+    fn late_subscriber() {
+        let jobs = JobStore::default();
+        let id = jobs.create(None, 0, &submission());
+
+        jobs.report(id, Progress::Compiling);
+        let (history, mut receiver) = jobs.subscribe(id).expect("the job should exist");
+        jobs.finish(id, SubmitResponse::Internal);
+
+        assert_eq!(
+            history,
+            [Progress::Queued { position: 0 }, Progress::Compiling]
+        );
+        assert_eq!(
+            receiver.try_recv(),
+            Ok(Progress::Finished {
+                status: JobStatus::Failed
+            })
+        );
+        assert!(receiver.try_recv().is_err());
+    }
+
+    #[test]
+    fn finished_job() {
+        let jobs = JobStore::default();
+        let id = jobs.create(None, 0, &submission());
+
+        jobs.finish(id, SubmitResponse::Busy);
+        let (history, mut receiver) = jobs.subscribe(id).expect("the job should exist");
+
+        assert_eq!(history.len(), 2);
+        assert!(receiver.try_recv().is_err());
+    }
+
+    #[test]
+    fn queue_wait() {
+        let jobs = JobStore::default();
+        let id = jobs.create(None, 0, &submission());
+        let result = SubmissionResult {
+            timings: Some(Box::default()),
+            ..SubmissionResult::checked(String::new(), Box::new([]))
+        };
+
+        thread::sleep(Duration::from_millis(20));
+        jobs.set_status(id, JobStatus::Running);
+        jobs.finish(id, SubmitResponse::Checked(result));
+        let record = jobs.record(id).expect("the job should exist");
+
+        let Some(SubmitResponse::Checked(result)) = record.result else {
+            panic!("the job should be checked");
+        };
+        let queued = result
+            .timings
+            .expect("the timings should be measured")
+            .queued;
+        assert!(queued >= 20);
+        assert_eq!(queued, record.started_at.unwrap() - record.submitted_at);
+    }
+}
+
+#[cfg(test)]
+mod lifecycle {
+    use super::{JobStatus, JobStore, Progress};
+    use crate::{
+        error::EventError,
+        events::{Events, Publisher},
+        model::Submission,
+        response::SubmitResponse,
+    };
+    use std::{
+        sync::{
+            mpsc::{self, Sender},
+            Arc,
+        },
+        time::Duration,
+    };
+
+    struct Subjects(Sender<String>);
+
+    impl Publisher for Subjects {
+        fn publish(&mut self, subject: &str, _: &[u8]) -> Result<(), EventError> {
+            self.0.send(subject.to_string()).unwrap();
+            Ok(())
+        }
+    }
+
+    #[test]
+    fn publishes_events() {
+        let (sender, receiver) = mpsc::channel();
+        let events = Events::new(Box::new(Subjects(sender)), String::from("mozart"));
+        let jobs = JobStore::default().publishing(Arc::new(events));
+        let submission: Submission =
+            serde_json::from_str(r#"{"solution": "", "testCases": []}"#).unwrap();
+
+        let id = jobs.create(Some("algorithms"), 0, &submission);
+        jobs.set_status(id, JobStatus::Running);
+        jobs.report(id, Progress::Compiling);
+        jobs.report(
+            id,
+            Progress::Ran {
+                index: 1,
+                total: 1,
+                id: 0,
+                passed: true,
+            },
+        );
+        jobs.cleaned_up(id, false);
+        jobs.finish(id, SubmitResponse::Internal);
+        let subjects: Vec<String> = (0..5)
+            .map(|_| receiver.recv_timeout(Duration::from_secs(5)).unwrap())
+            .collect();
+
+        assert_eq!(
+            subjects,
+            [
+                "mozart.jobQueued",
+                "mozart.jobStarted",
+                "mozart.testFinished",
+                "mozart.cleanupDone",
+                "mozart.jobCompleted"
+            ]
+        );
+    }
+}
+
+#[cfg(test)]
+mod batch {
+    use super::{BatchEntry, JobStore};
+    use crate::model::Submission;
+    use uuid::Uuid;
+
+    fn submission() -> Submission {
+        serde_json::from_str(r#"{"solution": "", "testCases": []}"#).unwrap()
+    }
+
+    #[test]
+    fn in_submitted_order() {
+        let jobs = JobStore::default();
+        let batch_id = Uuid::new_v4();
+        let second = jobs.create_in_batch(
+            None,
+            1,
+            &submission(),
+            BatchEntry {
+                id: batch_id,
+                index: 1,
+            },
+        );
+        let first = jobs.create_in_batch(
+            None,
+            0,
+            &submission(),
+            BatchEntry {
+                id: batch_id,
+                index: 0,
+            },
+        );
+        jobs.create(None, 0, &submission());
+
+        let actual: Vec<Uuid> = jobs
+            .batch_jobs(batch_id)
+            .iter()
+            .map(|record| record.id)
+            .collect();
+
+        assert_eq!(actual, [first, second]);
+        assert!(jobs.batch_jobs(Uuid::new_v4()).is_empty());
+    }
+}
+
+#[cfg(test)]
+mod redact {
+    use super::JobStore;
+    use crate::{model::Submission, response::SubmitResponse};
+    use serde_json::json;
+
+    #[test]
+    fn hidden_parameters() {
+        let submission: Submission = serde_json::from_value(json!({
+            "solution": "solution = 5",
+            "testCases": [
+                {
+                    "id": 0,
+                    "inputParameters": [],
+                    "outputParameters": [{ "valueType": "int", "value": "5" }]
+                },
+                {
+                    "id": 1,
+                    "hidden": true,
+                    "inputParameters": [{ "valueType": "int", "value": "2" }],
+                    "outputParameters": [{ "valueType": "int", "value": "7" }],
+                    "workDir": { "secret.txt": "Nw==" }
+                }
+            ]
+        }))
+        .unwrap();
+        let jobs = JobStore::default();
+        let id = jobs.create(None, 0, &submission);
+        jobs.finish(id, SubmitResponse::Internal);
+        let mut record = jobs.record(id).expect("the job should exist");
+
+        record.redact();
+
+        let test_cases = &record.submission["testCases"];
+        assert_eq!(test_cases[0]["outputParameters"][0]["value"], "5");
+        assert_eq!(test_cases[1]["inputParameters"], json!([]));
+        assert_eq!(test_cases[1]["outputParameters"], json!([]));
+        assert_eq!(test_cases[1]["workDir"], json!({}));
+        assert_eq!(test_cases[1]["hidden"], true);
+    }
+}
+
+#[cfg(test)]
+mod rejudge {
+    use super::{JobStatus, JobStore};
+    use crate::{
+        error::{CancelError, RejudgeError},
+        model::{LimitCaps, Submission, SubmissionLimits},
+        response::SubmitResponse,
+    };
+    use serde_json::json;
+    use std::collections::BTreeMap;
+    use uuid::Uuid;
+
+    const LIMITS: SubmissionLimits = SubmissionLimits {
+        solution_size: 1024,
+        test_cases: 8,
+        allowed_env: Vec::new(),
+        allowed_endpoints: Vec::new(),
+        callback_hosts: Vec::new(),
+        profiles: Vec::new(),
+        versions: BTreeMap::new(),
+        caps: LimitCaps::UNCAPPED,
+    };
+
+    fn test_case(id: u64) -> serde_json::Value {
+        json!({
+            "id": id,
+            "inputParameters": [],
+            "outputParameters": [{ "valueType": "int", "value": "5" }]
+        })
+    }
+
+    fn submission() -> Submission {
+        serde_json::from_value(json!({
+            "solution": "solution = 5",
+            "exerciseId": "sum",
+            "testCases": [test_case(0)]
+        }))
+        .unwrap()
+    }
+
+    #[test]
+    fn keeps_previous_result() {
+        let jobs = JobStore::default();
+        let id = jobs.create(None, 0, &submission());
+        jobs.finish(id, SubmitResponse::Internal);
+
+        let rejudged = jobs
+            .rejudge(id, Some(json!([test_case(1)])), 0, &LIMITS)
+            .expect("a finished job can be rejudged");
+        let record = jobs.record(id).expect("the job should exist");
+
+        assert_eq!(rejudged.test_cases[0].id, 1);
+        assert_eq!(record.status, JobStatus::Queued);
+        assert_eq!(record.version, 2);
+        assert_eq!(record.previous.len(), 1);
+        assert!(matches!(
+            record.previous[0].result,
+            Some(SubmitResponse::Internal)
+        ));
+        assert!(record.result.is_none());
+        assert_eq!(record.submission["testCases"][0]["id"], 1);
+    }
+
+    #[test]
+    fn not_done() {
+        let jobs = JobStore::default();
+        let id = jobs.create(None, 0, &submission());
+
+        let actual = jobs.rejudge(id, None, 0, &LIMITS);
+
+        assert!(matches!(actual, Err(RejudgeError::NotDone)));
+    }
+
+    #[test]
+    fn cancelled_job() {
+        let jobs = JobStore::default();
+        let id = jobs.create(None, 0, &submission());
+        let cancellation = jobs.cancellation(id);
+
+        jobs.cancel(id).expect("a queued job can be cancelled");
+        jobs.finish(id, SubmitResponse::Cancelled);
+        let status = jobs.status(id);
+        let rejudged = jobs.rejudge(id, None, 0, &LIMITS);
+
+        assert!(cancellation.is_cancelled());
+        assert_eq!(status, Some(JobStatus::Cancelled));
+        assert!(matches!(
+            jobs.cancel(Uuid::new_v4()),
+            Err(CancelError::NotFound)
+        ));
+        assert!(rejudged.is_ok());
+        assert!(!jobs.cancellation(id).is_cancelled());
+    }
+
+    #[test]
+    fn cancel_done_job() {
+        let jobs = JobStore::default();
+        let id = jobs.create(None, 0, &submission());
+        jobs.finish(id, SubmitResponse::Internal);
+
+        let actual = jobs.cancel(id);
+
+        assert!(matches!(actual, Err(CancelError::Done)));
+        assert!(!jobs.cancellation(id).is_cancelled());
+    }
+
+    #[test]
+    fn by_exercise() {
+        let jobs = JobStore::default();
+        let id = jobs.create(None, 0, &submission());
+
+        assert_eq!(jobs.exercise_jobs("sum", None), [id]);
+        assert!(jobs.exercise_jobs("sum", Some("course")).is_empty());
+        assert!(jobs.exercise_jobs("product", None).is_empty());
+    }
+}
+
+#[cfg(test)]
+mod retention {
+    use super::JobStore;
+    use crate::{model::Submission, response::SubmitResponse};
+    use std::time::Duration;
+
+    fn submission() -> Submission {
+        serde_json::from_str(r#"{"solution": "solution = 5", "testCases": []}"#).unwrap()
+    }
+
+    #[test]
+    fn retires_done_jobs() {
+        let jobs = JobStore::default();
+        let done = jobs.create(None, 0, &submission());
+        jobs.finish(done, SubmitResponse::Internal);
+        let queued = jobs.create(None, 0, &submission());
+
+        let kept = jobs.retire(Duration::from_secs(60));
+        let retired = jobs.retire(Duration::ZERO);
+
+        assert!(kept.is_empty());
+        assert_eq!(retired, [done]);
+        assert!(jobs.record(done).is_none());
+        assert!(jobs.record(queued).is_some());
+    }
+}
+
+#[cfg(test)]
+mod revision {
+    use super::JobStore;
+    use crate::{
+        model::{Submission, SubmissionResult, Verdict},
+        response::SubmitResponse,
+    };
+    use uuid::Uuid;
+
+    fn submission(solution: &str, previous_task_id: Option<Uuid>) -> Submission {
+        let previous_task_id =
+            previous_task_id.map_or(String::new(), |id| format!(r#", "previousTaskId": "{id}""#));
+        serde_json::from_str(&format!(
+            r#"{{"solution": "{solution}", "testCases": []{previous_task_id}}}"#
+        ))
+        .unwrap()
+    }
+
+    fn revision(jobs: &JobStore, id: Uuid) -> Option<Verdict> {
+        match jobs.record(id)?.result? {
+            SubmitResponse::Checked(result) => Some(result.revision?.previous_verdict),
+            _ => None,
+        }
+    }
+
+    #[test]
+    fn compares_with_previous_attempt() {
+        let jobs = JobStore::default();
+        let previous = jobs.create(Some("algorithms"), 0, &submission("a = 1", None));
+        let failed = SubmissionResult::compilation_error(String::new(), Vec::new());
+        jobs.finish(previous, SubmitResponse::Checked(failed));
+        let revised = jobs.create(Some("algorithms"), 0, &submission("a = 2", Some(previous)));
+        let other_tenant = jobs.create(None, 0, &submission("a = 2", Some(previous)));
+        let unknown = jobs.create(
+            Some("algorithms"),
+            0,
+            &submission("a = 2", Some(Uuid::new_v4())),
+        );
+
+        for id in [revised, other_tenant, unknown] {
+            let passed = SubmissionResult::checked(String::new(), Box::new([]));
+            jobs.finish(id, SubmitResponse::Checked(passed));
+        }
+
+        assert_eq!(revision(&jobs, revised), Some(Verdict::CompilationError));
+        assert_eq!(revision(&jobs, other_tenant), None);
+        assert_eq!(revision(&jobs, unknown), None);
+    }
+}
@@ -0,0 +1,420 @@
+use crate::{
+    cache::CompileCache,
+    cancel::Cancellation,
+    config::Config,
+    error::CheckError,
+    metrics::METRICS,
+    model::{NetworkPolicy, Reproduction, Submission, SubmissionResult},
+    plugin::VerdictPlugins,
+    response::{Invalid, SubmitResponse},
+    runner::{self, TestRunner},
+    sandbox::{Sandbox, Sink},
+    similarity::{self, Fingerprints},
+    warm::WarmPool,
+    workspace::Workspace,
+};
+use std::time::Instant;
+use tracing::{debug, error, info, info_span, warn};
+use uuid::Uuid;
+
+pub use crate::job::Progress;
+
+/// Judges submissions within the process, for services which embed mozart rather than submitting to it over HTTP.
+///
+/// Submissions are checked like `POST /submit` checks them, subject to the same config, except that their test cases
+/// are never run in warm containers, as those belong to a running server.
+pub struct Judge {
+    config: Config,
+    cache: CompileCache,
+    warm: WarmPool,
+    /// The verdict plugins, or why they could not be loaded, which fails every judgment like a failing plugin does.
+    plugins: Result<VerdictPlugins, String>,
+}
+
+impl Judge {
+    /// Creates a judge from the config, e.g. one loaded by [`Config::load`], whose compile cache is shared with every
+    /// other judge using the same cache directory, and which loads the verdict plugins once.
+    pub fn new(config: Config) -> Self {
+        Self {
+            cache: CompileCache::new(config.compile_cache_dir(), config.compile_cache_capacity()),
+            warm: WarmPool::default(),
+            plugins: VerdictPlugins::from_config(&config).map_err(|err| err.to_string()),
+            config,
+        }
+    }
+
+    pub fn config(&self) -> &Config {
+        &self.config
+    }
+
+    /// Checks a submission, blocking until it is judged.
+    ///
+    /// The response is [`SubmitResponse::Checked`] for every solution which could be judged, including those which
+    /// failed to compile, and otherwise [`SubmitResponse::InvalidSubmission`], [`SubmitResponse::TimedOut`], or
+    /// [`SubmitResponse::Internal`].
+    pub fn check(&self, submission: Submission) -> SubmitResponse {
+        self.check_with(submission, &Cancellation::default(), &|_| {})
+    }
+
+    /// Checks a submission like [`Judge::check`], reporting its progress as it happens, and stopping with
+    /// [`SubmitResponse::Cancelled`] once it is cancelled, or [`SubmitResponse::TimedOut`] once the judgment timeout
+    /// has passed.
+    pub fn check_with(
+        &self,
+        submission: Submission,
+        cancellation: &Cancellation,
+        report: &dyn Fn(Progress),
+    ) -> SubmitResponse {
+        let plugins = match &self.plugins {
+            Ok(plugins) => plugins,
+            Err(err) => {
+                error!(%err, "failed to load the verdict plugins");
+                return SubmitResponse::Internal;
+            }
+        };
+
+        judge(
+            Uuid::new_v4(),
+            submission,
+            &self.config,
+            &self.cache,
+            &self.warm,
+            plugins,
+            cancellation,
+            report,
+            None,
+        )
+    }
+}
+
+/// Checks a submission in a fresh temporary directory named by the task id, removing the directory afterwards.
+///
+/// The test cases are run in a warm container of the language if one is idle, in whose directory the temporary
+/// directory is created, so the container is only reset once the directory is removed.
+///
+/// Every log line of the judgment carries the task id, and its progress is reported as it happens, as is the output of
+/// the test cases if a sink is given. A cancelled judgment stops as soon as the sandbox notices, and its directory is
+/// removed all the same.
+///
+/// The cancellation expires once the judgment timeout has passed since the submission was found valid, after which
+/// the judgment stops like a cancelled one, with [`SubmitResponse::TimedOut`].
+#[allow(clippy::too_many_arguments)]
+pub fn judge(
+    task: Uuid,
+    mut submission: Submission,
+    config: &Config,
+    cache: &CompileCache,
+    warm: &WarmPool,
+    plugins: &VerdictPlugins,
+    cancellation: &Cancellation,
+    report: &dyn Fn(Progress),
+    sink: Option<Sink>,
+) -> SubmitResponse {
+    let _span = info_span!("judgment", %task, language = %submission.language).entered();
+
+    if let Err(err) = submission.validate(&config.submission_limits()) {
+        info!(%err, "rejected invalid submission");
+        return SubmitResponse::InvalidSubmission(err.into());
+    }
+    if let Some(timeout) = config.judgment_timeout() {
+        cancellation.expire_at(Instant::now() + timeout);
+    }
+    if let Some(exercise) = submission
+        .exercise_id
+        .as_deref()
+        .filter(|_| submission.fingerprint)
+    {
+        let fingerprints = similarity::fingerprint(submission.language, &submission.solution);
+        // the judgment does not depend on the fingerprints, so failing to store them only loses the comparison
+        if let Err(err) = Fingerprints::from(config).put(exercise, task, fingerprints) {
+            warn!(%err, "failed to store the fingerprints of the solution");
+        }
+    }
+    let max_failures = [
+        apply_profile(&mut submission, config).then_some(1),
+        submission.failure_limit(),
+    ]
+    .into_iter()
+    .flatten()
+    .min();
+    // the seed is chosen below 2^53, so it survives clients which read JSON numbers as doubles
+    let seed = submission
+        .seed
+        .unwrap_or_else(|| Uuid::new_v4().as_u64_pair().0 >> 11);
+    // the version was validated along with the rest of the submission
+    let versioned;
+    let config = match submission
+        .toolchain_version
+        .as_deref()
+        .and_then(|version| config.with_version(submission.language, version))
+    {
+        Some(config) => {
+            versioned = config;
+            &versioned
+        }
+        None => config,
+    };
+    let pinned;
+    let config = match &submission.image_digest {
+        Some(digest) => match runner::pin_image(submission.language, config, digest) {
+            Some(config) => {
+                pinned = config;
+                &pinned
+            }
+            None => {
+                info!("rejected pinned image outside of docker");
+                return SubmitResponse::InvalidSubmission(Invalid::new(
+                    "unpinnableImage",
+                    format!(
+                        "the image of {} cannot be pinned, as it is not run in docker",
+                        submission.language
+                    ),
+                ));
+            }
+        },
+        None => config,
+    };
+
+    // warm containers forbid sockets once they are started, so test cases with network access run in fresh sandboxes
+    // and run the configured image of their language, which a pinned digest or another version may differ from
+    let checkout = match (
+        &submission.network,
+        &submission.image_digest,
+        &submission.toolchain_version,
+    ) {
+        (NetworkPolicy::None, None, None) => warm.checkout(submission.language),
+        _ => None,
+    };
+    let workspace_started = Instant::now();
+    let created = match &checkout {
+        Some(checkout) => Workspace::create_in(checkout.dir(), config, task),
+        None => Workspace::create(config, task),
+    };
+    let workspace = match created {
+        Ok(workspace) => workspace,
+        Err(err) => {
+            error!(%err, path = %config.workspace_dir().display(), "failed to create workspace");
+            return SubmitResponse::Internal;
+        }
+    };
+    let workspace_time = workspace_started.elapsed();
+
+    let Some(mut runner) =
+        TestRunner::new(submission.language, workspace.dir().to_path_buf(), config)
+    else {
+        error!("language is not supported by this build");
+        return SubmitResponse::Internal;
+    };
+    if let Some(checkout) = &checkout {
+        debug!("running test cases in a warm container");
+        runner = runner.run_in(checkout.sandbox().clone());
+    }
+    if let Some(sink) = sink {
+        runner = runner.stream_to(sink);
+    }
+    if let Some(max_failures) = max_failures {
+        runner = runner.stop_after(max_failures);
+    }
+    if submission.export_artifact {
+        runner = runner.export_artifacts_to(config.artifact_path(task));
+    }
+    if submission.record_transcript {
+        runner = runner.record_transcript_to(config.transcript_path(task));
+    }
+    if submission.test_suite {
+        runner = runner.test_suite();
+    }
+    runner = runner.seed(seed).adjust_with(plugins.clone());
+    let image = match runner::sandbox(submission.language, config) {
+        Some(Sandbox::Docker { image, .. }) => Some(image),
+        _ => None,
+    };
+    let toolchain_version = submission.toolchain_version.clone();
+
+    let mut response = match runner.check(submission, cache, cancellation, report) {
+        Ok(result) => {
+            info!(verdict = ?result.verdict, "checked submission");
+            SubmitResponse::Checked(SubmissionResult {
+                reproduction: Some(Box::new(Reproduction {
+                    seed,
+                    image,
+                    toolchain_version,
+                })),
+                ..result
+            })
+        }
+        Err(err) => match err {
+            CheckError::Sandbox => {
+                error!(%err, "failed to check submission");
+                METRICS.sandbox_failure();
+                SubmitResponse::Internal
+            }
+            // compilation errors are part of the result
+            CheckError::IOInteraction | CheckError::Compilation(_) | CheckError::Plugin(_) => {
+                error!(%err, "failed to check submission");
+                SubmitResponse::Internal
+            }
+            CheckError::UnsupportedTestCase(reason) => {
+                info!(%reason, "rejected unsupported test case");
+                SubmitResponse::InvalidSubmission(Invalid::new("unsupportedTestCase", reason))
+            }
+            // the checker is part of the submission, so a broken checker is a broken submission
+            CheckError::Checker(reason) => {
+                info!(%reason, "rejected submission with a failing checker");
+                SubmitResponse::InvalidSubmission(Invalid::new("checkerFailed", reason))
+            }
+            // like the checker, the setup script is part of the submission
+            CheckError::Hook(reason) => {
+                info!(%reason, "rejected submission with a failing setup script");
+                SubmitResponse::InvalidSubmission(Invalid::new("setupFailed", reason))
+            }
+            CheckError::MissingFixture(_) => {
+                info!(%err, "rejected submission with a missing fixture");
+                SubmitResponse::InvalidSubmission(Invalid::new("missingFixture", err.to_string()))
+            }
+            CheckError::MissingBlob(_) => {
+                info!(%err, "rejected submission with a missing blob");
+                SubmitResponse::InvalidSubmission(Invalid::new("missingBlob", err.to_string()))
+            }
+            CheckError::Cancelled if cancellation.is_expired() => {
+                warn!("cancelled submission which took longer than the judgment timeout");
+                SubmitResponse::TimedOut
+            }
+            CheckError::Cancelled => {
+                info!("cancelled running submission");
+                SubmitResponse::Cancelled
+            }
+        },
+    };
+
+    // retained workspaces are removed by the janitor instead
+    let cleanup_started = Instant::now();
+    if let Err(err) = workspace.destroy() {
+        error!(%err, "failed to remove workspace");
+        return SubmitResponse::Internal;
+    }
+    if let Some(timings) = response.timings() {
+        timings.workspace += workspace_time.as_millis() as u64;
+        timings.cleanup += cleanup_started.elapsed().as_millis() as u64;
+    }
+
+    response
+}
+
+/// Restricts the submission to the test cases and limits of its judging profile, if it selected one, returning whether
+/// its test cases stop at the first one which does not pass.
+///
+/// Groups are left out once none of their test cases are left, so the score is that of the test cases which are run.
+fn apply_profile(submission: &mut Submission, config: &Config) -> bool {
+    let Some(profile) = submission
+        .profile
+        .as_deref()
+        .and_then(|name| config.profile(name))
+    else {
+        return false;
+    };
+    debug!(
+        profile = submission.profile.as_deref(),
+        "applying judging profile"
+    );
+
+    if let Some(count) = profile.test_cases {
+        let mut test_cases = Vec::from(std::mem::take(&mut submission.test_cases));
+        test_cases.truncate(count);
+        submission.test_cases = test_cases.into();
+        let test_cases = &submission.test_cases;
+        submission.groups.retain(|group| {
+            test_cases
+                .iter()
+                .any(|test_case| test_case.group.as_ref() == Some(&group.name))
+        });
+    }
+    if let Some(time_limit) = profile.time_limit {
+        for test_case in submission.test_cases.iter_mut() {
+            let limit = test_case
+                .time_limit
+                .or(submission.time_limit)
+                .unwrap_or(config.time_limit);
+            test_case.time_limit = Some(limit.min(time_limit));
+        }
+    }
+    if let Some(memory_limit) = profile.memory_limit {
+        let limit = submission.memory_limit.unwrap_or(config.memory_limit);
+        submission.memory_limit = Some(limit.min(memory_limit));
+    }
+
+    profile.stop_on_failure
+}
+
+#[cfg(test)]
+mod embedded {
+    use super::{apply_profile, Judge};
+    use crate::{config::Config, model::Submission, response::SubmitResponse};
+    use serde_json::json;
+
+    #[test]
+    fn rejects_invalid_submission() {
+        let judge = Judge::new(Config::default());
+        let submission: Submission = serde_json::from_value(json!({
+            "language": "haskell",
+            "solution": "solution x = x",
+            "testCases": []
+        }))
+        .unwrap();
+
+        let actual = judge.check(submission);
+
+        assert!(
+            matches!(actual, SubmitResponse::InvalidSubmission(invalid) if invalid.code == "noTestCases")
+        );
+    }
+
+    #[test]
+    fn unloadable_plugins_fail_judgment() {
+        let judge = Judge::new(Config {
+            verdict_plugins: vec![std::env::temp_dir().join("mozart-missing-plugin")],
+            ..Config::default()
+        });
+        let submission: Submission = serde_json::from_value(json!({
+            "language": "haskell",
+            "solution": "solution = 5",
+            "testCases": [{ "id": 0, "inputParameters": [], "outputParameters": [{ "valueType": "int", "value": "5" }] }]
+        }))
+        .unwrap();
+
+        let actual = judge.check(submission);
+
+        assert!(matches!(actual, SubmitResponse::Internal));
+    }
+
+    #[test]
+    fn quick_profile() {
+        let test_case = |id: u64, group: &str| {
+            json!({
+                "id": id,
+                "inputParameters": [],
+                "outputParameters": [{ "valueType": "int", "value": "5" }],
+                "timeLimit": 3000,
+                "group": group
+            })
+        };
+        let mut submission: Submission = serde_json::from_value(json!({
+            "language": "haskell",
+            "solution": "solution = 5",
+            "testCases": (0..8).map(|id| test_case(id, if id < 6 { "basic" } else { "edge" })).collect::<Vec<_>>(),
+            "groups": [{ "name": "basic", "weight": 1 }, { "name": "edge", "weight": 1 }],
+            "profile": "quick"
+        }))
+        .unwrap();
+
+        let stops = apply_profile(&mut submission, &Config::default());
+
+        assert!(stops);
+        assert_eq!(submission.test_cases.len(), 5);
+        assert!(submission
+            .test_cases
+            .iter()
+            .all(|test_case| test_case.time_limit == Some(1000)));
+        assert_eq!(submission.groups.len(), 1);
+    }
+}
@@ -0,0 +1,313 @@
+use crate::{
+    error::ListingError,
+    job::{JobRecord, JobStatus},
+    model::{Language, Verdict},
+    problem::Problem,
+    response::SubmitResponse,
+};
+use axum::{
+    http::StatusCode,
+    response::{IntoResponse, Response},
+};
+use base64::{engine::general_purpose::URL_SAFE_NO_PAD, Engine};
+use serde::{Deserialize, Serialize};
+use std::cmp::Reverse;
+use uuid::Uuid;
+
+/// The number of jobs on a page, if the query does not ask for a number.
+const DEFAULT_LIMIT: usize = 20;
+
+/// The maximum number of jobs on a page.
+const MAX_LIMIT: usize = 100;
+
+/// Which jobs are listed, in which order, and from where.
+#[derive(Deserialize, Default)]
+pub struct TaskQuery {
+    /// Only lists the submissions to the exercise.
+    exercise: Option<String>,
+    /// Only lists the submissions which were checked with the verdict.
+    verdict: Option<Verdict>,
+    status: Option<JobStatus>,
+    /// Only lists the jobs submitted at or after the time, in milliseconds since the unix epoch.
+    since: Option<u64>,
+    /// The cursor of the page, as the `next` of the page before it, where the first page is listed without one.
+    page: Option<String>,
+    /// How many jobs the page has at most.
+    limit: Option<usize>,
+    #[serde(default)]
+    order: Order,
+}
+
+/// The order jobs are listed in, by when they were submitted.
+#[derive(Deserialize, Clone, Copy, PartialEq, Debug, Default)]
+#[serde(rename_all = "lowercase")]
+enum Order {
+    #[default]
+    Newest,
+    Oldest,
+}
+
+/// A page of jobs, along with the cursor of the next page, if there are more jobs.
+#[derive(Serialize)]
+pub struct TaskPage {
+    tasks: Vec<TaskSummary>,
+    next: Option<String>,
+}
+
+/// A job as it is listed, which leaves out its submission and the details of its result.
+#[derive(Serialize)]
+#[serde(rename_all = "camelCase")]
+struct TaskSummary {
+    id: Uuid,
+    status: JobStatus,
+    language: Language,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    exercise_id: Option<String>,
+    /// When the job was accepted, in milliseconds since the unix epoch.
+    submitted_at: u64,
+    /// When the job was done, in milliseconds since the unix epoch.
+    #[serde(skip_serializing_if = "Option::is_none")]
+    finished_at: Option<u64>,
+    /// The verdict of the submission, which is left out unless it has been checked.
+    #[serde(skip_serializing_if = "Option::is_none")]
+    verdict: Option<Verdict>,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    score: Option<f64>,
+}
+
+impl From<&JobRecord> for TaskSummary {
+    fn from(record: &JobRecord) -> Self {
+        let submission = &record.submission;
+        let checked = match &record.result {
+            Some(SubmitResponse::Checked(result)) => Some(result),
+            _ => None,
+        };
+
+        Self {
+            id: record.id,
+            status: record.status,
+            language: Language::deserialize(&submission["language"]).unwrap_or_default(),
+            exercise_id: submission["exerciseId"].as_str().map(str::to_string),
+            submitted_at: record.submitted_at,
+            finished_at: record.finished_at,
+            verdict: checked.map(|result| result.verdict),
+            score: checked.map(|result| result.score),
+        }
+    }
+}
+
+/// The position of a job in the listing, which the next page begins after.
+///
+/// Jobs are ordered by when they were submitted and then by their ids, so the position of a job stays the same while
+/// other jobs are submitted, and a page never repeats or skips a job of the page before it.
+#[derive(Clone, Copy, PartialEq, Debug)]
+struct Cursor {
+    submitted_at: u64,
+    id: Uuid,
+}
+
+impl Cursor {
+    fn of(record: &JobRecord) -> Self {
+        Self {
+            submitted_at: record.submitted_at,
+            id: record.id,
+        }
+    }
+
+    fn encode(self) -> String {
+        URL_SAFE_NO_PAD.encode(format!("{}.{}", self.submitted_at, self.id))
+    }
+
+    fn decode(page: &str) -> Result<Self, ListingError> {
+        let invalid = || ListingError::InvalidCursor(page.to_string());
+        let decoded = URL_SAFE_NO_PAD.decode(page).map_err(|_| invalid())?;
+        let (submitted_at, id) = std::str::from_utf8(&decoded)
+            .ok()
+            .and_then(|decoded| decoded.split_once('.'))
+            .ok_or_else(invalid)?;
+
+        Ok(Self {
+            submitted_at: submitted_at.parse().map_err(|_| invalid())?,
+            id: id.parse().map_err(|_| invalid())?,
+        })
+    }
+}
+
+impl TaskQuery {
+    fn matches(&self, record: &JobRecord) -> bool {
+        let verdict = match &record.result {
+            Some(SubmitResponse::Checked(result)) => Some(result.verdict),
+            _ => None,
+        };
+
+        self.exercise
+            .as_ref()
+            .is_none_or(|exercise| record.submission["exerciseId"] == exercise.as_str())
+            // a failure is wanted whichever reason the verdict policy judged it by
+            && self.verdict.is_none_or(|wanted| {
+                verdict.is_some_and(|verdict| {
+                    verdict == wanted || wanted == Verdict::Failure && verdict.is_failure()
+                })
+            })
+            && self.status.is_none_or(|status| record.status == status)
+            && self.since.is_none_or(|since| record.submitted_at >= since)
+    }
+
+    /// Lists the page of the jobs which match the query.
+    pub fn page(&self, mut records: Vec<JobRecord>) -> Result<TaskPage, ListingError> {
+        let after = self.page.as_deref().map(Cursor::decode).transpose()?;
+        let limit = self.limit.unwrap_or(DEFAULT_LIMIT).clamp(1, MAX_LIMIT);
+
+        records.retain(|record| self.matches(record));
+        let position = |record: &JobRecord| (record.submitted_at, record.id);
+        match self.order {
+            Order::Newest => records.sort_by_key(|record| Reverse(position(record))),
+            Order::Oldest => records.sort_by_key(position),
+        }
+        let beyond = |record: &JobRecord| match (after, self.order) {
+            (None, _) => true,
+            (Some(after), Order::Newest) => position(record) < (after.submitted_at, after.id),
+            (Some(after), Order::Oldest) => position(record) > (after.submitted_at, after.id),
+        };
+
+        let mut page: Vec<&JobRecord> = records
+            .iter()
+            .filter(|record| beyond(record))
+            .take(limit + 1)
+            .collect();
+        let more = page.len() > limit;
+        page.truncate(limit);
+
+        Ok(TaskPage {
+            next: page
+                .last()
+                .filter(|_| more)
+                .map(|record| Cursor::of(record).encode()),
+            tasks: page.into_iter().map(TaskSummary::from).collect(),
+        })
+    }
+}
+
+impl IntoResponse for ListingError {
+    fn into_response(self) -> Response {
+        match self {
+            ListingError::InvalidCursor(_) => {
+                Problem::new(StatusCode::BAD_REQUEST, "invalidCursor", self.to_string())
+                    .into_response()
+            }
+        }
+    }
+}
+
+#[cfg(test)]
+mod pages {
+    use super::{Cursor, Order, TaskQuery};
+    use crate::{
+        error::ListingError,
+        job::{JobRecord, JobStatus},
+        model::{SubmissionResult, Verdict},
+        response::SubmitResponse,
+    };
+    use serde_json::json;
+    use uuid::Uuid;
+
+    fn record(submitted_at: u64, exercise_id: &str, verdict: Option<Verdict>) -> JobRecord {
+        let result = verdict.map(|verdict| {
+            let mut result = SubmissionResult::checked(String::new(), Box::new([]));
+            result.verdict = verdict;
+            SubmitResponse::Checked(result)
+        });
+
+        serde_json::from_value(json!({
+            "id": Uuid::new_v4(),
+            "status": match verdict {
+                Some(_) => "finished",
+                None => "queued",
+            },
+            "submission": { "exerciseId": exercise_id, "language": "python" },
+            "submittedAt": submitted_at,
+            "startedAt": null,
+            "finishedAt": null,
+            "result": result,
+            "progress": [],
+        }))
+        .unwrap()
+    }
+
+    fn ids(records: &[&JobRecord]) -> Vec<String> {
+        records.iter().map(|record| record.id.to_string()).collect()
+    }
+
+    #[test]
+    fn pages_through_jobs() {
+        let records = vec![
+            record(1, "sum", Some(Verdict::Failure)),
+            record(2, "sum", Some(Verdict::Pass)),
+            // a failure decided by its reason is listed as a failure as well
+            record(3, "sum", Some(Verdict::TimeLimitExceeded)),
+            record(4, "other", Some(Verdict::Failure)),
+            record(5, "sum", Some(Verdict::Failure)),
+        ];
+        let query = |page: Option<String>| TaskQuery {
+            exercise: Some(String::from("sum")),
+            verdict: Some(Verdict::Failure),
+            page,
+            limit: Some(2),
+            ..TaskQuery::default()
+        };
+
+        let first = query(None).page(records.clone()).unwrap();
+        let second = query(first.next.clone()).page(records.clone()).unwrap();
+        let first = serde_json::to_value(first).unwrap();
+        let second = serde_json::to_value(second).unwrap();
+
+        let listed = |page: &serde_json::Value| {
+            page["tasks"]
+                .as_array()
+                .unwrap()
+                .iter()
+                .map(|task| task["id"].as_str().unwrap().to_string())
+                .collect::<Vec<_>>()
+        };
+        assert_eq!(listed(&first), ids(&[&records[4], &records[2]]));
+        assert_eq!(first["tasks"][0]["verdict"], "failure");
+        assert_eq!(first["tasks"][0]["exerciseId"], "sum");
+        assert_eq!(listed(&second), ids(&[&records[0]]));
+        assert_eq!(second["next"], serde_json::Value::Null);
+    }
+
+    #[test]
+    fn oldest_since() {
+        let records = vec![
+            record(1, "sum", None),
+            record(2, "sum", None),
+            record(3, "sum", None),
+        ];
+        let query = TaskQuery {
+            since: Some(2),
+            status: Some(JobStatus::Queued),
+            order: Order::Oldest,
+            limit: Some(1),
+            ..TaskQuery::default()
+        };
+
+        let actual = query.page(records.clone()).unwrap();
+
+        assert_eq!(actual.tasks.len(), 1);
+        assert_eq!(actual.tasks[0].id, records[1].id);
+        assert_eq!(actual.next, Some(Cursor::of(&records[1]).encode()));
+    }
+
+    #[test]
+    fn invalid_cursor() {
+        let query = TaskQuery {
+            page: Some(String::from("not a cursor")),
+            ..TaskQuery::default()
+        };
+
+        let actual = query.page(Vec::new());
+
+        assert!(matches!(actual, Err(ListingError::InvalidCursor(page)) if page == "not a cursor"));
+        assert!(Cursor::decode(&Cursor::of(&record(7, "sum", None)).encode()).is_ok());
+    }
+}
@@ -0,0 +1,270 @@
+use crate::{
+    config::{Config, LogFormat, LogLevel},
+    telemetry::{Exporter, TRACEPARENT_FIELD, TRACEPARENT_HEADER},
+};
+use axum::{extract::Request, http::HeaderValue, middleware::Next, response::Response};
+use serde_json::{Map, Value};
+use std::{fmt, time::Instant};
+use tracing::{
+    field::{self, Field, Visit},
+    info, info_span,
+    span::Record,
+    Event, Instrument, Level, Subscriber,
+};
+use tracing_subscriber::{
+    field::RecordFields,
+    filter::{filter_fn, LevelFilter},
+    fmt::{
+        self as format,
+        format::Writer,
+        time::{FormatTime, SystemTime},
+        FmtContext, FormatEvent, FormatFields, FormattedFields,
+    },
+    layer::SubscriberExt,
+    registry::LookupSpan,
+    util::SubscriberInitExt,
+    Layer,
+};
+use uuid::Uuid;
+
+/// The header carrying the correlation id of a request, which is generated if the client does not send one.
+pub const REQUEST_ID_HEADER: &str = "x-request-id";
+
+/// The maximum length of a correlation id sent by a client, longer ids are replaced by a generated one.
+pub const MAX_REQUEST_ID_LEN: usize = 128;
+
+/// Installs the global logger, which writes to stdout at the configured level and in the configured format.
+///
+/// The spans of mozart are exported along with it if an OpenTelemetry collector is configured, regardless of the log
+/// level, down to the `info` level.
+pub fn init(config: &Config) {
+    let filter = LevelFilter::from_level(level(config.log_level));
+    let exporter = Exporter::from_config(config).map(|exporter| {
+        exporter.with_filter(filter_fn(|metadata| {
+            metadata.target().starts_with("mozart") && *metadata.level() <= Level::INFO
+        }))
+    });
+    let registry = tracing_subscriber::registry().with(exporter);
+
+    match config.log_format {
+        LogFormat::Text => registry
+            .with(format::layer().with_ansi(false).with_filter(filter))
+            .init(),
+        LogFormat::Json => registry
+            .with(
+                format::layer()
+                    .with_ansi(false)
+                    .event_format(Json)
+                    .fmt_fields(JsonFields)
+                    .with_filter(filter),
+            )
+            .init(),
+    }
+}
+
+fn level(level: LogLevel) -> Level {
+    match level {
+        LogLevel::Error => Level::ERROR,
+        LogLevel::Warn => Level::WARN,
+        LogLevel::Info => Level::INFO,
+        LogLevel::Debug => Level::DEBUG,
+        LogLevel::Trace => Level::TRACE,
+    }
+}
+
+/// Handles the request within a span carrying its correlation id, which is echoed in [`REQUEST_ID_HEADER`].
+///
+/// The correlation id is taken from the request if it has a usable one, and generated otherwise. The span continues the
+/// trace of the request, if it carries a [`TRACEPARENT_HEADER`].
+pub async fn correlate(request: Request, next: Next) -> Response {
+    let request_id = request
+        .headers()
+        .get(REQUEST_ID_HEADER)
+        .and_then(|value| value.to_str().ok())
+        .filter(|id| !id.is_empty() && id.len() <= MAX_REQUEST_ID_LEN)
+        .map_or_else(|| Uuid::new_v4().to_string(), str::to_string);
+
+    let span = info_span!("request", request_id = %request_id, traceparent = field::Empty);
+    if let Some(traceparent) = request
+        .headers()
+        .get(TRACEPARENT_HEADER)
+        .and_then(|value| value.to_str().ok())
+    {
+        span.record(TRACEPARENT_FIELD, traceparent);
+    }
+    let method = request.method().clone();
+    let path = request.uri().path().to_string();
+    let started = Instant::now();
+
+    let mut response = next.run(request).instrument(span.clone()).await;
+
+    span.in_scope(|| {
+        info!(
+            %method,
+            %path,
+            status = response.status().as_u16(),
+            latency_ms = started.elapsed().as_millis() as u64,
+            "handled request"
+        );
+    });
+    if let Ok(value) = HeaderValue::from_str(&request_id) {
+        response.headers_mut().insert(REQUEST_ID_HEADER, value);
+    }
+
+    response
+}
+
+/// Formats every event as a single JSON object, including the fields of the spans the event occurred in.
+struct Json;
+
+impl<S, N> FormatEvent<S, N> for Json
+where
+    S: Subscriber + for<'a> LookupSpan<'a>,
+    N: for<'a> FormatFields<'a> + 'static,
+{
+    fn format_event(
+        &self,
+        ctx: &FmtContext<'_, S, N>,
+        mut writer: Writer<'_>,
+        event: &Event<'_>,
+    ) -> fmt::Result {
+        let mut timestamp = String::new();
+        SystemTime.format_time(&mut Writer::new(&mut timestamp))?;
+
+        let mut line = Map::new();
+        line.insert("timestamp".to_string(), timestamp.into());
+        line.insert(
+            "level".to_string(),
+            event.metadata().level().as_str().into(),
+        );
+        line.insert("target".to_string(), event.metadata().target().into());
+
+        // the fields of inner spans take precedence over those of outer spans
+        if let Some(scope) = ctx.event_scope() {
+            for span in scope.from_root() {
+                let extensions = span.extensions();
+                let Some(fields) = extensions.get::<FormattedFields<N>>() else {
+                    continue;
+                };
+                if let Ok(Value::Object(fields)) = serde_json::from_str(&fields.fields) {
+                    line.extend(fields);
+                }
+            }
+        }
+        event.record(&mut JsonVisitor(&mut line));
+
+        writeln!(writer, "{}", Value::Object(line))
+    }
+}
+
+/// Formats the fields of spans as a JSON object, so [`Json`] can merge them into the events.
+struct JsonFields;
+
+impl<'writer> FormatFields<'writer> for JsonFields {
+    fn format_fields<R: RecordFields>(
+        &self,
+        mut writer: Writer<'writer>,
+        fields: R,
+    ) -> fmt::Result {
+        let mut map = Map::new();
+        fields.record(&mut JsonVisitor(&mut map));
+
+        write!(writer, "{}", Value::Object(map))
+    }
+
+    fn add_fields(
+        &self,
+        current: &'writer mut FormattedFields<Self>,
+        fields: &Record<'_>,
+    ) -> fmt::Result {
+        let mut map = match serde_json::from_str(&current.fields) {
+            Ok(Value::Object(map)) => map,
+            _ => Map::new(),
+        };
+        fields.record(&mut JsonVisitor(&mut map));
+        current.fields = Value::Object(map).to_string();
+
+        Ok(())
+    }
+}
+
+/// Records fields into a JSON object, keeping numbers and booleans as such.
+struct JsonVisitor<'a>(&'a mut Map<String, Value>);
+
+impl Visit for JsonVisitor<'_> {
+    fn record_f64(&mut self, field: &Field, value: f64) {
+        self.0.insert(field.name().to_string(), value.into());
+    }
+
+    fn record_i64(&mut self, field: &Field, value: i64) {
+        self.0.insert(field.name().to_string(), value.into());
+    }
+
+    fn record_u64(&mut self, field: &Field, value: u64) {
+        self.0.insert(field.name().to_string(), value.into());
+    }
+
+    fn record_bool(&mut self, field: &Field, value: bool) {
+        self.0.insert(field.name().to_string(), value.into());
+    }
+
+    fn record_str(&mut self, field: &Field, value: &str) {
+        self.0.insert(field.name().to_string(), value.into());
+    }
+
+    fn record_debug(&mut self, field: &Field, value: &dyn fmt::Debug) {
+        self.0
+            .insert(field.name().to_string(), format!("{value:?}").into());
+    }
+}
+
+#[cfg(test)]
+mod json {
+    use super::{Json, JsonFields};
+    use serde_json::Value;
+    use std::{
+        io::{self, Write},
+        sync::{Arc, Mutex},
+    };
+    use tracing::{info, info_span};
+
+    /// Collects everything the logger writes.
+    #[derive(Clone, Default)]
+    struct Captured(Arc<Mutex<Vec<u8>>>);
+
+    impl Write for Captured {
+        fn write(&mut self, buf: &[u8]) -> io::Result<usize> {
+            self.0.lock().unwrap().extend_from_slice(buf);
+            Ok(buf.len())
+        }
+
+        fn flush(&mut self) -> io::Result<()> {
+            Ok(())
+        }
+    }
+
+    #[test]
+    fn includes_span_fields() {
+        let captured = Captured::default();
+        let writer = captured.clone();
+        let subscriber = tracing_subscriber::fmt()
+            .event_format(Json)
+            .fmt_fields(JsonFields)
+            .with_writer(move || writer.clone())
+            .finish();
+
+        tracing::subscriber::with_default(subscriber, || {
+            let _request = info_span!("request", request_id = "abc").entered();
+            let _judgment = info_span!("judgment", task = "123").entered();
+            info!(runtime_ms = 12u64, "ran test case");
+        });
+
+        let output = captured.0.lock().unwrap().clone();
+        let line: Value = serde_json::from_slice(&output).expect("a line should be JSON");
+        assert_eq!(line["level"], "INFO");
+        assert_eq!(line["message"], "ran test case");
+        assert_eq!(line["request_id"], "abc");
+        assert_eq!(line["task"], "123");
+        assert_eq!(line["runtime_ms"], 12);
+    }
+}
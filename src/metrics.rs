@@ -0,0 +1,392 @@
+use crate::{pool::WorkerPool, response::SubmitResponse, tenant::Tenants};
+use std::{
+    fmt::Write,
+    sync::atomic::{AtomicU64, Ordering},
+    time::Duration,
+};
+
+/// The metrics of this process, which are exposed at `/metrics`.
+pub static METRICS: Metrics = Metrics::new();
+
+/// The verdicts by which submissions are counted, where the rejections of mozart itself count as verdicts as well.
+const VERDICTS: [&str; 21] = [
+    "pass",
+    "failure",
+    "compilationError",
+    "securityViolation",
+    "wrongAnswer",
+    "runtimeError",
+    "timeLimitExceeded",
+    "memoryLimitExceeded",
+    "outputLimitExceeded",
+    "wrongInteraction",
+    "idlenessLimitExceeded",
+    "testFailed",
+    "invalidSubmission",
+    "busy",
+    "unavailable",
+    "paused",
+    "lowDiskSpace",
+    "cancelled",
+    "timedOut",
+    "quotaExceeded",
+    "internal",
+];
+
+/// The upper bounds in seconds of the buckets of the compile time histogram.
+const COMPILE_BUCKETS: &[f64] = &[0.1, 0.25, 0.5, 1.0, 2.5, 5.0, 10.0, 30.0, 60.0];
+
+/// The upper bounds in seconds of the buckets of the test case run time histogram.
+const RUN_BUCKETS: &[f64] = &[
+    0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1.0, 2.5, 5.0, 10.0,
+];
+
+/// The maximum number of buckets of a histogram, not counting the implicit `+Inf` bucket.
+const MAX_BUCKETS: usize = 12;
+
+/// Counters and histograms of everything mozart does, rendered in the Prometheus text format.
+pub struct Metrics {
+    submissions: AtomicU64,
+    verdicts: [AtomicU64; VERDICTS.len()],
+    sandbox_failures: AtomicU64,
+    compile_cache_hits: AtomicU64,
+    compile_cache_misses: AtomicU64,
+    rate_limited: AtomicU64,
+    rate_limited_clients: AtomicU64,
+    callbacks_delivered: AtomicU64,
+    callback_failures: AtomicU64,
+    panics: AtomicU64,
+    compile_time: Histogram,
+    run_time: Histogram,
+}
+
+impl Metrics {
+    const fn new() -> Self {
+        Self {
+            submissions: AtomicU64::new(0),
+            verdicts: [const { AtomicU64::new(0) }; VERDICTS.len()],
+            sandbox_failures: AtomicU64::new(0),
+            compile_cache_hits: AtomicU64::new(0),
+            compile_cache_misses: AtomicU64::new(0),
+            rate_limited: AtomicU64::new(0),
+            rate_limited_clients: AtomicU64::new(0),
+            callbacks_delivered: AtomicU64::new(0),
+            callback_failures: AtomicU64::new(0),
+            panics: AtomicU64::new(0),
+            compile_time: Histogram::new(COMPILE_BUCKETS),
+            run_time: Histogram::new(RUN_BUCKETS),
+        }
+    }
+
+    /// Counts a received submission, regardless of whether it is checked.
+    pub fn submission_received(&self) {
+        self.submissions.fetch_add(1, Ordering::Relaxed);
+    }
+
+    /// Counts the verdict of the response to a submission.
+    pub fn verdict(&self, response: &SubmitResponse) {
+        let verdict = match response {
+            SubmitResponse::Checked(result) => result.verdict.as_str(),
+            SubmitResponse::InvalidSubmission(_) => "invalidSubmission",
+            SubmitResponse::Busy => "busy",
+            SubmitResponse::Unavailable => "unavailable",
+            SubmitResponse::Paused => "paused",
+            SubmitResponse::LowDiskSpace => "lowDiskSpace",
+            SubmitResponse::Cancelled => "cancelled",
+            SubmitResponse::TimedOut => "timedOut",
+            SubmitResponse::QuotaExceeded(_) => "quotaExceeded",
+            SubmitResponse::Internal => "internal",
+        };
+
+        let index = VERDICTS
+            .iter()
+            .position(|label| *label == verdict)
+            .expect("every verdict has a counter");
+        self.verdicts[index].fetch_add(1, Ordering::Relaxed);
+    }
+
+    /// Counts a command which the sandbox failed to execute.
+    pub fn sandbox_failure(&self) {
+        self.sandbox_failures.fetch_add(1, Ordering::Relaxed);
+    }
+
+    /// Counts a submission whose compilation was restored from the compile cache.
+    pub fn compile_cache_hit(&self) {
+        self.compile_cache_hits.fetch_add(1, Ordering::Relaxed);
+    }
+
+    /// Counts a submission which had to be compiled, as its compilation was not cached.
+    pub fn compile_cache_miss(&self) {
+        self.compile_cache_misses.fetch_add(1, Ordering::Relaxed);
+    }
+
+    /// Counts a submission which was rejected, as its client exceeded the rate limit.
+    pub fn rate_limited(&self) {
+        self.rate_limited.fetch_add(1, Ordering::Relaxed);
+    }
+
+    /// Sets the number of clients which the rate limiter currently tracks.
+    pub fn rate_limited_clients(&self, clients: usize) {
+        self.rate_limited_clients
+            .store(clients as u64, Ordering::Relaxed);
+    }
+
+    /// Counts a callback which was accepted by its receiver.
+    pub fn callback_delivered(&self) {
+        self.callbacks_delivered.fetch_add(1, Ordering::Relaxed);
+    }
+
+    /// Counts a callback which was given up on, after every attempt to deliver it failed.
+    pub fn callback_failure(&self) {
+        self.callback_failures.fetch_add(1, Ordering::Relaxed);
+    }
+
+    /// Counts a panic which was recovered from, failing its request or job instead of the process.
+    pub fn panicked(&self) {
+        self.panics.fetch_add(1, Ordering::Relaxed);
+    }
+
+    /// Records how long it took to compile a submission.
+    pub fn compiled(&self, duration: Duration) {
+        self.compile_time.observe(duration);
+    }
+
+    /// Records how long a single test case ran.
+    pub fn ran(&self, duration: Duration) {
+        self.run_time.observe(duration);
+    }
+
+    /// Renders the metrics in the Prometheus text format, along with the current state of the worker pool and the usage
+    /// of every tenant.
+    pub fn render(&self, pool: &WorkerPool, tenants: &Tenants) -> String {
+        let mut out = String::new();
+
+        counter(
+            &mut out,
+            "mozart_submissions_total",
+            "The number of submissions received.",
+            self.submissions.load(Ordering::Relaxed),
+        );
+
+        header(
+            &mut out,
+            "mozart_verdicts_total",
+            "The number of responses to submissions by verdict.",
+            "counter",
+        );
+        for (verdict, count) in VERDICTS.iter().zip(&self.verdicts) {
+            let count = count.load(Ordering::Relaxed);
+            let _ = writeln!(
+                out,
+                "mozart_verdicts_total{{verdict=\"{verdict}\"}} {count}"
+            );
+        }
+
+        counter(
+            &mut out,
+            "mozart_sandbox_failures_total",
+            "The number of commands which the sandbox failed to execute.",
+            self.sandbox_failures.load(Ordering::Relaxed),
+        );
+
+        counter(
+            &mut out,
+            "mozart_compile_cache_hits_total",
+            "The number of compilations restored from the compile cache.",
+            self.compile_cache_hits.load(Ordering::Relaxed),
+        );
+        counter(
+            &mut out,
+            "mozart_compile_cache_misses_total",
+            "The number of compilations which were not cached.",
+            self.compile_cache_misses.load(Ordering::Relaxed),
+        );
+
+        counter(
+            &mut out,
+            "mozart_rate_limited_total",
+            "The number of submissions rejected by the rate limiter.",
+            self.rate_limited.load(Ordering::Relaxed),
+        );
+
+        counter(
+            &mut out,
+            "mozart_callbacks_delivered_total",
+            "The number of callbacks accepted by their receiver.",
+            self.callbacks_delivered.load(Ordering::Relaxed),
+        );
+        counter(
+            &mut out,
+            "mozart_callback_failures_total",
+            "The number of callbacks which could not be delivered.",
+            self.callback_failures.load(Ordering::Relaxed),
+        );
+
+        counter(
+            &mut out,
+            "mozart_panics_total",
+            "The number of panics recovered from, which failed their request or job instead of the process.",
+            self.panics.load(Ordering::Relaxed),
+        );
+
+        self.compile_time.render(
+            &mut out,
+            "mozart_compile_duration_seconds",
+            "The time it took to compile submissions.",
+        );
+        self.run_time.render(
+            &mut out,
+            "mozart_test_case_duration_seconds",
+            "The wall-clock time test cases ran for.",
+        );
+
+        gauge(
+            &mut out,
+            "mozart_queue_depth",
+            "The number of submissions waiting for a worker.",
+            pool.queue_depth(),
+        );
+        gauge(
+            &mut out,
+            "mozart_active_workers",
+            "The number of workers currently checking a submission.",
+            pool.active_workers(),
+        );
+        gauge(
+            &mut out,
+            "mozart_rate_limiter_clients",
+            "The number of clients tracked by the rate limiter.",
+            self.rate_limited_clients.load(Ordering::Relaxed) as usize,
+        );
+        tenants.render(&mut out);
+
+        out
+    }
+}
+
+/// A histogram of durations with fixed buckets.
+struct Histogram {
+    bounds: &'static [f64],
+    /// The number of observations in each bucket, which is not cumulative, where the last bucket is `+Inf`.
+    buckets: [AtomicU64; MAX_BUCKETS + 1],
+    count: AtomicU64,
+    sum_micros: AtomicU64,
+}
+
+impl Histogram {
+    const fn new(bounds: &'static [f64]) -> Self {
+        assert!(bounds.len() <= MAX_BUCKETS);
+
+        Self {
+            bounds,
+            buckets: [const { AtomicU64::new(0) }; MAX_BUCKETS + 1],
+            count: AtomicU64::new(0),
+            sum_micros: AtomicU64::new(0),
+        }
+    }
+
+    fn observe(&self, duration: Duration) {
+        let seconds = duration.as_secs_f64();
+        let index = self
+            .bounds
+            .iter()
+            .position(|bound| seconds <= *bound)
+            .unwrap_or(MAX_BUCKETS);
+
+        self.buckets[index].fetch_add(1, Ordering::Relaxed);
+        self.count.fetch_add(1, Ordering::Relaxed);
+        let micros = u64::try_from(duration.as_micros()).unwrap_or(u64::MAX);
+        self.sum_micros.fetch_add(micros, Ordering::Relaxed);
+    }
+
+    fn render(&self, out: &mut String, name: &str, help: &str) {
+        header(out, name, help, "histogram");
+
+        let mut cumulative = 0;
+        for (bound, bucket) in self.bounds.iter().zip(&self.buckets) {
+            cumulative += bucket.load(Ordering::Relaxed);
+            let _ = writeln!(out, "{name}_bucket{{le=\"{bound}\"}} {cumulative}");
+        }
+
+        let count = self.count.load(Ordering::Relaxed);
+        let sum = self.sum_micros.load(Ordering::Relaxed) as f64 / 1_000_000.0;
+        let _ = writeln!(out, "{name}_bucket{{le=\"+Inf\"}} {count}");
+        let _ = writeln!(out, "{name}_sum {sum}");
+        let _ = writeln!(out, "{name}_count {count}");
+    }
+}
+
+pub fn header(out: &mut String, name: &str, help: &str, kind: &str) {
+    let _ = writeln!(out, "# HELP {name} {help}");
+    let _ = writeln!(out, "# TYPE {name} {kind}");
+}
+
+fn counter(out: &mut String, name: &str, help: &str, value: u64) {
+    header(out, name, help, "counter");
+    let _ = writeln!(out, "{name} {value}");
+}
+
+fn gauge(out: &mut String, name: &str, help: &str, value: usize) {
+    header(out, name, help, "gauge");
+    let _ = writeln!(out, "{name} {value}");
+}
+
+#[cfg(test)]
+mod render {
+    use super::Metrics;
+    use crate::{
+        config::Config, exercise::Exercises, fixture::Fixtures, pool::WorkerPool,
+        response::SubmitResponse, tenant::Tenants,
+    };
+    use std::{sync::Arc, time::Duration};
+
+    fn no_tenants() -> Tenants {
+        let config = Config::default();
+        let fixtures = Arc::new(Fixtures::from(&config));
+        let exercises = Arc::new(Exercises::from(&config));
+
+        Tenants::new(Arc::new(config), fixtures, exercises)
+    }
+
+    #[test]
+    fn histogram_is_cumulative() {
+        let metrics = Metrics::new();
+
+        metrics.ran(Duration::from_millis(3));
+        metrics.ran(Duration::from_millis(40));
+        metrics.ran(Duration::from_secs(60));
+        let actual = metrics.render(&WorkerPool::new(1, 0), &no_tenants());
+
+        assert!(actual.contains("mozart_test_case_duration_seconds_bucket{le=\"0.005\"} 1\n"));
+        assert!(actual.contains("mozart_test_case_duration_seconds_bucket{le=\"0.05\"} 2\n"));
+        assert!(actual.contains("mozart_test_case_duration_seconds_bucket{le=\"10\"} 2\n"));
+        assert!(actual.contains("mozart_test_case_duration_seconds_bucket{le=\"+Inf\"} 3\n"));
+        assert!(actual.contains("mozart_test_case_duration_seconds_count 3\n"));
+    }
+
+    #[test]
+    fn verdicts() {
+        let metrics = Metrics::new();
+
+        metrics.verdict(&SubmitResponse::Busy);
+        metrics.verdict(&SubmitResponse::Busy);
+        metrics.verdict(&SubmitResponse::Internal);
+        let actual = metrics.render(&WorkerPool::new(1, 0), &no_tenants());
+
+        assert!(actual.contains("mozart_verdicts_total{verdict=\"busy\"} 2\n"));
+        assert!(actual.contains("mozart_verdicts_total{verdict=\"internal\"} 1\n"));
+        assert!(actual.contains("mozart_verdicts_total{verdict=\"pass\"} 0\n"));
+    }
+
+    #[test]
+    fn pool_gauges() {
+        let metrics = Metrics::new();
+        let pool = WorkerPool::new(1, 2);
+
+        let _first = pool.admit();
+        let _second = pool.admit();
+        let actual = metrics.render(&pool, &no_tenants());
+
+        assert!(actual.contains("mozart_queue_depth 1\n"));
+    }
+}
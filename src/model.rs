@@ -1,25 +1,977 @@
+use crate::{
+    blob, callback,
+    compare::Comparison,
+    error::SubmissionError,
+    files, fixture,
+    revision::Revision,
+    score::{GroupScore, Scoring, TestGroup},
+};
 use serde::{Deserialize, Serialize};
+use std::collections::{BTreeMap, HashSet};
+use uuid::Uuid;
 
-#[derive(Deserialize)]
+#[derive(Deserialize, Serialize)]
 pub struct Submission {
+    /// The language of the solution, which defaults to haskell.
+    #[serde(default)]
+    pub language: Language,
     pub solution: String,
+    /// The other files of the solution by their path within the workspace, with their contents encoded in base64.
+    #[serde(default, skip_serializing_if = "BTreeMap::is_empty")]
+    pub files: BTreeMap<String, String>,
+    /// The uploaded blobs which are laid out like files, by their path within the workspace, with the SHA-256 of their
+    /// contents.
+    #[serde(default, skip_serializing_if = "BTreeMap::is_empty")]
+    pub blobs: BTreeMap<String, String>,
     #[serde(rename = "testCases")]
     pub test_cases: Box<[TestCase]>,
+    /// The memory limit of the submission in mebibytes, which is capped by the server.
+    #[serde(rename = "memoryLimit")]
+    pub memory_limit: Option<u64>,
+    /// The time limit of the test cases in milliseconds, which a test case may override, and is capped by the server.
+    #[serde(rename = "timeLimit", default, skip_serializing_if = "Option::is_none")]
+    pub time_limit: Option<u64>,
+    /// How many processes and threads every test case may have at once, which is capped by the server.
+    #[serde(
+        rename = "processLimit",
+        default,
+        skip_serializing_if = "Option::is_none"
+    )]
+    pub process_limit: Option<u64>,
+    /// How many mebibytes every test case may write into its workspace, which is capped by the server.
+    #[serde(rename = "diskLimit", default, skip_serializing_if = "Option::is_none")]
+    pub disk_limit: Option<u64>,
+    /// A program which judges the output of test cases, instead of comparing it to the expected output.
+    pub checker: Option<Checker>,
+    /// The exercise the submission belongs to, by which its job can be rejudged along with the rest of the exercise.
+    #[serde(rename = "exerciseId")]
+    pub exercise_id: Option<String>,
+    /// A program which converses with the solution during every test case, which makes the submission interactive.
+    pub interactor: Option<Interactor>,
+    /// A http(s) URL the result is posted to once the submission is judged.
+    #[serde(rename = "callbackUrl")]
+    pub callback_url: Option<String>,
+    /// Whether the solution is analyzed by the linter of its language once it compiles.
+    #[serde(default)]
+    pub analyze: bool,
+    /// Whether the solution is fingerprinted, so it can be compared with the other submissions to its exercise.
+    #[serde(default, skip_serializing_if = "std::ops::Not::not")]
+    pub fingerprint: bool,
+    /// The groups test cases may belong to, which are scored as a whole.
+    #[serde(default)]
+    pub groups: Vec<TestGroup>,
+    /// How the test cases of a group are scored, which defaults to all or nothing.
+    #[serde(default)]
+    pub scoring: Scoring,
+    /// What the test cases may connect to over the network, which is nothing by default.
+    #[serde(default, skip_serializing_if = "NetworkPolicy::is_none")]
+    pub network: NetworkPolicy,
+    /// The judging profile the submission is checked with, e.g. `quick` for feedback while editing, where every test
+    /// case is run with its own limits if not given.
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub profile: Option<String>,
+    /// The version of the toolchain the submission is checked with, which is one of the versions of its language
+    /// configured by the server, where the configured toolchain of its language is used if not given.
+    #[serde(
+        rename = "toolchainVersion",
+        default,
+        skip_serializing_if = "Option::is_none"
+    )]
+    pub toolchain_version: Option<String>,
+    /// Whether the test cases stop at the first one which does not pass, like a `maxFailures` of 1.
+    #[serde(
+        rename = "stopOnFirstFail",
+        default,
+        skip_serializing_if = "std::ops::Not::not"
+    )]
+    pub stop_on_first_fail: bool,
+    /// How many test cases may not pass before the rest are skipped, where every test case is run if not given.
+    #[serde(
+        rename = "maxFailures",
+        default,
+        skip_serializing_if = "Option::is_none"
+    )]
+    pub max_failures: Option<usize>,
+    /// Whether the artifacts the solution compiles to are kept, so they can be downloaded from its job.
+    #[serde(
+        rename = "exportArtifact",
+        default,
+        skip_serializing_if = "std::ops::Not::not"
+    )]
+    pub export_artifact: bool,
+    /// Whether what the solution and the interactor write to each other is recorded, so the transcript of every test
+    /// case can be downloaded from its job.
+    #[serde(
+        rename = "recordTranscript",
+        default,
+        skip_serializing_if = "std::ops::Not::not"
+    )]
+    pub record_transcript: bool,
+    /// The seed the test cases are given in `MOZART_SEED`, so randomized test code can be replayed exactly, where a
+    /// random seed is chosen if not given.
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub seed: Option<u64>,
+    /// The digest of the docker image the submission is checked in, e.g. `sha256:...`, which pins the image of its
+    /// language rather than using whatever its tag refers to at the time.
+    #[serde(
+        rename = "imageDigest",
+        default,
+        skip_serializing_if = "Option::is_none"
+    )]
+    pub image_digest: Option<String>,
+    /// Runs the test cases which pass again and again, so their runtimes can be compared rather than only whether they
+    /// pass.
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub benchmark: Option<Benchmark>,
+    /// Which failing test case decides the submission, which overrides the policy of the server, e.g. for an exercise
+    /// where exceeding the time limit weighs more than a wrong answer.
+    #[serde(
+        rename = "verdictPolicy",
+        default,
+        skip_serializing_if = "Option::is_none"
+    )]
+    pub verdict_policy: Option<VerdictPolicy>,
+    /// Whether the test cases are the tests of the test files of the submission, i.e. Test functions of go, JUnit tests
+    /// of java, and pytest tests of python, which are run by their name rather than compared by their parameters,
+    /// where every test the test files declare is a test case if none are given.
+    #[serde(
+        rename = "testSuite",
+        default,
+        skip_serializing_if = "std::ops::Not::not"
+    )]
+    pub test_suite: bool,
+    /// The priority the submission waits for a worker with, which is at most the priority its caller may use, and
+    /// defaults to it.
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub priority: Option<Priority>,
+    /// The job of an earlier attempt at the same task, which the result is compared with, so it tells which test cases
+    /// the change fixed and which it broke.
+    #[serde(
+        rename = "previousTaskId",
+        default,
+        skip_serializing_if = "Option::is_none"
+    )]
+    pub previous_task_id: Option<Uuid>,
+    /// Whether the results of the exercise the submission belongs to may differ between runs of the same solution,
+    /// e.g. as its test cases are randomized, so the result of an earlier attempt with the same solution is not reused.
+    #[serde(default, skip_serializing_if = "std::ops::Not::not")]
+    pub nondeterministic: bool,
+    /// The script run before the test cases, e.g. to generate the data they read.
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub setup: Option<Hook>,
+    /// The script run after the test cases, whose failure does not affect the verdict.
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub teardown: Option<Hook>,
+}
+
+/// How the test cases of a benchmarked submission are run again once they pass.
+#[derive(Deserialize, Serialize, Clone, Debug, PartialEq)]
+pub struct Benchmark {
+    /// How many timed runs every benchmarked test case has.
+    #[serde(default = "default_benchmark_runs")]
+    pub runs: usize,
+    /// How many runs precede the timed runs, e.g. to fill the page cache, whose runtimes are discarded.
+    #[serde(default)]
+    pub warmup: usize,
+    /// The ids of the test cases which are benchmarked, where every test case is if none are given.
+    #[serde(rename = "testCases", default, skip_serializing_if = "Vec::is_empty")]
+    pub test_cases: Vec<u64>,
+}
+
+impl Benchmark {
+    /// The most runs a test case may have, counting the judged run, the warmup runs, and the timed runs.
+    pub const MAX_RUNS: usize = 100;
+
+    /// Whether the test case is benchmarked.
+    pub fn includes(&self, test_case: &TestCase) -> bool {
+        self.test_cases.is_empty() || self.test_cases.contains(&test_case.id)
+    }
+}
+
+fn default_benchmark_runs() -> usize {
+    5
+}
+
+/// What the test cases of a submission may connect to over the network, e.g. a mock server provided by the exercise.
+#[derive(Deserialize, Serialize, Clone, PartialEq, Eq, Debug, Default)]
+pub enum NetworkPolicy {
+    /// The test cases have no network access at all.
+    #[default]
+    #[serde(rename = "none")]
+    None,
+
+    /// The test cases may only connect to servers on the loopback interface of their own sandbox, e.g. one they start
+    /// themselves, which is not the loopback interface of the host.
+    #[serde(rename = "loopback-only")]
+    LoopbackOnly,
+
+    /// The test cases may connect to the given `host:port` endpoints, which are forwarded to from the same port on the
+    /// loopback interface of their sandbox, and to nothing else.
+    #[serde(rename = "allow")]
+    Allow(Box<[String]>),
+}
+
+impl NetworkPolicy {
+    pub fn is_none(&self) -> bool {
+        *self == Self::None
+    }
+
+    /// Gets the endpoints the test cases may connect to, which are none unless they are allowed explicitly.
+    pub fn endpoints(&self) -> &[String] {
+        match self {
+            Self::Allow(endpoints) => endpoints,
+            Self::None | Self::LoopbackOnly => &[],
+        }
+    }
+}
+
+/// Whether the digest is a sha256 digest of a docker image, the only algorithm docker uses.
+fn is_valid_digest(digest: &str) -> bool {
+    digest.strip_prefix("sha256:").is_some_and(|hash| {
+        hash.len() == 64
+            && hash
+                .bytes()
+                .all(|byte| byte.is_ascii_digit() || (b'a'..=b'f').contains(&byte))
+    })
+}
+
+/// Whether the file at the path is a test file of a test suite in the language, i.e. the `_test.go` files of go, the
+/// files of java named like the test classes maven runs, and the `test_*.py` and `*_test.py` files of python.
+pub fn is_test_file(language: Language, path: &str) -> bool {
+    let name = path.rsplit('/').next().unwrap_or(path);
+    match language {
+        Language::Go => name.ends_with("_test.go"),
+        Language::Java => name.strip_suffix(".java").is_some_and(|class| {
+            class.starts_with("Test")
+                || class.ends_with("Test")
+                || class.ends_with("Tests")
+                || class.ends_with("TestCase")
+        }),
+        Language::Python => name
+            .strip_suffix(".py")
+            .is_some_and(|module| module.starts_with("test_") || module.ends_with("_test")),
+        Language::Haskell | Language::C | Language::Sql => false,
+    }
+}
+
+/// Whether the name selects a single test of a test suite in the language, which is also safe to pass to its framework:
+/// a Test function of go, i.e. `Test` followed by anything but a lowercase letter, the method of a JUnit test class like
+/// `sum.SumTest#large`, or the node id of a pytest test like `test_sum.py::TestSum::test_large`.
+pub fn is_test_name(language: Language, name: &str) -> bool {
+    match language {
+        Language::Go => name.strip_prefix("Test").is_some_and(|rest| {
+            !rest.starts_with(|char: char| char.is_lowercase())
+                && rest
+                    .chars()
+                    .all(|char| char.is_alphanumeric() || char == '_')
+        }),
+        Language::Java => name.split_once('#').is_some_and(|(class, method)| {
+            class.split('.').all(is_identifier) && is_identifier(method)
+        }),
+        Language::Python => name.split_once("::").is_some_and(|(path, rest)| {
+            is_test_file(Language::Python, path)
+                && path
+                    .split('/')
+                    .all(|component| !matches!(component, "" | "." | ".."))
+                && rest.split("::").all(is_identifier)
+        }),
+        Language::Haskell | Language::C | Language::Sql => false,
+    }
+}
+
+fn is_identifier(name: &str) -> bool {
+    !name.starts_with(|char: char| char.is_ascii_digit())
+        && !name.is_empty()
+        && name
+            .chars()
+            .all(|char| char.is_alphanumeric() || char == '_' || char == '$')
+}
+
+/// Gets the port of a `host:port` endpoint, unless it is not a valid endpoint.
+pub fn endpoint_port(endpoint: &str) -> Option<u16> {
+    let (host, port) = endpoint.rsplit_once(':')?;
+    let port = port.parse().ok().filter(|&port| port > 0)?;
+
+    (!host.is_empty() && !host.contains(char::is_whitespace)).then_some(port)
+}
+
+/// The limits of the size of a submission, which are configured by the server.
+#[derive(Clone, Debug, PartialEq)]
+pub struct SubmissionLimits {
+    /// How many bytes the solution may have, along with the decoded contents of its other files.
+    pub solution_size: usize,
+    pub test_cases: usize,
+    /// The environment variables test cases may set, where a name ending in `*` allows every name with its prefix.
+    pub allowed_env: Vec<String>,
+    /// The `host:port` endpoints the network policy of a submission may allow.
+    pub allowed_endpoints: Vec<String>,
+    /// The only hosts a callback may be sent to, where every host may be if none are given.
+    pub callback_hosts: Vec<String>,
+    /// The names of the judging profiles a submission may select.
+    pub profiles: Vec<String>,
+    /// The names of the versions of the toolchain of each language a submission may ask for.
+    pub versions: BTreeMap<Language, Vec<String>>,
+    pub caps: LimitCaps,
+}
+
+/// The maxima of the limits a submission may set, which are configured by the server.
+#[derive(Clone, Copy, Debug, PartialEq)]
+pub struct LimitCaps {
+    /// The maximum time limit of a test case in milliseconds.
+    pub time: u64,
+    /// The maximum memory limit in mebibytes.
+    pub memory: u64,
+    /// The maximum number of processes and threads, where zero is unlimited.
+    pub processes: u64,
+    /// The maximum disk limit in mebibytes.
+    pub disk: u64,
+}
+
+impl LimitCaps {
+    /// Caps which allow every limit.
+    pub const UNCAPPED: Self = Self {
+        time: u64::MAX,
+        memory: u64::MAX,
+        processes: 0,
+        disk: u64::MAX,
+    };
+
+    /// Checks that no limit of the submission, or of its test cases, is above its cap.
+    ///
+    /// A process limit of zero is unlimited, so it is above every cap but zero.
+    fn check(&self, submission: &Submission) -> Result<(), SubmissionError> {
+        let time_limits = submission
+            .time_limit
+            .into_iter()
+            .chain(
+                submission
+                    .test_cases
+                    .iter()
+                    .filter_map(|test_case| test_case.time_limit),
+            )
+            .chain(submission.hooks().filter_map(|(_, hook)| hook.time_limit));
+        for time_limit in time_limits {
+            if time_limit > self.time {
+                return Err(SubmissionError::LimitTooHigh(
+                    "time limit in milliseconds",
+                    time_limit,
+                    self.time,
+                ));
+            }
+        }
+
+        if let Some(memory_limit) = submission.memory_limit.filter(|limit| *limit > self.memory) {
+            return Err(SubmissionError::LimitTooHigh(
+                "memory limit in mebibytes",
+                memory_limit,
+                self.memory,
+            ));
+        }
+
+        let unlimited = self.processes == 0;
+        if let Some(process_limit) = submission
+            .process_limit
+            .filter(|limit| !unlimited && (*limit == 0 || *limit > self.processes))
+        {
+            return Err(SubmissionError::LimitTooHigh(
+                "process limit",
+                process_limit,
+                self.processes,
+            ));
+        }
+
+        if let Some(disk_limit) = submission.disk_limit.filter(|limit| *limit > self.disk) {
+            return Err(SubmissionError::LimitTooHigh(
+                "disk limit in mebibytes",
+                disk_limit,
+                self.disk,
+            ));
+        }
+
+        Ok(())
+    }
+}
+
+impl SubmissionLimits {
+    /// Whether test cases may set the environment variable, which must be a valid name as well as allowed.
+    fn allows_env(&self, name: &str) -> bool {
+        let valid = name
+            .chars()
+            .next()
+            .is_some_and(|first| first.is_ascii_alphabetic() || first == '_')
+            && name
+                .chars()
+                .all(|char| char.is_ascii_alphanumeric() || char == '_');
+
+        valid
+            && self
+                .allowed_env
+                .iter()
+                .any(|allowed| match allowed.strip_suffix('*') {
+                    Some(prefix) => name.starts_with(prefix),
+                    None => name == allowed,
+                })
+    }
 }
 
 impl Submission {
     pub fn into_inner(self) -> (String, Box<[TestCase]>) {
         (self.solution, self.test_cases)
     }
+
+    /// Gets the setup and teardown scripts of the submission which it has, by their names.
+    pub fn hooks(&self) -> impl Iterator<Item = (&'static str, &Hook)> {
+        [("setup", &self.setup), ("teardown", &self.teardown)]
+            .into_iter()
+            .filter_map(|(name, hook)| Some((name, hook.as_ref()?)))
+    }
+
+    /// Gets how many test cases may not pass before the rest are skipped, if the submission is stopped short at all.
+    pub fn failure_limit(&self) -> Option<usize> {
+        match self.stop_on_first_fail {
+            true => Some(1),
+            false => self.max_failures,
+        }
+    }
+
+    /// Settles the priority of the submission, which is the priority it asks for, or the default if it asks for none,
+    /// but never higher than the priority its caller is allowed.
+    pub fn prioritize(&mut self, allowed: Priority, default: Priority) -> Priority {
+        let priority = self.priority.unwrap_or(default).min(allowed);
+        self.priority = Some(priority);
+
+        priority
+    }
+
+    pub fn priority(&self) -> Priority {
+        self.priority.unwrap_or_default()
+    }
+
+    /// Validates the structure of the submission before it is checked, and that it is within the limits.
+    pub fn validate(&self, limits: &SubmissionLimits) -> Result<(), SubmissionError> {
+        if self.solution.trim().is_empty() {
+            return Err(SubmissionError::EmptySolution);
+        }
+
+        if self.test_cases.is_empty() && !self.test_suite {
+            return Err(SubmissionError::NoTestCases);
+        }
+
+        if self.test_suite
+            && matches!(
+                self.language,
+                Language::Haskell | Language::C | Language::Sql
+            )
+        {
+            return Err(SubmissionError::NoTestFramework(self.language));
+        }
+
+        if self.test_suite
+            && !self
+                .files
+                .keys()
+                .any(|path| is_test_file(self.language, path))
+        {
+            return Err(SubmissionError::NoTestFiles);
+        }
+
+        if self.test_cases.len() > limits.test_cases {
+            return Err(SubmissionError::TooManyTestCases(limits.test_cases));
+        }
+
+        limits.caps.check(self)?;
+
+        let mut names = HashSet::with_capacity(self.groups.len());
+        for group in &self.groups {
+            if !names.insert(group.name.as_str()) {
+                return Err(SubmissionError::DuplicateGroup(group.name.clone()));
+            }
+
+            if group.weight == 0 {
+                return Err(SubmissionError::ZeroGroupWeight(group.name.clone()));
+            }
+
+            if group.weight > TestCase::MAX_WEIGHT {
+                return Err(SubmissionError::GroupWeightTooHigh(
+                    group.name.clone(),
+                    TestCase::MAX_WEIGHT,
+                ));
+            }
+
+            if !self
+                .test_cases
+                .iter()
+                .any(|test_case| test_case.group.as_ref() == Some(&group.name))
+            {
+                return Err(SubmissionError::EmptyGroup(group.name.clone()));
+            }
+        }
+
+        let mut ids = HashSet::with_capacity(self.test_cases.len());
+        for test_case in self.test_cases.iter() {
+            if !ids.insert(test_case.id) {
+                return Err(SubmissionError::DuplicateTestCaseId(test_case.id));
+            }
+
+            // the test cases of a test suite are run by their name, and judged by their test
+            if self.test_suite {
+                let is_test = |name| is_test_name(self.language, name);
+                if !test_case.name.as_deref().is_some_and(is_test) {
+                    return Err(SubmissionError::InvalidTestName(test_case.id));
+                }
+            } else if test_case.output_parameters.is_empty() {
+                return Err(SubmissionError::NoOutputParameters(test_case.id));
+            }
+
+            if test_case.weight == 0 {
+                return Err(SubmissionError::ZeroWeight(test_case.id));
+            }
+
+            if test_case.weight > TestCase::MAX_WEIGHT {
+                return Err(SubmissionError::WeightTooHigh(
+                    test_case.id,
+                    TestCase::MAX_WEIGHT,
+                ));
+            }
+
+            if let Some(group) = test_case
+                .group
+                .as_ref()
+                .filter(|group| !names.contains(group.as_str()))
+            {
+                return Err(SubmissionError::UnknownGroup(test_case.id, group.clone()));
+            }
+
+            if test_case
+                .epsilon
+                .is_some_and(|epsilon| !epsilon.is_finite() || epsilon < 0.0)
+            {
+                return Err(SubmissionError::InvalidEpsilon(test_case.id));
+            }
+
+            if let Some(id) = test_case.fixture_ids().find(|id| !fixture::is_valid_id(id)) {
+                return Err(SubmissionError::InvalidFixtureId(
+                    test_case.id,
+                    id.to_string(),
+                ));
+            }
+
+            if test_case.stdin.is_some() && self.interactor.is_some() {
+                return Err(SubmissionError::InteractiveStdin(test_case.id));
+            }
+
+            if let Some((name, _)) = test_case
+                .env
+                .iter()
+                .find(|(name, value)| !limits.allows_env(name) || value.contains('\0'))
+            {
+                return Err(SubmissionError::EnvNotAllowed(test_case.id, name.clone()));
+            }
+
+            for (path, contents) in &test_case.work_dir {
+                if !files::is_valid_path(path) {
+                    return Err(SubmissionError::InvalidFilePath(path.clone()));
+                }
+
+                if files::decode(contents).is_none() {
+                    return Err(SubmissionError::InvalidFileContents(path.clone()));
+                }
+            }
+        }
+
+        for (path, contents) in &self.files {
+            if !files::is_valid_path(path) {
+                return Err(SubmissionError::InvalidFilePath(path.clone()));
+            }
+
+            if files::decode(contents).is_none() {
+                return Err(SubmissionError::InvalidFileContents(path.clone()));
+            }
+        }
+
+        for (path, hash) in &self.blobs {
+            if !files::is_valid_path(path) {
+                return Err(SubmissionError::InvalidFilePath(path.clone()));
+            }
+
+            if !blob::is_valid_hash(hash) {
+                return Err(SubmissionError::InvalidBlobHash(path.clone()));
+            }
+
+            if self.files.contains_key(path) {
+                return Err(SubmissionError::BlobReplacesFile(path.clone()));
+            }
+        }
+
+        // base64 encodes three bytes in four characters, which is close enough to the decoded size
+        let size = self.solution.len()
+            + self
+                .files
+                .values()
+                .map(|contents| contents.len() / 4 * 3)
+                .sum::<usize>();
+        if size > limits.solution_size {
+            return Err(SubmissionError::SolutionTooLarge(limits.solution_size));
+        }
+
+        if let Some(name) = self
+            .hooks()
+            .find_map(|(name, hook)| hook.script.trim().is_empty().then_some(name))
+        {
+            return Err(SubmissionError::EmptyHook(name));
+        }
+
+        let sources = [
+            ("setup script", self.setup.as_ref().map(|hook| &hook.script)),
+            (
+                "teardown script",
+                self.teardown.as_ref().map(|hook| &hook.script),
+            ),
+            (
+                "checker",
+                self.checker.as_ref().map(|checker| &checker.source),
+            ),
+            (
+                "interactor",
+                self.interactor
+                    .as_ref()
+                    .map(|interactor| &interactor.source),
+            ),
+        ];
+        for (program, source) in sources {
+            if source.is_some_and(|source| source.len() > limits.solution_size) {
+                return Err(SubmissionError::SourceTooLarge(
+                    program,
+                    limits.solution_size,
+                ));
+            }
+        }
+
+        let mut ports = HashSet::with_capacity(self.network.endpoints().len());
+        for endpoint in self.network.endpoints() {
+            // every endpoint is forwarded to from its port, so no two endpoints may share one
+            if !endpoint_port(endpoint).is_some_and(|port| ports.insert(port)) {
+                return Err(SubmissionError::InvalidEndpoint(endpoint.clone()));
+            }
+
+            if !limits.allowed_endpoints.contains(endpoint) {
+                return Err(SubmissionError::EndpointNotAllowed(endpoint.clone()));
+            }
+        }
+
+        if self.max_failures == Some(0) {
+            return Err(SubmissionError::ZeroMaxFailures);
+        }
+
+        if let Some(benchmark) = &self.benchmark {
+            if benchmark.runs == 0 || benchmark.runs + benchmark.warmup >= Benchmark::MAX_RUNS {
+                return Err(SubmissionError::InvalidBenchmarkRuns(
+                    Benchmark::MAX_RUNS - 1,
+                ));
+            }
+
+            if let Some(&id) = benchmark.test_cases.iter().find(|&&id| !ids.contains(&id)) {
+                return Err(SubmissionError::UnknownBenchmarkTestCase(id));
+            }
+        }
+
+        if self.fingerprint && self.exercise_id.is_none() {
+            return Err(SubmissionError::FingerprintWithoutExercise);
+        }
+
+        if self.record_transcript && self.interactor.is_none() {
+            return Err(SubmissionError::TranscriptWithoutInteractor);
+        }
+
+        if let Some(digest) = self
+            .image_digest
+            .as_ref()
+            .filter(|digest| !is_valid_digest(digest))
+        {
+            return Err(SubmissionError::InvalidImageDigest(digest.clone()));
+        }
+
+        if let Some(profile) = self
+            .profile
+            .as_ref()
+            .filter(|profile| !limits.profiles.contains(profile))
+        {
+            return Err(SubmissionError::UnknownProfile(profile.clone()));
+        }
+
+        if let Some(version) = &self.toolchain_version {
+            let versions = limits
+                .versions
+                .get(&self.language)
+                .map(Vec::as_slice)
+                .unwrap_or_default();
+            if !versions.contains(version) {
+                return Err(SubmissionError::UnknownVersion(
+                    self.language,
+                    version.clone(),
+                    versions.to_vec(),
+                ));
+            }
+        }
+
+        if let Some(url) = &self.callback_url {
+            let host = callback::host(url);
+            if !url.starts_with("http://") && !url.starts_with("https://") || host.is_none() {
+                return Err(SubmissionError::InvalidCallbackUrl(url.clone()));
+            }
+
+            if !limits.callback_hosts.is_empty()
+                && host.is_some_and(|host| !callback::is_trusted(&limits.callback_hosts, &host))
+            {
+                return Err(SubmissionError::CallbackNotAllowed(url.clone()));
+            }
+        }
+
+        if !self.language.is_supported() {
+            return Err(SubmissionError::UnsupportedLanguage(self.language));
+        }
+
+        if let Some(checker) = &self.checker {
+            let language = checker.language.unwrap_or(self.language);
+            if !language.is_supported() {
+                return Err(SubmissionError::UnsupportedLanguage(language));
+            }
+        }
+
+        if let Some(interactor) = &self.interactor {
+            // haskell solutions are pure functions, and sql solutions queries, which cannot converse with anything
+            if matches!(self.language, Language::Haskell | Language::Sql) {
+                return Err(SubmissionError::NotInteractive(self.language));
+            }
+
+            let language = interactor.language.unwrap_or(self.language);
+            if !language.is_supported() {
+                return Err(SubmissionError::UnsupportedLanguage(language));
+            }
+        }
+
+        Ok(())
+    }
 }
 
-#[derive(Deserialize)]
+/// A checker program, for exercises where more than one output is correct.
+///
+/// The checker is compiled and run in the sandbox of its language, with the paths of three files as its arguments: the
+/// input parameters, the expected output, and the actual output of the test case. It exits with 0 if the actual
+/// output is correct, with 1 if it is not, and any other exit code is a failure of the checker itself.
+#[derive(Deserialize, Serialize)]
+pub struct Checker {
+    /// The language of the checker, which defaults to the language of the solution.
+    pub language: Option<Language>,
+    pub source: String,
+}
+
+/// An interactor program, for exercises where the solution converses with the judge, e.g. a guessing game.
+///
+/// The interactor is compiled and run in the sandbox of its language alongside every test case, with the path of a file
+/// containing the input parameters as its argument. Everything the interactor writes to its standard output is the
+/// standard input of the solution, and the other way around. It exits with 0 if the interaction was correct, with 1 if
+/// it was not, and any other exit code is a failure of the interactor itself.
+#[derive(Deserialize, Serialize)]
+pub struct Interactor {
+    /// The language of the interactor, which defaults to the language of the solution.
+    pub language: Option<Language>,
+    pub source: String,
+}
+
+/// A shell script of an exercise, which is run on its own around the test cases, in the sandbox and the workspace they
+/// run in, with the seed of the submission in `MOZART_SEED`.
+///
+/// The script is run with `sh`, and is bounded by the limits of the submission, except for its own time limit.
+#[derive(Deserialize, Serialize, Clone, PartialEq, Debug)]
+pub struct Hook {
+    pub script: String,
+    /// The time limit of the script in milliseconds, which defaults to the time limit of the submission.
+    #[serde(rename = "timeLimit", default, skip_serializing_if = "Option::is_none")]
+    pub time_limit: Option<u64>,
+}
+
+/// What a setup or teardown script did, which is meant for the author of the exercise, so it is left out of redacted
+/// results.
+#[derive(Serialize, Deserialize, Clone, PartialEq, Debug)]
+pub struct HookResult {
+    /// The exit code of the script, which is left out if it was killed.
+    #[serde(rename = "exitCode", default, skip_serializing_if = "Option::is_none")]
+    pub exit_code: Option<i32>,
+    /// Why the script was killed, which is left out if it exited by itself.
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub killed: Option<FailureKind>,
+    /// The standard output of the script, up to the output limit.
+    pub stdout: String,
+    /// The standard error of the script, up to the output limit.
+    pub stderr: String,
+    /// The wall-clock runtime of the script in milliseconds.
+    pub runtime: u64,
+}
+
+impl HookResult {
+    /// Whether the script exited successfully.
+    pub fn succeeded(&self) -> bool {
+        self.exit_code == Some(0)
+    }
+}
+
+/// The programming language of a solution.
+#[derive(
+    Deserialize, Serialize, Clone, Copy, PartialEq, Eq, PartialOrd, Ord, Hash, Debug, Default,
+)]
+pub enum Language {
+    #[default]
+    #[serde(rename = "haskell")]
+    Haskell,
+
+    #[serde(rename = "python")]
+    Python,
+
+    #[serde(rename = "go")]
+    Go,
+
+    #[serde(rename = "c")]
+    C,
+
+    #[serde(rename = "java")]
+    Java,
+
+    #[serde(rename = "sql")]
+    Sql,
+}
+
+impl Language {
+    /// Every language, whether its support is compiled in or not.
+    pub const ALL: [Language; 6] = [
+        Language::Haskell,
+        Language::Python,
+        Language::Go,
+        Language::C,
+        Language::Java,
+        Language::Sql,
+    ];
+
+    /// Gets the name of the language, as it is written in a submission.
+    pub fn as_str(&self) -> &'static str {
+        match self {
+            Language::Haskell => "haskell",
+            Language::Python => "python",
+            Language::Go => "go",
+            Language::C => "c",
+            Language::Java => "java",
+            Language::Sql => "sql",
+        }
+    }
+
+    /// Whether support for the language is compiled in, which is controlled by the feature of the same name.
+    pub fn is_supported(&self) -> bool {
+        match self {
+            Language::Haskell => cfg!(feature = "haskell"),
+            Language::Python => cfg!(feature = "python"),
+            Language::Go => cfg!(feature = "go"),
+            Language::C => cfg!(feature = "c"),
+            Language::Java => cfg!(feature = "java"),
+            Language::Sql => cfg!(feature = "sql"),
+        }
+    }
+}
+
+impl std::fmt::Display for Language {
+    fn fmt(&self, f: &mut std::fmt::Formatter<'_>) -> std::fmt::Result {
+        f.write_str(self.as_str())
+    }
+}
+
+/// The priority of a job, by which the jobs waiting for a worker are served, where a job of a higher priority is always
+/// checked before one of a lower priority, and jobs of the same priority are checked in the order they were admitted.
+#[derive(
+    Deserialize, Serialize, Clone, Copy, PartialEq, Eq, PartialOrd, Ord, Hash, Debug, Default,
+)]
+#[serde(rename_all = "lowercase")]
+pub enum Priority {
+    /// Jobs which nobody waits for right away, like batches and rejudges.
+    Batch,
+
+    #[default]
+    Normal,
+
+    /// Jobs of live exams, which may also use the workers reserved for them.
+    Exam,
+}
+
+impl Priority {
+    /// Every priority, the highest first.
+    pub const ALL: [Priority; 3] = [Priority::Exam, Priority::Normal, Priority::Batch];
+
+    pub fn as_str(&self) -> &'static str {
+        match self {
+            Priority::Batch => "batch",
+            Priority::Normal => "normal",
+            Priority::Exam => "exam",
+        }
+    }
+}
+
+#[derive(Deserialize, Serialize)]
 pub struct TestCase {
     pub id: u64,
-    #[serde(rename = "inputParameters")]
+    /// A human readable name of the test case, which is included in the result.
+    pub name: Option<String>,
+    /// The parameters of the test case, which a test case of a test suite has none of.
+    #[serde(rename = "inputParameters", default)]
     pub input_parameters: Box<[Parameter]>,
-    #[serde(rename = "outputParameters")]
+    #[serde(rename = "outputParameters", default)]
     pub output_parameters: Box<[Parameter]>,
+    /// The relative weight of the test case, which must be greater than zero.
+    #[serde(default = "default_weight")]
+    pub weight: u32,
+    /// Whether the test case should be hidden from the submitter.
+    #[serde(default)]
+    pub hidden: bool,
+    /// The wall-clock time limit of the test case in milliseconds, which overrides the default time limit.
+    #[serde(rename = "timeLimit")]
+    pub time_limit: Option<u64>,
+    /// How the actual output is compared to the expected output, which defaults to an exact comparison.
+    #[serde(default)]
+    pub comparison: Comparison,
+    /// The tolerance of a float comparison, which defaults to [`crate::compare::DEFAULT_EPSILON`].
+    pub epsilon: Option<f64>,
+    /// The name of the group the test case belongs to, which must be one of the groups of the submission.
+    pub group: Option<String>,
+    /// The ids of the uploaded fixtures the test program may read from the `fixtures` directory of its workspace.
+    #[serde(default)]
+    pub fixtures: Vec<String>,
+    /// The id of an uploaded fixture which is the standard input of the test program.
+    pub stdin: Option<String>,
+    /// The environment variables of the test program, whose names must be allowed by the server.
+    #[serde(default, skip_serializing_if = "BTreeMap::is_empty")]
+    pub env: BTreeMap<String, String>,
+    /// The files of the working directory of the test program by their relative paths, with base64 contents.
+    ///
+    /// A test case with files runs in a directory of its own, so test cases running at the same time do not see each
+    /// others files.
+    #[serde(
+        rename = "workDir",
+        default,
+        skip_serializing_if = "BTreeMap::is_empty"
+    )]
+    pub work_dir: BTreeMap<String, String>,
+}
+
+impl TestCase {
+    /// The highest weight of a test case or group, so the weights of a submission sum to a whole number far below
+    /// where a score stops being exact.
+    pub const MAX_WEIGHT: u32 = 1_000_000;
+
+    /// Gets the ids of every fixture the test case refers to.
+    pub fn fixture_ids(&self) -> impl Iterator<Item = &str> {
+        self.fixtures.iter().chain(&self.stdin).map(String::as_str)
+    }
+}
+
+fn default_weight() -> u32 {
+    1
 }
 
 #[derive(Deserialize, Serialize, PartialEq, Clone)]
@@ -29,32 +981,751 @@ pub struct Parameter {
     pub value: String,
 }
 
+/// The result of checking a submission.
+#[derive(Serialize, Deserialize, Clone)]
+pub struct SubmissionResult {
+    pub verdict: Verdict,
+    /// The output of the compiler, which contains the reason if the solution failed to compile.
+    #[serde(rename = "compileOutput")]
+    pub compile_output: String,
+    /// The results of the test cases, which is empty if the solution failed to compile.
+    #[serde(rename = "testCaseResults")]
+    pub test_case_results: Box<[TestCaseResult]>,
+    /// The problems the compiler reported, which are only parsed from its output if the solution failed to compile.
+    #[serde(default, skip_serializing_if = "<[Diagnostic]>::is_empty")]
+    pub diagnostics: Box<[Diagnostic]>,
+    /// The problems the linter found in the solution, if the submission asked for them and the linter could be run,
+    /// which is boxed as few submissions ask for it.
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub analysis: Option<Box<Analysis>>,
+    /// The score of the submission from 0 to 100, which is 0 unless the solution was run against the test cases.
+    #[serde(default)]
+    pub score: f64,
+    /// The scores of the groups of the submission, in the order they were declared.
+    #[serde(default, skip_serializing_if = "<[GroupScore]>::is_empty")]
+    pub groups: Box<[GroupScore]>,
+    /// What the test cases were run with, which reproduces the judgment when the submission is sent with the same seed
+    /// and image digest, and is boxed to keep results small.
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub reproduction: Option<Box<Reproduction>>,
+    /// The failing test case which decided the submission by the verdict policy, which is left out if none failed.
+    #[serde(
+        rename = "decidingFailure",
+        default,
+        skip_serializing_if = "Option::is_none"
+    )]
+    pub deciding_failure: Option<Box<DecidingFailure>>,
+    /// How the submission changed from the earlier attempt its `previousTaskId` names, which is left out unless that
+    /// attempt was checked.
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub revision: Option<Box<Revision>>,
+    /// Whether the result is that of an earlier attempt at the same exercise with the same solution, which was reused
+    /// rather than judging the solution again.
+    #[serde(default, skip_serializing_if = "std::ops::Not::not")]
+    pub cached: bool,
+    /// What the setup and teardown scripts of the submission did, which is left out if it has neither, and is boxed
+    /// as few submissions have them.
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub hooks: Option<Box<HookResults>>,
+    /// How long each stage of the judgment took, as measured by the server, which is left out of results judged
+    /// before it was measured.
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub timings: Option<Box<Timings>>,
+}
+
+/// How long the stages of judging a submission took in milliseconds of wall-clock time, which tells whether slow
+/// feedback is caused by waiting in the queue, compiling, or running the test cases.
+#[derive(Serialize, Deserialize, Clone, PartialEq, Debug, Default)]
+#[serde(rename_all = "camelCase")]
+pub struct Timings {
+    /// How long the submission waited for a worker.
+    pub queued: u64,
+    /// How long creating the workspace and laying out the files, test code and fixtures in it took.
+    pub workspace: u64,
+    /// How long compiling the solution, checker and interactor took, which is short when it was cached.
+    pub compile: u64,
+    /// How long running the setup script, the test cases and the teardown script took, as one test case may run while
+    /// another does.
+    pub tests: u64,
+    /// How long judging each test case took, including its checker, in the order of the test cases, leaving out
+    /// those which were skipped.
+    pub test_cases: Vec<TestCaseTiming>,
+    /// How long cleaning up after the test cases and removing the workspace took.
+    pub cleanup: u64,
+}
+
+/// How long judging a test case took in milliseconds of wall-clock time.
+#[derive(Serialize, Deserialize, Clone, PartialEq, Debug)]
+pub struct TestCaseTiming {
+    pub id: u64,
+    pub elapsed: u64,
+}
+
+/// What the setup and teardown scripts of a submission did.
+#[derive(Serialize, Deserialize, Clone, PartialEq, Debug)]
+pub struct HookResults {
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub setup: Option<HookResult>,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub teardown: Option<HookResult>,
+}
+
+/// Which program of an interaction wrote a message of its transcript.
+#[derive(Serialize, Deserialize, Clone, Copy, PartialEq, Eq, Debug)]
+#[serde(rename_all = "camelCase")]
+pub enum Speaker {
+    Solution,
+    Interactor,
+}
+
+/// A chunk of what one program of an interaction wrote to the other, as it was relayed.
+#[derive(Serialize, Deserialize, Clone, PartialEq, Debug)]
+pub struct Message {
+    /// When the chunk was relayed, in milliseconds since the interaction started.
+    pub at: u64,
+    pub from: Speaker,
+    /// The chunk as text, where invalid utf-8 is replaced.
+    pub data: String,
+}
+
+/// What the solution and the interactor wrote to each other during a test case, in the order it was relayed.
+#[derive(Serialize, Deserialize, Clone, PartialEq, Debug)]
+pub struct TestCaseTranscript {
+    pub id: u64,
+    pub messages: Vec<Message>,
+    /// Whether the rest of the interaction was left out, as it exceeded the output limit.
+    #[serde(default, skip_serializing_if = "std::ops::Not::not")]
+    pub truncated: bool,
+}
+
+/// The transcripts of the interactive test cases of a job, in the order of the test cases.
+#[derive(Serialize, Deserialize, Clone, PartialEq, Debug, Default)]
+pub struct Transcript {
+    #[serde(rename = "testCases")]
+    pub test_cases: Vec<TestCaseTranscript>,
+}
+
+/// The failing test case which decided a submission, and why it failed.
+#[derive(Serialize, Deserialize, Clone, Copy, PartialEq, Debug)]
+pub struct DecidingFailure {
+    #[serde(rename = "testCase")]
+    pub test_case: u64,
+    pub reason: FailureKind,
+}
+
+/// What the test cases of a submission were run with, besides the submission itself.
+#[derive(Serialize, Deserialize, Clone, PartialEq, Debug)]
+pub struct Reproduction {
+    /// The seed the test cases, the checker, and the interactor were given in `MOZART_SEED`.
+    pub seed: u64,
+    /// The docker image the submission was checked in, which is left out if it was checked on the host.
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub image: Option<String>,
+    /// The version of the toolchain the submission asked for, which is left out if it was checked with the configured
+    /// toolchain of its language.
+    #[serde(
+        rename = "toolchainVersion",
+        default,
+        skip_serializing_if = "Option::is_none"
+    )]
+    pub toolchain_version: Option<String>,
+}
+
+/// The problems a linter found in a solution, which do not affect its verdict.
+#[derive(Serialize, Deserialize, Clone, PartialEq, Debug)]
+pub struct Analysis {
+    /// The command of the linter, e.g. `go vet`.
+    pub linter: String,
+    /// The problems within the solution, whose severities are at most warnings, as the solution compiled.
+    pub diagnostics: Vec<Diagnostic>,
+}
+
+impl SubmissionResult {
+    /// Creates the result of a solution which compiled, and was run against the test cases, which is decided by the
+    /// default verdict policy.
+    pub fn checked(compile_output: String, test_case_results: Box<[TestCaseResult]>) -> Self {
+        Self::judged(compile_output, test_case_results, &VerdictPolicy::default())
+    }
+
+    /// Creates the result of a solution which compiled, and was run against the test cases, whose verdict and failing
+    /// test case are decided by the given policy.
+    ///
+    /// A security violation in any test case takes precedence over every other failure.
+    pub fn judged(
+        compile_output: String,
+        test_case_results: Box<[TestCaseResult]>,
+        policy: &VerdictPolicy,
+    ) -> Self {
+        let deciding_failure = policy.decide(&test_case_results);
+        let verdict = policy.verdict(&test_case_results, deciding_failure.as_ref());
+        let deciding_failure = deciding_failure.map(Box::new);
+
+        Self {
+            verdict,
+            compile_output,
+            test_case_results,
+            diagnostics: Box::new([]),
+            analysis: None,
+            score: 0.0,
+            groups: Box::new([]),
+            reproduction: None,
+            deciding_failure,
+            revision: None,
+            cached: false,
+            hooks: None,
+            timings: None,
+        }
+    }
+
+    /// Leaves out everything about the hidden test cases other than whether they passed, i.e. their input, the
+    /// expected and actual output of wrong answers, and their standard error, along with the setup and teardown scripts.
+    pub fn redact(&mut self) {
+        self.hooks = None;
+        for result in self
+            .test_case_results
+            .iter_mut()
+            .filter(|result| result.hidden)
+        {
+            result.redact();
+        }
+    }
+
+    /// Leaves out the outputs of the test cases which are larger than the threshold in bytes, which are linked to as
+    /// attachments of the job of the submission instead, so large outputs are only downloaded when they are looked at.
+    pub fn attach(&mut self, task: Uuid, threshold: usize) {
+        for result in self.test_case_results.iter_mut() {
+            result.attach(task, threshold);
+        }
+    }
+
+    /// Gets the CPU time in milliseconds the test cases took, which is their wall-clock runtime where it could not be
+    /// measured.
+    pub fn cpu_time(&self) -> u64 {
+        self.test_case_results
+            .iter()
+            .map(|result| match (result.user_time, result.system_time) {
+                (None, None) => result.runtime,
+                (user, system) => user.unwrap_or_default() + system.unwrap_or_default(),
+            })
+            .sum()
+    }
+
+    /// Leaves out the outputs of every test case, keeping whether it passed and why it did not, like the hidden test
+    /// cases of a redacted result.
+    pub fn compact(&mut self) {
+        for result in self.test_case_results.iter_mut() {
+            result.redact();
+        }
+    }
+
+    /// Creates the result of a solution which failed to compile, along with the problems the compiler reported.
+    pub fn compilation_error(compile_output: String, diagnostics: Vec<Diagnostic>) -> Self {
+        Self {
+            verdict: Verdict::CompilationError,
+            compile_output,
+            test_case_results: Box::new([]),
+            diagnostics: diagnostics.into(),
+            analysis: None,
+            score: 0.0,
+            groups: Box::new([]),
+            reproduction: None,
+            deciding_failure: None,
+            revision: None,
+            cached: false,
+            hooks: None,
+            timings: None,
+        }
+    }
+}
+
+/// The overall verdict of a submission.
+#[derive(Serialize, Deserialize, Clone, Copy, PartialEq, Debug)]
+pub enum Verdict {
+    /// Every test case passed.
+    #[serde(rename = "pass")]
+    Pass,
+
+    /// At least one test case did not pass.
+    #[serde(rename = "failure")]
+    Failure,
+
+    /// The solution failed to compile, so no test cases were run.
+    #[serde(rename = "compilationError")]
+    CompilationError,
+
+    /// At least one test case was killed, as it made a system call which the seccomp profile of its language forbids.
+    #[serde(rename = "securityViolation")]
+    SecurityViolation,
+
+    /// At least one test case did not pass, and the verdict policy decided the submission by a wrong answer, which
+    /// like the verdicts below is only ever decided by the priority mode.
+    #[serde(rename = "wrongAnswer")]
+    WrongAnswer,
+
+    #[serde(rename = "runtimeError")]
+    RuntimeError,
+
+    #[serde(rename = "timeLimitExceeded")]
+    TimeLimitExceeded,
+
+    #[serde(rename = "memoryLimitExceeded")]
+    MemoryLimitExceeded,
+
+    #[serde(rename = "outputLimitExceeded")]
+    OutputLimitExceeded,
+
+    #[serde(rename = "wrongInteraction")]
+    WrongInteraction,
+
+    #[serde(rename = "idlenessLimitExceeded")]
+    IdlenessLimitExceeded,
+
+    #[serde(rename = "testFailed")]
+    TestFailed,
+}
+
+impl Verdict {
+    /// Gets the name of the verdict, as it is written in a result.
+    pub fn as_str(&self) -> &'static str {
+        match self {
+            Verdict::Pass => "pass",
+            Verdict::Failure => "failure",
+            Verdict::CompilationError => "compilationError",
+            Verdict::SecurityViolation => "securityViolation",
+            Verdict::WrongAnswer => "wrongAnswer",
+            Verdict::RuntimeError => "runtimeError",
+            Verdict::TimeLimitExceeded => "timeLimitExceeded",
+            Verdict::MemoryLimitExceeded => "memoryLimitExceeded",
+            Verdict::OutputLimitExceeded => "outputLimitExceeded",
+            Verdict::WrongInteraction => "wrongInteraction",
+            Verdict::IdlenessLimitExceeded => "idlenessLimitExceeded",
+            Verdict::TestFailed => "testFailed",
+        }
+    }
+
+    /// Whether a test case did not pass, whether the verdict is a failure, or the reason of the failure deciding it,
+    /// rather than a security violation.
+    pub fn is_failure(&self) -> bool {
+        !matches!(
+            self,
+            Verdict::Pass | Verdict::CompilationError | Verdict::SecurityViolation
+        )
+    }
+}
+
+impl From<FailureKind> for Verdict {
+    fn from(kind: FailureKind) -> Self {
+        match kind {
+            FailureKind::WrongAnswer => Verdict::WrongAnswer,
+            FailureKind::RuntimeError => Verdict::RuntimeError,
+            FailureKind::TimeLimitExceeded => Verdict::TimeLimitExceeded,
+            FailureKind::MemoryLimitExceeded => Verdict::MemoryLimitExceeded,
+            FailureKind::OutputLimitExceeded => Verdict::OutputLimitExceeded,
+            FailureKind::SecurityViolation => Verdict::SecurityViolation,
+            FailureKind::WrongInteraction => Verdict::WrongInteraction,
+            FailureKind::IdlenessLimitExceeded => Verdict::IdlenessLimitExceeded,
+            FailureKind::TestFailed => Verdict::TestFailed,
+        }
+    }
+}
+
+/// How the verdict of a submission and the failing test case which decides it are chosen, when a test case failed.
+///
+/// A security violation always decides the submission, as it decides its verdict. Of the other failures, ties are
+/// decided by the order of the test cases, so the same results are always decided by the same test case.
+#[derive(Serialize, Deserialize, Clone, PartialEq, Debug, Default)]
+pub struct VerdictPolicy {
+    #[serde(default)]
+    pub mode: VerdictMode,
+    /// The reasons of failures from the one which weighs the most, after which the reasons not given follow, which is
+    /// only used by the priority mode.
+    #[serde(default, skip_serializing_if = "Vec::is_empty")]
+    pub priority: Vec<FailureKind>,
+}
+
+impl VerdictPolicy {
+    /// Gets the failing test case which decides the results, if any failed.
+    pub fn decide(&self, results: &[TestCaseResult]) -> Option<DecidingFailure> {
+        results
+            .iter()
+            .filter_map(|result| match &result.test_result {
+                TestResult::Failure(reason) => Some(DecidingFailure {
+                    test_case: result.id,
+                    reason: reason.kind(),
+                }),
+                _ => None,
+            })
+            // the first of the failures which weigh the same is kept
+            .min_by_key(|failure| self.rank(failure.reason))
+    }
+
+    /// Gets the overall verdict of the results, given the failure deciding them.
+    ///
+    /// The priority mode judges a submission by the reason of its deciding failure, e.g. as a time limit exceeded,
+    /// while the first mode judges every submission which did not pass as a failure.
+    pub fn verdict(
+        &self,
+        results: &[TestCaseResult],
+        deciding: Option<&DecidingFailure>,
+    ) -> Verdict {
+        match (deciding, self.mode) {
+            (Some(failure), _) if failure.reason == FailureKind::SecurityViolation => {
+                Verdict::SecurityViolation
+            }
+            (Some(failure), VerdictMode::Priority) => Verdict::from(failure.reason),
+            (None, _)
+                if results
+                    .iter()
+                    .all(|result| result.test_result == TestResult::Pass) =>
+            {
+                Verdict::Pass
+            }
+            _ => Verdict::Failure,
+        }
+    }
+
+    /// Ranks the reason of a failure, where the lowest rank weighs the most.
+    fn rank(&self, reason: FailureKind) -> usize {
+        match (reason, self.mode) {
+            (FailureKind::SecurityViolation, _) => 0,
+            (_, VerdictMode::First) => 1,
+            (_, VerdictMode::Priority) => {
+                let position = self.priority.iter().position(|&kind| kind == reason);
+                1 + position.unwrap_or(self.priority.len())
+            }
+        }
+    }
+}
+
+/// How a verdict policy chooses among failing test cases.
+#[derive(Serialize, Deserialize, Clone, Copy, PartialEq, Debug, Default)]
+pub enum VerdictMode {
+    /// The first failing test case decides the submission.
+    #[default]
+    #[serde(rename = "first")]
+    First,
+
+    /// The failing test case whose reason comes first in the priority decides the submission, e.g. any exceeded time
+    /// limit rather than an earlier wrong answer, and its reason is the verdict of the submission.
+    #[serde(rename = "priority")]
+    Priority,
+}
+
+/// A request to compile a solution without running it.
+#[derive(Deserialize)]
+pub struct CompileRequest {
+    /// The language of the solution, which defaults to haskell.
+    #[serde(default)]
+    pub language: Language,
+    pub solution: String,
+}
+
+/// The result of compiling a solution without running it.
 #[derive(Serialize)]
+pub struct CompileResult {
+    pub compiled: bool,
+    /// The output of the compiler, which contains the reason if the solution failed to compile.
+    #[serde(rename = "compileOutput")]
+    pub compile_output: String,
+    /// The problems the compiler reported, including warnings of a solution which compiled.
+    pub diagnostics: Vec<Diagnostic>,
+}
+
+/// A problem the compiler or a linter reported, as it was parsed from their output.
+#[derive(Serialize, Deserialize, Clone, PartialEq, Debug)]
+pub struct Diagnostic {
+    pub severity: Severity,
+    /// The path of the file of the solution the problem is in, which is left out for problems in the solution itself.
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub file: Option<String>,
+    /// The line in the solution or the file, starting at 1, which is left out if the problem is not within either.
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub line: Option<usize>,
+    /// The column in the line, starting at 1, which is left out if the compiler does not report it.
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub column: Option<usize>,
+    pub message: String,
+}
+
+#[derive(Serialize, Deserialize, Clone, Copy, PartialEq, Debug)]
+#[serde(rename_all = "camelCase")]
+pub enum Severity {
+    Error,
+    Warning,
+    Note,
+}
+
+#[derive(Serialize, Deserialize, Clone)]
 pub struct TestCaseResult {
     pub id: u64,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub name: Option<String>,
     #[serde(rename = "testResult")]
     pub test_result: TestResult,
+    /// Whether the test case is hidden, in which case only whether it passed is shown to untrusted callers.
+    #[serde(default, skip_serializing_if = "std::ops::Not::not")]
+    pub hidden: bool,
+    /// The standard error of the test case.
+    pub stderr: String,
+    /// The wall-clock runtime of the test case in milliseconds.
+    pub runtime: u64,
+    /// The peak resident memory of the test case in bytes, if it could be measured.
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub memory: Option<u64>,
+    /// The CPU time the test case spent in user mode in milliseconds, if it could be measured.
+    #[serde(rename = "userTime", skip_serializing_if = "Option::is_none")]
+    pub user_time: Option<u64>,
+    /// The CPU time the test case spent in kernel mode in milliseconds, if it could be measured.
+    #[serde(rename = "systemTime", skip_serializing_if = "Option::is_none")]
+    pub system_time: Option<u64>,
+    /// Why the test case caused a runtime error, if it is known.
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub cause: Option<RuntimeErrorCause>,
+    /// The runtimes and memory of the timed runs of a benchmarked test case, which are only measured once it passes.
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub benchmark: Option<BenchmarkResult>,
+    /// The outputs which were too large to be inlined, with the path of the attachment of the job they are downloaded
+    /// from, while they are left empty in the result.
+    #[serde(default, skip_serializing_if = "BTreeMap::is_empty")]
+    pub attachments: BTreeMap<TestOutput, String>,
+}
+
+/// An output of a test case, which is left out of the result of its job once it is too large.
+#[derive(Serialize, Deserialize, PartialEq, Eq, PartialOrd, Ord, Clone, Copy, Debug)]
+#[serde(rename_all = "camelCase")]
+pub enum TestOutput {
+    Stderr,
+    /// The actual output of a wrong answer.
+    Actual,
+    /// The expected output of a wrong answer.
+    Expected,
+    /// The diff of a wrong answer.
+    Diff,
+    /// What the failed test of a test suite reported.
+    Output,
+    /// The stack trace the test of a test suite failed with.
+    Trace,
+}
+
+impl TestOutput {
+    pub const ALL: [TestOutput; 6] = [
+        TestOutput::Stderr,
+        TestOutput::Actual,
+        TestOutput::Expected,
+        TestOutput::Diff,
+        TestOutput::Output,
+        TestOutput::Trace,
+    ];
+
+    /// Gets the name of the output, as it is written in a result.
+    pub fn as_str(&self) -> &'static str {
+        match self {
+            TestOutput::Stderr => "stderr",
+            TestOutput::Actual => "actual",
+            TestOutput::Expected => "expected",
+            TestOutput::Diff => "diff",
+            TestOutput::Output => "output",
+            TestOutput::Trace => "trace",
+        }
+    }
 }
 
-#[derive(Serialize, PartialEq)]
+/// The runtimes and memory of the timed runs of a test case, in milliseconds and bytes.
+///
+/// The percentiles are those of the nearest rank, so they are the runtime or memory of one of the runs.
+#[derive(Serialize, Deserialize, PartialEq, Clone, Debug)]
+pub struct BenchmarkResult {
+    pub runs: usize,
+    #[serde(rename = "medianRuntime")]
+    pub median_runtime: u64,
+    #[serde(rename = "p95Runtime")]
+    pub p95_runtime: u64,
+    /// The median peak resident memory, if it could be measured.
+    #[serde(rename = "medianMemory", skip_serializing_if = "Option::is_none")]
+    pub median_memory: Option<u64>,
+    #[serde(rename = "p95Memory", skip_serializing_if = "Option::is_none")]
+    pub p95_memory: Option<u64>,
+}
+
+impl BenchmarkResult {
+    /// Summarizes the runtimes and memory of the timed runs, where memory is only summarized if every run measured it.
+    pub fn of(runtimes: &mut [u64], memory: Option<&mut [u64]>) -> Self {
+        runtimes.sort_unstable();
+        let memory = memory.map(|memory| {
+            memory.sort_unstable();
+            (percentile(memory, 50), percentile(memory, 95))
+        });
+
+        Self {
+            runs: runtimes.len(),
+            median_runtime: percentile(runtimes, 50),
+            p95_runtime: percentile(runtimes, 95),
+            median_memory: memory.map(|(median, _)| median),
+            p95_memory: memory.map(|(_, p95)| p95),
+        }
+    }
+}
+
+/// Gets the percentile of sorted values by the nearest rank, which is 0 without any values.
+fn percentile(sorted: &[u64], percent: usize) -> u64 {
+    let rank = (sorted.len() * percent).div_ceil(100);
+    sorted
+        .get(rank.saturating_sub(1))
+        .copied()
+        .unwrap_or_default()
+}
+
+impl TestCaseResult {
+    /// Creates the result of a test case which was skipped, as earlier test cases failed.
+    pub fn skipped(test_case: &TestCase) -> Self {
+        Self {
+            id: test_case.id,
+            name: test_case.name.clone(),
+            test_result: TestResult::Skipped,
+            hidden: test_case.hidden,
+            stderr: String::new(),
+            runtime: 0,
+            memory: None,
+            user_time: None,
+            system_time: None,
+            cause: None,
+            benchmark: None,
+            attachments: BTreeMap::new(),
+        }
+    }
+
+    /// Gets an output of the test case, unless the test case has no such output, e.g. the diff of a passing test case.
+    pub fn output(&self, output: TestOutput) -> Option<&str> {
+        match (output, &self.test_result) {
+            (TestOutput::Stderr, _) => Some(&self.stderr),
+            (
+                TestOutput::Actual,
+                TestResult::Failure(TestCaseFailureReason::WrongAnswer { actual, .. }),
+            ) => Some(actual),
+            (
+                TestOutput::Expected,
+                TestResult::Failure(TestCaseFailureReason::WrongAnswer { expected, .. }),
+            ) => Some(expected),
+            (
+                TestOutput::Diff,
+                TestResult::Failure(TestCaseFailureReason::WrongAnswer { diff, .. }),
+            ) => diff.as_deref(),
+            (
+                TestOutput::Output,
+                TestResult::Failure(TestCaseFailureReason::TestFailed { output, .. }),
+            ) => Some(output),
+            (
+                TestOutput::Trace,
+                TestResult::Failure(TestCaseFailureReason::TestFailed { trace, .. }),
+            ) => trace.as_deref(),
+            _ => None,
+        }
+    }
+
+    /// Leaves out the outputs which are larger than the threshold, linking to them as attachments of the job instead.
+    fn attach(&mut self, task: Uuid, threshold: usize) {
+        for output in TestOutput::ALL {
+            let id = self.id;
+            if let Some(contents) = self
+                .output_mut(output)
+                .filter(|contents| contents.len() > threshold)
+            {
+                contents.clear();
+                self.attachments.insert(
+                    output,
+                    format!("/task/{task}/output/{id}?part={}", output.as_str()),
+                );
+            }
+        }
+    }
+
+    fn output_mut(&mut self, output: TestOutput) -> Option<&mut String> {
+        match (output, &mut self.test_result) {
+            (TestOutput::Stderr, _) => Some(&mut self.stderr),
+            (
+                TestOutput::Actual,
+                TestResult::Failure(TestCaseFailureReason::WrongAnswer { actual, .. }),
+            ) => Some(actual),
+            (
+                TestOutput::Expected,
+                TestResult::Failure(TestCaseFailureReason::WrongAnswer { expected, .. }),
+            ) => Some(expected),
+            (
+                TestOutput::Diff,
+                TestResult::Failure(TestCaseFailureReason::WrongAnswer { diff, .. }),
+            ) => diff.as_mut(),
+            (
+                TestOutput::Output,
+                TestResult::Failure(TestCaseFailureReason::TestFailed { output, .. }),
+            ) => Some(output),
+            (
+                TestOutput::Trace,
+                TestResult::Failure(TestCaseFailureReason::TestFailed { trace, .. }),
+            ) => trace.as_mut(),
+            _ => None,
+        }
+    }
+
+    /// Leaves out everything about the test case other than whether it passed, and why it did not.
+    fn redact(&mut self) {
+        self.stderr.clear();
+        match &mut self.test_result {
+            TestResult::Failure(TestCaseFailureReason::WrongAnswer {
+                input_parameters,
+                actual,
+                expected,
+                diff,
+                encoding,
+            }) => {
+                *input_parameters = Box::new([]);
+                actual.clear();
+                expected.clear();
+                *diff = None;
+                *encoding = None;
+            }
+            TestResult::Failure(TestCaseFailureReason::TestFailed {
+                output,
+                message,
+                trace,
+            }) => {
+                output.clear();
+                *message = None;
+                *trace = None;
+            }
+            _ => {}
+        }
+    }
+}
+
+#[derive(Serialize, Deserialize, PartialEq, Clone)]
 pub enum TestResult {
     /// The test case passed.
     #[serde(rename = "pass")]
     Pass,
 
-    /// The result of the test case is unknown.
-    ///
-    /// This is likely caused by a previous test case causing a runtime error, thereby crashing the test runner.
+    /// The result of the test case is unknown, as it was not run.
     #[serde(rename = "unknown")]
     Unknown,
 
+    /// The test case was not run, as enough of the test cases before it did not pass to stop the submission short.
+    #[serde(rename = "skipped")]
+    Skipped,
+
     /// The test case did not pass.
     #[serde(rename = "failure")]
     Failure(TestCaseFailureReason),
 }
 
+/// How an output in a result is encoded, as it is not text.
+#[derive(Serialize, Deserialize, PartialEq, Eq, Clone, Copy, Debug)]
+pub enum Encoding {
+    /// The output is base64, as it was not valid utf-8, or had a nul byte.
+    #[serde(rename = "base64")]
+    Base64,
+}
+
 /// The reason why a given test case failed.
-#[derive(Serialize, PartialEq)]
+#[derive(Serialize, Deserialize, PartialEq, Clone)]
 pub enum TestCaseFailureReason {
     /// The answer to the test case was incorrect.
     #[serde(rename = "wrongAnswer")]
@@ -63,9 +1734,1139 @@ pub enum TestCaseFailureReason {
         input_parameters: Box<[Parameter]>,
         actual: String,
         expected: String,
+        /// The diff from the expected to the actual output, unless diffs are disabled, or either is not text.
+        #[serde(default, skip_serializing_if = "Option::is_none")]
+        diff: Option<String>,
+        /// How the actual and the expected output are encoded, which is only set if either of them is not text, in
+        /// which case both are.
+        #[serde(default, skip_serializing_if = "Option::is_none")]
+        encoding: Option<Encoding>,
     },
 
     /// A runtime error occured during the test case.
     #[serde(rename = "runtimeError")]
     RuntimeError,
+
+    /// The test case was killed, as it exceeded its time limit.
+    #[serde(rename = "timeLimitExceeded")]
+    TimeLimitExceeded,
+
+    /// The test case was killed, as it exceeded the memory limit.
+    #[serde(rename = "memoryLimitExceeded")]
+    MemoryLimitExceeded,
+
+    /// The test case was killed, as it wrote more than the disk limit into its workspace, or into a single file.
+    #[serde(rename = "outputLimitExceeded")]
+    OutputLimitExceeded,
+
+    /// The test case was killed, as it made a forbidden system call, e.g. to create a process or open a socket.
+    #[serde(rename = "securityViolation")]
+    SecurityViolation,
+
+    /// The interactor of the submission rejected the interaction with the solution.
+    #[serde(rename = "wrongInteraction")]
+    WrongInteraction,
+
+    /// The test case was killed along with the interactor, as neither made progress while waiting for the other.
+    #[serde(rename = "idlenessLimitExceeded")]
+    IdlenessLimitExceeded,
+
+    /// The test of a test suite failed, with what it reported, e.g. by `t.Errorf` in go, and the message and stack
+    /// trace of the assertion or exception it failed by, if its framework reports them.
+    #[serde(rename = "testFailed")]
+    TestFailed {
+        output: String,
+        #[serde(default, skip_serializing_if = "Option::is_none")]
+        message: Option<String>,
+        #[serde(default, skip_serializing_if = "Option::is_none")]
+        trace: Option<String>,
+    },
+}
+
+impl TestCaseFailureReason {
+    /// Gets the kind of the reason, without what it reports.
+    pub fn kind(&self) -> FailureKind {
+        match self {
+            TestCaseFailureReason::WrongAnswer { .. } => FailureKind::WrongAnswer,
+            TestCaseFailureReason::RuntimeError => FailureKind::RuntimeError,
+            TestCaseFailureReason::TimeLimitExceeded => FailureKind::TimeLimitExceeded,
+            TestCaseFailureReason::MemoryLimitExceeded => FailureKind::MemoryLimitExceeded,
+            TestCaseFailureReason::OutputLimitExceeded => FailureKind::OutputLimitExceeded,
+            TestCaseFailureReason::SecurityViolation => FailureKind::SecurityViolation,
+            TestCaseFailureReason::WrongInteraction => FailureKind::WrongInteraction,
+            TestCaseFailureReason::IdlenessLimitExceeded => FailureKind::IdlenessLimitExceeded,
+            TestCaseFailureReason::TestFailed { .. } => FailureKind::TestFailed,
+        }
+    }
+}
+
+/// The kind of reason why a test case failed, which is named like the reason.
+#[derive(Serialize, Deserialize, PartialEq, Eq, Clone, Copy, Debug)]
+#[serde(rename_all = "camelCase")]
+pub enum FailureKind {
+    WrongAnswer,
+    RuntimeError,
+    TimeLimitExceeded,
+    MemoryLimitExceeded,
+    OutputLimitExceeded,
+    SecurityViolation,
+    WrongInteraction,
+    IdlenessLimitExceeded,
+    TestFailed,
+}
+
+impl FailureKind {
+    pub const ALL: [FailureKind; 9] = [
+        FailureKind::WrongAnswer,
+        FailureKind::RuntimeError,
+        FailureKind::TimeLimitExceeded,
+        FailureKind::MemoryLimitExceeded,
+        FailureKind::OutputLimitExceeded,
+        FailureKind::SecurityViolation,
+        FailureKind::WrongInteraction,
+        FailureKind::IdlenessLimitExceeded,
+        FailureKind::TestFailed,
+    ];
+
+    /// Gets the name of the kind, as it is written in a result.
+    pub fn as_str(&self) -> &'static str {
+        match self {
+            FailureKind::WrongAnswer => "wrongAnswer",
+            FailureKind::RuntimeError => "runtimeError",
+            FailureKind::TimeLimitExceeded => "timeLimitExceeded",
+            FailureKind::MemoryLimitExceeded => "memoryLimitExceeded",
+            FailureKind::OutputLimitExceeded => "outputLimitExceeded",
+            FailureKind::SecurityViolation => "securityViolation",
+            FailureKind::WrongInteraction => "wrongInteraction",
+            FailureKind::IdlenessLimitExceeded => "idlenessLimitExceeded",
+            FailureKind::TestFailed => "testFailed",
+        }
+    }
+}
+
+/// The known cause of a runtime error, which is reported alongside it.
+#[derive(Serialize, Deserialize, PartialEq, Clone, Copy, Debug)]
+pub enum RuntimeErrorCause {
+    /// The test case failed to create a process or thread, as it had as many as its process limit allows.
+    #[serde(rename = "processLimitExceeded")]
+    ProcessLimitExceeded,
+}
+
+#[cfg(test)]
+mod validation {
+    use super::{
+        Benchmark, Interactor, Language, LimitCaps, NetworkPolicy, Parameter, Submission,
+        SubmissionLimits, TestCase,
+    };
+    use crate::{
+        compare::Comparison,
+        error::SubmissionError,
+        score::{Scoring, TestGroup},
+    };
+    use std::collections::BTreeMap;
+
+    const LIMITS: SubmissionLimits = SubmissionLimits {
+        solution_size: 1024,
+        test_cases: 8,
+        allowed_env: Vec::new(),
+        allowed_endpoints: Vec::new(),
+        callback_hosts: Vec::new(),
+        profiles: Vec::new(),
+        versions: BTreeMap::new(),
+        caps: LimitCaps::UNCAPPED,
+    };
+
+    fn test_case(id: u64) -> TestCase {
+        TestCase {
+            id,
+            name: None,
+            input_parameters: Box::new([]),
+            output_parameters: Box::new([Parameter {
+                value_type: String::from("int"),
+                value: String::from("5"),
+            }]),
+            weight: 1,
+            hidden: false,
+            time_limit: None,
+            comparison: Comparison::Exact,
+            epsilon: None,
+            group: None,
+            fixtures: Vec::new(),
+            stdin: None,
+            env: BTreeMap::new(),
+            work_dir: BTreeMap::new(),
+        }
+    }
+
+    fn submission(test_cases: Vec<TestCase>) -> Submission {
+        Submission {
+            language: Language::Haskell,
+            solution: String::from("solution = 5"),
+            files: BTreeMap::new(),
+            blobs: BTreeMap::new(),
+            test_cases: test_cases.into_boxed_slice(),
+            memory_limit: None,
+            time_limit: None,
+            process_limit: None,
+            disk_limit: None,
+            checker: None,
+            exercise_id: None,
+            interactor: None,
+            callback_url: None,
+            analyze: false,
+            fingerprint: false,
+            groups: Vec::new(),
+            scoring: Scoring::default(),
+            network: NetworkPolicy::None,
+            profile: None,
+            toolchain_version: None,
+            stop_on_first_fail: false,
+            max_failures: None,
+            export_artifact: false,
+            record_transcript: false,
+            seed: None,
+            image_digest: None,
+            benchmark: None,
+            verdict_policy: None,
+            test_suite: false,
+            priority: None,
+            previous_task_id: None,
+            nondeterministic: false,
+            setup: None,
+            teardown: None,
+        }
+    }
+
+    #[test]
+    #[cfg(feature = "haskell")]
+    fn valid() {
+        let submission = submission(vec![test_case(0), test_case(1)]);
+
+        assert!(submission.validate(&LIMITS).is_ok());
+    }
+
+    #[test]
+    fn no_test_cases() {
+        let submission = submission(Vec::new());
+
+        let actual = submission.validate(&LIMITS);
+
+        assert!(matches!(actual, Err(SubmissionError::NoTestCases)));
+    }
+
+    #[test]
+    fn empty_solution() {
+        let mut submission = submission(vec![test_case(0)]);
+        submission.solution = String::from("\n  \n");
+
+        let actual = submission.validate(&LIMITS);
+
+        assert!(matches!(actual, Err(SubmissionError::EmptySolution)));
+    }
+
+    #[test]
+    fn too_many_test_cases() {
+        let submission = submission((0..9).map(test_case).collect());
+
+        let actual = submission.validate(&LIMITS);
+
+        assert!(matches!(actual, Err(SubmissionError::TooManyTestCases(8))));
+    }
+
+    #[test]
+    fn solution_too_large() {
+        let mut submission = submission(vec![test_case(0)]);
+        submission.files = BTreeMap::from([(String::from("data.txt"), "AAAA".repeat(512))]);
+
+        let actual = submission.validate(&LIMITS);
+
+        assert!(matches!(
+            actual,
+            Err(SubmissionError::SolutionTooLarge(1024))
+        ));
+    }
+
+    #[test]
+    fn checker_too_large() {
+        let mut submission = submission(vec![test_case(0)]);
+        submission.checker = Some(super::Checker {
+            language: None,
+            source: "x".repeat(1025),
+        });
+
+        let actual = submission.validate(&LIMITS);
+
+        assert!(matches!(
+            actual,
+            Err(SubmissionError::SourceTooLarge("checker", 1024))
+        ));
+    }
+
+    #[test]
+    fn empty_hook() {
+        let mut submission = submission(vec![test_case(0)]);
+        submission.teardown = Some(super::Hook {
+            script: String::from(" \n"),
+            time_limit: None,
+        });
+
+        let actual = submission.validate(&LIMITS);
+
+        assert!(matches!(
+            actual,
+            Err(SubmissionError::EmptyHook("teardown"))
+        ));
+    }
+
+    #[test]
+    fn duplicate_test_case_id() {
+        let submission = submission(vec![test_case(0), test_case(1), test_case(0)]);
+
+        let actual = submission.validate(&LIMITS);
+
+        assert!(matches!(
+            actual,
+            Err(SubmissionError::DuplicateTestCaseId(0))
+        ));
+    }
+
+    #[test]
+    fn no_output_parameters() {
+        let mut test_case = test_case(3);
+        test_case.output_parameters = Box::new([]);
+        let submission = submission(vec![test_case]);
+
+        let actual = submission.validate(&LIMITS);
+
+        assert!(matches!(
+            actual,
+            Err(SubmissionError::NoOutputParameters(3))
+        ));
+    }
+
+    #[test]
+    fn zero_weight() {
+        let mut test_case = test_case(2);
+        test_case.weight = 0;
+        let submission = submission(vec![test_case]);
+
+        let actual = submission.validate(&LIMITS);
+
+        assert!(matches!(actual, Err(SubmissionError::ZeroWeight(2))));
+    }
+
+    #[test]
+    fn weight_too_high() {
+        let mut test_case = test_case(2);
+        test_case.weight = TestCase::MAX_WEIGHT + 1;
+        let submission = submission(vec![test_case]);
+
+        let actual = submission.validate(&LIMITS);
+
+        assert!(matches!(
+            actual,
+            Err(SubmissionError::WeightTooHigh(2, TestCase::MAX_WEIGHT))
+        ));
+    }
+
+    #[test]
+    fn empty_group() {
+        let mut grouped = test_case(1);
+        grouped.group = Some(String::from("edge cases"));
+        let mut submission = submission(vec![test_case(0), grouped]);
+        submission.groups = vec![TestGroup {
+            name: String::from("basics"),
+            weight: 1,
+        }];
+
+        let actual = submission.validate(&LIMITS);
+
+        assert!(matches!(actual, Err(SubmissionError::EmptyGroup(name)) if name == "basics"));
+    }
+
+    #[test]
+    fn undeclared_group() {
+        let mut grouped = test_case(1);
+        grouped.group = Some(String::from("edge cases"));
+        let submission = submission(vec![test_case(0), grouped]);
+
+        let actual = submission.validate(&LIMITS);
+
+        assert!(
+            matches!(actual, Err(SubmissionError::UnknownGroup(1, name)) if name == "edge cases")
+        );
+    }
+
+    #[test]
+    #[cfg(feature = "haskell")]
+    fn haskell_is_not_interactive() {
+        let mut submission = submission(vec![test_case(0)]);
+        submission.interactor = Some(Interactor {
+            language: None,
+            source: String::new(),
+        });
+
+        let actual = submission.validate(&LIMITS);
+
+        assert!(matches!(
+            actual,
+            Err(SubmissionError::NotInteractive(Language::Haskell))
+        ));
+    }
+
+    #[test]
+    fn transcript_without_interactor() {
+        let mut submission = submission(vec![test_case(0)]);
+        submission.record_transcript = true;
+
+        let actual = submission.validate(&LIMITS);
+
+        assert!(matches!(
+            actual,
+            Err(SubmissionError::TranscriptWithoutInteractor)
+        ));
+    }
+
+    #[test]
+    fn fixture_outside_of_the_fixtures() {
+        let mut test_case = test_case(2);
+        test_case.fixtures = vec![String::from("../config.toml")];
+        let submission = submission(vec![test_case]);
+
+        let actual = submission.validate(&LIMITS);
+
+        assert!(
+            matches!(actual, Err(SubmissionError::InvalidFixtureId(2, id)) if id == "../config.toml")
+        );
+    }
+
+    #[test]
+    fn interactive_stdin() {
+        let mut test_case = test_case(0);
+        test_case.stdin = Some(String::from("input.txt"));
+        let mut submission = submission(vec![test_case]);
+        submission.interactor = Some(Interactor {
+            language: Some(Language::Python),
+            source: String::new(),
+        });
+
+        let actual = submission.validate(&LIMITS);
+
+        assert!(matches!(actual, Err(SubmissionError::InteractiveStdin(0))));
+    }
+
+    #[test]
+    fn file_outside_of_the_workspace() {
+        let mut submission = submission(vec![test_case(0)]);
+        submission.files = BTreeMap::from([(String::from("../helper.py"), String::new())]);
+
+        let actual = submission.validate(&LIMITS);
+
+        assert!(
+            matches!(actual, Err(SubmissionError::InvalidFilePath(path)) if path == "../helper.py")
+        );
+    }
+
+    #[test]
+    fn file_which_is_not_base64() {
+        let mut submission = submission(vec![test_case(0)]);
+        submission.files = BTreeMap::from([(String::from("helper.py"), String::from("x = 5"))]);
+
+        let actual = submission.validate(&LIMITS);
+
+        assert!(
+            matches!(actual, Err(SubmissionError::InvalidFileContents(path)) if path == "helper.py")
+        );
+    }
+
+    #[test]
+    fn invalid_blobs() {
+        let hash = "7c8f5059290305cec8323d79521f0353c9ac308b60cb4c1976340d0ce4a121d5";
+        let mut replacing = submission(vec![test_case(0)]);
+        replacing.files = BTreeMap::from([(String::from("data.txt"), String::from("MSAyIDM="))]);
+        replacing.blobs = BTreeMap::from([(String::from("data.txt"), hash.to_string())]);
+        let mut unhashed = submission(vec![test_case(0)]);
+        unhashed.blobs = BTreeMap::from([(String::from("data.txt"), String::from("data"))]);
+
+        let replacing = replacing.validate(&LIMITS);
+        let unhashed = unhashed.validate(&LIMITS);
+
+        assert!(
+            matches!(replacing, Err(SubmissionError::BlobReplacesFile(path)) if path == "data.txt")
+        );
+        assert!(
+            matches!(unhashed, Err(SubmissionError::InvalidBlobHash(path)) if path == "data.txt")
+        );
+    }
+
+    #[test]
+    fn env_not_allowed() {
+        let limits = SubmissionLimits {
+            allowed_env: vec![String::from("HOME"), String::from("APP_*")],
+            ..LIMITS
+        };
+        let env = |name: &str| {
+            let mut test_case = test_case(4);
+            test_case.env = BTreeMap::from([(name.to_string(), String::from("value"))]);
+            submission(vec![test_case])
+        };
+
+        for name in ["LD_PRELOAD", "HOMEDIR", "APP-NAME", "APP_=1", ""] {
+            let actual = env(name).validate(&limits);
+
+            assert!(
+                matches!(&actual, Err(SubmissionError::EnvNotAllowed(4, actual)) if actual == name),
+                "{name}"
+            );
+        }
+    }
+
+    #[test]
+    #[cfg(feature = "haskell")]
+    fn allowed_env() {
+        let limits = SubmissionLimits {
+            allowed_env: vec![String::from("HOME"), String::from("APP_*")],
+            ..LIMITS
+        };
+        let mut test_case = test_case(0);
+        test_case.env = BTreeMap::from([
+            (String::from("HOME"), String::from("/tmp")),
+            (String::from("APP_MODE"), String::from("test")),
+        ]);
+
+        let actual = submission(vec![test_case]).validate(&limits);
+
+        assert!(actual.is_ok());
+    }
+
+    #[test]
+    fn endpoint_not_allowed() {
+        let limits = SubmissionLimits {
+            allowed_endpoints: vec![String::from("mock:8080"), String::from("db:8080")],
+            ..LIMITS
+        };
+        let network = |endpoints: &[&str]| {
+            let mut submission = submission(vec![test_case(0)]);
+            submission.network = NetworkPolicy::Allow(
+                endpoints
+                    .iter()
+                    .map(|endpoint| endpoint.to_string())
+                    .collect(),
+            );
+            submission.validate(&limits)
+        };
+
+        assert!(
+            matches!(network(&["other:8080"]), Err(SubmissionError::EndpointNotAllowed(endpoint)) if endpoint == "other:8080")
+        );
+        assert!(
+            matches!(network(&["mock:8080", "db:8080"]), Err(SubmissionError::InvalidEndpoint(endpoint)) if endpoint == "db:8080")
+        );
+        for endpoint in ["mock", "mock:0", ":8080", "mock:http"] {
+            assert!(
+                matches!(
+                    network(&[endpoint]),
+                    Err(SubmissionError::InvalidEndpoint(_))
+                ),
+                "{endpoint}"
+            );
+        }
+    }
+
+    #[test]
+    fn network_policy() {
+        let parse = |network: &str| {
+            serde_json::from_str::<NetworkPolicy>(network).expect("the policy should be valid")
+        };
+
+        assert_eq!(parse(r#""none""#), NetworkPolicy::None);
+        assert_eq!(parse(r#""loopback-only""#), NetworkPolicy::LoopbackOnly);
+        assert_eq!(
+            parse(r#"{ "allow": ["mock:8080"] }"#),
+            NetworkPolicy::Allow(Box::new([String::from("mock:8080")]))
+        );
+    }
+
+    #[test]
+    fn work_dir_outside_of_the_workspace() {
+        let mut test_case = test_case(0);
+        test_case.work_dir = BTreeMap::from([(String::from("/etc/passwd"), String::new())]);
+
+        let actual = submission(vec![test_case]).validate(&LIMITS);
+
+        assert!(
+            matches!(actual, Err(SubmissionError::InvalidFilePath(path)) if path == "/etc/passwd")
+        );
+    }
+
+    #[test]
+    fn negative_epsilon() {
+        let mut test_case = test_case(3);
+        test_case.epsilon = Some(-0.1);
+        let submission = submission(vec![test_case]);
+
+        let actual = submission.validate(&LIMITS);
+
+        assert!(matches!(actual, Err(SubmissionError::InvalidEpsilon(3))));
+    }
+
+    #[test]
+    fn invalid_callback_url() {
+        let mut submission = submission(vec![test_case(0)]);
+        submission.callback_url = Some(String::from("ftp://example.com/results"));
+
+        let actual = submission.validate(&LIMITS);
+
+        assert!(matches!(
+            actual,
+            Err(SubmissionError::InvalidCallbackUrl(_))
+        ));
+    }
+
+    #[test]
+    fn callback_not_allowed() {
+        let limits = SubmissionLimits {
+            callback_hosts: vec![String::from("lms.example.com")],
+            ..LIMITS
+        };
+        let callback = |url: &str| {
+            let mut submission = submission(vec![test_case(0)]);
+            submission.callback_url = Some(url.to_string());
+            submission.validate(&limits)
+        };
+
+        assert!(callback("https://LMS.example.com:8443/results").is_ok());
+        assert!(matches!(
+            callback("https://other.example.com/results"),
+            Err(SubmissionError::CallbackNotAllowed(_))
+        ));
+        assert!(matches!(
+            callback("https://"),
+            Err(SubmissionError::InvalidCallbackUrl(_))
+        ));
+    }
+
+    #[test]
+    fn zero_max_failures() {
+        let mut submission = submission(vec![test_case(0)]);
+        submission.max_failures = Some(0);
+
+        let actual = submission.validate(&LIMITS);
+
+        assert!(matches!(actual, Err(SubmissionError::ZeroMaxFailures)));
+    }
+
+    #[test]
+    fn benchmarks() {
+        let mut submission = submission(vec![test_case(0), test_case(1)]);
+        let benchmark = |runs, warmup, test_cases| {
+            Some(Benchmark {
+                runs,
+                warmup,
+                test_cases,
+            })
+        };
+        let mut validate = |benchmark| {
+            submission.benchmark = benchmark;
+            submission.validate(&LIMITS)
+        };
+
+        assert!(validate(benchmark(5, 1, vec![1])).is_ok());
+        assert!(matches!(
+            validate(benchmark(0, 1, Vec::new())),
+            Err(SubmissionError::InvalidBenchmarkRuns(99))
+        ));
+        assert!(matches!(
+            validate(benchmark(90, 10, Vec::new())),
+            Err(SubmissionError::InvalidBenchmarkRuns(_))
+        ));
+        assert!(matches!(
+            validate(benchmark(5, 0, vec![2])),
+            Err(SubmissionError::UnknownBenchmarkTestCase(2))
+        ));
+    }
+
+    #[test]
+    fn image_digests() {
+        let mut submission = submission(vec![test_case(0)]);
+        submission.image_digest = Some(format!("sha256:{}", "0a".repeat(32)));
+        let valid = submission.validate(&LIMITS);
+        submission.image_digest = Some(String::from("haskell:9.8"));
+
+        let actual = submission.validate(&LIMITS);
+
+        assert!(valid.is_ok());
+        assert!(matches!(
+            actual,
+            Err(SubmissionError::InvalidImageDigest(_))
+        ));
+    }
+
+    #[test]
+    #[cfg(feature = "python")]
+    fn test_suites() {
+        let mut named = test_case(0);
+        named.name = Some(String::from("test_sum.py::test_large"));
+        named.output_parameters = Box::new([]);
+        let mut submission = submission(vec![named]);
+        submission.test_suite = true;
+        let without_framework = submission.validate(&LIMITS);
+        submission.language = Language::Python;
+        let without_test_files = submission.validate(&LIMITS);
+        submission.files = BTreeMap::from([(String::from("test_sum.py"), String::new())]);
+        let valid = submission.validate(&LIMITS);
+        submission.language = Language::Go;
+
+        let actual = submission.validate(&LIMITS);
+
+        assert!(matches!(
+            without_framework,
+            Err(SubmissionError::NoTestFramework(Language::Haskell))
+        ));
+        assert!(matches!(
+            without_test_files,
+            Err(SubmissionError::NoTestFiles)
+        ));
+        assert!(valid.is_ok());
+        assert!(matches!(actual, Err(SubmissionError::NoTestFiles)));
+    }
+
+    #[test]
+    fn failure_limit() {
+        let mut submission = submission(vec![test_case(0)]);
+        submission.max_failures = Some(3);
+        let max_failures = submission.failure_limit();
+        submission.stop_on_first_fail = true;
+
+        assert_eq!(max_failures, Some(3));
+        assert_eq!(submission.failure_limit(), Some(1));
+    }
+
+    #[test]
+    fn unknown_profile() {
+        let limits = SubmissionLimits {
+            profiles: vec![String::from("quick"), String::from("full")],
+            ..LIMITS
+        };
+        let mut submission = submission(vec![test_case(0)]);
+        submission.profile = Some(String::from("fast"));
+
+        let actual = submission.validate(&limits);
+
+        assert!(
+            matches!(actual, Err(SubmissionError::UnknownProfile(profile)) if profile == "fast")
+        );
+    }
+
+    #[test]
+    fn unknown_version() {
+        let limits = SubmissionLimits {
+            versions: BTreeMap::from([(
+                Language::Haskell,
+                vec![String::from("9.6"), String::from("9.8")],
+            )]),
+            ..LIMITS
+        };
+        let mut submission = submission(vec![test_case(0)]);
+        submission.toolchain_version = Some(String::from("9.8"));
+        let available = submission.validate(&limits);
+        submission.toolchain_version = Some(String::from("9.4"));
+
+        let actual = submission.validate(&limits).unwrap_err();
+
+        assert!(available.is_ok());
+        assert_eq!(actual.code(), "unknownVersion");
+        assert_eq!(
+            actual.to_string(),
+            "haskell has no toolchain version 9.4, the available versions are: 9.6, 9.8"
+        );
+        assert!(submission
+            .validate(&LIMITS)
+            .is_err_and(|err| err.to_string().ends_with("are: none")));
+    }
+
+    #[test]
+    fn capped_limits() {
+        let limits = SubmissionLimits {
+            caps: LimitCaps {
+                time: 10000,
+                memory: 512,
+                processes: 64,
+                disk: 128,
+            },
+            ..LIMITS
+        };
+        let within = {
+            let mut test_case = test_case(0);
+            test_case.time_limit = Some(10000);
+            let mut submission = submission(vec![test_case]);
+            submission.time_limit = Some(5000);
+            submission.memory_limit = Some(512);
+            submission.process_limit = Some(64);
+            submission.disk_limit = Some(128);
+            submission
+        };
+        let slow_test_case = {
+            let mut test_case = test_case(1);
+            test_case.time_limit = Some(10001);
+            submission(vec![test_case])
+        };
+        let mut slow_setup = submission(vec![test_case(0)]);
+        slow_setup.setup = Some(super::Hook {
+            script: String::from("true"),
+            time_limit: Some(10001),
+        });
+        let mut unlimited_processes = submission(vec![test_case(0)]);
+        unlimited_processes.process_limit = Some(0);
+        let mut large_disk = submission(vec![test_case(0)]);
+        large_disk.disk_limit = Some(129);
+
+        assert!(within.validate(&limits).is_ok());
+        assert!(matches!(
+            slow_test_case.validate(&limits),
+            Err(SubmissionError::LimitTooHigh(
+                "time limit in milliseconds",
+                10001,
+                10000
+            ))
+        ));
+        assert!(matches!(
+            slow_setup.validate(&limits),
+            Err(SubmissionError::LimitTooHigh(
+                "time limit in milliseconds",
+                10001,
+                10000
+            ))
+        ));
+        assert!(matches!(
+            unlimited_processes.validate(&limits),
+            Err(SubmissionError::LimitTooHigh("process limit", 0, 64))
+        ));
+        assert!(matches!(
+            large_disk.validate(&limits),
+            Err(SubmissionError::LimitTooHigh(_, 129, 128))
+        ));
+        assert!(large_disk.validate(&LIMITS).is_ok());
+    }
+
+    #[test]
+    #[cfg(all(feature = "haskell", not(feature = "java")))]
+    fn unsupported_checker_language() {
+        let mut submission = submission(vec![test_case(0)]);
+        submission.checker = Some(super::Checker {
+            language: Some(Language::Java),
+            source: String::new(),
+        });
+
+        let actual = submission.validate(&LIMITS);
+
+        assert!(matches!(
+            actual,
+            Err(SubmissionError::UnsupportedLanguage(Language::Java))
+        ));
+    }
+}
+
+#[cfg(test)]
+mod priority {
+    use super::{Priority, Submission};
+
+    fn asking_for(priority: Option<Priority>) -> Submission {
+        let mut submission: Submission =
+            serde_json::from_str(r#"{"solution": "solution = 5", "testCases": []}"#).unwrap();
+        submission.priority = priority;
+
+        submission
+    }
+
+    #[test]
+    fn caps_priority_at_allowed() {
+        let exam = asking_for(Some(Priority::Exam)).prioritize(Priority::Normal, Priority::Normal);
+        let batch = asking_for(Some(Priority::Batch)).prioritize(Priority::Exam, Priority::Exam);
+        let default = asking_for(None).prioritize(Priority::Exam, Priority::Batch);
+        let allowed = asking_for(None).prioritize(Priority::Exam, Priority::Exam);
+
+        assert_eq!(exam, Priority::Normal);
+        assert_eq!(batch, Priority::Batch);
+        assert_eq!(default, Priority::Batch);
+        assert_eq!(allowed, Priority::Exam);
+        assert_eq!(asking_for(None).priority(), Priority::Normal);
+    }
+}
+
+#[cfg(test)]
+mod benchmark {
+    use super::BenchmarkResult;
+
+    #[test]
+    fn percentiles() {
+        let mut runtimes = [40, 10, 30, 20, 100];
+        let mut memory = [1, 2, 3, 4, 5];
+
+        let actual = BenchmarkResult::of(&mut runtimes, Some(&mut memory));
+
+        assert_eq!(
+            actual,
+            BenchmarkResult {
+                runs: 5,
+                median_runtime: 30,
+                p95_runtime: 100,
+                median_memory: Some(3),
+                p95_memory: Some(5),
+            }
+        );
+        assert_eq!(BenchmarkResult::of(&mut [7], None).p95_runtime, 7);
+        assert_eq!(BenchmarkResult::of(&mut [], None).median_runtime, 0);
+    }
+}
+
+#[cfg(test)]
+mod verdict {
+    use super::{
+        DecidingFailure, FailureKind, SubmissionResult, TestCaseFailureReason, TestCaseResult,
+        TestResult, Verdict, VerdictMode, VerdictPolicy,
+    };
+    use std::collections::BTreeMap;
+
+    fn result(id: u64, test_result: TestResult) -> TestCaseResult {
+        TestCaseResult {
+            id,
+            name: None,
+            test_result,
+            hidden: false,
+            stderr: String::new(),
+            runtime: 0,
+            memory: None,
+            user_time: None,
+            system_time: None,
+            cause: None,
+            benchmark: None,
+            attachments: BTreeMap::new(),
+        }
+    }
+
+    #[test]
+    fn security_violation_takes_precedence() {
+        let actual = SubmissionResult::checked(
+            String::new(),
+            Box::new([
+                result(0, TestResult::Failure(TestCaseFailureReason::RuntimeError)),
+                result(
+                    1,
+                    TestResult::Failure(TestCaseFailureReason::SecurityViolation),
+                ),
+            ]),
+        );
+
+        assert_eq!(actual.verdict, Verdict::SecurityViolation);
+    }
+
+    #[test]
+    fn failure() {
+        let actual = SubmissionResult::checked(
+            String::new(),
+            Box::new([
+                result(0, TestResult::Pass),
+                result(1, TestResult::Failure(TestCaseFailureReason::RuntimeError)),
+            ]),
+        );
+
+        assert_eq!(actual.verdict, Verdict::Failure);
+    }
+
+    fn failures() -> Box<[TestCaseResult]> {
+        Box::new([
+            result(0, TestResult::Pass),
+            result(1, TestResult::Failure(TestCaseFailureReason::RuntimeError)),
+            result(
+                2,
+                TestResult::Failure(TestCaseFailureReason::TimeLimitExceeded),
+            ),
+            result(
+                3,
+                TestResult::Failure(TestCaseFailureReason::TimeLimitExceeded),
+            ),
+            result(4, TestResult::Skipped),
+        ])
+    }
+
+    #[test]
+    fn first_failure_decides() {
+        let actual = SubmissionResult::checked(String::new(), failures());
+
+        assert_eq!(
+            actual.deciding_failure.as_deref(),
+            Some(&DecidingFailure {
+                test_case: 1,
+                reason: FailureKind::RuntimeError,
+            })
+        );
+        assert!(
+            SubmissionResult::checked(String::new(), Box::new([result(0, TestResult::Pass)]))
+                .deciding_failure
+                .is_none()
+        );
+    }
+
+    #[test]
+    fn priority_decides() {
+        let policy = VerdictPolicy {
+            mode: VerdictMode::Priority,
+            priority: vec![FailureKind::TimeLimitExceeded, FailureKind::RuntimeError],
+        };
+        let unlisted = VerdictPolicy {
+            mode: VerdictMode::Priority,
+            priority: vec![FailureKind::WrongAnswer],
+        };
+
+        let actual = SubmissionResult::judged(String::new(), failures(), &policy);
+
+        // the earlier of the test cases which exceeded their time limit decides
+        assert_eq!(actual.verdict, Verdict::TimeLimitExceeded);
+        assert_eq!(
+            actual.deciding_failure.map(|failure| failure.test_case),
+            Some(2)
+        );
+        assert_eq!(
+            unlisted
+                .decide(&failures())
+                .map(|failure| failure.test_case),
+            Some(1)
+        );
+    }
+
+    #[test]
+    fn policies_decide_verdicts() {
+        let first = VerdictPolicy::default();
+        let priority = VerdictPolicy {
+            mode: VerdictMode::Priority,
+            priority: vec![FailureKind::TimeLimitExceeded],
+        };
+        let unlisted = VerdictPolicy {
+            mode: VerdictMode::Priority,
+            priority: vec![FailureKind::MemoryLimitExceeded],
+        };
+
+        let verdict = |policy| SubmissionResult::judged(String::new(), failures(), policy).verdict;
+
+        assert_eq!(verdict(&first), Verdict::Failure);
+        assert_eq!(verdict(&priority), Verdict::TimeLimitExceeded);
+        // the first of the failures the priority leaves out decides, which is the runtime error of test case 1
+        assert_eq!(verdict(&unlisted), Verdict::RuntimeError);
+        assert_eq!(
+            SubmissionResult::judged(
+                String::new(),
+                Box::new([result(0, TestResult::Pass)]),
+                &priority
+            )
+            .verdict,
+            Verdict::Pass
+        );
+    }
+
+    #[test]
+    fn security_violation_decides() {
+        let policy = VerdictPolicy {
+            mode: VerdictMode::Priority,
+            priority: vec![FailureKind::RuntimeError],
+        };
+        let mut results = failures().into_vec();
+        results.push(result(
+            5,
+            TestResult::Failure(TestCaseFailureReason::SecurityViolation),
+        ));
+
+        let actual = policy.decide(&results);
+
+        assert_eq!(
+            actual,
+            Some(DecidingFailure {
+                test_case: 5,
+                reason: FailureKind::SecurityViolation,
+            })
+        );
+    }
+}
+
+#[cfg(test)]
+mod redact {
+    use super::{
+        HookResult, HookResults, Parameter, SubmissionResult, TestCaseFailureReason,
+        TestCaseResult, TestResult,
+    };
+    use std::collections::BTreeMap;
+
+    fn wrong_answer(id: u64, hidden: bool) -> TestCaseResult {
+        TestCaseResult {
+            id,
+            name: Some(format!("test {id}")),
+            test_result: TestResult::Failure(TestCaseFailureReason::WrongAnswer {
+                input_parameters: Box::new([Parameter {
+                    value_type: String::from("int"),
+                    value: String::from("2"),
+                }]),
+                actual: String::from("3"),
+                expected: String::from("4"),
+                diff: Some(String::from("[-4-]{+3+}")),
+                encoding: None,
+            }),
+            hidden,
+            stderr: String::from("adding numbers"),
+            runtime: 12,
+            memory: None,
+            user_time: None,
+            system_time: None,
+            cause: None,
+            benchmark: None,
+            attachments: BTreeMap::new(),
+        }
+    }
+
+    #[test]
+    fn only_hidden_test_cases() {
+        let mut actual = SubmissionResult::checked(
+            String::new(),
+            Box::new([wrong_answer(0, false), wrong_answer(1, true)]),
+        );
+
+        actual.redact();
+
+        let [visible, hidden] = &*actual.test_case_results else {
+            panic!("both test cases should be kept");
+        };
+        assert!(visible.test_result == wrong_answer(0, false).test_result);
+        assert_eq!(visible.stderr, "adding numbers");
+        assert!(
+            hidden.test_result
+                == TestResult::Failure(TestCaseFailureReason::WrongAnswer {
+                    input_parameters: Box::new([]),
+                    actual: String::new(),
+                    expected: String::new(),
+                    diff: None,
+                    encoding: None,
+                })
+        );
+        assert_eq!(hidden.stderr, "");
+        assert_eq!(hidden.name.as_deref(), Some("test 1"));
+        assert_eq!(hidden.runtime, 12);
+    }
+
+    #[test]
+    fn hooks() {
+        let mut actual = SubmissionResult::checked(String::new(), Box::new([]));
+        actual.hooks = Some(Box::new(HookResults {
+            setup: Some(HookResult {
+                exit_code: Some(0),
+                killed: None,
+                stdout: String::from("generated 100 rows"),
+                stderr: String::new(),
+                runtime: 3,
+            }),
+            teardown: None,
+        }));
+
+        actual.redact();
+
+        assert!(actual.hooks.is_none());
+    }
 }
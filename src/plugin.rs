@@ -0,0 +1,334 @@
+use crate::{
+    config::Config,
+    error::{CheckError, PluginError},
+    model::{Language, SubmissionResult, TestCase, TestCaseResult, Verdict},
+};
+use serde::{Deserialize, Serialize};
+use std::{
+    fs,
+    io::{Read, Write},
+    os::unix::{fs::PermissionsExt, process::CommandExt},
+    path::{Path, PathBuf},
+    process::{Command, Stdio},
+    sync::Arc,
+    thread,
+    time::{Duration, Instant},
+};
+use tracing::{info, warn};
+
+/// How often a running plugin is checked for having exited.
+const POLL_INTERVAL: Duration = Duration::from_millis(10);
+
+/// The programs which adjust the verdict and score of every judged submission once its test cases were compared, in
+/// the order they are configured.
+///
+/// A plugin is an executable the operator trusts, so it runs on the host rather than in the sandbox. It is given the
+/// submission and the result of every test case as json on its standard input, and prints what it adjusts as json on
+/// its standard output.
+///
+/// The plugins are loaded once on startup, and shared by every judgment, where the default has none.
+#[derive(Clone, Default)]
+pub struct VerdictPlugins {
+    plugins: Arc<[PathBuf]>,
+    timeout: Duration,
+}
+
+/// What a plugin is given to judge the submission by.
+#[derive(Serialize)]
+#[serde(rename_all = "camelCase")]
+struct Context<'a> {
+    language: Language,
+    solution: &'a str,
+    verdict: Verdict,
+    score: f64,
+    test_cases: Vec<TestCaseContext<'a>>,
+}
+
+/// A test case along with how it was judged.
+#[derive(Serialize)]
+#[serde(rename_all = "camelCase")]
+struct TestCaseContext<'a> {
+    test_case: &'a TestCase,
+    result: &'a TestCaseResult,
+}
+
+/// What a plugin changes about the result, where whatever it leaves out stays as it was.
+#[derive(Deserialize, Default, Debug, PartialEq)]
+#[serde(deny_unknown_fields)]
+struct Adjustment {
+    verdict: Option<Verdict>,
+    score: Option<f64>,
+}
+
+impl VerdictPlugins {
+    /// Loads the configured plugins, each of which must be an executable file.
+    pub fn from_config(config: &Config) -> Result<Self, PluginError> {
+        for plugin in &config.verdict_plugins {
+            let metadata = fs::metadata(plugin)
+                .map_err(|err| PluginError::Read(plugin.clone(), err.to_string()))?;
+            if !metadata.is_file() || metadata.permissions().mode() & 0o111 == 0 {
+                return Err(PluginError::NotExecutable(plugin.clone()));
+            }
+        }
+
+        Ok(Self {
+            plugins: config.verdict_plugins.clone().into(),
+            timeout: config.verdict_plugin_timeout(),
+        })
+    }
+
+    /// Gets the number of plugins.
+    pub fn len(&self) -> usize {
+        self.plugins.len()
+    }
+
+    /// Whether no plugins are configured.
+    pub fn is_empty(&self) -> bool {
+        self.plugins.is_empty()
+    }
+
+    /// Runs every plugin on the result in turn, so each is given the verdict and score as adjusted by the plugins
+    /// before it.
+    ///
+    /// A solution which tried to escape the sandbox is never adjusted, and a plugin may only decide that a submission
+    /// passed or failed. A plugin which fails, takes longer than the timeout, or prints anything but an adjustment
+    /// fails the judgment with [`CheckError::Plugin`], as the result would not be what the operator meant it to be.
+    pub fn adjust(
+        &self,
+        language: Language,
+        solution: &str,
+        test_cases: &[TestCase],
+        mut result: SubmissionResult,
+    ) -> Result<SubmissionResult, CheckError> {
+        if result.verdict == Verdict::SecurityViolation {
+            return Ok(result);
+        }
+
+        for plugin in self.plugins.iter() {
+            let context = Context {
+                language,
+                solution,
+                verdict: result.verdict,
+                score: result.score,
+                test_cases: test_cases
+                    .iter()
+                    .zip(result.test_case_results.iter())
+                    .map(|(test_case, result)| TestCaseContext { test_case, result })
+                    .collect(),
+            };
+            let input = serde_json::to_vec(&context).expect("the context should serialize");
+            let adjustment = run(plugin, &input, self.timeout)?;
+
+            if let Some(verdict) = adjustment.verdict {
+                if !matches!(verdict, Verdict::Pass | Verdict::Failure) {
+                    return Err(plugin_failure(
+                        plugin,
+                        "may only adjust the verdict to pass or failure",
+                    ));
+                }
+                result.verdict = verdict;
+            }
+            if let Some(score) = adjustment.score {
+                if !(0.0..=100.0).contains(&score) {
+                    return Err(plugin_failure(plugin, "adjusted the score beyond 0 to 100"));
+                }
+                result.score = score;
+            }
+            info!(
+                plugin = %plugin.display(),
+                verdict = ?result.verdict,
+                score = result.score,
+                "adjusted the result"
+            );
+        }
+
+        Ok(result)
+    }
+}
+
+/// Runs the plugin with the input on its standard input, killing it if it takes longer than the timeout, and parses
+/// the adjustment it printed.
+///
+/// A plugin which prints nothing adjusts nothing.
+fn run(plugin: &Path, input: &[u8], timeout: Duration) -> Result<Adjustment, CheckError> {
+    let mut command = Command::new(plugin);
+    // a separate process group allows killing every process spawned by the plugin
+    command
+        .process_group(0)
+        .stdin(Stdio::piped())
+        .stdout(Stdio::piped())
+        .stderr(Stdio::piped());
+
+    let deadline = Instant::now() + timeout;
+    let mut child = command
+        .spawn()
+        .map_err(|err| plugin_failure(plugin, &format!("could not be started: {err}")))?;
+
+    // the input is written while the output is read, so neither side blocks on a full pipe
+    let mut stdin_pipe = child.stdin.take().expect("stdin is piped");
+    let mut stdout_pipe = child.stdout.take().expect("stdout is piped");
+    let mut stderr_pipe = child.stderr.take().expect("stderr is piped");
+    let input = input.to_vec();
+    let writer = thread::spawn(move || {
+        // a plugin may exit without reading everything it was given
+        let _ = stdin_pipe.write_all(&input);
+    });
+    let stdout_reader = thread::spawn(move || read_all(&mut stdout_pipe));
+    let stderr_reader = thread::spawn(move || read_all(&mut stderr_pipe));
+
+    let status = loop {
+        match child.try_wait() {
+            Ok(Some(status)) => break status,
+            Ok(None) if Instant::now() >= deadline => {
+                // SAFETY: the child is the leader of its own process group, so only its processes are signalled.
+                unsafe {
+                    libc::kill(-(child.id() as i32), libc::SIGKILL);
+                }
+                let _ = child.wait();
+                return Err(plugin_failure(plugin, "took longer than its timeout"));
+            }
+            Ok(None) => thread::sleep(POLL_INTERVAL),
+            Err(err) => return Err(plugin_failure(plugin, &err.to_string())),
+        }
+    };
+    let _ = writer.join();
+    let stdout = stdout_reader.join().unwrap_or_default();
+    let stderr = stderr_reader.join().unwrap_or_default();
+
+    if !status.success() {
+        let stderr = String::from_utf8_lossy(&stderr);
+        return Err(plugin_failure(
+            plugin,
+            &format!("exited with {status}: {}", stderr.trim()),
+        ));
+    }
+    if stdout.iter().all(u8::is_ascii_whitespace) {
+        return Ok(Adjustment::default());
+    }
+    serde_json::from_slice(&stdout).map_err(|err| {
+        warn!(plugin = %plugin.display(), output = %String::from_utf8_lossy(&stdout), "plugin printed an invalid adjustment");
+        plugin_failure(plugin, &format!("printed an invalid adjustment: {err}"))
+    })
+}
+
+fn read_all(pipe: &mut impl Read) -> Vec<u8> {
+    let mut output = Vec::new();
+    let _ = pipe.read_to_end(&mut output);
+    output
+}
+
+fn plugin_failure(plugin: &Path, reason: &str) -> CheckError {
+    CheckError::Plugin(format!("the verdict plugin {} {reason}", plugin.display()))
+}
+
+#[cfg(test)]
+mod adjusting {
+    use super::VerdictPlugins;
+    use crate::{
+        config::Config,
+        error::CheckError,
+        model::{Language, SubmissionResult, TestCase, TestCaseResult, TestResult, Verdict},
+    };
+    use std::{
+        env, fs,
+        os::unix::fs::PermissionsExt,
+        path::{Path, PathBuf},
+    };
+    use uuid::Uuid;
+
+    fn plugin(dir: &Path, name: &str, script: &str) -> PathBuf {
+        let path = dir.join(name);
+        fs::write(&path, format!("#!/bin/sh\n{script}\n")).unwrap();
+        fs::set_permissions(&path, fs::Permissions::from_mode(0o755)).unwrap();
+        path
+    }
+
+    fn result(test_result: TestResult) -> (Vec<TestCase>, SubmissionResult) {
+        let test_case: TestCase =
+            serde_json::from_str(r#"{"id": 3, "inputParameters": [], "outputParameters": []}"#)
+                .unwrap();
+        let mut test_case_result = TestCaseResult::skipped(&test_case);
+        test_case_result.test_result = test_result;
+        let mut result = SubmissionResult::checked(String::new(), Box::new([test_case_result]));
+        result.score = 100.0;
+
+        (vec![test_case], result)
+    }
+
+    #[test]
+    fn adjusts_in_order() {
+        let dir = env::temp_dir().join(format!("mozart-plugin-{}", Uuid::new_v4()));
+        fs::create_dir_all(&dir).unwrap();
+        let config = Config {
+            verdict_plugins: vec![
+                // fails the submission unless the context has the test case, and halves its score
+                plugin(
+                    &dir,
+                    "style",
+                    r#"grep -q '"testCase":{"id":3' && echo '{"verdict": "failure", "score": 50}'"#,
+                ),
+                // sees the score of the plugin before it
+                plugin(
+                    &dir,
+                    "bonus",
+                    r#"grep -q '"score":50' && echo '{"score": 60}'"#,
+                ),
+            ],
+            ..Config::default()
+        };
+        let broken = Config {
+            verdict_plugins: vec![plugin(
+                &dir,
+                "broken",
+                "echo '{\"verdict\": \"compilationError\"}'",
+            )],
+            ..Config::default()
+        };
+
+        let (test_cases, passed) = result(TestResult::Pass);
+        let adjusted = VerdictPlugins::from_config(&config).unwrap().adjust(
+            Language::Python,
+            "",
+            &test_cases,
+            passed.clone(),
+        );
+        let rejected = VerdictPlugins::from_config(&broken).unwrap().adjust(
+            Language::Python,
+            "",
+            &test_cases,
+            passed,
+        );
+        let _ = fs::remove_dir_all(&dir);
+
+        let adjusted = adjusted.unwrap();
+        assert_eq!(adjusted.verdict, Verdict::Failure);
+        assert_eq!(adjusted.score, 60.0);
+        assert!(
+            matches!(rejected, Err(CheckError::Plugin(reason)) if reason.contains("pass or failure"))
+        );
+    }
+
+    #[test]
+    fn loads_executables() {
+        let dir = env::temp_dir().join(format!("mozart-plugin-{}", Uuid::new_v4()));
+        fs::create_dir_all(&dir).unwrap();
+        let script = dir.join("script");
+        fs::write(&script, "").unwrap();
+
+        let missing = VerdictPlugins::from_config(&Config {
+            verdict_plugins: vec![dir.join("missing")],
+            ..Config::default()
+        });
+        let not_executable = VerdictPlugins::from_config(&Config {
+            verdict_plugins: vec![script],
+            ..Config::default()
+        });
+        let _ = fs::remove_dir_all(&dir);
+
+        assert!(missing.is_err());
+        assert!(not_executable.is_err());
+        assert!(VerdictPlugins::from_config(&Config::default())
+            .unwrap()
+            .is_empty());
+    }
+}
@@ -0,0 +1,689 @@
+use crate::{crash, model::Priority};
+use std::{
+    collections::VecDeque,
+    future::{self, Future},
+    sync::{
+        atomic::{AtomicBool, AtomicUsize, Ordering},
+        Arc, Mutex, MutexGuard,
+    },
+    time::Duration,
+};
+use tokio::sync::{oneshot, OwnedSemaphorePermit, Semaphore};
+use tracing::{info_span, warn, Instrument, Span};
+
+/// The reason a job was not admitted into a [`WorkerPool`].
+#[derive(Debug, PartialEq)]
+pub enum Rejection {
+    /// The queue is full.
+    Full,
+
+    /// The pool is shutting down, and no longer admits jobs.
+    ShuttingDown,
+
+    /// The pool is paused, and admits no jobs until it is resumed.
+    Paused,
+
+    /// The disk is running low on space, so the pool admits no jobs until enough is free again.
+    LowDiskSpace,
+}
+
+/// A bounded pool of workers checking submissions, with a bounded queue of jobs waiting for a worker.
+///
+/// Waiting jobs get a worker by their [`Priority`], and some workers may be reserved for exam jobs, so a full queue of
+/// batch jobs never delays an exam.
+#[derive(Clone)]
+pub struct WorkerPool {
+    workers: Arc<Workers>,
+    slots: Arc<Semaphore>,
+    slot_count: Arc<AtomicUsize>,
+    shutting_down: Arc<AtomicBool>,
+    paused: Arc<AtomicBool>,
+    low_disk_space: Arc<AtomicBool>,
+}
+
+impl WorkerPool {
+    /// Creates a pool with the given number of workers, and room for `queue_size` waiting jobs.
+    pub fn new(worker_count: usize, queue_size: usize) -> Self {
+        let worker_count = worker_count.max(1);
+        let slot_count = worker_count + queue_size;
+
+        Self {
+            workers: Arc::new(Workers::new(worker_count)),
+            slots: Arc::new(Semaphore::new(slot_count)),
+            slot_count: Arc::new(AtomicUsize::new(slot_count)),
+            shutting_down: Arc::new(AtomicBool::new(false)),
+            paused: Arc::new(AtomicBool::new(false)),
+            low_disk_space: Arc::new(AtomicBool::new(false)),
+        }
+    }
+
+    /// Changes the number of workers, and the room for waiting jobs.
+    ///
+    /// Jobs which are running or waiting already are never dropped by shrinking the pool, as the workers and slots they
+    /// hold are only removed once they are done.
+    pub fn resize(&self, worker_count: usize, queue_size: usize) {
+        let worker_count = worker_count.max(1);
+        let slot_count = worker_count + queue_size;
+
+        self.workers.resize(worker_count);
+        resize(
+            &self.slots,
+            self.slot_count.swap(slot_count, Ordering::SeqCst),
+            slot_count,
+        );
+    }
+
+    /// Reserves workers for exam jobs, which no job of a lower priority runs on, while at least one worker is always
+    /// left to the other jobs.
+    ///
+    /// Jobs which are running on a worker already keep it until they are done.
+    pub fn reserve(&self, reserved: usize) {
+        self.workers.reserve(reserved);
+    }
+
+    /// Admits a job into the pool at normal priority, unless the queue is full, the pool is shutting down or paused, or
+    /// the disk is low on space.
+    pub fn admit(&self) -> Result<Admission, Rejection> {
+        if self.shutting_down.load(Ordering::SeqCst) {
+            return Err(Rejection::ShuttingDown);
+        }
+        if self.paused.load(Ordering::SeqCst) {
+            return Err(Rejection::Paused);
+        }
+        if self.low_disk_space.load(Ordering::SeqCst) {
+            return Err(Rejection::LowDiskSpace);
+        }
+
+        let slot = self
+            .slots
+            .clone()
+            .try_acquire_owned()
+            .map_err(|_| Rejection::Full)?;
+
+        Ok(Admission {
+            _slot: slot,
+            workers: self.workers.clone(),
+            priority: Priority::default(),
+        })
+    }
+
+    /// Stops admitting jobs, and waits until every admitted job is done.
+    ///
+    /// Jobs which are still waiting for a worker once the grace period has passed are cancelled, while running jobs
+    /// are always allowed to finish, as they are bounded by their own limits.
+    pub async fn shutdown(&self, grace: Duration) {
+        self.shutting_down.store(true, Ordering::SeqCst);
+
+        // every slot is returned once its job is done
+        let slot_count = u32::try_from(self.slot_count()).unwrap_or(u32::MAX);
+        if tokio::time::timeout(grace, self.slots.acquire_many(slot_count))
+            .await
+            .is_err()
+        {
+            warn!(
+                queued = self.queue_depth(),
+                "grace period passed, cancelling queued submissions"
+            );
+            self.workers.close();
+            let _ = self.slots.acquire_many(slot_count).await;
+        }
+    }
+
+    /// Gets the number of admitted jobs which are waiting for a worker, as every worker is taken.
+    pub fn queue_depth(&self) -> usize {
+        let admitted = self
+            .slot_count()
+            .saturating_sub(self.slots.available_permits());
+
+        admitted.saturating_sub(self.worker_count())
+    }
+
+    /// Whether every worker is taken and the queue is full, so no further job is admitted.
+    pub fn is_saturated(&self) -> bool {
+        self.slots.available_permits() == 0
+    }
+
+    /// Whether the pool is shutting down, so no further job is admitted.
+    pub fn is_shutting_down(&self) -> bool {
+        self.shutting_down.load(Ordering::SeqCst)
+    }
+
+    /// Stops admitting jobs until the pool is resumed, while the jobs which were admitted before are still run.
+    ///
+    /// Returns whether the pool was running before.
+    pub fn pause(&self) -> bool {
+        !self.paused.swap(true, Ordering::SeqCst)
+    }
+
+    /// Admits jobs again after the pool was paused, returning whether it was paused before.
+    pub fn resume(&self) -> bool {
+        self.paused.swap(false, Ordering::SeqCst)
+    }
+
+    pub fn is_paused(&self) -> bool {
+        self.paused.load(Ordering::SeqCst)
+    }
+
+    /// Stops admitting jobs while the disk is low on space, and admits them again once it is not, while the jobs which
+    /// were admitted before are still run.
+    pub fn set_low_disk_space(&self, low: bool) {
+        self.low_disk_space.store(low, Ordering::SeqCst);
+    }
+
+    pub fn is_low_on_disk_space(&self) -> bool {
+        self.low_disk_space.load(Ordering::SeqCst)
+    }
+
+    pub fn worker_count(&self) -> usize {
+        self.workers.schedule().count
+    }
+
+    fn slot_count(&self) -> usize {
+        self.slot_count.load(Ordering::SeqCst)
+    }
+
+    /// Gets the number of jobs which may wait for a worker.
+    pub fn queue_size(&self) -> usize {
+        self.slot_count() - self.worker_count()
+    }
+
+    /// Gets the number of workers which are currently running a job.
+    pub fn active_workers(&self) -> usize {
+        self.workers.schedule().running
+    }
+
+    /// Gets the lowest priority a job may have to run on a worker which is free right now, if one is free at all, as
+    /// the free workers may all be reserved for exam jobs.
+    pub fn available(&self) -> Option<Priority> {
+        let schedule = self.workers.schedule();
+        if schedule.running >= schedule.count {
+            return None;
+        }
+
+        match schedule.unreserved < schedule.unreserved_count() {
+            true => Some(Priority::Batch),
+            false => Some(Priority::Exam),
+        }
+    }
+}
+
+/// The workers of a [`WorkerPool`], which are handed to the waiting jobs of the highest priority first, and to jobs of
+/// the same priority in the order they started waiting.
+struct Workers {
+    schedule: Mutex<Schedule>,
+}
+
+struct Schedule {
+    count: usize,
+    /// The number of workers only exam jobs run on.
+    reserved: usize,
+    running: usize,
+    /// The number of running jobs which are not exam jobs.
+    unreserved: usize,
+    /// The jobs waiting for a worker, by priority, where a job which gave up waiting is skipped once it is its turn.
+    waiting: [VecDeque<oneshot::Sender<Worker>>; Priority::ALL.len()],
+    /// Whether the jobs which are waiting were cancelled, along with any job which starts waiting later.
+    closed: bool,
+}
+
+impl Schedule {
+    /// Gets the number of workers jobs which are not exam jobs may run on.
+    fn unreserved_count(&self) -> usize {
+        self.count.saturating_sub(self.reserved).max(1)
+    }
+}
+
+impl Workers {
+    fn new(count: usize) -> Self {
+        Self {
+            schedule: Mutex::new(Schedule {
+                count,
+                reserved: 0,
+                running: 0,
+                unreserved: 0,
+                waiting: Default::default(),
+                closed: false,
+            }),
+        }
+    }
+
+    fn schedule(&self) -> MutexGuard<'_, Schedule> {
+        self.schedule
+            .lock()
+            .expect("worker lock should not be poisoned")
+    }
+
+    fn resize(self: &Arc<Self>, count: usize) {
+        self.reschedule(|schedule| schedule.count = count);
+    }
+
+    fn reserve(self: &Arc<Self>, reserved: usize) {
+        self.reschedule(|schedule| schedule.reserved = reserved);
+    }
+
+    /// Changes the schedule, and hands the workers which are free afterwards to the waiting jobs.
+    fn reschedule(self: &Arc<Self>, change: impl FnOnce(&mut Schedule)) {
+        let unclaimed = {
+            let mut schedule = self.schedule();
+            change(&mut schedule);
+            self.dispatch(&mut schedule)
+        };
+        drop(unclaimed);
+    }
+
+    /// Waits for a worker to run a job of the priority on, returning `None` once the workers are closed.
+    async fn acquire(self: &Arc<Self>, priority: Priority) -> Option<Worker> {
+        let (sender, receiver) = oneshot::channel();
+        let unclaimed = {
+            let mut schedule = self.schedule();
+            if schedule.closed {
+                return None;
+            }
+            schedule.waiting[rank(priority)].push_back(sender);
+            self.dispatch(&mut schedule)
+        };
+        drop(unclaimed);
+
+        receiver.await.ok()
+    }
+
+    /// Cancels every job which is waiting for a worker, or starts waiting later.
+    fn close(&self) {
+        let mut schedule = self.schedule();
+        schedule.closed = true;
+        schedule.waiting.iter_mut().for_each(VecDeque::clear);
+    }
+
+    /// Hands the free workers to the waiting jobs, returning the workers of jobs which gave up waiting right as they
+    /// got one, which have to be dropped once the schedule is unlocked, as dropping a worker returns it.
+    fn dispatch(self: &Arc<Self>, schedule: &mut Schedule) -> Vec<Worker> {
+        let mut unclaimed = Vec::new();
+        while schedule.running < schedule.count {
+            let unreserved = schedule.unreserved < schedule.unreserved_count();
+            let Some((priority, sender)) = Priority::ALL
+                .into_iter()
+                .filter(|priority| *priority == Priority::Exam || unreserved)
+                .find_map(|priority| {
+                    let waiting = &mut schedule.waiting[rank(priority)];
+                    while let Some(sender) = waiting.pop_front() {
+                        if !sender.is_closed() {
+                            return Some((priority, sender));
+                        }
+                    }
+                    None
+                })
+            else {
+                break;
+            };
+
+            let exam = priority == Priority::Exam;
+            schedule.running += 1;
+            schedule.unreserved += usize::from(!exam);
+            if let Err(worker) = sender.send(Worker {
+                workers: self.clone(),
+                exam,
+            }) {
+                unclaimed.push(worker);
+            }
+        }
+
+        unclaimed
+    }
+
+    fn release(self: &Arc<Self>, exam: bool) {
+        self.reschedule(|schedule| {
+            schedule.running -= 1;
+            schedule.unreserved -= usize::from(!exam);
+        });
+    }
+}
+
+/// Gets the index of the queue of a priority, the highest first.
+fn rank(priority: Priority) -> usize {
+    Priority::ALL
+        .iter()
+        .position(|p| *p == priority)
+        .expect("every priority is ranked")
+}
+
+/// A worker which runs a job, and is returned to the pool once it is dropped.
+struct Worker {
+    workers: Arc<Workers>,
+    exam: bool,
+}
+
+impl Drop for Worker {
+    fn drop(&mut self) {
+        self.workers.release(self.exam);
+    }
+}
+
+/// Changes the number of permits of a semaphore, where permits which are taken are removed once they are returned.
+fn resize(semaphore: &Arc<Semaphore>, from: usize, to: usize) {
+    if to >= from {
+        semaphore.add_permits(to - from);
+        return;
+    }
+
+    let taken = (from - to) - semaphore.forget_permits(from - to);
+    if taken > 0 {
+        let semaphore = semaphore.clone();
+        let taken = u32::try_from(taken).unwrap_or(u32::MAX);
+        tokio::spawn(async move {
+            if let Ok(permits) = semaphore.acquire_many_owned(taken).await {
+                permits.forget();
+            }
+        });
+    }
+}
+
+/// A job which has been admitted into a [`WorkerPool`], and holds its place in the queue until it is run.
+pub struct Admission {
+    _slot: OwnedSemaphorePermit,
+    workers: Arc<Workers>,
+    priority: Priority,
+}
+
+impl Admission {
+    /// Changes the priority the job waits for a worker with.
+    pub fn with_priority(self, priority: Priority) -> Self {
+        Self { priority, ..self }
+    }
+
+    /// Waits for a free worker, then runs the job on a thread where blocking is allowed, within the current span.
+    ///
+    /// A job which panics does not take its worker down, but the [`Crash`](crash::Crash) is resumed on the caller, so whoever
+    /// recovers from it there gets the backtrace of the job.
+    ///
+    /// Returns `None` without running the job, if it was cancelled by [`WorkerPool::shutdown`].
+    pub async fn run<T, F>(self, job: F) -> Option<T>
+    where
+        T: Send + 'static,
+        F: FnOnce() -> T + Send + 'static,
+    {
+        self.run_unless(future::pending(), job).await
+    }
+
+    /// Runs the job like [`Admission::run`], unless `cancelled` completes while the job is still waiting for a worker,
+    /// in which case its place in the queue is given up.
+    ///
+    /// Once the job runs it is no longer affected by `cancelled`, so the job has to stop by itself.
+    pub async fn run_unless<T, F>(self, cancelled: impl Future<Output = ()>, job: F) -> Option<T>
+    where
+        T: Send + 'static,
+        F: FnOnce() -> T + Send + 'static,
+    {
+        // the workers are only closed when cancelling queued jobs
+        let _worker = tokio::select! {
+            worker = self.workers.acquire(self.priority).instrument(info_span!("queue", priority = self.priority.as_str())) => worker?,
+            () = cancelled => return None,
+        };
+
+        let span = Span::current();
+        let output = tokio::task::spawn_blocking(move || span.in_scope(|| crash::catch(job)))
+            .await
+            .expect("the job should not panic, as it is caught");
+
+        Some(output.unwrap_or_else(|crash| crash.resume()))
+    }
+}
+
+#[cfg(test)]
+mod admission {
+    use super::{Rejection, WorkerPool};
+    use crate::{crash, model::Priority};
+    use std::{
+        sync::{Arc, Mutex},
+        time::Duration,
+    };
+
+    #[test]
+    fn full_queue() {
+        let pool = WorkerPool::new(1, 1);
+
+        let first = pool.admit();
+        let second = pool.admit();
+        let third = pool.admit();
+
+        assert!(first.is_ok());
+        assert!(second.is_ok());
+        assert!(matches!(third, Err(Rejection::Full)));
+    }
+
+    #[test]
+    fn released_slot() {
+        let pool = WorkerPool::new(1, 0);
+
+        let first = pool.admit();
+        drop(first);
+        let second = pool.admit();
+
+        assert!(second.is_ok());
+    }
+
+    #[tokio::test]
+    async fn runs_job() {
+        let pool = WorkerPool::new(1, 0);
+        let admission = pool.admit().expect("the pool is empty");
+
+        let actual = admission.run(|| 42).await;
+
+        assert_eq!(actual, Some(42));
+    }
+
+    #[tokio::test]
+    async fn paused() {
+        let pool = WorkerPool::new(1, 1);
+        let admitted = pool.admit().expect("the pool is empty");
+
+        let paused = pool.pause();
+        let rejected = pool.admit();
+        let resumed = pool.resume();
+        let actual = admitted.run(|| 42).await;
+
+        assert!(paused && resumed);
+        assert!(matches!(rejected, Err(Rejection::Paused)));
+        assert!(pool.admit().is_ok());
+        assert_eq!(actual, Some(42));
+    }
+
+    #[test]
+    fn low_disk_space() {
+        let pool = WorkerPool::new(1, 1);
+
+        pool.set_low_disk_space(true);
+        let rejected = pool.admit();
+        pool.set_low_disk_space(false);
+
+        assert!(matches!(rejected, Err(Rejection::LowDiskSpace)));
+        assert!(pool.admit().is_ok());
+    }
+
+    #[tokio::test]
+    async fn panicking_job() {
+        let pool = WorkerPool::new(1, 0);
+        let admission = pool.admit().expect("the pool is empty");
+
+        let panicked = tokio::spawn(admission.run(|| panic!("malformed job")))
+            .await
+            .expect_err("the job should panic on the caller");
+        let crash = crash::catch(|| std::panic::resume_unwind(panicked.into_panic())).unwrap_err();
+        let actual = pool.admit().expect("the worker is free").run(|| 42).await;
+
+        assert_eq!(crash.message, "malformed job");
+        assert_eq!(actual, Some(42));
+    }
+
+    #[tokio::test]
+    async fn rejects_after_shutdown() {
+        let pool = WorkerPool::new(1, 0);
+
+        pool.shutdown(Duration::ZERO).await;
+        let actual = pool.admit();
+
+        assert!(matches!(actual, Err(Rejection::ShuttingDown)));
+    }
+
+    #[tokio::test]
+    async fn drains_running_job() {
+        let pool = WorkerPool::new(1, 0);
+        let admission = pool.admit().expect("the pool is empty");
+        let job = tokio::spawn(admission.run(|| std::thread::sleep(Duration::from_millis(50))));
+
+        pool.shutdown(Duration::from_secs(5)).await;
+
+        assert!(job.is_finished());
+        assert_eq!(job.await.expect("the job should not panic"), Some(()));
+    }
+
+    #[tokio::test]
+    async fn cancels_queued_job() {
+        let pool = WorkerPool::new(1, 1);
+        let running = pool.admit().expect("the pool is empty");
+        let queued = pool.admit().expect("the queue is empty");
+        let running = tokio::spawn(running.run(|| std::thread::sleep(Duration::from_millis(200))));
+        tokio::task::yield_now().await;
+        let queued = tokio::spawn(queued.run(|| ()));
+
+        pool.shutdown(Duration::from_millis(50)).await;
+
+        assert_eq!(running.await.expect("the job should not panic"), Some(()));
+        assert_eq!(queued.await.expect("the job should not panic"), None);
+    }
+
+    #[tokio::test]
+    async fn dequeues_cancelled_job() {
+        let pool = WorkerPool::new(1, 1);
+        let running = pool.admit().expect("the pool is empty");
+        let queued = pool.admit().expect("the queue is empty");
+        let running = tokio::spawn(running.run(|| std::thread::sleep(Duration::from_millis(200))));
+        tokio::task::yield_now().await;
+        let (cancel, cancelled) = tokio::sync::oneshot::channel::<()>();
+        let queued = tokio::spawn(queued.run_unless(
+            async move {
+                let _ = cancelled.await;
+            },
+            || (),
+        ));
+
+        let _ = cancel.send(());
+        let actual = queued.await.expect("the job should not panic");
+
+        assert_eq!(actual, None);
+        assert!(!pool.is_saturated());
+        assert_eq!(running.await.expect("the job should not panic"), Some(()));
+    }
+
+    #[tokio::test]
+    async fn serves_higher_priorities_first() {
+        let pool = WorkerPool::new(1, 3);
+        let (release, released) = std::sync::mpsc::channel::<()>();
+        let running = pool.admit().expect("the pool is empty");
+        let running = tokio::spawn(running.run(move || released.recv().unwrap()));
+        tokio::task::yield_now().await;
+        let order = Arc::new(Mutex::new(Vec::new()));
+        let mut queued = Vec::new();
+        for priority in [Priority::Batch, Priority::Normal, Priority::Exam] {
+            let order = order.clone();
+            let admission = pool.admit().expect("the queue has room");
+            queued.push(tokio::spawn(
+                admission
+                    .with_priority(priority)
+                    .run(move || order.lock().unwrap().push(priority)),
+            ));
+            tokio::task::yield_now().await;
+        }
+
+        release.send(()).unwrap();
+        running.await.unwrap();
+        for job in queued {
+            job.await.unwrap();
+        }
+
+        assert_eq!(
+            *order.lock().unwrap(),
+            [Priority::Exam, Priority::Normal, Priority::Batch]
+        );
+    }
+
+    #[tokio::test]
+    async fn reserves_workers_for_exams() {
+        let pool = WorkerPool::new(2, 2);
+        pool.reserve(1);
+        let (release, released) = std::sync::mpsc::channel::<()>();
+        let batch = pool.admit().expect("the pool is empty");
+        let batch = tokio::spawn(
+            batch
+                .with_priority(Priority::Batch)
+                .run(move || released.recv().unwrap()),
+        );
+        tokio::task::yield_now().await;
+        let available = pool.available();
+        let normal = tokio::spawn(pool.admit().expect("the queue has room").run(|| ()));
+        tokio::task::yield_now().await;
+        let exam = pool
+            .admit()
+            .expect("the queue has room")
+            .with_priority(Priority::Exam)
+            .run(|| ())
+            .await;
+        let waiting = !normal.is_finished();
+
+        release.send(()).unwrap();
+        batch.await.unwrap();
+
+        assert_eq!(available, Some(Priority::Exam));
+        assert_eq!(exam, Some(()));
+        assert!(waiting);
+        assert_eq!(normal.await.unwrap(), Some(()));
+        assert_eq!(pool.available(), Some(Priority::Batch));
+    }
+
+    #[test]
+    fn queue_depth() {
+        let pool = WorkerPool::new(2, 4);
+
+        let _first = pool.admit();
+        let _second = pool.admit();
+        let _third = pool.admit();
+
+        assert_eq!(pool.queue_depth(), 1);
+    }
+
+    #[test]
+    fn saturated() {
+        let pool = WorkerPool::new(1, 1);
+
+        let _running = pool.admit();
+        let not_saturated = pool.is_saturated();
+        let _queued = pool.admit();
+
+        assert!(!not_saturated);
+        assert!(pool.is_saturated());
+    }
+
+    #[tokio::test]
+    async fn resizes() {
+        let pool = WorkerPool::new(2, 1);
+        let first = pool.admit().expect("the pool is empty");
+        let second = pool.admit().expect("the pool is empty");
+
+        pool.resize(1, 0);
+        let shrunk = pool.admit();
+        let first = tokio::spawn(first.run(|| ()));
+        let second = tokio::spawn(second.run(|| ()));
+        let ran = (first.await.unwrap(), second.await.unwrap());
+        tokio::task::yield_now().await;
+        let after = pool.admit().map(|_| ());
+        pool.resize(3, 2);
+        let _grown: Vec<_> = (0..5)
+            .map(|_| pool.admit().expect("the pool has grown"))
+            .collect();
+
+        assert!(matches!(shrunk, Err(Rejection::Full)));
+        assert_eq!(ran, (Some(()), Some(())));
+        assert!(after.is_ok());
+        assert_eq!((pool.worker_count(), pool.queue_size()), (3, 2));
+        assert!(pool.is_saturated());
+    }
+}
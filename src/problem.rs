@@ -0,0 +1,151 @@
+use axum::{
+    async_trait,
+    extract::{rejection::JsonRejection, FromRequest, Request},
+    http::{header, StatusCode},
+    response::{IntoResponse, Response},
+    Json,
+};
+use serde::Serialize;
+use std::borrow::Cow;
+
+/// The media type of the body of every error response.
+pub const PROBLEM_JSON: &str = "application/problem+json";
+
+/// The details of a problem with a request as of RFC 7807, which is the body of every error response.
+///
+/// Problems have no type of their own, so they are identified by their machine readable `code` instead, which clients
+/// can map to their own messages.
+#[derive(Serialize, Debug)]
+pub struct Problem {
+    #[serde(rename = "type")]
+    kind: &'static str,
+    /// The reason phrase of the status code.
+    title: &'static str,
+    status: u16,
+    code: Cow<'static, str>,
+    /// A human readable description of this occurrence of the problem.
+    detail: Cow<'static, str>,
+}
+
+impl Problem {
+    pub fn new(
+        status: StatusCode,
+        code: impl Into<Cow<'static, str>>,
+        detail: impl Into<Cow<'static, str>>,
+    ) -> Self {
+        Self {
+            kind: "about:blank",
+            title: status.canonical_reason().unwrap_or_default(),
+            status: status.as_u16(),
+            code: code.into(),
+            detail: detail.into(),
+        }
+    }
+
+    pub fn status(&self) -> StatusCode {
+        StatusCode::from_u16(self.status).unwrap_or(StatusCode::INTERNAL_SERVER_ERROR)
+    }
+}
+
+impl IntoResponse for Problem {
+    fn into_response(self) -> Response {
+        (
+            self.status(),
+            [(header::CONTENT_TYPE, PROBLEM_JSON)],
+            Json(self),
+        )
+            .into_response()
+    }
+}
+
+impl From<JsonRejection> for Problem {
+    fn from(rejection: JsonRejection) -> Self {
+        let code = match rejection {
+            JsonRejection::JsonDataError(_) => "invalidPayload",
+            JsonRejection::JsonSyntaxError(_) => "malformedJson",
+            JsonRejection::MissingJsonContentType(_) => "unsupportedMediaType",
+            // the body is only read up to the limit, which is the only reason it is too large
+            _ if rejection.status() == StatusCode::PAYLOAD_TOO_LARGE => {
+                return Problem::new(
+                    StatusCode::PAYLOAD_TOO_LARGE,
+                    "payloadTooLarge",
+                    "the request body is larger than the body size limit",
+                );
+            }
+            _ => "unreadableBody",
+        };
+
+        Problem::new(rejection.status(), code, rejection.body_text())
+    }
+}
+
+/// A JSON request body, which is rejected with a problem rather than the plain text of axum if it cannot be parsed.
+pub struct Payload<T>(pub T);
+
+#[async_trait]
+impl<T, S> FromRequest<S> for Payload<T>
+where
+    Json<T>: FromRequest<S, Rejection = JsonRejection>,
+    S: Send + Sync,
+{
+    type Rejection = Problem;
+
+    async fn from_request(request: Request, state: &S) -> Result<Self, Self::Rejection> {
+        let Json(payload) = Json::<T>::from_request(request, state).await?;
+
+        Ok(Payload(payload))
+    }
+}
+
+#[cfg(test)]
+mod response {
+    use super::{Payload, Problem, PROBLEM_JSON};
+    use axum::{
+        body::{to_bytes, Body},
+        extract::FromRequest,
+        http::{header, Request, StatusCode},
+        response::IntoResponse,
+    };
+    use serde_json::{json, Value};
+
+    #[tokio::test]
+    async fn problem_json() {
+        let problem = Problem::new(
+            StatusCode::UNPROCESSABLE_ENTITY,
+            "noTestCases",
+            "the submission contains no test cases",
+        );
+
+        let actual = problem.into_response();
+        let content_type = actual.headers()[header::CONTENT_TYPE].clone();
+        let body = to_bytes(actual.into_body(), usize::MAX).await.unwrap();
+
+        assert_eq!(content_type, PROBLEM_JSON);
+        assert_eq!(
+            serde_json::from_slice::<Value>(&body).unwrap(),
+            json!({
+                "type": "about:blank",
+                "title": "Unprocessable Entity",
+                "status": 422,
+                "code": "noTestCases",
+                "detail": "the submission contains no test cases",
+            })
+        );
+    }
+
+    #[tokio::test]
+    async fn malformed_payload() {
+        let request = Request::builder()
+            .header(header::CONTENT_TYPE, "application/json")
+            .body(Body::from("{"))
+            .unwrap();
+
+        let actual = Payload::<Value>::from_request(request, &()).await;
+
+        let Err(problem) = actual else {
+            panic!("a malformed payload is rejected")
+        };
+        assert_eq!(problem.status(), StatusCode::BAD_REQUEST);
+        assert_eq!(problem.code, "malformedJson");
+    }
+}
@@ -0,0 +1,299 @@
+use crate::{auth::Tokens, config::Config, metrics::METRICS, problem::Problem};
+use axum::{
+    extract::{ConnectInfo, Request, State},
+    http::{header, StatusCode},
+    middleware::Next,
+    response::{IntoResponse, Response},
+};
+use std::{
+    collections::HashMap,
+    net::SocketAddr,
+    sync::{Arc, Mutex, RwLock},
+    time::{Duration, Instant},
+};
+use tracing::warn;
+
+/// The number of clients above which the buckets of idle clients are dropped, to bound the memory of the limiter.
+const PRUNE_THRESHOLD: usize = 1024;
+
+/// The most clients the limiter keeps a bucket for, beyond which the bucket of the client which submitted least
+/// recently is dropped, so clients making up new addresses cannot grow the limiter without bound.
+const CLIENT_LIMIT: usize = 16 * 1024;
+
+/// A token bucket rate limiter, with a bucket for each client.
+///
+/// Every bucket holds up to `burst` tokens, and is refilled at the rate limit. Every submission takes a token, and is
+/// rejected if its client has none left.
+pub struct RateLimiter {
+    limits: RwLock<Limits>,
+    buckets: Mutex<HashMap<String, Bucket>>,
+}
+
+#[derive(Clone, Copy)]
+struct Limits {
+    /// The number of tokens added to a bucket per second, where zero disables the limiter.
+    rate: f64,
+    burst: f64,
+}
+
+impl Limits {
+    fn new(per_minute: u64, burst: u64) -> Self {
+        Self {
+            rate: per_minute as f64 / 60.0,
+            burst: burst as f64,
+        }
+    }
+
+    /// Gets the tokens the bucket holds at the given time.
+    fn refilled(self, bucket: &Bucket, now: Instant) -> f64 {
+        let elapsed = now.saturating_duration_since(bucket.updated).as_secs_f64();
+
+        (bucket.tokens + elapsed * self.rate).min(self.burst)
+    }
+}
+
+struct Bucket {
+    tokens: f64,
+    updated: Instant,
+}
+
+/// A submission which was rejected as its client exceeded the rate limit.
+#[derive(Debug, PartialEq)]
+pub struct RateLimited {
+    /// How long until the client has a token again.
+    pub retry_after: Duration,
+}
+
+impl RateLimiter {
+    /// Creates a limiter allowing `per_minute` submissions per minute and client, after a burst of `burst`.
+    pub fn new(per_minute: u64, burst: u64) -> Self {
+        Self {
+            limits: RwLock::new(Limits::new(per_minute, burst)),
+            buckets: Mutex::default(),
+        }
+    }
+
+    /// Creates the limiter configured by the rate limit settings.
+    pub fn from_config(config: &Config) -> Self {
+        Self::new(config.rate_limit, config.rate_limit_burst)
+    }
+
+    /// Applies the rate limit settings of a reloaded config, where the buckets of clients keep their tokens up to the
+    /// new burst.
+    pub fn reconfigure(&self, config: &Config) {
+        *self
+            .limits
+            .write()
+            .expect("limiter lock should not be poisoned") =
+            Limits::new(config.rate_limit, config.rate_limit_burst);
+    }
+
+    /// Takes a token from the bucket of the client, unless it has none left.
+    pub fn acquire(&self, client: &str, now: Instant) -> Result<(), RateLimited> {
+        let limits = *self
+            .limits
+            .read()
+            .expect("limiter lock should not be poisoned");
+        if limits.rate == 0.0 {
+            return Ok(());
+        }
+
+        let mut buckets = self
+            .buckets
+            .lock()
+            .expect("limiter lock should not be poisoned");
+
+        if !buckets.contains_key(client) && buckets.len() >= PRUNE_THRESHOLD {
+            // a bucket which would be full again is the same as no bucket
+            buckets.retain(|_, bucket| limits.refilled(bucket, now) < limits.burst);
+        }
+        if !buckets.contains_key(client) && buckets.len() >= CLIENT_LIMIT {
+            let oldest = buckets
+                .iter()
+                .min_by_key(|(_, bucket)| bucket.updated)
+                .map(|(client, _)| client.clone());
+            if let Some(oldest) = oldest {
+                buckets.remove(&oldest);
+            }
+        }
+        let bucket = buckets.entry(client.to_string()).or_insert(Bucket {
+            tokens: limits.burst,
+            updated: now,
+        });
+        bucket.tokens = limits.refilled(bucket, now);
+        bucket.updated = now;
+
+        let result = if bucket.tokens >= 1.0 {
+            bucket.tokens -= 1.0;
+            Ok(())
+        } else {
+            Err(RateLimited {
+                retry_after: Duration::from_secs_f64((1.0 - bucket.tokens) / limits.rate),
+            })
+        };
+        METRICS.rate_limited_clients(buckets.len());
+
+        result
+    }
+}
+
+/// Gets the client of a request, which is its bearer token if it has one of the tokens, and its peer address otherwise.
+fn client(tokens: &Tokens, request: &Request) -> String {
+    let authorization = request
+        .headers()
+        .get(header::AUTHORIZATION)
+        .and_then(|value| value.to_str().ok());
+    let peer = request
+        .extensions()
+        .get::<ConnectInfo<SocketAddr>>()
+        .map(|ConnectInfo(address)| *address);
+
+    client_of(tokens, authorization, peer)
+}
+
+/// Gets the client with the value of an `Authorization` header and peer address, where either may be missing.
+///
+/// The token identifies clients more precisely than the address, as many clients may share an address behind a proxy.
+/// It is only trusted if it is one of the tokens, as a client could get a fresh bucket for every submission by making
+/// up a new token otherwise, which is always the case if authentication is disabled.
+pub fn client_of(tokens: &Tokens, authorization: Option<&str>, peer: Option<SocketAddr>) -> String {
+    let token = authorization
+        .filter(|_| tokens.trusts(authorization))
+        .and_then(|value| value.split_once(' '))
+        .filter(|(scheme, _)| scheme.eq_ignore_ascii_case("bearer"))
+        .map(|(_, token)| token.trim());
+
+    match (token, peer) {
+        (Some(token), _) => format!("token:{token}"),
+        (None, Some(address)) => format!("ip:{}", address.ip()),
+        (None, None) => "unknown".to_string(),
+    }
+}
+
+/// Rejects submissions of clients which exceeded the rate limit.
+pub async fn limit_rate(
+    State((limiter, tokens)): State<(Arc<RateLimiter>, Arc<Tokens>)>,
+    request: Request,
+    next: Next,
+) -> Result<Response, RateLimited> {
+    if let Err(limited) = limiter.acquire(&client(&tokens, &request), Instant::now()) {
+        METRICS.rate_limited();
+        warn!(
+            retry_after_ms = limited.retry_after.as_millis() as u64,
+            "rejected rate limited submission"
+        );
+        return Err(limited);
+    }
+
+    Ok(next.run(request).await)
+}
+
+impl IntoResponse for RateLimited {
+    fn into_response(self) -> Response {
+        // clients retrying before the bucket has a whole token would be rejected again
+        let retry_after = self.retry_after.as_secs_f64().ceil().max(1.0) as u64;
+
+        (
+            [(header::RETRY_AFTER, retry_after.to_string())],
+            Problem::new(
+                StatusCode::TOO_MANY_REQUESTS,
+                "rateLimited",
+                "too many submissions, retry later",
+            ),
+        )
+            .into_response()
+    }
+}
+
+#[cfg(test)]
+mod acquire {
+    use super::{client_of, RateLimiter, CLIENT_LIMIT};
+    use crate::{auth::Tokens, config::Config};
+    use std::time::{Duration, Instant};
+
+    #[test]
+    fn disabled() {
+        let limiter = RateLimiter::new(0, 0);
+        let now = Instant::now();
+
+        assert!((0..100).all(|_| limiter.acquire("client", now).is_ok()));
+    }
+
+    #[test]
+    fn burst() {
+        let limiter = RateLimiter::new(60, 3);
+        let now = Instant::now();
+
+        for _ in 0..3 {
+            assert!(limiter.acquire("client", now).is_ok());
+        }
+        let actual = limiter.acquire("client", now);
+
+        let retry_after = actual.expect_err("the burst should be used up").retry_after;
+        assert_eq!(retry_after, Duration::from_secs(1));
+    }
+
+    #[test]
+    fn refills() {
+        let limiter = RateLimiter::new(60, 1);
+        let now = Instant::now();
+
+        let first = limiter.acquire("client", now);
+        let early = limiter.acquire("client", now + Duration::from_millis(500));
+        let later = limiter.acquire("client", now + Duration::from_millis(1500));
+
+        assert!(first.is_ok());
+        assert!(early.is_err());
+        assert!(later.is_ok());
+    }
+
+    #[test]
+    fn separate_clients() {
+        let limiter = RateLimiter::new(60, 1);
+        let now = Instant::now();
+
+        let first = limiter.acquire("first", now);
+        let second = limiter.acquire("second", now);
+
+        assert!(first.is_ok());
+        assert!(second.is_ok());
+    }
+
+    #[test]
+    fn only_trusts_known_tokens() {
+        let tokens = Tokens::new(&Config {
+            tokens: vec![String::from("secret")],
+            ..Config::default()
+        });
+        let unauthenticated = Tokens::new(&Config::default());
+        let peer = Some("10.0.0.1:4000".parse().unwrap());
+
+        let known = client_of(&tokens, Some("Bearer secret"), peer);
+        let made_up = client_of(&tokens, Some("Bearer guessed"), peer);
+        let disabled = client_of(&unauthenticated, Some("Bearer anything"), peer);
+
+        assert_eq!(known, "token:secret");
+        assert_eq!(made_up, "ip:10.0.0.1");
+        assert_eq!(disabled, "ip:10.0.0.1");
+    }
+
+    #[test]
+    fn caps_the_clients() {
+        let limiter = RateLimiter::new(60, 1);
+        let now = Instant::now();
+
+        for client in 0..CLIENT_LIMIT {
+            let _ = limiter.acquire(
+                &client.to_string(),
+                now + Duration::from_nanos(client as u64),
+            );
+        }
+        let newest = limiter.acquire("newest", now + Duration::from_secs(1));
+        let clients = limiter.buckets.lock().unwrap();
+
+        assert!(newest.is_ok());
+        assert_eq!(clients.len(), CLIENT_LIMIT);
+        assert!(!clients.contains_key("0"));
+        assert!(clients.contains_key("1"));
+    }
+}
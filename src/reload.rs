@@ -0,0 +1,89 @@
+use crate::{auth::Tokens, config::Config, AppState};
+use std::sync::Arc;
+use tokio::signal::unix::{signal, SignalKind};
+use tracing::{error, info, warn};
+
+/// Loads the config again whenever mozart receives SIGHUP, in the background, applying the settings which can be
+/// changed without a restart.
+///
+/// A config which fails to load or is invalid is ignored, so mozart keeps running with the config it had before.
+pub fn start(state: AppState) {
+    let mut hangup = signal(SignalKind::hangup()).expect("failed to listen for SIGHUP");
+
+    tokio::spawn(async move {
+        while hangup.recv().await.is_some() {
+            let reloaded = match tokio::task::spawn_blocking(Config::load).await {
+                Ok(Ok(reloaded)) => reloaded,
+                Ok(Err(err)) => {
+                    error!(%err, "failed to reload the config, keeping the current one");
+                    continue;
+                }
+                Err(_) => continue,
+            };
+
+            let restart = apply(&state, reloaded);
+            if !restart.is_empty() {
+                warn!(
+                    settings = restart.join(", "),
+                    "some settings only change once mozart is restarted"
+                );
+            }
+            info!("reloaded the config");
+        }
+    });
+}
+
+/// Applies the reloadable settings of a reloaded config, e.g. the limits, the rate limits, the number of workers and
+/// those reserved for exams, the settings of languages, and the tokens, returning the names of the settings which changed but need a restart.
+///
+/// Jobs which were accepted before are still checked with the config they were accepted with, and the workers taken
+/// away by a smaller pool are only removed once their jobs are done.
+pub fn apply(state: &AppState, reloaded: Config) -> Vec<String> {
+    let (config, restart) = state.tenants.named(None).config.reloaded(reloaded);
+
+    state.tokens.reconfigure(Tokens::new(&config));
+    state.admin_tokens.reconfigure(Tokens::admin(&config));
+    state.reveal_tokens.reconfigure(Tokens::reveal(&config));
+    state.exam_tokens.reconfigure(Tokens::exam(&config));
+    state.limiter.reconfigure(&config);
+    state.pool.resize(config.workers(), config.queue_size);
+    state.pool.reserve(config.exam_workers);
+    state.tenants.reconfigure(Arc::new(config));
+
+    restart
+}
+
+#[cfg(test)]
+mod reloading {
+    use super::apply;
+    use crate::{config::Config, AppState};
+    use std::net::SocketAddr;
+
+    #[tokio::test]
+    async fn applies_tunables() {
+        let state = AppState::new(Config {
+            tokens: vec![String::from("old")],
+            ..Config::default()
+        });
+
+        let restart = apply(
+            &state,
+            Config {
+                tokens: vec![String::from("new")],
+                time_limit: 1000,
+                workers: Some(3),
+                queue_size: 7,
+                listen: SocketAddr::from(([127, 0, 0, 1], 9090)),
+                ..Config::default()
+            },
+        );
+        let config = state.tenants.named(None).config;
+
+        assert_eq!(restart, ["listen"]);
+        assert_eq!(config.time_limit, 1000);
+        assert_eq!(config.listen, Config::default().listen);
+        assert!(state.tokens.authenticate(Some("Bearer new")).is_ok());
+        assert!(state.tokens.authenticate(Some("Bearer old")).is_err());
+        assert_eq!((state.pool.worker_count(), state.pool.queue_size()), (3, 7));
+    }
+}
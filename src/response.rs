@@ -1,29 +1,488 @@
-use crate::model::TestCaseResult;
+use crate::{
+    batch::BatchReport,
+    error::{GenerateError, SubmissionError},
+    job::{JobRecord, JobStatus},
+    model::{SubmissionResult, TestOutput, Timings, Transcript, Verdict},
+    pool::Rejection,
+    problem::Problem,
+};
 use axum::{
-    body::Body,
-    http::StatusCode,
+    http::{header, StatusCode},
     response::{IntoResponse, Response},
     Json,
 };
+use serde::{Deserialize, Serialize};
+use uuid::Uuid;
+
+/// Why a submission is invalid, which is responded to as a problem.
+#[derive(Serialize, Deserialize, Clone, Debug, PartialEq)]
+#[serde(from = "StoredInvalid")]
+pub struct Invalid {
+    /// The machine readable code of the problem, e.g. `noTestCases`.
+    pub code: String,
+    pub detail: String,
+}
+
+impl Invalid {
+    pub fn new(code: &str, detail: impl Into<String>) -> Self {
+        Self {
+            code: code.to_string(),
+            detail: detail.into(),
+        }
+    }
+}
+
+impl From<SubmissionError> for Invalid {
+    fn from(err: SubmissionError) -> Self {
+        Self::new(err.code(), err.to_string())
+    }
+}
+
+impl From<GenerateError> for Invalid {
+    fn from(err: GenerateError) -> Self {
+        Self::new(err.code(), err.to_string())
+    }
+}
 
+/// An invalid submission as it is persisted, where jobs persisted before problems had codes only have a detail.
+#[derive(Deserialize)]
+#[serde(untagged)]
+enum StoredInvalid {
+    Detail(String),
+    Invalid { code: String, detail: String },
+}
+
+impl From<StoredInvalid> for Invalid {
+    fn from(stored: StoredInvalid) -> Self {
+        match stored {
+            StoredInvalid::Detail(detail) => Invalid::new("invalidSubmission", detail),
+            StoredInvalid::Invalid { code, detail } => Invalid { code, detail },
+        }
+    }
+}
+
+/// A quota of a tenant, which a submission or upload of the tenant would exceed.
+#[derive(Serialize, Deserialize, Clone, Copy, Debug, PartialEq)]
+#[serde(rename_all = "camelCase")]
+pub enum Quota {
+    /// The tenant already has as many submissions queued or being checked as it may have at once.
+    Jobs,
+    /// The test cases of the tenant already took as much CPU time today as they may take per day.
+    CpuTime,
+    /// The fixtures and exercises of the tenant would take up more space than they may take up.
+    Storage,
+}
+
+/// The response to a submission, which is persisted along with its job.
+#[derive(Serialize, Deserialize, Clone)]
+#[serde(tag = "kind", content = "body", rename_all = "camelCase")]
 pub enum SubmitResponse {
-    Success,
-    Failure(Box<[TestCaseResult]>),
-    CompilationError(String),
+    /// The submission was checked, which includes solutions that failed to compile.
+    Checked(SubmissionResult),
+    InvalidSubmission(Invalid),
+    /// The queue of the worker pool is full.
+    Busy,
+    /// Mozart is shutting down, so the submission was not checked.
+    Unavailable,
+    /// Mozart is paused for maintenance, so the submission was not accepted.
+    Paused,
+    /// The disk of mozart is almost full, so the submission was not accepted.
+    LowDiskSpace,
+    /// The job was cancelled on request before the submission was checked.
+    Cancelled,
+    /// The judgment took longer than the judgment timeout, so it was cancelled.
+    TimedOut,
+    /// The tenant of the submission used up one of its quotas, so the submission was not accepted.
+    QuotaExceeded(Quota),
     Internal,
 }
 
+impl From<Rejection> for SubmitResponse {
+    fn from(rejection: Rejection) -> Self {
+        match rejection {
+            Rejection::Full => SubmitResponse::Busy,
+            Rejection::ShuttingDown => SubmitResponse::Unavailable,
+            Rejection::Paused => SubmitResponse::Paused,
+            Rejection::LowDiskSpace => SubmitResponse::LowDiskSpace,
+        }
+    }
+}
+
+impl SubmitResponse {
+    /// Leaves out the details of the hidden test cases, if the submission was checked.
+    pub fn redact(&mut self) {
+        if let SubmitResponse::Checked(result) = self {
+            result.redact();
+        }
+    }
+
+    /// Leaves out the outputs of the test cases which are larger than the threshold in bytes, linking to them as
+    /// attachments of the job instead, if the submission was checked.
+    pub fn attach(&mut self, task: Uuid, threshold: usize) {
+        if let SubmitResponse::Checked(result) = self {
+            result.attach(task, threshold);
+        }
+    }
+
+    /// Leaves out the outputs of every test case, if the submission was checked.
+    pub fn compact(&mut self) {
+        if let SubmitResponse::Checked(result) = self {
+            result.compact();
+        }
+    }
+
+    /// Gets how long the stages of the judgment took, if the submission was checked and they were measured.
+    pub fn timings(&mut self) -> Option<&mut Timings> {
+        match self {
+            SubmitResponse::Checked(result) => result.timings.as_deref_mut(),
+            _ => None,
+        }
+    }
+
+    /// Gets the result of a checked submission, or the problem responded with otherwise.
+    pub fn into_result(self) -> Result<SubmissionResult, Problem> {
+        match self {
+            SubmitResponse::Checked(result) => Ok(result),
+            SubmitResponse::InvalidSubmission(invalid) => Err(Problem::new(
+                StatusCode::UNPROCESSABLE_ENTITY,
+                invalid.code,
+                invalid.detail,
+            )),
+            SubmitResponse::Busy => Err(Problem::new(
+                StatusCode::TOO_MANY_REQUESTS,
+                "queueFull",
+                "the queue is full, retry later",
+            )),
+            SubmitResponse::Unavailable => Err(Problem::new(
+                StatusCode::SERVICE_UNAVAILABLE,
+                "unavailable",
+                "mozart is shutting down",
+            )),
+            SubmitResponse::Paused => Err(Problem::new(
+                StatusCode::SERVICE_UNAVAILABLE,
+                "paused",
+                "mozart is paused for maintenance, retry later",
+            )),
+            SubmitResponse::LowDiskSpace => Err(Problem::new(
+                StatusCode::SERVICE_UNAVAILABLE,
+                "lowDiskSpace",
+                "mozart is running low on disk space, retry later",
+            )),
+            SubmitResponse::Cancelled => Err(Problem::new(
+                StatusCode::GONE,
+                "cancelled",
+                "the job was cancelled",
+            )),
+            SubmitResponse::TimedOut => Err(Problem::new(
+                StatusCode::GATEWAY_TIMEOUT,
+                "judgmentTimeout",
+                "judging the submission took longer than the judgment timeout",
+            )),
+            SubmitResponse::QuotaExceeded(Quota::Jobs) => Err(Problem::new(
+                StatusCode::TOO_MANY_REQUESTS,
+                "jobQuotaExceeded",
+                "the tenant has as many submissions queued or being checked as it may have, retry later",
+            )),
+            SubmitResponse::QuotaExceeded(Quota::CpuTime) => Err(Problem::new(
+                StatusCode::TOO_MANY_REQUESTS,
+                "cpuQuotaExceeded",
+                "the tenant used up its CPU time for today, retry tomorrow",
+            )),
+            SubmitResponse::QuotaExceeded(Quota::Storage) => Err(Problem::new(
+                StatusCode::INSUFFICIENT_STORAGE,
+                "storageQuotaExceeded",
+                "the fixtures and exercises of the tenant would exceed its storage quota",
+            )),
+            SubmitResponse::Internal => Err(Problem::new(
+                StatusCode::INTERNAL_SERVER_ERROR,
+                "internal",
+                "an internal error occured",
+            )),
+        }
+    }
+}
+
 impl IntoResponse for SubmitResponse {
+    fn into_response(self) -> Response {
+        match self.into_result() {
+            Ok(result) => {
+                let status_code = match result.verdict {
+                    Verdict::CompilationError => StatusCode::BAD_REQUEST,
+                    _ => StatusCode::OK,
+                };
+
+                (status_code, Json(result)).into_response()
+            }
+            Err(problem) => problem.into_response(),
+        }
+    }
+}
+
+/// The response to a submission as it is delivered outside of HTTP, tagged like a persisted job, along with the id of
+/// its job.
+#[derive(Serialize)]
+pub struct JobResponse<'a> {
+    /// The id of the job, which can also be polled over HTTP, if the submission was accepted at all.
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub id: Option<Uuid>,
+    #[serde(flatten)]
+    pub response: &'a SubmitResponse,
+}
+
+#[derive(Serialize)]
+struct TaskId {
+    id: Uuid,
+    #[serde(rename = "queuePosition")]
+    queue_position: usize,
+}
+
+#[derive(Serialize)]
+struct TaskBatch {
+    tasks: Vec<TaskId>,
+    skipped: Vec<Uuid>,
+}
+
+#[derive(Serialize)]
+struct BatchTasks {
+    id: Uuid,
+    tasks: Vec<TaskId>,
+}
+
+#[derive(Serialize)]
+struct TaskStatus {
+    status: JobStatus,
+}
+
+pub enum TaskResponse {
+    /// The submission was accepted and will be checked in the background, after the given number of queued jobs.
+    Accepted(Uuid, usize),
+
+    /// Everything known about a job.
+    Record(Box<JobRecord>),
+
+    /// The current status of a job.
+    Status(JobStatus),
+
+    /// The final result of a job.
+    Result(SubmitResponse),
+
+    /// The job exists, but a result is not available yet.
+    Pending,
+
+    /// The job exists, but cannot be rejudged as it is not done yet.
+    NotDone,
+
+    /// The judgment of the job is being cancelled.
+    Cancelling,
+
+    /// The job exists, but cannot be cancelled as it is already done.
+    Done,
+
+    /// The jobs which were accepted for rejudging with their queue positions, and the jobs which were skipped.
+    Batch(Vec<(Uuid, usize)>, Vec<Uuid>),
+
+    /// The submissions of a batch were accepted, with the ids of their jobs and their queue positions in the order
+    /// they were submitted.
+    AcceptedBatch(Uuid, Vec<(Uuid, usize)>),
+
+    /// The status of every job of a batch.
+    BatchReport(BatchReport),
+
+    /// The tar archive of the compiled artifacts of a job.
+    Artifact(Uuid, Vec<u8>),
+
+    /// The zip archive of everything a job was judged from, and what it resulted in.
+    Bundle(Uuid, Vec<u8>),
+
+    /// The transcripts of the interactive test cases of a job.
+    Transcript(Transcript),
+
+    /// An output of a test case of a job, by the id of the test case.
+    Output(Uuid, u64, TestOutput, String),
+
+    /// The job is done, but the test case does not exist, or has no such output.
+    NoOutput,
+
+    /// The test case is hidden, so its outputs are not shown to the caller.
+    OutputHidden,
+
+    /// The job is done, but has no compiled artifacts, as they were not exported or the solution did not compile.
+    NoArtifact,
+
+    /// The compiled artifacts of the job contain its hidden test cases, which the caller is not shown.
+    ArtifactHidden,
+
+    /// The job is done, but has no transcripts, as they were not recorded or no test case was run.
+    NoTranscript,
+
+    /// No job exists with the requested id.
+    NotFound,
+}
+
+impl IntoResponse for TaskResponse {
     fn into_response(self) -> Response {
         match self {
-            SubmitResponse::Success => StatusCode::OK.into_response(),
-            SubmitResponse::Failure(test_case_results) => {
-                (StatusCode::OK, Json(test_case_results)).into_response()
+            TaskResponse::Accepted(id, queue_position) => {
+                (StatusCode::ACCEPTED, Json(TaskId { id, queue_position })).into_response()
+            }
+            TaskResponse::Record(record) => (StatusCode::OK, Json(record)).into_response(),
+            TaskResponse::Status(status) => {
+                (StatusCode::OK, Json(TaskStatus { status })).into_response()
+            }
+            TaskResponse::Result(result) => result.into_response(),
+            TaskResponse::Pending => StatusCode::ACCEPTED.into_response(),
+            TaskResponse::NotDone => {
+                Problem::new(StatusCode::CONFLICT, "notDone", "the job is not done yet")
+                    .into_response()
+            }
+            TaskResponse::Cancelling => StatusCode::ACCEPTED.into_response(),
+            TaskResponse::Done => {
+                Problem::new(StatusCode::CONFLICT, "done", "the job is already done")
+                    .into_response()
+            }
+            TaskResponse::Batch(accepted, skipped) => {
+                let tasks = accepted
+                    .into_iter()
+                    .map(|(id, queue_position)| TaskId { id, queue_position })
+                    .collect();
+
+                (StatusCode::ACCEPTED, Json(TaskBatch { tasks, skipped })).into_response()
+            }
+            TaskResponse::AcceptedBatch(id, accepted) => {
+                let tasks = accepted
+                    .into_iter()
+                    .map(|(id, queue_position)| TaskId { id, queue_position })
+                    .collect();
+
+                (StatusCode::ACCEPTED, Json(BatchTasks { id, tasks })).into_response()
             }
-            SubmitResponse::CompilationError(reason) => {
-                (StatusCode::BAD_REQUEST, Body::from(reason)).into_response()
+            TaskResponse::BatchReport(report) => (StatusCode::OK, Json(report)).into_response(),
+            TaskResponse::Artifact(id, archive) => (
+                StatusCode::OK,
+                [
+                    (header::CONTENT_TYPE, String::from("application/x-tar")),
+                    (
+                        header::CONTENT_DISPOSITION,
+                        format!("attachment; filename=\"{id}.tar\""),
+                    ),
+                ],
+                archive,
+            )
+                .into_response(),
+            TaskResponse::Bundle(id, bundle) => (
+                StatusCode::OK,
+                [
+                    (header::CONTENT_TYPE, String::from("application/zip")),
+                    (
+                        header::CONTENT_DISPOSITION,
+                        format!("attachment; filename=\"{id}.zip\""),
+                    ),
+                ],
+                bundle,
+            )
+                .into_response(),
+            TaskResponse::Transcript(transcript) => (StatusCode::OK, Json(transcript)).into_response(),
+            TaskResponse::Output(id, test_case, output, contents) => (
+                StatusCode::OK,
+                [
+                    (header::CONTENT_TYPE, String::from("text/plain; charset=utf-8")),
+                    (
+                        header::CONTENT_DISPOSITION,
+                        format!(
+                            "attachment; filename=\"{id}-{test_case}-{}.txt\"",
+                            output.as_str()
+                        ),
+                    ),
+                ],
+                contents,
+            )
+                .into_response(),
+            TaskResponse::NoOutput => Problem::new(
+                StatusCode::NOT_FOUND,
+                "noOutput",
+                "the test case does not exist, or does not have the output",
+            )
+            .into_response(),
+            TaskResponse::OutputHidden => Problem::new(
+                StatusCode::FORBIDDEN,
+                "outputHidden",
+                "the test case is hidden, so its outputs are only revealed to trusted callers",
+            )
+            .into_response(),
+            TaskResponse::NoArtifact => Problem::new(
+                StatusCode::NOT_FOUND,
+                "noArtifact",
+                "the job has no compiled artifacts",
+            )
+            .into_response(),
+            TaskResponse::ArtifactHidden => Problem::new(
+                StatusCode::FORBIDDEN,
+                "artifactHidden",
+                "the compiled artifacts contain hidden test cases, which are only revealed to trusted callers",
+            )
+            .into_response(),
+            TaskResponse::NoTranscript => Problem::new(
+                StatusCode::NOT_FOUND,
+                "noTranscript",
+                "the job has no transcripts",
+            )
+            .into_response(),
+            TaskResponse::NotFound => {
+                Problem::new(StatusCode::NOT_FOUND, "notFound", "the job does not exist")
+                    .into_response()
             }
-            SubmitResponse::Internal => StatusCode::INTERNAL_SERVER_ERROR.into_response(),
         }
     }
 }
+
+#[cfg(test)]
+mod job_response {
+    use super::{Invalid, JobResponse, SubmitResponse};
+    use serde_json::json;
+    use uuid::Uuid;
+
+    #[test]
+    fn tagged_like_job_result() {
+        let id = Uuid::new_v4();
+        let response = SubmitResponse::InvalidSubmission(Invalid::new("noTestCases", "invalid"));
+
+        let actual = serde_json::to_value(JobResponse {
+            id: Some(id),
+            response: &response,
+        })
+        .unwrap();
+
+        assert_eq!(
+            actual,
+            json!({
+                "id": id,
+                "kind": "invalidSubmission",
+                "body": { "code": "noTestCases", "detail": "invalid" },
+            })
+        );
+    }
+
+    #[test]
+    fn persisted_without_code() {
+        let actual: SubmitResponse =
+            serde_json::from_value(json!({ "kind": "invalidSubmission", "body": "invalid" }))
+                .unwrap();
+
+        assert!(matches!(
+            actual,
+            SubmitResponse::InvalidSubmission(invalid)
+                if invalid == Invalid::new("invalidSubmission", "invalid")
+        ));
+    }
+
+    #[test]
+    fn rejected_without_job() {
+        let actual = serde_json::to_value(JobResponse {
+            id: None,
+            response: &SubmitResponse::Internal,
+        })
+        .unwrap();
+
+        assert_eq!(actual, json!({ "kind": "internal" }));
+    }
+}
@@ -0,0 +1,148 @@
+use crate::{
+    job::JobStore,
+    model::{Submission, SubmissionResult},
+    response::SubmitResponse,
+};
+use sha2::{Digest, Sha256};
+use std::{
+    collections::{HashMap, VecDeque},
+    sync::Mutex,
+};
+use uuid::Uuid;
+
+/// How many attempts the cache remembers the jobs of, beyond which the attempts judged first are forgotten first.
+const CAPACITY: usize = 4096;
+
+/// The fields of a submission which do not affect its result, so two attempts which only differ by them share it.
+const IRRELEVANT: [&str; 3] = ["callbackUrl", "priority", "previousTaskId"];
+
+/// The jobs which judged attempts at exercises, by the solution and the version of the exercise they judged, so an
+/// attempt with the exact same solution as an earlier one is given the result of the earlier one, rather than being
+/// judged again.
+///
+/// Only the ids of the jobs are kept, as their results are kept by the job store, so the solution of a job which has
+/// been retired, or which was not checked, is judged again.
+#[derive(Default)]
+pub struct ResultCache {
+    entries: Mutex<Entries>,
+}
+
+#[derive(Default)]
+struct Entries {
+    jobs: HashMap<[u8; 32], Uuid>,
+    /// The keys of the jobs, in the order they were inserted.
+    order: VecDeque<[u8; 32]>,
+}
+
+impl ResultCache {
+    /// Gets the key of an attempt of the tenant, which is the digest of its submission, i.e. the exercise it belongs to
+    /// as it is now along with the solution, leaving out what does not affect its result.
+    ///
+    /// A submission which is nondeterministic has no key, as its result cannot be reused.
+    pub fn key(tenant: Option<&str>, submission: &Submission) -> Option<[u8; 32]> {
+        if submission.nondeterministic {
+            return None;
+        }
+        let mut submission = serde_json::to_value(submission).ok()?;
+        let fields = submission.as_object_mut()?;
+        for field in IRRELEVANT {
+            fields.remove(field);
+        }
+
+        let mut hasher = Sha256::new();
+        hasher.update(tenant.unwrap_or_default());
+        hasher.update([0]);
+        hasher.update(serde_json::to_vec(&submission).ok()?);
+        Some(hasher.finalize().into())
+    }
+
+    /// Gets the result of the job which judged an attempt with the key, marked as cached, if the job was checked.
+    ///
+    /// The result is a copy, so it is not compared with the earlier attempt of the job which judged it.
+    pub fn get(&self, key: &[u8; 32], jobs: &JobStore) -> Option<SubmissionResult> {
+        let id = *self
+            .entries
+            .lock()
+            .expect("result cache lock poisoned")
+            .jobs
+            .get(key)?;
+        let Some(SubmitResponse::Checked(result)) = jobs.record(id)?.result else {
+            return None;
+        };
+
+        Some(SubmissionResult {
+            cached: true,
+            revision: None,
+            // a cached result is not judged again, so none of the stages took any time
+            timings: Some(Box::default()),
+            ..result
+        })
+    }
+
+    /// Remembers the job which judges an attempt with the key, replacing the job of an earlier attempt.
+    pub fn insert(&self, key: [u8; 32], id: Uuid) {
+        let mut entries = self.entries.lock().expect("result cache lock poisoned");
+        if entries.jobs.insert(key, id).is_none() {
+            entries.order.push_back(key);
+        }
+        if entries.order.len() > CAPACITY {
+            if let Some(forgotten) = entries.order.pop_front() {
+                entries.jobs.remove(&forgotten);
+            }
+        }
+    }
+}
+
+#[cfg(test)]
+mod reuse {
+    use super::ResultCache;
+    use crate::{
+        job::JobStore,
+        model::{Submission, SubmissionResult},
+        response::SubmitResponse,
+    };
+    use serde_json::json;
+
+    fn submission(solution: &str) -> Submission {
+        serde_json::from_value(json!({
+            "solution": solution,
+            "testCases": [],
+            "exerciseId": "sum",
+            "callbackUrl": "https://lms.example/results",
+        }))
+        .unwrap()
+    }
+
+    #[test]
+    fn reuses_checked_results() {
+        let (cache, jobs) = (ResultCache::default(), JobStore::default());
+        let key = ResultCache::key(None, &submission("a")).unwrap();
+        let id = jobs.create(None, 0, &submission("a"));
+        cache.insert(key, id);
+
+        let queued = cache.get(&key, &jobs);
+        jobs.finish(
+            id,
+            SubmitResponse::Checked(SubmissionResult::checked(String::new(), Box::new([]))),
+        );
+        let checked = cache.get(&key, &jobs);
+
+        assert!(queued.is_none());
+        assert!(checked.is_some_and(|result| result.cached));
+    }
+
+    #[test]
+    fn keys() {
+        let mut resubmitted = submission("a");
+        resubmitted.callback_url = None;
+        let mut nondeterministic = submission("a");
+        nondeterministic.nondeterministic = true;
+
+        let key = ResultCache::key(None, &submission("a"));
+
+        assert_eq!(key, ResultCache::key(None, &resubmitted));
+        assert_ne!(key, ResultCache::key(None, &submission("b")));
+        assert_ne!(key, ResultCache::key(Some("algorithms"), &submission("a")));
+        assert_eq!(ResultCache::key(None, &nondeterministic), None);
+    }
+}
@@ -0,0 +1,48 @@
+use crate::{config::Config, job::JobStore};
+use std::{fs, time::Duration};
+use tracing::info;
+
+/// How often finished jobs are checked for whether their retention has passed.
+const INTERVAL: Duration = Duration::from_secs(60 * 60);
+
+/// Periodically retires the jobs whose retention has passed in the background, if results are not kept forever.
+///
+/// A retired job is archived if there is an archive, and removed from memory and the store along with its retained
+/// workspace and its compiled artifacts either way.
+pub fn start(config: &Config, jobs: JobStore) {
+    let Some(retention) = config.result_retention() else {
+        return;
+    };
+    let work_dir = config.work_dir.clone();
+    // the job of a tenant has its artifacts in the namespace of the tenant, so every namespace is checked
+    let artifact_configs: Vec<Config> = std::iter::once(config.clone())
+        .chain(config.tenants.keys().map(|name| config.tenant(name)))
+        .collect();
+
+    tokio::spawn(async move {
+        let mut interval = tokio::time::interval(INTERVAL);
+        loop {
+            interval.tick().await;
+            let jobs = jobs.clone();
+            let work_dir = work_dir.clone();
+            let artifact_configs = artifact_configs.clone();
+            let retired = tokio::task::spawn_blocking(move || {
+                let retired = jobs.retire(retention);
+                for id in &retired {
+                    // most workspaces are long gone, unless they are retained for longer than the results
+                    let _ = fs::remove_dir_all(work_dir.join(id.to_string()));
+                    for config in &artifact_configs {
+                        let _ = fs::remove_file(config.artifact_path(*id));
+                        let _ = fs::remove_file(config.transcript_path(*id));
+                    }
+                }
+                retired.len()
+            })
+            .await
+            .unwrap_or_default();
+            if retired > 0 {
+                info!(retired, "retired expired jobs");
+            }
+        }
+    });
+}
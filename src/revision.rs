@@ -0,0 +1,248 @@
+use crate::{
+    diff, files,
+    model::{SubmissionResult, TestCaseResult, TestResult, Verdict},
+};
+use serde::{Deserialize, Serialize};
+use serde_json::Value;
+use std::collections::{BTreeMap, BTreeSet};
+use uuid::Uuid;
+
+/// How a submission changed from the earlier attempt its `previousTaskId` names, in its sources and in its results, so
+/// a frontend can show e.g. that a change fixed test cases 3 and 5 but broke test case 7.
+#[derive(Serialize, Deserialize, Clone, PartialEq, Debug)]
+#[serde(rename_all = "camelCase")]
+pub struct Revision {
+    pub previous_task_id: Uuid,
+    /// Whether the solution itself changed.
+    pub solution_changed: bool,
+    /// The paths of the other files and blobs which changed, were added, or were removed.
+    pub files_changed: Vec<String>,
+    /// How many lines were added to the solution and the other files, where a changed line is added and removed.
+    pub lines_added: usize,
+    /// How many lines were removed from the solution and the other files.
+    pub lines_removed: usize,
+    pub previous_verdict: Verdict,
+    /// Whether the verdict is better than that of the earlier attempt, or, if both failed, whether more test cases
+    /// passed.
+    pub verdict_improved: bool,
+    /// The ids of the test cases which passed, but had not passed in the earlier attempt.
+    pub fixed: Vec<u64>,
+    /// The ids of the test cases which failed, but had passed in the earlier attempt.
+    pub broken: Vec<u64>,
+}
+
+impl Revision {
+    /// Compares a submission and its result, as the submission was sent, with an earlier attempt and its result.
+    pub fn new(
+        previous_task_id: Uuid,
+        (previous, previous_result): (&Value, &SubmissionResult),
+        (submission, result): (&Value, &SubmissionResult),
+    ) -> Self {
+        let (previous_solution, solution) =
+            (text(&previous["solution"]), text(&submission["solution"]));
+        let (mut lines_added, mut lines_removed) = diff::line_counts(previous_solution, solution);
+
+        let (previous_files, files) = (decoded(previous), decoded(submission));
+        let (previous_blobs, blobs) = (&previous["blobs"], &submission["blobs"]);
+        let paths: BTreeSet<&str> = previous_files
+            .keys()
+            .chain(files.keys())
+            .copied()
+            .chain(paths(previous_blobs))
+            .chain(paths(blobs))
+            .collect();
+        let mut files_changed = Vec::new();
+        for path in paths {
+            let (before, after) = (previous_files.get(path), files.get(path));
+            if before == after && previous_blobs[path] == blobs[path] {
+                continue;
+            }
+            // files which are not text are changed as a whole, so they count no lines
+            if let (Ok(before), Ok(after)) = (text_of(before), text_of(after)) {
+                let (added, removed) = diff::line_counts(before, after);
+                lines_added += added;
+                lines_removed += removed;
+            }
+            files_changed.push(path.to_string());
+        }
+
+        let passed = |results: &[TestCaseResult], id| {
+            results
+                .iter()
+                .any(|result| result.id == id && result.test_result == TestResult::Pass)
+        };
+        let fixed = result
+            .test_case_results
+            .iter()
+            .filter(|current| current.test_result == TestResult::Pass)
+            .filter(|current| !passed(&previous_result.test_case_results, current.id))
+            .map(|current| current.id)
+            .collect();
+        // a test case which was skipped this time did not fail, so it did not break either
+        let broken = result
+            .test_case_results
+            .iter()
+            .filter(|current| matches!(current.test_result, TestResult::Failure(_)))
+            .filter(|current| passed(&previous_result.test_case_results, current.id))
+            .map(|current| current.id)
+            .collect();
+
+        Self {
+            previous_task_id,
+            solution_changed: previous_solution != solution,
+            files_changed,
+            lines_added,
+            lines_removed,
+            previous_verdict: previous_result.verdict,
+            verdict_improved: progress(result) > progress(previous_result),
+            fixed,
+            broken,
+        }
+    }
+}
+
+/// Ranks how far a result got, by its verdict and then by how many of its test cases passed.
+fn progress(result: &SubmissionResult) -> (u8, usize) {
+    let rank = match result.verdict {
+        Verdict::SecurityViolation => 0,
+        Verdict::CompilationError => 1,
+        Verdict::Pass => 3,
+        _ => 2,
+    };
+    let passed = result
+        .test_case_results
+        .iter()
+        .filter(|result| result.test_result == TestResult::Pass)
+        .count();
+
+    (rank, passed)
+}
+
+fn text(value: &Value) -> &str {
+    value.as_str().unwrap_or_default()
+}
+
+/// Gets the decoded contents of the other files of a submission by their path, leaving out those which are not base64.
+fn decoded(submission: &Value) -> BTreeMap<&str, Vec<u8>> {
+    submission["files"]
+        .as_object()
+        .into_iter()
+        .flatten()
+        .filter_map(|(path, contents)| Some((path.as_str(), files::decode(contents.as_str()?)?)))
+        .collect()
+}
+
+/// Gets the contents of a file as text, where a file which does not exist is empty.
+fn text_of(contents: Option<&Vec<u8>>) -> Result<&str, std::str::Utf8Error> {
+    contents.map_or(Ok(""), |contents| std::str::from_utf8(contents))
+}
+
+fn paths(blobs: &Value) -> impl Iterator<Item = &str> {
+    blobs
+        .as_object()
+        .into_iter()
+        .flat_map(|blobs| blobs.keys().map(String::as_str))
+}
+
+#[cfg(test)]
+mod comparison {
+    use super::Revision;
+    use crate::model::{
+        SubmissionResult, TestCaseFailureReason, TestCaseResult, TestResult, Verdict,
+    };
+    use serde_json::json;
+    use std::collections::BTreeMap;
+    use uuid::Uuid;
+
+    fn result(test_results: Vec<TestResult>) -> SubmissionResult {
+        let test_case_results = test_results
+            .into_iter()
+            .enumerate()
+            .map(|(index, test_result)| TestCaseResult {
+                id: index as u64,
+                name: None,
+                test_result,
+                hidden: false,
+                stderr: String::new(),
+                runtime: 0,
+                memory: None,
+                user_time: None,
+                system_time: None,
+                cause: None,
+                benchmark: None,
+                attachments: BTreeMap::new(),
+            })
+            .collect();
+
+        SubmissionResult::checked(String::new(), test_case_results)
+    }
+
+    #[test]
+    fn fixed_and_broken() {
+        let failure = || TestResult::Failure(TestCaseFailureReason::RuntimeError);
+        let previous = json!({
+            "solution": "add a b = a - b\nmain = pure ()\n",
+            "files": {"Util.hs": "bW9kdWxlIFV0aWwgd2hlcmUK", "Old.hs": "b2xkCg=="},
+            "blobs": {"data.txt": "aaaa"},
+        });
+        let submission = json!({
+            "solution": "add a b = a + b\nmain = pure ()\n",
+            "files": {"Util.hs": "bW9kdWxlIFV0aWwgd2hlcmUK"},
+            "blobs": {"data.txt": "bbbb"},
+        });
+        let previous_result = result(vec![
+            failure(),
+            TestResult::Pass,
+            failure(),
+            TestResult::Pass,
+        ]);
+        let current = result(vec![
+            TestResult::Pass,
+            TestResult::Pass,
+            failure(),
+            failure(),
+        ]);
+        let id = Uuid::new_v4();
+
+        let actual = Revision::new(id, (&previous, &previous_result), (&submission, &current));
+
+        assert_eq!(
+            actual,
+            Revision {
+                previous_task_id: id,
+                solution_changed: true,
+                files_changed: vec![String::from("Old.hs"), String::from("data.txt")],
+                lines_added: 1,
+                lines_removed: 2,
+                previous_verdict: Verdict::Failure,
+                verdict_improved: false,
+                fixed: vec![0],
+                broken: vec![3],
+            }
+        );
+    }
+
+    #[test]
+    fn improved_verdict() {
+        let submission = json!({"solution": "main = pure ()"});
+        let compiled = result(vec![TestResult::Pass]);
+        let failed = SubmissionResult::compilation_error(String::new(), Vec::new());
+
+        let improved = Revision::new(
+            Uuid::nil(),
+            (&submission, &failed),
+            (&submission, &compiled),
+        );
+        let regressed = Revision::new(
+            Uuid::nil(),
+            (&submission, &compiled),
+            (&submission, &failed),
+        );
+
+        assert!(improved.verdict_improved);
+        assert_eq!(improved.fixed, vec![0]);
+        assert!(!improved.solution_changed);
+        assert!(!regressed.verdict_improved);
+        assert!(regressed.broken.is_empty());
+    }
+}
@@ -0,0 +1,290 @@
+use crate::{
+    error::GenerateError,
+    generate::{GenerateRequest, Input},
+    model::{Language, Parameter, Submission, SubmissionResult, TestResult},
+    problem::Problem,
+    response::SubmitResponse,
+    sandbox::{Channel, Sink},
+};
+use axum::response::sse::Event;
+use serde::{Deserialize, Serialize};
+use std::{
+    collections::BTreeMap,
+    sync::{Arc, Mutex},
+};
+use tokio::sync::mpsc::UnboundedSender;
+
+/// A request to run a solution against a single input while debugging it, whose output is streamed as it runs.
+#[derive(Deserialize)]
+pub struct RunRequest {
+    /// The language of the solution, which defaults to haskell.
+    #[serde(default)]
+    pub language: Language,
+    pub solution: String,
+    #[serde(default)]
+    pub files: BTreeMap<String, String>,
+    /// The value type of the value the solution returns.
+    #[serde(rename = "outputType")]
+    pub output_type: String,
+    #[serde(rename = "inputParameters")]
+    pub input_parameters: Box<[Parameter]>,
+    /// The wall-clock time limit of the solution in milliseconds.
+    #[serde(rename = "timeLimit")]
+    pub time_limit: Option<u64>,
+    /// The memory limit of the solution in mebibytes, which is capped by the server.
+    #[serde(rename = "memoryLimit")]
+    pub memory_limit: Option<u64>,
+}
+
+/// The result of a debugging run, which is the result of its submission along with the value the solution returned.
+#[derive(Serialize)]
+pub struct RunResult {
+    /// The value the solution returned, unless it failed to return one.
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub output: Option<String>,
+    #[serde(flatten)]
+    pub result: SubmissionResult,
+}
+
+/// An event of a debugging run, which is sent to its client as a server-sent event named by its kind.
+pub enum RunEvent {
+    /// A chunk of the standard output or error of the solution.
+    Output(Channel, String),
+    /// The solution was run, or failed to compile.
+    Result(Box<RunResult>),
+    /// The solution could not be run at all.
+    Problem(Problem),
+}
+
+impl RunRequest {
+    /// Creates a submission of the solution with a single test case, which is generated like a test case of a reference
+    /// solution, so its output is known whatever it is.
+    pub fn submission(&self) -> Result<Submission, GenerateError> {
+        let mut submission = self.generate().submission()?;
+        submission.files = self.files.clone();
+
+        Ok(submission)
+    }
+
+    /// Creates the result of the run from the response to its submission.
+    ///
+    /// The single test case expects a placeholder rather than an actual output, so it passes whenever the solution
+    /// returned a value.
+    pub fn finish(&self, response: SubmitResponse) -> RunEvent {
+        let result = match response.into_result() {
+            Ok(result) => result,
+            Err(problem) => return RunEvent::Problem(problem),
+        };
+        let output = match self.generate().test_cases(&result) {
+            Ok(mut test_cases) if !result.test_case_results.is_empty() => {
+                test_cases.test_cases.pop().and_then(|test_case| {
+                    Vec::from(test_case.output_parameters)
+                        .pop()
+                        .map(|parameter| parameter.value)
+                })
+            }
+            _ => None,
+        };
+        let result = match output {
+            Some(_) => {
+                let mut test_case_results = result.test_case_results;
+                for test_case_result in test_case_results.iter_mut() {
+                    test_case_result.test_result = TestResult::Pass;
+                }
+                SubmissionResult::checked(result.compile_output, test_case_results)
+            }
+            None => result,
+        };
+
+        RunEvent::Result(Box::new(RunResult { output, result }))
+    }
+
+    fn generate(&self) -> GenerateRequest {
+        GenerateRequest {
+            language: self.language,
+            solution: self.solution.clone(),
+            output_type: self.output_type.clone(),
+            inputs: Box::new([Input {
+                name: None,
+                input_parameters: self.input_parameters.clone(),
+                time_limit: self.time_limit,
+            }]),
+            memory_limit: self.memory_limit,
+        }
+    }
+}
+
+impl RunEvent {
+    pub fn event(&self) -> Event {
+        match self {
+            RunEvent::Output(Channel::Stdout, text) => Event::default().event("stdout").data(text),
+            RunEvent::Output(Channel::Stderr, text) => Event::default().event("stderr").data(text),
+            RunEvent::Result(result) => Event::default()
+                .event("result")
+                .json_data(result)
+                .expect("a result should always serialize"),
+            RunEvent::Problem(problem) => Event::default()
+                .event("problem")
+                .json_data(problem)
+                .expect("a problem should always serialize"),
+        }
+    }
+}
+
+/// Creates a sink sending the output of a run as events, holding back a character which is split across chunks until
+/// the rest of it is read.
+pub fn sink(sender: UnboundedSender<RunEvent>) -> Sink {
+    let pending = Mutex::new([Vec::new(), Vec::new()]);
+
+    Arc::new(move |channel, chunk| {
+        let mut pending = pending.lock().expect("run output lock poisoned");
+        let pending = &mut pending[channel as usize];
+        pending.extend_from_slice(chunk);
+
+        let complete = complete_len(pending);
+        if complete == 0 {
+            return;
+        }
+        let text = String::from_utf8_lossy(&pending[..complete]).into_owned();
+        pending.drain(..complete);
+        // the client may be gone already, in which case the run is cancelled anyway
+        let _ = sender.send(RunEvent::Output(channel, text));
+    })
+}
+
+/// Gets the length of the bytes up to the incomplete character they end in, if they end in one.
+fn complete_len(bytes: &[u8]) -> usize {
+    for start in (bytes.len().saturating_sub(3)..bytes.len()).rev() {
+        let width = match bytes[start] {
+            0x80..=0xbf => continue,
+            0xc0..=0xdf => 2,
+            0xe0..=0xef => 3,
+            0xf0..=0xf7 => 4,
+            _ => break,
+        };
+        if start + width > bytes.len() {
+            return start;
+        }
+        break;
+    }
+
+    bytes.len()
+}
+
+#[cfg(test)]
+mod debugging {
+    use super::{sink, RunEvent, RunRequest};
+    use crate::{
+        model::{
+            Language, Parameter, SubmissionResult, TestCaseFailureReason, TestCaseResult,
+            TestResult, Verdict,
+        },
+        response::SubmitResponse,
+        sandbox::Channel,
+    };
+    use std::collections::BTreeMap;
+    use tokio::sync::mpsc;
+
+    fn request() -> RunRequest {
+        RunRequest {
+            language: Language::Haskell,
+            solution: String::from("solution x = x * 2"),
+            files: BTreeMap::new(),
+            output_type: String::from("int"),
+            input_parameters: Box::new([Parameter {
+                value_type: String::from("int"),
+                value: String::from("21"),
+            }]),
+            time_limit: Some(1000),
+            memory_limit: None,
+        }
+    }
+
+    fn checked(test_result: TestResult) -> SubmitResponse {
+        SubmitResponse::Checked(SubmissionResult::checked(
+            String::new(),
+            Box::new([TestCaseResult {
+                id: 0,
+                name: None,
+                test_result,
+                hidden: false,
+                stderr: String::from("debugging\n"),
+                runtime: 5,
+                memory: None,
+                user_time: None,
+                system_time: None,
+                cause: None,
+                benchmark: None,
+                attachments: BTreeMap::new(),
+            }]),
+        ))
+    }
+
+    #[test]
+    fn single_test_case() {
+        let submission = request()
+            .submission()
+            .expect("int is a supported output type");
+
+        assert_eq!(submission.test_cases.len(), 1);
+        assert_eq!(submission.test_cases[0].time_limit, Some(1000));
+        assert_eq!(submission.test_cases[0].input_parameters[0].value, "21");
+    }
+
+    #[test]
+    fn returned_output() {
+        let wrong_answer = checked(TestResult::Failure(TestCaseFailureReason::WrongAnswer {
+            input_parameters: Box::new([]),
+            expected: String::from("0"),
+            actual: String::from("42"),
+            diff: None,
+            encoding: None,
+        }));
+
+        let RunEvent::Result(run) = request().finish(wrong_answer) else {
+            panic!("the run should have a result");
+        };
+
+        assert_eq!(run.output.as_deref(), Some("42"));
+        assert_eq!(run.result.verdict, Verdict::Pass);
+        assert_eq!(run.result.test_case_results[0].stderr, "debugging\n");
+    }
+
+    #[test]
+    fn failed_run() {
+        let runtime_error = checked(TestResult::Failure(TestCaseFailureReason::RuntimeError));
+
+        let RunEvent::Result(run) = request().finish(runtime_error) else {
+            panic!("the run should have a result");
+        };
+        let internal = request().finish(SubmitResponse::Internal);
+
+        assert_eq!(run.output, None);
+        assert_eq!(run.result.verdict, Verdict::Failure);
+        assert!(matches!(internal, RunEvent::Problem(_)));
+    }
+
+    #[test]
+    fn split_characters() {
+        let (sender, mut receiver) = mpsc::unbounded_channel();
+        let sink = sink(sender);
+        let text = "añb".as_bytes();
+
+        sink(Channel::Stdout, &text[..2]);
+        sink(Channel::Stderr, b"err");
+        sink(Channel::Stdout, &text[2..]);
+
+        let mut received = Vec::new();
+        while let Ok(RunEvent::Output(channel, text)) = receiver.try_recv() {
+            received.push((channel, text));
+        }
+        assert_eq!(
+            received,
+            [
+                (Channel::Stdout, String::from("a")),
+                (Channel::Stderr, String::from("err")),
+                (Channel::Stdout, String::from("ñb")),
+            ]
+        );
+    }
+}
@@ -0,0 +1,187 @@
+use super::{
+    compile_in, diagnostics::Format, imports, quote, remove_files, single_output, sources_with,
+    Compiler, LanguageHandler, Toolchain, ValueType,
+};
+use crate::{
+    config::Config,
+    error::{CheckError, UUID_SHOULD_BE_VALID_STR},
+    model::{Language, Parameter, TestCase},
+    sandbox::Sandbox,
+};
+use std::path::PathBuf;
+
+/// The docker image used when no image is configured for c.
+pub(super) const C_DEFAULT_IMAGE: &str = "gcc:14";
+
+/// The flags passed to gcc after the input file, as the math library must be linked after it.
+pub(super) const C_COMPILE_FLAGS: &[&str] = &["-O2", "-lm"];
+
+pub(super) const TOOLCHAIN: Toolchain = Toolchain {
+    image: C_DEFAULT_IMAGE,
+    compiler: "gcc",
+    flags: C_COMPILE_FLAGS,
+    version_args: &["-dumpfullversion"],
+    linter: &["gcc", "-fsyntax-only", "-Wall", "-Wextra"],
+    extensions: &["c", "h"],
+};
+
+const C_BASE_TEST_CODE: &str = r###"
+#include <stdbool.h>
+#include <stdio.h>
+#include <stdlib.h>
+#include <string.h>
+
+SOLUTION
+
+int main(int argc, char **argv) {
+  if (argc != 2) {
+    return 1;
+  }
+
+  char output_path[4096];
+  snprintf(output_path, sizeof(output_path), "%s/%s", "OUTPUT_DIR_PATH", argv[1]);
+
+  switch (atoi(argv[1])) {
+TEST_CASES
+  }
+
+  return 0;
+}
+"###;
+
+pub struct C {
+    temp_dir: PathBuf,
+    sandbox: Sandbox,
+    compiler: Compiler,
+}
+
+impl LanguageHandler for C {
+    fn new(temp_dir: PathBuf, config: &Config) -> Self {
+        Self {
+            temp_dir,
+            sandbox: Sandbox::new(config, Language::C, C_DEFAULT_IMAGE),
+            compiler: Compiler::new(config, Language::C, TOOLCHAIN.compiler, TOOLCHAIN.flags),
+        }
+    }
+
+    fn dir(&self) -> &PathBuf {
+        &self.temp_dir
+    }
+
+    fn test_file_path(&self) -> PathBuf {
+        let mut path = self.temp_dir.clone();
+        path.push("test.c");
+
+        path
+    }
+
+    fn base_test_code(&self) -> &str {
+        C_BASE_TEST_CODE
+    }
+
+    fn generate_test_cases(&self, test_cases: &[TestCase]) -> Result<String, CheckError> {
+        let mut generated_test_cases = Vec::with_capacity(test_cases.len());
+
+        for (index, test_case) in test_cases.iter().enumerate() {
+            let output = single_output(test_case)?;
+
+            let formatted_input_parameters = test_case
+                .input_parameters
+                .iter()
+                .map(|ip| self.format_parameter(ip))
+                .collect::<Vec<String>>()
+                .join(", ");
+
+            let formatted_output_parameter = self.format_parameter(output);
+
+            let (type_name, passed, format) = match ValueType::of(output)? {
+                ValueType::Int => ("long long", "actual == expected", r#""%lld""#),
+                ValueType::Float => ("double", "actual == expected", r#""%g""#),
+                ValueType::Bool => ("bool", "actual == expected", r#""%d""#),
+                ValueType::Char => ("char", "actual == expected", r#""'%c'""#),
+                ValueType::String => (
+                    "const char *",
+                    "strcmp(actual, expected) == 0",
+                    r#""\"%s\"""#,
+                ),
+            };
+
+            let generated_test_case = format!(
+                r#"  case {index}: {{
+    {type_name} actual = solution({formatted_input_parameters});
+    {type_name} expected = {formatted_output_parameter};
+    FILE *output = fopen(output_path, "a");
+    if ({passed}) {{
+      fprintf(output, "p\n");
+    }} else {{
+      fprintf(output, "f," {format} "," {format} "\n", actual, expected);
+    }}
+    fclose(output);
+    break;
+  }}"#
+            );
+            generated_test_cases.push(generated_test_case);
+        }
+
+        Ok(generated_test_cases.join("\n"))
+    }
+
+    fn format_parameter(&self, parameter: &Parameter) -> String {
+        match parameter.value_type.as_str() {
+            "string" => quote(&parameter.value),
+            "char" => format!("'{}'", parameter.value),
+            _ => format!("({})", parameter.value),
+        }
+    }
+
+    fn compiler(&self) -> &Compiler {
+        &self.compiler
+    }
+
+    fn imports(&self, solution: &str) -> Vec<String> {
+        imports::c(solution)
+    }
+
+    fn compile(&self, sources: &[PathBuf]) -> Result<String, CheckError> {
+        let executable_path = self.temp_dir.join("test");
+        let executable_str = executable_path.to_str().expect(UUID_SHOULD_BE_VALID_STR);
+        let test_file_path = self.test_file_path();
+        let test_file_str = test_file_path.to_str().expect(UUID_SHOULD_BE_VALID_STR);
+
+        compile_in(
+            &self.sandbox,
+            &self.temp_dir,
+            self.compiler.program(),
+            &[
+                vec!["-o", executable_str, test_file_str],
+                sources_with(sources, "c"),
+                self.compiler.flags(),
+            ]
+            .concat(),
+            self.compiler.timeout(),
+        )
+    }
+
+    fn diagnostic_format(&self) -> Format {
+        Format::Gnu
+    }
+
+    fn artifacts(&self) -> Result<Vec<String>, CheckError> {
+        Ok(vec!["test".to_string()])
+    }
+
+    fn sandbox(&self) -> &Sandbox {
+        &self.sandbox
+    }
+
+    fn run_command(&self, index: usize, _: &TestCase) -> Vec<String> {
+        let executable_path = self.temp_dir.join("test");
+        let executable_str = executable_path.to_str().expect(UUID_SHOULD_BE_VALID_STR);
+
+        vec![executable_str.to_string(), index.to_string()]
+    }
+
+    fn cleanup(&self) -> Result<(), CheckError> {
+        remove_files(&self.temp_dir, &["test"])
+    }
+}
@@ -0,0 +1,200 @@
+use super::program::Program;
+use crate::{
+    compare::unquote,
+    config::Config,
+    error::{CheckError, UUID_SHOULD_BE_VALID_STR},
+    model::{Language, TestCase},
+    sandbox::{Limits, Outcome},
+};
+use std::{fs, path::PathBuf};
+
+/// The exit code of a checker which accepts the actual output.
+const ACCEPTED_EXIT_CODE: i32 = 0;
+
+/// The exit code of a checker which rejects the actual output.
+const REJECTED_EXIT_CODE: i32 = 1;
+
+/// A compiled checker program, which judges the output of test cases in place of a comparison.
+pub struct CheckerProgram(Program);
+
+impl CheckerProgram {
+    /// Writes the source of a checker into its own directory and compiles it there, in the sandbox of its language.
+    ///
+    /// Fails with [`CheckError::Checker`] if the checker does not compile, as a broken checker cannot judge anything.
+    pub fn compile(
+        language: Language,
+        source: &str,
+        dir: PathBuf,
+        config: &Config,
+    ) -> Result<Self, CheckError> {
+        Program::compile("checker", language, source, dir, config).map(Self)
+    }
+
+    /// Judges the actual output of the test case at the given index, returning whether it is correct.
+    ///
+    /// The output is given to the checker as printed by the test program, without the quotes of strings.
+    pub fn check(
+        &self,
+        index: usize,
+        test_case: &TestCase,
+        actual: &str,
+        expected: &str,
+        limits: &Limits,
+    ) -> Result<bool, CheckError> {
+        let input = test_case
+            .input_parameters
+            .iter()
+            .map(|parameter| format!("{}\n", parameter.value))
+            .collect::<String>();
+
+        let mut paths = Vec::with_capacity(3);
+        for (suffix, contents) in [
+            ("input", input),
+            ("expected", unquote(expected)),
+            ("actual", unquote(actual)),
+        ] {
+            let path = self.0.dir().join(format!("{index}.{suffix}"));
+            if fs::write(&path, contents).is_err() {
+                return Err(CheckError::IOInteraction);
+            }
+            paths.push(path.to_str().expect(UUID_SHOULD_BE_VALID_STR).to_string());
+        }
+
+        let (program, args) = self.0.command(&paths);
+        let execution =
+            self.0
+                .sandbox()
+                .execute(self.0.dir(), program, &args, None, &self.0.limits(limits))?;
+
+        match execution.outcome {
+            Outcome::Exited(status) => match status.code() {
+                Some(ACCEPTED_EXIT_CODE) => Ok(true),
+                Some(REJECTED_EXIT_CODE) => Ok(false),
+                _ => Err(CheckError::Checker(format!(
+                    "the checker failed on test case {} with {status}: {}",
+                    test_case.id, execution.stderr
+                ))),
+            },
+            Outcome::TimedOut => Err(CheckError::Checker(format!(
+                "the checker exceeded the time limit on test case {}",
+                test_case.id
+            ))),
+            Outcome::MemoryExceeded => Err(CheckError::Checker(format!(
+                "the checker exceeded the memory limit on test case {}",
+                test_case.id
+            ))),
+            Outcome::SecurityViolation => Err(CheckError::Checker(format!(
+                "the checker made a forbidden system call on test case {}",
+                test_case.id
+            ))),
+            Outcome::OutputExceeded => Err(CheckError::Checker(format!(
+                "the checker exceeded the disk limit on test case {}",
+                test_case.id
+            ))),
+        }
+    }
+}
+
+#[cfg(all(test, feature = "python"))]
+mod behavior {
+    use super::CheckerProgram;
+    use crate::{
+        cancel::Cancellation,
+        compare::Comparison,
+        config::{Config, SandboxKind, SeccompProfile},
+        error::CheckError,
+        model::{Language, NetworkPolicy, Parameter, TestCase},
+        sandbox::Limits,
+    };
+    use std::{collections::BTreeMap, env, fs, time::Duration};
+    use uuid::Uuid;
+
+    /// A checker accepting any number within 0.001 of the expected one.
+    const TOLERANT: &str = "\
+import sys
+expected, actual = (float(open(path).read()) for path in sys.argv[2:4])
+sys.exit(0 if abs(expected - actual) <= 0.001 else 1)
+";
+
+    fn check(source: &str, actual: &str, expected: &str) -> Result<bool, CheckError> {
+        let dir = env::temp_dir().join(format!("mozart-checker-{}", Uuid::new_v4()));
+        let config = Config {
+            sandbox: SandboxKind::Dev,
+            ..Config::default()
+        };
+        let test_case = TestCase {
+            id: 3,
+            name: None,
+            input_parameters: Box::new([Parameter {
+                value_type: String::from("double"),
+                value: String::from("0.1"),
+            }]),
+            output_parameters: Box::new([]),
+            weight: 1,
+            hidden: false,
+            time_limit: None,
+            comparison: Comparison::Exact,
+            epsilon: None,
+            group: None,
+            fixtures: Vec::new(),
+            stdin: None,
+            env: BTreeMap::new(),
+            work_dir: BTreeMap::new(),
+        };
+        let limits = Limits {
+            time: Duration::from_millis(1500),
+            memory: 256 * 1024 * 1024,
+            disk: 1024 * 1024,
+            output: 1024,
+            output_tail: 0,
+            seccomp: SeccompProfile::default(),
+            processes: 0,
+            network: NetworkPolicy::None,
+            cancellation: Cancellation::default(),
+            env: BTreeMap::new(),
+            work_dir: None,
+            user: None,
+        };
+
+        let checked = CheckerProgram::compile(Language::Python, source, dir.clone(), &config)
+            .and_then(|checker| checker.check(0, &test_case, actual, expected, &limits));
+        let _ = fs::remove_dir_all(&dir);
+        checked
+    }
+
+    #[test]
+    fn accepts_a_different_correct_answer() {
+        let actual = check(TOLERANT, "0.30000000000000004", "0.3");
+
+        assert!(matches!(actual, Ok(true)));
+    }
+
+    #[test]
+    fn rejects_a_wrong_answer() {
+        let actual = check(TOLERANT, "0.4", "0.3");
+
+        assert!(matches!(actual, Ok(false)));
+    }
+
+    #[test]
+    fn crashing_checker_fails() {
+        let actual = check("import os\nos.abort()\n", "0.3", "0.3");
+
+        assert!(
+            matches!(&actual, Err(CheckError::Checker(message)) if message.contains("failed on test case 3")),
+            "{:?}",
+            actual.err()
+        );
+    }
+
+    #[test]
+    fn hanging_checker_times_out() {
+        let actual = check("while True:\n    pass\n", "0.3", "0.3");
+
+        assert!(
+            matches!(&actual, Err(CheckError::Checker(message)) if message.contains("exceeded the time limit on test case 3")),
+            "{:?}",
+            actual.err()
+        );
+    }
+}
@@ -0,0 +1,441 @@
+use crate::model::{Diagnostic, Severity};
+use std::ops::Range;
+
+/// How a compiler prints the problems it finds, which determines how they are parsed into diagnostics.
+#[derive(Clone, Copy, PartialEq, Debug)]
+pub enum Format {
+    /// `file:line:column: severity: message`, as printed by gcc, go, and javac, where go leaves out the severity and
+    /// javac the column, which is then taken from the caret under the source line.
+    Gnu,
+    /// Like [`Format::Gnu`], where the message continues on the following indented lines, as printed by ghc.
+    Ghc,
+    /// The traceback of a syntax error, as printed by python.
+    Python,
+}
+
+/// A file which diagnostics may be reported in, which is either the test file or a file of the solution.
+#[derive(Clone, Copy)]
+struct Source<'a> {
+    /// The path of the file, which is the name of the test file, or relative to the workspace.
+    path: &'a str,
+    /// Whether the file is a file of the solution rather than the test file.
+    is_file: bool,
+}
+
+/// Parses the diagnostics in the output of a compiler which compiled the file named `file_name`, containing
+/// `test_code`, where the solution spans the lines in `solution`, along with the `files` of the solution.
+///
+/// The lines of the diagnostics are relative to the solution, and are left out along with the columns of problems
+/// outside of it, such as in the generated test code. Problems in the files of the solution keep their lines, and name
+/// the file they are in. Problems in other files are left out entirely.
+pub(super) fn parse(
+    format: Format,
+    output: &str,
+    file_name: &str,
+    files: &[&str],
+    test_code: &str,
+    solution: Range<usize>,
+) -> Vec<Diagnostic> {
+    let mut sources: Vec<Source> = files
+        .iter()
+        .map(|path| Source {
+            path,
+            is_file: true,
+        })
+        .collect();
+    sources.push(Source {
+        path: file_name,
+        is_file: false,
+    });
+    // the longest path is matched first, so `util/test.c` is not taken for the test file `test.c`
+    sources.sort_by_key(|source| std::cmp::Reverse(source.path.len()));
+
+    let diagnostics = match format {
+        Format::Gnu => located(output, &sources, false),
+        Format::Ghc => located(output, &sources, true),
+        Format::Python => python(output, &sources, test_code),
+    };
+
+    diagnostics
+        .into_iter()
+        .map(|diagnostic| match diagnostic.line {
+            _ if diagnostic.file.is_some() => diagnostic,
+            Some(line) if solution.contains(&line) => Diagnostic {
+                line: Some(line - solution.start + 1),
+                ..diagnostic
+            },
+            _ => Diagnostic {
+                line: None,
+                column: None,
+                ..diagnostic
+            },
+        })
+        .collect()
+}
+
+/// Whether a path printed by the compiler is that of the source, e.g. `/tmp/mozart/1/util/strings.c` or
+/// `./util/strings.c` for `util/strings.c`.
+fn is_path_of(printed: &str, source: &Source) -> bool {
+    printed
+        .strip_suffix(source.path)
+        .is_some_and(|before| before.is_empty() || before.ends_with(['/', ' ', '\t']))
+}
+
+/// Finds the source whose path is followed by `:` in the line, returning it along with the rest of the line.
+fn find_source<'l, 's>(line: &'l str, sources: &[Source<'s>]) -> Option<(Source<'s>, &'l str)> {
+    sources.iter().find_map(|source| {
+        let prefix = format!("{}:", source.path);
+        line.match_indices(&prefix)
+            .find(|(start, _)| is_path_of(&line[..start + source.path.len()], source))
+            .map(|(start, _)| (*source, &line[start + prefix.len()..]))
+    })
+}
+
+/// The file of a diagnostic in the source, which is only named for files of the solution.
+fn file_of(source: &Source) -> Option<String> {
+    source.is_file.then(|| source.path.to_string())
+}
+
+/// Parses the diagnostics which start with the location of the problem in the file.
+///
+/// A diagnostic without a column takes it from a following caret line, e.g. `       ^`, as printed by javac.
+fn located(output: &str, sources: &[Source], continued: bool) -> Vec<Diagnostic> {
+    let mut diagnostics: Vec<Diagnostic> = Vec::new();
+    let mut continuing = false;
+
+    for line in output.lines() {
+        let location =
+            find_source(line, sources).and_then(|(source, rest)| Some((source, location(rest)?)));
+        if let Some((source, (line, column, message))) = location {
+            let (severity, message) = severity(message);
+            diagnostics.push(Diagnostic {
+                severity,
+                file: file_of(&source),
+                line: Some(line),
+                column,
+                message: message.to_string(),
+            });
+            continuing = continued;
+            continue;
+        }
+
+        let Some(last) = diagnostics.last_mut() else {
+            continue;
+        };
+        let trimmed = line.trim_start();
+        if last.column.is_none() && trimmed.starts_with('^') {
+            last.column = Some(line.len() - trimmed.len() + 1);
+        } else if continuing && line.starts_with("    ") && !is_source_excerpt(trimmed) {
+            if !last.message.is_empty() {
+                last.message.push('\n');
+            }
+            last.message.push_str(trimmed);
+        } else if continuing && !line.starts_with(' ') {
+            continuing = false;
+        }
+    }
+
+    diagnostics
+}
+
+/// Parses the location after the file name, e.g. `12:5: error: ...`, `12: error: ...`, or `(12,5)-(13,1): error: ...`,
+/// returning the line, the column, and the rest of the diagnostic.
+fn location(rest: &str) -> Option<(usize, Option<usize>, &str)> {
+    if let Some(span) = rest.strip_prefix('(') {
+        let (start, rest) = span.split_once(')')?;
+        let (line, column) = start.split_once(',')?;
+        let (_, message) = rest.split_once(':')?;
+
+        return Some((line.parse().ok()?, column.parse().ok(), message));
+    }
+
+    let (line, rest) = rest.split_once(':')?;
+    let line = line.parse().ok()?;
+
+    // the column may be followed by the end of a span, e.g. `5-9`
+    let column = rest
+        .split_once(':')
+        .and_then(|(column, message)| Some((column.split('-').next()?.parse().ok()?, message)));
+    match column {
+        Some((column, message)) => Some((line, Some(column), message)),
+        None => Some((line, None, rest)),
+    }
+}
+
+/// Splits the severity off the start of a message, regardless of its case as linters like hlint capitalize it, where
+/// a message without one is an error.
+fn severity(message: &str) -> (Severity, &str) {
+    let message = message.trim();
+    let severities = [
+        ("fatal error:", Severity::Error),
+        ("error:", Severity::Error),
+        ("warning:", Severity::Warning),
+        ("note:", Severity::Note),
+        ("suggestion:", Severity::Note),
+    ];
+
+    severities
+        .into_iter()
+        .find_map(|(prefix, severity)| {
+            let rest = message.get(prefix.len()..)?;
+            message[..prefix.len()]
+                .eq_ignore_ascii_case(prefix)
+                .then(|| (severity, rest.trim()))
+        })
+        .unwrap_or((Severity::Error, message))
+}
+
+/// Whether the line is part of the excerpt of the source which ghc prints under a diagnostic, e.g. `12 | x = y`.
+fn is_source_excerpt(line: &str) -> bool {
+    line.trim_start_matches(|c: char| c.is_ascii_digit())
+        .trim_start()
+        .starts_with('|')
+}
+
+/// Parses the syntax errors in a python traceback, e.g. `File "test.py", line 12`, followed by the source line, a caret
+/// under the column, and `SyntaxError: ...`.
+///
+/// The source line is printed without its indentation, which is taken from the test code to find the column, so the
+/// problems in the files of the solution are left without a column.
+fn python(output: &str, sources: &[Source], test_code: &str) -> Vec<Diagnostic> {
+    let mut diagnostics = Vec::new();
+    let mut location: Option<(Source, usize, Option<usize>)> = None;
+    let mut source_indent = 0;
+
+    for line in output.lines() {
+        let trimmed = line.trim_start();
+        if let Some(file) = trimmed.strip_prefix("File \"") {
+            location = file.split_once("\", line ").and_then(|(path, number)| {
+                let source = sources.iter().find(|source| is_path_of(path, source))?;
+                let number = number.split(',').next()?.trim().parse().ok()?;
+                Some((*source, number, None))
+            });
+            source_indent = 0;
+        } else if let Some((source, number, column)) = &mut location {
+            if trimmed.starts_with('^') {
+                if !source.is_file {
+                    let indent = number
+                        .checked_sub(1)
+                        .and_then(|index| test_code.lines().nth(index))
+                        .map_or(0, |source| source.len() - source.trim_start().len());
+                    *column = Some(
+                        (line.len() - trimmed.len()).saturating_sub(source_indent) + indent + 1,
+                    );
+                }
+            } else if let Some((name, message)) = trimmed
+                .split_once(": ")
+                .filter(|(name, _)| name.ends_with("Error") || name.ends_with("Warning"))
+            {
+                diagnostics.push(Diagnostic {
+                    severity: match name.ends_with("Warning") {
+                        true => Severity::Warning,
+                        false => Severity::Error,
+                    },
+                    file: file_of(source),
+                    line: Some(*number),
+                    column: *column,
+                    message: format!("{name}: {message}"),
+                });
+                location = None;
+            } else if column.is_none() {
+                source_indent = line.len() - trimmed.len();
+            }
+        }
+    }
+
+    diagnostics
+}
+
+#[cfg(test)]
+mod parse {
+    use super::{parse, Format};
+    use crate::model::{Diagnostic, Severity};
+
+    fn diagnostic(
+        severity: Severity,
+        line: Option<usize>,
+        column: Option<usize>,
+        message: &str,
+    ) -> Diagnostic {
+        Diagnostic {
+            severity,
+            file: None,
+            line,
+            column,
+            message: message.to_string(),
+        }
+    }
+
+    #[test]
+    fn gcc() {
+        let output = "/tmp/mozart/1/test.c: In function 'solution':\n/tmp/mozart/1/test.c:12:10: error: 'y' undeclared (first use in this function)\n   12 |   return y\n      |          ^\n/tmp/mozart/1/test.c:12:10: note: each undeclared identifier is reported only once\n/tmp/mozart/1/test.c:30:3: warning: unused variable 'z'\n";
+
+        let actual = parse(Format::Gnu, output, "test.c", &[], "", 10..13);
+
+        assert_eq!(
+            actual,
+            vec![
+                diagnostic(
+                    Severity::Error,
+                    Some(3),
+                    Some(10),
+                    "'y' undeclared (first use in this function)"
+                ),
+                diagnostic(
+                    Severity::Note,
+                    Some(3),
+                    Some(10),
+                    "each undeclared identifier is reported only once"
+                ),
+                diagnostic(Severity::Warning, None, None, "unused variable 'z'"),
+            ]
+        );
+    }
+
+    #[test]
+    fn go() {
+        let output = "# command-line-arguments\n./main.go:4:9: undefined: y\n";
+
+        let actual = parse(Format::Gnu, output, "main.go", &[], "", 3..6);
+
+        assert_eq!(
+            actual,
+            vec![diagnostic(
+                Severity::Error,
+                Some(2),
+                Some(9),
+                "undefined: y"
+            )]
+        );
+    }
+
+    #[test]
+    fn javac() {
+        let output = "/tmp/mozart/1/Test.java:27: error: cannot find symbol\n        return y;\n               ^\n  symbol:   variable y\n1 error\n";
+
+        let actual = parse(Format::Gnu, output, "Test.java", &[], "", 26..28);
+
+        assert_eq!(
+            actual,
+            vec![diagnostic(
+                Severity::Error,
+                Some(2),
+                Some(16),
+                "cannot find symbol"
+            )]
+        );
+    }
+
+    #[test]
+    fn ghc() {
+        let output = "[1 of 2] Compiling Main ( Test.hs, Test.o )\n\n/tmp/mozart/1/Test.hs:4:12: error: [GHC-88464]\n    Variable not in scope: y\n  |\n4 | solution = y\n  |            ^\n/tmp/mozart/1/Test.hs:(5,1)-(6,3): warning: [-Wunused-top-binds]\n    Defined but not used: `f'\n";
+
+        let actual = parse(Format::Ghc, output, "Test.hs", &[], "", 4..7);
+
+        assert_eq!(
+            actual,
+            vec![
+                diagnostic(
+                    Severity::Error,
+                    Some(1),
+                    Some(12),
+                    "[GHC-88464]\nVariable not in scope: y"
+                ),
+                diagnostic(
+                    Severity::Warning,
+                    Some(2),
+                    Some(1),
+                    "[-Wunused-top-binds]\nDefined but not used: `f'"
+                ),
+            ]
+        );
+    }
+
+    #[test]
+    fn hlint() {
+        let output = "/tmp/mozart/1/Test.hs:5:12-24: Warning: Use map\nFound:\n  foldr ((:) . f) []\nPerhaps:\n  map f\n\n/tmp/mozart/1/Test.hs:6:1: Suggestion: Eta reduce\n\n2 hints\n";
+
+        let actual = parse(Format::Gnu, output, "Test.hs", &[], "", 4..7);
+
+        assert_eq!(
+            actual,
+            vec![
+                diagnostic(Severity::Warning, Some(2), Some(12), "Use map"),
+                diagnostic(Severity::Note, Some(3), Some(1), "Eta reduce"),
+            ]
+        );
+    }
+
+    #[test]
+    fn python() {
+        let output = "  File \"/tmp/mozart/1/test.py\", line 3\n    if x\n        ^\nSyntaxError: expected ':'\n";
+        let test_code = "import sys\ndef solution(x):\n    if x\n        return 1\n";
+
+        let actual = parse(Format::Python, output, "test.py", &[], test_code, 2..5);
+
+        assert_eq!(
+            actual,
+            vec![diagnostic(
+                Severity::Error,
+                Some(2),
+                Some(9),
+                "SyntaxError: expected ':'"
+            )]
+        );
+    }
+
+    #[test]
+    fn files() {
+        let output = "/tmp/mozart/1/test.c:11:3: warning: implicit declaration of function 'reverse'\n/tmp/mozart/1/util/test.c:4:12: error: expected ';' before '}' token\n/usr/include/stdio.h:1:1: note: included from here\n";
+
+        let actual = parse(Format::Gnu, output, "test.c", &["util/test.c"], "", 10..13);
+
+        assert_eq!(
+            actual,
+            vec![
+                diagnostic(
+                    Severity::Warning,
+                    Some(2),
+                    Some(3),
+                    "implicit declaration of function 'reverse'"
+                ),
+                Diagnostic {
+                    file: Some(String::from("util/test.c")),
+                    ..diagnostic(
+                        Severity::Error,
+                        Some(4),
+                        Some(12),
+                        "expected ';' before '}' token"
+                    )
+                },
+            ]
+        );
+    }
+
+    #[test]
+    fn python_files() {
+        let output = "  File \"/tmp/mozart/1/util/strings.py\", line 2\n    return s[::-1\n            ^\nSyntaxError: '[' was never closed\n";
+
+        let actual = parse(
+            Format::Python,
+            output,
+            "test.py",
+            &["util/strings.py"],
+            "",
+            2..5,
+        );
+
+        assert_eq!(
+            actual,
+            vec![Diagnostic {
+                file: Some(String::from("util/strings.py")),
+                ..diagnostic(
+                    Severity::Error,
+                    Some(2),
+                    None,
+                    "SyntaxError: '[' was never closed"
+                )
+            }]
+        );
+    }
+}
@@ -0,0 +1,326 @@
+use super::{
+    compile_in, diagnostics::Format, imports, quote, remove_files, single_output, sources_with,
+    Compiler, LanguageHandler, Toolchain, ValueType,
+};
+use crate::{
+    config::Config,
+    error::{CheckError, UUID_SHOULD_BE_VALID_STR},
+    model::{Language, Parameter, TestCase},
+    sandbox::Sandbox,
+};
+use std::path::{Path, PathBuf};
+
+/// The docker image used when no image is configured for go.
+pub(super) const GO_DEFAULT_IMAGE: &str = "golang:1.23-alpine";
+
+/// The imports are aliased, so they do not collide with imports of the solution.
+pub(super) const TOOLCHAIN: Toolchain = Toolchain {
+    image: GO_DEFAULT_IMAGE,
+    compiler: "go",
+    flags: &[],
+    version_args: &["version"],
+    linter: &["go", "vet"],
+    extensions: &["go"],
+};
+
+const GO_BASE_TEST_CODE: &str = r###"package main
+
+import (
+	mozartfmt "fmt"
+	mozartos "os"
+)
+
+SOLUTION
+
+func testChecker[T comparable](actual T, expected T) {
+	output, err := mozartos.OpenFile("OUTPUT_DIR_PATH/"+mozartos.Args[1], mozartos.O_APPEND|mozartos.O_CREATE|mozartos.O_WRONLY, 0644)
+	if err != nil {
+		panic(err)
+	}
+	defer output.Close()
+
+	if actual == expected {
+		mozartfmt.Fprint(output, "p"+"\n")
+	} else {
+		mozartfmt.Fprintf(output, "f"+","+"%#v"+","+"%#v"+"\n", actual, expected)
+	}
+}
+
+func main() {
+	switch mozartos.Args[1] {
+TEST_CASES
+	}
+}
+"###;
+
+/// The test file of a go test suite, which runs one of the Test functions of the suite at a time.
+///
+/// The progress of the test binary is written to the output file of the test case rather than its standard output, so
+/// it can be parsed like `go test -json` parses it, without running the go tool in the sandbox.
+const GO_TEST_MAIN_CODE: &str = r###"package main
+
+import (
+	mozartflag "flag"
+	mozartos "os"
+	mozarttesting "testing"
+)
+
+SOLUTION
+
+func TestMain(m *mozarttesting.M) {
+	mozartflag.Parse()
+	events, err := mozartos.Create("OUTPUT_DIR_PATH/" + mozartflag.Arg(0))
+	if err != nil {
+		panic(err)
+	}
+	mozartos.Stdout = events
+	mozartos.Exit(m.Run())
+}
+"###;
+
+pub struct Go {
+    temp_dir: PathBuf,
+    sandbox: Sandbox,
+    compiler: Compiler,
+}
+
+impl LanguageHandler for Go {
+    fn new(temp_dir: PathBuf, config: &Config) -> Self {
+        Self {
+            temp_dir,
+            sandbox: Sandbox::new(config, Language::Go, GO_DEFAULT_IMAGE),
+            compiler: Compiler::new(config, Language::Go, TOOLCHAIN.compiler, TOOLCHAIN.flags),
+        }
+    }
+
+    fn dir(&self) -> &PathBuf {
+        &self.temp_dir
+    }
+
+    fn test_file_path(&self) -> PathBuf {
+        let mut path = self.temp_dir.clone();
+        path.push("main.go");
+
+        path
+    }
+
+    fn base_test_code(&self) -> &str {
+        GO_BASE_TEST_CODE
+    }
+
+    fn generate_test_cases(&self, test_cases: &[TestCase]) -> Result<String, CheckError> {
+        let mut generated_test_cases = Vec::with_capacity(test_cases.len());
+
+        for (index, test_case) in test_cases.iter().enumerate() {
+            let output = single_output(test_case)?;
+            ValueType::of(output)?;
+
+            let formatted_input_parameters = test_case
+                .input_parameters
+                .iter()
+                .map(|ip| self.format_parameter(ip))
+                .collect::<Vec<String>>()
+                .join(", ");
+
+            let formatted_output_parameter = self.format_parameter(output);
+
+            let generated_test_case = format!(
+                "\tcase \"{index}\":\n\t\ttestChecker(solution({formatted_input_parameters}), {formatted_output_parameter})"
+            );
+            generated_test_cases.push(generated_test_case);
+        }
+
+        Ok(generated_test_cases.join("\n"))
+    }
+
+    fn format_parameter(&self, parameter: &Parameter) -> String {
+        match parameter.value_type.as_str() {
+            "string" => quote(&parameter.value),
+            "char" => format!("'{}'", parameter.value),
+            _ => parameter.value.clone(),
+        }
+    }
+
+    fn compiler(&self) -> &Compiler {
+        &self.compiler
+    }
+
+    fn imports(&self, solution: &str) -> Vec<String> {
+        imports::go(solution)
+    }
+
+    fn compile(&self, sources: &[PathBuf]) -> Result<String, CheckError> {
+        let executable_path = self.temp_dir.join("test");
+        let executable_str = executable_path.to_str().expect(UUID_SHOULD_BE_VALID_STR);
+        let test_file_path = self.test_file_path();
+        let test_file_str = test_file_path.to_str().expect(UUID_SHOULD_BE_VALID_STR);
+        let cache_dir = format!("GOCACHE={}", self.temp_dir.join(".gocache").display());
+
+        // other packages of the solution are found through its go.mod, but the files of the main package must be named
+        let package: Vec<&str> = sources_with(sources, "go")
+            .into_iter()
+            .filter(|source| Path::new(source).parent() == Some(self.temp_dir.as_path()))
+            .collect();
+
+        // the build cache is kept in the temporary directory, as the root filesystem may be read-only
+        compile_in(
+            &self.sandbox,
+            &self.temp_dir,
+            "env",
+            &[
+                vec![cache_dir.as_str(), self.compiler.program(), "build"],
+                self.compiler.flags(),
+                vec!["-o", executable_str, test_file_str],
+                package,
+            ]
+            .concat(),
+            self.compiler.timeout(),
+        )
+    }
+
+    fn diagnostic_format(&self) -> Format {
+        Format::Gnu
+    }
+
+    fn artifacts(&self) -> Result<Vec<String>, CheckError> {
+        Ok(vec!["test".to_string()])
+    }
+
+    fn sandbox(&self) -> &Sandbox {
+        &self.sandbox
+    }
+
+    fn run_command(&self, index: usize, _: &TestCase) -> Vec<String> {
+        let executable_path = self.temp_dir.join("test");
+        let executable_str = executable_path.to_str().expect(UUID_SHOULD_BE_VALID_STR);
+
+        vec![executable_str.to_string(), index.to_string()]
+    }
+
+    fn cleanup(&self) -> Result<(), CheckError> {
+        let cache_dir = self.temp_dir.join(".gocache");
+        if cache_dir.exists() && std::fs::remove_dir_all(cache_dir).is_err() {
+            return Err(CheckError::IOInteraction);
+        }
+
+        remove_files(&self.temp_dir, &["test"])
+    }
+}
+
+/// Runs the Test functions of the `_test.go` files of a go submission as its test cases, which are in the package of
+/// the solution, so they call it directly.
+pub struct GoTest {
+    temp_dir: PathBuf,
+    sandbox: Sandbox,
+    compiler: Compiler,
+}
+
+impl LanguageHandler for GoTest {
+    fn new(temp_dir: PathBuf, config: &Config) -> Self {
+        Self {
+            temp_dir,
+            sandbox: Sandbox::new(config, Language::Go, GO_DEFAULT_IMAGE),
+            compiler: Compiler::new(config, Language::Go, TOOLCHAIN.compiler, TOOLCHAIN.flags),
+        }
+    }
+
+    fn dir(&self) -> &PathBuf {
+        &self.temp_dir
+    }
+
+    fn test_file_path(&self) -> PathBuf {
+        self.temp_dir.join("mozart_test.go")
+    }
+
+    fn base_test_code(&self) -> &str {
+        GO_TEST_MAIN_CODE
+    }
+
+    /// The Test functions are the test cases themselves, so nothing is generated for them.
+    fn generate_test_cases(&self, _: &[TestCase]) -> Result<String, CheckError> {
+        Ok(String::new())
+    }
+
+    fn format_parameter(&self, parameter: &Parameter) -> String {
+        parameter.value.clone()
+    }
+
+    fn compiler(&self) -> &Compiler {
+        &self.compiler
+    }
+
+    fn imports(&self, solution: &str) -> Vec<String> {
+        imports::go(solution)
+    }
+
+    /// Compiles the test binary of the suite, without vetting it like `go test` does, as solutions are not vetted
+    /// when they are built either.
+    fn compile(&self, sources: &[PathBuf]) -> Result<String, CheckError> {
+        let executable_path = self.temp_dir.join("suite.test");
+        let executable_str = executable_path.to_str().expect(UUID_SHOULD_BE_VALID_STR);
+        let test_file_path = self.test_file_path();
+        let test_file_str = test_file_path.to_str().expect(UUID_SHOULD_BE_VALID_STR);
+        let cache_dir = format!("GOCACHE={}", self.temp_dir.join(".gocache").display());
+
+        // the test files are among the files of the package, which must be named like those of a solution
+        let package: Vec<&str> = sources_with(sources, "go")
+            .into_iter()
+            .filter(|source| Path::new(source).parent() == Some(self.temp_dir.as_path()))
+            .collect();
+
+        compile_in(
+            &self.sandbox,
+            &self.temp_dir,
+            "env",
+            &[
+                vec![
+                    cache_dir.as_str(),
+                    self.compiler.program(),
+                    "test",
+                    "-c",
+                    "-vet=off",
+                ],
+                self.compiler.flags(),
+                vec!["-o", executable_str, test_file_str],
+                package,
+            ]
+            .concat(),
+            self.compiler.timeout(),
+        )
+    }
+
+    fn diagnostic_format(&self) -> Format {
+        Format::Gnu
+    }
+
+    fn artifacts(&self) -> Result<Vec<String>, CheckError> {
+        Ok(vec!["suite.test".to_string()])
+    }
+
+    fn sandbox(&self) -> &Sandbox {
+        &self.sandbox
+    }
+
+    /// Runs the Test function named by the test case along with its subtests, with the index naming its output file.
+    fn run_command(&self, index: usize, test_case: &TestCase) -> Vec<String> {
+        let executable_path = self.temp_dir.join("suite.test");
+        let executable_str = executable_path.to_str().expect(UUID_SHOULD_BE_VALID_STR);
+        let name = test_case.name.as_deref().unwrap_or_default();
+
+        vec![
+            executable_str.to_string(),
+            format!("-test.run=^{name}$"),
+            String::from("-test.v=test2json"),
+            index.to_string(),
+        ]
+    }
+
+    fn cleanup(&self) -> Result<(), CheckError> {
+        let cache_dir = self.temp_dir.join(".gocache");
+        if cache_dir.exists() && std::fs::remove_dir_all(cache_dir).is_err() {
+            return Err(CheckError::IOInteraction);
+        }
+
+        remove_files(&self.temp_dir, &["suite.test"])
+    }
+}
@@ -0,0 +1,315 @@
+use crate::{
+    compare::Comparison,
+    error::CheckError,
+    files,
+    model::{is_test_name, Language, TestCase, TestCaseFailureReason, TestResult},
+};
+use std::{collections::BTreeMap, fs, io::ErrorKind, path::Path};
+
+/// The byte which starts the lines a go test binary reports its progress by when run with `-test.v=test2json`, so
+/// they are told apart from the output of the tests, the way `go test -json` does.
+const FRAME: char = '\u{16}';
+
+/// The bytes which enclose the errors a test reports within its output, e.g. by `t.Error`.
+const ERROR_MARKS: [char; 2] = ['\u{0f}', '\u{0e}'];
+
+/// Gets a test case of every Test function of the test files of a go test suite, i.e. its files ending in `_test.go`,
+/// in the order of their paths and then of their declarations, which are numbered from 0 in that order.
+pub(super) fn declared_tests(files: &BTreeMap<String, String>) -> Box<[TestCase]> {
+    let mut names = Vec::new();
+    for contents in files
+        .iter()
+        .filter(|(path, _)| path.ends_with("_test.go"))
+        .filter_map(|(_, contents)| files::decode(contents))
+    {
+        for line in String::from_utf8_lossy(&contents).lines() {
+            let Some((name, signature)) = line
+                .strip_prefix("func ")
+                .and_then(|declaration| declaration.split_once('('))
+            else {
+                continue;
+            };
+            // a test takes a *testing.T, whichever name the testing package is imported by
+            let declared = names.iter().any(|known| known == name);
+            if is_test_name(Language::Go, name) && signature.trim_end().contains(".T)") && !declared
+            {
+                names.push(name.to_string());
+            }
+        }
+    }
+
+    numbered(names)
+}
+
+/// Gets a test case of every test with the name, numbered from 0 in their order, which are judged alike.
+pub(super) fn numbered(names: Vec<String>) -> Box<[TestCase]> {
+    names
+        .into_iter()
+        .enumerate()
+        .map(|(id, name)| TestCase {
+            id: id as u64,
+            name: Some(name),
+            input_parameters: Box::new([]),
+            output_parameters: Box::new([]),
+            weight: 1,
+            hidden: false,
+            time_limit: None,
+            comparison: Comparison::Exact,
+            epsilon: None,
+            group: None,
+            fixtures: Vec::new(),
+            stdin: None,
+            env: BTreeMap::new(),
+            work_dir: BTreeMap::new(),
+        })
+        .collect()
+}
+
+/// What happened while a go test binary ran, like the actions of the events of `go test -json`.
+#[derive(PartialEq, Debug)]
+enum Action {
+    Run,
+    Pass,
+    Fail,
+    Skip,
+    Output,
+}
+
+/// An event of the run of a go test binary, where the test is left out of the events about the whole binary.
+#[derive(PartialEq, Debug)]
+struct Event<'a> {
+    action: Action,
+    test: Option<&'a str>,
+    output: &'a str,
+}
+
+/// Parses the output of a go test binary run with `-test.v=test2json` into its events, attributing every line of
+/// output to the test which was running when it was written.
+fn parse_events(stream: &str) -> Vec<Event<'_>> {
+    let mut events = Vec::new();
+    let mut current = None;
+    for line in stream.lines() {
+        let Some(frame) = line.strip_prefix(FRAME) else {
+            events.push(Event {
+                action: Action::Output,
+                test: current,
+                output: line,
+            });
+            continue;
+        };
+
+        let (action, test) = if let Some(test) = frame.strip_prefix("=== RUN") {
+            (Action::Run, test)
+        } else if let Some(test) = frame
+            .strip_prefix("=== NAME")
+            .or_else(|| frame.strip_prefix("=== CONT"))
+            .or_else(|| frame.strip_prefix("=== PAUSE"))
+        {
+            current = Some(test.trim()).filter(|test| !test.is_empty());
+            continue;
+        } else if let Some(test) = frame.strip_prefix("--- PASS:") {
+            (Action::Pass, test)
+        } else if let Some(test) = frame.strip_prefix("--- FAIL:") {
+            (Action::Fail, test)
+        } else if let Some(test) = frame.strip_prefix("--- SKIP:") {
+            (Action::Skip, test)
+        } else {
+            match frame.trim() {
+                "PASS" => (Action::Pass, ""),
+                "FAIL" => (Action::Fail, ""),
+                _ => (Action::Output, ""),
+            }
+        };
+        // the outcome of a test is followed by its elapsed time, e.g. `--- PASS: TestSum (0.00s)`
+        let test = test.split_whitespace().next();
+        if action == Action::Run {
+            current = test;
+        }
+        events.push(Event {
+            action,
+            test,
+            output: frame,
+        });
+    }
+
+    events
+}
+
+/// Reads the result of a test case of a go test suite from the events its run wrote to its output file.
+///
+/// A test which fails has the output of itself and its subtests, and a skipped test passes. A run which ends before
+/// the test binary reported its outcome, e.g. as the test panicked, is a runtime error, as is a test which never ran.
+pub(super) fn read_test_result(
+    test_case: &TestCase,
+    output_file_path: &Path,
+) -> Result<TestResult, CheckError> {
+    let stream = match fs::read(output_file_path) {
+        Ok(stream) => String::from_utf8_lossy(&stream).into_owned(),
+        Err(err) if err.kind() == ErrorKind::NotFound => {
+            return Ok(TestResult::Failure(TestCaseFailureReason::RuntimeError));
+        }
+        Err(_) => return Err(CheckError::IOInteraction),
+    };
+    let events = parse_events(&stream);
+    let name = test_case.name.as_deref().unwrap_or_default();
+    let within = |test: Option<&str>| {
+        test.is_some_and(|test| test == name || test.starts_with(&format!("{name}/")))
+    };
+
+    let finished = events
+        .iter()
+        .any(|event| event.test.is_none() && matches!(event.action, Action::Pass | Action::Fail));
+    let outcome = events
+        .iter()
+        .rev()
+        .find(|event| event.test == Some(name) && event.action != Action::Output);
+    let test_result = match outcome.map(|event| &event.action) {
+        _ if !finished => TestResult::Failure(TestCaseFailureReason::RuntimeError),
+        Some(Action::Pass | Action::Skip) => TestResult::Pass,
+        Some(Action::Fail) => {
+            let output: Vec<String> = events
+                .iter()
+                .filter(|event| event.action == Action::Output && within(event.test))
+                .map(|event| event.output.replace(ERROR_MARKS, ""))
+                .collect();
+            TestResult::Failure(TestCaseFailureReason::TestFailed {
+                output: output.join("\n"),
+                message: None,
+                trace: None,
+            })
+        }
+        _ => TestResult::Failure(TestCaseFailureReason::RuntimeError),
+    };
+
+    Ok(test_result)
+}
+
+#[cfg(test)]
+mod events {
+    use super::{declared_tests, parse_events, read_test_result, Action};
+    use crate::{
+        compare::Comparison,
+        model::{is_test_name, Language, TestCase, TestCaseFailureReason, TestResult},
+    };
+    use base64::{engine::general_purpose::STANDARD, Engine};
+    use std::{collections::BTreeMap, env, fs};
+    use uuid::Uuid;
+
+    const SUBTEST_FAILED: &str = "\u{16}=== RUN   TestSub
+\u{16}=== RUN   TestSub/a
+\u{0f}    sum_test.go:13: sub failed\u{0e}
+\u{16}--- FAIL: TestSub/a (0.00s)
+\u{16}=== NAME  TestSub
+\u{16}--- FAIL: TestSub (0.00s)
+\u{16}=== NAME
+\u{16}FAIL
+";
+
+    fn test_case(name: &str) -> TestCase {
+        TestCase {
+            id: 0,
+            name: Some(name.to_string()),
+            input_parameters: Box::new([]),
+            output_parameters: Box::new([]),
+            weight: 1,
+            hidden: false,
+            time_limit: None,
+            comparison: Comparison::Exact,
+            epsilon: None,
+            group: None,
+            fixtures: Vec::new(),
+            stdin: None,
+            env: BTreeMap::new(),
+            work_dir: BTreeMap::new(),
+        }
+    }
+
+    fn read(name: &str, stream: &str) -> TestResult {
+        let path = env::temp_dir().join(format!("gotest-{}", Uuid::new_v4()));
+        fs::write(&path, stream).unwrap();
+        let test_result = read_test_result(&test_case(name), &path).unwrap();
+        fs::remove_file(path).unwrap();
+        test_result
+    }
+
+    #[test]
+    fn attributes_output() {
+        let actual = parse_events(SUBTEST_FAILED);
+
+        assert_eq!(actual[0].action, Action::Run);
+        assert_eq!(actual[2].test, Some("TestSub/a"));
+        assert_eq!(actual[2].action, Action::Output);
+        assert_eq!(actual[4].test, Some("TestSub"));
+        assert_eq!(actual[4].action, Action::Fail);
+        assert_eq!(actual[5].test, None);
+    }
+
+    #[test]
+    fn failed_test() {
+        let actual = read("TestSub", SUBTEST_FAILED);
+
+        assert!(matches!(
+            actual,
+            TestResult::Failure(TestCaseFailureReason::TestFailed { output, message: None, .. })
+                if output == "    sum_test.go:13: sub failed"
+        ));
+    }
+
+    #[test]
+    fn passed_test() {
+        let passed = "\u{16}=== RUN   TestSum\n    sum_test.go:7: fine\n\u{16}--- PASS: TestSum (0.00s)\n\u{16}PASS\n";
+        let skipped = "\u{16}=== RUN   TestSum\n\u{16}--- SKIP: TestSum (0.00s)\n\u{16}PASS\n";
+
+        assert!(read("TestSum", passed) == TestResult::Pass);
+        assert!(read("TestSum", skipped) == TestResult::Pass);
+    }
+
+    #[test]
+    fn unfinished_run() {
+        // a panic ends the run before the binary reports its outcome
+        let panicked = "\u{16}=== RUN   TestPanic\n\u{16}--- FAIL: TestPanic (0.00s)\n";
+        let no_tests = "testing: warning: no tests to run\n\u{16}PASS\n";
+
+        assert!(
+            read("TestPanic", panicked) == TestResult::Failure(TestCaseFailureReason::RuntimeError)
+        );
+        assert!(
+            read("TestMissing", no_tests)
+                == TestResult::Failure(TestCaseFailureReason::RuntimeError)
+        );
+    }
+
+    #[test]
+    fn test_names() {
+        let is_go_test_name = |name| is_test_name(Language::Go, name);
+
+        assert!(is_go_test_name("TestSum"));
+        assert!(is_go_test_name("Test"));
+        assert!(is_go_test_name("Test_large_input"));
+        assert!(!is_go_test_name("Testing"));
+        assert!(!is_go_test_name("TestSum|TestOther"));
+        assert!(!is_go_test_name("BenchmarkSum"));
+    }
+
+    #[test]
+    fn declares_tests() {
+        let source = "package main\n\nimport tt \"testing\"\n\nfunc TestSum(t *tt.T) {}\nfunc helper(t *tt.T) {}\nfunc TestSum(t *tt.T) {}\nfunc BenchmarkSum(b *tt.B) {}\nfunc TestLarge(t *tt.T) {\n}\n";
+        let files = BTreeMap::from([
+            (String::from("sum_test.go"), STANDARD.encode(source)),
+            (
+                String::from("sum.go"),
+                STANDARD.encode("func TestOther(t *testing.T) {}"),
+            ),
+        ]);
+
+        let actual = declared_tests(&files);
+
+        assert_eq!(
+            actual
+                .iter()
+                .map(|test_case| (test_case.id, test_case.name.as_deref()))
+                .collect::<Vec<_>>(),
+            [(0, Some("TestSum")), (1, Some("TestLarge"))]
+        );
+    }
+}
@@ -1,29 +1,64 @@
-use super::LanguageHandler;
+use super::{
+    compile_in, diagnostics::Format, imports, remove_files, Compiler, LanguageHandler, Toolchain,
+};
 use crate::{
+    config::Config,
     error::{CheckError, UUID_SHOULD_BE_VALID_STR},
-    model::{Parameter, TestCase},
+    model::{Language, Parameter, TestCase},
+    sandbox::Sandbox,
+};
+use std::path::PathBuf;
+
+/// The docker image used when no image is configured for haskell.
+pub(super) const HASKELL_DEFAULT_IMAGE: &str = "haskell:9.8";
+
+const HASKELL_COMPILE_FLAGS: &[&str] = &["-O2"];
+
+pub(super) const TOOLCHAIN: Toolchain = Toolchain {
+    image: HASKELL_DEFAULT_IMAGE,
+    compiler: "ghc",
+    flags: HASKELL_COMPILE_FLAGS,
+    version_args: &["--numeric-version"],
+    linter: &["hlint"],
+    extensions: &["hs"],
 };
-use std::{path::PathBuf, process::Command};
 
 const HASKELL_BASE_TEST_CODE: &str = r###"
+import System.Environment (getArgs)
+
 SOLUTION
 
 main = do
+  [index] <- getArgs
+  let output = "OUTPUT_DIR_PATH/" ++ index
+  case index of
 TEST_CASES
+    _ -> return ()
 
-testChecker actual expected = do
+testChecker output actual expected = do
   if actual == expected
-    then appendFile "OUTPUT_FILE_PATH" ("p" ++ "\n")
-    else appendFile "OUTPUT_FILE_PATH" ("f" ++ "," ++ show actual ++ "," ++ show expected ++ "\n")
+    then appendFile output ("p" ++ "\n")
+    else appendFile output ("f" ++ "," ++ show actual ++ "," ++ show expected ++ "\n")
 "###;
 
 pub struct Haskell {
     temp_dir: PathBuf,
+    sandbox: Sandbox,
+    compiler: Compiler,
 }
 
 impl LanguageHandler for Haskell {
-    fn new(temp_dir: PathBuf) -> Self {
-        Self { temp_dir }
+    fn new(temp_dir: PathBuf, config: &Config) -> Self {
+        Self {
+            temp_dir,
+            sandbox: Sandbox::new(config, Language::Haskell, HASKELL_DEFAULT_IMAGE),
+            compiler: Compiler::new(
+                config,
+                Language::Haskell,
+                TOOLCHAIN.compiler,
+                TOOLCHAIN.flags,
+            ),
+        }
     }
 
     fn dir(&self) -> &PathBuf {
@@ -41,10 +76,10 @@ impl LanguageHandler for Haskell {
         HASKELL_BASE_TEST_CODE
     }
 
-    fn generate_test_cases(&self, test_cases: &[TestCase]) -> String {
+    fn generate_test_cases(&self, test_cases: &[TestCase]) -> Result<String, CheckError> {
         let mut generated_test_cases = Vec::with_capacity(test_cases.len());
 
-        for test_case in test_cases {
+        for (index, test_case) in test_cases.iter().enumerate() {
             let formatted_input_parameters = test_case
                 .input_parameters
                 .iter()
@@ -60,12 +95,12 @@ impl LanguageHandler for Haskell {
                 .join(" ");
 
             let generated_test_case = format!(
-                "  testChecker (solution {formatted_input_parameters}) ({formatted_output_parameters})"
+                "    \"{index}\" -> testChecker output (solution {formatted_input_parameters}) ({formatted_output_parameters})"
             );
             generated_test_cases.push(generated_test_case);
         }
 
-        generated_test_cases.join("\n")
+        Ok(generated_test_cases.join("\n"))
     }
 
     fn format_parameter(&self, parameter: &Parameter) -> String {
@@ -75,33 +110,63 @@ impl LanguageHandler for Haskell {
         }
     }
 
-    fn run(&self) -> Result<(), CheckError> {
-        let mut executable_path = self.temp_dir.clone();
-        executable_path.push("/test");
+    fn compiler(&self) -> &Compiler {
+        &self.compiler
+    }
+
+    fn imports(&self, solution: &str) -> Vec<String> {
+        imports::haskell(solution)
+    }
+
+    fn compile(&self, _sources: &[PathBuf]) -> Result<String, CheckError> {
+        let executable_path = self.executable_path();
         let executable_str = executable_path.to_str().expect(UUID_SHOULD_BE_VALID_STR);
         let test_file_path = self.test_file_path();
         let test_file_str = test_file_path.to_str().expect(UUID_SHOULD_BE_VALID_STR);
 
-        let Ok(compile_output) = Command::new("ghc")
-            .args(["-O2", "-o", executable_str, test_file_str])
-            .output()
-        else {
-            return Err(CheckError::IOInteraction);
-        };
+        // ghc finds the other modules of the solution by itself, as their paths follow their names
+        compile_in(
+            &self.sandbox,
+            &self.temp_dir,
+            self.compiler.program(),
+            &[
+                self.compiler.flags(),
+                vec!["-o", executable_str, test_file_str],
+            ]
+            .concat(),
+            self.compiler.timeout(),
+        )
+    }
 
-        let Ok(stderr) = String::from_utf8(compile_output.stderr) else {
-            // this should probably not be an io interaction, and possibly may never occur
-            return Err(CheckError::IOInteraction);
-        };
+    fn diagnostic_format(&self) -> Format {
+        Format::Ghc
+    }
 
-        if stderr.contains("error:") {
-            return Err(CheckError::Compilation(stderr));
-        }
+    fn artifacts(&self) -> Result<Vec<String>, CheckError> {
+        Ok(vec!["test".to_string()])
+    }
 
-        if Command::new(executable_path).output().is_err() {
-            return Err(CheckError::IOInteraction);
-        }
+    fn sandbox(&self) -> &Sandbox {
+        &self.sandbox
+    }
+
+    fn run_command(&self, index: usize, _: &TestCase) -> Vec<String> {
+        let executable_path = self.executable_path();
+        let executable_str = executable_path.to_str().expect(UUID_SHOULD_BE_VALID_STR);
+
+        vec![executable_str.to_string(), index.to_string()]
+    }
 
-        Ok(())
+    fn cleanup(&self) -> Result<(), CheckError> {
+        remove_files(&self.temp_dir, &["test", "Test.hi", "Test.o"])
+    }
+}
+
+impl Haskell {
+    fn executable_path(&self) -> PathBuf {
+        let mut path = self.temp_dir.clone();
+        path.push("test");
+
+        path
     }
 }
@@ -0,0 +1,142 @@
+use crate::{
+    error::CheckError,
+    model::{FailureKind, Hook, HookResult},
+    sandbox::{Channel, Limits, Outcome, Sandbox, Sink},
+};
+use std::{
+    fs,
+    path::Path,
+    sync::{Arc, Mutex},
+};
+
+/// The directory of the setup and teardown scripts, relative to the temporary directory, like the checker.
+pub const HOOK_DIR: &str = "hooks";
+
+/// Writes the script of the hook of the given name into the directory of the scripts, which must happen before the
+/// workspace is handed over, so the script cannot be changed by the solution.
+pub fn write(dir: &Path, name: &str, hook: &Hook) -> Result<(), CheckError> {
+    let hook_dir = dir.join(HOOK_DIR);
+    fs::create_dir_all(&hook_dir)
+        .and_then(|_| fs::write(hook_dir.join(format!("{name}.sh")), &hook.script))
+        .map_err(|_| CheckError::IOInteraction)
+}
+
+/// Runs the script of the hook of the given name with `sh` in the sandbox, from the workspace in which the test cases
+/// are run, capturing its standard output and error.
+///
+/// A script which fails is a result rather than an error, so the caller decides what its failure means.
+pub fn run(
+    sandbox: &Sandbox,
+    dir: &Path,
+    name: &str,
+    limits: &Limits,
+) -> Result<HookResult, CheckError> {
+    let stdout = Arc::new(Mutex::new(Vec::new()));
+    let sink: Sink = {
+        let stdout = stdout.clone();
+        Arc::new(move |channel, chunk| {
+            if channel == Channel::Stdout {
+                stdout
+                    .lock()
+                    .expect("hook stdout lock poisoned")
+                    .extend_from_slice(chunk);
+            }
+        })
+    };
+    let script = format!("{HOOK_DIR}/{name}.sh");
+    let execution = sandbox.stream(dir, "sh", &[&script], None, limits, &sink)?;
+
+    let (exit_code, killed) = match execution.outcome {
+        Outcome::Exited(status) => (status.code(), None),
+        Outcome::TimedOut => (None, Some(FailureKind::TimeLimitExceeded)),
+        Outcome::MemoryExceeded => (None, Some(FailureKind::MemoryLimitExceeded)),
+        Outcome::SecurityViolation => (None, Some(FailureKind::SecurityViolation)),
+        Outcome::OutputExceeded => (None, Some(FailureKind::OutputLimitExceeded)),
+    };
+    let stdout = std::mem::take(&mut *stdout.lock().expect("hook stdout lock poisoned"));
+
+    Ok(HookResult {
+        exit_code,
+        killed,
+        stdout: String::from_utf8_lossy(&stdout).into_owned(),
+        stderr: execution.stderr,
+        runtime: execution.runtime.as_millis() as u64,
+    })
+}
+
+/// Describes why the setup script failed, along with its output, as the reason the submission could not be judged.
+pub fn failure(result: &HookResult) -> String {
+    let reason = match (result.killed, result.exit_code) {
+        (Some(kind), _) => format!("the setup script was killed: {}", kind.as_str()),
+        (None, Some(code)) => format!("the setup script exited with {code}"),
+        (None, None) => String::from("the setup script was killed by a signal"),
+    };
+
+    match result.stderr.trim() {
+        "" => reason,
+        stderr => format!("{reason}\n{stderr}"),
+    }
+}
+
+#[cfg(test)]
+mod host {
+    use super::{failure, run, write};
+    use crate::{
+        cancel::Cancellation,
+        config::SeccompProfile,
+        model::{Hook, NetworkPolicy},
+        sandbox::{Limits, Sandbox},
+    };
+    use std::{collections::BTreeMap, env, fs, time::Duration};
+    use uuid::Uuid;
+
+    fn limits() -> Limits {
+        Limits {
+            time: Duration::from_secs(5),
+            memory: 256 * 1024 * 1024,
+            disk: 1024 * 1024,
+            output: 1024,
+            output_tail: 0,
+            seccomp: SeccompProfile::NoNetwork,
+            processes: 0,
+            network: NetworkPolicy::None,
+            cancellation: Cancellation::default(),
+            env: BTreeMap::from([(String::from("MOZART_SEED"), String::from("7"))]),
+            work_dir: None,
+            user: None,
+        }
+    }
+
+    #[test]
+    fn captures_output() {
+        let dir = env::temp_dir().join(format!("mozart-hook-{}", Uuid::new_v4()));
+        fs::create_dir_all(&dir).unwrap();
+        let setup = Hook {
+            script: String::from("echo \"seed $MOZART_SEED\"\necho rows > data.txt\necho done >&2"),
+            time_limit: None,
+        };
+        let teardown = Hook {
+            script: String::from("echo missing >&2\nexit 3"),
+            time_limit: None,
+        };
+
+        write(&dir, "setup", &setup).unwrap();
+        write(&dir, "teardown", &teardown).unwrap();
+        let set_up = run(&Sandbox::Host, &dir, "setup", &limits());
+        let data = fs::read_to_string(dir.join("data.txt"));
+        let torn_down = run(&Sandbox::Host, &dir, "teardown", &limits());
+        let _ = fs::remove_dir_all(&dir);
+
+        let set_up = set_up.unwrap();
+        assert!(set_up.succeeded());
+        assert_eq!(set_up.stdout, "seed 7\n");
+        assert_eq!(set_up.stderr, "done\n");
+        assert_eq!(data.unwrap(), "rows\n");
+        let torn_down = torn_down.unwrap();
+        assert_eq!(torn_down.exit_code, Some(3));
+        assert_eq!(
+            failure(&torn_down),
+            "the setup script exited with 3\nmissing"
+        );
+    }
+}
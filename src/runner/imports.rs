@@ -0,0 +1,155 @@
+/// Gets the modules imported by a haskell solution, e.g. `Data.List` from `import qualified Data.List as L`.
+#[cfg(feature = "haskell")]
+pub(super) fn haskell(solution: &str) -> Vec<String> {
+    solution
+        .lines()
+        .filter_map(|line| line.strip_prefix("import "))
+        .filter_map(|import| {
+            import
+                .split_whitespace()
+                .find(|word| !matches!(*word, "qualified" | "safe" | "{-#" | "SOURCE" | "#-}"))
+                .map(str::to_string)
+        })
+        .collect()
+}
+
+/// Gets the modules imported by a python solution, from both `import a, b as c` and `from a.b import c`.
+#[cfg(feature = "python")]
+pub(super) fn python(solution: &str) -> Vec<String> {
+    let mut imports = Vec::new();
+    for line in solution.lines().map(str::trim_start) {
+        if let Some(modules) = line.strip_prefix("import ") {
+            let modules = modules.split('#').next().unwrap_or_default();
+            imports.extend(
+                modules
+                    .split(',')
+                    .filter_map(|module| module.split_whitespace().next())
+                    .map(str::to_string),
+            );
+        } else if let Some(from) = line.strip_prefix("from ") {
+            if let Some(module) = from.split_whitespace().next() {
+                imports.push(module.to_string());
+            }
+        }
+    }
+
+    imports
+}
+
+/// Gets the packages imported by a go solution, from both single imports and import blocks.
+#[cfg(feature = "go")]
+pub(super) fn go(solution: &str) -> Vec<String> {
+    let mut imports = Vec::new();
+    let mut in_block = false;
+    for line in solution.lines().map(str::trim) {
+        let spec = if in_block {
+            if line.starts_with(')') {
+                in_block = false;
+                continue;
+            }
+            line
+        } else if let Some(import) = line.strip_prefix("import") {
+            let import = import.trim_start();
+            match import.strip_prefix('(') {
+                Some(spec) => {
+                    in_block = true;
+                    spec
+                }
+                None => import,
+            }
+        } else {
+            continue;
+        };
+
+        // the path is the only quoted part of a spec, which may be preceded by an alias
+        if let Some(path) = spec.split('"').nth(1) {
+            imports.push(path.to_string());
+        }
+    }
+
+    imports
+}
+
+/// Gets the headers included by a c solution, e.g. `stdio.h` from `#include <stdio.h>`.
+#[cfg(feature = "c")]
+pub(super) fn c(solution: &str) -> Vec<String> {
+    solution
+        .lines()
+        .filter_map(|line| line.trim_start().strip_prefix('#'))
+        .filter_map(|directive| directive.trim_start().strip_prefix("include"))
+        .filter_map(|header| {
+            header
+                .trim()
+                .strip_prefix(['<', '"'])?
+                .split(['>', '"'])
+                .next()
+                .map(str::to_string)
+        })
+        .collect()
+}
+
+/// Gets the classes named by their fully qualified names in a java solution, e.g. `java.util.List`.
+///
+/// The solution is inserted into the body of the test class, where it cannot have imports of its own, so classes
+/// outside of `java.lang` are named by their fully qualified names instead.
+#[cfg(feature = "java")]
+pub(super) fn java(solution: &str) -> Vec<String> {
+    const ROOTS: [&str; 4] = ["java.", "javax.", "jdk.", "sun."];
+
+    solution
+        .split(|c: char| !c.is_alphanumeric() && c != '_' && c != '.')
+        .filter(|name| ROOTS.iter().any(|root| name.starts_with(root)))
+        .map(|name| name.trim_end_matches('.').to_string())
+        .collect()
+}
+
+#[cfg(test)]
+mod find {
+    #[test]
+    #[cfg(feature = "haskell")]
+    fn haskell() {
+        let solution = "import Data.List (sort)\nimport qualified Data.Map as M\n\nsolution = sort";
+
+        assert_eq!(super::haskell(solution), vec!["Data.List", "Data.Map"]);
+    }
+
+    #[test]
+    #[cfg(feature = "python")]
+    fn python() {
+        let solution = "import os, sys as system\nfrom os.path import join\n\ndef solution():\n    import math # lazily\n";
+
+        assert_eq!(
+            super::python(solution),
+            vec!["os", "sys", "os.path", "math"]
+        );
+    }
+
+    #[test]
+    #[cfg(feature = "go")]
+    fn go() {
+        let solution =
+            "import \"fmt\"\nimport (\n\t\"os\"\n\tstr \"strings\"\n)\n\nfunc solution() {}";
+
+        assert_eq!(super::go(solution), vec!["fmt", "os", "strings"]);
+    }
+
+    #[test]
+    #[cfg(feature = "c")]
+    fn c() {
+        let solution =
+            "#include <stdio.h>\n# include \"solution.h\"\n\nint solution() { return 0; }";
+
+        assert_eq!(super::c(solution), vec!["stdio.h", "solution.h"]);
+    }
+
+    #[test]
+    #[cfg(feature = "java")]
+    fn java() {
+        let solution = "static int solution(java.util.List<Integer> xs) {\n    return java.util.Collections.max(xs);\n}";
+
+        assert_eq!(
+            super::java(solution),
+            vec!["java.util.List", "java.util.Collections.max"]
+        );
+    }
+}
@@ -0,0 +1,143 @@
+use super::program::Program;
+use crate::{
+    config::Config,
+    error::{CheckError, UUID_SHOULD_BE_VALID_STR},
+    model::{Language, TestCase, TestCaseFailureReason},
+    sandbox::{self, Execution, Limits, Outcome, Party, Recording, Sandbox},
+};
+use std::{
+    fs,
+    path::{Path, PathBuf},
+    sync::Arc,
+    time::Duration,
+};
+
+/// The exit code of an interactor which accepts the interaction.
+const ACCEPTED_EXIT_CODE: i32 = 0;
+
+/// The exit code of an interactor which rejects the interaction.
+const REJECTED_EXIT_CODE: i32 = 1;
+
+/// A compiled interactor program, which converses with the solution during every test case.
+pub struct InteractorProgram(Program);
+
+/// The test program of a test case, which the interactor converses with.
+pub struct Solution<'a> {
+    pub sandbox: &'a Sandbox,
+    pub dir: &'a Path,
+    pub command: &'a [String],
+}
+
+impl InteractorProgram {
+    /// Writes the source of an interactor into its own directory and compiles it there, in the sandbox of its
+    /// language.
+    ///
+    /// Fails with [`CheckError::Checker`] if the interactor does not compile, as a broken interactor cannot judge
+    /// anything.
+    pub fn compile(
+        language: Language,
+        source: &str,
+        dir: PathBuf,
+        config: &Config,
+    ) -> Result<Self, CheckError> {
+        Program::compile("interactor", language, source, dir, config).map(Self)
+    }
+
+    /// Runs the solution against the test case at the given index while the interactor converses with it, returning
+    /// the execution of the solution along with why the interaction failed, if it did.
+    ///
+    /// The verdict of the interactor only counts if the solution exited by itself, as it most likely rejects a
+    /// solution which was killed halfway through. What they write to each other is recorded, if a recording is given.
+    #[allow(clippy::too_many_arguments)]
+    pub fn interact(
+        &self,
+        index: usize,
+        test_case: &TestCase,
+        solution: Solution,
+        limits: &Limits,
+        idle_limit: Duration,
+        recording: Option<&Arc<Recording>>,
+    ) -> Result<(Execution, Option<TestCaseFailureReason>), CheckError> {
+        let input = test_case
+            .input_parameters
+            .iter()
+            .map(|parameter| format!("{}\n", parameter.value))
+            .collect::<String>();
+        let input_path = self.0.dir().join(format!("{index}.input"));
+        if fs::write(&input_path, input).is_err() {
+            return Err(CheckError::IOInteraction);
+        }
+
+        let input_paths = [input_path
+            .to_str()
+            .expect(UUID_SHOULD_BE_VALID_STR)
+            .to_string()];
+        let (program, args) = self.0.command(&input_paths);
+        let (solution_program, solution_args) = solution
+            .command
+            .split_first()
+            .expect("a run command is never empty");
+        let solution_args: Vec<&str> = solution_args.iter().map(String::as_str).collect();
+        let interactor_limits = self.0.limits(limits);
+
+        let interaction = sandbox::interact(
+            Party {
+                sandbox: solution.sandbox,
+                dir: solution.dir,
+                program: solution_program,
+                args: &solution_args,
+                limits,
+            },
+            Party {
+                sandbox: self.0.sandbox(),
+                dir: self.0.dir(),
+                program,
+                args: &args,
+                limits: &interactor_limits,
+            },
+            idle_limit,
+            recording,
+        )?;
+
+        let failure = match interaction.interactor.outcome {
+            _ if interaction.idle => Some(TestCaseFailureReason::IdlenessLimitExceeded),
+            _ if !matches!(interaction.solution.outcome, Outcome::Exited(_)) => None,
+            Outcome::Exited(status) => match status.code() {
+                Some(ACCEPTED_EXIT_CODE) => None,
+                Some(REJECTED_EXIT_CODE) => Some(TestCaseFailureReason::WrongInteraction),
+                _ => {
+                    return Err(CheckError::Checker(format!(
+                        "the interactor failed on test case {} with {status}: {}",
+                        test_case.id, interaction.interactor.stderr
+                    )))
+                }
+            },
+            Outcome::TimedOut => {
+                return Err(CheckError::Checker(format!(
+                    "the interactor exceeded the time limit on test case {}",
+                    test_case.id
+                )))
+            }
+            Outcome::MemoryExceeded => {
+                return Err(CheckError::Checker(format!(
+                    "the interactor exceeded the memory limit on test case {}",
+                    test_case.id
+                )))
+            }
+            Outcome::SecurityViolation => {
+                return Err(CheckError::Checker(format!(
+                    "the interactor made a forbidden system call on test case {}",
+                    test_case.id
+                )))
+            }
+            Outcome::OutputExceeded => {
+                return Err(CheckError::Checker(format!(
+                    "the interactor exceeded the disk limit on test case {}",
+                    test_case.id
+                )))
+            }
+        };
+
+        Ok((interaction.solution, failure))
+    }
+}
@@ -0,0 +1,380 @@
+use super::{
+    compile_in, diagnostics::Format, imports, quote, single_output, sources_with, Compiler,
+    LanguageHandler, Toolchain, ValueType,
+};
+use crate::{
+    config::Config,
+    error::{CheckError, UUID_SHOULD_BE_VALID_STR},
+    files,
+    model::{Language, Parameter, TestCase},
+    sandbox::Sandbox,
+};
+use std::path::{Path, PathBuf};
+
+/// The docker image used when no image is configured for java.
+pub(super) const JAVA_DEFAULT_IMAGE: &str = "eclipse-temurin:21";
+
+/// The classpath of JUnit test suites when no test classpath is configured for java, which is the standalone jar of
+/// the console launcher of JUnit, as it bundles the launcher, the engines, and the reporting of JUnit.
+pub(super) const JUNIT_DEFAULT_CLASSPATH: &str = "/opt/junit/junit-platform-console-standalone.jar";
+
+/// The solution is inserted into the body of the test class, so it should consist of static methods.
+/// The java launcher is taken from the same directory as the configured compiler.
+pub(super) const TOOLCHAIN: Toolchain = Toolchain {
+    image: JAVA_DEFAULT_IMAGE,
+    compiler: "javac",
+    flags: &[],
+    version_args: &["-version"],
+    linter: &[],
+    extensions: &["java"],
+};
+
+const JAVA_BASE_TEST_CODE: &str = r###"
+public class Test {
+SOLUTION
+
+  private static String output;
+
+  private static void testChecker(boolean passed, Object actual, Object expected) {
+    String line = passed ? "p" + "\n" : "f" + "," + actual + "," + expected + "\n";
+    try {
+      java.nio.file.Files.writeString(
+          java.nio.file.Path.of(output),
+          line,
+          java.nio.file.StandardOpenOption.CREATE,
+          java.nio.file.StandardOpenOption.APPEND);
+    } catch (java.io.IOException e) {
+      throw new RuntimeException(e);
+    }
+  }
+
+  public static void main(String[] args) {
+    output = "OUTPUT_DIR_PATH/" + args[0];
+    switch (args[0]) {
+TEST_CASES
+    }
+  }
+}
+"###;
+
+pub struct Java {
+    temp_dir: PathBuf,
+    sandbox: Sandbox,
+    compiler: Compiler,
+}
+
+impl LanguageHandler for Java {
+    fn new(temp_dir: PathBuf, config: &Config) -> Self {
+        Self {
+            temp_dir,
+            sandbox: Sandbox::new(config, Language::Java, JAVA_DEFAULT_IMAGE),
+            compiler: Compiler::new(config, Language::Java, TOOLCHAIN.compiler, TOOLCHAIN.flags),
+        }
+    }
+
+    fn dir(&self) -> &PathBuf {
+        &self.temp_dir
+    }
+
+    fn test_file_path(&self) -> PathBuf {
+        let mut path = self.temp_dir.clone();
+        path.push("Test.java");
+
+        path
+    }
+
+    fn base_test_code(&self) -> &str {
+        JAVA_BASE_TEST_CODE
+    }
+
+    fn generate_test_cases(&self, test_cases: &[TestCase]) -> Result<String, CheckError> {
+        let mut generated_test_cases = Vec::with_capacity(test_cases.len());
+
+        for (index, test_case) in test_cases.iter().enumerate() {
+            let output = single_output(test_case)?;
+
+            let formatted_input_parameters = test_case
+                .input_parameters
+                .iter()
+                .map(|ip| self.format_parameter(ip))
+                .collect::<Vec<String>>()
+                .join(", ");
+
+            let formatted_output_parameter = self.format_parameter(output);
+
+            let (type_name, passed) = match ValueType::of(output)? {
+                ValueType::Int => ("long", "actual == expected"),
+                ValueType::Float => ("double", "actual == expected"),
+                ValueType::Bool => ("boolean", "actual == expected"),
+                ValueType::Char => ("char", "actual == expected"),
+                ValueType::String => ("String", "java.util.Objects.equals(actual, expected)"),
+            };
+
+            let generated_test_case = format!(
+                r#"      case "{index}": {{
+        {type_name} actual = solution({formatted_input_parameters});
+        {type_name} expected = {formatted_output_parameter};
+        testChecker({passed}, actual, expected);
+        break;
+      }}"#
+            );
+            generated_test_cases.push(generated_test_case);
+        }
+
+        Ok(generated_test_cases.join("\n"))
+    }
+
+    fn format_parameter(&self, parameter: &Parameter) -> String {
+        match parameter.value_type.as_str() {
+            "string" => quote(&parameter.value),
+            "char" => format!("'{}'", parameter.value),
+            _ => parameter.value.clone(),
+        }
+    }
+
+    fn compiler(&self) -> &Compiler {
+        &self.compiler
+    }
+
+    fn imports(&self, solution: &str) -> Vec<String> {
+        imports::java(solution)
+    }
+
+    fn compile(&self, sources: &[PathBuf]) -> Result<String, CheckError> {
+        let dir_str = self.temp_dir.to_str().expect(UUID_SHOULD_BE_VALID_STR);
+        let test_file_path = self.test_file_path();
+        let test_file_str = test_file_path.to_str().expect(UUID_SHOULD_BE_VALID_STR);
+
+        compile_in(
+            &self.sandbox,
+            &self.temp_dir,
+            self.compiler.program(),
+            &[
+                self.compiler.flags(),
+                vec!["-d", dir_str, test_file_str],
+                sources_with(sources, "java"),
+            ]
+            .concat(),
+            self.compiler.timeout(),
+        )
+    }
+
+    fn diagnostic_format(&self) -> Format {
+        Format::Gnu
+    }
+
+    fn artifacts(&self) -> Result<Vec<String>, CheckError> {
+        class_artifacts(&self.temp_dir)
+    }
+
+    fn sandbox(&self) -> &Sandbox {
+        &self.sandbox
+    }
+
+    fn run_command(&self, index: usize, _: &TestCase) -> Vec<String> {
+        let dir_str = self.temp_dir.to_str().expect(UUID_SHOULD_BE_VALID_STR);
+
+        vec![
+            launcher(self.compiler.program()),
+            String::from("-cp"),
+            dir_str.to_string(),
+            String::from("Test"),
+            index.to_string(),
+        ]
+    }
+
+    fn cleanup(&self) -> Result<(), CheckError> {
+        remove_class_files(&self.temp_dir)
+    }
+}
+
+/// The test file of a JUnit test suite, whose class is the solution itself, so the tests call it like `Solution.sum`,
+/// and which runs one of the tests of the suite at a time with the launcher of the JUnit platform.
+///
+/// The reports of every engine which ran the test are written to the output file of the test case one after another.
+const JUNIT_MAIN_CODE: &str = r###"
+public class Solution {
+SOLUTION
+
+  public static void main(String[] args) throws Exception {
+    java.nio.file.Path reports = java.nio.file.Path.of("OUTPUT_DIR_PATH", args[0] + ".reports");
+    org.junit.platform.launcher.core.LauncherFactory.create().execute(
+        org.junit.platform.launcher.core.LauncherDiscoveryRequestBuilder.request()
+            .selectors(org.junit.platform.engine.discovery.DiscoverySelectors.selectMethod(args[1]))
+            .build(),
+        new org.junit.platform.reporting.legacy.xml.LegacyXmlReportGeneratingListener(
+            reports, new java.io.PrintWriter(System.err)));
+
+    try (java.io.OutputStream output =
+            java.nio.file.Files.newOutputStream(java.nio.file.Path.of("OUTPUT_DIR_PATH", args[0]));
+        java.nio.file.DirectoryStream<java.nio.file.Path> written =
+            java.nio.file.Files.newDirectoryStream(reports)) {
+      for (java.nio.file.Path report : written) {
+        java.nio.file.Files.copy(report, output);
+      }
+    }
+  }
+}
+"###;
+
+/// Runs the JUnit tests of the test files of a java submission as its test cases, which are compiled along with the
+/// solution against the test classpath of java.
+pub struct JUnit {
+    temp_dir: PathBuf,
+    sandbox: Sandbox,
+    compiler: Compiler,
+    classpath: String,
+}
+
+impl LanguageHandler for JUnit {
+    fn new(temp_dir: PathBuf, config: &Config) -> Self {
+        let classpath = &config.language(Language::Java).test_classpath;
+        Self {
+            temp_dir,
+            sandbox: Sandbox::new(config, Language::Java, JAVA_DEFAULT_IMAGE),
+            compiler: Compiler::new(config, Language::Java, TOOLCHAIN.compiler, TOOLCHAIN.flags),
+            classpath: match classpath.as_slice() {
+                [] => JUNIT_DEFAULT_CLASSPATH.to_string(),
+                classpath => classpath.join(":"),
+            },
+        }
+    }
+
+    fn dir(&self) -> &PathBuf {
+        &self.temp_dir
+    }
+
+    fn test_file_path(&self) -> PathBuf {
+        self.temp_dir.join("Solution.java")
+    }
+
+    fn base_test_code(&self) -> &str {
+        JUNIT_MAIN_CODE
+    }
+
+    /// The tests are the test cases themselves, so nothing is generated for them.
+    fn generate_test_cases(&self, _: &[TestCase]) -> Result<String, CheckError> {
+        Ok(String::new())
+    }
+
+    fn format_parameter(&self, parameter: &Parameter) -> String {
+        parameter.value.clone()
+    }
+
+    fn compiler(&self) -> &Compiler {
+        &self.compiler
+    }
+
+    fn imports(&self, solution: &str) -> Vec<String> {
+        imports::java(solution)
+    }
+
+    fn compile(&self, sources: &[PathBuf]) -> Result<String, CheckError> {
+        let dir_str = self.temp_dir.to_str().expect(UUID_SHOULD_BE_VALID_STR);
+        let test_file_path = self.test_file_path();
+        let test_file_str = test_file_path.to_str().expect(UUID_SHOULD_BE_VALID_STR);
+
+        compile_in(
+            &self.sandbox,
+            &self.temp_dir,
+            self.compiler.program(),
+            &[
+                self.compiler.flags(),
+                vec!["-cp", self.classpath.as_str(), "-d", dir_str, test_file_str],
+                sources_with(sources, "java"),
+            ]
+            .concat(),
+            self.compiler.timeout(),
+        )
+    }
+
+    fn diagnostic_format(&self) -> Format {
+        Format::Gnu
+    }
+
+    fn artifacts(&self) -> Result<Vec<String>, CheckError> {
+        class_artifacts(&self.temp_dir)
+    }
+
+    fn sandbox(&self) -> &Sandbox {
+        &self.sandbox
+    }
+
+    /// Runs the test method named by the test case, with the index naming its output file.
+    fn run_command(&self, index: usize, test_case: &TestCase) -> Vec<String> {
+        let dir_str = self.temp_dir.to_str().expect(UUID_SHOULD_BE_VALID_STR);
+
+        vec![
+            launcher(self.compiler.program()),
+            String::from("-cp"),
+            format!("{dir_str}:{}", self.classpath),
+            String::from("Solution"),
+            index.to_string(),
+            test_case.name.clone().unwrap_or_default(),
+        ]
+    }
+
+    fn cleanup(&self) -> Result<(), CheckError> {
+        remove_class_files(&self.temp_dir)
+    }
+}
+
+/// Gets the paths of the class files in the temporary directory relative to it, which are the artifacts of java.
+fn class_artifacts(temp_dir: &Path) -> Result<Vec<String>, CheckError> {
+    let class_files = class_files(temp_dir)?;
+
+    Ok(class_files
+        .iter()
+        .filter_map(|path| path.strip_prefix(temp_dir).ok()?.to_str())
+        .map(str::to_string)
+        .collect())
+}
+
+fn remove_class_files(temp_dir: &Path) -> Result<(), CheckError> {
+    for path in class_files(temp_dir)? {
+        if std::fs::remove_file(path).is_err() {
+            return Err(CheckError::IOInteraction);
+        }
+    }
+
+    Ok(())
+}
+
+/// Gets the paths of every class file in the temporary directory, as nested classes of the solution are compiled to
+/// separate class files, and the classes of its packages to their directories.
+fn class_files(temp_dir: &Path) -> Result<Vec<PathBuf>, CheckError> {
+    let mut class_files = Vec::new();
+    let mut dirs = vec![temp_dir.to_path_buf()];
+    while let Some(dir) = dirs.pop() {
+        let Ok(entries) = std::fs::read_dir(&dir) else {
+            return Err(CheckError::IOInteraction);
+        };
+
+        for entry in entries.flatten() {
+            let path = entry.path();
+            // the directories mozart creates itself never hold classes of the solution
+            let reserved = dir == temp_dir
+                && files::RESERVED.contains(&entry.file_name().to_string_lossy().as_ref());
+            if entry.file_type().is_ok_and(|file_type| file_type.is_dir()) {
+                if !reserved {
+                    dirs.push(path);
+                }
+            } else if path
+                .extension()
+                .is_some_and(|extension| extension == "class")
+            {
+                class_files.push(path);
+            }
+        }
+    }
+
+    Ok(class_files)
+}
+
+/// Gets the java launcher next to the compiler, e.g. `/opt/jdk/bin/java` for `/opt/jdk/bin/javac`.
+pub(super) fn launcher(compiler: &str) -> String {
+    match compiler.strip_suffix("javac") {
+        Some(dir) => format!("{dir}java"),
+        None => String::from("java"),
+    }
+}
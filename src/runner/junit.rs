@@ -0,0 +1,459 @@
+use super::gotest::numbered;
+use crate::{
+    error::CheckError,
+    files,
+    model::{is_test_file, is_test_name, Language, TestCase, TestCaseFailureReason, TestResult},
+};
+use std::{collections::BTreeMap, fs, io::ErrorKind, path::Path};
+
+/// The annotations of the methods JUnit runs as tests, where every run of a parameterized or repeated test is a test
+/// case of its report.
+const JUNIT_ANNOTATIONS: [&str; 3] = ["@Test", "@ParameterizedTest", "@RepeatedTest"];
+
+/// Gets a test case of every test of the test files of a java or python test suite, in the order of their paths and
+/// then of their declarations, which are numbered from 0 in that order.
+///
+/// The tests of java are the annotated methods of the class named like its file, like `SumTest#large`, and those of
+/// python are its `test` functions, and the `test` methods of its `Test` classes, like `test_sum.py::TestSum::test_large`.
+pub(super) fn declared_tests(
+    language: Language,
+    files: &BTreeMap<String, String>,
+) -> Box<[TestCase]> {
+    let mut names = Vec::new();
+    for (path, contents) in files
+        .iter()
+        .filter(|(path, _)| is_test_file(language, path))
+        .filter_map(|(path, contents)| Some((path, files::decode(contents)?)))
+    {
+        let source = String::from_utf8_lossy(&contents);
+        let declared = match language {
+            Language::Java => java_tests(path, &source),
+            _ => python_tests(path, &source),
+        };
+        for name in declared {
+            if is_test_name(language, &name) && !names.contains(&name) {
+                names.push(name);
+            }
+        }
+    }
+
+    numbered(names)
+}
+
+fn java_tests(path: &str, source: &str) -> Vec<String> {
+    let file_name = path.rsplit('/').next().unwrap_or(path);
+    let class = file_name.strip_suffix(".java").unwrap_or(file_name);
+    let mut qualified = class.to_string();
+    let mut tests = Vec::new();
+    let mut annotated = false;
+    for line in source.lines().map(str::trim) {
+        if let Some(package) = line.strip_prefix("package ") {
+            qualified = format!("{}.{class}", package.trim_end_matches(';').trim());
+            continue;
+        }
+
+        // the annotation may be on the line of the method, like `@Test void large() {`
+        let mut declaration = line;
+        if let Some(annotation) = JUNIT_ANNOTATIONS.iter().find(|annotation| {
+            line.strip_prefix(**annotation)
+                .is_some_and(|rest| !rest.starts_with(|char: char| char.is_alphanumeric()))
+        }) {
+            annotated = true;
+            declaration = line[annotation.len()..].trim();
+        }
+        // comments and further annotations may come before the method
+        if !annotated || declaration.is_empty() || declaration.starts_with(['@', '(', '/', '*']) {
+            continue;
+        }
+
+        if let Some((signature, _)) = declaration.split_once('(') {
+            if let Some(method) = signature.split_whitespace().last() {
+                tests.push(format!("{qualified}#{method}"));
+            }
+            annotated = false;
+        }
+    }
+
+    tests
+}
+
+fn python_tests(path: &str, source: &str) -> Vec<String> {
+    let mut tests = Vec::new();
+    let mut class = None;
+    for line in source.lines() {
+        let indented = line.starts_with([' ', '\t']);
+        let declaration = line.trim_start();
+        if !indented && !declaration.is_empty() && !declaration.starts_with('#') {
+            class = declaration
+                .strip_prefix("class ")
+                .map(|rest| rest.split([':', '(']).next().unwrap_or_default().trim())
+                .filter(|name| name.starts_with("Test"));
+        }
+
+        let Some(function) = declaration
+            .strip_prefix("def ")
+            .and_then(|rest| rest.split_once('('))
+            .map(|(name, _)| name.trim())
+            .filter(|name| name.starts_with("test"))
+        else {
+            continue;
+        };
+        match class {
+            Some(class) if indented => tests.push(format!("{path}::{class}::{function}")),
+            None if !indented => tests.push(format!("{path}::{function}")),
+            _ => {}
+        }
+    }
+
+    tests
+}
+
+/// A test case of a JUnit XML report, i.e. a single run of a test, of which a parameterized test has one per set of
+/// arguments.
+#[derive(Default, PartialEq, Debug)]
+struct Case {
+    /// The message and stack trace the test failed or errored with, if it did.
+    failure: Option<(Option<String>, String)>,
+    /// What the test printed, if the framework captured it.
+    output: String,
+}
+
+/// What the contents of an element of a report are collected into.
+#[derive(PartialEq)]
+enum Capture {
+    Trace,
+    Output,
+}
+
+/// Parses the test cases of a JUnit XML report, or of several reports written one after another, only keeping the
+/// elements telling how the tests went, as the reports of JUnit and pytest differ in everything else.
+fn parse_report(report: &str) -> Option<Vec<Case>> {
+    let mut cases: Vec<Case> = Vec::new();
+    let mut within = false;
+    let mut capture = None;
+    for token in tokens(report)? {
+        match token {
+            Token::Open(name, attributes, empty) => match name {
+                "testcase" => {
+                    cases.push(Case::default());
+                    within = !empty;
+                }
+                "failure" | "error" if within => {
+                    let case = cases.last_mut()?;
+                    if case.failure.is_none() {
+                        case.failure = Some((attribute(attributes, "message")?, String::new()));
+                        capture = Some(Capture::Trace).filter(|_| !empty);
+                    }
+                }
+                "system-out" | "system-err" if within && !empty => capture = Some(Capture::Output),
+                _ => {}
+            },
+            Token::Close(name) => match name {
+                "testcase" => within = false,
+                "failure" | "error" | "system-out" | "system-err" => capture = None,
+                _ => {}
+            },
+            Token::Text(text) => {
+                let Some(case) = cases.last_mut().filter(|_| within) else {
+                    continue;
+                };
+                match (&capture, &mut case.failure) {
+                    (Some(Capture::Trace), Some((_, trace))) => trace.push_str(&text),
+                    (Some(Capture::Output), _) => case.output.push_str(&text),
+                    _ => {}
+                }
+            }
+        }
+    }
+
+    Some(cases)
+}
+
+enum Token<'a> {
+    /// The name and attributes of an opening tag, and whether the element is empty, like `<skipped/>`.
+    Open(&'a str, &'a str, bool),
+    Close(&'a str),
+    Text(String),
+}
+
+/// Splits a document into its tags and the text between them, leaving out its declaration, comments, and doctype.
+fn tokens(document: &str) -> Option<Vec<Token<'_>>> {
+    let mut tokens = Vec::new();
+    let mut rest = document;
+    while !rest.is_empty() {
+        let Some(start) = rest.find('<') else {
+            tokens.push(Token::Text(unescape(rest)?));
+            break;
+        };
+        if start > 0 {
+            tokens.push(Token::Text(unescape(&rest[..start])?));
+        }
+        rest = &rest[start..];
+
+        if let Some(cdata) = rest.strip_prefix("<![CDATA[") {
+            let (text, after) = cdata.split_once("]]>")?;
+            tokens.push(Token::Text(text.to_string()));
+            rest = after;
+        } else if let Some(comment) = rest.strip_prefix("<!--") {
+            rest = comment.split_once("-->")?.1;
+        } else if rest.starts_with("<?") || rest.starts_with("<!") {
+            rest = rest.split_once('>')?.1;
+        } else if let Some(tag) = rest.strip_prefix("</") {
+            let (name, after) = tag.split_once('>')?;
+            tokens.push(Token::Close(name.trim()));
+            rest = after;
+        } else {
+            // a `>` may be within the value of an attribute, which ends with the same quote it starts with
+            let mut quote = None;
+            let end = rest.char_indices().skip(1).find_map(|(index, char)| {
+                match (quote, char) {
+                    (None, '"' | '\'') => quote = Some(char),
+                    (Some(open), _) if open == char => quote = None,
+                    (None, '>') => return Some(index),
+                    _ => {}
+                }
+                None
+            })?;
+            let tag = &rest[1..end];
+            let (tag, empty) = match tag.strip_suffix('/') {
+                Some(tag) => (tag, true),
+                None => (tag, false),
+            };
+            let (name, attributes) = tag.split_once(char::is_whitespace).unwrap_or((tag, ""));
+            tokens.push(Token::Open(name, attributes, empty));
+            rest = &rest[end + 1..];
+        }
+    }
+
+    Some(tokens)
+}
+
+/// Gets the value of the attribute of a tag, which is `None` if the attributes are malformed, and `Some(None)` if the
+/// tag does not have the attribute.
+fn attribute(attributes: &str, name: &str) -> Option<Option<String>> {
+    let mut rest = attributes.trim_start();
+    while !rest.is_empty() {
+        let (key, after) = rest.split_once('=')?;
+        let after = after.trim_start();
+        let quote = after
+            .chars()
+            .next()
+            .filter(|char| matches!(char, '"' | '\''))?;
+        let (value, after) = after[1..].split_once(quote)?;
+        if key.trim() == name {
+            return unescape(value).map(Some);
+        }
+        rest = after.trim_start();
+    }
+
+    Some(None)
+}
+
+/// Replaces the references to characters in the text of a document with the characters, unless one is malformed.
+fn unescape(text: &str) -> Option<String> {
+    let mut unescaped = String::with_capacity(text.len());
+    let mut rest = text;
+    while let Some(start) = rest.find('&') {
+        unescaped.push_str(&rest[..start]);
+        let (reference, after) = rest[start + 1..].split_once(';')?;
+        let char = match reference {
+            "lt" => '<',
+            "gt" => '>',
+            "amp" => '&',
+            "quot" => '"',
+            "apos" => '\'',
+            _ => {
+                let code = match reference.strip_prefix("#x") {
+                    Some(hex) => u32::from_str_radix(hex, 16).ok()?,
+                    None => reference.strip_prefix('#')?.parse().ok()?,
+                };
+                char::from_u32(code)?
+            }
+        };
+        unescaped.push(char);
+        rest = after;
+    }
+    unescaped.push_str(rest);
+
+    Some(unescaped)
+}
+
+/// Reads the result of a test case of a JUnit or pytest test suite from the JUnit XML report its run wrote to its
+/// output file.
+///
+/// A test fails with the message and stack trace of its first failing run, along with what every run printed, and a
+/// skipped test passes. A run which ends before the report is written, e.g. as the solution exited the test runner, is
+/// a runtime error, as is a test which never ran, or a report which cannot be parsed.
+pub(super) fn read_test_result(output_file_path: &Path) -> Result<TestResult, CheckError> {
+    let report = match fs::read(output_file_path) {
+        Ok(report) => String::from_utf8_lossy(&report).into_owned(),
+        Err(err) if err.kind() == ErrorKind::NotFound => {
+            return Ok(TestResult::Failure(TestCaseFailureReason::RuntimeError));
+        }
+        Err(_) => return Err(CheckError::IOInteraction),
+    };
+    let Some(cases) = parse_report(&report).filter(|cases| !cases.is_empty()) else {
+        return Ok(TestResult::Failure(TestCaseFailureReason::RuntimeError));
+    };
+
+    let Some((message, trace)) = cases.iter().find_map(|case| case.failure.as_ref()) else {
+        return Ok(TestResult::Pass);
+    };
+    // the reports of JUnit tell which test every case is in its output, which says nothing about the test itself
+    let output: Vec<&str> = cases
+        .iter()
+        .flat_map(|case| case.output.lines())
+        .filter(|line| !line.starts_with("unique-id: ") && !line.starts_with("display-name: "))
+        .filter(|line| !line.trim().is_empty())
+        .collect();
+
+    Ok(TestResult::Failure(TestCaseFailureReason::TestFailed {
+        output: output.join("\n"),
+        message: message.clone(),
+        trace: Some(trace.trim().to_string()).filter(|trace| !trace.is_empty()),
+    }))
+}
+
+#[cfg(test)]
+mod reports {
+    use super::{declared_tests, parse_report, read_test_result, Case};
+    use crate::model::{is_test_name, Language, TestCaseFailureReason, TestResult};
+    use base64::{engine::general_purpose::STANDARD, Engine};
+    use std::{collections::BTreeMap, env, fs};
+    use uuid::Uuid;
+
+    const JUNIT_FAILED: &str = r#"<?xml version="1.0" encoding="UTF-8"?>
+<testsuite name="JUnit Jupiter" tests="2" skipped="0" failures="1" errors="0">
+<!--Unique ID: [engine:junit-jupiter]-->
+<testcase name="large()" classname="SumTest" time="0.01">
+<failure message="expected: &lt;3&gt; but was: &lt;4&gt;" type="org.opentest4j.AssertionFailedError"><![CDATA[org.opentest4j.AssertionFailedError: expected: <3> but was: <4>
+	at SumTest.large(SumTest.java:9)
+]]></failure>
+<system-out><![CDATA[
+unique-id: [engine:junit-jupiter]/[class:SumTest]/[method:large()]
+display-name: large()
+]]></system-out>
+</testcase>
+</testsuite>
+"#;
+
+    const PYTEST_PASSED: &str = r#"<?xml version="1.0" encoding="utf-8"?><testsuites><testsuite name="pytest" errors="0" failures="0" skipped="1" tests="2"><testcase classname="test_sum" name="test_sum[1]" time="0.001"><system-out>sum &gt; 0</system-out></testcase><testcase classname="test_sum" name="test_sum[2]" time="0.001"><skipped type="pytest.skip" message="slow">test_sum.py:4: slow</skipped></testcase></testsuite></testsuites>"#;
+
+    fn read(report: &str) -> TestResult {
+        let path = env::temp_dir().join(format!("junit-{}", Uuid::new_v4()));
+        fs::write(&path, report).unwrap();
+        let test_result = read_test_result(&path).unwrap();
+        fs::remove_file(path).unwrap();
+        test_result
+    }
+
+    #[test]
+    fn parses_report() {
+        let actual = parse_report(PYTEST_PASSED).expect("the report should be parsed");
+
+        assert_eq!(
+            actual,
+            [
+                Case {
+                    failure: None,
+                    output: String::from("sum > 0")
+                },
+                Case::default()
+            ]
+        );
+        assert!(parse_report("<testcase name=\"a\"><failure message=\"&bogus;\"/>").is_none());
+    }
+
+    #[test]
+    fn failed_test() {
+        let actual = read(JUNIT_FAILED);
+
+        assert!(matches!(
+            actual,
+            TestResult::Failure(TestCaseFailureReason::TestFailed { output, message, trace })
+                if output.is_empty()
+                    && message.as_deref() == Some("expected: <3> but was: <4>")
+                    && trace.as_deref().is_some_and(|trace| trace.ends_with("at SumTest.large(SumTest.java:9)"))
+        ));
+    }
+
+    #[test]
+    fn errored_test() {
+        let report = "<testsuite><testcase classname=\"test_sum\" name=\"test_sum\"><error message=\"failed on setup with &quot;fixture 'x' not found&quot;\">file test_sum.py, line 3</error><system-err>warning</system-err></testcase></testsuite>";
+
+        let actual = read(report);
+
+        assert!(matches!(
+            actual,
+            TestResult::Failure(TestCaseFailureReason::TestFailed { output, message, trace })
+                if output == "warning"
+                    && message.as_deref() == Some("failed on setup with \"fixture 'x' not found\"")
+                    && trace.as_deref() == Some("file test_sum.py, line 3")
+        ));
+    }
+
+    #[test]
+    fn passed_test() {
+        assert!(read(PYTEST_PASSED) == TestResult::Pass);
+    }
+
+    #[test]
+    fn missing_test() {
+        let no_tests = "<testsuite name=\"JUnit Jupiter\" tests=\"0\"></testsuite>";
+
+        assert!(read(no_tests) == TestResult::Failure(TestCaseFailureReason::RuntimeError));
+        assert!(read("<testcase") == TestResult::Failure(TestCaseFailureReason::RuntimeError));
+    }
+
+    #[test]
+    fn test_names() {
+        assert!(is_test_name(Language::Java, "SumTest#large"));
+        assert!(is_test_name(Language::Java, "sum.SumTest#large_input"));
+        assert!(!is_test_name(Language::Java, "SumTest"));
+        assert!(!is_test_name(Language::Java, "SumTest#large()"));
+        assert!(is_test_name(Language::Python, "test_sum.py::test_large"));
+        assert!(is_test_name(
+            Language::Python,
+            "tests/sum_test.py::TestSum::test_large"
+        ));
+        assert!(!is_test_name(Language::Python, "test_sum.py"));
+        assert!(!is_test_name(Language::Python, "sum.py::test_large"));
+        assert!(!is_test_name(
+            Language::Python,
+            "../test_sum.py::test_large"
+        ));
+        assert!(!is_test_name(
+            Language::Python,
+            "test_sum.py::test_large[1]"
+        ));
+    }
+
+    #[test]
+    fn declares_tests() {
+        let java = "package sum;\n\nclass SumTest {\n  @Test\n  void small() {}\n\n  @Test void large() {}\n\n  @ParameterizedTest\n  @ValueSource(ints = {1, 2})\n  public void many(int x) {}\n\n  void helper() {}\n}\n";
+        let python = "import pytest\n\ndef test_small():\n    pass\n\nclass TestSum:\n    def test_large(self):\n        pass\n\n    def helper(self):\n        pass\n\ndef helper():\n    def test_nested():\n        pass\n";
+        let files = BTreeMap::from([
+            (String::from("sum/SumTest.java"), STANDARD.encode(java)),
+            (String::from("test_sum.py"), STANDARD.encode(python)),
+        ]);
+
+        let names = |language| {
+            declared_tests(language, &files)
+                .iter()
+                .map(|test_case| test_case.name.clone().unwrap_or_default())
+                .collect::<Vec<_>>()
+        };
+
+        assert_eq!(
+            names(Language::Java),
+            ["sum.SumTest#small", "sum.SumTest#large", "sum.SumTest#many"]
+        );
+        assert_eq!(
+            names(Language::Python),
+            [
+                "test_sum.py::test_small",
+                "test_sum.py::TestSum::test_large"
+            ]
+        );
+    }
+}
@@ -1,30 +1,120 @@
 use crate::{
+    artifact,
+    blob::{self, Blobs},
+    cache::CompileCache,
+    cancel::Cancellation,
+    compare::DEFAULT_EPSILON,
+    config::{Config, LanguageConfig, SeccompProfile},
+    diff,
     error::{CheckError, UUID_SHOULD_BE_VALID_STR},
-    model::{Parameter, Submission, TestCase, TestCaseFailureReason, TestCaseResult, TestResult},
+    fault::{self, Fault},
+    files,
+    fixture::{Fixtures, FIXTURE_DIR},
+    job::Progress,
+    metrics::METRICS,
+    model::{
+        Analysis, Benchmark, BenchmarkResult, CompileResult, Diagnostic, Encoding, Hook,
+        HookResult, HookResults, Language, NetworkPolicy, Parameter, RuntimeErrorCause, Severity,
+        Submission, SubmissionResult, TestCase, TestCaseFailureReason, TestCaseResult,
+        TestCaseTiming, TestCaseTranscript, TestResult, Timings, Transcript, Verdict,
+    },
+    plugin::VerdictPlugins,
+    sandbox::{self, Limits, Outcome, Recording, Sandbox, Sink},
+    score, workspace,
 };
 use std::{
-    fs::File,
-    io::{Read, Write},
-    path::PathBuf,
+    collections::BTreeMap,
+    fs::{self, File},
+    io::{ErrorKind, Read, Write},
+    ops::Range,
+    os::unix::fs::symlink,
+    path::{Path, PathBuf},
+    sync::{
+        atomic::{AtomicBool, AtomicUsize, Ordering},
+        mpsc, Arc, Mutex,
+    },
+    thread,
+    time::{Duration, Instant},
 };
+use tracing::{debug, info, info_span, trace, warn, Span};
 
+use checker::CheckerProgram;
+use diagnostics::Format;
+use interactor::{InteractorProgram, Solution};
+
+#[cfg(feature = "c")]
+use c::C;
+#[cfg(feature = "go")]
+use go::{Go, GoTest};
 #[cfg(feature = "haskell")]
 use haskell::Haskell;
+#[cfg(feature = "java")]
+use java::{JUnit, Java};
+#[cfg(feature = "python")]
+use python::{Pytest, Python};
+#[cfg(feature = "sql")]
+use sql::Sql;
+
+#[cfg(feature = "c")]
+mod c;
+mod checker;
+mod diagnostics;
+#[cfg(feature = "go")]
+mod go;
+mod gotest;
 #[cfg(feature = "haskell")]
 mod haskell;
+mod hook;
+mod imports;
+mod interactor;
+#[cfg(feature = "java")]
+mod java;
+mod junit;
+mod program;
+#[cfg(feature = "python")]
+mod python;
+#[cfg(feature = "sql")]
+mod sql;
 
 /// The replacement target for inserting test cases.
 const TEST_CASES_TARGET: &str = "TEST_CASES";
 
-/// The replacement target for inserting the output file path.
-const OUTPUT_FILE_PATH_TARGET: &str = "OUTPUT_FILE_PATH";
+/// The replacement target for inserting the path of the output directory.
+///
+/// Every test case writes its result to a file in the output directory, named after the index of the test case.
+const OUTPUT_DIR_PATH_TARGET: &str = "OUTPUT_DIR_PATH";
+
+/// The path of the output directory, relative to the temporary directory in which test cases are run.
+///
+/// The path is relative so that the compiled test code does not depend on its temporary directory, and can be cached.
+const OUTPUT_DIR: &str = "output";
+
+/// The directory in which test cases with files of their own get a working directory each, named by their index.
+const WORK_DIR: &str = "cases";
+
+/// The directory of the checker, relative to the temporary directory, so its files do not collide with the test files.
+const CHECKER_DIR: &str = "checker";
+
+/// The directory of the interactor, relative to the temporary directory, like [`CHECKER_DIR`].
+const INTERACTOR_DIR: &str = "interactor";
 
 /// The replacement target for inserting the submitted solution.
 const SOLUTION_TARGET: &str = "SOLUTION";
 
+/// The environment variable in which test cases, checkers, and interactors are given the seed of the submission.
+const SEED_ENV: &str = "MOZART_SEED";
+
+/// The number of bytes in a mebibyte.
+const MEBIBYTE: u64 = 1024 * 1024;
+
+/// The number of bytes in a kibibyte.
+const KIBIBYTE: u64 = 1024;
+
 pub trait LanguageHandler {
-    /// Creates a new `LanguageHandler`.
-    fn new(temp_dir: PathBuf) -> Self;
+    /// Creates a new `LanguageHandler`, which executes in the sandbox configured for its language.
+    fn new(temp_dir: PathBuf, config: &Config) -> Self
+    where
+        Self: Sized;
 
     /// Gets a reference to the temporary working directory of the current `LanguageHandler`.
     fn dir(&self) -> &PathBuf;
@@ -36,128 +126,1889 @@ pub trait LanguageHandler {
     ///
     /// The test cases are inserted in place of the value in [`TEST_CASES_TARGET`].
     ///
-    /// The output file path is inserted in place of the value in [`OUTPUT_FILE_PATH_TARGET`].
+    /// The output directory path is inserted in place of the value in [`OUTPUT_DIR_PATH_TARGET`].
     ///
     /// The solution is inserted in place of the value in [`SOLUTION_TARGET`].
     fn base_test_code(&self) -> &str;
 
     /// Generates the language specific test cases.
-    fn generate_test_cases(&self, test_cases: &[TestCase]) -> String;
+    ///
+    /// Fails with [`CheckError::UnsupportedTestCase`] if a test case cannot be expressed in the language.
+    fn generate_test_cases(&self, test_cases: &[TestCase]) -> Result<String, CheckError>;
 
     /// Formats a parameter to the necessary language specific syntax.
     fn format_parameter(&self, parameter: &Parameter) -> String;
 
-    /// Runs the submission against the test cases.
+    /// Gets the compiler of the language, whose program and flags are part of the key of cached compilations.
+    fn compiler(&self) -> &Compiler;
+
+    /// Gets the modules the solution imports, or the headers it includes, as they are named in the configured
+    /// allowed and blocked imports.
+    fn imports(&self, solution: &str) -> Vec<String>;
+
+    /// Compiles the test file along with the other files of the solution, returning the output of the compiler.
+    ///
+    /// The sources are the paths of the files of the solution within the temporary directory, of which the compiler is
+    /// given those it cannot find by itself.
+    ///
+    /// If the programming language is interpreted, then this step should at least check the syntax of the test file.
+    fn compile(&self, sources: &[PathBuf]) -> Result<String, CheckError>;
+
+    /// Gets how the compiler prints the problems it finds, so they can be parsed from the output of the compiler.
+    fn diagnostic_format(&self) -> Format;
+
+    /// Gets the names of the files in the temporary directory which are produced by compiling, and needed to run the
+    /// submission, so that they can be cached.
     ///
-    /// If the programming language is compiled, then this step **also** includes compilation of the source code.
-    fn run(&self) -> Result<(), CheckError>;
+    /// Interpreted languages produce no artifacts, in which case only the output of the compiler is cached.
+    fn artifacts(&self) -> Result<Vec<String>, CheckError>;
+
+    /// Gets the sandbox which the language executes in.
+    fn sandbox(&self) -> &Sandbox;
+
+    /// Gets the program and arguments which run the compiled submission against the test case at the given index.
+    ///
+    /// The index is passed to the test program as its last argument.
+    fn run_command(&self, index: usize, test_case: &TestCase) -> Vec<String>;
+
+    /// Removes the files produced by compiling and running the submission.
+    fn cleanup(&self) -> Result<(), CheckError>;
+}
+
+/// The compiler, or interpreter, of a language as it is configured, along with the flags it is invoked with.
+pub struct Compiler {
+    program: String,
+    /// The default flags of the language, followed by the configured flags.
+    flags: Vec<String>,
+    /// How long compiling may take, beyond which the solution is considered failed to compile.
+    timeout: Duration,
+}
+
+impl Compiler {
+    /// Creates the compiler of the language, where the configured compiler replaces the default program.
+    fn new(config: &Config, language: Language, program: &str, flags: &[&str]) -> Self {
+        let language = config.language(language);
+
+        Self {
+            program: language
+                .compiler
+                .clone()
+                .unwrap_or_else(|| program.to_string()),
+            flags: flags
+                .iter()
+                .map(|flag| flag.to_string())
+                .chain(language.flags.iter().cloned())
+                .collect(),
+            timeout: config.compile_timeout(),
+        }
+    }
+
+    pub fn program(&self) -> &str {
+        &self.program
+    }
+
+    pub fn flags(&self) -> Vec<&str> {
+        self.flags.iter().map(String::as_str).collect()
+    }
+
+    fn timeout(&self) -> Duration {
+        self.timeout
+    }
+}
+
+/// The defaults of the toolchain of a language, which its configuration overrides.
+struct Toolchain {
+    image: &'static str,
+    compiler: &'static str,
+    flags: &'static [&'static str],
+    /// The arguments which make the compiler print its version.
+    version_args: &'static [&'static str],
+    /// The command analyzing solutions, followed by the path of the test file, which is empty without a linter.
+    linter: &'static [&'static str],
+    /// The extensions of the source files of a solution, whose imports are restricted like those of the solution.
+    extensions: &'static [&'static str],
+}
+
+/// Gets the defaults of the toolchain of the language, if support for the language is compiled in.
+fn toolchain(language: Language) -> Option<Toolchain> {
+    let toolchain = match language {
+        #[cfg(feature = "haskell")]
+        Language::Haskell => haskell::TOOLCHAIN,
+        #[cfg(feature = "python")]
+        Language::Python => python::TOOLCHAIN,
+        #[cfg(feature = "go")]
+        Language::Go => go::TOOLCHAIN,
+        #[cfg(feature = "c")]
+        Language::C => c::TOOLCHAIN,
+        #[cfg(feature = "java")]
+        Language::Java => java::TOOLCHAIN,
+        #[cfg(feature = "sql")]
+        Language::Sql => sql::TOOLCHAIN,
+        #[allow(unreachable_patterns)]
+        _ => return None,
+    };
+
+    Some(toolchain)
+}
+
+/// Gets the compiler of the language as it is configured, if support for the language is compiled in.
+pub fn compiler(language: Language, config: &Config) -> Option<Compiler> {
+    let toolchain = toolchain(language)?;
+
+    Some(Compiler::new(
+        config,
+        language,
+        toolchain.compiler,
+        toolchain.flags,
+    ))
+}
+
+/// Gets the sandbox of the language as it is configured, if support for the language is compiled in.
+pub fn sandbox(language: Language, config: &Config) -> Option<Sandbox> {
+    let toolchain = toolchain(language)?;
+
+    Some(Sandbox::new(config, language, toolchain.image))
+}
+
+/// Gets the config with the docker image of the language pinned to the digest, unless the language is not compiled in
+/// or is not run in docker.
+///
+/// The tag of the image is kept, which docker ignores once there is a digest, but which still tells what the image is.
+pub fn pin_image(language: Language, config: &Config, digest: &str) -> Option<Config> {
+    let Sandbox::Docker { image, .. } = sandbox(language, config)? else {
+        return None;
+    };
+    let repository = image
+        .split_once('@')
+        .map_or(image.as_str(), |(repository, _)| repository);
+
+    let mut config = config.clone();
+    config.languages.entry(language).or_default().image = Some(format!("{repository}@{digest}"));
+    Some(config)
+}
+
+/// Gets the command of the linter of the language as it is configured, which is empty if the language has no linter,
+/// if support for the language is compiled in.
+pub fn linter(language: Language, config: &Config) -> Option<Vec<String>> {
+    let toolchain = toolchain(language)?;
+
+    Some(
+        config
+            .language(language)
+            .linter
+            .clone()
+            .unwrap_or_else(|| toolchain.linter.iter().map(|arg| arg.to_string()).collect()),
+    )
+}
+
+/// Detects the version of the compiler of the language by running it in its sandbox, if support for the language is
+/// compiled in.
+pub fn detect_version(language: Language, config: &Config) -> Option<Result<String, CheckError>> {
+    let toolchain = toolchain(language)?;
+    let sandbox = Sandbox::new(config, language, toolchain.image);
+    let compiler = Compiler::new(config, language, toolchain.compiler, &[]);
+
+    let output = sandbox
+        .command(&config.work_dir, compiler.program(), toolchain.version_args)
+        .output();
+    let version = match output {
+        Ok(output) if output.status.success() => {
+            // some compilers print their version to the standard error
+            [output.stdout, output.stderr]
+                .iter()
+                .find_map(|output| parse_version(&String::from_utf8_lossy(output)))
+                .ok_or(CheckError::Sandbox)
+        }
+        Ok(_) => Err(CheckError::Sandbox),
+        Err(_) => Err(CheckError::IOInteraction),
+    };
+
+    Some(version)
+}
+
+/// Gets the version number from the output of a compiler, e.g. `3.12.1` from `Python 3.12.1`, or `1.23.1` from
+/// `go version go1.23.1 linux/amd64`.
+fn parse_version(output: &str) -> Option<String> {
+    let word = output
+        .split_whitespace()
+        .find(|word| word.contains(|c: char| c.is_ascii_digit()))?;
+
+    Some(
+        word.trim_start_matches(|c: char| !c.is_ascii_digit())
+            .to_string(),
+    )
+}
+
+/// Whether the version satisfies the pinned version, which pins every release it is a prefix of.
+pub fn satisfies(version: &str, pinned: &str) -> bool {
+    version
+        .strip_prefix(pinned)
+        .is_some_and(|rest| rest.is_empty() || rest.starts_with(['.', '-', '+']))
+}
+
+/// Gets the first import of the solution which is not allowed, or is blocked, by the configuration of its language.
+///
+/// The local imports name files of the solution itself, which are allowed whether or not they are among the allowed
+/// imports, but are still restricted by the blocked imports.
+///
+/// The imports are found in the source alone, so this restricts which modules a solution names rather than what it can
+/// do, which is up to the sandbox.
+fn restricted_import(
+    imports: &[String],
+    local: &[String],
+    config: &LanguageConfig,
+) -> Option<String> {
+    // a module covers its submodules, e.g. `os` covers `os.path`, and `net` covers `net/http`
+    let covers = |module: &str, import: &str| {
+        import
+            .strip_prefix(module)
+            .is_some_and(|rest| rest.is_empty() || rest.starts_with(['.', '/']))
+    };
+
+    imports
+        .iter()
+        .find(|import| {
+            let allowed = local.iter().any(|module| covers(module, import))
+                || config
+                    .allowed_imports
+                    .as_ref()
+                    .is_none_or(|allowed| allowed.iter().any(|module| covers(module, import)));
+            let blocked = config
+                .blocked_imports
+                .iter()
+                .any(|module| covers(module, import));
+
+            !allowed || blocked
+        })
+        .cloned()
+}
+
+/// Gets the names by which a solution imports its own files.
+///
+/// A file is named by its path, e.g. the header `util/strings.h`, or by its path as a module, e.g. `util.strings` for
+/// `util/strings.py`, and a directory by its path as a package, where go packages are named within the module of the
+/// go.mod of the solution.
+fn local_imports(files: &BTreeMap<String, String>) -> Vec<String> {
+    let module = files
+        .get("go.mod")
+        .and_then(|contents| files::decode(contents))
+        .and_then(|contents| {
+            String::from_utf8_lossy(&contents).lines().find_map(|line| {
+                let module = line.trim().strip_prefix("module")?.trim();
+                Some(module.trim_matches('"').to_string())
+            })
+        });
+
+    let mut imports = Vec::new();
+    for path in files.keys() {
+        imports.push(path.clone());
+        let stem = path
+            .rsplit_once('.')
+            .map_or(path.as_str(), |(stem, _)| stem);
+        imports.push(stem.replace('/', "."));
+        for (index, _) in path.match_indices('/') {
+            let dir = &path[..index];
+            imports.push(dir.replace('/', "."));
+            if let Some(module) = &module {
+                imports.push(format!("{module}/{dir}"));
+            }
+        }
+    }
+
+    imports.sort();
+    imports.dedup();
+    imports
 }
 
 pub struct TestRunner {
-    #[cfg(feature = "haskell")]
-    handler: Haskell,
+    language: Language,
+    handler: Box<dyn LanguageHandler>,
+    /// The time limit of test cases which do not specify one.
+    time_limit: Duration,
+    /// The memory limit in mebibytes of submissions which do not specify one.
+    memory_limit: u64,
+    /// The maximum memory limit in mebibytes which a submission may request.
+    max_memory_limit: u64,
+    /// The config which the sandbox of a checker is created from, as its language is only known from the submission.
+    config: Config,
+    /// The sandbox which runs the test cases in place of the sandbox of the language, e.g. a warm container.
+    test_sandbox: Option<Sandbox>,
+    /// Where the output of the test cases is streamed to as they run, e.g. for a debugging run.
+    sink: Option<Sink>,
+    /// How many test cases may not pass before the rest are skipped, e.g. for quick feedback.
+    max_failures: Option<usize>,
+    /// Where the artifacts of a successful compilation are archived, so they can be downloaded afterwards.
+    artifact_archive: Option<PathBuf>,
+    /// Where the transcripts of the interactive test cases are written, so they can be downloaded afterwards.
+    transcript: Option<PathBuf>,
+    /// The seed every test case is given, if any.
+    seed: Option<u64>,
+    /// Whether the test cases are the tests of a test suite, whose results are read from what its framework reports.
+    test_suite: bool,
+    /// The plugins which adjust the result once the test cases were judged.
+    plugins: VerdictPlugins,
 }
 
 impl TestRunner {
-    pub fn new(temp_dir: PathBuf) -> Self {
-        Self {
+    /// Creates a test runner for the given language, if support for the language is compiled in.
+    pub fn new(language: Language, temp_dir: PathBuf, config: &Config) -> Option<Self> {
+        let handler: Box<dyn LanguageHandler> = match language {
             #[cfg(feature = "haskell")]
-            handler: Haskell::new(temp_dir),
+            Language::Haskell => Box::new(Haskell::new(temp_dir, config)),
+            #[cfg(feature = "python")]
+            Language::Python => Box::new(Python::new(temp_dir, config)),
+            #[cfg(feature = "go")]
+            Language::Go => Box::new(Go::new(temp_dir, config)),
+            #[cfg(feature = "c")]
+            Language::C => Box::new(C::new(temp_dir, config)),
+            #[cfg(feature = "java")]
+            Language::Java => Box::new(Java::new(temp_dir, config)),
+            #[cfg(feature = "sql")]
+            Language::Sql => Box::new(Sql::new(temp_dir, config)),
+            #[allow(unreachable_patterns)]
+            _ => return None,
+        };
+
+        Some(Self {
+            language,
+            handler,
+            time_limit: config.time_limit(),
+            memory_limit: config.memory_limit,
+            max_memory_limit: config.max_memory_limit,
+            config: config.clone(),
+            test_sandbox: None,
+            sink: None,
+            max_failures: None,
+            artifact_archive: None,
+            transcript: None,
+            seed: None,
+            test_suite: false,
+            plugins: VerdictPlugins::default(),
+        })
+    }
+
+    /// Runs the test cases in the given sandbox rather than in the sandbox of the language, which still compiles the
+    /// submission, as its compiler is not subject to the seccomp profile of the sandbox.
+    pub fn run_in(self, sandbox: Sandbox) -> Self {
+        Self {
+            test_sandbox: Some(sandbox),
+            ..self
+        }
+    }
+
+    /// Streams the standard output and error of every test case to the sink while it runs, except for those of test
+    /// cases which converse with an interactor.
+    pub fn stream_to(self, sink: Sink) -> Self {
+        Self {
+            sink: Some(sink),
+            ..self
+        }
+    }
+
+    /// Adjusts the result of the submission with the plugins once its test cases were judged.
+    pub fn adjust_with(self, plugins: VerdictPlugins) -> Self {
+        Self { plugins, ..self }
+    }
+
+    /// Stops running test cases once the given number of them did not pass, so the test cases which have not started
+    /// yet are skipped.
+    pub fn stop_after(self, failures: usize) -> Self {
+        Self {
+            max_failures: Some(failures),
+            ..self
+        }
+    }
+
+    /// Gives every test case the seed in its environment, along with the checker and the interactor, so randomized test
+    /// code can be replayed with the same seed.
+    pub fn seed(self, seed: u64) -> Self {
+        Self {
+            seed: Some(seed),
+            ..self
+        }
+    }
+
+    /// Runs the tests of the test files of the submission as its test cases with the test framework of its language,
+    /// which runs them along with the solution, rather than generating test code from the parameters of the test cases.
+    pub fn test_suite(self) -> Self {
+        let handler: Option<Box<dyn LanguageHandler>> = match self.language {
+            #[cfg(feature = "go")]
+            Language::Go => Some(Box::new(GoTest::new(
+                self.handler.dir().clone(),
+                &self.config,
+            ))),
+            #[cfg(feature = "java")]
+            Language::Java => Some(Box::new(JUnit::new(
+                self.handler.dir().clone(),
+                &self.config,
+            ))),
+            #[cfg(feature = "python")]
+            Language::Python => Some(Box::new(Pytest::new(
+                self.handler.dir().clone(),
+                &self.config,
+            ))),
+            // submissions in other languages cannot run a test suite, so they are never valid
+            _ => None,
+        };
+
+        match handler {
+            Some(handler) => Self {
+                handler,
+                test_suite: true,
+                ..self
+            },
+            None => self,
+        }
+    }
+
+    /// Archives the artifacts of the compiled submission as a tar file at the given path once it compiles, replacing
+    /// the archive of an earlier check, which is removed if the submission does not compile.
+    pub fn export_artifacts_to(self, archive: PathBuf) -> Self {
+        Self {
+            artifact_archive: Some(archive),
+            ..self
         }
     }
 
-    pub fn check(self, submission: Submission) -> Result<Box<[TestCaseResult]>, CheckError> {
+    /// Records what the solution and the interactor write to each other during every test case, and writes the
+    /// transcripts as json to the given path once the test cases ran, replacing the transcripts of an earlier check.
+    pub fn record_transcript_to(self, path: PathBuf) -> Self {
+        Self {
+            transcript: Some(path),
+            ..self
+        }
+    }
+
+    /// Checks the submission, where a solution which fails to compile is a result rather than an error, along with the
+    /// diagnostics parsed from the output of the compiler.
+    ///
+    /// If the same test code has been compiled before, its cached artifacts are used instead of compiling again.
+    ///
+    /// The progress of compiling and running each test case is reported as it happens.
+    ///
+    /// A checker of the submission is compiled after the solution, and judges every test case whose output differs
+    /// from the expected output, in place of the comparison mode of the test case.
+    ///
+    /// Once the judgment is cancelled, the test cases which are running are killed and no further test case is run,
+    /// which fails with [`CheckError::Cancelled`]. Compiling is bounded by the compile timeout, so it is not cancelled.
+    ///
+    /// How long each stage took is part of the result, other than creating the workspace and removing it, which the
+    /// caller measures.
+    pub fn check(
+        self,
+        mut submission: Submission,
+        cache: &CompileCache,
+        cancellation: &Cancellation,
+        report: &dyn Fn(Progress),
+    ) -> Result<SubmissionResult, CheckError> {
+        let started = Instant::now();
+        // the blobs become files of the solution, so they are judged like every other file, and cached by their contents
+        let blobs = std::mem::take(&mut submission.blobs);
+        Blobs::from(&self.config).resolve(&blobs, &mut submission.files)?;
+
+        // a test suite without test cases runs every test it declares
+        if self.test_suite && submission.test_cases.is_empty() {
+            submission.test_cases = match self.language {
+                Language::Go => gotest::declared_tests(&submission.files),
+                language => junit::declared_tests(language, &submission.files),
+            };
+            if submission.test_cases.is_empty() {
+                return Err(CheckError::UnsupportedTestCase(String::from(
+                    "the test files declare no tests",
+                )));
+            }
+        }
+
+        // the solution is rejected like one which does not compile, as the compiler would be the one to resolve imports
+        if let Some(import) = self.restricted_import(&submission.solution, &submission.files) {
+            info!(import, "rejected restricted import");
+            return Ok(SubmissionResult {
+                timings: Some(Box::new(Timings {
+                    workspace: started.elapsed().as_millis() as u64,
+                    ..Timings::default()
+                })),
+                ..SubmissionResult::compilation_error(
+                    format!("the import {import} is not allowed"),
+                    Vec::new(),
+                )
+            });
+        }
+
+        // the files are laid out first, so a file of the solution could never replace one which mozart writes
+        let files = std::mem::take(&mut submission.files);
+        let sources = workspace::populate(self.handler.dir(), &files)?;
+        blob::protect(self.handler.dir(), blobs.keys())?;
+        if fault::injected(self.handler.dir(), Fault::WorkerCrash) {
+            panic!("injected worker crash");
+        }
+
         let Ok(mut test_file) = File::create(self.handler.test_file_path()) else {
             return Err(CheckError::IOInteraction);
         };
 
-        let mut output_file_path = self.handler.dir().clone();
-        output_file_path.push("output");
-        let Ok(mut output_file) = File::create_new(output_file_path.as_path()) else {
+        let mut output_dir_path = self.handler.dir().clone();
+        output_dir_path.push(OUTPUT_DIR);
+        if fs::create_dir(output_dir_path.as_path()).is_err() {
             return Err(CheckError::IOInteraction);
-        };
+        }
+
+        Fixtures::from(&self.config).provide(
+            submission.test_cases.iter().flat_map(TestCase::fixture_ids),
+            self.handler.dir(),
+        )?;
 
-        let output_file_path_str = output_file_path.to_str().expect(UUID_SHOULD_BE_VALID_STR);
+        let resources = Resources {
+            time: submission
+                .time_limit
+                .map(Duration::from_millis)
+                .unwrap_or(self.time_limit),
+            memory: self.memory_limit(submission.memory_limit),
+            processes: submission
+                .process_limit
+                .unwrap_or_else(|| self.config.process_limit(self.language)),
+            disk: submission
+                .disk_limit
+                .unwrap_or(self.config.disk_limit)
+                .min(self.config.max_disk_limit)
+                .saturating_mul(MEBIBYTE),
+        };
+        let analyze = submission.analyze;
+        let scoring = submission.scoring;
+        let groups = std::mem::take(&mut submission.groups);
+        let checker = submission.checker.take();
+        let checker_language = checker
+            .as_ref()
+            .and_then(|checker| checker.language)
+            .unwrap_or(self.language);
+        let interactor = submission.interactor.take();
+        let network = std::mem::take(&mut submission.network);
+        let benchmark = submission.benchmark.take();
+        let (setup, teardown) = (submission.setup.take(), submission.teardown.take());
+        let verdict_policy = submission
+            .verdict_policy
+            .take()
+            .unwrap_or_else(|| self.config.verdict_policy());
         let (solution, test_cases) = submission.into_inner();
-        let generated_test_cases = self.handler.generate_test_cases(&test_cases);
+        let generated_test_cases = self.handler.generate_test_cases(&test_cases)?;
 
         let final_test_code = self
             .handler
             .base_test_code()
             .replace(SOLUTION_TARGET, solution.as_str())
             .replace(TEST_CASES_TARGET, generated_test_cases.as_str())
-            .replace(OUTPUT_FILE_PATH_TARGET, output_file_path_str);
+            .replace(OUTPUT_DIR_PATH_TARGET, OUTPUT_DIR);
 
-        println!("{final_test_code}");
+        trace!(test_code = %final_test_code, "generated test code");
 
-        if test_file.write_all(final_test_code.as_bytes()).is_err() {
+        if fault::fill_disk(self.handler.dir())
+            .and_then(|_| test_file.write_all(final_test_code.as_bytes()))
+            .is_err()
+        {
             return Err(CheckError::IOInteraction);
         }
 
-        self.handler.run()?;
+        let compile_started = Instant::now();
+        let compiled = self.compile(&final_test_code, (&files, &sources), cache, report);
+        let mut timings = Timings {
+            workspace: (compile_started - started).as_millis() as u64,
+            compile: compile_started.elapsed().as_millis() as u64,
+            ..Timings::default()
+        };
+        if let Some(archive) = &self.artifact_archive {
+            self.export_artifacts(compiled.is_ok(), archive);
+        }
+
+        let transcripts = Mutex::new(BTreeMap::new());
+        let outcome = compiled.and_then(|compile_output| {
+            let analysis = match analyze {
+                true => self
+                    .analyze(
+                        &final_test_code,
+                        self.solution_lines(&solution, &generated_test_cases),
+                    )
+                    .map(Box::new),
+                false => None,
+            };
+            let judges_started = Instant::now();
+            let checker = checker
+                .map(|checker| {
+                    CheckerProgram::compile(
+                        checker_language,
+                        &checker.source,
+                        self.handler.dir().join(CHECKER_DIR),
+                        &self.config,
+                    )
+                })
+                .transpose()?;
+            let interactor = interactor
+                .map(|interactor| {
+                    InteractorProgram::compile(
+                        interactor.language.unwrap_or(self.language),
+                        &interactor.source,
+                        self.handler.dir().join(INTERACTOR_DIR),
+                        &self.config,
+                    )
+                })
+                .transpose()?;
+            timings.compile += judges_started.elapsed().as_millis() as u64;
+            for (name, hook) in [("setup", &setup), ("teardown", &teardown)] {
+                if let Some(hook) = hook {
+                    hook::write(self.handler.dir(), name, hook)?;
+                }
+            }
+            // everything is compiled by now, so the workspace is handed over to the user the solution runs as
+            if sandbox::hand_over(self.handler.dir(), self.config.sandbox_user()).is_err() {
+                return Err(CheckError::IOInteraction);
+            }
+            let tests_started = Instant::now();
+            let setup = setup
+                .map(|hook| self.run_hook("setup", &hook, &resources, &network, cancellation))
+                .transpose()?;
+            if let Some(setup) = setup.as_ref().filter(|setup| !setup.succeeded()) {
+                return Err(CheckError::Hook(hook::failure(setup)));
+            }
+            let (test_case_results, test_case_timings) = self.run_test_cases(
+                &test_cases,
+                &output_dir_path,
+                resources,
+                network.clone(),
+                Judges {
+                    checker: checker.as_ref(),
+                    interactor: interactor.as_ref(),
+                },
+                benchmark.as_ref(),
+                self.transcript.as_ref().map(|_| &transcripts),
+                cancellation,
+                report,
+            )?;
+            // the teardown only cleans up after the test cases, so its failure is left to the author to look into
+            let teardown = teardown
+                .map(|hook| self.run_hook("teardown", &hook, &resources, &network, cancellation))
+                .transpose()?;
+            timings.tests = tests_started.elapsed().as_millis() as u64;
+            timings.test_cases = test_case_timings;
+            let hooks = (setup.is_some() || teardown.is_some())
+                .then(|| Box::new(HookResults { setup, teardown }));
+            let result =
+                SubmissionResult::judged(compile_output, test_case_results, &verdict_policy);
+            // a solution which tried to escape the sandbox scores nothing, regardless of what it passed
+            let (score, groups) = match result.verdict {
+                Verdict::SecurityViolation => (0.0, Vec::new()),
+                _ => score::score(scoring, &groups, &test_cases, &result.test_case_results),
+            };
+
+            let result = SubmissionResult {
+                analysis,
+                score,
+                groups: groups.into(),
+                hooks,
+                ..result
+            };
+            // the plugins judge once everything else has, so they are given the result as it would be without them
+            self.plugins
+                .adjust(self.language, &solution, &test_cases, result)
+        });
+        let cleanup_started = Instant::now();
+        self.handler.cleanup()?;
+        debug!("cleaned up");
+        if let Some(path) = &self.transcript {
+            let test_cases = transcripts
+                .into_inner()
+                .expect("transcripts lock poisoned")
+                .into_values()
+                .collect();
+            write_transcript(&Transcript { test_cases }, path);
+        }
+        timings.cleanup = cleanup_started.elapsed().as_millis() as u64;
+
+        let outcome = match outcome {
+            Err(CheckError::Compilation(compile_output)) => {
+                let paths: Vec<&str> = files.keys().map(String::as_str).collect();
+                let diagnostics = diagnostics::parse(
+                    self.handler.diagnostic_format(),
+                    &compile_output,
+                    &self.test_file_name(),
+                    &paths,
+                    &final_test_code,
+                    self.solution_lines(&solution, &generated_test_cases),
+                );
+                Ok(SubmissionResult::compilation_error(
+                    compile_output,
+                    diagnostics,
+                ))
+            }
+            outcome => outcome,
+        };
+
+        outcome.map(|result| SubmissionResult {
+            timings: Some(Box::new(timings)),
+            ..result
+        })
+    }
+
+    /// Gets the first restricted import of the solution, or of one of its files with a source extension of the language.
+    fn restricted_import(
+        &self,
+        solution: &str,
+        files: &BTreeMap<String, String>,
+    ) -> Option<String> {
+        let extensions = toolchain(self.language).map_or(&[][..], |toolchain| toolchain.extensions);
+        let mut imports = self.handler.imports(solution);
+        for (path, contents) in files {
+            let is_source = Path::new(path)
+                .extension()
+                .and_then(|extension| extension.to_str())
+                .is_some_and(|extension| extensions.contains(&extension));
+            if let Some(contents) = is_source.then(|| files::decode(contents)).flatten() {
+                imports.extend(self.handler.imports(&String::from_utf8_lossy(&contents)));
+            }
+        }
+
+        restricted_import(
+            &imports,
+            &local_imports(files),
+            self.config.language(self.language),
+        )
+    }
 
-        let mut test_output = String::new();
-        if output_file.read_to_string(&mut test_output).is_err() {
+    /// Compiles the solution without any test cases, parsing the problems the compiler reports into diagnostics.
+    ///
+    /// The compilation is not cached, as nothing is run from it. A solution which fails to compile is a result rather
+    /// than an error, like when checking a submission.
+    pub fn compile_only(self, solution: &str) -> Result<CompileResult, CheckError> {
+        if let Some(import) = self.restricted_import(solution, &BTreeMap::new()) {
+            info!(import, "rejected restricted import");
+            return Ok(CompileResult {
+                compiled: false,
+                compile_output: format!("the import {import} is not allowed"),
+                diagnostics: Vec::new(),
+            });
+        }
+
+        let base_test_code = self.handler.base_test_code();
+        let generated_test_cases = self.handler.generate_test_cases(&[])?;
+        let test_code = base_test_code
+            .replace(SOLUTION_TARGET, solution)
+            .replace(TEST_CASES_TARGET, generated_test_cases.as_str())
+            .replace(OUTPUT_DIR_PATH_TARGET, OUTPUT_DIR);
+
+        let solution_lines = self.solution_lines(solution, &generated_test_cases);
+        if fs::write(self.handler.test_file_path(), &test_code).is_err() {
             return Err(CheckError::IOInteraction);
         }
 
-        let mut test_case_results = Vec::new();
-        for (index, line) in test_output.lines().enumerate() {
-            if line.trim().is_empty() {
-                // not correct error
-                return Err(CheckError::IOInteraction);
+        let compile_started = Instant::now();
+        let compiled = self.handler.compile(&[]);
+        let compile_time = compile_started.elapsed();
+        METRICS.compiled(compile_time);
+        self.handler.cleanup()?;
+
+        let (compiled, compile_output) = match compiled {
+            Ok(compile_output) => (true, compile_output),
+            Err(CheckError::Compilation(compile_output)) => (false, compile_output),
+            Err(err) => return Err(err),
+        };
+        info!(
+            duration_ms = compile_time.as_millis() as u64,
+            success = compiled,
+            "compiled solution"
+        );
+
+        let diagnostics = diagnostics::parse(
+            self.handler.diagnostic_format(),
+            &compile_output,
+            &self.test_file_name(),
+            &[],
+            &test_code,
+            solution_lines,
+        );
+
+        Ok(CompileResult {
+            compiled,
+            compile_output,
+            diagnostics,
+        })
+    }
+
+    /// Analyzes the test code with the linter of the language, keeping the problems within the solution.
+    ///
+    /// The analysis is left out if the language has no linter, or the linter cannot be run, e.g. as it is not installed
+    /// in the sandbox, which only affects the analysis rather than the submission.
+    fn analyze(&self, test_code: &str, solution_lines: Range<usize>) -> Option<Analysis> {
+        let linter = linter(self.language, &self.config)?;
+        let (program, args) = linter.split_first()?;
+        let sandbox = sandbox(self.language, &self.config)?;
+
+        // linters keep their caches in the home directory, which may not be writable in the sandbox
+        let dir = self.handler.dir();
+        let home = format!("HOME={}", dir.display());
+        let cache = format!("XDG_CACHE_HOME={}", dir.join(".cache").display());
+        let test_file_path = self.handler.test_file_path();
+        let test_file_str = test_file_path.to_str().expect(UUID_SHOULD_BE_VALID_STR);
+        let args = [
+            vec![home.as_str(), cache.as_str(), program.as_str()],
+            args.iter().map(String::as_str).collect(),
+            vec![test_file_str],
+        ]
+        .concat();
+
+        let linter = linter.join(" ");
+        let output = match sandbox.output_within(dir, "env", &args, self.config.compile_timeout()) {
+            // env exits with 126 or 127 if it cannot execute the linter, like docker does
+            Ok(Some(output)) if !matches!(output.status.code(), Some(126 | 127)) => output,
+            Ok(Some(_)) => {
+                warn!(linter, "failed to run the linter");
+                return None;
+            }
+            Ok(None) => {
+                warn!(linter, "the linter took longer than the compile timeout");
+                return None;
+            }
+            Err(err) => {
+                warn!(linter, %err, "failed to run the linter");
+                return None;
             }
+        };
 
-            let test_case = &test_cases[index];
+        let mut lint_output = String::from_utf8_lossy(&output.stdout).into_owned();
+        lint_output.push_str(&String::from_utf8_lossy(&output.stderr));
+        let diagnostics: Vec<Diagnostic> = diagnostics::parse(
+            Format::Gnu,
+            &lint_output,
+            &self.test_file_name(),
+            &[],
+            test_code,
+            solution_lines,
+        )
+        .into_iter()
+        .filter(|diagnostic| diagnostic.line.is_some())
+        .map(|diagnostic| Diagnostic {
+            severity: match diagnostic.severity {
+                Severity::Note => Severity::Note,
+                Severity::Error | Severity::Warning => Severity::Warning,
+            },
+            ..diagnostic
+        })
+        .collect();
+        debug!(linter, diagnostics = diagnostics.len(), "analyzed solution");
 
-            let mut split = line.split(',');
-            let result = match split.next().expect("line is not empty") {
-                "p" => TestCaseResult {
-                    id: test_case.id,
-                    test_result: TestResult::Pass,
-                },
-                "f" => {
-                    let (Some(actual), Some(expected)) = (split.next(), split.next()) else {
-                        // not correct error type
-                        return Err(CheckError::IOInteraction);
+        Some(Analysis {
+            linter,
+            diagnostics,
+        })
+    }
+
+    /// Gets the lines of the test code which the solution spans, which starts on its own line.
+    fn solution_lines(&self, solution: &str, generated_test_cases: &str) -> Range<usize> {
+        let before_solution = self
+            .handler
+            .base_test_code()
+            .split(SOLUTION_TARGET)
+            .next()
+            .unwrap_or_default()
+            .replace(TEST_CASES_TARGET, generated_test_cases);
+        let first_line = before_solution.matches('\n').count() + 1;
+
+        first_line..first_line + solution.lines().count().max(1)
+    }
+
+    fn test_file_name(&self) -> String {
+        self.handler
+            .test_file_path()
+            .file_name()
+            .map(|name| name.to_string_lossy().into_owned())
+            .unwrap_or_default()
+    }
+
+    /// Compiles the test code along with the files of the solution, or restores its artifacts from the cache,
+    /// returning the output of the compiler.
+    ///
+    /// Only successful compilations are cached, as failed ones have nothing to run.
+    fn compile(
+        &self,
+        test_code: &str,
+        (files, sources): (&BTreeMap<String, String>, &[PathBuf]),
+        cache: &CompileCache,
+        report: &dyn Fn(Progress),
+    ) -> Result<String, CheckError> {
+        let compiler = self.handler.compiler();
+        let flags = [vec![compiler.program()], compiler.flags()].concat();
+        let image = match self.handler.sandbox() {
+            Sandbox::Docker { image, .. } => Some(image.as_str()),
+            Sandbox::Host | Sandbox::Dev | Sandbox::Warm { .. } | Sandbox::Fake => None,
+        };
+        let key = CompileCache::key(self.language, image, &flags, test_code, files);
+        if let Some(compile_output) = cache.restore(&key, self.handler.dir()) {
+            debug!(key, "restored cached compilation");
+            report(Progress::Compiled { cached: true });
+            return Ok(compile_output);
+        }
+
+        report(Progress::Compiling);
+        let compile_started = Instant::now();
+        let compiled = info_span!("compile").in_scope(|| self.handler.compile(sources));
+        let compile_time = compile_started.elapsed();
+        METRICS.compiled(compile_time);
+        info!(
+            duration_ms = compile_time.as_millis() as u64,
+            success = !matches!(compiled, Err(CheckError::Compilation(_))),
+            "compiled submission"
+        );
+
+        let compile_output = compiled?;
+        report(Progress::Compiled { cached: false });
+        cache.store(
+            &key,
+            self.handler.dir(),
+            &self.handler.artifacts()?,
+            &compile_output,
+        );
+
+        Ok(compile_output)
+    }
+
+    /// Archives the artifacts of the compiled submission, or removes an earlier archive if it did not compile.
+    ///
+    /// Failing to archive the artifacts is not an error, as they are not part of the result.
+    fn export_artifacts(&self, compiled: bool, archive: &Path) {
+        let artifacts = match compiled {
+            true => self.handler.artifacts(),
+            false => Ok(Vec::new()),
+        };
+        let exported = artifacts
+            .map_err(|err| std::io::Error::other(err.to_string()))
+            .and_then(|artifacts| artifact::export(self.handler.dir(), &artifacts, archive));
+        if let Err(err) = exported {
+            warn!(%err, archive = %archive.display(), "failed to archive the compiled artifacts");
+        }
+    }
+
+    /// Runs the setup or teardown script of the given name in the sandbox of the test cases, with their limits but for
+    /// its own time limit.
+    ///
+    /// The script may create processes even if the test cases may not, as a shell script hardly does anything without.
+    fn run_hook(
+        &self,
+        name: &str,
+        hook: &Hook,
+        resources: &Resources,
+        network: &NetworkPolicy,
+        cancellation: &Cancellation,
+    ) -> Result<HookResult, CheckError> {
+        let seccomp = match self.config.language(self.language).seccomp {
+            SeccompProfile::Unfiltered => SeccompProfile::Unfiltered,
+            _ => SeccompProfile::NoNetwork,
+        };
+        let limits = Limits {
+            time: hook
+                .time_limit
+                .map(Duration::from_millis)
+                .unwrap_or(resources.time),
+            memory: resources.memory,
+            disk: resources.disk,
+            output: usize::try_from(self.config.output_limit.saturating_mul(KIBIBYTE))
+                .unwrap_or(usize::MAX),
+            output_tail: usize::try_from(self.config.output_tail_limit.saturating_mul(KIBIBYTE))
+                .unwrap_or(usize::MAX),
+            seccomp,
+            processes: resources.processes,
+            network: network.clone(),
+            cancellation: cancellation.clone(),
+            env: self
+                .seed
+                .map(|seed| (SEED_ENV.to_string(), seed.to_string()))
+                .into_iter()
+                .collect(),
+            work_dir: None,
+            user: self.config.sandbox_user(),
+        };
+        let sandbox = self
+            .test_sandbox
+            .as_ref()
+            .unwrap_or_else(|| self.handler.sandbox());
+
+        let result = hook::run(sandbox, self.handler.dir(), name, &limits)?;
+        debug!(
+            name,
+            exit_code = result.exit_code,
+            runtime = result.runtime,
+            "ran script"
+        );
+        Ok(result)
+    }
+
+    /// Runs every test case in a separate execution, so each test case is subject to its own limits.
+    ///
+    /// Up to the configured parallelism of test cases run at the same time, each on a thread of its own, while their
+    /// progress is reported from the calling thread as they start and finish. The results are in the order of the test
+    /// cases either way, and the first error stops every test case which has not started yet, as does the last failure
+    /// the runner allows.
+    ///
+    /// How long judging each test case took is returned along with the results, leaving out those which were skipped.
+    #[allow(clippy::too_many_arguments)]
+    fn run_test_cases(
+        &self,
+        test_cases: &[TestCase],
+        output_dir_path: &Path,
+        resources: Resources,
+        network: NetworkPolicy,
+        judges: Judges,
+        benchmark: Option<&Benchmark>,
+        transcripts: Option<&Transcripts>,
+        cancellation: &Cancellation,
+        report: &dyn Fn(Progress),
+    ) -> Result<(Box<[TestCaseResult]>, Vec<TestCaseTiming>), CheckError> {
+        let total = test_cases.len();
+        // the language handler stays on the calling thread, so everything the test cases need is gathered up front
+        let commands: Vec<Vec<String>> = test_cases
+            .iter()
+            .enumerate()
+            .map(|(index, test_case)| self.handler.run_command(index, test_case))
+            .collect();
+        let run = TestCaseRun {
+            sandbox: self
+                .test_sandbox
+                .as_ref()
+                .unwrap_or_else(|| self.handler.sandbox()),
+            dir: self.handler.dir(),
+            output_dir_path,
+            time_limit: resources.time,
+            memory_limit: resources.memory,
+            disk_limit: resources.disk,
+            seccomp: self.config.language(self.language).seccomp,
+            processes: resources.processes,
+            network,
+            config: &self.config,
+            judges,
+            cancellation,
+            sink: self.sink.as_ref(),
+            seed: self.seed,
+            benchmark,
+            transcripts,
+            test_suite: self.test_suite.then_some(self.language),
+        };
+
+        let next = AtomicUsize::new(0);
+        let failed = AtomicBool::new(false);
+        let (sender, receiver) = mpsc::channel();
+        // benchmarked test cases run one at a time, so their runtimes are not skewed by each other
+        let parallelism = match benchmark {
+            Some(_) => 1,
+            None => self.config.test_case_parallelism.clamp(1, total.max(1)),
+        };
+        let failures = AtomicUsize::new(0);
+        let max_failures = self.max_failures.unwrap_or(usize::MAX);
+        // spans are per thread, so the threads running test cases continue the span of the judgment explicitly
+        let span = Span::current();
+
+        thread::scope(|scope| {
+            for _ in 0..parallelism {
+                let sender = sender.clone();
+                let (next, failed, failures, run, commands, span) =
+                    (&next, &failed, &failures, &run, &commands, &span);
+                scope.spawn(move || loop {
+                    let index = next.fetch_add(1, Ordering::Relaxed);
+                    let Some(test_case) = test_cases.get(index) else {
+                        return;
                     };
+                    if failed.load(Ordering::Relaxed)
+                        || failures.load(Ordering::Relaxed) >= max_failures
+                    {
+                        return;
+                    }
+
+                    let _ = sender.send((index, None));
+                    let started = Instant::now();
+                    let result = span.in_scope(|| {
+                        info_span!("test case", id = test_case.id)
+                            .in_scope(|| run.judge(index, test_case, &commands[index]))
+                    });
+                    match &result {
+                        Ok(test_case_result)
+                            if test_case_result.test_result != TestResult::Pass =>
+                        {
+                            failures.fetch_add(1, Ordering::Relaxed);
+                        }
+                        Ok(_) => {}
+                        Err(_) => {
+                            failed.store(true, Ordering::Relaxed);
+                        }
+                    }
+                    let _ = sender.send((index, Some((result, started.elapsed()))));
+                });
+            }
+            drop(sender);
+
+            let mut test_case_results: Vec<Option<TestCaseResult>> = vec![None; total];
+            let mut elapsed = vec![None; total];
+            let mut error = None;
+            for (index, result) in receiver {
+                let id = test_cases[index].id;
+                match result {
+                    None => report(Progress::Running {
+                        index: index + 1,
+                        total,
+                        id,
+                    }),
+                    Some((Ok(test_case_result), judged)) => {
+                        report(Progress::Ran {
+                            index: index + 1,
+                            total,
+                            id,
+                            passed: matches!(test_case_result.test_result, TestResult::Pass),
+                        });
+                        test_case_results[index] = Some(test_case_result);
+                        elapsed[index] = Some(judged.as_millis() as u64);
+                    }
+                    Some((Err(err), _)) => {
+                        error.get_or_insert(err);
+                    }
+                }
+            }
+
+            match error {
+                Some(err) => Err(err),
+                // only test cases after the last failure the runner allows are left out
+                None => Ok((
+                    test_case_results
+                        .into_iter()
+                        .zip(test_cases)
+                        .map(|(test_case_result, test_case)| {
+                            test_case_result.unwrap_or_else(|| TestCaseResult::skipped(test_case))
+                        })
+                        .collect(),
+                    elapsed
+                        .into_iter()
+                        .zip(test_cases)
+                        .filter_map(|(elapsed, test_case)| {
+                            elapsed.map(|elapsed| TestCaseTiming {
+                                id: test_case.id,
+                                elapsed,
+                            })
+                        })
+                        .collect(),
+                )),
+            }
+        })
+    }
+
+    /// Gets the memory limit in bytes, given the memory limit requested by a submission in mebibytes.
+    ///
+    /// The requested limit falls back to the configured memory limit, and is capped by the maximum memory limit.
+    fn memory_limit(&self, requested: Option<u64>) -> u64 {
+        requested
+            .unwrap_or(self.memory_limit)
+            .min(self.max_memory_limit)
+            .saturating_mul(MEBIBYTE)
+    }
+}
+
+/// The limits every test case of a submission runs with, unless a test case sets its own time limit.
+#[derive(Clone, Copy)]
+struct Resources {
+    time: Duration,
+    /// The memory limit in bytes.
+    memory: u64,
+    /// How many processes and threads a test case may have at once, where 0 is unlimited.
+    processes: u64,
+    /// How many bytes a test case may write into its workspace.
+    disk: u64,
+}
+
+/// The programs of a submission which judge its test cases besides comparing their output.
+struct Judges<'a> {
+    checker: Option<&'a CheckerProgram>,
+    interactor: Option<&'a InteractorProgram>,
+}
+
+/// The transcripts of the test cases which were run, by their index, where a test case which is run again replaces its
+/// transcript.
+type Transcripts = Mutex<BTreeMap<usize, TestCaseTranscript>>;
+
+/// Everything needed to run the test cases of a submission, which is shared by the threads running them.
+struct TestCaseRun<'a> {
+    sandbox: &'a Sandbox,
+    dir: &'a Path,
+    output_dir_path: &'a Path,
+    /// The time limit of test cases which do not specify one.
+    time_limit: Duration,
+    /// The memory limit of every test case in bytes.
+    memory_limit: u64,
+    /// How many bytes every test case may write into its workspace.
+    disk_limit: u64,
+    seccomp: SeccompProfile,
+    /// How many processes and threads every test case may have at once, where 0 is unlimited.
+    processes: u64,
+    /// What every test case may connect to over the network.
+    network: NetworkPolicy,
+    config: &'a Config,
+    judges: Judges<'a>,
+    cancellation: &'a Cancellation,
+    sink: Option<&'a Sink>,
+    seed: Option<u64>,
+    benchmark: Option<&'a Benchmark>,
+    /// Where the transcripts of the interactive test cases are kept, if they are recorded.
+    transcripts: Option<&'a Transcripts>,
+    /// The language of the test suite the test cases are the tests of, if they are.
+    test_suite: Option<Language>,
+}
+
+impl TestCaseRun<'_> {
+    /// Gets the environment of a test case, which has the seed on top of the variables of the test case.
+    fn env(&self, test_case: &TestCase) -> BTreeMap<String, String> {
+        let mut env = test_case.env.clone();
+        if let Some(seed) = self.seed {
+            env.insert(SEED_ENV.to_string(), seed.to_string());
+        }
+
+        env
+    }
+
+    /// Creates the working directory of a test case with the files of the test case, replacing the directory of an
+    /// earlier run of the test case.
+    ///
+    /// The output and fixture directories of the workspace are linked into it, as the test program refers to them by
+    /// their relative paths.
+    fn create_work_dir(&self, index: usize, test_case: &TestCase) -> Result<PathBuf, CheckError> {
+        let work_dir = self.dir.join(WORK_DIR).join(index.to_string());
+        let _ = fs::remove_dir_all(&work_dir);
+        let linked = fs::create_dir_all(&work_dir).and_then(|_| {
+            [OUTPUT_DIR, FIXTURE_DIR]
+                .into_iter()
+                .filter(|dir| self.dir.join(dir).is_dir())
+                .try_for_each(|dir| symlink(Path::new("../..").join(dir), work_dir.join(dir)))
+        });
+        if linked.is_err() {
+            return Err(CheckError::IOInteraction);
+        }
+        workspace::populate(&work_dir, &test_case.work_dir)?;
+        if sandbox::hand_over(&work_dir, self.config.sandbox_user()).is_err() {
+            return Err(CheckError::IOInteraction);
+        }
+
+        Ok(work_dir)
+    }
+
+    /// Runs the test case at the given index with the command running it, and judges its result, which is benchmarked
+    /// if the test case passed and the submission benchmarks it.
+    fn judge(
+        &self,
+        index: usize,
+        test_case: &TestCase,
+        command: &[String],
+    ) -> Result<TestCaseResult, CheckError> {
+        let result = self.run(index, test_case, command, self.sink)?;
+
+        match self.benchmark {
+            Some(benchmark)
+                if result.test_result == TestResult::Pass && benchmark.includes(test_case) =>
+            {
+                self.benchmark(index, test_case, command, benchmark, result)
+            }
+            _ => Ok(result),
+        }
+    }
+
+    /// Runs a test case which passed for the warmup and timed runs of the benchmark, without streaming their output,
+    /// and adds the runtimes and memory of the timed runs to its result.
+    ///
+    /// A run which does not pass ends the benchmark, and becomes the result of the test case, as the solution does not
+    /// pass reliably, e.g. as it is only just within the time limit.
+    fn benchmark(
+        &self,
+        index: usize,
+        test_case: &TestCase,
+        command: &[String],
+        benchmark: &Benchmark,
+        passed: TestCaseResult,
+    ) -> Result<TestCaseResult, CheckError> {
+        let mut runtimes = Vec::with_capacity(benchmark.runs);
+        let mut memory = Vec::with_capacity(benchmark.runs);
+        for run in 0..benchmark.warmup + benchmark.runs {
+            let result = self.run(index, test_case, command, None)?;
+            if result.test_result != TestResult::Pass {
+                debug!(
+                    test_case = test_case.id,
+                    run, "benchmarked test case did not pass"
+                );
+                return Ok(result);
+            }
+            if run >= benchmark.warmup {
+                runtimes.push(result.runtime);
+                memory.extend(result.memory);
+            }
+        }
+
+        let measured = memory.len() == runtimes.len();
+        let summary = BenchmarkResult::of(&mut runtimes, measured.then_some(&mut memory[..]));
+        debug!(
+            test_case = test_case.id,
+            median_runtime_ms = summary.median_runtime,
+            "benchmarked test case"
+        );
+
+        Ok(TestCaseResult {
+            benchmark: Some(summary),
+            ..passed
+        })
+    }
+
+    /// Runs the test case at the given index with the command running it, streaming its output to the sink, and
+    /// judges its result.
+    fn run(
+        &self,
+        index: usize,
+        test_case: &TestCase,
+        command: &[String],
+        sink: Option<&Sink>,
+    ) -> Result<TestCaseResult, CheckError> {
+        if self.cancellation.is_cancelled() {
+            return Err(CheckError::Cancelled);
+        }
+        let output_file_path = self.output_dir_path.join(index.to_string());
+        // the output of an earlier run would otherwise be read if the test program has no output this time
+        if let Err(err) = fs::remove_file(&output_file_path) {
+            if err.kind() != ErrorKind::NotFound {
+                return Err(CheckError::IOInteraction);
+            }
+        }
+
+        let limits = Limits {
+            time: test_case
+                .time_limit
+                .map(Duration::from_millis)
+                .unwrap_or(self.time_limit),
+            memory: self.memory_limit,
+            disk: self.disk_limit,
+            output: usize::try_from(self.config.output_limit.saturating_mul(KIBIBYTE))
+                .unwrap_or(usize::MAX),
+            output_tail: usize::try_from(self.config.output_tail_limit.saturating_mul(KIBIBYTE))
+                .unwrap_or(usize::MAX),
+            seccomp: self.seccomp,
+            processes: self.processes,
+            network: self.network.clone(),
+            cancellation: self.cancellation.clone(),
+            env: self.env(test_case),
+            work_dir: match test_case.work_dir.is_empty() {
+                true => None,
+                false => Some(self.create_work_dir(index, test_case)?),
+            },
+            user: self.config.sandbox_user(),
+        };
 
-                    TestCaseResult {
-                        id: test_case.id,
-                        test_result: TestResult::Failure(TestCaseFailureReason::WrongAnswer {
-                            input_parameters: test_case.input_parameters.clone(),
-                            actual: actual.to_string(),
-                            expected: expected.to_string(),
+        let (execution, interaction_failure) = match self.judges.interactor {
+            Some(interactor) => {
+                let recording = self
+                    .transcripts
+                    .map(|_| Arc::new(Recording::new(limits.output)));
+                let interaction = interactor.interact(
+                    index,
+                    test_case,
+                    Solution {
+                        sandbox: self.sandbox,
+                        dir: self.dir,
+                        command,
+                    },
+                    &limits,
+                    self.config.idle_limit(),
+                    recording.as_ref(),
+                )?;
+                if let Some((transcripts, recording)) = self.transcripts.zip(recording) {
+                    let (messages, truncated) = Arc::into_inner(recording)
+                        .expect("the interaction is over")
+                        .finish();
+                    transcripts
+                        .lock()
+                        .expect("transcripts lock poisoned")
+                        .insert(
+                            index,
+                            TestCaseTranscript {
+                                id: test_case.id,
+                                messages,
+                                truncated,
+                            },
+                        );
+                }
+                interaction
+            }
+            None => {
+                let (program, args) = command.split_first().expect("a run command is never empty");
+                let args: Vec<&str> = args.iter().map(String::as_str).collect();
+                let stdin = test_case
+                    .stdin
+                    .as_ref()
+                    .map(|id| self.dir.join(FIXTURE_DIR).join(id));
+                let stdin = stdin.as_deref();
+                let execution = match sink {
+                    Some(sink) => self
+                        .sandbox
+                        .stream(self.dir, program, &args, stdin, &limits, sink)?,
+                    None => self
+                        .sandbox
+                        .execute(self.dir, program, &args, stdin, &limits)?,
+                };
+                (execution, None)
+            }
+        };
+        METRICS.ran(execution.runtime);
+        let test_result = match execution.outcome {
+            _ if interaction_failure.is_some() => {
+                TestResult::Failure(interaction_failure.expect("the failure was checked"))
+            }
+            Outcome::TimedOut => TestResult::Failure(TestCaseFailureReason::TimeLimitExceeded),
+            Outcome::MemoryExceeded => {
+                TestResult::Failure(TestCaseFailureReason::MemoryLimitExceeded)
+            }
+            Outcome::SecurityViolation => {
+                TestResult::Failure(TestCaseFailureReason::SecurityViolation)
+            }
+            Outcome::OutputExceeded => {
+                TestResult::Failure(TestCaseFailureReason::OutputLimitExceeded)
+            }
+            Outcome::Exited(_) if self.test_suite == Some(Language::Go) => {
+                gotest::read_test_result(test_case, &output_file_path)?
+            }
+            Outcome::Exited(_) if self.test_suite.is_some() => {
+                junit::read_test_result(&output_file_path)?
+            }
+            Outcome::Exited(_) => {
+                match (
+                    read_test_result(test_case, &output_file_path, self.config.diff_limit())?,
+                    self.judges.checker,
+                ) {
+                    // a checker judges text, so output which is not text is only ever compared byte for byte
+                    (
+                        TestResult::Failure(TestCaseFailureReason::WrongAnswer {
+                            actual,
+                            expected,
+                            encoding: None,
+                            ..
                         }),
+                        Some(checker),
+                    ) if checker.check(index, test_case, &actual, &expected, &limits)? => {
+                        TestResult::Pass
                     }
+                    (test_result, _) => test_result,
                 }
+            }
+        };
+
+        debug!(
+            test_case = test_case.id,
+            passed = matches!(test_result, TestResult::Pass),
+            runtime_ms = execution.runtime.as_millis() as u64,
+            "ran test case"
+        );
+
+        Ok(TestCaseResult {
+            id: test_case.id,
+            name: test_case.name.clone(),
+            hidden: test_case.hidden,
+            stderr: execution.stderr,
+            runtime: execution.runtime.as_millis() as u64,
+            memory: execution.peak_memory,
+            user_time: execution
+                .cpu_time
+                .map(|cpu_time| cpu_time.user.as_millis() as u64),
+            system_time: execution
+                .cpu_time
+                .map(|cpu_time| cpu_time.system.as_millis() as u64),
+            cause: match test_result {
+                TestResult::Failure(TestCaseFailureReason::RuntimeError)
+                    if execution.exceeded_process_limit =>
+                {
+                    Some(RuntimeErrorCause::ProcessLimitExceeded)
+                }
+                _ => None,
+            },
+            test_result,
+            benchmark: None,
+            attachments: BTreeMap::new(),
+        })
+    }
+}
+
+/// Writes the transcripts of a check as json, or removes those of an earlier check if no test case was recorded, e.g. as
+/// the solution did not compile.
+///
+/// Failing to write the transcripts is not an error, as they are not part of the result.
+fn write_transcript(transcript: &Transcript, path: &Path) {
+    let written = match transcript.test_cases.is_empty() {
+        true => match fs::remove_file(path) {
+            Err(err) if err.kind() != ErrorKind::NotFound => Err(err),
+            _ => Ok(()),
+        },
+        false => path
+            .parent()
+            .map_or(Ok(()), fs::create_dir_all)
+            .and_then(|_| {
+                let json = serde_json::to_vec(transcript).expect("a transcript always serializes");
+                fs::write(path, json)
+            }),
+    };
+    if let Err(err) = written {
+        warn!(%err, path = %path.display(), "failed to write the transcripts");
+    }
+}
+
+/// Reads the result of a test case from its output file.
+///
+/// A missing output file means that the test case caused a runtime error before the result could be written. A
+/// wrong answer still passes if the printed values match in the comparison mode of the test case, and otherwise has
+/// a diff of up to `diff_limit` bytes, where zero leaves it out.
+///
+/// The values are read as bytes, as a solution may print anything. Values which are not both text can only match
+/// byte for byte, and are base64 in the wrong answer, so the result can still be encoded as json.
+fn read_test_result(
+    test_case: &TestCase,
+    output_file_path: &Path,
+    diff_limit: usize,
+) -> Result<TestResult, CheckError> {
+    let mut test_output = Vec::new();
+    match File::open(output_file_path) {
+        Ok(mut output_file) => {
+            if output_file.read_to_end(&mut test_output).is_err() {
+                return Err(CheckError::IOInteraction);
+            }
+        }
+        Err(err) if err.kind() == ErrorKind::NotFound => {
+            return Ok(TestResult::Failure(TestCaseFailureReason::RuntimeError));
+        }
+        Err(_) => return Err(CheckError::IOInteraction),
+    }
+
+    if test_output.is_empty() {
+        return Ok(TestResult::Failure(TestCaseFailureReason::RuntimeError));
+    }
+    let line = test_output
+        .split(|byte| *byte == b'\n')
+        .next()
+        .expect("split always yields at least once");
+    let line = line.strip_suffix(b"\r").unwrap_or(line);
+
+    let mut split = line.split(|byte| *byte == b',');
+    let test_result = match split.next().expect("split always yields at least once") {
+        b"p" => TestResult::Pass,
+        b"f" => {
+            let (Some(actual), Some(expected)) = (split.next(), split.next()) else {
                 // not correct error type
-                _ => return Err(CheckError::IOInteraction),
+                return Err(CheckError::IOInteraction);
             };
 
-            test_case_results.push(result);
-        }
+            let (actual, expected, diff, encoding) = match (text(actual), text(expected)) {
+                (Some(actual), Some(expected)) => {
+                    let epsilon = test_case.epsilon.unwrap_or(DEFAULT_EPSILON);
+                    if test_case.comparison.matches(actual, expected, epsilon) {
+                        return Ok(TestResult::Pass);
+                    }
+                    let diff = (diff_limit > 0).then(|| diff::diff(expected, actual, diff_limit));
 
-        // extrapolating that a testcase caused a runtime error
-        if test_case_results.len() != test_cases.len() {
-            let index = test_case_results.len();
-            let test_case = &test_cases[index];
-            let result = TestCaseResult {
-                id: test_case.id,
-                test_result: TestResult::Failure(TestCaseFailureReason::RuntimeError),
+                    (actual.to_string(), expected.to_string(), diff, None)
+                }
+                // every comparison mode compares text, so anything else has to be the same bytes
+                _ if actual == expected => return Ok(TestResult::Pass),
+                _ => (
+                    files::encode(actual),
+                    files::encode(expected),
+                    None,
+                    Some(Encoding::Base64),
+                ),
             };
-            test_case_results.push(result);
+
+            TestResult::Failure(TestCaseFailureReason::WrongAnswer {
+                input_parameters: test_case.input_parameters.clone(),
+                actual,
+                expected,
+                diff,
+                encoding,
+            })
         }
+        // not correct error type
+        _ => return Err(CheckError::IOInteraction),
+    };
 
-        // handling the remaining test cases which are considered unknown (were not run)
-        for test_case in test_cases.iter().skip(test_cases.len()) {
-            let result = TestCaseResult {
-                id: test_case.id,
-                test_result: TestResult::Unknown,
-            };
-            test_case_results.push(result);
+    Ok(test_result)
+}
+
+/// Gets an output as text, unless it is not valid utf-8, or has a nul byte, which no text printed by a test program has.
+fn text(output: &[u8]) -> Option<&str> {
+    std::str::from_utf8(output)
+        .ok()
+        .filter(|text| !text.contains('\0'))
+}
+
+/// Compiles with the given command in the sandbox, returning the output of the compiler.
+///
+/// Compilation is considered failed if the compiler exits unsuccessfully, in which case its output is the reason, or if
+/// it takes longer than the timeout.
+fn compile_in(
+    sandbox: &Sandbox,
+    dir: &Path,
+    program: &str,
+    args: &[&str],
+    timeout: Duration,
+) -> Result<String, CheckError> {
+    if fault::injected(dir, Fault::SandboxStart) {
+        return Err(CheckError::Sandbox);
+    }
+    let output = match sandbox.output_within(dir, program, args, timeout) {
+        Ok(Some(output)) => output,
+        Ok(None) => {
+            return Err(CheckError::Compilation(format!(
+                "compiling took longer than {} seconds",
+                timeout.as_secs()
+            )))
+        }
+        Err(_) => return Err(CheckError::IOInteraction),
+    };
+
+    if sandbox.failed(
+        output.status.code(),
+        &String::from_utf8_lossy(&output.stderr),
+    ) {
+        return Err(CheckError::Sandbox);
+    }
+
+    let mut compile_output = String::from_utf8_lossy(&output.stderr).into_owned();
+    compile_output.push_str(&String::from_utf8_lossy(&output.stdout));
+
+    if !output.status.success() {
+        return Err(CheckError::Compilation(compile_output));
+    }
+
+    Ok(compile_output)
+}
+
+/// Removes the given files within the directory, ignoring files which do not exist.
+fn remove_files(dir: &Path, files: &[&str]) -> Result<(), CheckError> {
+    for file in files {
+        let path = dir.join(file);
+        if path.exists() && fs::remove_file(path).is_err() {
+            return Err(CheckError::IOInteraction);
         }
+    }
+
+    Ok(())
+}
+
+/// Gets the sources with the extension, as the arguments naming them to a compiler.
+fn sources_with<'a>(sources: &'a [PathBuf], extension: &str) -> Vec<&'a str> {
+    sources
+        .iter()
+        .filter(|path| path.extension().is_some_and(|ext| ext == extension))
+        .map(|path| path.to_str().expect(UUID_SHOULD_BE_VALID_STR))
+        .collect()
+}
+
+/// Quotes a value as a double quoted string literal, with the escape sequences shared by C-like languages.
+fn quote(value: &str) -> String {
+    let mut quoted = String::with_capacity(value.len() + 2);
+    quoted.push('"');
+    for c in value.chars() {
+        match c {
+            '"' => quoted.push_str("\\\""),
+            '\\' => quoted.push_str("\\\\"),
+            '\n' => quoted.push_str("\\n"),
+            '\r' => quoted.push_str("\\r"),
+            '\t' => quoted.push_str("\\t"),
+            c => quoted.push(c),
+        }
+    }
+    quoted.push('"');
+
+    quoted
+}
+
+/// Gets the single output parameter of a test case, for languages which cannot compare multiple return values.
+fn single_output(test_case: &TestCase) -> Result<&Parameter, CheckError> {
+    match test_case.output_parameters.as_ref() {
+        [output] => Ok(output),
+        _ => Err(CheckError::UnsupportedTestCase(format!(
+            "test case {} must have exactly one output parameter",
+            test_case.id
+        ))),
+    }
+}
+
+/// The value types shared by all statically typed languages.
+#[derive(Clone, Copy, PartialEq, Debug)]
+enum ValueType {
+    Int,
+    Float,
+    Bool,
+    Char,
+    String,
+}
+
+impl ValueType {
+    fn of(parameter: &Parameter) -> Result<Self, CheckError> {
+        match parameter.value_type.as_str() {
+            "int" | "integer" => Ok(Self::Int),
+            "float" | "double" => Ok(Self::Float),
+            "bool" | "boolean" => Ok(Self::Bool),
+            "char" => Ok(Self::Char),
+            "string" => Ok(Self::String),
+            value_type => Err(CheckError::UnsupportedTestCase(format!(
+                "the value type {value_type} is not supported"
+            ))),
+        }
+    }
+}
+
+#[cfg(test)]
+mod helpers {
+    use super::{
+        local_imports, parse_version, pin_image, quote, read_test_result, restricted_import,
+        satisfies,
+    };
+    use crate::{
+        config::{Config, LanguageConfig, SandboxKind},
+        model::{Encoding, Language, TestCase, TestCaseFailureReason, TestResult},
+    };
+    use std::{collections::BTreeMap, env, fs};
+    use uuid::Uuid;
+
+    #[test]
+    fn quote_plain() {
+        assert_eq!(quote("hello"), r#""hello""#);
+    }
+
+    #[test]
+    fn quote_escapes() {
+        assert_eq!(quote("a\"b\\c\n"), r#""a\"b\\c\n""#);
+    }
+
+    #[test]
+    fn parse_versions() {
+        assert_eq!(parse_version("Python 3.12.1\n").as_deref(), Some("3.12.1"));
+        assert_eq!(
+            parse_version("go version go1.23.1 linux/amd64").as_deref(),
+            Some("1.23.1")
+        );
+        assert_eq!(parse_version("9.8.2").as_deref(), Some("9.8.2"));
+        assert_eq!(parse_version("unknown"), None);
+    }
+
+    #[test]
+    fn pinned_versions() {
+        assert!(satisfies("9.8.2", "9.8"));
+        assert!(satisfies("9.8", "9.8"));
+        assert!(!satisfies("9.10.1", "9.1"));
+        assert!(!satisfies("9.6.1", "9.8"));
+    }
+
+    #[test]
+    #[cfg(feature = "haskell")]
+    fn pinned_images() {
+        let digest = format!("sha256:{}", "0a".repeat(32));
+        let config = Config {
+            sandbox: SandboxKind::Docker,
+            ..Config::default()
+        };
+
+        let pinned =
+            pin_image(Language::Haskell, &config, &digest).expect("docker images can be pinned");
+        let repinned = pin_image(Language::Haskell, &pinned, &digest).unwrap();
+
+        assert_eq!(
+            pinned.image(Language::Haskell),
+            Some(format!("haskell:9.8@{digest}").as_str())
+        );
+        assert_eq!(
+            repinned.image(Language::Haskell),
+            pinned.image(Language::Haskell)
+        );
+        assert!(pin_image(Language::Haskell, &Config::default(), &digest).is_none());
+    }
+
+    #[test]
+    fn restricted_imports() {
+        let config = LanguageConfig {
+            allowed_imports: Some(vec![String::from("math"), String::from("os")]),
+            blocked_imports: vec![String::from("os.path")],
+            ..LanguageConfig::default()
+        };
+        let imports = |imports: &[&str]| imports.iter().map(|i| i.to_string()).collect::<Vec<_>>();
+
+        assert_eq!(
+            restricted_import(&imports(&["math", "os"]), &[], &config),
+            None
+        );
+        assert_eq!(
+            restricted_import(&imports(&["math", "os.path"]), &[], &config).as_deref(),
+            Some("os.path")
+        );
+        assert_eq!(
+            restricted_import(&imports(&["mathematics"]), &[], &config).as_deref(),
+            Some("mathematics")
+        );
+        assert_eq!(
+            restricted_import(&imports(&["subprocess"]), &[], &LanguageConfig::default()),
+            None
+        );
+    }
+
+    #[test]
+    fn binary_output() {
+        let test_case: TestCase =
+            serde_json::from_str(r#"{"id": 0, "inputParameters": [], "outputParameters": []}"#)
+                .unwrap();
+        let path = env::temp_dir().join(format!("mozart-output-{}", Uuid::new_v4()));
+        let read = |output: &[u8]| {
+            fs::write(&path, output).unwrap();
+            read_test_result(&test_case, &path, 100).map_err(|err| err.to_string())
+        };
+
+        let garbage = read(b"f,\xff\xfe,hello\n");
+        let nul = read(b"f,a\0b,a\0b\r\n");
+        let text = read(b"f,3,4\n");
+        let _ = fs::remove_file(&path);
+
+        assert!(garbage.is_ok_and(|result| result
+            == TestResult::Failure(TestCaseFailureReason::WrongAnswer {
+                input_parameters: Box::new([]),
+                actual: String::from("//4="),
+                expected: String::from("aGVsbG8="),
+                diff: None,
+                encoding: Some(Encoding::Base64),
+            })));
+        assert!(nul.is_ok_and(|result| result == TestResult::Pass));
+        assert!(text.is_ok_and(|result| matches!(
+            result,
+            TestResult::Failure(TestCaseFailureReason::WrongAnswer {
+                diff: Some(_),
+                encoding: None,
+                ..
+            })
+        )));
+    }
+
+    #[test]
+    fn local_imports_are_allowed() {
+        let config = LanguageConfig {
+            allowed_imports: Some(vec![String::from("math")]),
+            blocked_imports: vec![String::from("os")],
+            ..LanguageConfig::default()
+        };
+        let files = BTreeMap::from([
+            (
+                String::from("go.mod"),
+                String::from("bW9kdWxlIGV4YW1wbGUuY29tL3NvbHV0aW9uCg=="),
+            ),
+            (String::from("util/strings.py"), String::new()),
+            (String::from("os.py"), String::new()),
+        ]);
+        let local = local_imports(&files);
+        let imports = |imports: &[&str]| imports.iter().map(|i| i.to_string()).collect::<Vec<_>>();
 
-        Ok(test_case_results.into_boxed_slice())
+        assert!(local.contains(&String::from("example.com/solution/util")));
+        assert_eq!(
+            restricted_import(&imports(&["math", "util.strings", "util"]), &local, &config),
+            None
+        );
+        assert_eq!(
+            restricted_import(&imports(&["os"]), &local, &config).as_deref(),
+            Some("os")
+        );
     }
 }
@@ -0,0 +1,282 @@
+use super::{compile_in, SEED_ENV};
+use crate::{
+    config::{Config, SeccompProfile},
+    error::{CheckError, UUID_SHOULD_BE_VALID_STR},
+    model::{Language, NetworkPolicy},
+    sandbox::{Limits, Sandbox},
+};
+use std::{
+    fs,
+    path::{Path, PathBuf},
+};
+
+/// A program of a submission which takes part in judging it, such as its checker, compiled in its own directory.
+pub struct Program {
+    dir: PathBuf,
+    sandbox: Sandbox,
+    /// The program and arguments which run it, before the arguments of a single run.
+    command: Vec<String>,
+    /// The seccomp profile of the language of the program, which it is run with in place of that of the solution.
+    seccomp: SeccompProfile,
+}
+
+/// How a program in a language is compiled and run.
+struct Toolchain {
+    default_image: &'static str,
+    source_file: String,
+    compile: Vec<String>,
+    run: Vec<String>,
+}
+
+impl Program {
+    /// Writes the source of the program into its own directory and compiles it there, in the sandbox of its language.
+    ///
+    /// Fails with [`CheckError::Checker`] if the program does not compile, as a broken program cannot judge anything.
+    pub fn compile(
+        name: &'static str,
+        language: Language,
+        source: &str,
+        dir: PathBuf,
+        config: &Config,
+    ) -> Result<Self, CheckError> {
+        let Some(toolchain) = toolchain(language, name, &dir, config) else {
+            return Err(CheckError::Checker(format!(
+                "the {name} language {language} is not supported"
+            )));
+        };
+
+        if fs::create_dir_all(&dir).is_err()
+            || fs::write(dir.join(&toolchain.source_file), source).is_err()
+        {
+            return Err(CheckError::IOInteraction);
+        }
+
+        let sandbox = Sandbox::new(config, language, toolchain.default_image);
+        let (program, args) = toolchain
+            .compile
+            .split_first()
+            .expect("a compile command is never empty");
+        let args: Vec<&str> = args.iter().map(String::as_str).collect();
+        match compile_in(&sandbox, &dir, program, &args, config.compile_timeout()) {
+            Ok(_) => {}
+            Err(CheckError::Compilation(output)) => {
+                return Err(CheckError::Checker(format!(
+                    "the {name} failed to compile: {output}"
+                )))
+            }
+            Err(err) => return Err(err),
+        }
+
+        Ok(Self {
+            dir,
+            sandbox,
+            command: toolchain.run,
+            seccomp: config.language(language).seccomp,
+        })
+    }
+
+    pub fn dir(&self) -> &Path {
+        &self.dir
+    }
+
+    pub fn sandbox(&self) -> &Sandbox {
+        &self.sandbox
+    }
+
+    /// Gets the program which runs it, along with its arguments followed by `args`.
+    pub fn command<'a>(&'a self, args: &'a [String]) -> (&'a str, Vec<&'a str>) {
+        let (program, own_args) = self
+            .command
+            .split_first()
+            .expect("a run command is never empty");
+
+        (
+            program,
+            own_args.iter().chain(args).map(String::as_str).collect(),
+        )
+    }
+
+    /// Gets the limits of a run of the program, which are those of the test case with the seccomp profile of the
+    /// language of the program, without the environment and the network access of the test case, which are meant for
+    /// the solution. Only the seed is kept, so a randomized checker or interactor is replayed along with the solution.
+    pub fn limits(&self, limits: &Limits) -> Limits {
+        Limits {
+            seccomp: self.seccomp,
+            network: NetworkPolicy::None,
+            env: limits
+                .env
+                .get_key_value(SEED_ENV)
+                .map(|(name, value)| (name.clone(), value.clone()))
+                .into_iter()
+                .collect(),
+            work_dir: None,
+            ..limits.clone()
+        }
+    }
+}
+
+/// Gets the toolchain of a program in the given language, if support for the language is compiled in.
+///
+/// The commands mirror those of the language handlers, with the program in place of the test file, whose source file
+/// is named by the program, e.g. `checker.c` or `Checker.java`. The configured compiler of the language is used, but
+/// not its flags, as those are meant for solutions.
+fn toolchain(language: Language, name: &str, dir: &Path, config: &Config) -> Option<Toolchain> {
+    let path = |file: &str| {
+        dir.join(file)
+            .to_str()
+            .expect(UUID_SHOULD_BE_VALID_STR)
+            .to_string()
+    };
+    let owned = |args: &[&str]| args.iter().map(|arg| arg.to_string()).collect::<Vec<_>>();
+    #[allow(unused_variables)]
+    let compiler = |default: &str| {
+        config
+            .language(language)
+            .compiler
+            .clone()
+            .unwrap_or_else(|| default.to_string())
+    };
+    // haskell modules and java classes are capitalized, and must be named like their files
+    #[allow(unused_variables)]
+    let capitalized = name[..1].to_uppercase() + &name[1..];
+    let toolchain = match language {
+        #[cfg(feature = "haskell")]
+        Language::Haskell => Toolchain {
+            default_image: super::haskell::HASKELL_DEFAULT_IMAGE,
+            source_file: format!("{capitalized}.hs"),
+            compile: [
+                vec![compiler(super::haskell::TOOLCHAIN.compiler)],
+                owned(&["-O2", "-o"]),
+                vec![path(name), path(&format!("{capitalized}.hs"))],
+            ]
+            .concat(),
+            run: vec![path(name)],
+        },
+        #[cfg(feature = "python")]
+        Language::Python => Toolchain {
+            default_image: super::python::PYTHON_DEFAULT_IMAGE,
+            source_file: format!("{name}.py"),
+            compile: [
+                vec![compiler(super::python::TOOLCHAIN.compiler)],
+                owned(&["-m", "py_compile"]),
+                vec![path(&format!("{name}.py"))],
+            ]
+            .concat(),
+            run: vec![
+                compiler(super::python::TOOLCHAIN.compiler),
+                String::from("-B"),
+                path(&format!("{name}.py")),
+            ],
+        },
+        #[cfg(feature = "go")]
+        Language::Go => Toolchain {
+            default_image: super::go::GO_DEFAULT_IMAGE,
+            source_file: format!("{name}.go"),
+            // the build cache is kept in the directory, as the root filesystem may be read-only
+            compile: [
+                vec!["env".to_string(), format!("GOCACHE={}", path(".gocache"))],
+                vec![compiler(super::go::TOOLCHAIN.compiler)],
+                owned(&["build", "-o"]),
+                vec![path(name), path(&format!("{name}.go"))],
+            ]
+            .concat(),
+            run: vec![path(name)],
+        },
+        #[cfg(feature = "c")]
+        Language::C => Toolchain {
+            default_image: super::c::C_DEFAULT_IMAGE,
+            source_file: format!("{name}.c"),
+            compile: [
+                vec![compiler(super::c::TOOLCHAIN.compiler)],
+                owned(&["-o"]),
+                vec![path(name), path(&format!("{name}.c"))],
+                owned(super::c::C_COMPILE_FLAGS),
+            ]
+            .concat(),
+            run: vec![path(name)],
+        },
+        #[cfg(feature = "java")]
+        Language::Java => Toolchain {
+            default_image: super::java::JAVA_DEFAULT_IMAGE,
+            source_file: format!("{capitalized}.java"),
+            compile: [
+                vec![compiler(super::java::TOOLCHAIN.compiler)],
+                owned(&["-d"]),
+                vec![path(""), path(&format!("{capitalized}.java"))],
+            ]
+            .concat(),
+            run: vec![
+                super::java::launcher(&compiler(super::java::TOOLCHAIN.compiler)),
+                String::from("-cp"),
+                path(""),
+                capitalized,
+            ],
+        },
+        #[allow(unreachable_patterns)]
+        _ => return None,
+    };
+
+    Some(toolchain)
+}
+
+#[cfg(test)]
+mod toolchain {
+    use super::toolchain;
+    use crate::{config::Config, model::Language};
+    use std::path::Path;
+
+    #[test]
+    #[cfg(feature = "c")]
+    fn c_uses_compile_flags() {
+        let actual = toolchain(
+            Language::C,
+            "checker",
+            Path::new("/tmp/task/checker"),
+            &Config::default(),
+        )
+        .unwrap();
+
+        assert_eq!(
+            actual.compile,
+            vec![
+                "gcc",
+                "-o",
+                "/tmp/task/checker/checker",
+                "/tmp/task/checker/checker.c",
+                "-O2",
+                "-lm"
+            ]
+        );
+        assert_eq!(actual.run, vec!["/tmp/task/checker/checker"]);
+    }
+
+    #[test]
+    #[cfg(feature = "haskell")]
+    fn haskell_runs_executable() {
+        let actual = toolchain(
+            Language::Haskell,
+            "checker",
+            Path::new("/tmp/task/checker"),
+            &Config::default(),
+        )
+        .unwrap();
+
+        assert_eq!(actual.source_file, "Checker.hs");
+        assert_eq!(actual.run, vec!["/tmp/task/checker/checker"]);
+    }
+
+    #[test]
+    #[cfg(feature = "java")]
+    fn java_class_is_named_by_the_program() {
+        let actual = toolchain(
+            Language::Java,
+            "interactor",
+            Path::new("/tmp/task/interactor"),
+            &Config::default(),
+        )
+        .unwrap();
+
+        assert_eq!(actual.source_file, "Interactor.java");
+        assert_eq!(actual.run.last().map(String::as_str), Some("Interactor"));
+    }
+}
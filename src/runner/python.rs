@@ -0,0 +1,318 @@
+use super::{
+    compile_in, diagnostics::Format, imports, quote, sources_with, Compiler, LanguageHandler,
+    Toolchain,
+};
+use crate::{
+    config::Config,
+    error::{CheckError, UUID_SHOULD_BE_VALID_STR},
+    model::{Language, Parameter, TestCase},
+    sandbox::Sandbox,
+};
+use std::path::PathBuf;
+
+/// The docker image used when no image is configured for python.
+pub(super) const PYTHON_DEFAULT_IMAGE: &str = "python:3.12-alpine";
+
+/// The flags of python are passed to the interpreter when running the test file.
+pub(super) const TOOLCHAIN: Toolchain = Toolchain {
+    image: PYTHON_DEFAULT_IMAGE,
+    compiler: "python3",
+    flags: &[],
+    version_args: &["--version"],
+    linter: &["pylint", "--score=n"],
+    extensions: &["py"],
+};
+
+const PYTHON_BASE_TEST_CODE: &str = r###"
+SOLUTION
+
+import sys as mozart_sys
+
+def test_checker(actual, expected):
+    with open("OUTPUT_DIR_PATH/" + mozart_sys.argv[1], "a") as output:
+        if actual == expected:
+            output.write("p" + "\n")
+        else:
+            output.write("f" + "," + repr(actual) + "," + repr(expected) + "\n")
+
+mozart_test_cases = {
+TEST_CASES
+}
+
+mozart_test_cases[mozart_sys.argv[1]]()
+"###;
+
+pub struct Python {
+    temp_dir: PathBuf,
+    sandbox: Sandbox,
+    compiler: Compiler,
+}
+
+impl LanguageHandler for Python {
+    fn new(temp_dir: PathBuf, config: &Config) -> Self {
+        Self {
+            temp_dir,
+            sandbox: Sandbox::new(config, Language::Python, PYTHON_DEFAULT_IMAGE),
+            compiler: Compiler::new(
+                config,
+                Language::Python,
+                TOOLCHAIN.compiler,
+                TOOLCHAIN.flags,
+            ),
+        }
+    }
+
+    fn dir(&self) -> &PathBuf {
+        &self.temp_dir
+    }
+
+    fn test_file_path(&self) -> PathBuf {
+        let mut path = self.temp_dir.clone();
+        path.push("test.py");
+
+        path
+    }
+
+    fn base_test_code(&self) -> &str {
+        PYTHON_BASE_TEST_CODE
+    }
+
+    fn generate_test_cases(&self, test_cases: &[TestCase]) -> Result<String, CheckError> {
+        let mut generated_test_cases = Vec::with_capacity(test_cases.len());
+
+        for (index, test_case) in test_cases.iter().enumerate() {
+            let formatted_input_parameters = test_case
+                .input_parameters
+                .iter()
+                .map(|ip| self.format_parameter(ip))
+                .collect::<Vec<String>>()
+                .join(", ");
+
+            // multiple output parameters are compared as a tuple
+            let formatted_output_parameters = match test_case.output_parameters.as_ref() {
+                [output] => self.format_parameter(output),
+                outputs => format!(
+                    "({},)",
+                    outputs
+                        .iter()
+                        .map(|op| self.format_parameter(op))
+                        .collect::<Vec<String>>()
+                        .join(", ")
+                ),
+            };
+
+            let generated_test_case = format!(
+                "    \"{index}\": lambda: test_checker(solution({formatted_input_parameters}), {formatted_output_parameters}),"
+            );
+            generated_test_cases.push(generated_test_case);
+        }
+
+        Ok(generated_test_cases.join("\n"))
+    }
+
+    fn format_parameter(&self, parameter: &Parameter) -> String {
+        match parameter.value_type.as_str() {
+            "string" | "char" => quote(&parameter.value),
+            "bool" | "boolean" => match parameter.value.as_str() {
+                "true" => String::from("True"),
+                "false" => String::from("False"),
+                value => value.to_string(),
+            },
+            _ => format!("({})", parameter.value),
+        }
+    }
+
+    fn compiler(&self) -> &Compiler {
+        &self.compiler
+    }
+
+    fn imports(&self, solution: &str) -> Vec<String> {
+        imports::python(solution)
+    }
+
+    fn compile(&self, sources: &[PathBuf]) -> Result<String, CheckError> {
+        let test_file_path = self.test_file_path();
+        let test_file_str = test_file_path.to_str().expect(UUID_SHOULD_BE_VALID_STR);
+
+        // python is interpreted, so compilation only checks the syntax
+        compile_in(
+            &self.sandbox,
+            &self.temp_dir,
+            self.compiler.program(),
+            &[
+                vec!["-m", "py_compile", test_file_str],
+                sources_with(sources, "py"),
+            ]
+            .concat(),
+            self.compiler.timeout(),
+        )
+    }
+
+    fn diagnostic_format(&self) -> Format {
+        Format::Python
+    }
+
+    fn artifacts(&self) -> Result<Vec<String>, CheckError> {
+        // the test file is run directly, so nothing produced by checking the syntax is needed
+        Ok(Vec::new())
+    }
+
+    fn sandbox(&self) -> &Sandbox {
+        &self.sandbox
+    }
+
+    fn run_command(&self, index: usize, _: &TestCase) -> Vec<String> {
+        let test_file_path = self.test_file_path();
+        let test_file_str = test_file_path.to_str().expect(UUID_SHOULD_BE_VALID_STR);
+
+        let index = index.to_string();
+
+        [
+            vec![self.compiler.program()],
+            self.compiler.flags(),
+            vec!["-B", test_file_str, index.as_str()],
+        ]
+        .concat()
+        .into_iter()
+        .map(str::to_string)
+        .collect()
+    }
+
+    fn cleanup(&self) -> Result<(), CheckError> {
+        let pycache = self.temp_dir.join("__pycache__");
+        if pycache.exists() && std::fs::remove_dir_all(pycache).is_err() {
+            return Err(CheckError::IOInteraction);
+        }
+
+        Ok(())
+    }
+}
+
+/// The test file of a pytest test suite, which is the solution itself, so the tests import it as `solution`, followed
+/// by running one of the tests of the suite at a time when it is run as a script.
+///
+/// The report of pytest is written to the output file of the test case, and what the test printed is kept in it.
+const PYTEST_MAIN_CODE: &str = r###"
+SOLUTION
+
+if __name__ == "__main__":
+    import sys as mozart_sys
+    import pytest as mozart_pytest
+
+    mozart_sys.exit(mozart_pytest.main([
+        "-q",
+        "-p", "no:cacheprovider",
+        "-o", "junit_logging=all",
+        "--junitxml=OUTPUT_DIR_PATH/" + mozart_sys.argv[1],
+        mozart_sys.argv[2],
+    ]))
+"###;
+
+/// Runs the tests of the test files of a python submission as its test cases with pytest, which is imported by the
+/// solution, so it must be installed along with the interpreter.
+pub struct Pytest {
+    temp_dir: PathBuf,
+    sandbox: Sandbox,
+    compiler: Compiler,
+}
+
+impl LanguageHandler for Pytest {
+    fn new(temp_dir: PathBuf, config: &Config) -> Self {
+        Self {
+            temp_dir,
+            sandbox: Sandbox::new(config, Language::Python, PYTHON_DEFAULT_IMAGE),
+            compiler: Compiler::new(
+                config,
+                Language::Python,
+                TOOLCHAIN.compiler,
+                TOOLCHAIN.flags,
+            ),
+        }
+    }
+
+    fn dir(&self) -> &PathBuf {
+        &self.temp_dir
+    }
+
+    fn test_file_path(&self) -> PathBuf {
+        self.temp_dir.join("solution.py")
+    }
+
+    fn base_test_code(&self) -> &str {
+        PYTEST_MAIN_CODE
+    }
+
+    /// The tests are the test cases themselves, so nothing is generated for them.
+    fn generate_test_cases(&self, _: &[TestCase]) -> Result<String, CheckError> {
+        Ok(String::new())
+    }
+
+    fn format_parameter(&self, parameter: &Parameter) -> String {
+        parameter.value.clone()
+    }
+
+    fn compiler(&self) -> &Compiler {
+        &self.compiler
+    }
+
+    fn imports(&self, solution: &str) -> Vec<String> {
+        imports::python(solution)
+    }
+
+    fn compile(&self, sources: &[PathBuf]) -> Result<String, CheckError> {
+        let test_file_path = self.test_file_path();
+        let test_file_str = test_file_path.to_str().expect(UUID_SHOULD_BE_VALID_STR);
+
+        compile_in(
+            &self.sandbox,
+            &self.temp_dir,
+            self.compiler.program(),
+            &[
+                vec!["-m", "py_compile", test_file_str],
+                sources_with(sources, "py"),
+            ]
+            .concat(),
+            self.compiler.timeout(),
+        )
+    }
+
+    fn diagnostic_format(&self) -> Format {
+        Format::Python
+    }
+
+    fn artifacts(&self) -> Result<Vec<String>, CheckError> {
+        Ok(Vec::new())
+    }
+
+    fn sandbox(&self) -> &Sandbox {
+        &self.sandbox
+    }
+
+    /// Runs the test with the node id named by the test case, with the index naming its output file.
+    fn run_command(&self, index: usize, test_case: &TestCase) -> Vec<String> {
+        let test_file_path = self.test_file_path();
+        let test_file_str = test_file_path.to_str().expect(UUID_SHOULD_BE_VALID_STR);
+
+        let index = index.to_string();
+        let name = test_case.name.as_deref().unwrap_or_default();
+
+        [
+            vec![self.compiler.program()],
+            self.compiler.flags(),
+            vec!["-B", test_file_str, index.as_str(), name],
+        ]
+        .concat()
+        .into_iter()
+        .map(str::to_string)
+        .collect()
+    }
+
+    fn cleanup(&self) -> Result<(), CheckError> {
+        let pycache = self.temp_dir.join("__pycache__");
+        if pycache.exists() && std::fs::remove_dir_all(pycache).is_err() {
+            return Err(CheckError::IOInteraction);
+        }
+
+        Ok(())
+    }
+}
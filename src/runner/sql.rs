@@ -0,0 +1,327 @@
+use super::{
+    compile_in, diagnostics::Format, single_output, Compiler, LanguageHandler, Toolchain, ValueType,
+};
+use crate::{
+    config::Config,
+    error::{CheckError, UUID_SHOULD_BE_VALID_STR},
+    model::{Language, Parameter, TestCase},
+    sandbox::Sandbox,
+};
+use serde_json::Value;
+use std::{fs, path::PathBuf};
+
+/// The docker image used when no image is configured for sql.
+pub(super) const SQL_DEFAULT_IMAGE: &str = "keinos/sqlite3:latest";
+
+/// The flags of sql are passed to the sqlite shell whenever it opens the database of a test case.
+pub(super) const TOOLCHAIN: Toolchain = Toolchain {
+    image: SQL_DEFAULT_IMAGE,
+    compiler: "sqlite3",
+    flags: &[],
+    version_args: &["--version"],
+    linter: &[],
+    extensions: &["sql"],
+};
+
+/// The line which ends the solution in the test script, which the solution itself may not contain.
+const SOLUTION_DELIMITER: &str = "MOZART_SOLUTION";
+
+/// The test script, which loads the setup of a test case into a fresh database, and compares the rows the solution
+/// queries from it to the rows the expected query selects from a copy of it.
+///
+/// Rows are printed as sql literals separated by spaces, one row per line, and the solution is run in the safe mode of
+/// the sqlite shell, so it cannot run programs or write files other than the database.
+const SQL_BASE_TEST_CODE: &str = r###"
+mozart_case=$1
+mozart_db="OUTPUT_DIR_PATH/$mozart_case.db"
+mozart_expected_db="OUTPUT_DIR_PATH/$mozart_case.expected.db"
+trap 'rm -f "$mozart_db" "$mozart_expected_db"' EXIT
+
+mozart_solution() {
+	cat <<'MOZART_SOLUTION'
+SOLUTION
+MOZART_SOLUTION
+}
+
+mozart_query() {
+	mozart_sqlite -batch -bail -safe -noheader -cmd '.mode quote' -cmd ".separator ' '" "$1"
+}
+
+mozart_quote() {
+	printf '"%s"' "$(printf '%s' "$1" | sed -e 's/\\/\\\\/g' -e 's/"/\\"/g' -e '$!s/$/\\n/' | tr -d '\n')"
+}
+
+mozart_check() {
+	rm -f "$mozart_db" "$mozart_expected_db"
+	printf '%s\n' "$1" | mozart_sqlite -batch -bail "$mozart_db" > /dev/null || exit 1
+	cp "$mozart_db" "$mozart_expected_db" || exit 1
+	mozart_expected=$(printf '%s\n' "$2" | mozart_query "$mozart_expected_db") || exit 1
+	mozart_actual=$(mozart_solution | mozart_query "$mozart_db") || exit 1
+	if [ "$mozart_actual" = "$mozart_expected" ]; then
+		echo "p" > "OUTPUT_DIR_PATH/$mozart_case"
+	else
+		printf 'f,%s,%s\n' "$(mozart_quote "$mozart_actual")" "$(mozart_quote "$mozart_expected")" > "OUTPUT_DIR_PATH/$mozart_case"
+	fi
+}
+
+TEST_CASES
+"###;
+
+/// Runs the query of a sql solution against an ephemeral sqlite database per test case, which is set up by the `sql`
+/// input parameters of the test case, and compares the rows it selects to those of its output parameter.
+pub struct Sql {
+    temp_dir: PathBuf,
+    sandbox: Sandbox,
+    compiler: Compiler,
+}
+
+impl LanguageHandler for Sql {
+    fn new(temp_dir: PathBuf, config: &Config) -> Self {
+        Self {
+            temp_dir,
+            sandbox: Sandbox::new(config, Language::Sql, SQL_DEFAULT_IMAGE),
+            compiler: Compiler::new(config, Language::Sql, TOOLCHAIN.compiler, TOOLCHAIN.flags),
+        }
+    }
+
+    fn dir(&self) -> &PathBuf {
+        &self.temp_dir
+    }
+
+    fn test_file_path(&self) -> PathBuf {
+        self.temp_dir.join("test.sh")
+    }
+
+    fn base_test_code(&self) -> &str {
+        SQL_BASE_TEST_CODE
+    }
+
+    /// Generates the function running the sqlite shell with its flags, followed by a case running the check of every
+    /// test case with its setup and expected query.
+    fn generate_test_cases(&self, test_cases: &[TestCase]) -> Result<String, CheckError> {
+        let sqlite = [vec![self.compiler.program()], self.compiler.flags()]
+            .concat()
+            .into_iter()
+            .map(shell_quote)
+            .collect::<Vec<String>>()
+            .join(" ");
+        let mut generated = vec![
+            format!("mozart_sqlite() {{\n\t{sqlite} \"$@\"\n}}\n"),
+            String::from("case \"$mozart_case\" in"),
+        ];
+
+        for (index, test_case) in test_cases.iter().enumerate() {
+            let setup = test_case
+                .input_parameters
+                .iter()
+                .map(|parameter| match parameter.value_type.as_str() {
+                    "sql" => Ok(parameter.value.as_str()),
+                    value_type => Err(CheckError::UnsupportedTestCase(format!(
+                        "the input parameters of test case {} must be sql, not {value_type}",
+                        test_case.id
+                    ))),
+                })
+                .collect::<Result<Vec<&str>, CheckError>>()?
+                .join("\n");
+            let expected = self.expected_query(single_output(test_case)?)?;
+
+            generated.push(format!(
+                "\t{index}) mozart_check {} {} ;;",
+                shell_quote(&setup),
+                shell_quote(&expected)
+            ));
+        }
+        generated.push(String::from("esac"));
+
+        Ok(generated.join("\n"))
+    }
+
+    fn format_parameter(&self, parameter: &Parameter) -> String {
+        match parameter.value_type.as_str() {
+            "string" | "char" => literal(&Value::String(parameter.value.clone())),
+            "bool" | "boolean" => String::from(match parameter.value.as_str() {
+                "true" => "1",
+                _ => "0",
+            }),
+            _ => parameter.value.clone(),
+        }
+    }
+
+    fn compiler(&self) -> &Compiler {
+        &self.compiler
+    }
+
+    /// Sql has no imports, as a query can only refer to the tables of its database.
+    fn imports(&self, _: &str) -> Vec<String> {
+        Vec::new()
+    }
+
+    /// The query cannot be prepared without the tables set up by the test cases, so only the syntax of the test script
+    /// is checked, after making sure the solution does not end early.
+    fn compile(&self, _: &[PathBuf]) -> Result<String, CheckError> {
+        let test_file_path = self.test_file_path();
+        let Ok(test_code) = fs::read_to_string(&test_file_path) else {
+            return Err(CheckError::IOInteraction);
+        };
+        if test_code
+            .lines()
+            .filter(|line| *line == SOLUTION_DELIMITER)
+            .count()
+            != 1
+        {
+            return Err(CheckError::Compilation(format!(
+                "the solution may not have a line consisting of {SOLUTION_DELIMITER}"
+            )));
+        }
+
+        compile_in(
+            &self.sandbox,
+            &self.temp_dir,
+            "sh",
+            &[
+                "-n",
+                test_file_path.to_str().expect(UUID_SHOULD_BE_VALID_STR),
+            ],
+            self.compiler.timeout(),
+        )
+    }
+
+    /// Compiling only checks the syntax of the test script, whose problems are never in the solution.
+    fn diagnostic_format(&self) -> Format {
+        Format::Gnu
+    }
+
+    fn artifacts(&self) -> Result<Vec<String>, CheckError> {
+        // the test script is run directly, so nothing is produced by compiling
+        Ok(Vec::new())
+    }
+
+    fn sandbox(&self) -> &Sandbox {
+        &self.sandbox
+    }
+
+    fn run_command(&self, index: usize, _: &TestCase) -> Vec<String> {
+        let test_file_path = self.test_file_path();
+        let test_file_str = test_file_path.to_str().expect(UUID_SHOULD_BE_VALID_STR);
+
+        vec![
+            String::from("sh"),
+            test_file_str.to_string(),
+            index.to_string(),
+        ]
+    }
+
+    fn cleanup(&self) -> Result<(), CheckError> {
+        Ok(())
+    }
+}
+
+impl Sql {
+    /// Gets the query selecting the expected rows of the output parameter, which is either a query itself, the rows as
+    /// a json array of arrays of values, or a single value of one of the value types shared by all languages.
+    fn expected_query(&self, parameter: &Parameter) -> Result<String, CheckError> {
+        match parameter.value_type.as_str() {
+            "sql" => Ok(parameter.value.clone()),
+            "rows" => {
+                let rows = match serde_json::from_str::<Value>(&parameter.value) {
+                    Ok(Value::Array(rows)) => rows,
+                    _ => {
+                        return Err(CheckError::UnsupportedTestCase(String::from(
+                            "the expected rows must be a json array of arrays",
+                        )))
+                    }
+                };
+
+                let mut values = Vec::with_capacity(rows.len());
+                for row in &rows {
+                    let Value::Array(row) = row else {
+                        return Err(CheckError::UnsupportedTestCase(String::from(
+                            "every expected row must be a json array",
+                        )));
+                    };
+                    values.push(format!(
+                        "({})",
+                        row.iter().map(literal).collect::<Vec<String>>().join(", ")
+                    ));
+                }
+
+                // a query selecting no rows, as values cannot be empty
+                match values.is_empty() {
+                    true => Ok(String::from("SELECT 1 WHERE 0;")),
+                    false => Ok(format!("VALUES {};", values.join(", "))),
+                }
+            }
+            _ => {
+                ValueType::of(parameter)?;
+                Ok(format!("SELECT {};", self.format_parameter(parameter)))
+            }
+        }
+    }
+}
+
+/// Formats a json value as a sql literal, where booleans are integers like in sqlite, and arrays and objects are their
+/// json text.
+fn literal(value: &Value) -> String {
+    match value {
+        Value::Null => String::from("NULL"),
+        Value::Bool(value) => u8::from(*value).to_string(),
+        Value::Number(number) => number.to_string(),
+        Value::String(string) => format!("'{}'", string.replace('\'', "''")),
+        value => literal(&Value::String(value.to_string())),
+    }
+}
+
+/// Quotes a value as a single argument to the shell.
+fn shell_quote(value: &str) -> String {
+    format!("'{}'", value.replace('\'', "'\\''"))
+}
+
+#[cfg(test)]
+mod queries {
+    use super::{literal, shell_quote, Sql};
+    use crate::{config::Config, model::Parameter, runner::LanguageHandler};
+    use serde_json::json;
+    use std::env;
+
+    fn parameter(value_type: &str, value: &str) -> Parameter {
+        Parameter {
+            value_type: value_type.to_string(),
+            value: value.to_string(),
+        }
+    }
+
+    #[test]
+    fn expected_rows() {
+        let sql = Sql::new(env::temp_dir(), &Config::default());
+        let expected_query = |parameter| sql.expected_query(&parameter);
+
+        assert_eq!(
+            expected_query(parameter(
+                "rows",
+                r#"[[1, "o'neil", null], [2, true, 1.5]]"#
+            ))
+            .unwrap(),
+            "VALUES (1, 'o''neil', NULL), (2, 1, 1.5);"
+        );
+        assert_eq!(
+            expected_query(parameter("rows", "[]")).unwrap(),
+            "SELECT 1 WHERE 0;"
+        );
+        assert_eq!(
+            expected_query(parameter("string", "it's")).unwrap(),
+            "SELECT 'it''s';"
+        );
+        assert_eq!(
+            expected_query(parameter("sql", "SELECT name FROM users;")).unwrap(),
+            "SELECT name FROM users;"
+        );
+        assert!(expected_query(parameter("rows", "[1]")).is_err());
+        assert!(expected_query(parameter("list", "[1]")).is_err());
+    }
+
+    #[test]
+    fn quoting() {
+        assert_eq!(literal(&json!({ "a": 1 })), r#"'{"a":1}'"#);
+        assert_eq!(shell_quote("it's"), r"'it'\''s'");
+    }
+}
@@ -0,0 +1,128 @@
+use std::{
+    env,
+    ffi::{CStr, CString},
+    fs, io,
+    os::unix::ffi::OsStrExt,
+    path::{Path, PathBuf},
+};
+use uuid::Uuid;
+
+/// The environment variable overriding the parent cgroup of all executions.
+const CGROUP_VAR: &str = "MOZART_CGROUP";
+
+/// The parent cgroup of all executions, if [`CGROUP_VAR`] is not set.
+const DEFAULT_CGROUP: &str = "/sys/fs/cgroup/mozart";
+
+/// A cgroup v2 of a single execution, which limits the memory and the number of processes in it.
+///
+/// The cgroup kills its remaining processes and removes itself when dropped.
+pub struct Cgroup {
+    path: PathBuf,
+    procs_path: CString,
+}
+
+impl Cgroup {
+    /// Creates a cgroup with the given memory limit in bytes, and the given limit of processes and threads, where 0 is
+    /// unlimited.
+    ///
+    /// Returns `None` if cgroups v2 are unavailable, or the memory controller cannot be enabled in the parent cgroup.
+    /// The process limit is only enforced if the pids controller can be enabled as well, as RLIMIT_NPROC would count
+    /// every process of the user rather than of the execution.
+    pub fn create(memory: u64, processes: u64) -> Option<Self> {
+        let parent =
+            env::var(CGROUP_VAR).map_or_else(|_| PathBuf::from(DEFAULT_CGROUP), PathBuf::from);
+
+        // a directory is only a cgroup v2 if the kernel has populated it with its interface files
+        let is_cgroup = |path: &Path| path.join("cgroup.controllers").exists();
+
+        if !is_cgroup(&parent) {
+            let created = parent.parent().is_some_and(is_cgroup) && fs::create_dir(&parent).is_ok();
+            if !created {
+                return None;
+            }
+        }
+
+        // this fails if the controller is already enabled by someone else, which is detected below
+        let _ = fs::write(parent.join("cgroup.subtree_control"), "+memory");
+        // the controllers are enabled separately, so a missing pids controller does not keep the memory one disabled
+        let _ = fs::write(parent.join("cgroup.subtree_control"), "+pids");
+
+        let path = parent.join(Uuid::new_v4().to_string());
+        if fs::create_dir(&path).is_err() {
+            return None;
+        }
+
+        let procs_path = CString::new(path.join("cgroup.procs").as_os_str().as_bytes())
+            .expect("a cgroup path should never contain a nul byte");
+        let cgroup = Self { path, procs_path };
+
+        // the files of the memory controller only exist if the controller is enabled
+        let configured = fs::write(cgroup.path.join("memory.max"), memory.to_string()).is_ok()
+            && fs::write(cgroup.path.join("memory.swap.max"), "0").is_ok();
+
+        if configured && processes > 0 {
+            let _ = fs::write(cgroup.path.join("pids.max"), processes.to_string());
+        }
+
+        configured.then_some(cgroup)
+    }
+
+    /// Gets the path of the file which processes are moved into the cgroup with, see [`enter`].
+    pub fn procs_path(&self) -> CString {
+        self.procs_path.clone()
+    }
+
+    /// Whether a process in the cgroup was killed, as the cgroup exceeded its memory limit.
+    pub fn oom_killed(&self) -> bool {
+        let Ok(events) = fs::read_to_string(self.path.join("memory.events")) else {
+            return false;
+        };
+
+        events
+            .lines()
+            .filter_map(|line| line.strip_prefix("oom_kill "))
+            .any(|count| count.trim().parse::<u64>().is_ok_and(|count| count > 0))
+    }
+
+    /// Whether a process in the cgroup failed to fork or spawn a thread, as the cgroup reached its process limit.
+    pub fn exceeded_process_limit(&self) -> bool {
+        let Ok(events) = fs::read_to_string(self.path.join("pids.events")) else {
+            return false;
+        };
+
+        events
+            .lines()
+            .filter_map(|line| line.strip_prefix("max "))
+            .any(|count| count.trim().parse::<u64>().is_ok_and(|count| count > 0))
+    }
+}
+
+/// Moves the calling process into the cgroup of the given `cgroup.procs` file.
+///
+/// This is meant to be called between fork and exec, so it only uses async-signal-safe functions.
+pub fn enter(procs_path: &CStr) -> io::Result<()> {
+    // SAFETY: the path is a valid nul terminated string, and the written buffer outlives the call.
+    unsafe {
+        let fd = libc::open(procs_path.as_ptr(), libc::O_WRONLY);
+        if fd < 0 {
+            return Err(io::Error::last_os_error());
+        }
+
+        // writing 0 moves the writing process itself
+        let written = libc::write(fd, b"0".as_ptr().cast(), 1);
+        libc::close(fd);
+        if written != 1 {
+            return Err(io::Error::last_os_error());
+        }
+    }
+
+    Ok(())
+}
+
+impl Drop for Cgroup {
+    fn drop(&mut self) {
+        // the processes of a cgroup must be gone before it can be removed
+        let _ = fs::write(self.path.join("cgroup.kill"), "1");
+        let _ = fs::remove_dir(&self.path);
+    }
+}
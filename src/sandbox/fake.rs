@@ -0,0 +1,227 @@
+use super::{Execution, Limits, Outcome, POLL_INTERVAL};
+use crate::error::CheckError;
+use std::{
+    fs, io,
+    os::unix::process::ExitStatusExt,
+    path::Path,
+    process::{Command, ExitStatus, Output},
+    thread,
+    time::Instant,
+};
+
+/// The word which starts a directive of the fake sandbox, e.g. `mozart-fake: wrong-answer 4 5`, or
+/// `mozart-fake[1]: time-limit-exceeded` for the test case at index 1 only.
+pub const DIRECTIVE: &str = "mozart-fake";
+
+/// The version every compiler reports in the fake sandbox.
+pub const VERSION: &str = "0.0.0-fake";
+
+/// The directory of the workspace the test program writes the result of every test case into, like the runner reads it.
+const OUTPUT_DIR: &str = "output";
+
+/// What the fake sandbox pretends a compiler or a test program did, as told by a directive in the workspace.
+#[derive(Clone, Debug, PartialEq)]
+pub enum Behavior {
+    /// The test case passes, which is what happens without a directive.
+    Pass,
+    /// The test case prints the actual value rather than the expected one.
+    WrongAnswer { actual: String, expected: String },
+    /// The test program exits with 1 before writing its result, printing the message to its standard error.
+    RuntimeError(String),
+    /// The test program is killed at its time limit, without the fake waiting for it.
+    TimeLimitExceeded,
+    /// The test program is killed as it exceeded its memory limit.
+    MemoryLimitExceeded,
+    /// The test program runs until its judgment is cancelled, or until its time limit.
+    Hang,
+    /// The compiler fails with the message, while test programs pass.
+    CompilationError(String),
+}
+
+impl Behavior {
+    /// Parses the behavior after the colon of a directive, where an unknown one is a runtime error, as the fake has no
+    /// other way to tell that the directive is wrong.
+    fn parse(directive: &str) -> Self {
+        let (name, argument) = directive
+            .trim()
+            .split_once(char::is_whitespace)
+            .map_or((directive.trim(), ""), |(name, argument)| {
+                (name, argument.trim())
+            });
+
+        match name {
+            "pass" => Self::Pass,
+            "wrong-answer" => {
+                let (actual, expected) = argument
+                    .split_once(char::is_whitespace)
+                    .unwrap_or((argument, ""));
+                Self::WrongAnswer {
+                    actual: actual.to_string(),
+                    expected: expected.trim().to_string(),
+                }
+            }
+            "runtime-error" => Self::RuntimeError(argument.to_string()),
+            "time-limit-exceeded" => Self::TimeLimitExceeded,
+            "memory-limit-exceeded" => Self::MemoryLimitExceeded,
+            "hang" => Self::Hang,
+            "compilation-error" => Self::CompilationError(argument.to_string()),
+            _ => Self::RuntimeError(format!("unknown {DIRECTIVE} directive: {directive}")),
+        }
+    }
+}
+
+/// Finds the behavior of the test case at the index in the files at the top of the workspace, where `None` is the
+/// compiler.
+///
+/// A directive for the index takes precedence over one for every test case, and the first of either kind wins.
+pub fn behavior(dir: &Path, index: Option<usize>) -> Behavior {
+    let mut general = None;
+    for text in texts(dir) {
+        for line in text.lines() {
+            let Some((_, rest)) = line.split_once(DIRECTIVE) else {
+                continue;
+            };
+            if let Some(directive) = rest.strip_prefix(':') {
+                general.get_or_insert_with(|| Behavior::parse(directive));
+            } else if let Some((target, directive)) = rest
+                .strip_prefix('[')
+                .and_then(|rest| rest.split_once("]:"))
+            {
+                if index.is_some() && target.trim().parse::<usize>().ok() == index {
+                    return Behavior::parse(directive);
+                }
+            }
+        }
+    }
+
+    general.unwrap_or(Behavior::Pass)
+}
+
+/// Reads the text of every file at the top of the workspace, which are the solution and the test code.
+fn texts(dir: &Path) -> Vec<String> {
+    let Ok(entries) = fs::read_dir(dir) else {
+        return Vec::new();
+    };
+
+    entries
+        .filter_map(Result::ok)
+        .filter(|entry| entry.file_type().is_ok_and(|kind| kind.is_file()))
+        .filter_map(|entry| fs::read_to_string(entry.path()).ok())
+        .collect()
+}
+
+/// Creates a command which prints the version of the program, as the fake has no compilers to ask.
+pub fn command(program: &str) -> Command {
+    let mut command = Command::new("echo");
+    command.arg(format!("{program} {VERSION}"));
+    command
+}
+
+/// Pretends to run a compiler in the workspace, which fails if a directive says so and succeeds otherwise.
+pub fn output(dir: &Path) -> io::Result<Option<Output>> {
+    let (status, stderr) = match behavior(dir, None) {
+        Behavior::CompilationError(message) => (exited(1), message.into_bytes()),
+        _ => (exited(0), Vec::new()),
+    };
+
+    Ok(Some(Output {
+        status,
+        stdout: Vec::new(),
+        stderr,
+    }))
+}
+
+/// Pretends to run a program in the workspace, writing the result of its test case as told by the directives.
+///
+/// The index of the test case is the first argument which is a number, and a program without one, like a setup script,
+/// exits successfully without a result.
+pub fn run(dir: &Path, args: &[&str], limits: &Limits) -> Result<Execution, CheckError> {
+    let started = Instant::now();
+    let execution = |outcome, stderr: String| Execution {
+        outcome,
+        stderr,
+        runtime: started.elapsed(),
+        peak_memory: None,
+        cpu_time: None,
+        exceeded_process_limit: false,
+    };
+    let Some(index) = args.iter().find_map(|arg| arg.parse::<usize>().ok()) else {
+        return Ok(execution(Outcome::Exited(exited(0)), String::new()));
+    };
+
+    let result = match behavior(dir, Some(index)) {
+        Behavior::Pass | Behavior::CompilationError(_) => String::from("p\n"),
+        Behavior::WrongAnswer { actual, expected } => format!("f,{actual},{expected}\n"),
+        Behavior::RuntimeError(message) => {
+            return Ok(execution(Outcome::Exited(exited(1)), message))
+        }
+        Behavior::TimeLimitExceeded => {
+            let mut timed_out = execution(Outcome::TimedOut, String::new());
+            timed_out.runtime = limits.time;
+            return Ok(timed_out);
+        }
+        Behavior::MemoryLimitExceeded => {
+            return Ok(execution(Outcome::MemoryExceeded, String::new()))
+        }
+        Behavior::Hang => {
+            while started.elapsed() < limits.time {
+                if limits.cancellation.is_cancelled() {
+                    return Err(CheckError::Cancelled);
+                }
+                thread::sleep(POLL_INTERVAL);
+            }
+            return Ok(execution(Outcome::TimedOut, String::new()));
+        }
+    };
+
+    let output_dir = dir.join(OUTPUT_DIR);
+    fs::create_dir_all(&output_dir)
+        .and_then(|_| fs::write(output_dir.join(index.to_string()), result))
+        .map_err(|_| CheckError::IOInteraction)?;
+
+    Ok(execution(Outcome::Exited(exited(0)), String::new()))
+}
+
+/// Gets the status of a process which exited with the code.
+fn exited(code: i32) -> ExitStatus {
+    ExitStatus::from_raw(code << 8)
+}
+
+#[cfg(test)]
+mod directives {
+    use super::{behavior, Behavior};
+    use std::{env, fs};
+    use uuid::Uuid;
+
+    #[test]
+    fn targets_test_cases() {
+        let dir = env::temp_dir().join(format!("mozart-fake-{}", Uuid::new_v4()));
+        fs::create_dir_all(&dir).unwrap();
+        fs::write(
+            dir.join("solution.py"),
+            "# mozart-fake: wrong-answer 4 5\n# mozart-fake[1]: hang\n# mozart-fake[2]: frobnicate\n",
+        )
+        .unwrap();
+
+        let first = behavior(&dir, Some(0));
+        let second = behavior(&dir, Some(1));
+        let unknown = behavior(&dir, Some(2));
+        let compiled = behavior(&dir, None);
+        let _ = fs::remove_dir_all(&dir);
+
+        let wrong = Behavior::WrongAnswer {
+            actual: String::from("4"),
+            expected: String::from("5"),
+        };
+        assert_eq!(first, wrong);
+        assert_eq!(second, Behavior::Hang);
+        assert!(
+            matches!(unknown, Behavior::RuntimeError(message) if message.contains("frobnicate"))
+        );
+        assert_eq!(compiled, wrong);
+        assert_eq!(
+            behavior(&env::temp_dir().join("mozart-fake-missing"), None),
+            Behavior::Pass
+        );
+    }
+}
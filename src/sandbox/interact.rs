@@ -0,0 +1,346 @@
+use super::{Execution, Input, Limits, Running, Sandbox, POLL_INTERVAL};
+use crate::{
+    error::CheckError,
+    model::{Message, Speaker},
+};
+use std::{
+    io::{self, Read, Write},
+    path::Path,
+    sync::{
+        atomic::{AtomicU64, Ordering},
+        Arc, Mutex,
+    },
+    thread::{self, JoinHandle},
+    time::{Duration, Instant},
+};
+
+/// A program taking part in an interaction, which is executed like [`Sandbox::execute`].
+pub struct Party<'a> {
+    pub sandbox: &'a Sandbox,
+    pub dir: &'a Path,
+    pub program: &'a str,
+    pub args: &'a [&'a str],
+    pub limits: &'a Limits,
+}
+
+impl<'a> Party<'a> {
+    fn spawn(&self) -> Result<Running<'a>, CheckError> {
+        self.sandbox.spawn(
+            self.dir,
+            self.program,
+            self.args,
+            self.limits,
+            Input::Interactive,
+            None,
+        )
+    }
+}
+
+/// The executions of both programs of an interaction.
+#[derive(Debug)]
+pub struct Interaction {
+    pub solution: Execution,
+    pub interactor: Execution,
+    /// Whether both were killed as neither made progress for the idle limit, as they were most likely waiting for
+    /// each other.
+    pub idle: bool,
+}
+
+/// What both programs of an interaction wrote to each other, as it was relayed, up to a limit in bytes.
+pub struct Recording {
+    started: Instant,
+    limit: usize,
+    messages: Mutex<Recorded>,
+}
+
+#[derive(Default)]
+struct Recorded {
+    messages: Vec<Message>,
+    size: usize,
+    truncated: bool,
+}
+
+impl Recording {
+    /// Starts recording an interaction, of which the first `limit` bytes are kept.
+    pub fn new(limit: usize) -> Self {
+        Self {
+            started: Instant::now(),
+            limit,
+            messages: Mutex::default(),
+        }
+    }
+
+    /// Records a chunk which was relayed from the speaker to the other party, keeping as much of it as fits.
+    fn record(&self, from: Speaker, chunk: &[u8]) {
+        let mut recorded = self.messages.lock().expect("recording lock poisoned");
+        let kept = chunk.len().min(self.limit - recorded.size);
+        recorded.size += kept;
+        recorded.truncated |= kept < chunk.len();
+        if kept > 0 {
+            recorded.messages.push(Message {
+                at: self.started.elapsed().as_millis() as u64,
+                from,
+                data: String::from_utf8_lossy(&chunk[..kept]).into_owned(),
+            });
+        }
+    }
+
+    /// Gets the recorded messages in the order they were relayed, and whether any were left out for the limit.
+    pub fn finish(self) -> (Vec<Message>, bool) {
+        let recorded = self.messages.into_inner().expect("recording lock poisoned");
+
+        (recorded.messages, recorded.truncated)
+    }
+}
+
+/// Executes the solution and the interactor at the same time, relaying everything one of them writes to its standard
+/// output to the standard input of the other.
+///
+/// Both are killed if neither writes anything nor uses any CPU time for the idle limit while both are running, in
+/// which case their outcome is [`Outcome::TimedOut`]. The CPU time is only measured on the host, so only the traffic
+/// between them counts with docker.
+///
+/// Everything relayed is also recorded, if a recording is given.
+pub fn interact(
+    solution: Party,
+    interactor: Party,
+    idle_limit: Duration,
+    recording: Option<&Arc<Recording>>,
+) -> Result<Interaction, CheckError> {
+    let mut solution = solution.spawn()?;
+    let mut interactor = match interactor.spawn() {
+        Ok(interactor) => interactor,
+        Err(err) => {
+            solution.kill();
+            return Err(err);
+        }
+    };
+
+    let traffic = Arc::new(AtomicU64::new(0));
+    let relays = [
+        relay(
+            solution.child.stdout.take().expect("stdout is piped"),
+            interactor.child.stdin.take().expect("stdin is piped"),
+            traffic.clone(),
+            recording.map(|recording| (recording.clone(), Speaker::Solution)),
+        ),
+        relay(
+            interactor.child.stdout.take().expect("stdout is piped"),
+            solution.child.stdin.take().expect("stdin is piped"),
+            traffic.clone(),
+            recording.map(|recording| (recording.clone(), Speaker::Interactor)),
+        ),
+    ];
+
+    let mut executions = (None, None);
+    let mut progress = (0, None, None);
+    let mut progressed = Instant::now();
+    let mut idle = false;
+    let (solution_execution, interactor_execution) = loop {
+        let polled = poll(&mut solution, &mut executions.0)
+            .and_then(|()| poll(&mut interactor, &mut executions.1));
+        if let Err(err) = polled {
+            for (running, execution) in [
+                (&mut solution, &executions.0),
+                (&mut interactor, &executions.1),
+            ] {
+                if execution.is_none() {
+                    running.kill();
+                }
+            }
+            return Err(err);
+        }
+
+        match executions {
+            (Some(solution), Some(interactor)) => break (solution, interactor),
+            (None, None) => {
+                let current = (
+                    traffic.load(Ordering::Relaxed),
+                    solution.cpu_time(),
+                    interactor.cpu_time(),
+                );
+                if current != progress {
+                    progress = current;
+                    progressed = Instant::now();
+                } else if progressed.elapsed() >= idle_limit {
+                    idle = true;
+                    break (solution.stop(), interactor.stop());
+                }
+            }
+            _ => {}
+        }
+
+        thread::sleep(POLL_INTERVAL);
+    };
+
+    for relay in relays {
+        let _ = relay.join();
+    }
+
+    Ok(Interaction {
+        solution: solution_execution,
+        interactor: interactor_execution,
+        idle,
+    })
+}
+
+/// Polls the execution unless it is already finished, keeping it once it is.
+fn poll(running: &mut Running, execution: &mut Option<Execution>) -> Result<(), CheckError> {
+    if execution.is_none() {
+        *execution = running.poll()?;
+    }
+
+    Ok(())
+}
+
+/// Copies everything from one pipe into another on its own thread, counting the relayed bytes as traffic, and
+/// recording them as said by the speaker.
+///
+/// The pipe written to is closed once the other one is, so the program reading it reaches the end of its input. If the
+/// program stops reading, the rest is discarded, so the one writing never blocks on a full pipe.
+fn relay(
+    mut from: impl Read + Send + 'static,
+    mut to: impl Write + Send + 'static,
+    traffic: Arc<AtomicU64>,
+    recording: Option<(Arc<Recording>, Speaker)>,
+) -> JoinHandle<()> {
+    thread::spawn(move || {
+        let mut buffer = [0; 8192];
+        loop {
+            let read = match from.read(&mut buffer) {
+                Ok(0) | Err(_) => return,
+                Ok(read) => read,
+            };
+            if to.write_all(&buffer[..read]).is_err() {
+                break;
+            }
+            if let Some((recording, speaker)) = &recording {
+                recording.record(*speaker, &buffer[..read]);
+            }
+            traffic.fetch_add(read as u64, Ordering::Relaxed);
+        }
+
+        drop(to);
+        let _ = io::copy(&mut from, &mut io::sink());
+    })
+}
+
+#[cfg(test)]
+mod interaction {
+    use super::{interact, Party, Recording};
+    use crate::{
+        cancel::Cancellation,
+        config::SeccompProfile,
+        model::{NetworkPolicy, Speaker},
+        sandbox::{Limits, Outcome, Sandbox},
+    };
+    use std::{collections::BTreeMap, env, fs, path::PathBuf, sync::Arc, time::Duration};
+    use uuid::Uuid;
+
+    fn workspace() -> PathBuf {
+        let dir = env::temp_dir().join(format!("mozart-interact-{}", Uuid::new_v4()));
+        fs::create_dir(&dir).expect("failed to create workspace");
+        dir
+    }
+
+    fn limits() -> Limits {
+        Limits {
+            time: Duration::from_secs(5),
+            memory: 256 * 1024 * 1024,
+            disk: 1024 * 1024,
+            output: 1024,
+            output_tail: 0,
+            seccomp: SeccompProfile::NoNetwork,
+            processes: 0,
+            network: NetworkPolicy::None,
+            cancellation: Cancellation::default(),
+            env: BTreeMap::new(),
+            work_dir: None,
+            user: None,
+        }
+    }
+
+    fn party<'a>(dir: &'a PathBuf, args: &'a [&'a str], limits: &'a Limits) -> Party<'a> {
+        Party {
+            sandbox: &Sandbox::Host,
+            dir,
+            program: "sh",
+            args,
+            limits,
+        }
+    }
+
+    #[test]
+    fn host_relays_both_ways() {
+        let dir = workspace();
+        let limits = limits();
+        // the interactor asks for a number, and accepts if it is answered with the number after it
+        let interactor = ["-c", "echo 41; read answer; [ \"$answer\" = 42 ]"];
+        let solution = ["-c", "read question; echo $((question + 1))"];
+
+        let recording = Arc::new(Recording::new(1024));
+
+        let actual = interact(
+            party(&dir, &solution, &limits),
+            party(&dir, &interactor, &limits),
+            Duration::from_secs(2),
+            Some(&recording),
+        );
+        let _ = fs::remove_dir_all(&dir);
+
+        let actual = actual.expect("the interaction should run");
+        assert!(!actual.idle);
+        assert!(
+            matches!(actual.interactor.outcome, Outcome::Exited(status) if status.success()),
+            "{:?}",
+            actual.interactor
+        );
+        let (messages, truncated) = Arc::into_inner(recording)
+            .expect("the relays should be done")
+            .finish();
+        let messages: Vec<(Speaker, &str)> = messages
+            .iter()
+            .map(|message| (message.from, message.data.as_str()))
+            .collect();
+        assert_eq!(
+            messages,
+            [(Speaker::Interactor, "41\n"), (Speaker::Solution, "42\n")]
+        );
+        assert!(!truncated);
+    }
+
+    #[test]
+    fn recording_truncates() {
+        let recording = Recording::new(4);
+
+        recording.record(Speaker::Solution, b"abc");
+        recording.record(Speaker::Interactor, b"def");
+        recording.record(Speaker::Solution, b"ghi");
+        let (messages, truncated) = recording.finish();
+
+        assert_eq!(messages.len(), 2);
+        assert_eq!(messages[1].data, "d");
+        assert!(truncated);
+    }
+
+    #[test]
+    fn host_detects_deadlock() {
+        let dir = workspace();
+        let limits = limits();
+        // both wait for the other to speak first
+        let waiting = ["-c", "read line"];
+
+        let actual = interact(
+            party(&dir, &waiting, &limits),
+            party(&dir, &waiting, &limits),
+            Duration::from_millis(200),
+            None,
+        );
+        let _ = fs::remove_dir_all(&dir);
+
+        let actual = actual.expect("the interaction should run");
+        assert!(actual.idle);
+        assert!(matches!(actual.solution.outcome, Outcome::TimedOut));
+        assert!(actual.solution.runtime < Duration::from_secs(2));
+    }
+}
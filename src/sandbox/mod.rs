@@ -0,0 +1,2203 @@
+use crate::{
+    cancel::Cancellation,
+    config::{Config, SandboxKind, SandboxUser, SeccompProfile},
+    error::CheckError,
+    fault::{self, Fault},
+    fixture::FIXTURE_DIR,
+    model::{Language, NetworkPolicy},
+};
+use cgroup::Cgroup;
+use mount::Isolation;
+use network::Namespace;
+use quota::Quota;
+use seccomp::Filter;
+use std::{
+    collections::{BTreeMap, VecDeque},
+    fs,
+    io::{self, ErrorKind, Read},
+    os::unix::{
+        fs::chown,
+        process::{CommandExt, ExitStatusExt},
+    },
+    path::{Path, PathBuf},
+    process::{Child, Command, ExitStatus, Output, Stdio},
+    sync::Arc,
+    thread::{self, JoinHandle},
+    time::{Duration, Instant},
+};
+use usage::Measurement;
+use uuid::Uuid;
+
+mod cgroup;
+pub(crate) mod fake;
+mod interact;
+#[cfg(target_os = "linux")]
+mod mount;
+#[cfg(target_os = "linux")]
+mod network;
+#[cfg(target_os = "linux")]
+mod quota;
+#[cfg(target_os = "linux")]
+mod seccomp;
+#[cfg(not(target_os = "linux"))]
+mod unsupported;
+mod usage;
+#[cfg(not(target_os = "linux"))]
+use unsupported::{mount, network, quota, seccomp};
+
+pub use interact::{interact, Party, Recording};
+
+/// How often a running execution is polled for whether it has exited.
+const POLL_INTERVAL: Duration = Duration::from_millis(5);
+
+/// How often the workspace of a running execution is measured, which walks the whole workspace, unless the workspace is
+/// a tmpfs of its own, whose size is limited instead.
+const DISK_POLL_INTERVAL: Duration = Duration::from_millis(100);
+
+/// How long a docker command managing a container may take, such as killing or resetting it, beyond which the docker
+/// client is killed, so a daemon which stopped responding cannot leave a judgment cleaning up forever.
+const DOCKER_TIMEOUT: Duration = Duration::from_secs(30);
+
+/// The exit code of a docker container whose process was killed by exceeding its CPU time limit.
+const DOCKER_CPU_LIMIT_EXIT_CODE: i32 = 128 + libc::SIGXCPU;
+
+/// The exit code of a docker container whose process was killed by the kernel, which is assumed to be caused by the memory limit.
+const DOCKER_KILLED_EXIT_CODE: i32 = 128 + libc::SIGKILL;
+
+/// The exit code of a docker container whose process was killed by writing a file beyond its file size limit.
+const DOCKER_FILE_SIZE_EXIT_CODE: i32 = 128 + libc::SIGXFSZ;
+
+/// The prefixes of the error docker prints as the last line of its standard error when it fails itself, rather than
+/// the program it runs, as printed by `docker run`, the daemon, the runtime of `docker exec`, and a missing container.
+const DOCKER_ERRORS: &[&str] = &[
+    "docker: ",
+    "Error response from daemon: ",
+    "OCI runtime exec failed: ",
+    "Error: No such container: ",
+];
+
+/// The resource limits of a single execution, along with the system calls it may make, and the environment it runs in.
+#[derive(Clone, Debug)]
+pub struct Limits {
+    /// The wall-clock time limit, the CPU time limit is derived from this rounded up to whole seconds.
+    pub time: Duration,
+
+    /// The memory limit in bytes.
+    pub memory: u64,
+
+    /// How many bytes the execution may write into its working directory, which also limits the size of every file.
+    pub disk: u64,
+
+    /// How many bytes from the start of the standard error are kept, beyond which it is truncated.
+    pub output: usize,
+
+    /// How many bytes from the end of truncated standard error are kept as well, after the marker of what was left out.
+    pub output_tail: usize,
+
+    /// The system calls which kill the execution, along with every process it has spawned.
+    pub seccomp: SeccompProfile,
+
+    /// How many processes and threads the execution may have at once, where 0 is unlimited, beyond which spawning
+    /// another one fails.
+    pub processes: u64,
+
+    /// What the execution may connect to over the network, which is confined by a network namespace of its own
+    /// unless it is nothing, in which case sockets are forbidden by its seccomp profile instead.
+    pub network: NetworkPolicy,
+
+    /// The cancellation of the judgment the execution belongs to, which kills the execution once it is cancelled.
+    pub cancellation: Cancellation,
+
+    /// The environment variables of the execution, in addition to those of the sandbox.
+    pub env: BTreeMap<String, String>,
+
+    /// The working directory of the execution, which must be within the directory it is executed in, and defaults to
+    /// that directory.
+    pub work_dir: Option<PathBuf>,
+
+    /// The unprivileged user the execution runs as, where `None` is the user mozart runs as.
+    pub user: Option<SandboxUser>,
+}
+
+/// A stream of output of an execution, which is forwarded to a [`Sink`] while it runs.
+#[derive(Clone, Copy, PartialEq, Debug)]
+pub enum Channel {
+    Stdout,
+    Stderr,
+}
+
+/// Receives the output of a streamed execution as it is read, in chunks which may end in the middle of a character.
+pub type Sink = Arc<dyn Fn(Channel, &[u8]) + Send + Sync>;
+
+/// How a single execution is confined by the sandbox, beyond the isolation every command has.
+struct Confinement<'a> {
+    limits: &'a Limits,
+
+    /// The cgroup enforcing the memory limit on the host, if one could be created.
+    cgroup: Option<&'a Cgroup>,
+
+    /// The network namespace the program joins on the host, unless it has no network access.
+    namespace: Option<&'a Namespace>,
+
+    /// The docker seccomp profile enforcing the seccomp profile of the limits, if it filters anything.
+    docker_profile: Option<PathBuf>,
+
+    /// Whether the standard input of the program is attached, which docker leaves closed otherwise.
+    interactive: bool,
+}
+
+/// Where the standard input of an execution comes from.
+#[derive(Clone, Copy)]
+enum Input<'a> {
+    /// The standard input is empty.
+    Closed,
+
+    /// The standard input is the contents of the file.
+    File(&'a Path),
+
+    /// The standard input and output are piped, so another program can converse with the execution.
+    Interactive,
+}
+
+/// The outcome of an execution which was subject to [`Limits`].
+#[derive(Debug)]
+pub enum Outcome {
+    /// The execution exited by itself, successfully or not.
+    Exited(ExitStatus),
+
+    /// The execution was killed, as it exceeded its time limit.
+    TimedOut,
+
+    /// The execution was killed, as it exceeded its memory limit.
+    MemoryExceeded,
+
+    /// The execution was killed, as it made a system call which its seccomp profile forbids.
+    SecurityViolation,
+
+    /// The execution was killed, as it wrote more than its disk limit into its working directory, or into a file.
+    OutputExceeded,
+}
+
+/// A finished execution of a program.
+#[derive(Debug)]
+pub struct Execution {
+    pub outcome: Outcome,
+
+    /// The standard error of the program.
+    pub stderr: String,
+
+    /// The wall-clock time from starting the program until it exited or was killed.
+    pub runtime: Duration,
+
+    /// The peak resident memory of the program in bytes, if it can be measured.
+    pub peak_memory: Option<u64>,
+
+    /// The CPU time the program used, if it can be measured.
+    pub cpu_time: Option<CpuTime>,
+
+    /// Whether the program failed to spawn a process or thread as it had as many as its process limit allows, which
+    /// can only be detected on the host.
+    pub exceeded_process_limit: bool,
+}
+
+/// The CPU time of an execution, including every process it spawned and waited for.
+#[derive(Clone, Copy, Debug)]
+pub struct CpuTime {
+    /// The time spent in user mode.
+    pub user: Duration,
+    /// The time spent in kernel mode, on behalf of the program.
+    pub system: Duration,
+}
+
+/// The environment in which compilers and submitted solutions are executed.
+#[derive(Clone, Debug, PartialEq)]
+pub enum Sandbox {
+    /// Commands are executed directly on the host, without any isolation.
+    Host,
+
+    /// Commands are executed directly on the host like [`Sandbox::Host`], but without its rlimits, cgroups, seccomp
+    /// filters, or network namespaces, so only the time limit and the disk limit are enforced.
+    ///
+    /// It relies on no linux primitives, so mozart can be developed on other platforms, but never confines the
+    /// submissions it checks.
+    Dev,
+
+    /// Every command is executed in a fresh docker container of the given image, run by the given docker runtime or
+    /// the default runtime of docker.
+    ///
+    /// The container has no network access and a read-only root filesystem, with a tmpfs mounted at `/tmp`.
+    /// The temporary directory of the submission is the only part of the host filesystem which is mounted, where its
+    /// fixtures are mounted read-only. A runtime like runsc of gVisor, or the Firecracker runtime of Kata Containers,
+    /// isolates the container further, at the cost of starting it more slowly.
+    Docker {
+        image: String,
+        runtime: Option<String>,
+    },
+
+    /// Every command is executed in the given docker container, which was started ahead of time by
+    /// [`Sandbox::warm_up`], and is reused by one submission after another.
+    ///
+    /// The container is confined like a fresh one, except that its seccomp profile, its file size limit, and the size
+    /// of its tmpfs are fixed when it is started, and its memory limit is shared by every command executing in it.
+    /// The directory is the only part of the host filesystem which is mounted, in which the workspace of the submission
+    /// using the container is created, so fixtures are only read-only files rather than a read-only mount.
+    Warm { container: String, dir: PathBuf },
+
+    /// Nothing is executed, as every compiler and test program only pretends to run, doing what the directives in the
+    /// files of the workspace tell it, so the whole judging pipeline can be tested without any toolchain or docker.
+    ///
+    /// It is only available with the `sandboxtest` feature.
+    Fake,
+}
+
+impl Sandbox {
+    /// Creates the configured sandbox of the given language.
+    ///
+    /// The docker image is the configured image of the language, falling back to `default_image`.
+    pub fn new(config: &Config, language: Language, default_image: &str) -> Self {
+        match config.sandbox {
+            SandboxKind::Docker | SandboxKind::Gvisor | SandboxKind::Firecracker => {
+                let image = config.image(language).unwrap_or(default_image).to_string();
+
+                Self::Docker {
+                    image,
+                    runtime: config.sandbox_runtime().map(str::to_string),
+                }
+            }
+            SandboxKind::Host => Self::Host,
+            SandboxKind::Dev => Self::Dev,
+            SandboxKind::Fake => Self::Fake,
+        }
+    }
+
+    /// Creates a command which executes `program` with `args` inside the sandbox, with `dir` as the working directory.
+    ///
+    /// The directory is mounted at the same path inside the sandbox, so paths within it need no translation.
+    pub fn command(&self, dir: &Path, program: &str, args: &[&str]) -> Command {
+        if *self == Self::Fake {
+            return fake::command(program);
+        }
+        self.build(dir, program, args, &container_name(), None)
+    }
+
+    /// Executes `program` with `args` inside the sandbox like [`Sandbox::command`], and captures its output, killing it
+    /// if it takes longer than `timeout`.
+    ///
+    /// The output is `None` if the program was killed, as whatever it printed so far is incomplete.
+    pub fn output_within(
+        &self,
+        dir: &Path,
+        program: &str,
+        args: &[&str],
+        timeout: Duration,
+    ) -> io::Result<Option<Output>> {
+        // a hung program is only noticed once it is killed
+        if fault::injected(dir, Fault::CompilerHang) {
+            thread::sleep(timeout);
+            return Ok(None);
+        }
+        if *self == Self::Fake {
+            return fake::output(dir);
+        }
+        let name = container_name();
+        let mut command = self.build(dir, program, args, &name, None);
+        // a separate process group allows killing every process spawned by the program
+        command
+            .process_group(0)
+            .stdin(Stdio::null())
+            .stdout(Stdio::piped())
+            .stderr(Stdio::piped());
+
+        let deadline = Instant::now() + timeout;
+        let mut child = command.spawn()?;
+
+        // both pipes are drained concurrently, so the program never blocks on a full pipe
+        let mut stdout_pipe = child.stdout.take().expect("stdout is piped");
+        let mut stderr_pipe = child.stderr.take().expect("stderr is piped");
+        let stdout_reader = thread::spawn(move || read_all(&mut stdout_pipe));
+        let stderr_reader = thread::spawn(move || read_all(&mut stderr_pipe));
+
+        let status = loop {
+            match child.try_wait()? {
+                Some(status) => break status,
+                None if Instant::now() >= deadline => {
+                    self.kill(&mut child, &name);
+                    return Ok(None);
+                }
+                None => thread::sleep(POLL_INTERVAL),
+            }
+        };
+
+        Ok(Some(Output {
+            status,
+            stdout: stdout_reader.join().unwrap_or_default(),
+            stderr: stderr_reader.join().unwrap_or_default(),
+        }))
+    }
+
+    /// Executes `program` with `args` inside the sandbox, killing it if it exceeds the limits.
+    ///
+    /// On the host, the memory limit is enforced by a cgroup v2 if possible.
+    /// Otherwise the data segment of the program is limited instead, in which case exceeding the memory limit cannot be
+    /// detected, and is most likely reported as a runtime error.
+    ///
+    /// The disk limit is enforced by measuring the working directory while the program runs, and by limiting the size
+    /// of every file it writes. With docker, the tmpfs at `/tmp` is only as large as the disk limit, so writing beyond
+    /// it fails rather than killing the program.
+    ///
+    /// The seccomp profile is enforced by a filter installed before executing the program on the host, and by docker
+    /// otherwise.
+    ///
+    /// The program is killed as well if its judgment is cancelled, which fails with [`CheckError::Cancelled`].
+    ///
+    /// The standard input of the program is the contents of the `stdin` file if one is given, and empty otherwise.
+    /// The standard error of the program is captured up to the output limit, while its standard output is discarded.
+    pub fn execute(
+        &self,
+        dir: &Path,
+        program: &str,
+        args: &[&str],
+        stdin: Option<&Path>,
+        limits: &Limits,
+    ) -> Result<Execution, CheckError> {
+        self.run(dir, program, args, stdin, limits, None)
+    }
+
+    /// Executes `program` like [`Sandbox::execute`], while forwarding its standard output and error to the sink as
+    /// they are written, each up to the output limit.
+    ///
+    /// Every chunk is forwarded before the execution is returned.
+    pub fn stream(
+        &self,
+        dir: &Path,
+        program: &str,
+        args: &[&str],
+        stdin: Option<&Path>,
+        limits: &Limits,
+        sink: &Sink,
+    ) -> Result<Execution, CheckError> {
+        self.run(dir, program, args, stdin, limits, Some(sink))
+    }
+
+    fn run(
+        &self,
+        dir: &Path,
+        program: &str,
+        args: &[&str],
+        stdin: Option<&Path>,
+        limits: &Limits,
+        sink: Option<&Sink>,
+    ) -> Result<Execution, CheckError> {
+        if *self == Self::Fake {
+            return fake::run(dir, args, limits);
+        }
+        let input = stdin.map_or(Input::Closed, Input::File);
+        let mut running = self.spawn(dir, program, args, limits, input, sink)?;
+
+        loop {
+            if let Some(execution) = running.poll()? {
+                return Ok(execution);
+            }
+            thread::sleep(POLL_INTERVAL);
+        }
+    }
+
+    /// Spawns `program` with `args` inside the sandbox subject to the limits, which is then polled until it exits.
+    ///
+    /// The standard input and output of an interactive program are piped, and the standard output is discarded
+    /// otherwise, unless it is streamed to a sink along with the standard error.
+    fn spawn<'a>(
+        &'a self,
+        dir: &'a Path,
+        program: &str,
+        args: &[&str],
+        limits: &'a Limits,
+        input: Input,
+        sink: Option<&Sink>,
+    ) -> Result<Running<'a>, CheckError> {
+        if fault::injected(dir, Fault::SandboxStart) {
+            return Err(CheckError::Sandbox);
+        }
+        // the fake cannot converse with another program, as it spawns none
+        if *self == Self::Fake {
+            return Err(CheckError::Sandbox);
+        }
+        let name = container_name();
+        let network = !limits.network.is_none();
+        let namespace = match (self, &limits.network) {
+            (_, NetworkPolicy::None) => None,
+            // the dev sandbox leaves the network unconfined, so everything is reachable
+            (Self::Dev | Self::Fake, _) => None,
+            (Self::Host, policy) => match Namespace::create(policy.endpoints()) {
+                Ok(namespace) => Some(namespace),
+                Err(_) => return Err(CheckError::Sandbox),
+            },
+            // a container without a network still has a loopback interface of its own
+            (Self::Docker { .. }, NetworkPolicy::LoopbackOnly) => None,
+            // docker cannot join the namespace the endpoints are forwarded into, and a warm container forbids sockets
+            (Self::Docker { .. }, NetworkPolicy::Allow(_)) | (Self::Warm { .. }, _) => {
+                return Err(CheckError::Sandbox)
+            }
+        };
+        let (cgroup, docker_profile) = match self {
+            Self::Host => (Cgroup::create(limits.memory, limits.processes), None),
+            Self::Dev | Self::Fake => (None, None),
+            Self::Docker { .. } => {
+                let Ok(profile) = seccomp::docker_profile(limits.seccomp, network) else {
+                    return Err(CheckError::IOInteraction);
+                };
+                (None, profile)
+            }
+            // the seccomp profile of a warm container is fixed, while its memory and process limits are not
+            Self::Warm {
+                container,
+                dir: mounted,
+            } => {
+                // the unprivileged user cannot create files in the directory of the container, so its pid file is
+                // created for it
+                if let Some(user) = limits.user {
+                    let pid_file = pid_file(mounted, &name);
+                    let created = fs::File::create(&pid_file)
+                        .and_then(|_| chown(&pid_file, Some(user.uid), Some(user.gid)));
+                    if created.is_err() {
+                        return Err(CheckError::IOInteraction);
+                    }
+                }
+                let memory = limits.memory.to_string();
+                let processes = pids_limit(limits.processes);
+                let updated = docker(&[
+                    "update",
+                    "--memory",
+                    &memory,
+                    "--memory-swap",
+                    &memory,
+                    "--pids-limit",
+                    &processes,
+                    container,
+                ]);
+                if updated.is_err() {
+                    return Err(CheckError::Sandbox);
+                }
+                (None, None)
+            }
+        };
+        let confinement = Confinement {
+            limits,
+            cgroup: cgroup.as_ref(),
+            namespace: namespace.as_ref(),
+            docker_profile,
+            interactive: !matches!(input, Input::Closed),
+        };
+        let mut command = self.build(dir, program, args, &name, Some(confinement));
+        // the standard output of an interactive program belongs to its conversation, so it is never streamed
+        let sink = sink.filter(|_| !matches!(input, Input::Interactive));
+        let discarded = || match sink {
+            Some(_) => Stdio::piped(),
+            None => Stdio::null(),
+        };
+        let (stdin, stdout) = match input {
+            Input::Closed => (Stdio::null(), discarded()),
+            Input::File(path) => match fs::File::open(path) {
+                Ok(file) => (Stdio::from(file), discarded()),
+                Err(_) => return Err(CheckError::IOInteraction),
+            },
+            Input::Interactive => (Stdio::piped(), Stdio::piped()),
+        };
+        command.stdin(stdin).stdout(stdout).stderr(Stdio::piped());
+
+        // the baseline is measured before spawning, as a fast program could write everything before the first poll
+        let quota = Quota::begin(dir, limits.disk);
+        let measurement = match quota {
+            Some(_) => None,
+            None => Some(Measurement::begin(dir, limits.disk)),
+        };
+
+        let started = Instant::now();
+        let Ok(mut child) = command.spawn() else {
+            return Err(CheckError::IOInteraction);
+        };
+
+        // the pipe is drained concurrently, so the program never blocks on a full pipe
+        let mut stderr_pipe = child.stderr.take().expect("stderr is piped");
+        let (head, tail) = (limits.output, limits.output_tail);
+        let stderr_sink = sink.map(|sink| (sink.clone(), Channel::Stderr));
+        let stderr_reader =
+            thread::spawn(move || read_truncated(&mut stderr_pipe, head, tail, stderr_sink));
+        let stdout_reader = sink.map(|sink| {
+            let mut stdout_pipe = child.stdout.take().expect("stdout is piped");
+            let stdout_sink = Some((sink.clone(), Channel::Stdout));
+            thread::spawn(move || read_truncated(&mut stdout_pipe, head, tail, stdout_sink))
+        });
+
+        Ok(Running {
+            sandbox: self,
+            limits,
+            child,
+            name,
+            cgroup,
+            namespace,
+            quota,
+            measurement,
+            started,
+            disk_measured: started,
+            stderr_reader: Some(stderr_reader),
+            stdout_reader,
+        })
+    }
+
+    fn build(
+        &self,
+        dir: &Path,
+        program: &str,
+        args: &[&str],
+        name: &str,
+        confinement: Option<Confinement>,
+    ) -> Command {
+        let (work_dir, env) = match &confinement {
+            Some(Confinement { limits, .. }) => {
+                (limits.work_dir.as_deref().unwrap_or(dir), Some(&limits.env))
+            }
+            None => (dir, None),
+        };
+        let env = env.into_iter().flatten();
+
+        match self {
+            Self::Host | Self::Dev | Self::Fake => {
+                let mut command = Command::new(program);
+                command.args(args).current_dir(work_dir);
+                // the environment of mozart may hold its secrets, so a confined program only learns where programs are
+                if confinement.is_some() && *self == Self::Host {
+                    command
+                        .env_clear()
+                        .env("HOME", work_dir)
+                        .env("TMPDIR", work_dir);
+                    if let Some(path) = std::env::var_os("PATH") {
+                        command.env("PATH", path);
+                    }
+                }
+                command.envs(env);
+
+                // the dev sandbox only keeps the processes of the program together, so they are killed along with it
+                if confinement.is_some() && *self == Self::Dev {
+                    command.process_group(0);
+                }
+                if let Some(Confinement {
+                    limits,
+                    cgroup,
+                    namespace,
+                    ..
+                }) = confinement.filter(|_| *self == Self::Host)
+                {
+                    // a separate process group allows killing every process spawned by the program
+                    command.process_group(0);
+
+                    let cpu_seconds = cpu_seconds(limits.time);
+                    let memory = limits.memory;
+                    let disk = limits.disk;
+                    let user = limits.user;
+                    let procs_path = cgroup.map(Cgroup::procs_path);
+                    let namespace = namespace.map(Namespace::fd);
+                    let filter = Filter::new(limits.seccomp, namespace.is_some());
+                    // only root may create a mount namespace, which it has to be to change the user anyway
+                    let isolation = user.and_then(|_| Isolation::new(dir, work_dir).ok());
+                    // SAFETY: only async-signal-safe functions are called, and nothing is allocated in the closure.
+                    unsafe {
+                        command.pre_exec(move || {
+                            set_rlimit(libc::RLIMIT_CPU, cpu_seconds, cpu_seconds + 1)?;
+                            set_rlimit(libc::RLIMIT_FSIZE, disk, disk)?;
+                            set_rlimit(libc::RLIMIT_CORE, 0, 0)?;
+                            // the files the program creates are its own, which mozart can read regardless
+                            libc::umask(0o077);
+
+                            match &procs_path {
+                                Some(procs_path) => cgroup::enter(procs_path)?,
+                                None => set_rlimit(libc::RLIMIT_DATA, memory, memory)?,
+                            }
+                            if let Some(namespace) = namespace {
+                                network::enter(namespace)?;
+                            }
+                            if let Some(isolation) = &isolation {
+                                isolation.enter()?;
+                            }
+                            // the privileges are dropped once entering the cgroup and the namespaces no longer need them
+                            if let Some(user) = user {
+                                drop_privileges(user)?;
+                            }
+
+                            // the filter is installed last, so it does not apply to setting up the limits
+                            if let Some(filter) = &filter {
+                                filter.install()?;
+                            }
+
+                            Ok(())
+                        });
+                    }
+                }
+
+                command
+            }
+            Self::Docker { image, runtime } => {
+                let fixture_dir = dir.join(FIXTURE_DIR);
+                let mut command = docker_run(name, runtime.as_deref(), dir, work_dir);
+                for (name, value) in env {
+                    command.arg("--env").arg(format!("{name}={value}"));
+                }
+                if fixture_dir.is_dir() {
+                    let fixture_dir = fixture_dir.display();
+                    command
+                        .arg("--volume")
+                        .arg(format!("{fixture_dir}:{fixture_dir}:ro"));
+                }
+
+                if let Some(Confinement {
+                    limits,
+                    docker_profile,
+                    interactive,
+                    ..
+                }) = confinement
+                {
+                    if interactive {
+                        command.arg("--interactive");
+                    }
+                    if let Some(user) = limits.user {
+                        command
+                            .arg("--user")
+                            .arg(format!("{}:{}", user.uid, user.gid));
+                    }
+                    let cpu_seconds = cpu_seconds(limits.time);
+                    command
+                        .arg("--tmpfs")
+                        .arg(format!("/tmp:size={}", limits.disk))
+                        .arg("--ulimit")
+                        .arg(format!("cpu={cpu_seconds}:{}", cpu_seconds + 1))
+                        .arg("--ulimit")
+                        .arg(format!("fsize={0}:{0}", limits.disk))
+                        .arg("--memory")
+                        .arg(limits.memory.to_string())
+                        .arg("--memory-swap")
+                        .arg(limits.memory.to_string())
+                        .arg("--pids-limit")
+                        .arg(pids_limit(limits.processes));
+                    if let Some(profile) = docker_profile {
+                        command
+                            .arg("--security-opt")
+                            .arg(format!("seccomp={}", profile.display()));
+                    }
+                } else {
+                    command.args(["--tmpfs", "/tmp"]);
+                }
+
+                command.arg(image).arg(program).args(args);
+
+                command
+            }
+            Self::Warm {
+                container,
+                dir: mounted,
+            } => {
+                let mut command = Command::new("docker");
+                command.arg("exec").arg("--workdir").arg(work_dir);
+                for (name, value) in env {
+                    command.arg("--env").arg(format!("{name}={value}"));
+                }
+
+                if let Some(Confinement {
+                    limits,
+                    interactive,
+                    ..
+                }) = confinement
+                {
+                    if interactive {
+                        command.arg("--interactive");
+                    }
+                    if let Some(user) = limits.user {
+                        command
+                            .arg("--user")
+                            .arg(format!("{}:{}", user.uid, user.gid));
+                    }
+                    // the shell records its pid before it becomes the program, so the program can be killed without
+                    // killing the other commands executing in the container
+                    let cpu_seconds = cpu_seconds(limits.time);
+                    let script = format!(
+                        "echo $$ > \"$0\" && ulimit -S -t {cpu_seconds} && ulimit -H -t {} && exec \"$@\"",
+                        cpu_seconds + 1
+                    );
+                    command
+                        .arg(container)
+                        .args(["sh", "-c", &script])
+                        .arg(pid_file(mounted, name));
+                } else {
+                    command.arg(container);
+                }
+
+                command.arg(program).args(args);
+
+                command
+            }
+        }
+    }
+
+    /// Starts a container of a docker sandbox ahead of time, which commands are executed in by the returned warm
+    /// sandbox.
+    ///
+    /// The directory of the container is created in `parent`, and named like the container, so a container which is
+    /// left behind can be found by its directory.
+    ///
+    /// The container is confined by the seccomp profile and the disk limit for as long as it runs, while its memory
+    /// limit is updated for every execution. It idles until it is removed.
+    pub fn warm_up(
+        &self,
+        parent: &Path,
+        seccomp: SeccompProfile,
+        disk: u64,
+        memory: u64,
+    ) -> io::Result<Sandbox> {
+        let Self::Docker { image, runtime } = self else {
+            return Err(io::Error::other("only a docker sandbox can be warmed up"));
+        };
+
+        let name = container_name();
+        let dir = parent.join(&name);
+        fs::create_dir_all(&dir)?;
+        let mut command = docker_run(&name, runtime.as_deref(), &dir, &dir);
+        command
+            .arg("--detach")
+            .arg("--tmpfs")
+            .arg(format!("/tmp:size={disk}"))
+            .arg("--ulimit")
+            .arg(format!("fsize={disk}:{disk}"))
+            .arg("--memory")
+            .arg(memory.to_string())
+            .arg("--memory-swap")
+            .arg(memory.to_string());
+        if let Some(profile) = seccomp::docker_profile(seccomp, false)? {
+            command
+                .arg("--security-opt")
+                .arg(format!("seccomp={}", profile.display()));
+        }
+        command
+            .args(["--entrypoint", "sleep"])
+            .arg(image)
+            .arg("infinity");
+
+        let err = match command.stdin(Stdio::null()).output() {
+            Ok(output) if output.status.success() => {
+                return Ok(Self::Warm {
+                    container: name,
+                    dir,
+                })
+            }
+            Ok(output) => {
+                io::Error::other(String::from_utf8_lossy(&output.stderr).trim().to_string())
+            }
+            Err(err) => err,
+        };
+
+        let _ = fs::remove_dir_all(&dir);
+        Err(err)
+    }
+
+    /// Resets a warm container for the next submission, killing every process but the idling one, and removing every
+    /// file in its tmpfs and in its directory.
+    ///
+    /// Fails if the container no longer runs, in which case it cannot be reused. Other sandboxes have nothing to reset.
+    pub fn reset(&self) -> io::Result<()> {
+        let Self::Warm { container, dir } = self else {
+            return Ok(());
+        };
+
+        // the shell is spared as well, and the seccomp profile may forbid it to fork, so only builtins are used
+        docker(&[
+            "exec",
+            container,
+            "sh",
+            "-c",
+            "kill -9 -1 2>/dev/null; true",
+        ])?;
+        docker(&[
+            "exec",
+            container,
+            "find",
+            "/tmp",
+            "-mindepth",
+            "1",
+            "-delete",
+        ])?;
+        for entry in fs::read_dir(dir)? {
+            let path = entry?.path();
+            match path.is_dir() {
+                true => fs::remove_dir_all(path)?,
+                false => fs::remove_file(path)?,
+            }
+        }
+
+        Ok(())
+    }
+
+    /// Removes a warm container along with its directory, while other sandboxes have nothing to remove.
+    pub fn remove(&self) -> io::Result<()> {
+        let Self::Warm { container, dir } = self else {
+            return Ok(());
+        };
+
+        docker(&["rm", "--force", container])?;
+        fs::remove_dir_all(dir)
+    }
+
+    /// Whether a command which exited with the code and standard error indicates that the sandbox itself failed,
+    /// rather than the executed program.
+    ///
+    /// Docker exits with 125, 126, or 127 if it fails to start the container or the program, but so may the program
+    /// itself, so only the error docker prints along with it tells them apart.
+    pub fn failed(&self, exit_code: Option<i32>, stderr: &str) -> bool {
+        match self {
+            Self::Host | Self::Dev | Self::Fake => false,
+            Self::Docker { .. } | Self::Warm { .. } => {
+                matches!(exit_code, Some(125..=127))
+                    && stderr
+                        .lines()
+                        .rfind(|line| !line.trim().is_empty())
+                        .is_some_and(|line| {
+                            DOCKER_ERRORS.iter().any(|prefix| line.starts_with(prefix))
+                        })
+            }
+        }
+    }
+
+    /// Whether the program was killed by the kernel, as it made a system call which its seccomp profile forbids.
+    ///
+    /// Only a process which was really terminated by `SIGSYS` counts, which only the host sandbox can tell, as docker
+    /// reports a process killed by a signal with the same exit code a program could exit with itself.
+    fn violated_seccomp(&self, status: ExitStatus) -> bool {
+        match self {
+            Self::Host => status.signal() == Some(libc::SIGSYS),
+            Self::Dev | Self::Fake | Self::Docker { .. } | Self::Warm { .. } => false,
+        }
+    }
+
+    /// Whether the program was killed by the kernel, as it wrote a file beyond its file size limit.
+    fn exceeded_file_size_limit(&self, status: ExitStatus) -> bool {
+        match self {
+            Self::Host => status.signal() == Some(libc::SIGXFSZ),
+            Self::Dev | Self::Fake => false,
+            Self::Docker { .. } | Self::Warm { .. } => {
+                status.code() == Some(DOCKER_FILE_SIZE_EXIT_CODE)
+            }
+        }
+    }
+
+    /// Whether the program was killed by the kernel, as it exceeded its CPU time limit.
+    fn exceeded_cpu_limit(&self, status: ExitStatus) -> bool {
+        match self {
+            Self::Host => status.signal() == Some(libc::SIGXCPU),
+            Self::Dev | Self::Fake => false,
+            Self::Docker { .. } | Self::Warm { .. } => {
+                status.code() == Some(DOCKER_CPU_LIMIT_EXIT_CODE)
+            }
+        }
+    }
+
+    /// Whether the program was killed, as it exceeded its memory limit.
+    fn exceeded_memory_limit(&self, status: ExitStatus, cgroup: Option<&Cgroup>) -> bool {
+        match self {
+            Self::Host | Self::Dev | Self::Fake => cgroup.is_some_and(Cgroup::oom_killed),
+            Self::Docker { .. } | Self::Warm { .. } => {
+                status.code() == Some(DOCKER_KILLED_EXIT_CODE)
+            }
+        }
+    }
+
+    /// Kills an execution along with every process it has spawned.
+    fn kill(&self, child: &mut Child, name: &str) {
+        match self {
+            Self::Host | Self::Dev | Self::Fake => {
+                // SAFETY: the child is the leader of its own process group, so only its processes are signalled.
+                unsafe {
+                    libc::kill(-(child.id() as i32), libc::SIGKILL);
+                }
+            }
+            Self::Docker { .. } => {
+                // killing the docker client does not stop the container
+                let _ = docker(&["kill", name]);
+            }
+            Self::Warm { container, dir } => {
+                // nor does it stop the program, which is killed by the pid its shell recorded, unless it was not
+                // recorded yet, in which case the program is left to the reset of the container
+                let pid = fs::read_to_string(pid_file(dir, name))
+                    .ok()
+                    .and_then(|pid| pid.trim().parse::<u32>().ok())
+                    .filter(|pid| *pid > 1);
+                if let Some(pid) = pid {
+                    let _ = docker(&["exec", container, "sh", "-c", &format!("kill -9 {pid}")]);
+                }
+            }
+        }
+
+        let _ = child.kill();
+        let _ = child.wait();
+    }
+}
+
+/// A spawned execution, which is polled until it exits by itself or is killed for exceeding its limits.
+struct Running<'a> {
+    sandbox: &'a Sandbox,
+    limits: &'a Limits,
+    child: Child,
+    /// The name of the container of the execution, which also names it on the host.
+    name: String,
+    cgroup: Option<Cgroup>,
+    /// The network namespace of the execution, which forwards its endpoints until the execution is finished.
+    namespace: Option<Namespace>,
+    /// The limit of the size of the workspace, if it is a tmpfs of its own, in which case it is not measured.
+    quota: Option<Quota>,
+    /// The measurement of the working directory, unless it has a quota.
+    measurement: Option<Measurement>,
+    started: Instant,
+    disk_measured: Instant,
+    /// The thread draining the standard error, which is joined once the execution is finished.
+    stderr_reader: Option<JoinHandle<String>>,
+    /// The thread streaming the standard output, if it is streamed, which is joined along with the standard error.
+    stdout_reader: Option<JoinHandle<String>>,
+}
+
+impl Running<'_> {
+    /// Checks whether the execution has exited without blocking, killing it if it exceeded its time or disk limit, or
+    /// if its judgment was cancelled.
+    ///
+    /// The execution is finished once it has exited or was killed, so it must not be polled again afterwards.
+    fn poll(&mut self) -> Result<Option<Execution>, CheckError> {
+        let sandbox = self.sandbox;
+        let (outcome, usage) = match try_wait_with_usage(&self.child) {
+            Ok(Some((status, usage))) => {
+                let outcome = if sandbox.violated_seccomp(status) {
+                    Outcome::SecurityViolation
+                } else if sandbox.exceeded_file_size_limit(status) || self.exceeded_disk_limit() {
+                    Outcome::OutputExceeded
+                } else if sandbox.exceeded_cpu_limit(status) {
+                    Outcome::TimedOut
+                } else if sandbox.exceeded_memory_limit(status, self.cgroup.as_ref()) {
+                    Outcome::MemoryExceeded
+                } else {
+                    Outcome::Exited(status)
+                };
+
+                // the usage of the docker client says nothing about the program
+                let usage = match sandbox {
+                    Sandbox::Host | Sandbox::Dev | Sandbox::Fake => Some(usage),
+                    Sandbox::Docker { .. } | Sandbox::Warm { .. } => None,
+                };
+
+                (outcome, usage)
+            }
+            Ok(None) if self.limits.cancellation.is_cancelled() => {
+                self.kill();
+                return Err(CheckError::Cancelled);
+            }
+            Ok(None) if self.started.elapsed() >= self.limits.time => {
+                self.kill();
+                (Outcome::TimedOut, None)
+            }
+            // the quota is checked on every poll, as it only takes a system call, unlike measuring the workspace
+            Ok(None) if self.quota.as_ref().is_some_and(Quota::exhausted) => {
+                self.kill();
+                (Outcome::OutputExceeded, None)
+            }
+            Ok(None)
+                if self.quota.is_none() && self.disk_measured.elapsed() >= DISK_POLL_INTERVAL =>
+            {
+                if !self.exceeded_disk_limit() {
+                    self.disk_measured = Instant::now();
+                    return Ok(None);
+                }
+                self.kill();
+                (Outcome::OutputExceeded, None)
+            }
+            Ok(None) => return Ok(None),
+            Err(_) => return Err(CheckError::IOInteraction),
+        };
+
+        let execution = self.finish(outcome, usage);
+        // the standard error telling whether docker failed is only complete once the execution is finished
+        match &execution.outcome {
+            Outcome::Exited(status) if sandbox.failed(status.code(), &execution.stderr) => {
+                Err(CheckError::Sandbox)
+            }
+            _ => Ok(Some(execution)),
+        }
+    }
+
+    /// Kills the execution before it exits by itself, finishing it as timed out.
+    fn stop(&mut self) -> Execution {
+        self.kill();
+        self.finish(Outcome::TimedOut, None)
+    }
+
+    /// Finishes the execution, whose resource usage is only known if it exited by itself on the host.
+    fn finish(&mut self, outcome: Outcome, usage: Option<libc::rusage>) -> Execution {
+        let runtime = self.started.elapsed();
+        if let Sandbox::Warm { dir, .. } = self.sandbox {
+            let _ = fs::remove_file(pid_file(dir, &self.name));
+        }
+        // nothing is forwarded to the endpoints of a finished execution, and the workspace gets its own size back
+        self.namespace = None;
+        self.quota = None;
+        self.measurement = None;
+        if let Some(reader) = self.stdout_reader.take() {
+            let _ = reader.join();
+        }
+        let stderr = self
+            .stderr_reader
+            .take()
+            .and_then(|reader| reader.join().ok())
+            .unwrap_or_default();
+
+        Execution {
+            outcome,
+            stderr,
+            runtime,
+            peak_memory: usage.as_ref().map(max_rss_bytes),
+            cpu_time: usage.as_ref().map(|usage| CpuTime {
+                user: duration(usage.ru_utime),
+                system: duration(usage.ru_stime),
+            }),
+            exceeded_process_limit: self
+                .cgroup
+                .as_ref()
+                .is_some_and(Cgroup::exceeded_process_limit),
+        }
+    }
+
+    fn exceeded_disk_limit(&self) -> bool {
+        match &self.quota {
+            Some(quota) => quota.exhausted(),
+            None => self.measurement.as_ref().is_some_and(Measurement::exceeded),
+        }
+    }
+
+    /// Kills the execution along with every process it has spawned.
+    fn kill(&mut self) {
+        self.sandbox.kill(&mut self.child, &self.name);
+    }
+
+    /// Gets the CPU time the execution has used so far, which can only be measured on the host.
+    fn cpu_time(&self) -> Option<Duration> {
+        match self.sandbox {
+            Sandbox::Host | Sandbox::Dev | Sandbox::Fake => cpu_time(self.child.id()),
+            Sandbox::Docker { .. } | Sandbox::Warm { .. } => None,
+        }
+    }
+}
+
+/// Checks whether the child has exited without blocking, along with its resource usage if it has.
+fn try_wait_with_usage(child: &Child) -> io::Result<Option<(ExitStatus, libc::rusage)>> {
+    let mut status = 0;
+    // SAFETY: rusage is a plain C struct, for which all zeroes is a valid value.
+    let mut usage = unsafe { std::mem::zeroed::<libc::rusage>() };
+
+    // SAFETY: the pointers are valid for the duration of the call, and the pid belongs to an unreaped child.
+    let pid = unsafe { libc::wait4(child.id() as i32, &mut status, libc::WNOHANG, &mut usage) };
+    match pid {
+        0 => Ok(None),
+        pid if pid < 0 => Err(io::Error::last_os_error()),
+        _ => Ok(Some((ExitStatus::from_raw(status), usage))),
+    }
+}
+
+/// Reads the pipe until it is closed, keeping at most the first `head` and the last `tail` bytes, and marking how many
+/// bytes were left out between them.
+///
+/// The rest is still read, so the program never blocks on a full pipe. The head is forwarded to the sink as it is
+/// read, if one is given, and the marker and the tail once the pipe is closed, as the tail is only known by then.
+fn read_truncated(
+    pipe: &mut impl Read,
+    head: usize,
+    tail: usize,
+    sink: Option<(Sink, Channel)>,
+) -> String {
+    let mut kept = Vec::new();
+    let mut last = VecDeque::new();
+    let mut truncated = 0;
+    let mut buffer = [0; 8192];
+    loop {
+        let read = match pipe.read(&mut buffer) {
+            Ok(0) => break,
+            Ok(read) => read,
+            Err(err) if err.kind() == io::ErrorKind::Interrupted => continue,
+            Err(_) => break,
+        };
+        let keep = read.min(head - kept.len());
+        if let Some((sink, channel)) = sink.as_ref().filter(|_| keep > 0) {
+            sink(*channel, &buffer[..keep]);
+        }
+        kept.extend_from_slice(&buffer[..keep]);
+
+        last.extend(&buffer[keep..read]);
+        let dropped = last.len().saturating_sub(tail);
+        last.drain(..dropped);
+        truncated += dropped;
+    }
+    // a tail which starts in the middle of a character starts at the next one instead
+    if truncated > 0 {
+        let continuation = last.iter().take_while(|&&byte| byte & 0xc0 == 0x80).count();
+        last.drain(..continuation);
+        truncated += continuation;
+    }
+
+    let marker = match truncated {
+        0 => String::new(),
+        _ => format!("\n[{truncated} more bytes were truncated]\n"),
+    };
+    let last = Vec::from(last);
+    if let Some((sink, channel)) = &sink {
+        for chunk in [marker.as_bytes(), &last] {
+            if !chunk.is_empty() {
+                sink(*channel, chunk);
+            }
+        }
+    }
+    kept.extend_from_slice(marker.as_bytes());
+    kept.extend_from_slice(&last);
+    String::from_utf8_lossy(&kept).into_owned()
+}
+
+/// Reads everything from the pipe, keeping whatever was read if it fails midway.
+fn read_all(pipe: &mut impl Read) -> Vec<u8> {
+    let mut output = Vec::new();
+    let _ = pipe.read_to_end(&mut output);
+    output
+}
+
+/// Gets the CPU time the process has used in user and kernel mode, if it is still running.
+fn cpu_time(pid: u32) -> Option<Duration> {
+    let stat = fs::read_to_string(format!("/proc/{pid}/stat")).ok()?;
+    // the name of the process is in parentheses and may contain spaces, so the fields are counted after it
+    let mut fields = stat.rsplit_once(')')?.1.split_whitespace().skip(11);
+    let user: u64 = fields.next()?.parse().ok()?;
+    let system: u64 = fields.next()?.parse().ok()?;
+
+    // SAFETY: sysconf has no preconditions.
+    let ticks_per_second = u64::try_from(unsafe { libc::sysconf(libc::_SC_CLK_TCK) }).ok()?;
+    Some(Duration::from_millis(
+        (user + system) * 1000 / ticks_per_second.max(1),
+    ))
+}
+
+/// Gets the peak resident memory in bytes, which linux reports in kibibytes, while macOS reports it in bytes.
+fn max_rss_bytes(usage: &libc::rusage) -> u64 {
+    let max_rss = u64::try_from(usage.ru_maxrss).unwrap_or_default();
+    match cfg!(target_os = "macos") {
+        true => max_rss,
+        false => max_rss * 1024,
+    }
+}
+
+/// Converts a time of a resource usage into a duration, where a negative time cannot occur.
+fn duration(time: libc::timeval) -> Duration {
+    Duration::from_secs(u64::try_from(time.tv_sec).unwrap_or_default())
+        + Duration::from_micros(u64::try_from(time.tv_usec).unwrap_or_default())
+}
+
+/// Generates a unique name for a container.
+fn container_name() -> String {
+    format!("mozart-{}", Uuid::new_v4())
+}
+
+/// Creates the command running a container which has no network access, capabilities, or writable root filesystem,
+/// and which only mounts `dir` of the host, at the same path, with `work_dir` within it as its working directory.
+fn docker_run(name: &str, runtime: Option<&str>, dir: &Path, work_dir: &Path) -> Command {
+    let dir = dir.display();
+    let mut command = Command::new("docker");
+    command
+        .args(["run", "--rm", "--network", "none", "--read-only"])
+        .args(["--cap-drop", "ALL"])
+        .args(["--security-opt", "no-new-privileges"])
+        .args(["--name", name]);
+    if let Some(runtime) = runtime {
+        command.args(["--runtime", runtime]);
+    }
+    command
+        .arg("--volume")
+        .arg(format!("{dir}:{dir}"))
+        .arg("--workdir")
+        .arg(work_dir);
+
+    command
+}
+
+/// Runs a docker command to completion, discarding its output, which fails unless it succeeds within
+/// [`DOCKER_TIMEOUT`].
+fn docker(args: &[&str]) -> io::Result<()> {
+    let mut child = Command::new("docker")
+        .args(args)
+        .stdin(Stdio::null())
+        .stdout(Stdio::null())
+        .stderr(Stdio::null())
+        .spawn()?;
+
+    let deadline = Instant::now() + DOCKER_TIMEOUT;
+    let status = loop {
+        if let Some(status) = child.try_wait()? {
+            break status;
+        }
+        if Instant::now() >= deadline {
+            let _ = child.kill();
+            let _ = child.wait();
+            return Err(io::Error::new(
+                ErrorKind::TimedOut,
+                format!("docker {} timed out", args[0]),
+            ));
+        }
+        thread::sleep(POLL_INTERVAL);
+    };
+
+    match status.success() {
+        true => Ok(()),
+        false => Err(io::Error::other(format!(
+            "docker {} failed with {status}",
+            args[0]
+        ))),
+    }
+}
+
+/// Gets the file in the directory of a warm container which the execution of the given name records its pid in.
+fn pid_file(dir: &Path, name: &str) -> PathBuf {
+    dir.join(format!(".{name}.pid"))
+}
+
+/// The type of resource identifiers, which differs between the C standard libraries.
+#[cfg(target_env = "gnu")]
+type Resource = libc::__rlimit_resource_t;
+#[cfg(not(target_env = "gnu"))]
+type Resource = libc::c_int;
+
+/// Sets a resource limit of the calling process.
+fn set_rlimit(resource: Resource, soft: u64, hard: u64) -> io::Result<()> {
+    let limit = libc::rlimit {
+        rlim_cur: soft,
+        rlim_max: hard,
+    };
+
+    // SAFETY: the limit is a valid rlimit, and setrlimit is async-signal-safe.
+    if unsafe { libc::setrlimit(resource, &limit) } != 0 {
+        return Err(io::Error::last_os_error());
+    }
+
+    Ok(())
+}
+
+/// Makes the calling process run as the given user and group, without any supplementary groups, for good.
+fn drop_privileges(user: SandboxUser) -> io::Result<()> {
+    // SAFETY: setgroups, setgid, and setuid are async-signal-safe, and no groups are read from the null pointer.
+    let dropped = unsafe {
+        libc::setgroups(0, std::ptr::null()) == 0
+            && libc::setgid(user.gid) == 0
+            && libc::setuid(user.uid) == 0
+    };
+    if !dropped {
+        return Err(io::Error::last_os_error());
+    }
+
+    Ok(())
+}
+
+/// Hands a workspace over to the unprivileged user solutions are executed as, by making it the owner of every
+/// directory of the workspace but the fixture directory, so the solutions can create files in them.
+///
+/// The files are left to mozart, so a solution can read them, but cannot change the files mozart wrote.
+pub fn hand_over(dir: &Path, user: Option<SandboxUser>) -> io::Result<()> {
+    let Some(user) = user else {
+        return Ok(());
+    };
+
+    chown(dir, Some(user.uid), Some(user.gid))?;
+    for entry in fs::read_dir(dir)? {
+        let entry = entry?;
+        // symlinks are not followed, as they lead back into the workspace, or out of it
+        if entry.file_type()?.is_dir() && entry.file_name() != FIXTURE_DIR {
+            hand_over(&entry.path(), Some(user))?;
+        }
+    }
+
+    Ok(())
+}
+
+/// Formats a process limit for docker, where -1 is unlimited.
+fn pids_limit(processes: u64) -> String {
+    match processes {
+        0 => String::from("-1"),
+        processes => processes.to_string(),
+    }
+}
+
+/// Rounds a duration up to whole seconds, as used by the CPU time limit.
+fn cpu_seconds(time: Duration) -> u64 {
+    time.as_secs() + u64::from(time.subsec_nanos() > 0)
+}
+
+#[cfg(test)]
+mod command {
+    use super::{
+        hand_over, read_truncated, Channel, Confinement, Limits, Namespace, Outcome, Sandbox, Sink,
+    };
+    use crate::{
+        cancel::Cancellation,
+        config::{SandboxUser, SeccompProfile},
+        error::CheckError,
+        model::NetworkPolicy,
+    };
+    use std::{
+        collections::BTreeMap,
+        env,
+        ffi::OsStr,
+        fs,
+        io::Write,
+        net::{Ipv4Addr, TcpListener},
+        os::unix::fs::PermissionsExt,
+        path::{Path, PathBuf},
+        sync::{Arc, Mutex},
+        thread,
+        time::Duration,
+    };
+    use uuid::Uuid;
+
+    /// Creates an empty working directory, as the disk usage of the working directory is measured.
+    fn workspace() -> PathBuf {
+        let dir = env::temp_dir().join(format!("mozart-sandbox-{}", Uuid::new_v4()));
+        fs::create_dir(&dir).expect("failed to create workspace");
+        dir
+    }
+
+    #[test]
+    fn host() {
+        let sandbox = Sandbox::Host;
+
+        let actual = sandbox.command(Path::new("/tmp/task"), "ghc", &["-O2", "Test.hs"]);
+
+        assert_eq!(actual.get_program(), "ghc");
+        assert_eq!(
+            actual.get_args().collect::<Vec<&OsStr>>(),
+            vec!["-O2", "Test.hs"]
+        );
+        assert_eq!(actual.get_current_dir(), Some(Path::new("/tmp/task")));
+    }
+
+    #[test]
+    fn docker() {
+        let sandbox = Sandbox::Docker {
+            image: String::from("haskell:9.8"),
+            runtime: None,
+        };
+
+        let actual = sandbox.command(Path::new("/tmp/task"), "ghc", &["-O2", "Test.hs"]);
+        let args = actual.get_args().collect::<Vec<&OsStr>>();
+
+        assert_eq!(actual.get_program(), "docker");
+        assert!(args.windows(2).any(|w| w == ["--network", "none"]));
+        assert!(args.contains(&OsStr::new("--read-only")));
+        assert!(args
+            .windows(2)
+            .any(|w| w == ["--volume", "/tmp/task:/tmp/task"]));
+        assert!(args.ends_with(&[
+            OsStr::new("haskell:9.8"),
+            OsStr::new("ghc"),
+            OsStr::new("-O2"),
+            OsStr::new("Test.hs")
+        ]));
+    }
+
+    #[test]
+    fn docker_runtime() {
+        let sandbox = Sandbox::Docker {
+            image: String::from("haskell:9.8"),
+            runtime: Some(String::from("runsc")),
+        };
+
+        let actual = sandbox.command(Path::new("/tmp/task"), "ghc", &["Test.hs"]);
+        let args = actual.get_args().collect::<Vec<&OsStr>>();
+
+        assert!(args.windows(2).any(|w| w == ["--runtime", "runsc"]));
+        assert!(args.ends_with(&[
+            OsStr::new("haskell:9.8"),
+            OsStr::new("ghc"),
+            OsStr::new("Test.hs")
+        ]));
+    }
+
+    #[test]
+    fn warm() {
+        let sandbox = Sandbox::Warm {
+            container: String::from("mozart-warm"),
+            dir: PathBuf::from("/tmp/warm"),
+        };
+        let limits = Limits {
+            time: Duration::from_millis(1500),
+            memory: 256 * 1024 * 1024,
+            disk: 1024,
+            output: 1024,
+            output_tail: 0,
+            seccomp: SeccompProfile::default(),
+            processes: 0,
+            network: NetworkPolicy::None,
+            cancellation: Cancellation::default(),
+            env: BTreeMap::from([(String::from("LANG"), String::from("C.UTF-8"))]),
+            work_dir: Some(PathBuf::from("/tmp/warm/task/cases/0")),
+            user: None,
+        };
+        let confinement = Confinement {
+            limits: &limits,
+            cgroup: None,
+            namespace: None,
+            docker_profile: None,
+            interactive: false,
+        };
+
+        let actual = sandbox.build(
+            Path::new("/tmp/warm/task"),
+            "python3",
+            &["test.py"],
+            "mozart-execution",
+            Some(confinement),
+        );
+        let args = actual.get_args().collect::<Vec<&OsStr>>();
+
+        assert_eq!(actual.get_program(), "docker");
+        assert_eq!(
+            args[..6],
+            [
+                "exec",
+                "--workdir",
+                "/tmp/warm/task/cases/0",
+                "--env",
+                "LANG=C.UTF-8",
+                "mozart-warm"
+            ]
+        );
+        assert!(args[8]
+            .to_string_lossy()
+            .contains("ulimit -S -t 2 && ulimit -H -t 3"));
+        assert!(args.ends_with(&[
+            OsStr::new("/tmp/warm/.mozart-execution.pid"),
+            OsStr::new("python3"),
+            OsStr::new("test.py")
+        ]));
+    }
+
+    #[test]
+    fn host_never_fails() {
+        let sandbox = Sandbox::Host;
+
+        assert!(!sandbox.failed(
+            Some(125),
+            "docker: Error response from daemon: no such image"
+        ));
+    }
+
+    #[test]
+    fn docker_failure() {
+        let sandbox = Sandbox::Docker {
+            image: String::from("haskell:9.8"),
+            runtime: None,
+        };
+
+        assert!(sandbox.failed(
+            Some(125),
+            "Unable to find image 'haskell:9.8' locally\ndocker: Error response from daemon: pull access denied.\n"
+        ));
+        assert!(sandbox.failed(
+            Some(126),
+            "OCI runtime exec failed: exec failed: permission denied: unknown\n"
+        ));
+        assert!(!sandbox.failed(Some(1), "docker: Error response from daemon: no such image"));
+    }
+
+    #[test]
+    fn program_exiting_like_docker() {
+        let sandbox = Sandbox::Docker {
+            image: String::from("haskell:9.8"),
+            runtime: None,
+        };
+
+        assert!(!sandbox.failed(Some(125), ""));
+        assert!(!sandbox.failed(Some(127), "main: exiting with 127\n"));
+    }
+
+    #[test]
+    fn host_exits() {
+        let sandbox = Sandbox::Host;
+        let limits = Limits {
+            time: Duration::from_secs(5),
+            memory: 256 * 1024 * 1024,
+            disk: 1024 * 1024,
+            output: 1024,
+            output_tail: 0,
+            seccomp: SeccompProfile::Isolated,
+            processes: 0,
+            network: NetworkPolicy::None,
+            cancellation: Cancellation::default(),
+            env: BTreeMap::new(),
+            work_dir: None,
+            user: None,
+        };
+        let dir = workspace();
+
+        let actual = sandbox.execute(&dir, "true", &[], None, &limits);
+        let _ = fs::remove_dir_all(&dir);
+
+        assert!(
+            matches!(actual, Ok(execution) if matches!(execution.outcome, Outcome::Exited(status) if status.success()))
+        );
+    }
+
+    #[test]
+    fn host_env_and_work_dir() {
+        let sandbox = Sandbox::Host;
+        let dir = workspace();
+        let work_dir = dir.join("cases").join("0");
+        fs::create_dir_all(&work_dir).unwrap();
+        fs::write(work_dir.join("input.txt"), "hello").unwrap();
+        let limits = Limits {
+            time: Duration::from_secs(5),
+            memory: 256 * 1024 * 1024,
+            disk: 1024 * 1024,
+            output: 1024,
+            output_tail: 0,
+            seccomp: SeccompProfile::NoNetwork,
+            processes: 0,
+            network: NetworkPolicy::None,
+            cancellation: Cancellation::default(),
+            env: BTreeMap::from([(String::from("GREETING"), String::from("hello"))]),
+            work_dir: Some(work_dir),
+            user: None,
+        };
+
+        let actual = sandbox.execute(
+            &dir,
+            "sh",
+            &["-c", "test \"$(cat input.txt)\" = \"$GREETING\""],
+            None,
+            &limits,
+        );
+        let _ = fs::remove_dir_all(&dir);
+
+        assert!(
+            matches!(actual, Ok(execution) if matches!(execution.outcome, Outcome::Exited(status) if status.success()))
+        );
+    }
+
+    #[test]
+    fn host_drops_privileges() {
+        // only root may become another user
+        if unsafe { libc::geteuid() } != 0 {
+            return;
+        }
+        let sandbox = Sandbox::Host;
+        let dir = workspace();
+        let outside = workspace();
+        // anyone may write to the directory, like to /tmp, so only the mount namespace keeps the program out of it
+        fs::set_permissions(&outside, fs::Permissions::from_mode(0o1777)).unwrap();
+        fs::write(dir.join("given.txt"), "hello").unwrap();
+        let user = SandboxUser {
+            uid: 65534,
+            gid: 65534,
+        };
+        hand_over(&dir, Some(user)).unwrap();
+        let limits = Limits {
+            time: Duration::from_secs(5),
+            memory: 256 * 1024 * 1024,
+            disk: 1024 * 1024,
+            output: 1024,
+            output_tail: 0,
+            seccomp: SeccompProfile::NoNetwork,
+            processes: 0,
+            network: NetworkPolicy::None,
+            cancellation: Cancellation::default(),
+            env: BTreeMap::new(),
+            work_dir: None,
+            user: Some(user),
+        };
+        let script = format!(
+            "test \"$(id -u):$(id -g)\" = 65534:65534 && test \"$HOME\" = \"$PWD\" && touch own.txt \
+             && ! (echo escaped > {}/escaped.txt) 2>/dev/null && ! (echo changed > given.txt) 2>/dev/null",
+            outside.display()
+        );
+
+        let actual = sandbox.execute(&dir, "sh", &["-c", &script], None, &limits);
+        let created = dir.join("own.txt").exists();
+        let _ = fs::remove_dir_all(&dir);
+        let _ = fs::remove_dir_all(&outside);
+
+        assert!(
+            matches!(actual, Ok(execution) if matches!(execution.outcome, Outcome::Exited(status) if status.success()))
+        );
+        assert!(created);
+    }
+
+    #[test]
+    fn host_streams_output() {
+        let sandbox = Sandbox::Host;
+        let dir = workspace();
+        let limits = Limits {
+            time: Duration::from_secs(5),
+            memory: 256 * 1024 * 1024,
+            disk: 1024 * 1024,
+            output: 1024,
+            output_tail: 0,
+            seccomp: SeccompProfile::Isolated,
+            processes: 0,
+            network: NetworkPolicy::None,
+            cancellation: Cancellation::default(),
+            env: BTreeMap::new(),
+            work_dir: None,
+            user: None,
+        };
+        let streamed = Arc::new(Mutex::new((Vec::new(), Vec::new())));
+        let sink: Sink = Arc::new({
+            let streamed = streamed.clone();
+            move |channel, chunk| {
+                let mut streamed = streamed.lock().unwrap();
+                match channel {
+                    Channel::Stdout => streamed.0.extend_from_slice(chunk),
+                    Channel::Stderr => streamed.1.extend_from_slice(chunk),
+                }
+            }
+        });
+
+        let actual = sandbox.stream(
+            &dir,
+            "sh",
+            &["-c", "echo out; echo err >&2"],
+            None,
+            &limits,
+            &sink,
+        );
+        let _ = fs::remove_dir_all(&dir);
+
+        let execution = actual.expect("the program should run");
+        assert_eq!(execution.stderr, "err\n");
+        assert_eq!(
+            *streamed.lock().unwrap(),
+            (b"out\n".to_vec(), b"err\n".to_vec())
+        );
+    }
+
+    #[test]
+    fn host_times_out() {
+        let sandbox = Sandbox::Host;
+        let limits = Limits {
+            time: Duration::from_millis(100),
+            memory: 256 * 1024 * 1024,
+            disk: 1024 * 1024,
+            output: 1024,
+            output_tail: 0,
+            seccomp: SeccompProfile::Isolated,
+            processes: 0,
+            network: NetworkPolicy::None,
+            cancellation: Cancellation::default(),
+            env: BTreeMap::new(),
+            work_dir: None,
+            user: None,
+        };
+        let dir = workspace();
+
+        let actual = sandbox.execute(&dir, "sleep", &["5"], None, &limits);
+        let _ = fs::remove_dir_all(&dir);
+
+        assert!(matches!(actual, Ok(execution) if matches!(execution.outcome, Outcome::TimedOut)));
+    }
+
+    #[test]
+    fn dev_times_out() {
+        let sandbox = Sandbox::Dev;
+        let limits = Limits {
+            time: Duration::from_millis(100),
+            memory: 256 * 1024 * 1024,
+            disk: 1024 * 1024,
+            output: 1024,
+            output_tail: 0,
+            seccomp: SeccompProfile::Isolated,
+            processes: 1,
+            network: NetworkPolicy::None,
+            cancellation: Cancellation::default(),
+            env: BTreeMap::new(),
+            work_dir: None,
+            user: None,
+        };
+        let dir = workspace();
+
+        // the shell is free to spawn the sleep, as the dev sandbox limits no processes
+        let actual = sandbox.execute(&dir, "sh", &["-c", "sleep 5; true"], None, &limits);
+        let _ = fs::remove_dir_all(&dir);
+
+        assert!(matches!(actual, Ok(execution) if matches!(execution.outcome, Outcome::TimedOut)));
+        assert!(!sandbox.failed(Some(125), ""));
+    }
+
+    #[test]
+    fn host_cancelled() {
+        let sandbox = Sandbox::Host;
+        let cancellation = Cancellation::default();
+        let limits = Limits {
+            time: Duration::from_secs(5),
+            memory: 256 * 1024 * 1024,
+            disk: 1024 * 1024,
+            output: 1024,
+            output_tail: 0,
+            seccomp: SeccompProfile::NoNetwork,
+            processes: 0,
+            network: NetworkPolicy::None,
+            cancellation: cancellation.clone(),
+            env: BTreeMap::new(),
+            work_dir: None,
+            user: None,
+        };
+        let dir = workspace();
+
+        let canceller = std::thread::spawn(move || {
+            std::thread::sleep(Duration::from_millis(100));
+            cancellation.cancel();
+        });
+        let started = std::time::Instant::now();
+        let actual = sandbox.execute(&dir, "sh", &["-c", "sleep 5 & sleep 5"], None, &limits);
+        let elapsed = started.elapsed();
+        let _ = canceller.join();
+        let _ = fs::remove_dir_all(&dir);
+
+        assert!(matches!(actual, Err(CheckError::Cancelled)));
+        assert!(elapsed < Duration::from_secs(2));
+    }
+
+    #[test]
+    fn host_forbids_processes() {
+        let sandbox = Sandbox::Host;
+        let limits = Limits {
+            time: Duration::from_secs(5),
+            memory: 256 * 1024 * 1024,
+            disk: 1024 * 1024,
+            output: 1024,
+            output_tail: 0,
+            seccomp: SeccompProfile::Isolated,
+            processes: 0,
+            network: NetworkPolicy::None,
+            cancellation: Cancellation::default(),
+            env: BTreeMap::new(),
+            work_dir: None,
+            user: None,
+        };
+        let dir = workspace();
+
+        let actual = sandbox.execute(&dir, "sh", &["-c", "true | true"], None, &limits);
+        let _ = fs::remove_dir_all(&dir);
+
+        assert!(
+            matches!(actual, Ok(execution) if matches!(execution.outcome, Outcome::SecurityViolation))
+        );
+    }
+
+    #[test]
+    fn host_allows_processes_without_network() {
+        let sandbox = Sandbox::Host;
+        let limits = Limits {
+            time: Duration::from_secs(5),
+            memory: 256 * 1024 * 1024,
+            disk: 1024 * 1024,
+            output: 1024,
+            output_tail: 0,
+            seccomp: SeccompProfile::NoNetwork,
+            processes: 0,
+            network: NetworkPolicy::None,
+            cancellation: Cancellation::default(),
+            env: BTreeMap::new(),
+            work_dir: None,
+            user: None,
+        };
+        let dir = workspace();
+
+        let actual = sandbox.execute(&dir, "sh", &["-c", "true | true"], None, &limits);
+        let _ = fs::remove_dir_all(&dir);
+
+        assert!(
+            matches!(actual, Ok(execution) if matches!(execution.outcome, Outcome::Exited(status) if status.success()))
+        );
+    }
+
+    #[test]
+    fn host_forwards_allowed_endpoints() {
+        // a network namespace requires CAP_SYS_ADMIN, without which there is nothing to forward into
+        if Namespace::create(&[]).is_err() {
+            return;
+        }
+        let server = TcpListener::bind((Ipv4Addr::LOCALHOST, 0)).unwrap();
+        let port = server.local_addr().unwrap().port();
+        thread::spawn(move || {
+            let (mut stream, _) = server.accept().unwrap();
+            stream.write_all(b"pong\n").unwrap();
+        });
+        let sandbox = Sandbox::Host;
+        let limits = |network| Limits {
+            time: Duration::from_secs(5),
+            memory: 256 * 1024 * 1024,
+            disk: 1024 * 1024,
+            output: 1024,
+            output_tail: 0,
+            seccomp: SeccompProfile::NoNetwork,
+            processes: 0,
+            network,
+            cancellation: Cancellation::default(),
+            env: BTreeMap::new(),
+            work_dir: None,
+            user: None,
+        };
+        let dir = workspace();
+        let script = format!("read line < /dev/tcp/127.0.0.1/{port} && echo \"$line\" >&2");
+
+        let loopback_only = sandbox.execute(
+            &dir,
+            "bash",
+            &["-c", &script],
+            None,
+            &limits(NetworkPolicy::LoopbackOnly),
+        );
+        let allowed = sandbox.execute(
+            &dir,
+            "bash",
+            &["-c", &script],
+            None,
+            &limits(NetworkPolicy::Allow(Box::new([format!(
+                "127.0.0.1:{port}"
+            )]))),
+        );
+        let _ = fs::remove_dir_all(&dir);
+
+        let loopback_only = loopback_only.unwrap();
+        assert!(
+            matches!(loopback_only.outcome, Outcome::Exited(status) if !status.success()),
+            "the host is not reachable from the loopback interface of the sandbox"
+        );
+        let allowed = allowed.unwrap();
+        assert!(matches!(allowed.outcome, Outcome::Exited(status) if status.success()));
+        assert_eq!(allowed.stderr, "pong\n");
+    }
+
+    #[test]
+    fn host_output_within() {
+        let sandbox = Sandbox::Host;
+        let dir = workspace();
+
+        let actual = sandbox.output_within(
+            &dir,
+            "sh",
+            &["-c", "echo out; echo err >&2"],
+            Duration::from_secs(5),
+        );
+        let timed_out = sandbox.output_within(&dir, "sleep", &["5"], Duration::from_millis(100));
+        let _ = fs::remove_dir_all(&dir);
+
+        let output = actual.unwrap().unwrap();
+        assert_eq!(output.stdout, b"out\n");
+        assert_eq!(output.stderr, b"err\n");
+        assert!(matches!(timed_out, Ok(None)));
+    }
+
+    #[test]
+    fn host_limits_file_size() {
+        let dir = workspace();
+        let sandbox = Sandbox::Host;
+        let limits = Limits {
+            time: Duration::from_secs(5),
+            memory: 256 * 1024 * 1024,
+            disk: 1024 * 1024,
+            output: 1024,
+            output_tail: 0,
+            seccomp: SeccompProfile::Isolated,
+            processes: 0,
+            network: NetworkPolicy::None,
+            cancellation: Cancellation::default(),
+            env: BTreeMap::new(),
+            work_dir: None,
+            user: None,
+        };
+
+        let actual = sandbox.execute(
+            &dir,
+            "dd",
+            &["if=/dev/zero", "of=big", "bs=1M", "count=2"],
+            None,
+            &limits,
+        );
+        let _ = fs::remove_dir_all(&dir);
+
+        assert!(
+            matches!(actual, Ok(execution) if matches!(execution.outcome, Outcome::OutputExceeded))
+        );
+    }
+
+    #[test]
+    fn host_limits_workspace() {
+        let dir = workspace();
+        let sandbox = Sandbox::Host;
+        let limits = Limits {
+            time: Duration::from_secs(5),
+            memory: 256 * 1024 * 1024,
+            disk: 1024 * 1024,
+            output: 1024,
+            output_tail: 0,
+            seccomp: SeccompProfile::NoNetwork,
+            processes: 0,
+            network: NetworkPolicy::None,
+            cancellation: Cancellation::default(),
+            env: BTreeMap::new(),
+            work_dir: None,
+            user: None,
+        };
+
+        // every file is below the limit, but not both of them
+        let actual = sandbox.execute(
+            &dir,
+            "sh",
+            &[
+                "-c",
+                "for f in a b; do dd if=/dev/urandom of=$f bs=600k count=1; done; sleep 5",
+            ],
+            None,
+            &limits,
+        );
+        let _ = fs::remove_dir_all(&dir);
+
+        assert!(
+            matches!(actual, Ok(execution) if matches!(execution.outcome, Outcome::OutputExceeded) && execution.runtime < Duration::from_secs(5))
+        );
+    }
+
+    #[test]
+    fn host_limits_parallel_executions_separately() {
+        let dir = workspace();
+        let sandbox = Sandbox::Host;
+        let limits = Limits {
+            time: Duration::from_secs(5),
+            memory: 256 * 1024 * 1024,
+            disk: 1024 * 1024,
+            output: 1024,
+            output_tail: 0,
+            seccomp: SeccompProfile::NoNetwork,
+            processes: 0,
+            network: NetworkPolicy::None,
+            cancellation: Cancellation::default(),
+            env: BTreeMap::new(),
+            work_dir: None,
+            user: None,
+        };
+
+        // each execution writes below the limit, while both of them together write beyond it
+        let outcomes: Vec<_> = thread::scope(|scope| {
+            let executions: Vec<_> = ["a", "b"]
+                .map(|file| {
+                    let (sandbox, dir, limits) = (&sandbox, &dir, &limits);
+                    scope.spawn(move || {
+                        let script = format!(
+                            "sleep 0.2; dd if=/dev/urandom of={file} bs=600k count=1; sleep 0.5"
+                        );
+                        sandbox.execute(dir, "sh", &["-c", &script], None, limits)
+                    })
+                })
+                .into_iter()
+                .collect();
+            executions
+                .into_iter()
+                .map(|execution| execution.join().unwrap().unwrap().outcome)
+                .collect()
+        });
+        let _ = fs::remove_dir_all(&dir);
+
+        assert!(
+            outcomes
+                .iter()
+                .all(|outcome| matches!(outcome, Outcome::Exited(status) if status.success())),
+            "{outcomes:?}"
+        );
+    }
+
+    #[test]
+    fn host_limits_workspace_by_its_size() {
+        let dir = workspace();
+        let target = std::ffi::CString::new(dir.as_os_str().as_encoded_bytes()).unwrap();
+        // only root may mount the tmpfs the workspace is limited by
+        let mounted = unsafe {
+            libc::mount(
+                c"tmpfs".as_ptr(),
+                target.as_ptr(),
+                c"tmpfs".as_ptr(),
+                0,
+                c"size=64m".as_ptr().cast(),
+            )
+        };
+        if mounted != 0 {
+            let _ = fs::remove_dir_all(&dir);
+            return;
+        }
+        let sandbox = Sandbox::Host;
+        let limits = Limits {
+            time: Duration::from_secs(5),
+            memory: 256 * 1024 * 1024,
+            disk: 1024 * 1024,
+            output: 1024,
+            output_tail: 0,
+            seccomp: SeccompProfile::NoNetwork,
+            processes: 0,
+            network: NetworkPolicy::None,
+            cancellation: Cancellation::default(),
+            env: BTreeMap::new(),
+            work_dir: None,
+            user: None,
+        };
+
+        let actual = sandbox.execute(
+            &dir,
+            "sh",
+            &[
+                "-c",
+                "for f in a b; do dd if=/dev/zero of=$f bs=600k count=1; done; sleep 5",
+            ],
+            None,
+            &limits,
+        );
+        let written = fs::metadata(dir.join("b")).map_or(0, |metadata| metadata.len());
+        let mut stat = unsafe { std::mem::zeroed::<libc::statfs>() };
+        unsafe { libc::statfs(target.as_ptr(), &mut stat) };
+        unsafe { libc::umount2(target.as_ptr(), libc::MNT_DETACH) };
+        let _ = fs::remove_dir_all(&dir);
+
+        assert!(
+            matches!(actual, Ok(execution) if matches!(execution.outcome, Outcome::OutputExceeded) && execution.runtime < Duration::from_secs(1))
+        );
+        assert!(written < 600 * 1024, "the second file was written in full");
+        assert_eq!(stat.f_blocks * stat.f_bsize as u64, 64 * 1024 * 1024);
+    }
+
+    #[test]
+    fn host_reads_stdin_from_file() {
+        let dir = workspace();
+        let stdin = dir.join("stdin");
+        fs::write(&stdin, "hello\n").expect("failed to write stdin");
+        let sandbox = Sandbox::Host;
+        let limits = Limits {
+            time: Duration::from_secs(5),
+            memory: 256 * 1024 * 1024,
+            disk: 1024 * 1024,
+            output: 1024,
+            output_tail: 0,
+            seccomp: SeccompProfile::NoNetwork,
+            processes: 0,
+            network: NetworkPolicy::None,
+            cancellation: Cancellation::default(),
+            env: BTreeMap::new(),
+            work_dir: None,
+            user: None,
+        };
+
+        let actual = sandbox.execute(
+            &dir,
+            "sh",
+            &["-c", "read line; [ \"$line\" = hello ]"],
+            Some(&stdin),
+            &limits,
+        );
+        let _ = fs::remove_dir_all(&dir);
+
+        assert!(
+            matches!(actual, Ok(execution) if matches!(execution.outcome, Outcome::Exited(status) if status.success()))
+        );
+    }
+
+    #[test]
+    fn host_measures_usage() {
+        let sandbox = Sandbox::Host;
+        let limits = Limits {
+            time: Duration::from_secs(5),
+            memory: 256 * 1024 * 1024,
+            disk: 1024 * 1024,
+            output: 1024,
+            output_tail: 0,
+            seccomp: SeccompProfile::NoNetwork,
+            processes: 0,
+            network: NetworkPolicy::None,
+            cancellation: Cancellation::default(),
+            env: BTreeMap::new(),
+            work_dir: None,
+            user: None,
+        };
+        let dir = workspace();
+
+        let actual = sandbox.execute(
+            &dir,
+            "sh",
+            &["-c", "i=0; while [ $i -lt 100000 ]; do i=$((i + 1)); done"],
+            None,
+            &limits,
+        );
+        let _ = fs::remove_dir_all(&dir);
+
+        let execution = actual.expect("the program should run");
+        let cpu_time = execution.cpu_time.expect("the cpu time should be measured");
+        assert!(cpu_time.user > Duration::ZERO);
+        assert!(cpu_time.user + cpu_time.system <= execution.runtime);
+        assert!(execution.peak_memory.is_some_and(|memory| memory > 0));
+    }
+
+    #[test]
+    fn truncates_output() {
+        let actual = read_truncated(&mut [b'x'; 10].as_slice(), 4, 0, None);
+
+        assert_eq!(actual, "xxxx\n[6 more bytes were truncated]\n");
+        assert_eq!(
+            read_truncated(&mut b"short".as_slice(), 8, 0, None),
+            "short"
+        );
+    }
+
+    #[test]
+    fn keeps_tail_of_truncated_output() {
+        let output = b"head-middle-tail";
+
+        let actual = read_truncated(&mut output.as_slice(), 4, 4, None);
+
+        assert_eq!(actual, "head\n[8 more bytes were truncated]\ntail");
+        assert_eq!(
+            read_truncated(&mut output.as_slice(), 8, 8, None),
+            "head-middle-tail"
+        );
+        // the tail starts at the next character rather than within one
+        assert_eq!(
+            read_truncated(&mut "ab-äé".as_bytes(), 2, 3, None),
+            "ab\n[3 more bytes were truncated]\né"
+        );
+    }
+
+    #[test]
+    fn streams_truncated_output() {
+        let streamed = Arc::new(Mutex::new(Vec::new()));
+        let sink: Sink = Arc::new({
+            let streamed = streamed.clone();
+            move |channel, chunk| streamed.lock().unwrap().push((channel, chunk.to_vec()))
+        });
+
+        let actual = read_truncated(
+            &mut [b'x'; 10].as_slice(),
+            4,
+            2,
+            Some((sink, Channel::Stdout)),
+        );
+
+        assert_eq!(
+            *streamed.lock().unwrap(),
+            [
+                (Channel::Stdout, b"xxxx".to_vec()),
+                (
+                    Channel::Stdout,
+                    b"\n[4 more bytes were truncated]\n".to_vec()
+                ),
+                (Channel::Stdout, b"xx".to_vec())
+            ]
+        );
+        assert_eq!(actual, "xxxx\n[4 more bytes were truncated]\nxx");
+    }
+}
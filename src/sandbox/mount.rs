@@ -0,0 +1,101 @@
+use std::{
+    ffi::{CStr, CString},
+    io,
+    os::unix::ffi::OsStrExt,
+    path::Path,
+    ptr,
+};
+
+/// The flag of `mount_setattr` which applies the attributes to every mount below the path as well.
+const AT_RECURSIVE: libc::c_uint = 0x8000;
+
+/// The root of the file system, whose mounts are all made read-only.
+const ROOT: &CStr = c"/";
+
+/// A mount namespace of a single execution, in which every mount is read-only but the workspace, so the program cannot
+/// write anywhere outside of it, e.g. to `/tmp` or `/dev/shm` of the host, nor execute anything which is setuid.
+///
+/// The paths are converted before forking, as nothing may be allocated between forking and executing.
+pub struct Isolation {
+    workspace: CString,
+    work_dir: CString,
+}
+
+impl Isolation {
+    /// Creates the isolation of the workspace, in which the program is executed in the working directory.
+    pub fn new(workspace: &Path, work_dir: &Path) -> io::Result<Self> {
+        let convert = |path: &Path| {
+            CString::new(path.as_os_str().as_bytes())
+                .map_err(|_| io::Error::from(io::ErrorKind::InvalidInput))
+        };
+
+        Ok(Self {
+            workspace: convert(workspace)?,
+            work_dir: convert(work_dir)?,
+        })
+    }
+
+    /// Moves the calling process into a mount namespace of its own, which is isolated as described above, which
+    /// requires `CAP_SYS_ADMIN`.
+    ///
+    /// This is meant to be called between fork and exec, so it only uses async-signal-safe functions. The namespace
+    /// goes away along with the last process in it, so nothing has to be unmounted.
+    pub fn enter(&self) -> io::Result<()> {
+        let attributes = libc::mount_attr {
+            attr_set: libc::MOUNT_ATTR_RDONLY | libc::MOUNT_ATTR_NOSUID,
+            attr_clr: 0,
+            propagation: 0,
+            userns_fd: 0,
+        };
+
+        // SAFETY: every path is a valid C string, the attributes outlive the call, and every call is a system call.
+        unsafe {
+            check(libc::unshare(libc::CLONE_NEWNS))?;
+            // nothing mounted in the namespace propagates back to the host
+            check(libc::mount(
+                ptr::null(),
+                ROOT.as_ptr(),
+                ptr::null(),
+                libc::MS_REC | libc::MS_PRIVATE,
+                ptr::null(),
+            ))?;
+            check(libc::syscall(
+                libc::SYS_mount_setattr,
+                libc::AT_FDCWD,
+                ROOT.as_ptr(),
+                AT_RECURSIVE,
+                &attributes as *const libc::mount_attr,
+                std::mem::size_of::<libc::mount_attr>(),
+            ) as libc::c_int)?;
+
+            // the workspace is bound onto itself, so the new mount can be made writable, unlike the one it is in
+            check(libc::mount(
+                self.workspace.as_ptr(),
+                self.workspace.as_ptr(),
+                ptr::null(),
+                libc::MS_BIND | libc::MS_REC,
+                ptr::null(),
+            ))?;
+            check(libc::mount(
+                ptr::null(),
+                self.workspace.as_ptr(),
+                ptr::null(),
+                libc::MS_REMOUNT | libc::MS_BIND | libc::MS_NOSUID | libc::MS_NODEV,
+                ptr::null(),
+            ))?;
+
+            // the working directory was entered through the read-only mount, before the workspace was bound
+            check(libc::chdir(self.work_dir.as_ptr()))?;
+        }
+
+        Ok(())
+    }
+}
+
+/// Converts the result of a system call into an error if it failed.
+fn check(result: libc::c_int) -> io::Result<()> {
+    match result {
+        0 => Ok(()),
+        _ => Err(io::Error::last_os_error()),
+    }
+}
@@ -0,0 +1,158 @@
+use crate::model::endpoint_port;
+use std::{
+    fs::File,
+    io::{self, ErrorKind},
+    net::{Ipv4Addr, Shutdown, TcpListener, TcpStream},
+    os::fd::{AsRawFd, OwnedFd, RawFd},
+    sync::{
+        atomic::{AtomicBool, Ordering},
+        Arc,
+    },
+    thread::{self, JoinHandle},
+    time::Duration,
+};
+
+/// How often the forwarder checks for new connections, and whether it has been stopped.
+const ACCEPT_INTERVAL: Duration = Duration::from_millis(10);
+
+/// A network namespace of a single execution, whose only interface is its own loopback interface.
+///
+/// Every allowed endpoint is forwarded to from the same port on the loopback interface of the namespace, by
+/// connecting to the endpoint from the namespace of mozart, so the execution reaches nothing but the endpoints. No
+/// further connection is forwarded once it is dropped.
+pub struct Namespace {
+    fd: OwnedFd,
+    stopped: Arc<AtomicBool>,
+    forwarder: Option<JoinHandle<()>>,
+}
+
+impl Namespace {
+    /// Creates a namespace forwarding the given `host:port` endpoints, which requires `CAP_SYS_ADMIN`.
+    pub fn create(endpoints: &[String]) -> io::Result<Self> {
+        let endpoints = endpoints.to_vec();
+        // unsharing only moves the calling thread into the namespace, so it gets a thread of its own
+        let (fd, listeners) = thread::spawn(move || {
+            // SAFETY: unshare has no preconditions, and only affects the calling thread.
+            if unsafe { libc::unshare(libc::CLONE_NEWNET) } != 0 {
+                return Err(io::Error::last_os_error());
+            }
+            loopback_up()?;
+
+            let fd = OwnedFd::from(File::open("/proc/thread-self/ns/net")?);
+            // the listeners stay in the namespace they are bound in, whichever thread accepts on them
+            let listeners = endpoints
+                .into_iter()
+                .map(|endpoint| {
+                    let port = endpoint_port(&endpoint).ok_or_else(|| {
+                        io::Error::new(ErrorKind::InvalidInput, "not a host:port endpoint")
+                    })?;
+                    let listener = TcpListener::bind((Ipv4Addr::LOCALHOST, port))?;
+                    listener.set_nonblocking(true)?;
+                    Ok((listener, endpoint))
+                })
+                .collect::<io::Result<Vec<_>>>()?;
+
+            Ok((fd, listeners))
+        })
+        .join()
+        .map_err(|_| io::Error::other("creating the network namespace panicked"))??;
+
+        let stopped = Arc::new(AtomicBool::new(false));
+        let forwarder = (!listeners.is_empty()).then(|| {
+            let stopped = stopped.clone();
+            thread::spawn(move || accept(&listeners, &stopped))
+        });
+
+        Ok(Self {
+            fd,
+            stopped,
+            forwarder,
+        })
+    }
+
+    /// Gets the file descriptor of the namespace, which processes join with [`enter`].
+    pub fn fd(&self) -> RawFd {
+        self.fd.as_raw_fd()
+    }
+}
+
+impl Drop for Namespace {
+    fn drop(&mut self) {
+        self.stopped.store(true, Ordering::Relaxed);
+        if let Some(forwarder) = self.forwarder.take() {
+            let _ = forwarder.join();
+        }
+    }
+}
+
+/// Moves the calling process into the network namespace of the given file descriptor.
+///
+/// This is meant to be called between fork and exec, so it only uses async-signal-safe functions.
+pub fn enter(fd: RawFd) -> io::Result<()> {
+    // SAFETY: setns is async-signal-safe, and fails if the file descriptor is not a network namespace.
+    if unsafe { libc::setns(fd, libc::CLONE_NEWNET) } != 0 {
+        return Err(io::Error::last_os_error());
+    }
+
+    Ok(())
+}
+
+/// Brings up the loopback interface of the network namespace of the calling thread, which starts out down.
+fn loopback_up() -> io::Result<()> {
+    // SAFETY: the socket is closed before returning, and the request is a valid ifreq naming the loopback interface.
+    unsafe {
+        let socket = libc::socket(libc::AF_INET, libc::SOCK_DGRAM | libc::SOCK_CLOEXEC, 0);
+        if socket < 0 {
+            return Err(io::Error::last_os_error());
+        }
+
+        let mut request = std::mem::zeroed::<libc::ifreq>();
+        for (target, byte) in request.ifr_name.iter_mut().zip(b"lo") {
+            *target = *byte as libc::c_char;
+        }
+        request.ifr_ifru.ifru_flags = (libc::IFF_UP | libc::IFF_LOOPBACK | libc::IFF_RUNNING) as _;
+        let result = libc::ioctl(socket, libc::SIOCSIFFLAGS as _, &request);
+        libc::close(socket);
+        if result != 0 {
+            return Err(io::Error::last_os_error());
+        }
+    }
+
+    Ok(())
+}
+
+/// Accepts the connections to every listener until stopped, forwarding each to the endpoint of its listener.
+fn accept(listeners: &[(TcpListener, String)], stopped: &AtomicBool) {
+    while !stopped.load(Ordering::Relaxed) {
+        for (listener, endpoint) in listeners {
+            while let Ok((client, _)) = listener.accept() {
+                let endpoint = endpoint.clone();
+                thread::spawn(move || forward(client, &endpoint));
+            }
+        }
+        thread::sleep(ACCEPT_INTERVAL);
+    }
+}
+
+/// Forwards a connection to the endpoint in both directions, until either side closes it.
+fn forward(client: TcpStream, endpoint: &str) {
+    // every connection of the forwarder is made from the namespace of mozart, as the forwarder was started in it
+    let Ok(server) = TcpStream::connect(endpoint) else {
+        return;
+    };
+    // the accepted connection is nonblocking like its listener on some platforms, so it is made blocking explicitly
+    let _ = client.set_nonblocking(false);
+    let (Ok(mut client_reader), Ok(mut server_reader)) = (client.try_clone(), server.try_clone())
+    else {
+        return;
+    };
+
+    let (mut client_writer, mut server_writer) = (client, server);
+    let upstream = thread::spawn(move || {
+        let _ = io::copy(&mut client_reader, &mut server_writer);
+        let _ = server_writer.shutdown(Shutdown::Write);
+    });
+    let _ = io::copy(&mut server_reader, &mut client_writer);
+    let _ = client_writer.shutdown(Shutdown::Write);
+    let _ = upstream.join();
+}
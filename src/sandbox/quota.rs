@@ -0,0 +1,139 @@
+use std::{
+    ffi::CString,
+    fs, io,
+    os::unix::{ffi::OsStrExt, fs::MetadataExt},
+    path::{Path, PathBuf},
+    sync::Mutex,
+};
+
+/// The workspaces whose tmpfs is resized for the executions running in them.
+static LIMITED: Mutex<Vec<Limited>> = Mutex::new(Vec::new());
+
+struct Limited {
+    dir: PathBuf,
+    /// The number of executions running in the workspace, the last of which resizes it back.
+    running: usize,
+    /// The size of the tmpfs in bytes before the first of the executions started.
+    size: u64,
+    /// The size the tmpfs was resized to in bytes, which grows by the disk limit of every execution which starts.
+    limit: u64,
+}
+
+/// The disk limit of a workspace which is a tmpfs of its own, which the kernel enforces by refusing to write beyond
+/// the size of the tmpfs, so the workspace does not have to be measured while the execution runs.
+///
+/// The tmpfs is resized to what it holds plus the disk limit once the first execution in it starts, and grows by the
+/// disk limit of every execution which starts while others are running, so each test case running at once has room to
+/// write up to its own limit. It gets its own size back once the last of them is done, as the files written by the
+/// executions which are done stay in the workspace until then.
+pub struct Quota {
+    dir: PathBuf,
+    path: CString,
+}
+
+impl Quota {
+    /// Limits the workspace to the disk limit in bytes for an execution, which is `None` if the workspace is not a
+    /// tmpfs of its own, or cannot be resized, in which case its usage has to be measured instead.
+    pub fn begin(dir: &Path, disk: u64) -> Option<Self> {
+        let path = CString::new(dir.as_os_str().as_bytes()).ok()?;
+        let stat = statfs(&path).ok()?;
+        if !is_own_tmpfs(dir, &stat) {
+            return None;
+        }
+
+        let mut limited = LIMITED.lock().expect("quota lock poisoned");
+        match limited.iter_mut().find(|limited| limited.dir == dir) {
+            Some(limited) => {
+                let limit = limited.limit.saturating_add(disk);
+                resize(&path, limit).ok()?;
+                limited.limit = limit;
+                limited.running += 1;
+            }
+            None => {
+                let block = stat.f_bsize as u64;
+                let size = stat.f_blocks as u64 * block;
+                let used = (stat.f_blocks - stat.f_bfree) as u64 * block;
+                // a block more than the limit, so a program writing exactly its limit does not fill the tmpfs
+                let limit = used.saturating_add(disk).saturating_add(block);
+                resize(&path, limit).ok()?;
+                limited.push(Limited {
+                    dir: dir.to_path_buf(),
+                    running: 1,
+                    size,
+                    limit,
+                });
+            }
+        }
+
+        Some(Self {
+            dir: dir.to_path_buf(),
+            path,
+        })
+    }
+
+    /// Whether the workspace is full, as the executions in it wrote more than their disk limit.
+    pub fn exhausted(&self) -> bool {
+        statfs(&self.path).is_ok_and(|stat| stat.f_bfree == 0)
+    }
+}
+
+impl Drop for Quota {
+    fn drop(&mut self) {
+        let mut limited = LIMITED.lock().expect("quota lock poisoned");
+        let Some(index) = limited.iter().position(|limited| limited.dir == self.dir) else {
+            return;
+        };
+
+        limited[index].running -= 1;
+        if limited[index].running == 0 {
+            let _ = resize(&self.path, limited[index].size);
+            limited.swap_remove(index);
+        }
+    }
+}
+
+/// Whether the directory is a tmpfs mounted at the directory itself, rather than within a tmpfs shared with others.
+fn is_own_tmpfs(dir: &Path, stat: &libc::statfs) -> bool {
+    #[allow(clippy::unnecessary_cast)]
+    let tmpfs = stat.f_type as i64 == libc::TMPFS_MAGIC as i64;
+    let device = |path: &Path| fs::metadata(path).map(|metadata| metadata.dev()).ok();
+
+    tmpfs
+        && dir
+            .parent()
+            .is_some_and(|parent| device(parent) != device(dir))
+}
+
+fn statfs(path: &CString) -> io::Result<libc::statfs> {
+    // SAFETY: statfs is a plain C struct, for which all zeroes is a valid value.
+    let mut stat = unsafe { std::mem::zeroed::<libc::statfs>() };
+
+    // SAFETY: the path is a valid nul terminated string, and the struct outlives the call.
+    if unsafe { libc::statfs(path.as_ptr(), &mut stat) } != 0 {
+        return Err(io::Error::last_os_error());
+    }
+
+    Ok(stat)
+}
+
+/// Resizes the tmpfs at the path to the size in bytes, which fails if it holds more than that, and requires
+/// `CAP_SYS_ADMIN`.
+fn resize(path: &CString, size: u64) -> io::Result<()> {
+    let options = CString::new(format!("size={size}"))?;
+
+    // SAFETY: every pointer is a valid nul terminated string, which outlives the call.
+    let resized = unsafe {
+        libc::mount(
+            c"tmpfs".as_ptr(),
+            path.as_ptr(),
+            c"tmpfs".as_ptr(),
+            libc::MS_REMOUNT | libc::MS_NOSUID | libc::MS_NODEV,
+            options.as_ptr().cast(),
+        )
+    };
+    if resized != 0 {
+        return Err(io::Error::last_os_error());
+    }
+
+    Ok(())
+}
@@ -0,0 +1,801 @@
+use crate::config::SeccompProfile;
+use serde_json::{json, Value};
+use std::{fs, io, path::PathBuf, sync::Mutex};
+use uuid::Uuid;
+
+#[cfg(not(any(target_arch = "x86_64", target_arch = "aarch64")))]
+compile_error!("seccomp filters are only built for x86_64 and aarch64");
+
+/// The architecture which system calls are numbered for, as it is identified in `seccomp_data`.
+#[cfg(target_arch = "x86_64")]
+const AUDIT_ARCH: u32 = 0xc000_003e;
+#[cfg(target_arch = "aarch64")]
+const AUDIT_ARCH: u32 = 0xc000_00b7;
+
+/// The offset of the system call number in `seccomp_data`.
+const NR_OFFSET: u32 = 0;
+
+/// The offset of the architecture in `seccomp_data`.
+const ARCH_OFFSET: u32 = 4;
+
+/// The offset of the lower half of the first argument in `seccomp_data`, on little endian architectures.
+const ARG0_OFFSET: u32 = 16;
+
+/// The bit which marks a system call of the x32 ABI, which shares the architecture of x86_64 in `seccomp_data`, so
+/// every forbidden system call could be made by its x32 number otherwise.
+const X32_SYSCALL_BIT: u32 = 0x4000_0000;
+
+/// The namespace flags of `clone`, which docker only allows with `CAP_SYS_ADMIN`.
+const CLONE_NAMESPACES: libc::c_int = libc::CLONE_NEWNS
+    | libc::CLONE_NEWUTS
+    | libc::CLONE_NEWIPC
+    | libc::CLONE_NEWUSER
+    | libc::CLONE_NEWPID
+    | libc::CLONE_NEWNET
+    | libc::CLONE_NEWCGROUP;
+
+/// The system calls which every profile forbids, as they could escape or tamper with the sandbox.
+const PRIVILEGED: &[(&str, libc::c_long)] = &[
+    ("ptrace", libc::SYS_ptrace),
+    ("process_vm_writev", libc::SYS_process_vm_writev),
+    ("mount", libc::SYS_mount),
+    ("umount2", libc::SYS_umount2),
+    ("pivot_root", libc::SYS_pivot_root),
+    ("chroot", libc::SYS_chroot),
+    ("unshare", libc::SYS_unshare),
+    ("setns", libc::SYS_setns),
+    ("bpf", libc::SYS_bpf),
+    ("perf_event_open", libc::SYS_perf_event_open),
+    ("keyctl", libc::SYS_keyctl),
+    ("add_key", libc::SYS_add_key),
+    ("request_key", libc::SYS_request_key),
+    ("init_module", libc::SYS_init_module),
+    ("finit_module", libc::SYS_finit_module),
+    ("delete_module", libc::SYS_delete_module),
+    ("kexec_load", libc::SYS_kexec_load),
+    ("reboot", libc::SYS_reboot),
+    ("swapon", libc::SYS_swapon),
+    ("swapoff", libc::SYS_swapoff),
+    ("io_uring_setup", libc::SYS_io_uring_setup),
+    ("io_uring_enter", libc::SYS_io_uring_enter),
+    ("io_uring_register", libc::SYS_io_uring_register),
+];
+
+/// The system calls which only create processes, which aarch64 does with `clone` alone.
+#[cfg(target_arch = "x86_64")]
+const FORKS: &[(&str, libc::c_long)] = &[("fork", libc::SYS_fork), ("vfork", libc::SYS_vfork)];
+#[cfg(target_arch = "aarch64")]
+const FORKS: &[(&str, libc::c_long)] = &[];
+
+/// The system calls which the default profile of docker allows regardless of capabilities, as of docker 25, which the
+/// docker profiles build on, so only what docker would allow anyway is allowed.
+///
+/// Names which the architecture does not have are skipped by docker, so the 32-bit ones are kept like in the profile of
+/// docker.
+const DOCKER_ALLOWED: &[&str] = &[
+    "accept",
+    "accept4",
+    "access",
+    "adjtimex",
+    "alarm",
+    "bind",
+    "brk",
+    "cachestat",
+    "capget",
+    "capset",
+    "chdir",
+    "chmod",
+    "chown",
+    "chown32",
+    "clock_adjtime",
+    "clock_adjtime64",
+    "clock_getres",
+    "clock_getres_time64",
+    "clock_gettime",
+    "clock_gettime64",
+    "clock_nanosleep",
+    "clock_nanosleep_time64",
+    "close",
+    "close_range",
+    "connect",
+    "copy_file_range",
+    "creat",
+    "dup",
+    "dup2",
+    "dup3",
+    "epoll_create",
+    "epoll_create1",
+    "epoll_ctl",
+    "epoll_ctl_old",
+    "epoll_pwait",
+    "epoll_pwait2",
+    "epoll_wait",
+    "epoll_wait_old",
+    "eventfd",
+    "eventfd2",
+    "execve",
+    "execveat",
+    "exit",
+    "exit_group",
+    "faccessat",
+    "faccessat2",
+    "fadvise64",
+    "fadvise64_64",
+    "fallocate",
+    "fanotify_mark",
+    "fchdir",
+    "fchmod",
+    "fchmodat",
+    "fchmodat2",
+    "fchown",
+    "fchown32",
+    "fchownat",
+    "fcntl",
+    "fcntl64",
+    "fdatasync",
+    "fgetxattr",
+    "flistxattr",
+    "flock",
+    "fork",
+    "fremovexattr",
+    "fsetxattr",
+    "fstat",
+    "fstat64",
+    "fstatat64",
+    "fstatfs",
+    "fstatfs64",
+    "fsync",
+    "ftruncate",
+    "ftruncate64",
+    "futex",
+    "futex_requeue",
+    "futex_time64",
+    "futex_wait",
+    "futex_waitv",
+    "futex_wake",
+    "futimesat",
+    "getcpu",
+    "getcwd",
+    "getdents",
+    "getdents64",
+    "getegid",
+    "getegid32",
+    "geteuid",
+    "geteuid32",
+    "getgid",
+    "getgid32",
+    "getgroups",
+    "getgroups32",
+    "getitimer",
+    "getpeername",
+    "getpgid",
+    "getpgrp",
+    "getpid",
+    "getppid",
+    "getpriority",
+    "getrandom",
+    "getresgid",
+    "getresgid32",
+    "getresuid",
+    "getresuid32",
+    "getrlimit",
+    "get_robust_list",
+    "getrusage",
+    "getsid",
+    "getsockname",
+    "getsockopt",
+    "get_thread_area",
+    "gettid",
+    "gettimeofday",
+    "getuid",
+    "getuid32",
+    "getxattr",
+    "inotify_add_watch",
+    "inotify_init",
+    "inotify_init1",
+    "inotify_rm_watch",
+    "io_cancel",
+    "ioctl",
+    "io_destroy",
+    "io_getevents",
+    "io_pgetevents",
+    "io_pgetevents_time64",
+    "ioprio_get",
+    "ioprio_set",
+    "io_setup",
+    "io_submit",
+    "ipc",
+    "kill",
+    "landlock_add_rule",
+    "landlock_create_ruleset",
+    "landlock_restrict_self",
+    "lchown",
+    "lchown32",
+    "lgetxattr",
+    "link",
+    "linkat",
+    "listen",
+    "listxattr",
+    "llistxattr",
+    "_llseek",
+    "lremovexattr",
+    "lseek",
+    "lsetxattr",
+    "lstat",
+    "lstat64",
+    "madvise",
+    "map_shadow_stack",
+    "membarrier",
+    "memfd_create",
+    "memfd_secret",
+    "mincore",
+    "mkdir",
+    "mkdirat",
+    "mknod",
+    "mknodat",
+    "mlock",
+    "mlock2",
+    "mlockall",
+    "mmap",
+    "mmap2",
+    "mprotect",
+    "mq_getsetattr",
+    "mq_notify",
+    "mq_open",
+    "mq_timedreceive",
+    "mq_timedreceive_time64",
+    "mq_timedsend",
+    "mq_timedsend_time64",
+    "mq_unlink",
+    "mremap",
+    "msgctl",
+    "msgget",
+    "msgrcv",
+    "msgsnd",
+    "msync",
+    "munlock",
+    "munlockall",
+    "munmap",
+    "name_to_handle_at",
+    "nanosleep",
+    "newfstatat",
+    "_newselect",
+    "open",
+    "openat",
+    "openat2",
+    "pause",
+    "pidfd_open",
+    "pidfd_send_signal",
+    "pipe",
+    "pipe2",
+    "pkey_alloc",
+    "pkey_free",
+    "pkey_mprotect",
+    "poll",
+    "ppoll",
+    "ppoll_time64",
+    "prctl",
+    "pread64",
+    "preadv",
+    "preadv2",
+    "prlimit64",
+    "process_mrelease",
+    "pselect6",
+    "pselect6_time64",
+    "pwrite64",
+    "pwritev",
+    "pwritev2",
+    "read",
+    "readahead",
+    "readlink",
+    "readlinkat",
+    "readv",
+    "recv",
+    "recvfrom",
+    "recvmmsg",
+    "recvmmsg_time64",
+    "recvmsg",
+    "remap_file_pages",
+    "removexattr",
+    "rename",
+    "renameat",
+    "renameat2",
+    "restart_syscall",
+    "rmdir",
+    "rseq",
+    "rt_sigaction",
+    "rt_sigpending",
+    "rt_sigprocmask",
+    "rt_sigqueueinfo",
+    "rt_sigreturn",
+    "rt_sigsuspend",
+    "rt_sigtimedwait",
+    "rt_sigtimedwait_time64",
+    "rt_tgsigqueueinfo",
+    "sched_getaffinity",
+    "sched_getattr",
+    "sched_getparam",
+    "sched_get_priority_max",
+    "sched_get_priority_min",
+    "sched_getscheduler",
+    "sched_rr_get_interval",
+    "sched_rr_get_interval_time64",
+    "sched_setaffinity",
+    "sched_setattr",
+    "sched_setparam",
+    "sched_setscheduler",
+    "sched_yield",
+    "seccomp",
+    "select",
+    "semctl",
+    "semget",
+    "semop",
+    "semtimedop",
+    "semtimedop_time64",
+    "send",
+    "sendfile",
+    "sendfile64",
+    "sendmmsg",
+    "sendmsg",
+    "sendto",
+    "setfsgid",
+    "setfsgid32",
+    "setfsuid",
+    "setfsuid32",
+    "setgid",
+    "setgid32",
+    "setgroups",
+    "setgroups32",
+    "setitimer",
+    "setpgid",
+    "setpriority",
+    "setregid",
+    "setregid32",
+    "setresgid",
+    "setresgid32",
+    "setresuid",
+    "setresuid32",
+    "setreuid",
+    "setreuid32",
+    "setrlimit",
+    "set_robust_list",
+    "setsid",
+    "setsockopt",
+    "set_thread_area",
+    "set_tid_address",
+    "setuid",
+    "setuid32",
+    "setxattr",
+    "shmat",
+    "shmctl",
+    "shmdt",
+    "shmget",
+    "shutdown",
+    "sigaltstack",
+    "signalfd",
+    "signalfd4",
+    "sigprocmask",
+    "sigreturn",
+    "socket",
+    "socketcall",
+    "socketpair",
+    "splice",
+    "stat",
+    "stat64",
+    "statfs",
+    "statfs64",
+    "statx",
+    "symlink",
+    "symlinkat",
+    "sync",
+    "sync_file_range",
+    "syncfs",
+    "sysinfo",
+    "tee",
+    "tgkill",
+    "time",
+    "timer_create",
+    "timer_delete",
+    "timer_getoverrun",
+    "timer_gettime",
+    "timer_gettime64",
+    "timer_settime",
+    "timer_settime64",
+    "timerfd_create",
+    "timerfd_gettime",
+    "timerfd_gettime64",
+    "timerfd_settime",
+    "timerfd_settime64",
+    "times",
+    "tkill",
+    "truncate",
+    "truncate64",
+    "ugetrlimit",
+    "umask",
+    "uname",
+    "unlink",
+    "unlinkat",
+    "utime",
+    "utimensat",
+    "utimensat_time64",
+    "utimes",
+    "vfork",
+    "vmsplice",
+    "wait4",
+    "waitid",
+    "waitpid",
+    "write",
+    "writev",
+];
+
+/// The system calls which the default profile of docker allows on the architecture alone.
+#[cfg(target_arch = "x86_64")]
+const DOCKER_ALLOWED_NATIVE: &[&str] = &["arch_prctl", "modify_ldt"];
+#[cfg(target_arch = "aarch64")]
+const DOCKER_ALLOWED_NATIVE: &[&str] = &[];
+
+/// The architecture of the docker profiles, which is the only one they allow, like the filter on the host.
+#[cfg(target_arch = "x86_64")]
+const DOCKER_ARCH: &str = "SCMP_ARCH_X86_64";
+#[cfg(target_arch = "aarch64")]
+const DOCKER_ARCH: &str = "SCMP_ARCH_AARCH64";
+
+/// The personalities which the default profile of docker may switch to, which are the default, one without address
+/// space randomization, their combinations with `UNAME26`, and querying the personality.
+const DOCKER_PERSONALITIES: &[u32] = &[0x0, 0x8, 0x2_0000, 0x2_0008, 0xffff_ffff];
+
+/// The paths of the docker profiles which have been written by whether they allow the network, which are written once
+/// per process.
+static DOCKER_PROFILES: Mutex<Vec<(SeccompProfile, bool, PathBuf)>> = Mutex::new(Vec::new());
+
+impl SeccompProfile {
+    fn forbids_processes(self) -> bool {
+        self == Self::Isolated
+    }
+
+    fn forbids_network(self) -> bool {
+        self != Self::Unfiltered
+    }
+
+    fn name(self) -> &'static str {
+        match self {
+            Self::Isolated => "default",
+            Self::NoNetwork => "no-network",
+            Self::Unfiltered => "none",
+        }
+    }
+}
+
+/// A seccomp-bpf program enforcing a profile on the host, which kills a process making a forbidden system call.
+///
+/// The program is compiled before forking, as nothing may be allocated between forking and executing.
+pub struct Filter(Vec<libc::sock_filter>);
+
+impl Filter {
+    /// Compiles the profile, which is `None` if it filters nothing.
+    ///
+    /// Sockets are allowed regardless of the profile if `network` is set, as the network of the execution is confined
+    /// by a network namespace instead.
+    pub fn new(profile: SeccompProfile, network: bool) -> Option<Self> {
+        if profile == SeccompProfile::Unfiltered {
+            return None;
+        }
+
+        let mut program = vec![
+            load(ARCH_OFFSET),
+            jump(libc::BPF_JEQ, AUDIT_ARCH, 1, 0),
+            ret(libc::SECCOMP_RET_KILL_PROCESS),
+            load(NR_OFFSET),
+            jump(libc::BPF_JGE, X32_SYSCALL_BIT, 0, 1),
+            ret(libc::SECCOMP_RET_KILL_PROCESS),
+        ];
+        let mut deny = |syscalls: &[(&str, libc::c_long)]| {
+            for (_, nr) in syscalls {
+                program.push(jump(libc::BPF_JEQ, *nr as u32, 0, 1));
+                program.push(ret(libc::SECCOMP_RET_KILL_PROCESS));
+            }
+        };
+        deny(PRIVILEGED);
+
+        if profile.forbids_processes() {
+            deny(FORKS);
+            program.extend([
+                // the flags of clone3 are behind a pointer, which a filter cannot read, so libc falls back to clone
+                jump(libc::BPF_JEQ, libc::SYS_clone3 as u32, 0, 1),
+                ret(libc::SECCOMP_RET_ERRNO | libc::ENOSYS as u32),
+                // clone creates a thread rather than a process with CLONE_THREAD, which every runtime needs
+                jump(libc::BPF_JEQ, libc::SYS_clone as u32, 0, 4),
+                load(ARG0_OFFSET),
+                jump(libc::BPF_JSET, libc::CLONE_THREAD as u32, 1, 0),
+                ret(libc::SECCOMP_RET_KILL_PROCESS),
+                ret(libc::SECCOMP_RET_ALLOW),
+            ]);
+        }
+
+        if profile.forbids_network() && !network {
+            program.extend([
+                // unix sockets stay allowed, as some runtimes use them without talking to anything outside
+                jump(libc::BPF_JEQ, libc::SYS_socket as u32, 0, 4),
+                load(ARG0_OFFSET),
+                jump(libc::BPF_JEQ, libc::AF_UNIX as u32, 1, 0),
+                ret(libc::SECCOMP_RET_KILL_PROCESS),
+                ret(libc::SECCOMP_RET_ALLOW),
+            ]);
+        }
+
+        program.push(ret(libc::SECCOMP_RET_ALLOW));
+        Some(Self(program))
+    }
+
+    /// Installs the filter in the calling process, which is inherited by everything it executes.
+    ///
+    /// This is meant to be called between fork and exec, so it only uses async-signal-safe functions. Executing the
+    /// program stays allowed, as `execve` is never filtered.
+    pub fn install(&self) -> io::Result<()> {
+        let program = libc::sock_fprog {
+            len: self.0.len() as libc::c_ushort,
+            filter: self.0.as_ptr().cast_mut(),
+        };
+
+        // SAFETY: the program points to the instructions of the filter, which outlive the call.
+        unsafe {
+            // an unprivileged process may only install a filter if it cannot gain privileges by executing
+            if libc::prctl(libc::PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0) != 0
+                || libc::prctl(
+                    libc::PR_SET_SECCOMP,
+                    libc::SECCOMP_MODE_FILTER,
+                    &program as *const libc::sock_fprog,
+                ) != 0
+            {
+                return Err(io::Error::last_os_error());
+            }
+        }
+
+        Ok(())
+    }
+}
+
+/// Gets the path of the docker seccomp profile enforcing the profile, writing it on first use, which allows sockets if
+/// `network` is set like [`Filter::new`].
+///
+/// The profile is `None` if it filters nothing, in which case docker applies its own default profile.
+pub fn docker_profile(profile: SeccompProfile, network: bool) -> io::Result<Option<PathBuf>> {
+    if profile == SeccompProfile::Unfiltered {
+        return Ok(None);
+    }
+
+    let mut written = DOCKER_PROFILES
+        .lock()
+        .expect("seccomp profiles lock poisoned");
+    if let Some((_, _, path)) = written
+        .iter()
+        .find(|(written, allows_network, _)| *written == profile && *allows_network == network)
+    {
+        return Ok(Some(path.clone()));
+    }
+
+    // the docker client reads the profile, so it only has to exist on this host
+    let suffix = if network { "-network" } else { "" };
+    let path = std::env::temp_dir().join(format!("mozart-seccomp-{}{suffix}.json", profile.name()));
+    let partial = path.with_extension(format!("{}.partial", Uuid::new_v4()));
+    fs::write(&partial, docker_json(profile, network).to_string())?;
+    fs::rename(&partial, &path)?;
+
+    written.push((profile, network, path.clone()));
+    Ok(Some(path))
+}
+
+/// Converts the profile into the JSON of a docker seccomp profile, which replaces the default profile of docker.
+///
+/// The profile is an allowlist like the default profile of docker, whose system calls it allows unless the profile
+/// forbids them, so no system call is allowed which docker would not allow. Forbidden system calls kill the process,
+/// while every other one fails with `EPERM`, like it does with the default profile of docker.
+fn docker_json(profile: SeccompProfile, network: bool) -> Value {
+    let kill = "SCMP_ACT_KILL_PROCESS";
+    let allow = "SCMP_ACT_ALLOW";
+    let forks: &[&str] = if profile.forbids_processes() {
+        &["fork", "vfork"]
+    } else {
+        &[]
+    };
+    let filters_sockets = profile.forbids_network() && !network;
+    let allowed: Vec<&str> = DOCKER_ALLOWED
+        .iter()
+        .chain(DOCKER_ALLOWED_NATIVE)
+        .copied()
+        .filter(|name| !PRIVILEGED.iter().any(|(privileged, _)| privileged == name))
+        .filter(|name| !forks.contains(name))
+        .filter(|name| !filters_sockets || *name != "socket")
+        .collect();
+    let privileged: Vec<&str> = PRIVILEGED.iter().map(|(name, _)| *name).collect();
+
+    let mut syscalls = vec![
+        json!({ "names": allowed, "action": allow }),
+        json!({ "names": privileged, "action": kill }),
+        json!({ "names": ["clone3"], "action": "SCMP_ACT_ERRNO", "errnoRet": libc::ENOSYS }),
+    ];
+    syscalls.extend(DOCKER_PERSONALITIES.iter().map(|personality| {
+        json!({
+            "names": ["personality"],
+            "action": allow,
+            "args": [{ "index": 0, "value": personality, "op": "SCMP_CMP_EQ" }],
+        })
+    }));
+
+    if profile.forbids_processes() {
+        syscalls.extend([
+            json!({ "names": forks, "action": kill }),
+            // threads are allowed, but not with new namespaces, which docker also forbids
+            json!({
+                "names": ["clone"],
+                "action": allow,
+                "args": [{
+                    "index": 0,
+                    "value": CLONE_NAMESPACES | libc::CLONE_THREAD,
+                    "valueTwo": libc::CLONE_THREAD,
+                    "op": "SCMP_CMP_MASKED_EQ",
+                }],
+            }),
+            json!({
+                "names": ["clone"],
+                "action": kill,
+                "args": [{ "index": 0, "value": libc::CLONE_THREAD, "valueTwo": 0, "op": "SCMP_CMP_MASKED_EQ" }],
+            }),
+        ]);
+    } else {
+        syscalls.push(json!({
+            "names": ["clone"],
+            "action": allow,
+            "args": [{ "index": 0, "value": CLONE_NAMESPACES, "valueTwo": 0, "op": "SCMP_CMP_MASKED_EQ" }],
+        }));
+    }
+    if filters_sockets {
+        syscalls.extend([
+            json!({
+                "names": ["socket"],
+                "action": allow,
+                "args": [{ "index": 0, "value": libc::AF_UNIX, "op": "SCMP_CMP_EQ" }],
+            }),
+            json!({
+                "names": ["socket"],
+                "action": kill,
+                "args": [{ "index": 0, "value": libc::AF_UNIX, "op": "SCMP_CMP_NE" }],
+            }),
+        ]);
+    }
+
+    json!({
+        "defaultAction": "SCMP_ACT_ERRNO",
+        "defaultErrnoRet": libc::EPERM,
+        "architectures": [DOCKER_ARCH],
+        "syscalls": syscalls,
+    })
+}
+
+/// Loads the word at the offset of `seccomp_data` into the accumulator.
+fn load(offset: u32) -> libc::sock_filter {
+    libc::sock_filter {
+        code: (libc::BPF_LD | libc::BPF_W | libc::BPF_ABS) as u16,
+        jt: 0,
+        jf: 0,
+        k: offset,
+    }
+}
+
+/// Compares the accumulator with `k`, skipping `jt` instructions if the comparison holds, and `jf` otherwise.
+fn jump(comparison: u32, k: u32, jt: u8, jf: u8) -> libc::sock_filter {
+    libc::sock_filter {
+        code: (libc::BPF_JMP | comparison | libc::BPF_K) as u16,
+        jt,
+        jf,
+        k,
+    }
+}
+
+/// Returns the action to the kernel, ending the program.
+fn ret(action: u32) -> libc::sock_filter {
+    libc::sock_filter {
+        code: (libc::BPF_RET | libc::BPF_K) as u16,
+        jt: 0,
+        jf: 0,
+        k: action,
+    }
+}
+
+#[cfg(test)]
+mod filter {
+    use super::{docker_json, Filter, PRIVILEGED, X32_SYSCALL_BIT};
+    use crate::config::SeccompProfile;
+
+    #[test]
+    fn unfiltered() {
+        assert!(Filter::new(SeccompProfile::Unfiltered, false).is_none());
+    }
+
+    #[test]
+    fn every_jump_stays_in_the_program() {
+        for profile in [SeccompProfile::Isolated, SeccompProfile::NoNetwork] {
+            let Filter(program) = Filter::new(profile, false).unwrap();
+
+            assert_eq!(
+                program.last().map(|instruction| instruction.k),
+                Some(libc::SECCOMP_RET_ALLOW)
+            );
+            for (index, instruction) in program.iter().enumerate() {
+                if u32::from(instruction.code) & 0x07 != libc::BPF_JMP {
+                    continue;
+                }
+                let furthest = index + 1 + usize::from(instruction.jt.max(instruction.jf));
+                assert!(
+                    furthest < program.len(),
+                    "jump at {index} leaves the program"
+                );
+            }
+        }
+    }
+
+    #[test]
+    fn no_network_is_smaller() {
+        let Filter(isolated) = Filter::new(SeccompProfile::Isolated, false).unwrap();
+        let Filter(no_network) = Filter::new(SeccompProfile::NoNetwork, false).unwrap();
+
+        assert!(no_network.len() < isolated.len());
+        assert!(no_network.len() > 2 * PRIVILEGED.len());
+    }
+
+    #[test]
+    fn network_namespace() {
+        let Filter(no_network) = Filter::new(SeccompProfile::NoNetwork, false).unwrap();
+        let Filter(namespaced) = Filter::new(SeccompProfile::NoNetwork, true).unwrap();
+
+        assert!(namespaced.len() < no_network.len());
+        assert!(
+            docker_json(SeccompProfile::Isolated, true)["syscalls"][0]["names"]
+                .as_array()
+                .unwrap()
+                .contains(&"socket".into())
+        );
+    }
+
+    #[test]
+    fn kills_x32_system_calls() {
+        let Filter(program) = Filter::new(SeccompProfile::NoNetwork, false).unwrap();
+
+        let check = program
+            .iter()
+            .position(|instruction| instruction.k == X32_SYSCALL_BIT)
+            .unwrap();
+        assert_eq!(check, 4);
+        assert_eq!(
+            program[check + 1].k,
+            libc::SECCOMP_RET_KILL_PROCESS,
+            "x32 system calls are not killed"
+        );
+    }
+
+    #[test]
+    fn docker() {
+        let actual = docker_json(SeccompProfile::NoNetwork, false);
+
+        assert_eq!(actual["defaultAction"], "SCMP_ACT_ERRNO");
+        let names = |action: &str| -> Vec<&str> {
+            actual["syscalls"]
+                .as_array()
+                .unwrap()
+                .iter()
+                .filter(|rule| rule["action"] == action && rule.get("args").is_none())
+                .flat_map(|rule| rule["names"].as_array().unwrap())
+                .filter_map(|name| name.as_str())
+                .collect()
+        };
+        let allowed = names("SCMP_ACT_ALLOW");
+        let killed = names("SCMP_ACT_KILL_PROCESS");
+        assert!(allowed.contains(&"read"));
+        assert!(allowed.contains(&"fork"));
+        assert!(!allowed.contains(&"socket"));
+        assert!(!allowed.contains(&"clone"));
+        assert!(!allowed.contains(&"io_uring_setup"));
+        assert!(killed.contains(&"ptrace"));
+        assert!(killed.contains(&"io_uring_enter"));
+    }
+}
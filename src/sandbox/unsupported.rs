@@ -0,0 +1,80 @@
+//! Stand-ins for the linux primitives the host sandbox confines executions with, so mozart builds on other platforms,
+//! where only the dev sandbox is available, which never uses them.
+
+pub mod network {
+    use std::{io, os::fd::RawFd};
+
+    /// A network namespace, which cannot be created.
+    pub struct Namespace;
+
+    impl Namespace {
+        pub fn create(_endpoints: &[String]) -> io::Result<Self> {
+            Err(io::ErrorKind::Unsupported.into())
+        }
+
+        pub fn fd(&self) -> RawFd {
+            -1
+        }
+    }
+
+    pub fn enter(_fd: RawFd) -> io::Result<()> {
+        Err(io::ErrorKind::Unsupported.into())
+    }
+}
+
+pub mod mount {
+    use std::{io, path::Path};
+
+    /// A mount namespace, which cannot be created.
+    pub struct Isolation;
+
+    impl Isolation {
+        pub fn new(_workspace: &Path, _work_dir: &Path) -> io::Result<Self> {
+            Err(io::ErrorKind::Unsupported.into())
+        }
+
+        pub fn enter(&self) -> io::Result<()> {
+            Err(io::ErrorKind::Unsupported.into())
+        }
+    }
+}
+
+pub mod quota {
+    use std::path::Path;
+
+    /// A limit of the size of a workspace, which is never set, as no workspace is a tmpfs of its own.
+    pub struct Quota;
+
+    impl Quota {
+        pub fn begin(_dir: &Path, _disk: u64) -> Option<Self> {
+            None
+        }
+
+        pub fn exhausted(&self) -> bool {
+            false
+        }
+    }
+}
+
+pub mod seccomp {
+    use crate::config::SeccompProfile;
+    use std::{io, path::PathBuf};
+
+    /// A seccomp filter, which is never built, as if every profile filtered nothing.
+    pub struct Filter;
+
+    impl Filter {
+        pub fn new(_profile: SeccompProfile, _network: bool) -> Option<Self> {
+            None
+        }
+
+        pub fn install(&self) -> io::Result<()> {
+            Err(io::ErrorKind::Unsupported.into())
+        }
+    }
+
+    /// Fails to write a docker seccomp profile, as the system calls it forbids are numbered for linux.
+    pub fn docker_profile(_profile: SeccompProfile, _network: bool) -> io::Result<Option<PathBuf>> {
+        Err(io::ErrorKind::Unsupported.into())
+    }
+}
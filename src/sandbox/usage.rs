@@ -0,0 +1,106 @@
+use std::{
+    fs,
+    os::unix::fs::MetadataExt,
+    path::{Path, PathBuf},
+    sync::Mutex,
+};
+
+/// The workspaces which are measured for the executions running in them.
+static MEASURED: Mutex<Vec<Measured>> = Mutex::new(Vec::new());
+
+struct Measured {
+    dir: PathBuf,
+    /// The number of executions running in the workspace, the last of which stops measuring it.
+    running: usize,
+    /// The disk usage of the workspace in bytes before the first of the executions started.
+    baseline: u64,
+    /// How many bytes the executions may write into the workspace together, which grows by the disk limit of every
+    /// execution which starts.
+    allowance: u64,
+}
+
+/// The disk limit of a workspace which is not a tmpfs of its own, which is enforced by measuring the workspace while
+/// the execution runs.
+///
+/// The executions running in a workspace at once are measured together, like the tmpfs of a workspace is sized, so
+/// each test case running alongside others has room to write up to its own limit, instead of every execution counting
+/// what the others wrote against its limit.
+pub struct Measurement {
+    dir: PathBuf,
+}
+
+impl Measurement {
+    /// Starts measuring the workspace for an execution with the disk limit in bytes.
+    pub fn begin(dir: &Path, disk: u64) -> Self {
+        // the workspace is walked outside of the lock, as it may take a while, but is only used for the first execution
+        let usage = disk_usage(dir);
+
+        let mut measured = MEASURED.lock().expect("measurement lock poisoned");
+        match measured.iter_mut().find(|measured| measured.dir == dir) {
+            Some(measured) => {
+                measured.running += 1;
+                measured.allowance = measured.allowance.saturating_add(disk);
+            }
+            None => measured.push(Measured {
+                dir: dir.to_path_buf(),
+                running: 1,
+                baseline: usage,
+                allowance: disk,
+            }),
+        }
+
+        Self {
+            dir: dir.to_path_buf(),
+        }
+    }
+
+    /// Whether the executions running in the workspace wrote more than their disk limits together.
+    pub fn exceeded(&self) -> bool {
+        let Some((baseline, allowance)) = MEASURED
+            .lock()
+            .expect("measurement lock poisoned")
+            .iter()
+            .find(|measured| measured.dir == self.dir)
+            .map(|measured| (measured.baseline, measured.allowance))
+        else {
+            return false;
+        };
+
+        disk_usage(&self.dir).saturating_sub(baseline) > allowance
+    }
+}
+
+impl Drop for Measurement {
+    fn drop(&mut self) {
+        let mut measured = MEASURED.lock().expect("measurement lock poisoned");
+        let Some(index) = measured
+            .iter()
+            .position(|measured| measured.dir == self.dir)
+        else {
+            return;
+        };
+
+        measured[index].running -= 1;
+        if measured[index].running == 0 {
+            measured.swap_remove(index);
+        }
+    }
+}
+
+/// Gets how many bytes the files in the directory take up on disk, which skips files it cannot measure.
+///
+/// The allocated blocks are counted rather than the lengths, so sparse files count as much as they use.
+fn disk_usage(dir: &Path) -> u64 {
+    let Ok(entries) = fs::read_dir(dir) else {
+        return 0;
+    };
+
+    entries
+        .flatten()
+        .map(|entry| match entry.metadata() {
+            Ok(metadata) if metadata.is_dir() => disk_usage(&entry.path()),
+            Ok(metadata) => metadata.blocks() * 512,
+            Err(_) => 0,
+        })
+        .sum()
+}
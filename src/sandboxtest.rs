@@ -0,0 +1,299 @@
+use crate::{
+    app,
+    config::{Config, SandboxKind},
+    model::Language,
+    plugin::VerdictPlugins,
+    server::{self, Timeouts},
+    signing::Signer,
+    AppState,
+};
+use reqwest::{header::CONTENT_TYPE, Client, Method, StatusCode};
+use serde_json::{json, Value};
+use std::{env, fs, net::SocketAddr, path::PathBuf, time::Duration};
+use tokio::{net::TcpListener, sync::oneshot};
+use uuid::Uuid;
+
+pub use crate::sandbox::fake::DIRECTIVE;
+
+/// How often the result of a task is polled until the task is done.
+const POLL_INTERVAL: Duration = Duration::from_millis(20);
+
+/// How long a task may take to be done before waiting for its result fails the test.
+const RESULT_TIMEOUT: Duration = Duration::from_secs(30);
+
+/// The whole mozart server, serving HTTP on an unused port of the loopback interface, which judges every submission on
+/// the fake sandbox, so the tests of a service judging with mozart can run against its real endpoints without any
+/// toolchain or docker.
+///
+/// The fake compiles and runs nothing, but does what the [directives](directive) in the solution tell it, so every
+/// language whose feature is enabled can be judged, with any verdict. The server stops and its directory is removed
+/// once it is dropped.
+pub struct TestServer {
+    address: SocketAddr,
+    /// The work directory of the server, which is its own, so servers can run side by side.
+    dir: PathBuf,
+    client: Client,
+    stop: Option<oneshot::Sender<()>>,
+}
+
+impl TestServer {
+    /// Starts a server with the default config.
+    pub async fn start() -> Self {
+        Self::with_config(Config::default()).await
+    }
+
+    /// Starts a server with the config, except that its sandbox is the fake, and its work directory is a fresh one.
+    pub async fn with_config(config: Config) -> Self {
+        let dir = env::temp_dir().join(format!("mozart-sandboxtest-{}", Uuid::new_v4()));
+        let plugins =
+            VerdictPlugins::from_config(&config).expect("the verdict plugins should load");
+        let state = AppState::with(
+            Config {
+                sandbox: SandboxKind::Fake,
+                work_dir: dir.clone(),
+                ..config
+            },
+            Signer::default(),
+            plugins,
+        );
+        if let Err(err) = state.toolchains.detect(&state.config).await {
+            panic!("failed to detect the fake toolchains: {err}");
+        }
+
+        let listener = TcpListener::bind("127.0.0.1:0")
+            .await
+            .expect("failed to bind the test server");
+        let address = listener
+            .local_addr()
+            .expect("a bound listener has an address");
+        let timeouts = Timeouts::from_config(&state.config);
+        let (stop, stopped) = oneshot::channel::<()>();
+        tokio::spawn(server::serve(
+            listener,
+            app(state),
+            None,
+            timeouts,
+            async move {
+                let _ = stopped.await;
+            },
+        ));
+
+        Self {
+            address,
+            dir,
+            client: Client::new(),
+            stop: Some(stop),
+        }
+    }
+
+    /// Gets the address the server listens on.
+    pub fn address(&self) -> SocketAddr {
+        self.address
+    }
+
+    /// Gets the url of the path on the server.
+    pub fn url(&self, path: &str) -> String {
+        format!("http://{}{path}", self.address)
+    }
+
+    /// Sends a request with the json body if there is one, responding with its status and its json body, which is
+    /// null if it has none.
+    pub async fn request(
+        &self,
+        method: Method,
+        path: &str,
+        body: Option<&Value>,
+    ) -> (StatusCode, Value) {
+        let mut request = self.client.request(method, self.url(path));
+        if let Some(body) = body {
+            request = request
+                .header(CONTENT_TYPE, "application/json")
+                .body(body.to_string());
+        }
+        let response = request
+            .send()
+            .await
+            .unwrap_or_else(|err| panic!("failed to request {path}: {err}"));
+
+        let status = response.status();
+        let body = response.bytes().await.unwrap_or_default();
+        (status, serde_json::from_slice(&body).unwrap_or(Value::Null))
+    }
+
+    /// Checks the submission with `POST /submit`, responding with the result once it is judged.
+    pub async fn submit(&self, submission: &Value) -> (StatusCode, Value) {
+        self.request(Method::POST, "/submit", Some(submission))
+            .await
+    }
+
+    /// Submits the submission as a job with `POST /task`, which is judged in the background, returning its id.
+    pub async fn create_task(&self, submission: &Value) -> Uuid {
+        let (status, task) = self.request(Method::POST, "/task", Some(submission)).await;
+        assert_eq!(
+            status,
+            StatusCode::ACCEPTED,
+            "the task was not accepted: {task}"
+        );
+
+        task["id"]
+            .as_str()
+            .and_then(|id| id.parse().ok())
+            .unwrap_or_else(|| panic!("the task has no id: {task}"))
+    }
+
+    /// Waits until the task is done, responding with its result.
+    pub async fn result(&self, id: Uuid) -> (StatusCode, Value) {
+        let path = format!("/task/{id}/result");
+        let deadline = tokio::time::Instant::now() + RESULT_TIMEOUT;
+        loop {
+            let (status, result) = self.request(Method::GET, &path, None).await;
+            if status != StatusCode::ACCEPTED {
+                return (status, result);
+            }
+            assert!(
+                tokio::time::Instant::now() < deadline,
+                "the task {id} was not done within {} seconds",
+                RESULT_TIMEOUT.as_secs()
+            );
+            tokio::time::sleep(POLL_INTERVAL).await;
+        }
+    }
+
+    /// Cancels the task with `DELETE /task/{id}`, responding with the status.
+    pub async fn cancel(&self, id: Uuid) -> StatusCode {
+        let (status, _) = self
+            .request(Method::DELETE, &format!("/task/{id}"), None)
+            .await;
+        status
+    }
+}
+
+impl Drop for TestServer {
+    fn drop(&mut self) {
+        if let Some(stop) = self.stop.take() {
+            let _ = stop.send(());
+        }
+        let _ = fs::remove_dir_all(&self.dir);
+    }
+}
+
+/// Gets the directive telling the fake how every test case behaves, and how the solution compiles:
+///
+/// | Behavior | Effect |
+/// | --- | --- |
+/// | `pass` | The test case passes, as it does without a directive. |
+/// | `wrong-answer <actual> <expected>` | The test case prints the actual rather than the expected value. |
+/// | `runtime-error <stderr>` | The test program exits with 1, printing to its standard error. |
+/// | `time-limit-exceeded` | The test program is killed at its time limit, without taking that long. |
+/// | `memory-limit-exceeded` | The test program is killed as it exceeded its memory limit. |
+/// | `hang` | The test program runs until it is cancelled, or is killed at its time limit. |
+/// | `compilation-error <message>` | The solution does not compile, with the message as the compiler output. |
+pub fn directive(behavior: &str) -> String {
+    format!("{DIRECTIVE}: {behavior}")
+}
+
+/// Gets the directive telling the fake how the test case at the index behaves, which takes precedence over a directive
+/// for every test case.
+pub fn directive_for(index: usize, behavior: &str) -> String {
+    format!("{DIRECTIVE}[{index}]: {behavior}")
+}
+
+/// Creates a submission of the directives as the solution in the language, with as many test cases, each of which has
+/// an integer input and output, numbered from zero.
+pub fn submission(language: Language, directives: &[String], test_cases: usize) -> Value {
+    let test_cases: Vec<Value> = (0..test_cases)
+        .map(|id| {
+            json!({
+                "id": id,
+                "inputParameters": [{ "valueType": "int", "value": id.to_string() }],
+                "outputParameters": [{ "valueType": "int", "value": id.to_string() }]
+            })
+        })
+        .collect();
+
+    json!({
+        "language": language,
+        "solution": directives.join("\n"),
+        "testCases": test_cases
+    })
+}
+
+#[cfg(all(test, feature = "python"))]
+mod scenarios {
+    use super::{directive, directive_for, submission, TestServer};
+    use crate::model::Language;
+    use reqwest::StatusCode;
+
+    #[tokio::test]
+    async fn pass_and_wrong_answer() {
+        let server = TestServer::start().await;
+
+        let (passed_status, passed) = server
+            .submit(&submission(Language::Python, &[directive("pass")], 2))
+            .await;
+        let (failed_status, failed) = server
+            .submit(&submission(
+                Language::Python,
+                &[directive_for(1, "wrong-answer 4 5")],
+                2,
+            ))
+            .await;
+
+        assert_eq!(passed_status, StatusCode::OK);
+        assert_eq!(passed["verdict"], "pass");
+        assert_eq!(passed["testCaseResults"][1]["testResult"], "pass");
+        assert_eq!(failed_status, StatusCode::OK);
+        assert_eq!(failed["verdict"], "failure");
+        assert_eq!(failed["testCaseResults"][0]["testResult"], "pass");
+        let wrong = &failed["testCaseResults"][1]["testResult"]["failure"]["wrongAnswer"];
+        assert_eq!(wrong["actual"], "4");
+        assert_eq!(wrong["expected"], "5");
+    }
+
+    #[tokio::test]
+    async fn time_limit_exceeded_and_compilation_error() {
+        let server = TestServer::start().await;
+
+        let (_, timed_out) = server
+            .submit(&submission(
+                Language::Python,
+                &[directive("time-limit-exceeded")],
+                1,
+            ))
+            .await;
+        let id = server
+            .create_task(&submission(
+                Language::Python,
+                &[directive("compilation-error expected an indented block")],
+                1,
+            ))
+            .await;
+        let (status, uncompiled) = server.result(id).await;
+
+        assert_eq!(timed_out["verdict"], "failure");
+        assert_eq!(
+            timed_out["testCaseResults"][0]["testResult"]["failure"],
+            "timeLimitExceeded"
+        );
+        assert_eq!(status, StatusCode::BAD_REQUEST);
+        assert_eq!(uncompiled["verdict"], "compilationError");
+        assert!(uncompiled["compileOutput"]
+            .as_str()
+            .is_some_and(|output| output.contains("expected an indented block")));
+    }
+
+    #[tokio::test]
+    async fn cancels_hanging_task() {
+        let server = TestServer::start().await;
+
+        let id = server
+            .create_task(&submission(Language::Python, &[directive("hang")], 1))
+            .await;
+        let cancelled = server.cancel(id).await;
+        let (status, result) = server.result(id).await;
+
+        assert_eq!(cancelled, StatusCode::ACCEPTED);
+        assert_eq!(status, StatusCode::GONE);
+        assert_eq!(result["code"], "cancelled");
+    }
+}
@@ -0,0 +1,225 @@
+use crate::model::{TestCase, TestCaseResult, TestResult};
+use serde::{Deserialize, Serialize};
+
+/// How the test cases of a group are scored.
+///
+/// A test case without a group is scored like a group of its own, whose weight is that of the test case, so both
+/// strategies score it the same.
+#[derive(Deserialize, Serialize, Clone, Copy, PartialEq, Debug, Default)]
+pub enum Scoring {
+    /// A group scores its full weight if every test case in it passed, and nothing otherwise.
+    #[default]
+    #[serde(rename = "allOrNothing")]
+    AllOrNothing,
+
+    /// A group scores the share of its weight which the weights of its passed test cases make up.
+    #[serde(rename = "proportional")]
+    Proportional,
+}
+
+/// A group of test cases, which is scored as a whole.
+#[derive(Deserialize, Serialize, Clone, PartialEq, Debug)]
+pub struct TestGroup {
+    /// The name of the group, which test cases refer to.
+    pub name: String,
+    /// The relative weight of the group, which must be greater than zero.
+    #[serde(default = "default_weight")]
+    pub weight: u32,
+}
+
+fn default_weight() -> u32 {
+    1
+}
+
+/// The score of a group of test cases.
+#[derive(Deserialize, Serialize, Clone, PartialEq, Debug)]
+pub struct GroupScore {
+    pub name: String,
+    /// The score of the group from 0 to 100, regardless of its weight.
+    pub score: f64,
+}
+
+/// Scores the results of the test cases from 0 to 100, along with the score of every group in the order they were
+/// declared.
+///
+/// Only passed test cases count, so a test case which was not run scores nothing. The groups of the test cases are
+/// expected to be declared, which the submission is validated for.
+pub fn score(
+    scoring: Scoring,
+    groups: &[TestGroup],
+    test_cases: &[TestCase],
+    results: &[TestCaseResult],
+) -> (f64, Vec<GroupScore>) {
+    let passed = |test_case: &TestCase| {
+        results
+            .iter()
+            .any(|result| result.id == test_case.id && result.test_result == TestResult::Pass)
+    };
+    let fraction = |test_cases: &[&TestCase]| {
+        let total: u64 = test_cases
+            .iter()
+            .map(|test_case| u64::from(test_case.weight))
+            .sum();
+        let passed: u64 = test_cases
+            .iter()
+            .filter(|test_case| passed(test_case))
+            .map(|test_case| u64::from(test_case.weight))
+            .sum();
+
+        match scoring {
+            _ if total == 0 => 0.0,
+            Scoring::AllOrNothing if passed == total => 1.0,
+            Scoring::AllOrNothing => 0.0,
+            Scoring::Proportional => passed as f64 / total as f64,
+        }
+    };
+
+    let mut weighted = Vec::with_capacity(groups.len() + test_cases.len());
+    let mut group_scores = Vec::with_capacity(groups.len());
+    for group in groups {
+        let members: Vec<&TestCase> = test_cases
+            .iter()
+            .filter(|test_case| test_case.group.as_ref() == Some(&group.name))
+            .collect();
+        let fraction = fraction(&members);
+
+        weighted.push((f64::from(group.weight), fraction));
+        group_scores.push(GroupScore {
+            name: group.name.clone(),
+            score: fraction * 100.0,
+        });
+    }
+    for test_case in test_cases
+        .iter()
+        .filter(|test_case| test_case.group.is_none())
+    {
+        weighted.push((f64::from(test_case.weight), fraction(&[test_case])));
+    }
+
+    let total: f64 = weighted.iter().map(|(weight, _)| weight).sum();
+    let scored: f64 = weighted
+        .iter()
+        .map(|(weight, fraction)| weight * fraction)
+        .sum();
+    let score = match total > 0.0 {
+        true => scored / total * 100.0,
+        false => 0.0,
+    };
+
+    (score, group_scores)
+}
+
+#[cfg(test)]
+mod scoring {
+    use super::{score, Scoring, TestGroup};
+    use crate::model::{TestCase, TestCaseFailureReason, TestCaseResult, TestResult};
+    use std::collections::BTreeMap;
+
+    fn test_case(id: u64, group: Option<&str>, weight: u32) -> TestCase {
+        serde_json::from_value(serde_json::json!({
+            "id": id,
+            "group": group,
+            "weight": weight,
+            "inputParameters": [],
+            "outputParameters": [{ "valueType": "int", "value": "1" }],
+        }))
+        .unwrap()
+    }
+
+    fn result(id: u64, passed: bool) -> TestCaseResult {
+        TestCaseResult {
+            id,
+            name: None,
+            test_result: match passed {
+                true => TestResult::Pass,
+                false => TestResult::Failure(TestCaseFailureReason::RuntimeError),
+            },
+            hidden: false,
+            stderr: String::new(),
+            runtime: 0,
+            memory: None,
+            user_time: None,
+            system_time: None,
+            cause: None,
+            benchmark: None,
+            attachments: BTreeMap::new(),
+        }
+    }
+
+    fn group(name: &str, weight: u32) -> TestGroup {
+        TestGroup {
+            name: name.to_string(),
+            weight,
+        }
+    }
+
+    #[test]
+    fn ungrouped_test_cases_are_weighted() {
+        let test_cases = [test_case(0, None, 3), test_case(1, None, 1)];
+        let results = [result(0, true), result(1, false)];
+
+        for scoring in [Scoring::AllOrNothing, Scoring::Proportional] {
+            let (actual, groups) = score(scoring, &[], &test_cases, &results);
+
+            assert_eq!(actual, 75.0);
+            assert!(groups.is_empty());
+        }
+    }
+
+    #[test]
+    fn all_or_nothing() {
+        let groups = [group("small", 1), group("large", 3)];
+        let test_cases = [
+            test_case(0, Some("small"), 1),
+            test_case(1, Some("large"), 1),
+            test_case(2, Some("large"), 1),
+        ];
+        let results = [result(0, true), result(1, true), result(2, false)];
+
+        let (actual, group_scores) = score(Scoring::AllOrNothing, &groups, &test_cases, &results);
+
+        assert_eq!(actual, 25.0);
+        assert_eq!(group_scores[0].score, 100.0);
+        assert_eq!(group_scores[1].score, 0.0);
+    }
+
+    #[test]
+    fn proportional() {
+        let groups = [group("small", 1), group("large", 3)];
+        let test_cases = [
+            test_case(0, Some("small"), 1),
+            test_case(1, Some("large"), 3),
+            test_case(2, Some("large"), 1),
+            test_case(3, None, 4),
+        ];
+        let results = [result(0, true), result(1, true), result(2, false)];
+
+        let (actual, group_scores) = score(Scoring::Proportional, &groups, &test_cases, &results);
+
+        assert_eq!(group_scores[1].score, 75.0);
+        assert_eq!(actual, (1.0 + 3.0 * 0.75) / 8.0 * 100.0);
+    }
+
+    #[test]
+    fn unrun_test_cases_score_nothing() {
+        let test_cases = [test_case(0, None, 1)];
+
+        let (actual, _) = score(Scoring::AllOrNothing, &[], &test_cases, &[]);
+
+        assert_eq!(actual, 0.0);
+    }
+
+    #[test]
+    fn weights_summing_past_u32() {
+        let groups = [group("large", 1)];
+        let test_cases = [
+            test_case(0, Some("large"), u32::MAX),
+            test_case(1, Some("large"), u32::MAX),
+        ];
+        let results = [result(0, true), result(1, false)];
+
+        let (actual, _) = score(Scoring::Proportional, &groups, &test_cases, &results);
+
+        assert_eq!(actual, 50.0);
+    }
+}
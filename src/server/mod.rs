@@ -0,0 +1,181 @@
+use axum::{body::Body, extract::ConnectInfo, http::Request, Router};
+use hyper::{
+    body::Incoming,
+    service::{service_fn, Service},
+};
+use hyper_util::{
+    rt::{TokioExecutor, TokioIo},
+    server::{
+        conn::auto::Builder,
+        graceful::{GracefulShutdown, Watcher},
+    },
+    service::TowerToHyperService,
+};
+use std::{convert::Infallible, future::Future, net::SocketAddr, pin::pin};
+use timeout::{Activity, ReadDeadline, Timed, Tracked};
+use tokio::{
+    io::{AsyncRead, AsyncWrite},
+    net::TcpListener,
+};
+use tokio_rustls::TlsAcceptor;
+use tracing::{debug, warn};
+
+mod timeout;
+pub mod tls;
+
+pub use timeout::Timeouts;
+
+/// Serves the app on the listener until the shutdown future completes, after which no connections are accepted, and
+/// the connections which are still open are closed once their requests are done.
+///
+/// Connections are served over TLS if an acceptor is given, and are closed once their client takes longer than the
+/// timeouts allow. The peer address of every connection is available to the app as the `ConnectInfo` of its requests.
+pub async fn serve(
+    listener: TcpListener,
+    app: Router,
+    tls: Option<TlsAcceptor>,
+    timeouts: Timeouts,
+    shutdown: impl Future<Output = ()>,
+) {
+    let mut builder = Builder::new(TokioExecutor::new());
+    // requests are timed by the stream of the connection, which tells an idle connection from a slow request
+    builder.http1().header_read_timeout(None);
+    let graceful = GracefulShutdown::new();
+    let mut shutdown = pin!(shutdown);
+
+    loop {
+        let (socket, peer) = tokio::select! {
+            accepted = listener.accept() => match accepted {
+                Ok(accepted) => accepted,
+                Err(err) => {
+                    warn!(%err, "failed to accept connection");
+                    continue;
+                }
+            },
+            () = &mut shutdown => break,
+        };
+
+        let (socket, activity) = Timed::new(socket, timeouts);
+        let connection = Connection {
+            builder: builder.clone(),
+            watcher: graceful.watcher(),
+            app: app.clone(),
+            peer,
+            activity,
+        };
+        let tls = tls.clone();
+        tokio::spawn(async move {
+            let Some(acceptor) = tls else {
+                return connection.serve(socket).await;
+            };
+            match tls::accept(&acceptor, socket, timeouts.read).await {
+                Ok(stream) => {
+                    connection.activity.reset();
+                    connection.serve(stream).await;
+                }
+                Err(err) => debug!(%err, %peer, "failed TLS handshake"),
+            }
+        });
+    }
+
+    graceful.shutdown().await;
+}
+
+/// A connection which has been accepted, along with what is needed to serve it.
+struct Connection {
+    builder: Builder<TokioExecutor>,
+    watcher: Watcher,
+    app: Router,
+    peer: SocketAddr,
+    activity: Activity,
+}
+
+impl Connection {
+    /// Serves the requests of the connection until it is closed, by either side or by shutting down.
+    async fn serve<S>(self, stream: S)
+    where
+        S: AsyncRead + AsyncWrite + Unpin + Send + 'static,
+    {
+        let Self {
+            builder,
+            watcher,
+            app,
+            peer,
+            activity,
+        } = self;
+        let app = TowerToHyperService::new(app);
+        let service = service_fn(move |request: Request<Incoming>| {
+            let in_flight = activity.begin();
+            let deadline = activity.read_deadline();
+            let mut request = request.map(|body| Body::new(ReadDeadline::new(body, deadline)));
+            request.extensions_mut().insert(ConnectInfo(peer));
+            let response = app.call(request);
+
+            async move {
+                let response = response.await?;
+                Ok::<_, Infallible>(response.map(|body| Body::new(Tracked::new(body, in_flight))))
+            }
+        });
+
+        let connection = builder.serve_connection(TokioIo::new(stream), service);
+        if let Err(err) = watcher.watch(connection).await {
+            debug!(%err, %peer, "connection failed");
+        }
+    }
+}
+
+#[cfg(test)]
+mod serve {
+    use super::{serve, Timeouts};
+    use axum::{extract::ConnectInfo, routing::get, Router};
+    use std::{future, net::SocketAddr, time::Duration};
+    use tokio::{
+        io::{AsyncReadExt, AsyncWriteExt},
+        net::{TcpListener, TcpStream},
+        time,
+    };
+
+    const TIMEOUTS: Timeouts = Timeouts {
+        read: Some(Duration::from_millis(200)),
+        write: Some(Duration::from_millis(200)),
+        idle: Some(Duration::from_millis(200)),
+    };
+
+    /// Serves an app responding with the peer address on an unused port, returning the address it serves on.
+    async fn server() -> SocketAddr {
+        let listener = TcpListener::bind("127.0.0.1:0").await.unwrap();
+        let address = listener.local_addr().unwrap();
+        let app = Router::new().route(
+            "/",
+            get(|ConnectInfo(peer): ConnectInfo<SocketAddr>| async move { peer.to_string() }),
+        );
+        tokio::spawn(serve(listener, app, None, TIMEOUTS, future::pending()));
+
+        address
+    }
+
+    #[tokio::test]
+    async fn serves_requests_with_peer_address() {
+        let mut client = TcpStream::connect(server().await).await.unwrap();
+        let peer = client.local_addr().unwrap();
+
+        client
+            .write_all(b"GET / HTTP/1.1\r\nHost: mozart\r\nConnection: close\r\n\r\n")
+            .await
+            .unwrap();
+        let mut response = String::new();
+        client.read_to_string(&mut response).await.unwrap();
+
+        assert!(response.starts_with("HTTP/1.1 200 OK"), "{response}");
+        assert!(response.ends_with(&peer.to_string()), "{response}");
+    }
+
+    #[tokio::test]
+    async fn closes_idle_connections() {
+        let mut client = TcpStream::connect(server().await).await.unwrap();
+
+        let read = time::timeout(Duration::from_secs(2), client.read(&mut [0; 8])).await;
+
+        assert!(matches!(read, Ok(Ok(0))), "the connection is closed");
+    }
+}
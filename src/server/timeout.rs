@@ -0,0 +1,401 @@
+use crate::config::Config;
+use hyper::body::{Body, Frame, SizeHint};
+use std::{
+    future::Future,
+    io::{self, IoSlice},
+    pin::Pin,
+    sync::{Arc, Mutex},
+    task::{ready, Context, Poll},
+    time::Duration,
+};
+use tokio::{
+    io::{AsyncRead, AsyncWrite, ReadBuf},
+    time::{self, Instant, Sleep},
+};
+
+/// How long the HTTP server waits for the clients of its connections.
+#[derive(Clone, Copy, Debug)]
+pub struct Timeouts {
+    /// How long a client may take to complete the TLS handshake, or to send a request once it sent its first byte.
+    pub read: Option<Duration>,
+    /// How long writing a response may be blocked by a client which does not read it.
+    pub write: Option<Duration>,
+    /// How long a connection without a request is kept open.
+    pub idle: Option<Duration>,
+}
+
+impl Timeouts {
+    pub fn from_config(config: &Config) -> Self {
+        Self {
+            read: config.read_timeout(),
+            write: config.write_timeout(),
+            idle: config.idle_timeout(),
+        }
+    }
+}
+
+/// What a connection is doing, which decides which timeout applies while it waits for its client.
+struct State {
+    /// The requests of the connection whose responses have not been written yet.
+    requests: usize,
+    /// When the connection last had no request, as it was accepted or wrote its last response.
+    idle_since: Instant,
+    /// When the first byte of the next request arrived, which is only tracked while the connection has no request.
+    request_since: Option<Instant>,
+}
+
+/// The activity of a connection, which is shared by its stream and its requests.
+#[derive(Clone)]
+pub struct Activity {
+    timeouts: Timeouts,
+    state: Arc<Mutex<State>>,
+}
+
+impl Activity {
+    fn new(timeouts: Timeouts) -> Self {
+        Self {
+            timeouts,
+            state: Arc::new(Mutex::new(State {
+                requests: 0,
+                idle_since: Instant::now(),
+                request_since: None,
+            })),
+        }
+    }
+
+    fn lock(&self) -> std::sync::MutexGuard<'_, State> {
+        self.state
+            .lock()
+            .expect("connection activity lock poisoned")
+    }
+
+    /// Makes the connection idle, e.g. once the TLS handshake is complete, so its first request is waited for as long
+    /// as for any other.
+    pub fn reset(&self) {
+        let mut state = self.lock();
+        state.idle_since = Instant::now();
+        state.request_since = None;
+    }
+
+    /// Starts a request, which keeps the connection from timing out until its response has been written.
+    pub fn begin(&self) -> InFlight {
+        let mut state = self.lock();
+        state.requests += 1;
+        state.request_since = None;
+
+        InFlight(self.clone())
+    }
+
+    /// Gets the read timeout of a request which starts now, if reads are limited at all.
+    pub fn read_deadline(&self) -> Option<Instant> {
+        self.timeouts.read.map(|timeout| Instant::now() + timeout)
+    }
+
+    /// Notes that the connection received bytes, which start the next request if it has none.
+    fn received(&self) {
+        let mut state = self.lock();
+        if state.requests == 0 && state.request_since.is_none() {
+            state.request_since = Some(Instant::now());
+        }
+    }
+
+    /// Gets when the connection times out while it waits for its client to send something, which it never does while
+    /// it has a request.
+    fn waiting_deadline(&self) -> Option<Instant> {
+        let state = self.lock();
+        if state.requests > 0 {
+            return None;
+        }
+
+        match state.request_since {
+            Some(since) => self.timeouts.read.map(|timeout| since + timeout),
+            None => self.timeouts.idle.map(|timeout| state.idle_since + timeout),
+        }
+    }
+}
+
+/// A request of a connection, which is in flight until it is dropped along with its response.
+pub struct InFlight(Activity);
+
+impl Drop for InFlight {
+    fn drop(&mut self) {
+        let mut state = self.0.lock();
+        state.requests -= 1;
+        if state.requests == 0 {
+            state.idle_since = Instant::now();
+        }
+    }
+}
+
+/// The stream of a connection, which fails once its client takes longer than the timeouts allow.
+pub struct Timed<S> {
+    stream: S,
+    activity: Activity,
+    read_timer: Pin<Box<Sleep>>,
+    write_timer: Pin<Box<Sleep>>,
+    /// When the pending write started, which is blocked until the client reads.
+    write_since: Option<Instant>,
+}
+
+impl<S> Timed<S> {
+    /// Wraps the stream of a connection which has just been accepted, along with its activity.
+    pub fn new(stream: S, timeouts: Timeouts) -> (Self, Activity) {
+        let activity = Activity::new(timeouts);
+        let timed = Self {
+            stream,
+            activity: activity.clone(),
+            read_timer: Box::pin(time::sleep(Duration::ZERO)),
+            write_timer: Box::pin(time::sleep(Duration::ZERO)),
+            write_since: None,
+        };
+
+        (timed, activity)
+    }
+
+    /// Fails a pending write if it has been blocked for longer than the write timeout.
+    fn poll_write_timeout<T>(&mut self, cx: &mut Context<'_>) -> Poll<io::Result<T>> {
+        let Some(timeout) = self.activity.timeouts.write else {
+            return Poll::Pending;
+        };
+
+        let since = *self.write_since.get_or_insert_with(Instant::now);
+        self.write_timer.as_mut().reset(since + timeout);
+        ready!(self.write_timer.as_mut().poll(cx));
+        Poll::Ready(Err(io::Error::new(
+            io::ErrorKind::TimedOut,
+            "the client did not read the response in time",
+        )))
+    }
+
+    /// Completes a write which is no longer blocked, or fails it once it has been blocked for too long.
+    fn write_polled<T>(
+        &mut self,
+        cx: &mut Context<'_>,
+        polled: Poll<io::Result<T>>,
+    ) -> Poll<io::Result<T>> {
+        match polled {
+            Poll::Ready(result) => {
+                self.write_since = None;
+                Poll::Ready(result)
+            }
+            Poll::Pending => self.poll_write_timeout(cx),
+        }
+    }
+}
+
+impl<S: AsyncRead + Unpin> AsyncRead for Timed<S> {
+    fn poll_read(
+        mut self: Pin<&mut Self>,
+        cx: &mut Context<'_>,
+        buf: &mut ReadBuf<'_>,
+    ) -> Poll<io::Result<()>> {
+        let this = &mut *self;
+        let filled = buf.filled().len();
+        if let Poll::Ready(result) = Pin::new(&mut this.stream).poll_read(cx, buf) {
+            if buf.filled().len() > filled {
+                this.activity.received();
+            }
+            return Poll::Ready(result);
+        }
+
+        let Some(deadline) = this.activity.waiting_deadline() else {
+            return Poll::Pending;
+        };
+        this.read_timer.as_mut().reset(deadline);
+        ready!(this.read_timer.as_mut().poll(cx));
+        Poll::Ready(Err(io::Error::new(
+            io::ErrorKind::TimedOut,
+            "the client did not send a request in time",
+        )))
+    }
+}
+
+impl<S: AsyncWrite + Unpin> AsyncWrite for Timed<S> {
+    fn poll_write(
+        mut self: Pin<&mut Self>,
+        cx: &mut Context<'_>,
+        buf: &[u8],
+    ) -> Poll<io::Result<usize>> {
+        let this = &mut *self;
+        let polled = Pin::new(&mut this.stream).poll_write(cx, buf);
+        this.write_polled(cx, polled)
+    }
+
+    fn poll_write_vectored(
+        mut self: Pin<&mut Self>,
+        cx: &mut Context<'_>,
+        bufs: &[IoSlice<'_>],
+    ) -> Poll<io::Result<usize>> {
+        let this = &mut *self;
+        let polled = Pin::new(&mut this.stream).poll_write_vectored(cx, bufs);
+        this.write_polled(cx, polled)
+    }
+
+    fn is_write_vectored(&self) -> bool {
+        self.stream.is_write_vectored()
+    }
+
+    fn poll_flush(mut self: Pin<&mut Self>, cx: &mut Context<'_>) -> Poll<io::Result<()>> {
+        let this = &mut *self;
+        let polled = Pin::new(&mut this.stream).poll_flush(cx);
+        this.write_polled(cx, polled)
+    }
+
+    fn poll_shutdown(mut self: Pin<&mut Self>, cx: &mut Context<'_>) -> Poll<io::Result<()>> {
+        let this = &mut *self;
+        let polled = Pin::new(&mut this.stream).poll_shutdown(cx);
+        this.write_polled(cx, polled)
+    }
+}
+
+/// The body of a request, which fails once it has not been received by the read timeout of its request.
+pub struct ReadDeadline<B> {
+    body: B,
+    deadline: Option<Pin<Box<Sleep>>>,
+}
+
+impl<B> ReadDeadline<B> {
+    pub fn new(body: B, deadline: Option<Instant>) -> Self {
+        Self {
+            body,
+            deadline: deadline.map(|deadline| Box::pin(time::sleep_until(deadline))),
+        }
+    }
+}
+
+impl<B> Body for ReadDeadline<B>
+where
+    B: Body + Unpin,
+    B::Error: Into<Box<dyn std::error::Error + Send + Sync>>,
+{
+    type Data = B::Data;
+    type Error = io::Error;
+
+    fn poll_frame(
+        mut self: Pin<&mut Self>,
+        cx: &mut Context<'_>,
+    ) -> Poll<Option<Result<Frame<Self::Data>, Self::Error>>> {
+        let this = &mut *self;
+        if let Poll::Ready(frame) = Pin::new(&mut this.body).poll_frame(cx) {
+            return Poll::Ready(frame.map(|frame| frame.map_err(io::Error::other)));
+        }
+
+        match &mut this.deadline {
+            Some(deadline) => {
+                ready!(deadline.as_mut().poll(cx));
+                Poll::Ready(Some(Err(io::Error::new(
+                    io::ErrorKind::TimedOut,
+                    "the client did not send the request body in time",
+                ))))
+            }
+            None => Poll::Pending,
+        }
+    }
+
+    fn is_end_stream(&self) -> bool {
+        self.body.is_end_stream()
+    }
+
+    fn size_hint(&self) -> SizeHint {
+        self.body.size_hint()
+    }
+}
+
+/// The body of a response, which keeps its request in flight until it has been written, or dropped.
+pub struct Tracked<B> {
+    body: B,
+    _request: InFlight,
+}
+
+impl<B> Tracked<B> {
+    pub fn new(body: B, request: InFlight) -> Self {
+        Self {
+            body,
+            _request: request,
+        }
+    }
+}
+
+impl<B: Body + Unpin> Body for Tracked<B> {
+    type Data = B::Data;
+    type Error = B::Error;
+
+    fn poll_frame(
+        mut self: Pin<&mut Self>,
+        cx: &mut Context<'_>,
+    ) -> Poll<Option<Result<Frame<Self::Data>, Self::Error>>> {
+        Pin::new(&mut self.body).poll_frame(cx)
+    }
+
+    fn is_end_stream(&self) -> bool {
+        self.body.is_end_stream()
+    }
+
+    fn size_hint(&self) -> SizeHint {
+        self.body.size_hint()
+    }
+}
+
+#[cfg(test)]
+mod timed {
+    use super::{Timed, Timeouts};
+    use std::{io::ErrorKind, time::Duration};
+    use tokio::io::{self, AsyncReadExt, AsyncWriteExt};
+
+    const TIMEOUTS: Timeouts = Timeouts {
+        read: Some(Duration::from_millis(100)),
+        write: Some(Duration::from_millis(100)),
+        idle: Some(Duration::from_millis(300)),
+    };
+
+    #[tokio::test]
+    async fn closes_idle_connection() {
+        let (_client, server) = io::duplex(64);
+        let (mut timed, _) = Timed::new(server, TIMEOUTS);
+
+        let actual = timed.read(&mut [0; 8]).await;
+
+        assert_eq!(actual.unwrap_err().kind(), ErrorKind::TimedOut);
+    }
+
+    #[tokio::test]
+    async fn waits_for_request_within_read_timeout() {
+        let (mut client, server) = io::duplex(64);
+        let (mut timed, _) = Timed::new(server, TIMEOUTS);
+
+        client.write_all(b"GET / HTTP/1.1\r\n").await.unwrap();
+        let first = timed.read(&mut [0; 64]).await;
+        let started = tokio::time::Instant::now();
+        let second = timed.read(&mut [0; 64]).await;
+
+        assert!(first.is_ok());
+        assert_eq!(second.unwrap_err().kind(), ErrorKind::TimedOut);
+        assert!(started.elapsed() < Duration::from_millis(300));
+    }
+
+    #[tokio::test]
+    async fn keeps_connection_with_request() {
+        let (mut client, server) = io::duplex(64);
+        let (mut timed, activity) = Timed::new(server, TIMEOUTS);
+
+        let request = activity.begin();
+        let read = tokio::time::timeout(Duration::from_millis(500), timed.read(&mut [0; 8])).await;
+        drop(request);
+        client.write_all(b"x").await.unwrap();
+
+        assert!(
+            read.is_err(),
+            "the read is not timed out while a request is in flight"
+        );
+    }
+
+    #[tokio::test]
+    async fn fails_blocked_write() {
+        let (_client, server) = io::duplex(8);
+        let (mut timed, _) = Timed::new(server, TIMEOUTS);
+
+        let actual = timed.write_all(&[0; 64]).await;
+
+        assert_eq!(actual.unwrap_err().kind(), ErrorKind::TimedOut);
+    }
+}
@@ -0,0 +1,149 @@
+use crate::{config::Config, error::TlsError};
+use std::{io, path::Path, sync::Arc, time::Duration};
+use tokio::{
+    io::{AsyncRead, AsyncWrite},
+    time,
+};
+use tokio_rustls::{
+    rustls::{
+        crypto::{ring, CryptoProvider},
+        pki_types::{
+            pem::{self, PemObject},
+            CertificateDer, PrivateKeyDer,
+        },
+        server::{danger::ClientCertVerifier, WebPkiClientVerifier},
+        RootCertStore, ServerConfig,
+    },
+    server::TlsStream,
+    TlsAcceptor,
+};
+
+/// The protocols the HTTP server negotiates, where HTTP/2 is preferred.
+pub const HTTP_PROTOCOLS: &[&[u8]] = &[b"h2", b"http/1.1"];
+
+/// The protocols the gRPC server negotiates, as gRPC is only served over HTTP/2.
+pub const GRPC_PROTOCOLS: &[&[u8]] = &[b"h2"];
+
+/// Creates the acceptor of TLS connections negotiating one of the protocols, if a certificate is configured.
+///
+/// Clients have to present a certificate signed by one of the configured client certificate authorities, if there
+/// are any, and otherwise are not asked for one.
+pub fn acceptor(config: &Config, protocols: &[&[u8]]) -> Result<Option<TlsAcceptor>, TlsError> {
+    let (Some(cert), Some(key)) = (&config.tls_cert, &config.tls_key) else {
+        return Ok(None);
+    };
+
+    let certificates = certificates(cert)?;
+    let key = PrivateKeyDer::from_pem_file(key).map_err(|err| match err {
+        pem::Error::NoItemsFound => TlsError::NoKey(key.clone()),
+        err => TlsError::Read(key.clone(), err.to_string()),
+    })?;
+
+    // the provider is chosen explicitly, as the default depends on the features other crates enable
+    let provider = Arc::new(ring::default_provider());
+    let builder = ServerConfig::builder_with_provider(provider.clone())
+        .with_safe_default_protocol_versions()
+        .map_err(|err| TlsError::Invalid(err.to_string()))?;
+    let builder = match &config.tls_client_ca {
+        Some(client_ca) => {
+            let verifier = client_verifier(client_ca, provider)?;
+            builder.with_client_cert_verifier(verifier)
+        }
+        None => builder.with_no_client_auth(),
+    };
+
+    let mut server = builder
+        .with_single_cert(certificates, key)
+        .map_err(|err| TlsError::Invalid(err.to_string()))?;
+    server.alpn_protocols = protocols.iter().map(|protocol| protocol.to_vec()).collect();
+
+    Ok(Some(TlsAcceptor::from(Arc::new(server))))
+}
+
+/// Completes the TLS handshake of a connection, which fails if it takes longer than the timeout.
+pub async fn accept<S>(
+    acceptor: &TlsAcceptor,
+    stream: S,
+    timeout: Option<Duration>,
+) -> io::Result<TlsStream<S>>
+where
+    S: AsyncRead + AsyncWrite + Unpin,
+{
+    let handshake = acceptor.accept(stream);
+    match timeout {
+        Some(timeout) => time::timeout(timeout, handshake).await?,
+        None => handshake.await,
+    }
+}
+
+/// Reads every certificate of a PEM file, of which there has to be at least one.
+fn certificates(path: &Path) -> Result<Vec<CertificateDer<'static>>, TlsError> {
+    let certificates = CertificateDer::pem_file_iter(path)
+        .and_then(|certificates| certificates.collect::<Result<Vec<_>, _>>())
+        .map_err(|err| TlsError::Read(path.to_path_buf(), err.to_string()))?;
+    if certificates.is_empty() {
+        return Err(TlsError::NoCertificate(path.to_path_buf()));
+    }
+
+    Ok(certificates)
+}
+
+/// Creates the verifier of client certificates, which are signed by one of the certificate authorities of the file.
+fn client_verifier(
+    path: &Path,
+    provider: Arc<CryptoProvider>,
+) -> Result<Arc<dyn ClientCertVerifier>, TlsError> {
+    let mut roots = RootCertStore::empty();
+    for certificate in certificates(path)? {
+        roots
+            .add(certificate)
+            .map_err(|err| TlsError::Invalid(err.to_string()))?;
+    }
+
+    WebPkiClientVerifier::builder_with_provider(Arc::new(roots), provider)
+        .build()
+        .map_err(|err| TlsError::Invalid(err.to_string()))
+}
+
+#[cfg(test)]
+mod acceptor {
+    use super::{acceptor, HTTP_PROTOCOLS};
+    use crate::{config::Config, error::TlsError};
+    use std::{env, fs};
+
+    #[test]
+    fn without_certificate() {
+        let actual = acceptor(&Config::default(), HTTP_PROTOCOLS);
+
+        assert!(matches!(actual, Ok(None)));
+    }
+
+    #[test]
+    fn missing_certificate() {
+        let config = Config {
+            tls_cert: Some(env::temp_dir().join("mozart-missing.crt")),
+            tls_key: Some(env::temp_dir().join("mozart-missing.key")),
+            ..Config::default()
+        };
+
+        let actual = acceptor(&config, HTTP_PROTOCOLS);
+
+        assert!(matches!(actual, Err(TlsError::Read(..))));
+    }
+
+    #[test]
+    fn not_a_certificate() {
+        let cert = env::temp_dir().join("mozart-not-a-certificate.crt");
+        fs::write(&cert, "not a certificate\n").unwrap();
+        let config = Config {
+            tls_cert: Some(cert.clone()),
+            tls_key: Some(cert.clone()),
+            ..Config::default()
+        };
+
+        let actual = acceptor(&config, HTTP_PROTOCOLS);
+
+        fs::remove_file(&cert).unwrap();
+        assert!(matches!(actual, Err(TlsError::NoCertificate(path)) if path == cert));
+    }
+}
@@ -0,0 +1,510 @@
+use crate::{config::Config, error::SimilarityError, fixture, model::Language, problem::Problem};
+use axum::{
+    http::StatusCode,
+    response::{IntoResponse, Response},
+};
+use serde::{Deserialize, Serialize};
+use std::{
+    collections::HashSet,
+    fs,
+    io::ErrorKind,
+    path::PathBuf,
+    time::{SystemTime, UNIX_EPOCH},
+};
+use uuid::Uuid;
+
+/// How many tokens a k-gram has, so matches of fewer tokens, like a common loop header, are ignored as noise.
+const K: usize = 8;
+
+/// How many consecutive k-grams a fingerprint is chosen from, so every match of at least `K + WINDOW - 1` tokens is
+/// found.
+const WINDOW: usize = 4;
+
+/// The directory of fingerprints within the exercise directory, which no exercise id can collide with.
+const FINGERPRINT_DIR: &str = ".fingerprints";
+
+/// The token every identifier which is not a keyword becomes, so renaming variables does not hide a copy.
+const IDENTIFIER: &str = "\0identifier";
+
+/// The token every number becomes.
+const NUMBER: &str = "\0number";
+
+/// The token every string or character literal becomes.
+const LITERAL: &str = "\0literal";
+
+/// Computes the fingerprints of a solution by winnowing the hashes of its k-grams of tokens, like MOSS does.
+///
+/// Comments and whitespace are left out, and every identifier, number, and literal is the same token, so only the
+/// structure of the solution is compared. A solution of fewer than `K` tokens has no fingerprints.
+pub fn fingerprint(language: Language, source: &str) -> Vec<u64> {
+    let tokens = tokens(language, source);
+    let grams: Vec<u64> = tokens
+        .windows(K)
+        .map(|gram| {
+            gram.iter()
+                .fold(FNV_OFFSET, |hash, &token| mix(hash, token))
+        })
+        .collect();
+
+    // the smallest hash of every window is a fingerprint, where the rightmost of equal hashes is taken, so a run of
+    // equal hashes yields a single fingerprint
+    let mut fingerprints = Vec::new();
+    if grams.is_empty() {
+        return fingerprints;
+    }
+    let mut chosen = None;
+    for (start, window) in grams.windows(WINDOW.min(grams.len())).enumerate() {
+        let (offset, &hash) = window
+            .iter()
+            .enumerate()
+            .rev()
+            .min_by_key(|(_, &hash)| hash)
+            .expect("a window is never empty");
+        if chosen != Some(start + offset) {
+            chosen = Some(start + offset);
+            fingerprints.push(hash);
+        }
+    }
+    fingerprints.sort_unstable();
+    fingerprints.dedup();
+
+    fingerprints
+}
+
+const FNV_OFFSET: u64 = 0xcbf2_9ce4_8422_2325;
+const FNV_PRIME: u64 = 0x0100_0000_01b3;
+
+fn mix(hash: u64, value: u64) -> u64 {
+    value.to_le_bytes().iter().fold(hash, |hash, &byte| {
+        (hash ^ u64::from(byte)).wrapping_mul(FNV_PRIME)
+    })
+}
+
+fn hash(token: &str) -> u64 {
+    token.bytes().fold(FNV_OFFSET, |hash, byte| {
+        (hash ^ u64::from(byte)).wrapping_mul(FNV_PRIME)
+    })
+}
+
+/// Splits a solution into the hashes of its tokens, leaving out its comments and whitespace.
+fn tokens(language: Language, source: &str) -> Vec<u64> {
+    let (line_comment, block_comment) = match language {
+        Language::Haskell => ("--", ("{-", "-}")),
+        Language::Python => ("#", ("\"\"\"", "\"\"\"")),
+        Language::Go | Language::C | Language::Java => ("//", ("/*", "*/")),
+        Language::Sql => ("--", ("/*", "*/")),
+    };
+    let keywords = keywords(language);
+
+    let mut tokens = Vec::new();
+    let mut rest = source;
+    while let Some(next) = rest.chars().next() {
+        if next.is_whitespace() {
+            rest = &rest[next.len_utf8()..];
+        } else if rest.starts_with(block_comment.0) {
+            rest = rest[block_comment.0.len()..]
+                .split_once(block_comment.1)
+                .map_or("", |(_, rest)| rest);
+        } else if rest.starts_with(line_comment) {
+            rest = rest.split_once('\n').map_or("", |(_, rest)| rest);
+        } else if next.is_alphabetic() || next == '_' {
+            let end = rest
+                .find(|c: char| !(c.is_alphanumeric() || c == '_' || c == '\''))
+                .unwrap_or(rest.len());
+            // the keywords of sql are case insensitive
+            let word = match language {
+                Language::Sql => rest[..end].to_ascii_lowercase(),
+                _ => rest[..end].to_string(),
+            };
+            tokens.push(hash(match keywords.contains(&word.as_str()) {
+                true => &word,
+                false => IDENTIFIER,
+            }));
+            rest = &rest[end..];
+        } else if next.is_ascii_digit() {
+            let end = rest
+                .find(|c: char| !(c.is_alphanumeric() || c == '_' || c == '.'))
+                .unwrap_or(rest.len());
+            tokens.push(hash(NUMBER));
+            rest = &rest[end..];
+        } else if matches!(next, '"' | '\'' | '`') {
+            tokens.push(hash(LITERAL));
+            rest = literal_end(&rest[1..], next);
+        } else {
+            tokens.push(hash(&rest[..next.len_utf8()]));
+            rest = &rest[next.len_utf8()..];
+        }
+    }
+
+    tokens
+}
+
+/// Skips the rest of a literal opened by the quote, which ends at the next unescaped quote or at the end of the line.
+fn literal_end(literal: &str, quote: char) -> &str {
+    let mut escaped = false;
+    for (index, c) in literal.char_indices() {
+        match c {
+            _ if escaped => escaped = false,
+            '\\' => escaped = true,
+            '\n' => return &literal[index..],
+            c if c == quote => return &literal[index + 1..],
+            _ => {}
+        }
+    }
+
+    ""
+}
+
+/// Gets the keywords of the language, which stay tokens of their own rather than becoming identifiers.
+fn keywords(language: Language) -> &'static [&'static str] {
+    match language {
+        Language::Haskell => &[
+            "case", "class", "data", "deriving", "do", "else", "if", "import", "in", "instance",
+            "let", "module", "newtype", "of", "then", "type", "where",
+        ],
+        Language::Python => &[
+            "and", "as", "break", "class", "continue", "def", "elif", "else", "except", "for",
+            "from", "if", "import", "in", "is", "lambda", "not", "or", "pass", "raise", "return",
+            "try", "while", "with", "yield",
+        ],
+        Language::Go => &[
+            "break",
+            "case",
+            "chan",
+            "const",
+            "continue",
+            "default",
+            "defer",
+            "else",
+            "for",
+            "func",
+            "go",
+            "if",
+            "import",
+            "interface",
+            "map",
+            "package",
+            "range",
+            "return",
+            "select",
+            "struct",
+            "switch",
+            "type",
+            "var",
+        ],
+        Language::C => &[
+            "break", "case", "char", "const", "continue", "default", "do", "double", "else",
+            "enum", "float", "for", "if", "int", "long", "return", "sizeof", "static", "struct",
+            "switch", "typedef", "unsigned", "void", "while",
+        ],
+        Language::Java => &[
+            "break",
+            "case",
+            "catch",
+            "class",
+            "continue",
+            "default",
+            "do",
+            "else",
+            "extends",
+            "final",
+            "for",
+            "if",
+            "implements",
+            "import",
+            "int",
+            "interface",
+            "new",
+            "private",
+            "public",
+            "return",
+            "static",
+            "switch",
+            "this",
+            "throw",
+            "try",
+            "void",
+            "while",
+        ],
+        Language::Sql => &[
+            "and", "as", "by", "case", "delete", "distinct", "else", "end", "exists", "from",
+            "group", "having", "in", "insert", "join", "left", "limit", "not", "on", "or", "order",
+            "select", "set", "then", "union", "update", "when", "where", "with",
+        ],
+    }
+}
+
+/// The fingerprints of a submission, as they are stored.
+#[derive(Serialize, Deserialize)]
+struct Stored {
+    /// When the submission was first fingerprinted, in milliseconds since the unix epoch, which a rejudge keeps.
+    #[serde(rename = "fingerprintedAt")]
+    fingerprinted_at: u64,
+    fingerprints: Vec<u64>,
+}
+
+/// A submission which shares fingerprints with another one.
+#[derive(Serialize, Debug, PartialEq)]
+pub struct Similar {
+    pub submission: Uuid,
+    /// The share of the fingerprints of the compared submission which this one has as well, from 0 to 1.
+    pub similarity: f64,
+    /// How many fingerprints the submissions share.
+    pub matches: usize,
+}
+
+/// The submissions which are most similar to a submission, by their similarity.
+#[derive(Serialize)]
+pub struct SimilarSubmissions {
+    pub submission: Uuid,
+    pub similar: Vec<Similar>,
+}
+
+/// The fingerprints of the submissions to every exercise, which submissions are compared by.
+///
+/// The fingerprints of every exercise are kept in a directory named like the exercise, within a hidden directory of
+/// the exercise directory, as a JSON file named by the job of the submission.
+pub struct Fingerprints {
+    dir: PathBuf,
+}
+
+impl Fingerprints {
+    pub fn new(dir: PathBuf) -> Self {
+        Self { dir }
+    }
+
+    /// Stores the fingerprints of a submission to an exercise, replacing those of an earlier judgment of the same job.
+    pub fn put(
+        &self,
+        exercise: &str,
+        submission: Uuid,
+        fingerprints: Vec<u64>,
+    ) -> Result<(), SimilarityError> {
+        let dir = self.exercise_dir(exercise)?;
+        let path = dir.join(format!("{submission}.json"));
+        let fingerprinted_at = match self.read(&path) {
+            Ok(Some(earlier)) => earlier.fingerprinted_at,
+            _ => now(),
+        };
+        let contents = serde_json::to_vec(&Stored {
+            fingerprinted_at,
+            fingerprints,
+        })
+        .map_err(|err| SimilarityError::Io(err.to_string()))?;
+
+        let temporary = dir.join(format!(".{submission}.tmp"));
+        fs::create_dir_all(&dir)
+            .and_then(|_| fs::write(&temporary, contents))
+            .and_then(|_| fs::rename(&temporary, &path))
+            .map_err(|err| SimilarityError::Io(err.to_string()))
+    }
+
+    /// Gets the submissions to the exercise which were fingerprinted before the given one, by how much of the given
+    /// submission they share, where submissions sharing nothing are left out.
+    ///
+    /// Every submission to the exercise is read, which is meant for exercises of a class rather than of the world.
+    pub fn similar(
+        &self,
+        exercise: &str,
+        submission: Uuid,
+        limit: usize,
+    ) -> Result<Vec<Similar>, SimilarityError> {
+        let dir = self.exercise_dir(exercise)?;
+        let own = self
+            .read(&dir.join(format!("{submission}.json")))?
+            .ok_or(SimilarityError::NotFingerprinted)?;
+        let fingerprints: HashSet<u64> = own.fingerprints.iter().copied().collect();
+
+        let mut similar = Vec::new();
+        for entry in fs::read_dir(&dir).map_err(|err| SimilarityError::Io(err.to_string()))? {
+            let path = entry
+                .map_err(|err| SimilarityError::Io(err.to_string()))?
+                .path();
+            let Some(other) = path
+                .file_stem()
+                .and_then(|stem| stem.to_str())
+                .and_then(|stem| stem.parse::<Uuid>().ok())
+                .filter(|&other| other != submission)
+            else {
+                continue;
+            };
+            // a submission which was removed in the meantime is skipped
+            let Some(stored) = self.read(&path)? else {
+                continue;
+            };
+            if stored.fingerprinted_at > own.fingerprinted_at {
+                continue;
+            }
+
+            let matches = stored
+                .fingerprints
+                .iter()
+                .filter(|fingerprint| fingerprints.contains(fingerprint))
+                .count();
+            if matches > 0 {
+                similar.push(Similar {
+                    submission: other,
+                    similarity: matches as f64 / fingerprints.len() as f64,
+                    matches,
+                });
+            }
+        }
+        similar.sort_by(|a, b| b.similarity.total_cmp(&a.similarity));
+        similar.truncate(limit);
+
+        Ok(similar)
+    }
+
+    fn read(&self, path: &std::path::Path) -> Result<Option<Stored>, SimilarityError> {
+        match fs::read(path) {
+            Ok(contents) => serde_json::from_slice(&contents)
+                .map(Some)
+                .map_err(|err| SimilarityError::Io(format!("corrupt fingerprints: {err}"))),
+            Err(err) if err.kind() == ErrorKind::NotFound => Ok(None),
+            Err(err) => Err(SimilarityError::Io(err.to_string())),
+        }
+    }
+
+    fn exercise_dir(&self, exercise: &str) -> Result<PathBuf, SimilarityError> {
+        match fixture::is_valid_id(exercise) {
+            true => Ok(self.dir.join(exercise)),
+            false => Err(SimilarityError::InvalidExerciseId(exercise.to_string())),
+        }
+    }
+}
+
+impl From<&Config> for Fingerprints {
+    fn from(config: &Config) -> Self {
+        Self::new(config.exercise_dir().join(FINGERPRINT_DIR))
+    }
+}
+
+fn now() -> u64 {
+    SystemTime::now()
+        .duration_since(UNIX_EPOCH)
+        .map_or(0, |now| now.as_millis() as u64)
+}
+
+impl IntoResponse for SimilarityError {
+    fn into_response(self) -> Response {
+        match self {
+            SimilarityError::InvalidExerciseId(_) => Problem::new(
+                StatusCode::BAD_REQUEST,
+                "invalidExerciseId",
+                "an exercise id consists of letters, digits, '-', '_', and '.', and does not start with '.'",
+            )
+            .into_response(),
+            SimilarityError::NotFingerprinted => Problem::new(
+                StatusCode::NOT_FOUND,
+                "notFingerprinted",
+                "the submission has not been fingerprinted as a submission to the exercise",
+            )
+            .into_response(),
+            SimilarityError::Untrusted => Problem::new(
+                StatusCode::FORBIDDEN,
+                "forbidden",
+                "similar submissions are only shown to trusted callers",
+            )
+            .into_response(),
+            SimilarityError::Io(_) => Problem::new(
+                StatusCode::INTERNAL_SERVER_ERROR,
+                "internal",
+                "an internal error occured",
+            )
+            .into_response(),
+        }
+    }
+}
+
+#[cfg(test)]
+mod winnowing {
+    use super::{fingerprint, tokens, Fingerprints};
+    use crate::{error::SimilarityError, model::Language};
+    use std::{env, fs};
+    use uuid::Uuid;
+
+    const ORIGINAL: &str = r#"
+def collatz(n):
+    # counts the steps until n reaches 1
+    steps = 0
+    while n != 1:
+        if n % 2 == 0:
+            n = n // 2
+        else:
+            n = 3 * n + 1
+        steps += 1
+    return steps
+"#;
+
+    const RENAMED: &str = r#"
+def solve(x):
+    count = 0
+    while x != 1:
+        if x % 2 == 0:
+            x = x // 2
+        else:
+            x = 3 * x + 1
+        count += 1
+    return count
+"#;
+
+    const OTHER: &str = r#"
+def solve(xs):
+    return sorted(set(xs), key=lambda x: (-xs.count(x), x))[:3]
+"#;
+
+    #[test]
+    fn ignores_comments_and_names() {
+        assert_eq!(
+            tokens(Language::Python, "x = 1 # one"),
+            tokens(Language::Python, "value = 42")
+        );
+        assert_ne!(
+            tokens(Language::Python, "return x"),
+            tokens(Language::Python, "yield x")
+        );
+        assert_eq!(
+            tokens(Language::Haskell, "f x = \"a -- b\" {- note -}").len(),
+            4
+        );
+    }
+
+    #[test]
+    fn renamed_copies_match() {
+        let original = fingerprint(Language::Python, ORIGINAL);
+
+        assert!(!original.is_empty());
+        assert_eq!(fingerprint(Language::Python, RENAMED), original);
+        assert!(fingerprint(Language::Python, OTHER)
+            .iter()
+            .all(|hash| !original.contains(hash)));
+        assert!(fingerprint(Language::Python, "x = 1").is_empty());
+    }
+
+    #[test]
+    fn similar_submissions() {
+        let dir = env::temp_dir().join(format!("fingerprints-{}", Uuid::new_v4()));
+        let fingerprints = Fingerprints::new(dir.clone());
+        let (original, other, copy) = (Uuid::new_v4(), Uuid::new_v4(), Uuid::new_v4());
+        for (submission, source) in [(original, ORIGINAL), (other, OTHER), (copy, RENAMED)] {
+            fingerprints
+                .put("collatz", submission, fingerprint(Language::Python, source))
+                .expect("the fingerprints should be stored");
+        }
+
+        let similar = fingerprints.similar("collatz", copy, 10).unwrap();
+
+        assert_eq!(similar.len(), 1);
+        assert_eq!(similar[0].submission, original);
+        assert_eq!(similar[0].similarity, 1.0);
+        assert!(matches!(
+            fingerprints.similar("collatz", Uuid::new_v4(), 10),
+            Err(SimilarityError::NotFingerprinted)
+        ));
+        assert!(matches!(
+            fingerprints.similar("../collatz", copy, 10),
+            Err(SimilarityError::InvalidExerciseId(_))
+        ));
+        fs::remove_dir_all(dir).unwrap();
+    }
+}
@@ -0,0 +1,573 @@
+use crate::{
+    archive::Archive,
+    cluster::RedisStore,
+    config::Config,
+    database::{self, SqlStore},
+    error::StoreError,
+    job::JobRecord,
+};
+use std::{
+    collections::{HashMap, HashSet},
+    fs,
+    io::ErrorKind,
+    path::PathBuf,
+    sync::{Arc, Mutex},
+};
+use tracing::warn;
+use uuid::Uuid;
+
+/// A persistent store of finished jobs, so their results outlive a restart of mozart.
+pub trait Store: Send + Sync {
+    /// Persists the record of a job, replacing an earlier record of the same job.
+    fn save(&self, record: &JobRecord) -> Result<(), StoreError>;
+
+    /// Loads the record of the job with the given id, which is `None` if the job was never persisted.
+    fn load(&self, id: Uuid) -> Result<Option<JobRecord>, StoreError>;
+
+    /// Loads the record of every persisted job, skipping the records which cannot be loaded.
+    fn all(&self) -> Result<Vec<JobRecord>, StoreError>;
+
+    /// Loads the record of every persisted job of the batch, without loading those of any other job.
+    fn batch(&self, batch_id: Uuid) -> Result<Vec<JobRecord>, StoreError>;
+
+    /// Loads the record of every persisted job submitted to the exercise by any tenant, without loading those of any
+    /// other job.
+    fn exercise(&self, exercise_id: &str) -> Result<Vec<JobRecord>, StoreError>;
+
+    /// Checks that jobs can be persisted, without persisting any.
+    fn check(&self) -> Result<(), StoreError>;
+
+    /// Removes the record of a job whose retention has passed, archiving its compacted record first if the store has
+    /// an archive, so it can still be loaded.
+    fn retire(&self, record: &JobRecord) -> Result<(), StoreError>;
+}
+
+/// Creates the configured store, if jobs should be persisted at all, which is the Redis server of the cluster if mozart
+/// runs as a replica of one, and otherwise the SQL database or the directory of the store.
+pub fn from_config(config: &Config) -> Option<Arc<dyn Store>> {
+    let database = config
+        .store_url
+        .as_deref()
+        .and_then(database::Address::parse);
+    let local: Box<dyn Store> = match (RedisStore::from_config(config), database) {
+        (Some(shared), _) => Box::new(shared),
+        (None, Some(address)) => Box::new(SqlStore::new(address)),
+        (None, None) => Box::new(FileStore::new(config.store_dir.clone()?)),
+    };
+
+    Some(match Archive::from_config(config) {
+        Some(archive) => Arc::new(ArchivedStore { local, archive }),
+        None => Arc::from(local),
+    })
+}
+
+/// Gets the exercise the job of the record was submitted to, if it was submitted to one.
+pub fn exercise_of(record: &JobRecord) -> Option<&str> {
+    record.submission["exerciseId"].as_str()
+}
+
+/// A store keeping every job as a JSON file in a directory, named by the id of the job.
+pub struct FileStore {
+    dir: PathBuf,
+    /// The ids of the persisted jobs by batch and by exercise, which is built from every record once it is first
+    /// needed, and kept up to date as records are saved and retired.
+    index: Mutex<Option<Index>>,
+}
+
+/// The ids of the jobs of every batch and of every exercise.
+#[derive(Default)]
+struct Index {
+    batches: HashMap<Uuid, HashSet<Uuid>>,
+    exercises: HashMap<String, HashSet<Uuid>>,
+}
+
+impl Index {
+    fn add(&mut self, record: &JobRecord) {
+        if let Some(batch) = &record.batch {
+            self.batches.entry(batch.id).or_default().insert(record.id);
+        }
+        if let Some(exercise_id) = exercise_of(record) {
+            self.exercises
+                .entry(exercise_id.to_string())
+                .or_default()
+                .insert(record.id);
+        }
+    }
+
+    fn remove(&mut self, record: &JobRecord) {
+        if let Some(batch) = &record.batch {
+            if let Some(ids) = self.batches.get_mut(&batch.id) {
+                ids.remove(&record.id);
+                if ids.is_empty() {
+                    self.batches.remove(&batch.id);
+                }
+            }
+        }
+        if let Some(exercise_id) = exercise_of(record) {
+            if let Some(ids) = self.exercises.get_mut(exercise_id) {
+                ids.remove(&record.id);
+                if ids.is_empty() {
+                    self.exercises.remove(exercise_id);
+                }
+            }
+        }
+    }
+}
+
+impl FileStore {
+    pub fn new(dir: PathBuf) -> Self {
+        Self {
+            dir,
+            index: Mutex::default(),
+        }
+    }
+
+    fn path(&self, id: Uuid) -> PathBuf {
+        self.dir.join(format!("{id}.json"))
+    }
+
+    /// Loads the records of the jobs the index has for a batch or an exercise, building the index first if it has not
+    /// been built yet.
+    fn indexed(&self, ids: impl FnOnce(&Index) -> Vec<Uuid>) -> Result<Vec<JobRecord>, StoreError> {
+        let ids = {
+            let mut index = self.index.lock().expect("store index lock poisoned");
+            if index.is_none() {
+                let mut built = Index::default();
+                for record in self.all()? {
+                    built.add(&record);
+                }
+                *index = Some(built);
+            }
+            ids(index.as_ref().expect("the index is built"))
+        };
+
+        Ok(ids
+            .into_iter()
+            .filter_map(|id| self.load_or_skip(id))
+            .collect())
+    }
+
+    /// Loads the record of the job, logging it and skipping it if it cannot be loaded, so a record which is corrupt
+    /// does not keep every other record from being loaded.
+    fn load_or_skip(&self, id: Uuid) -> Option<JobRecord> {
+        self.load(id).unwrap_or_else(|err| {
+            warn!(%err, task = %id, "skipped a persisted job which cannot be loaded");
+            None
+        })
+    }
+}
+
+impl Store for FileStore {
+    fn save(&self, record: &JobRecord) -> Result<(), StoreError> {
+        let json =
+            serde_json::to_vec(record).map_err(|err| StoreError::Corrupt(err.to_string()))?;
+
+        // the record is written to a temporary file first, so a crash never leaves a partial record behind
+        let path = self.path(record.id);
+        let temporary = path.with_extension("json.tmp");
+        fs::create_dir_all(&self.dir)
+            .and_then(|_| fs::write(&temporary, json))
+            .and_then(|_| fs::rename(&temporary, &path))
+            .map_err(|err| StoreError::Io(err.to_string()))?;
+
+        if let Some(index) = self
+            .index
+            .lock()
+            .expect("store index lock poisoned")
+            .as_mut()
+        {
+            index.add(record);
+        }
+
+        Ok(())
+    }
+
+    fn load(&self, id: Uuid) -> Result<Option<JobRecord>, StoreError> {
+        let json = match fs::read(self.path(id)) {
+            Ok(json) => json,
+            Err(err) if err.kind() == ErrorKind::NotFound => return Ok(None),
+            Err(err) => return Err(StoreError::Io(err.to_string())),
+        };
+
+        serde_json::from_slice(&json)
+            .map(Some)
+            .map_err(|err| StoreError::Corrupt(err.to_string()))
+    }
+
+    fn all(&self) -> Result<Vec<JobRecord>, StoreError> {
+        let entries = match fs::read_dir(&self.dir) {
+            Ok(entries) => entries,
+            Err(err) if err.kind() == ErrorKind::NotFound => return Ok(Vec::new()),
+            Err(err) => return Err(StoreError::Io(err.to_string())),
+        };
+
+        // temporary files of records being written are skipped, as are files which are not records at all
+        Ok(entries
+            .flatten()
+            .filter_map(|entry| {
+                let name = entry.file_name();
+                let id = name.to_str()?.strip_suffix(".json")?;
+                Uuid::parse_str(id).ok()
+            })
+            .filter_map(|id| self.load_or_skip(id))
+            .collect())
+    }
+
+    fn batch(&self, batch_id: Uuid) -> Result<Vec<JobRecord>, StoreError> {
+        self.indexed(|index| {
+            index
+                .batches
+                .get(&batch_id)
+                .map(|ids| ids.iter().copied().collect())
+                .unwrap_or_default()
+        })
+    }
+
+    fn exercise(&self, exercise_id: &str) -> Result<Vec<JobRecord>, StoreError> {
+        self.indexed(|index| {
+            index
+                .exercises
+                .get(exercise_id)
+                .map(|ids| ids.iter().copied().collect())
+                .unwrap_or_default()
+        })
+    }
+
+    fn check(&self) -> Result<(), StoreError> {
+        // a probe is not named like a record, so it is never loaded even if it is left behind
+        let probe = self.dir.join(format!(".ready-{}", Uuid::new_v4()));
+        fs::create_dir_all(&self.dir)
+            .and_then(|_| fs::write(&probe, []))
+            .and_then(|_| fs::remove_file(&probe))
+            .map_err(|err| StoreError::Io(err.to_string()))
+    }
+
+    fn retire(&self, record: &JobRecord) -> Result<(), StoreError> {
+        match fs::remove_file(self.path(record.id)) {
+            Err(err) if err.kind() != ErrorKind::NotFound => {
+                return Err(StoreError::Io(err.to_string()))
+            }
+            _ => {}
+        }
+
+        if let Some(index) = self
+            .index
+            .lock()
+            .expect("store index lock poisoned")
+            .as_mut()
+        {
+            index.remove(record);
+        }
+
+        Ok(())
+    }
+}
+
+/// A store keeping jobs in another store until their retention has passed, and in an [`Archive`] afterwards.
+///
+/// Jobs are loaded from the archive if they are not in the other store, so archived jobs are loaded like any other,
+/// while only the jobs in the other store are loaded as every job.
+pub struct ArchivedStore {
+    local: Box<dyn Store>,
+    archive: Archive,
+}
+
+impl Store for ArchivedStore {
+    fn save(&self, record: &JobRecord) -> Result<(), StoreError> {
+        self.local.save(record)
+    }
+
+    fn load(&self, id: Uuid) -> Result<Option<JobRecord>, StoreError> {
+        if let Some(record) = self.local.load(id)? {
+            return Ok(Some(record));
+        }
+
+        match self.archive.get(id)? {
+            Some(json) => serde_json::from_slice(&json)
+                .map(Some)
+                .map_err(|err| StoreError::Corrupt(err.to_string())),
+            None => Ok(None),
+        }
+    }
+
+    fn all(&self) -> Result<Vec<JobRecord>, StoreError> {
+        self.local.all()
+    }
+
+    fn batch(&self, batch_id: Uuid) -> Result<Vec<JobRecord>, StoreError> {
+        self.local.batch(batch_id)
+    }
+
+    fn exercise(&self, exercise_id: &str) -> Result<Vec<JobRecord>, StoreError> {
+        self.local.exercise(exercise_id)
+    }
+
+    fn check(&self) -> Result<(), StoreError> {
+        self.local.check()
+    }
+
+    fn retire(&self, record: &JobRecord) -> Result<(), StoreError> {
+        let mut compacted = record.clone();
+        compacted.compact();
+        let json =
+            serde_json::to_vec(&compacted).map_err(|err| StoreError::Corrupt(err.to_string()))?;
+
+        // the record is only removed once it is archived, so a failed upload is retried by the next sweep
+        self.archive.put(record.id, json)?;
+        self.local.retire(record)
+    }
+}
+
+#[cfg(test)]
+mod file_store {
+    use super::{FileStore, Store};
+    use crate::{
+        job::{JobStatus, JobStore},
+        model::Submission,
+        response::{Invalid, SubmitResponse},
+    };
+    use std::{env, fs, sync::Arc};
+    use uuid::Uuid;
+
+    #[test]
+    fn unknown_job() {
+        let store = FileStore::new(env::temp_dir().join(format!("store-{}", Uuid::new_v4())));
+
+        assert!(matches!(store.load(Uuid::new_v4()), Ok(None)));
+    }
+
+    #[test]
+    fn outlives_job_store() {
+        let dir = env::temp_dir().join(format!("store-{}", Uuid::new_v4()));
+        let submission: Submission =
+            serde_json::from_str(r#"{"solution": "solution = 5", "testCases": []}"#).unwrap();
+
+        let jobs = JobStore::new(Some(Arc::new(FileStore::new(dir.clone()))));
+        let id = jobs.create(None, 0, &submission);
+        jobs.set_status(id, JobStatus::Running);
+        jobs.finish(
+            id,
+            SubmitResponse::InvalidSubmission(Invalid::new("noTestCases", "invalid")),
+        );
+        let restarted = JobStore::new(Some(Arc::new(FileStore::new(dir.clone()))));
+        let actual = restarted.record(id).expect("the job should be persisted");
+
+        assert_eq!(actual.status, JobStatus::Finished);
+        assert_eq!(actual.submission["solution"], "solution = 5");
+        assert!(actual.started_at.is_some() && actual.finished_at.is_some());
+        assert!(matches!(
+            actual.result,
+            Some(SubmitResponse::InvalidSubmission(invalid)) if invalid.detail == "invalid"
+        ));
+        assert_eq!(
+            restarted.subscribe(id).map(|(history, _)| history.len()),
+            Some(2)
+        );
+        fs::remove_dir_all(dir).expect("failed to remove store");
+    }
+
+    #[test]
+    fn retired_job() {
+        let dir = env::temp_dir().join(format!("store-{}", Uuid::new_v4()));
+        let submission: Submission =
+            serde_json::from_str(r#"{"solution": "solution = 5", "testCases": []}"#).unwrap();
+        let store = Arc::new(FileStore::new(dir.clone()));
+        let jobs = JobStore::new(Some(store.clone()));
+        let id = jobs.create(None, 0, &submission);
+        jobs.finish(id, SubmitResponse::Internal);
+
+        let record = store
+            .load(id)
+            .unwrap()
+            .expect("the job should be persisted");
+        store.retire(&record).expect("the job should be retired");
+
+        assert!(matches!(store.load(id), Ok(None)));
+        assert!(store.retire(&record).is_ok());
+        fs::remove_dir_all(dir).expect("failed to remove store");
+    }
+
+    #[test]
+    fn skips_corrupt_records() {
+        let dir = env::temp_dir().join(format!("store-{}", Uuid::new_v4()));
+        let submission: Submission =
+            serde_json::from_str(r#"{"solution": "solution = 5", "testCases": []}"#).unwrap();
+        let jobs = JobStore::new(Some(Arc::new(FileStore::new(dir.clone()))));
+        let id = jobs.create(None, 0, &submission);
+        jobs.finish(id, SubmitResponse::Internal);
+        fs::write(
+            dir.join(format!("{}.json", Uuid::new_v4())),
+            "{ not a record",
+        )
+        .unwrap();
+
+        let actual = FileStore::new(dir.clone()).all();
+        fs::remove_dir_all(dir).expect("failed to remove store");
+
+        let ids: Vec<Uuid> = actual.unwrap().iter().map(|record| record.id).collect();
+        assert_eq!(ids, [id]);
+    }
+
+    #[test]
+    fn indexes_exercises() {
+        let dir = env::temp_dir().join(format!("store-{}", Uuid::new_v4()));
+        let submission = |exercise: &str| -> Submission {
+            serde_json::from_str(&format!(
+                r#"{{"solution": "solution = 5", "testCases": [], "exerciseId": "{exercise}"}}"#
+            ))
+            .unwrap()
+        };
+        let store = Arc::new(FileStore::new(dir.clone()));
+        let jobs = JobStore::new(Some(store.clone()));
+        let sum = jobs.create(None, 0, &submission("sum"));
+        let other = jobs.create(None, 0, &submission("fibonacci"));
+        jobs.finish(sum, SubmitResponse::Internal);
+        jobs.finish(other, SubmitResponse::Internal);
+
+        let restarted = FileStore::new(dir.clone());
+        let before = restarted.exercise("sum").unwrap();
+        let again = jobs.create(None, 0, &submission("sum"));
+        jobs.finish(again, SubmitResponse::Internal);
+        let saved = store.exercise("sum").unwrap();
+        let record = store.load(sum).unwrap().unwrap();
+        store.retire(&record).unwrap();
+        let retired = store.exercise("sum").unwrap();
+        fs::remove_dir_all(dir).expect("failed to remove store");
+
+        assert_eq!(
+            before.iter().map(|record| record.id).collect::<Vec<_>>(),
+            [sum]
+        );
+        assert_eq!(saved.len(), 2);
+        assert_eq!(
+            retired.iter().map(|record| record.id).collect::<Vec<_>>(),
+            [again]
+        );
+        assert!(restarted.exercise("missing").unwrap().is_empty());
+    }
+}
+
+#[cfg(test)]
+mod archived_store {
+    use super::from_config;
+    use crate::{
+        config::Config,
+        job::JobStore,
+        model::{Submission, SubmissionResult, TestCaseFailureReason, TestCaseResult, TestResult},
+        response::SubmitResponse,
+    };
+    use std::{
+        collections::{BTreeMap, HashMap},
+        env, fs,
+        io::{BufRead, BufReader, Read, Write},
+        net::TcpListener,
+        sync::{Arc, Mutex},
+        thread,
+        time::Duration,
+    };
+    use uuid::Uuid;
+
+    /// Serves a bucket of an S3-compatible object storage which keeps its objects in memory, and requires every
+    /// request to be signed.
+    fn bucket() -> String {
+        let listener = TcpListener::bind("127.0.0.1:0").unwrap();
+        let address = listener.local_addr().unwrap();
+        let objects = Arc::new(Mutex::new(HashMap::<String, Vec<u8>>::new()));
+        thread::spawn(move || {
+            for stream in listener.incoming().flatten() {
+                let mut reader = BufReader::new(stream.try_clone().unwrap());
+                let mut request_line = String::new();
+                reader.read_line(&mut request_line).unwrap();
+                let (mut length, mut signed) = (0, false);
+                loop {
+                    let mut header = String::new();
+                    reader.read_line(&mut header).unwrap();
+                    let header = header.trim_end().to_ascii_lowercase();
+                    if header.is_empty() {
+                        break;
+                    }
+                    if let Some(value) = header.strip_prefix("content-length: ") {
+                        length = value.parse().unwrap();
+                    }
+                    signed |= header.starts_with("authorization: aws4-hmac-sha256 credential=");
+                }
+                let mut body = vec![0; length];
+                reader.read_exact(&mut body).unwrap();
+
+                let mut parts = request_line.split(' ');
+                let (method, path) = (parts.next().unwrap(), parts.next().unwrap().to_string());
+                let mut objects = objects.lock().unwrap();
+                let (status, body) = match (method, objects.get(&path)) {
+                    _ if !signed => ("403 Forbidden", Vec::new()),
+                    ("PUT", _) => {
+                        objects.insert(path, body);
+                        ("200 OK", Vec::new())
+                    }
+                    ("GET", Some(object)) => ("200 OK", object.clone()),
+                    _ => ("404 Not Found", Vec::new()),
+                };
+                let mut stream = stream;
+                let _ = write!(
+                    stream,
+                    "HTTP/1.1 {status}\r\ncontent-length: {}\r\nconnection: close\r\n\r\n",
+                    body.len()
+                );
+                let _ = stream.write_all(&body);
+            }
+        });
+
+        format!("http://{address}/results")
+    }
+
+    #[test]
+    fn loads_archived_jobs() {
+        let dir = env::temp_dir().join(format!("store-{}", Uuid::new_v4()));
+        let config = Config {
+            store_dir: Some(dir.clone()),
+            result_retention: 1,
+            archive_url: Some(bucket()),
+            archive_access_key: Some(String::from("mozart")),
+            archive_secret_key: Some(String::from("secret")),
+            ..Config::default()
+        };
+        let store = from_config(&config).expect("the store should be configured");
+        let jobs = JobStore::new(Some(store.clone()));
+        let submission: Submission =
+            serde_json::from_str(r#"{"solution": "solution = 5", "testCases": []}"#).unwrap();
+        let id = jobs.create(None, 0, &submission);
+        jobs.finish(
+            id,
+            SubmitResponse::Checked(SubmissionResult::checked(
+                String::new(),
+                Box::new([TestCaseResult {
+                    id: 0,
+                    name: None,
+                    test_result: TestResult::Failure(TestCaseFailureReason::RuntimeError),
+                    hidden: false,
+                    stderr: String::from("exception"),
+                    runtime: 5,
+                    memory: None,
+                    user_time: None,
+                    system_time: None,
+                    cause: None,
+                    benchmark: None,
+                    attachments: BTreeMap::new(),
+                }]),
+            )),
+        );
+
+        let retired = jobs.retire(Duration::ZERO);
+        let actual = jobs.record(id).expect("the job should be archived");
+
+        assert_eq!(retired, [id]);
+        assert!(!dir.join(format!("{id}.json")).exists());
+        assert!(actual.archived_at.is_some());
+        assert!(actual.progress.is_empty());
+        let Some(SubmitResponse::Checked(result)) = actual.result else {
+            panic!("the archived job should have its result");
+        };
+        assert_eq!(result.test_case_results[0].stderr, "");
+        assert!(matches!(store.load(Uuid::new_v4()), Ok(None)));
+        fs::remove_dir_all(dir).expect("failed to remove store");
+    }
+}
@@ -0,0 +1,355 @@
+use crate::config::Config;
+use reqwest::{header, Client};
+use serde_json::{json, Value};
+use std::{
+    fmt::{self, Write},
+    sync::mpsc::{self, Receiver, RecvTimeoutError, SyncSender},
+    thread,
+    time::{Duration, Instant, SystemTime, UNIX_EPOCH},
+};
+use tracing::{
+    field::{Field, Visit},
+    span::{Attributes, Id, Record},
+    warn, Subscriber,
+};
+use tracing_subscriber::{layer::Context, registry::LookupSpan, Layer};
+use uuid::Uuid;
+
+/// The header carrying the trace context of a request in the format of W3C trace context, which the spans of the
+/// request continue.
+pub const TRACEPARENT_HEADER: &str = "traceparent";
+
+/// The field of a span which continues the trace context it is recorded with, rather than starting a new trace.
+pub const TRACEPARENT_FIELD: &str = "traceparent";
+
+/// How often finished spans are exported, unless a whole batch finished before.
+const EXPORT_INTERVAL: Duration = Duration::from_secs(5);
+
+/// The most spans which are exported at once.
+const BATCH_SIZE: usize = 512;
+
+/// The most finished spans which wait to be exported, beyond which spans are dropped rather than holding up judging.
+const QUEUE_SIZE: usize = 4096;
+
+/// How long a single export may take.
+const TIMEOUT: Duration = Duration::from_secs(10);
+
+/// The kinds of spans in OTLP, where the outermost span of mozart handles a request of another service.
+const KIND_INTERNAL: u8 = 1;
+const KIND_SERVER: u8 = 2;
+
+/// A layer exporting every span to an OpenTelemetry collector, which receives them over OTLP/HTTP in its JSON encoding.
+///
+/// Finished spans are batched up by a thread of their own, which exports them every [`EXPORT_INTERVAL`], so exporting
+/// never holds up a request, and a collector which is down only loses the spans.
+pub struct Exporter {
+    spans: SyncSender<Value>,
+}
+
+/// Everything known about a span which has not finished yet, which is kept in the extensions of the span.
+struct SpanData {
+    trace_id: [u8; 16],
+    span_id: [u8; 8],
+    parent_id: Option<[u8; 8]>,
+    /// Whether the span has a parent within mozart, rather than none or one in the service which sent the request.
+    local_parent: bool,
+    /// When the span was created, in nanoseconds since the unix epoch.
+    start: u128,
+    attributes: Vec<Value>,
+}
+
+impl Exporter {
+    /// Creates the configured exporter, if spans should be exported at all.
+    pub fn from_config(config: &Config) -> Option<Self> {
+        let endpoint = format!(
+            "{}/v1/traces",
+            config.otlp_endpoint.as_ref()?.trim_end_matches('/')
+        );
+        let (spans, receiver) = mpsc::sync_channel(QUEUE_SIZE);
+        thread::spawn(move || export(&endpoint, receiver));
+
+        Some(Self { spans })
+    }
+}
+
+impl<S> Layer<S> for Exporter
+where
+    S: Subscriber + for<'a> LookupSpan<'a>,
+{
+    fn on_new_span(&self, attributes: &Attributes<'_>, id: &Id, ctx: Context<'_, S>) {
+        let Some(span) = ctx.span(id) else {
+            return;
+        };
+        let parent = span.parent().and_then(|parent| {
+            let extensions = parent.extensions();
+            let parent = extensions.get::<SpanData>()?;
+            Some((parent.trace_id, parent.span_id))
+        });
+
+        let mut data = SpanData {
+            trace_id: parent.map_or_else(random::<16>, |(trace_id, _)| trace_id),
+            span_id: random::<8>(),
+            parent_id: parent.map(|(_, span_id)| span_id),
+            local_parent: parent.is_some(),
+            start: now(),
+            attributes: Vec::new(),
+        };
+        attributes.record(&mut data);
+        span.extensions_mut().insert(data);
+    }
+
+    fn on_record(&self, id: &Id, values: &Record<'_>, ctx: Context<'_, S>) {
+        if let Some(span) = ctx.span(id) {
+            if let Some(data) = span.extensions_mut().get_mut::<SpanData>() {
+                values.record(data);
+            }
+        }
+    }
+
+    fn on_close(&self, id: Id, ctx: Context<'_, S>) {
+        let Some(span) = ctx.span(&id) else {
+            return;
+        };
+        let Some(data) = span.extensions_mut().remove::<SpanData>() else {
+            return;
+        };
+
+        let mut exported = json!({
+            "traceId": hex(&data.trace_id),
+            "spanId": hex(&data.span_id),
+            "name": span.name(),
+            "kind": if data.local_parent { KIND_INTERNAL } else { KIND_SERVER },
+            "startTimeUnixNano": data.start.to_string(),
+            "endTimeUnixNano": now().to_string(),
+            "attributes": data.attributes,
+        });
+        if let Some(parent_id) = data.parent_id {
+            exported["parentSpanId"] = hex(&parent_id).into();
+        }
+        // the queue is only full if the collector cannot keep up, in which case the span is dropped
+        let _ = self.spans.try_send(exported);
+    }
+}
+
+impl SpanData {
+    fn attribute(&mut self, field: &Field, value: Value) {
+        self.attributes
+            .push(json!({ "key": field.name(), "value": value }));
+    }
+}
+
+impl Visit for SpanData {
+    fn record_f64(&mut self, field: &Field, value: f64) {
+        self.attribute(field, json!({ "doubleValue": value }));
+    }
+
+    fn record_i64(&mut self, field: &Field, value: i64) {
+        self.attribute(field, json!({ "intValue": value.to_string() }));
+    }
+
+    fn record_u64(&mut self, field: &Field, value: u64) {
+        self.attribute(field, json!({ "intValue": value.to_string() }));
+    }
+
+    fn record_bool(&mut self, field: &Field, value: bool) {
+        self.attribute(field, json!({ "boolValue": value }));
+    }
+
+    fn record_str(&mut self, field: &Field, value: &str) {
+        if field.name() == TRACEPARENT_FIELD {
+            // a trace context which cannot be parsed is ignored, like the standard demands
+            if let Some((trace_id, parent_id)) = parse_traceparent(value) {
+                self.trace_id = trace_id;
+                self.parent_id = Some(parent_id);
+            }
+            return;
+        }
+        self.attribute(field, json!({ "stringValue": value }));
+    }
+
+    fn record_debug(&mut self, field: &Field, value: &dyn fmt::Debug) {
+        self.record_str(field, &format!("{value:?}"));
+    }
+}
+
+/// Exports the finished spans in batches until the exporter is dropped.
+fn export(endpoint: &str, spans: Receiver<Value>) {
+    // the thread is never part of the runtime of the server, so it can block on a runtime of its own
+    let Ok(runtime) = tokio::runtime::Builder::new_current_thread()
+        .enable_all()
+        .build()
+    else {
+        return;
+    };
+    let Ok(client) = Client::builder().timeout(TIMEOUT).build() else {
+        return;
+    };
+
+    let mut batch = Vec::new();
+    let mut exported = Instant::now();
+    loop {
+        let disconnected = match spans.recv_timeout(EXPORT_INTERVAL) {
+            Ok(span) => {
+                batch.push(span);
+                false
+            }
+            Err(RecvTimeoutError::Timeout) => false,
+            Err(RecvTimeoutError::Disconnected) => true,
+        };
+        let due = batch.len() >= BATCH_SIZE || exported.elapsed() >= EXPORT_INTERVAL;
+        if !batch.is_empty() && (due || disconnected) {
+            let body = request(std::mem::take(&mut batch));
+            let sent = runtime.block_on(
+                client
+                    .post(endpoint)
+                    .header(header::CONTENT_TYPE, "application/json")
+                    .body(body.to_string())
+                    .send(),
+            );
+            match sent.and_then(|response| response.error_for_status()) {
+                Ok(_) => {}
+                Err(err) => warn!(%err, "failed to export spans"),
+            }
+            exported = Instant::now();
+        }
+        if disconnected {
+            return;
+        }
+    }
+}
+
+/// Creates the body of an export of the spans, all of which belong to the same service.
+fn request(spans: Vec<Value>) -> Value {
+    json!({
+        "resourceSpans": [{
+            "resource": {
+                "attributes": [
+                    { "key": "service.name", "value": { "stringValue": "mozart" } },
+                    { "key": "service.version", "value": { "stringValue": env!("CARGO_PKG_VERSION") } },
+                ],
+            },
+            "scopeSpans": [{
+                "scope": { "name": "mozart" },
+                "spans": spans,
+            }],
+        }],
+    })
+}
+
+/// Parses the trace id and parent span id of a `traceparent` header of version 00, neither of which may be all zeroes.
+fn parse_traceparent(value: &str) -> Option<([u8; 16], [u8; 8])> {
+    let mut parts = value.trim().split('-');
+    let (version, trace_id, parent_id, flags) =
+        (parts.next()?, parts.next()?, parts.next()?, parts.next()?);
+    if version != "00" || parts.next().is_some() || flags.len() != 2 {
+        return None;
+    }
+
+    let trace_id = unhex::<16>(trace_id)?;
+    let parent_id = unhex::<8>(parent_id)?;
+    match trace_id.iter().all(|byte| *byte == 0) || parent_id.iter().all(|byte| *byte == 0) {
+        true => None,
+        false => Some((trace_id, parent_id)),
+    }
+}
+
+fn unhex<const N: usize>(hex: &str) -> Option<[u8; N]> {
+    if hex.len() != 2 * N
+        || !hex
+            .bytes()
+            .all(|byte| matches!(byte, b'0'..=b'9' | b'a'..=b'f'))
+    {
+        return None;
+    }
+
+    let mut bytes = [0; N];
+    for (index, byte) in bytes.iter_mut().enumerate() {
+        *byte = u8::from_str_radix(&hex[2 * index..2 * index + 2], 16).ok()?;
+    }
+    Some(bytes)
+}
+
+fn hex(bytes: &[u8]) -> String {
+    bytes.iter().fold(String::new(), |mut hex, byte| {
+        let _ = write!(hex, "{byte:02x}");
+        hex
+    })
+}
+
+/// Generates a random id, which is never all zeroes as uuids of version 4 have fixed bits.
+fn random<const N: usize>() -> [u8; N] {
+    let uuid = Uuid::new_v4().into_bytes();
+    let mut id = [0; N];
+    // the variant of a uuid is in its ninth byte, so even its last eight bytes are never all zeroes
+    id.copy_from_slice(&uuid[16 - N..]);
+    id
+}
+
+/// Gets the current time in nanoseconds since the unix epoch.
+fn now() -> u128 {
+    SystemTime::now()
+        .duration_since(UNIX_EPOCH)
+        .map_or(0, |since| since.as_nanos())
+}
+
+#[cfg(test)]
+mod traces {
+    use super::{parse_traceparent, Exporter};
+    use std::sync::mpsc;
+    use tracing::info_span;
+    use tracing_subscriber::layer::SubscriberExt;
+
+    #[test]
+    fn parses_traceparent() {
+        let actual = parse_traceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01");
+
+        assert_eq!(
+            actual,
+            Some((
+                [
+                    0x4b, 0xf9, 0x2f, 0x35, 0x77, 0xb3, 0x4d, 0xa6, 0xa3, 0xce, 0x92, 0x9d, 0x0e,
+                    0x0e, 0x47, 0x36
+                ],
+                [0x00, 0xf0, 0x67, 0xaa, 0x0b, 0xa9, 0x02, 0xb7]
+            ))
+        );
+        assert_eq!(
+            parse_traceparent("00-00000000000000000000000000000000-00f067aa0ba902b7-01"),
+            None
+        );
+        assert_eq!(
+            parse_traceparent("01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"),
+            None
+        );
+        assert_eq!(parse_traceparent("00-4BF92F35-00f067aa0ba902b7-01"), None);
+    }
+
+    #[test]
+    fn continues_trace() {
+        let (spans, receiver) = mpsc::sync_channel(8);
+        let subscriber = tracing_subscriber::registry().with(Exporter { spans });
+
+        tracing::subscriber::with_default(subscriber, || {
+            let request = info_span!("request", traceparent = tracing::field::Empty);
+            request.record(
+                "traceparent",
+                "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+            );
+            let _request = request.entered();
+            let _judgment = info_span!("judgment", task = "123").entered();
+        });
+
+        let judgment = receiver.try_recv().expect("the inner span finishes first");
+        let request = receiver.try_recv().expect("the outer span finishes last");
+        assert_eq!(request["traceId"], "4bf92f3577b34da6a3ce929d0e0e4736");
+        assert_eq!(request["parentSpanId"], "00f067aa0ba902b7");
+        assert_eq!(request["kind"], 2);
+        assert_eq!(judgment["traceId"], request["traceId"]);
+        assert_eq!(judgment["parentSpanId"], request["spanId"]);
+        assert_eq!(judgment["kind"], 1);
+        assert_eq!(
+            judgment["attributes"][0],
+            serde_json::json!({ "key": "task", "value": { "stringValue": "123" } })
+        );
+    }
+}
@@ -0,0 +1,505 @@
+use crate::{
+    auth,
+    config::{Config, TenantConfig},
+    exercise::Exercises,
+    fixture::Fixtures,
+    job::{JobRecord, JobStore},
+    metrics::header,
+    response::{Quota, SubmitResponse, TaskResponse},
+};
+use axum::{
+    extract::{Path, Request, State},
+    http::header::AUTHORIZATION,
+    middleware::Next,
+    response::{IntoResponse, Response},
+    Extension,
+};
+use serde::Deserialize;
+use std::{
+    fmt::Write,
+    fs,
+    path::Path as FsPath,
+    sync::{
+        atomic::{AtomicU64, Ordering},
+        Arc, Mutex, RwLock,
+    },
+    time::{SystemTime, UNIX_EPOCH},
+};
+use tokio::sync::{OwnedSemaphorePermit, Semaphore};
+use uuid::Uuid;
+
+/// The quotas of a tenant, by their label in the metrics.
+const QUOTAS: [(Quota, &str); 3] = [
+    (Quota::Jobs, "jobs"),
+    (Quota::CpuTime, "cpuTime"),
+    (Quota::Storage, "storage"),
+];
+
+/// The tenants sharing mozart, whose requests are told apart by their bearer tokens.
+///
+/// Requests which are not made with the token of a tenant belong to no tenant, and use the directories and limits of
+/// mozart itself, without any quotas.
+pub struct Tenants {
+    untenanted: RwLock<Tenant>,
+    namespaces: Vec<Arc<Namespace>>,
+}
+
+/// Everything which is kept apart for a tenant, along with how much of its quotas it used.
+struct Namespace {
+    name: String,
+    tokens: Vec<String>,
+    quotas: TenantConfig,
+    config: RwLock<Arc<Config>>,
+    fixtures: Arc<Fixtures>,
+    exercises: Arc<Exercises>,
+    jobs: Arc<Semaphore>,
+    /// The day since the unix epoch, and the CPU time in milliseconds the tenant took on it.
+    today: Mutex<(u64, u64)>,
+    submissions: AtomicU64,
+    cpu_millis: AtomicU64,
+    rejections: [AtomicU64; QUOTAS.len()],
+}
+
+/// The tenant a request belongs to, along with the config, fixtures, and exercises it is judged with.
+#[derive(Clone)]
+pub struct Tenant {
+    namespace: Option<Arc<Namespace>>,
+    pub config: Arc<Config>,
+    pub fixtures: Arc<Fixtures>,
+    pub exercises: Arc<Exercises>,
+}
+
+/// A place among the submissions a tenant may have at once, which is given up once it is dropped.
+pub struct TenantPermit {
+    _permit: Option<OwnedSemaphorePermit>,
+}
+
+impl Tenants {
+    /// Creates the configured tenants, where requests of no tenant use the given config, fixtures, and exercises.
+    pub fn new(config: Arc<Config>, fixtures: Arc<Fixtures>, exercises: Arc<Exercises>) -> Self {
+        let mut namespaces: Vec<Arc<Namespace>> = config
+            .tenants
+            .iter()
+            .map(|(name, quotas)| {
+                let tenant_config = config.tenant(name);
+
+                Arc::new(Namespace {
+                    name: name.clone(),
+                    tokens: quotas.tokens.clone(),
+                    quotas: quotas.clone(),
+                    fixtures: Arc::new(Fixtures::from(&tenant_config)),
+                    exercises: Arc::new(Exercises::from(&tenant_config)),
+                    config: RwLock::new(Arc::new(tenant_config)),
+                    jobs: Arc::new(Semaphore::new(match quotas.max_jobs {
+                        0 => Semaphore::MAX_PERMITS,
+                        max_jobs => max_jobs,
+                    })),
+                    today: Mutex::default(),
+                    submissions: AtomicU64::new(0),
+                    cpu_millis: AtomicU64::new(0),
+                    rejections: [const { AtomicU64::new(0) }; QUOTAS.len()],
+                })
+            })
+            .collect();
+        namespaces.sort_by(|a, b| a.name.cmp(&b.name));
+
+        Self {
+            untenanted: RwLock::new(Tenant {
+                namespace: None,
+                config,
+                fixtures,
+                exercises,
+            }),
+            namespaces,
+        }
+    }
+
+    /// Identifies the tenant of a request by the value of its `Authorization` header.
+    pub fn identify(&self, authorization: Option<&str>) -> Tenant {
+        let Some(token) = authorization.and_then(auth::bearer) else {
+            return self.untenanted();
+        };
+
+        // every token is compared, so the time taken does not reveal which tokens exist
+        let namespace = self.namespaces.iter().fold(None, |found, namespace| {
+            let matches = namespace.tokens.iter().fold(false, |matches, candidate| {
+                auth::constant_time_eq(candidate.as_bytes(), token.as_bytes()) | matches
+            });
+            found.or(matches.then_some(namespace))
+        });
+
+        self.tenant(namespace)
+    }
+
+    /// Gets the tenant with the given name, like the tenant a job belongs to, where an unknown tenant is no tenant.
+    pub fn named(&self, name: Option<&str>) -> Tenant {
+        let namespace = self
+            .namespaces
+            .iter()
+            .find(|namespace| Some(namespace.name.as_str()) == name);
+
+        self.tenant(namespace)
+    }
+
+    fn tenant(&self, namespace: Option<&Arc<Namespace>>) -> Tenant {
+        match namespace {
+            Some(namespace) => Tenant {
+                namespace: Some(namespace.clone()),
+                config: namespace
+                    .config
+                    .read()
+                    .expect("tenant lock should not be poisoned")
+                    .clone(),
+                fixtures: namespace.fixtures.clone(),
+                exercises: namespace.exercises.clone(),
+            },
+            None => self.untenanted(),
+        }
+    }
+
+    fn untenanted(&self) -> Tenant {
+        self.untenanted
+            .read()
+            .expect("tenant lock should not be poisoned")
+            .clone()
+    }
+
+    /// Judges the requests of every tenant with the settings of a reloaded config from now on, while the jobs which
+    /// were accepted before keep the config they were accepted with.
+    pub fn reconfigure(&self, config: Arc<Config>) {
+        for namespace in &self.namespaces {
+            *namespace
+                .config
+                .write()
+                .expect("tenant lock should not be poisoned") =
+                Arc::new(config.tenant(&namespace.name));
+        }
+        self.untenanted
+            .write()
+            .expect("tenant lock should not be poisoned")
+            .config = config;
+    }
+
+    /// Renders the usage of every tenant in the Prometheus text format, labelled by the name of the tenant.
+    pub fn render(&self, out: &mut String) {
+        if self.namespaces.is_empty() {
+            return;
+        }
+
+        header(
+            out,
+            "mozart_tenant_submissions_total",
+            "The number of submissions accepted by tenant.",
+            "counter",
+        );
+        for namespace in &self.namespaces {
+            let count = namespace.submissions.load(Ordering::Relaxed);
+            let _ = writeln!(
+                out,
+                "mozart_tenant_submissions_total{{tenant=\"{}\"}} {count}",
+                namespace.name
+            );
+        }
+
+        header(
+            out,
+            "mozart_tenant_cpu_seconds_total",
+            "The CPU time test cases took by tenant.",
+            "counter",
+        );
+        for namespace in &self.namespaces {
+            let seconds = namespace.cpu_millis.load(Ordering::Relaxed) as f64 / 1000.0;
+            let _ = writeln!(
+                out,
+                "mozart_tenant_cpu_seconds_total{{tenant=\"{}\"}} {seconds}",
+                namespace.name
+            );
+        }
+
+        header(
+            out,
+            "mozart_tenant_quota_rejections_total",
+            "The number of submissions and uploads rejected by tenant and quota.",
+            "counter",
+        );
+        for namespace in &self.namespaces {
+            for ((_, quota), count) in QUOTAS.iter().zip(&namespace.rejections) {
+                let count = count.load(Ordering::Relaxed);
+                let _ = writeln!(
+                    out,
+                    "mozart_tenant_quota_rejections_total{{tenant=\"{}\",quota=\"{quota}\"}} {count}",
+                    namespace.name
+                );
+            }
+        }
+
+        header(
+            out,
+            "mozart_tenant_active_jobs",
+            "The number of submissions queued or being checked by tenant.",
+            "gauge",
+        );
+        for namespace in &self.namespaces {
+            let _ = writeln!(
+                out,
+                "mozart_tenant_active_jobs{{tenant=\"{}\"}} {}",
+                namespace.name,
+                namespace.active_jobs()
+            );
+        }
+    }
+}
+
+impl Namespace {
+    fn active_jobs(&self) -> usize {
+        match self.quotas.max_jobs {
+            0 => Semaphore::MAX_PERMITS - self.jobs.available_permits(),
+            max_jobs => max_jobs - self.jobs.available_permits(),
+        }
+    }
+
+    fn reject(&self, quota: Quota) -> Quota {
+        let index = QUOTAS
+            .iter()
+            .position(|(other, _)| *other == quota)
+            .expect("every quota has a counter");
+        self.rejections[index].fetch_add(1, Ordering::Relaxed);
+
+        quota
+    }
+}
+
+impl Tenant {
+    /// Gets the name of the tenant, which is `None` for requests of no tenant.
+    pub fn name(&self) -> Option<&str> {
+        self.namespace
+            .as_ref()
+            .map(|namespace| namespace.name.as_str())
+    }
+
+    /// Whether the job belongs to the tenant, where requests of no tenant only see jobs of no tenant.
+    pub fn owns(&self, record: &JobRecord) -> bool {
+        record.tenant.as_deref() == self.name()
+    }
+
+    /// Admits a submission of the tenant, unless the tenant has as many submissions as it may have at once, or used up
+    /// its CPU time for today.
+    pub fn admit(&self) -> Result<TenantPermit, Quota> {
+        let Some(namespace) = &self.namespace else {
+            return Ok(TenantPermit { _permit: None });
+        };
+
+        let daily_millis = namespace.quotas.daily_cpu_seconds.saturating_mul(1000);
+        let (day, used) = *namespace.today.lock().expect("tenant usage lock poisoned");
+        if daily_millis > 0 && day == today() && used >= daily_millis {
+            return Err(namespace.reject(Quota::CpuTime));
+        }
+        let permit = namespace
+            .jobs
+            .clone()
+            .try_acquire_owned()
+            .map_err(|_| namespace.reject(Quota::Jobs))?;
+        namespace.submissions.fetch_add(1, Ordering::Relaxed);
+
+        Ok(TenantPermit {
+            _permit: Some(permit),
+        })
+    }
+
+    /// Charges the tenant for the CPU time the test cases of a checked submission took, which is their wall-clock
+    /// runtime where it could not be measured.
+    pub fn charge(&self, response: &SubmitResponse) {
+        let (Some(namespace), SubmitResponse::Checked(result)) = (&self.namespace, response) else {
+            return;
+        };
+
+        let millis = result.cpu_time();
+        namespace.cpu_millis.fetch_add(millis, Ordering::Relaxed);
+
+        let mut today_usage = namespace.today.lock().expect("tenant usage lock poisoned");
+        let today = today();
+        if today_usage.0 != today {
+            *today_usage = (today, 0);
+        }
+        today_usage.1 += millis;
+    }
+
+    /// Whether the fixtures, blobs, and exercises of the tenant still fit in its storage quota with `size` more bytes.
+    ///
+    /// This reads the sizes of every fixture, blob, and exercise of the tenant, so it should not be called on the
+    /// runtime.
+    pub fn has_room_for(&self, size: u64) -> bool {
+        let Some(namespace) = &self.namespace else {
+            return true;
+        };
+        let limit = namespace.quotas.storage_limit.saturating_mul(1024 * 1024);
+        if limit == 0 {
+            return true;
+        }
+
+        let used = dir_size(&self.config.fixture_dir())
+            + dir_size(&self.config.blob_dir())
+            + dir_size(&self.config.exercise_dir());
+        if used + size > limit {
+            namespace.reject(Quota::Storage);
+            return false;
+        }
+
+        true
+    }
+}
+
+/// Identifies the tenant of every request by its bearer token, which handlers extract as an [`Extension<Tenant>`].
+pub async fn identify(
+    State(tenants): State<Arc<Tenants>>,
+    mut request: Request,
+    next: Next,
+) -> Response {
+    let authorization = request
+        .headers()
+        .get(AUTHORIZATION)
+        .and_then(|value| value.to_str().ok());
+    let tenant = tenants.identify(authorization);
+    request.extensions_mut().insert(tenant);
+
+    next.run(request).await
+}
+
+/// The path of a request for a job, which may have further parameters, e.g. the id of a test case of the job.
+#[derive(Deserialize)]
+pub struct JobPath {
+    id: Uuid,
+}
+
+/// Responds to requests for a job of another tenant as if the job did not exist, so tenants never see the jobs of
+/// each other.
+pub async fn confine(
+    State(jobs): State<JobStore>,
+    Path(JobPath { id }): Path<JobPath>,
+    Extension(tenant): Extension<Tenant>,
+    request: Request,
+    next: Next,
+) -> Response {
+    match jobs.record(id) {
+        Some(record) if !tenant.owns(&record) => TaskResponse::NotFound.into_response(),
+        _ => next.run(request).await,
+    }
+}
+
+/// Gets the day since the unix epoch, which starts at midnight UTC.
+fn today() -> u64 {
+    SystemTime::now()
+        .duration_since(UNIX_EPOCH)
+        .map_or(0, |since| since.as_secs() / (24 * 60 * 60))
+}
+
+/// Gets the total size of the files in the directory, which is 0 if it does not exist.
+fn dir_size(dir: &FsPath) -> u64 {
+    let Ok(entries) = fs::read_dir(dir) else {
+        return 0;
+    };
+
+    entries
+        .flatten()
+        .filter_map(|entry| entry.metadata().ok())
+        .filter(|metadata| metadata.is_file())
+        .map(|metadata| metadata.len())
+        .sum()
+}
+
+#[cfg(test)]
+mod quotas {
+    use super::{Quota, Tenants};
+    use crate::{
+        config::{Config, TenantConfig},
+        exercise::Exercises,
+        fixture::Fixtures,
+        model::{SubmissionResult, TestCaseResult, TestResult},
+        response::SubmitResponse,
+    };
+    use std::{
+        collections::{BTreeMap, HashMap},
+        sync::Arc,
+    };
+
+    fn tenants(quotas: TenantConfig) -> Tenants {
+        let config = Config {
+            tenants: HashMap::from([(String::from("course"), quotas)]),
+            ..Config::default()
+        };
+        let fixtures = Arc::new(Fixtures::from(&config));
+        let exercises = Arc::new(Exercises::from(&config));
+
+        Tenants::new(Arc::new(config), fixtures, exercises)
+    }
+
+    #[test]
+    fn identify() {
+        let tenants = tenants(TenantConfig {
+            tokens: vec![String::from("course-token")],
+            ..TenantConfig::default()
+        });
+
+        let tenant = tenants.identify(Some("Bearer course-token"));
+        let other = tenants.identify(Some("Bearer other-token"));
+
+        assert_eq!(tenant.name(), Some("course"));
+        assert!(tenant.config.fixture_dir().ends_with(".tenants/course"));
+        assert_eq!(other.name(), None);
+        assert_eq!(tenants.identify(None).name(), None);
+    }
+
+    #[test]
+    fn job_quota() {
+        let tenants = tenants(TenantConfig {
+            tokens: vec![String::from("course-token")],
+            max_jobs: 1,
+            ..TenantConfig::default()
+        });
+        let tenant = tenants.identify(Some("Bearer course-token"));
+
+        let permit = tenant.admit();
+        assert!(permit.is_ok());
+        assert_eq!(tenant.admit().err(), Some(Quota::Jobs));
+        drop(permit);
+        assert!(tenant.admit().is_ok());
+    }
+
+    #[test]
+    fn cpu_quota() {
+        let tenants = tenants(TenantConfig {
+            tokens: vec![String::from("course-token")],
+            daily_cpu_seconds: 1,
+            ..TenantConfig::default()
+        });
+        let tenant = tenants.identify(Some("Bearer course-token"));
+        let test_case_result = TestCaseResult {
+            id: 0,
+            name: None,
+            test_result: TestResult::Pass,
+            hidden: false,
+            stderr: String::new(),
+            runtime: 2000,
+            memory: None,
+            user_time: Some(600),
+            system_time: Some(400),
+            cause: None,
+            benchmark: None,
+            attachments: BTreeMap::new(),
+        };
+
+        tenant.charge(&SubmitResponse::Checked(SubmissionResult::checked(
+            String::new(),
+            Box::new([test_case_result]),
+        )));
+        let mut metrics = String::new();
+        let rejected = tenant.admit();
+        tenants.render(&mut metrics);
+
+        assert_eq!(rejected.err(), Some(Quota::CpuTime));
+        assert!(metrics.contains("mozart_tenant_cpu_seconds_total{tenant=\"course\"} 1\n"));
+        assert!(metrics.contains(
+            "mozart_tenant_quota_rejections_total{tenant=\"course\",quota=\"cpuTime\"} 1\n"
+        ));
+    }
+}
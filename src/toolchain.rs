@@ -0,0 +1,341 @@
+use crate::{
+    cache::CompileCache,
+    cancel::Cancellation,
+    config::{Config, SeccompProfile},
+    error::ConfigError,
+    judge,
+    model::{Language, Submission, TestResult, Verdict},
+    plugin::VerdictPlugins,
+    response::SubmitResponse,
+    runner,
+    sandbox::Sandbox,
+    warm::WarmPool,
+};
+use serde::Serialize;
+use serde_json::json;
+use std::{
+    collections::{BTreeMap, HashMap},
+    sync::{Arc, RwLock},
+};
+use tokio::task;
+use tracing::{error, info, warn};
+use uuid::Uuid;
+
+/// The toolchain of a supported language as it is configured, so clients can show what solutions are compiled with.
+#[derive(Serialize)]
+pub struct Toolchain {
+    language: Language,
+    /// The docker image of the language, which is left out if commands are executed on the host.
+    #[serde(skip_serializing_if = "Option::is_none")]
+    image: Option<String>,
+    compiler: String,
+    flags: Vec<String>,
+    /// The command which solutions are analyzed with, which is empty if the language has no linter.
+    linter: Vec<String>,
+    /// The version of the compiler, which is null if it could not be detected on startup.
+    version: Option<String>,
+    #[serde(rename = "pinnedVersion")]
+    pinned_version: Option<String>,
+    #[serde(rename = "allowedImports")]
+    allowed_imports: Option<Vec<String>>,
+    #[serde(rename = "blockedImports")]
+    blocked_imports: Vec<String>,
+    seccomp: SeccompProfile,
+    /// The other versions submissions may ask for by name, along with the image which has each of them.
+    versions: BTreeMap<String, String>,
+}
+
+/// The versions of the toolchains of the supported languages, as they were detected when they were preheated.
+#[derive(Default)]
+pub struct Toolchains {
+    versions: RwLock<HashMap<Language, String>>,
+    /// How far the toolchain of every language which was preheated got, where the others are cold.
+    heat: RwLock<HashMap<Language, Heat>>,
+    /// Why the toolchains which failed the self-test failed it, which is `None` until every toolchain was tested.
+    failures: RwLock<Option<Vec<(Language, String)>>>,
+}
+
+/// How far preheating the toolchain of a language got.
+#[derive(Serialize, Clone, Debug, PartialEq)]
+#[serde(rename_all = "camelCase", tag = "state", content = "error")]
+pub enum Heat {
+    /// The toolchain was never preheated, so the first submission to it pulls its image and starts its warm containers.
+    Cold,
+    Heating,
+    Ready,
+    /// The toolchain could not be run, or does not have its pinned version.
+    Failed(String),
+}
+
+/// How far preheating the toolchain of a language got, as it is reported by the readiness checks.
+#[derive(Serialize, Debug, PartialEq)]
+pub struct Readiness {
+    language: Language,
+    #[serde(flatten)]
+    heat: Heat,
+}
+
+impl Toolchains {
+    /// Preheats the toolchain of every language which is preheated on startup, leaving the others cold.
+    ///
+    /// Fails if a version does not satisfy the pinned version of its language. A version which cannot be detected
+    /// is only logged, as the sandbox may become available later, which the readiness checks report.
+    pub async fn detect(&self, config: &Arc<Config>) -> Result<(), ConfigError> {
+        for language in config.preheated() {
+            self.preheat(language, config).await?;
+        }
+
+        Ok(())
+    }
+
+    /// Detects the version of the toolchain of the language by running its compiler in its sandbox, which pulls the
+    /// image of the language unless docker already has it, failing if the version does not satisfy the pinned version.
+    ///
+    /// How far preheating got is recorded either way, so the readiness checks report a toolchain which failed.
+    pub async fn preheat(
+        &self,
+        language: Language,
+        config: &Arc<Config>,
+    ) -> Result<(), ConfigError> {
+        self.set_heat(language, Heat::Heating);
+        let detecting = config.clone();
+        let detected = task::spawn_blocking(move || runner::detect_version(language, &detecting))
+            .await
+            .ok()
+            .flatten();
+        let pinned = config.language(language).version.as_deref();
+
+        match detected {
+            Some(Ok(version)) => {
+                if let Some(pinned) = pinned.filter(|pinned| !runner::satisfies(&version, pinned)) {
+                    let err = ConfigError::PinnedVersion {
+                        language,
+                        version,
+                        pinned: pinned.to_string(),
+                    };
+                    self.set_heat(language, Heat::Failed(err.to_string()));
+                    return Err(err);
+                }
+
+                info!(%language, version, "detected toolchain");
+                self.versions
+                    .write()
+                    .expect("toolchains lock poisoned")
+                    .insert(language, version);
+                self.set_heat(language, Heat::Ready);
+            }
+            Some(Err(err)) => {
+                warn!(%language, %err, pinned, "failed to detect the toolchain version");
+                self.set_heat(language, Heat::Failed(err.to_string()));
+            }
+            // the language is not supported by this build
+            None => self.set_heat(language, Heat::Cold),
+        }
+
+        Ok(())
+    }
+
+    /// Gets how far preheating the toolchain of every supported language got.
+    pub fn readiness(&self) -> Vec<Readiness> {
+        let heat = self.heat.read().expect("toolchains lock poisoned");
+
+        Language::ALL
+            .into_iter()
+            .filter(Language::is_supported)
+            .map(|language| Readiness {
+                language,
+                heat: heat.get(&language).cloned().unwrap_or(Heat::Cold),
+            })
+            .collect()
+    }
+
+    /// Marks the toolchain of the language as heating, unless it already is, returning whether it was marked.
+    pub fn start_heating(&self, language: Language) -> bool {
+        let mut heat = self.heat.write().expect("toolchains lock poisoned");
+        match heat.get(&language) {
+            Some(Heat::Heating) => false,
+            _ => {
+                heat.insert(language, Heat::Heating);
+                true
+            }
+        }
+    }
+
+    fn set_heat(&self, language: Language, heat: Heat) {
+        self.heat
+            .write()
+            .expect("toolchains lock poisoned")
+            .insert(language, heat);
+    }
+
+    /// Checks a known-good solution in every language which is preheated on startup like a submission, which compiles it
+    /// and runs its test case in the sandbox of the language, so a broken toolchain is found before a submission to it
+    /// is.
+    ///
+    /// A toolchain which fails is logged, and fails the readiness checks until mozart is restarted.
+    pub fn self_test(&self, config: &Config, cache: &CompileCache) {
+        let mut failures = Vec::new();
+        for language in config.preheated() {
+            match self_test(language, config, cache) {
+                Ok(()) => info!(%language, "the toolchain passed the self-test"),
+                Err(err) => {
+                    error!(%language, err, "the toolchain failed the self-test");
+                    failures.push((language, err));
+                }
+            }
+        }
+
+        *self.failures.write().expect("toolchains lock poisoned") = Some(failures);
+    }
+
+    /// Checks that every toolchain passed the self-test, which fails while it is still running.
+    pub fn check(&self) -> Result<(), String> {
+        let failures = self.failures.read().expect("toolchains lock poisoned");
+        match failures.as_deref() {
+            None => Err(String::from("the toolchains are still being tested")),
+            Some([]) => Ok(()),
+            Some(failures) => Err(failures
+                .iter()
+                .map(|(language, err)| format!("{language} failed the self-test: {err}"))
+                .collect::<Vec<_>>()
+                .join("; ")),
+        }
+    }
+
+    /// Gets the version of the toolchain of the language, if it was detected.
+    pub fn version(&self, language: Language) -> Option<String> {
+        self.versions
+            .read()
+            .expect("toolchains lock poisoned")
+            .get(&language)
+            .cloned()
+    }
+
+    /// Gets the toolchain of every supported language.
+    pub fn list(&self, config: &Config) -> Vec<Toolchain> {
+        let versions = self.versions.read().expect("toolchains lock poisoned");
+
+        Language::ALL
+            .into_iter()
+            .filter_map(|language| {
+                let compiler = runner::compiler(language, config)?;
+                let image = match runner::sandbox(language, config)? {
+                    Sandbox::Docker { image, .. } => Some(image),
+                    Sandbox::Host | Sandbox::Dev | Sandbox::Warm { .. } | Sandbox::Fake => None,
+                };
+                let settings = config.language(language);
+
+                Some(Toolchain {
+                    language,
+                    image,
+                    compiler: compiler.program().to_string(),
+                    flags: compiler.flags().into_iter().map(str::to_string).collect(),
+                    linter: runner::linter(language, config).unwrap_or_default(),
+                    version: versions.get(&language).cloned(),
+                    pinned_version: settings.version.clone(),
+                    allowed_imports: settings.allowed_imports.clone(),
+                    blocked_imports: settings.blocked_imports.clone(),
+                    seccomp: settings.seccomp,
+                    versions: settings.versions.clone(),
+                })
+            })
+            .collect()
+    }
+}
+
+/// Gets a solution in the language which returns 5, without taking any parameters.
+fn known_good_solution(language: Language) -> &'static str {
+    match language {
+        Language::Haskell => "solution = 5",
+        Language::Python => "def solution():\n    return 5\n",
+        Language::Go => "func solution() int {\n\treturn 5\n}\n",
+        Language::C => "long long solution(void) { return 5; }\n",
+        Language::Java => "static long solution() { return 5; }\n",
+        Language::Sql => "SELECT 5;\n",
+    }
+}
+
+/// Checks the known-good solution of the language, which fails with why it did not pass its test case.
+///
+/// The solution is never run in a warm container, so the toolchain is tested in a fresh sandbox of the language.
+fn self_test(language: Language, config: &Config, cache: &CompileCache) -> Result<(), String> {
+    let submission: Submission = serde_json::from_value(json!({
+        "language": language,
+        "solution": known_good_solution(language),
+        "testCases": [{ "id": 0, "inputParameters": [], "outputParameters": [{ "valueType": "int", "value": "5" }] }]
+    }))
+    .expect("the self-test should be a valid submission");
+
+    let response = judge::judge(
+        Uuid::new_v4(),
+        submission,
+        config,
+        cache,
+        &WarmPool::default(),
+        &VerdictPlugins::default(),
+        &Cancellation::default(),
+        &|_| {},
+        None,
+    );
+    let result = match response {
+        SubmitResponse::Checked(result) => result,
+        SubmitResponse::InvalidSubmission(invalid) => return Err(invalid.detail),
+        SubmitResponse::TimedOut => return Err(String::from("the judgment timed out")),
+        // the judgment logs why, e.g. as the compiler could not be run
+        _ => {
+            return Err(String::from(
+                "the sandbox failed to compile or run the solution",
+            ))
+        }
+    };
+
+    match (&result.verdict, result.test_case_results.first()) {
+        (Verdict::Pass, _) => Ok(()),
+        (Verdict::CompilationError, _) => Err(format!(
+            "the solution failed to compile: {}",
+            result.compile_output.trim()
+        )),
+        (_, Some(test_case_result)) => {
+            let reason = match &test_case_result.test_result {
+                TestResult::Failure(reason) => reason.kind().as_str(),
+                _ => "unknown",
+            };
+            Err(format!(
+                "the test case failed with {reason}: {}",
+                test_case_result.stderr.trim()
+            ))
+        }
+        (_, None) => Err(String::from("the test case was not run")),
+    }
+}
+
+#[cfg(test)]
+mod self_test {
+    use super::Toolchains;
+    use crate::model::Language;
+
+    #[test]
+    fn reports_failures() {
+        let toolchains = Toolchains::default();
+        let pending = toolchains.check();
+        *toolchains.failures.write().unwrap() = Some(vec![
+            (
+                Language::Go,
+                String::from("the solution failed to compile: go: not found"),
+            ),
+            (Language::C, String::from("the test case was not run")),
+        ]);
+
+        let actual = toolchains.check();
+
+        assert!(pending.is_err());
+        assert_eq!(
+            actual,
+            Err(String::from(
+                "go failed the self-test: the solution failed to compile: go: not found; c failed the self-test: the test case was not run"
+            ))
+        );
+        *toolchains.failures.write().unwrap() = Some(Vec::new());
+        assert!(toolchains.check().is_ok());
+    }
+}
@@ -0,0 +1,370 @@
+use crate::{
+    config::{Config, SeccompProfile},
+    model::Language,
+    runner,
+    sandbox::Sandbox,
+};
+use std::{
+    collections::HashMap,
+    fs,
+    path::{Path, PathBuf},
+    process::{Command, Stdio},
+    sync::{Arc, Mutex},
+    thread,
+    time::{Duration, Instant},
+};
+use tracing::{debug, info, warn};
+
+/// The directory in the workspace directory which the directories of warm containers are created in.
+const WARM_DIR: &str = "warm";
+
+/// How many submissions use a warm container before it is replaced, as the processes a solution leaves behind are never
+/// reaped by the idling process of the container.
+const MAX_USES: usize = 100;
+
+/// How long shutting down waits for the containers which are still in use, or still starting, to be removed.
+const SHUTDOWN_TIMEOUT: Duration = Duration::from_secs(10);
+
+/// How often shutting down checks whether every container was removed.
+const SHUTDOWN_POLL_INTERVAL: Duration = Duration::from_millis(50);
+
+/// The number of bytes in a mebibyte.
+const MEBIBYTE: u64 = 1024 * 1024;
+
+/// Docker containers of every language with a warm pool, which are started ahead of time, so that the test cases of a
+/// submission are executed in a running container rather than in a fresh container each.
+///
+/// A submission checks out an idle container of its language, which is reset once the submission is done, and then
+/// checked out by the next submission. A submission for which no container is idle runs its test cases in fresh
+/// containers instead. A container which cannot be reset is replaced in the background.
+#[derive(Default)]
+pub struct WarmPool {
+    languages: HashMap<Language, Arc<Containers>>,
+}
+
+/// The warm containers of a single language.
+struct Containers {
+    language: Language,
+    /// The docker sandbox of the language, from which the containers are warmed up.
+    sandbox: Sandbox,
+    /// The directory the directory of every container is created in.
+    dir: PathBuf,
+    seccomp: SeccompProfile,
+    /// The disk limit in bytes, which sizes the tmpfs of every container.
+    disk: u64,
+    /// The memory limit in bytes every container starts with, which is updated for every execution.
+    memory: u64,
+    /// How many containers are kept, whether they are idle, in use, or starting.
+    size: usize,
+    state: Mutex<State>,
+}
+
+#[derive(Default)]
+struct State {
+    /// The containers which can be checked out, along with how many submissions have used each of them.
+    idle: Vec<(Sandbox, usize)>,
+    /// How many containers there are, whether they are idle, in use, or starting.
+    count: usize,
+    shutting_down: bool,
+}
+
+/// A warm container checked out by a submission, which is reset and returned in the background once dropped.
+pub struct Checkout {
+    containers: Arc<Containers>,
+    /// The sandbox of the container, which is only taken when the checkout is dropped.
+    sandbox: Option<Sandbox>,
+    dir: PathBuf,
+    /// How many submissions have used the container, including this one.
+    uses: usize,
+}
+
+impl WarmPool {
+    /// Creates the containers of every supported language which has a warm pool, without starting any of them yet.
+    pub fn from_config(config: &Config) -> Self {
+        let parent = config.workspace_dir().join(WARM_DIR);
+        let languages = Language::ALL
+            .into_iter()
+            .filter_map(|language| {
+                let settings = config.language(language);
+                let sandbox =
+                    runner::sandbox(language, config).filter(|_| settings.warm_pool > 0)?;
+                let containers = Containers {
+                    language,
+                    sandbox,
+                    dir: parent.join(language.as_str()),
+                    seccomp: settings.seccomp,
+                    disk: config.disk_limit.saturating_mul(MEBIBYTE),
+                    memory: config.memory_limit.saturating_mul(MEBIBYTE),
+                    size: settings.warm_pool,
+                    state: Mutex::default(),
+                };
+
+                Some((language, Arc::new(containers)))
+            })
+            .collect();
+
+        Self { languages }
+    }
+
+    /// Removes the containers left behind by a previous run, then starts the containers of the languages which are
+    /// preheated in the background, while the others are started once their language is preheated or checked out.
+    pub fn start(&self, preheated: &[Language]) {
+        for containers in self.languages.values() {
+            containers.remove_left_over();
+            if preheated.contains(&containers.language) {
+                containers.replenish();
+            }
+        }
+    }
+
+    /// Starts the missing containers of the language in the background, if it has a warm pool.
+    pub fn preheat(&self, language: Language) {
+        if let Some(containers) = self.languages.get(&language) {
+            containers.replenish();
+        }
+    }
+
+    /// Checks out an idle container of the language, if the language has a warm pool and one of its containers is idle.
+    ///
+    /// A pool which has fewer containers than it should, as starting one of them failed, is replenished in the
+    /// background.
+    pub fn checkout(&self, language: Language) -> Option<Checkout> {
+        let containers = self.languages.get(&language)?;
+        let idle = containers.lock().idle.pop();
+        let Some((sandbox, uses)) = idle else {
+            containers.replenish();
+            return None;
+        };
+        let Sandbox::Warm { dir, .. } = &sandbox else {
+            unreachable!("only warm sandboxes are kept in a warm pool")
+        };
+
+        Some(Checkout {
+            containers: containers.clone(),
+            dir: dir.clone(),
+            sandbox: Some(sandbox),
+            uses: uses + 1,
+        })
+    }
+
+    /// Removes every container, where those which are in use or starting are removed as soon as they are returned or
+    /// started, which is waited for up to a timeout.
+    pub fn shutdown(&self) {
+        for containers in self.languages.values() {
+            let idle = {
+                let mut state = containers.lock();
+                state.shutting_down = true;
+                std::mem::take(&mut state.idle)
+            };
+            for (sandbox, _) in idle {
+                containers.discard(&sandbox);
+            }
+        }
+
+        let deadline = Instant::now() + SHUTDOWN_TIMEOUT;
+        while self
+            .languages
+            .values()
+            .any(|containers| containers.lock().count > 0)
+        {
+            if Instant::now() >= deadline {
+                warn!("left behind warm containers which are still in use");
+                return;
+            }
+            thread::sleep(SHUTDOWN_POLL_INTERVAL);
+        }
+    }
+}
+
+impl Containers {
+    fn lock(&self) -> std::sync::MutexGuard<'_, State> {
+        self.state.lock().expect("warm pool lock poisoned")
+    }
+
+    /// Starts as many containers in the background as are missing, unless the pool is shutting down.
+    fn replenish(self: &Arc<Self>) {
+        let missing = {
+            let mut state = self.lock();
+            let missing = match state.shutting_down {
+                true => 0,
+                false => self.size.saturating_sub(state.count),
+            };
+            state.count += missing;
+            missing
+        };
+
+        for _ in 0..missing {
+            let containers = self.clone();
+            thread::spawn(move || containers.start_one());
+        }
+    }
+
+    fn start_one(&self) {
+        match self
+            .sandbox
+            .warm_up(&self.dir, self.seccomp, self.disk, self.memory)
+        {
+            Ok(sandbox) => {
+                debug!(language = %self.language, "started warm container");
+                self.add(sandbox, 0);
+            }
+            Err(err) => {
+                warn!(%err, language = %self.language, "failed to start warm container");
+                self.lock().count -= 1;
+            }
+        }
+    }
+
+    /// Makes the container available to be checked out, unless the pool is shutting down, in which case it is removed.
+    fn add(&self, sandbox: Sandbox, uses: usize) {
+        let mut state = self.lock();
+        if state.shutting_down {
+            drop(state);
+            self.discard(&sandbox);
+            return;
+        }
+
+        state.idle.push((sandbox, uses));
+    }
+
+    /// Resets a container which is no longer in use so it can be checked out again, or replaces it if it cannot be
+    /// reset, or has been used by enough submissions.
+    fn release(self: &Arc<Self>, sandbox: Sandbox, uses: usize) {
+        if uses < MAX_USES {
+            match sandbox.reset() {
+                Ok(()) => {
+                    self.add(sandbox, uses);
+                    return;
+                }
+                Err(err) => {
+                    warn!(%err, language = %self.language, "failed to reset warm container")
+                }
+            }
+        }
+
+        self.discard(&sandbox);
+        self.replenish();
+    }
+
+    fn discard(&self, sandbox: &Sandbox) {
+        if let Err(err) = sandbox.remove() {
+            warn!(%err, language = %self.language, "failed to remove warm container");
+        }
+        self.lock().count -= 1;
+    }
+
+    /// Removes every container of a previous run, each of which has a directory named like the container.
+    fn remove_left_over(&self) {
+        let Ok(entries) = fs::read_dir(&self.dir) else {
+            return;
+        };
+
+        let mut removed = 0;
+        for entry in entries.flatten() {
+            let _ = Command::new("docker")
+                .arg("rm")
+                .arg("--force")
+                .arg(entry.file_name())
+                .stdout(Stdio::null())
+                .stderr(Stdio::null())
+                .status();
+            if fs::remove_dir_all(entry.path()).is_ok() {
+                removed += 1;
+            }
+        }
+        if removed > 0 {
+            info!(removed, language = %self.language, "removed left over warm containers");
+        }
+    }
+}
+
+impl Checkout {
+    /// Gets the sandbox executing commands in the container.
+    pub fn sandbox(&self) -> &Sandbox {
+        self.sandbox
+            .as_ref()
+            .expect("the sandbox is only taken when dropped")
+    }
+
+    /// Gets the directory which is mounted into the container, in which the workspace of the submission is created.
+    pub fn dir(&self) -> &Path {
+        &self.dir
+    }
+}
+
+impl Drop for Checkout {
+    fn drop(&mut self) {
+        if let Some(sandbox) = self.sandbox.take() {
+            let containers = self.containers.clone();
+            let uses = self.uses;
+            // resetting takes a few docker commands, which the submission does not have to wait for
+            thread::spawn(move || containers.release(sandbox, uses));
+        }
+    }
+}
+
+#[cfg(test)]
+mod checkout {
+    use super::{Containers, State, WarmPool};
+    use crate::{
+        config::{Config, SeccompProfile},
+        model::Language,
+        sandbox::Sandbox,
+    };
+    use std::{
+        collections::HashMap,
+        env,
+        path::PathBuf,
+        sync::{Arc, Mutex},
+    };
+
+    /// Creates a pool of a single idle container, which is never replenished as its sandbox cannot be warmed up.
+    fn pool(dir: PathBuf) -> WarmPool {
+        let containers = Containers {
+            language: Language::Python,
+            sandbox: Sandbox::Host,
+            dir: dir.clone(),
+            seccomp: SeccompProfile::default(),
+            disk: 0,
+            memory: 0,
+            size: 1,
+            state: Mutex::new(State {
+                idle: vec![(
+                    Sandbox::Warm {
+                        container: String::from("mozart-warm"),
+                        dir: dir.join("mozart-warm"),
+                    },
+                    0,
+                )],
+                count: 1,
+                shutting_down: false,
+            }),
+        };
+
+        WarmPool {
+            languages: HashMap::from([(Language::Python, Arc::new(containers))]),
+        }
+    }
+
+    #[test]
+    fn without_pool() {
+        let warm = WarmPool::from_config(&Config::default());
+
+        assert!(warm.checkout(Language::Python).is_none());
+    }
+
+    #[test]
+    fn checks_out_idle_container() {
+        let dir = env::temp_dir().join("mozart-warm-pool");
+        let warm = pool(dir.clone());
+
+        let first = warm.checkout(Language::Python);
+        let second = warm.checkout(Language::Python);
+
+        let first = first.expect("the idle container is checked out");
+        assert_eq!(first.dir(), dir.join("mozart-warm"));
+        assert!(matches!(first.sandbox(), Sandbox::Warm { .. }));
+        assert_eq!(first.uses, 1);
+        assert!(second.is_none());
+    }
+}
@@ -0,0 +1,340 @@
+use crate::{config::Config, error::CheckError, files};
+use std::{
+    collections::BTreeMap,
+    fs, io,
+    os::unix::fs::MetadataExt,
+    path::{Path, PathBuf},
+};
+#[cfg(target_os = "linux")]
+use std::{ffi::CString, os::unix::ffi::OsStrExt};
+use tracing::{debug, error};
+use uuid::Uuid;
+
+/// The directory a single submission is compiled and checked in, named by its task.
+///
+/// The workspace is destroyed when dropped, so it never outlives its submission unless it is retained.
+pub struct Workspace {
+    dir: PathBuf,
+    /// The parent directory the workspace is kept in once destroyed, if workspaces are retained.
+    retained_in: Option<PathBuf>,
+    /// Whether the workspace is a tmpfs of its own, which is unmounted once it is removed.
+    mounted: bool,
+    destroyed: bool,
+}
+
+impl Workspace {
+    /// Creates the workspace of the task in the workspace directory.
+    ///
+    /// Retained workspaces are kept in the `work_dir`, so a workspace on a tmpfs is snapshotted there when destroyed.
+    ///
+    /// If the workspaces are kept on a tmpfs, the workspace is a tmpfs of its own, if mozart may mount one, so the
+    /// sandbox can limit how much an execution writes by its size. Its size is that of the workspace tmpfs.
+    pub fn create(config: &Config, task: Uuid) -> io::Result<Self> {
+        let mut workspace = Self::create_in(&config.workspace_dir(), config, task)?;
+        if config.workspace_tmpfs > 0 {
+            match mount(&workspace.dir, config.workspace_tmpfs) {
+                Ok(()) => workspace.mounted = true,
+                Err(err) => {
+                    debug!(%err, path = %workspace.dir.display(), "workspace is measured as it cannot be a tmpfs")
+                }
+            }
+        }
+
+        Ok(workspace)
+    }
+
+    /// Creates the workspace of the task in the parent directory rather than the workspace directory, e.g. in the
+    /// directory of a warm container.
+    pub fn create_in(parent: &Path, config: &Config, task: Uuid) -> io::Result<Self> {
+        let dir = parent.join(task.to_string());
+        fs::create_dir_all(&dir)?;
+
+        Ok(Self {
+            dir,
+            retained_in: config
+                .workspace_retention()
+                .map(|_| config.work_dir.clone()),
+            mounted: false,
+            destroyed: false,
+        })
+    }
+
+    pub fn dir(&self) -> &Path {
+        &self.dir
+    }
+
+    /// Copies every file and directory of the workspace into the destination, which is created if it does not exist.
+    ///
+    /// Symbolic links are left out, as a solution could link to any file on the host.
+    pub fn snapshot(&self, destination: &Path) -> io::Result<()> {
+        copy_tree(&self.dir, destination)
+    }
+
+    /// Removes the workspace, unless it is retained, in which case it is kept in the `work_dir` for the janitor.
+    pub fn destroy(mut self) -> io::Result<()> {
+        self.destroyed = true;
+        self.remove()
+    }
+
+    fn remove(&self) -> io::Result<()> {
+        if let Some(parent) = &self.retained_in {
+            if self.dir.parent() == Some(parent.as_path()) {
+                return Ok(());
+            }
+            let name = self
+                .dir
+                .file_name()
+                .expect("a workspace is named by its task");
+            self.snapshot(&parent.join(name))?;
+        }
+
+        // the files of the tmpfs go away with it, while its mount point stays behind
+        if self.mounted {
+            unmount(&self.dir)?;
+        }
+        fs::remove_dir_all(&self.dir)?;
+        debug!(path = %self.dir.display(), "removed workspace");
+        Ok(())
+    }
+}
+
+impl Drop for Workspace {
+    fn drop(&mut self) {
+        if !self.destroyed {
+            if let Err(err) = self.remove() {
+                error!(%err, path = %self.dir.display(), "failed to remove workspace");
+            }
+        }
+    }
+}
+
+/// Writes the files of a solution into its workspace, creating the directories they are in, and returns their paths.
+///
+/// The paths are expected to be valid, which the submission is validated for.
+pub fn populate(dir: &Path, files: &BTreeMap<String, String>) -> Result<Vec<PathBuf>, CheckError> {
+    let mut paths = Vec::with_capacity(files.len());
+    for (path, contents) in files {
+        let Some(contents) = files::decode(contents) else {
+            return Err(CheckError::IOInteraction);
+        };
+
+        let path = dir.join(path);
+        let created = match path.parent() {
+            Some(parent) => fs::create_dir_all(parent),
+            None => Ok(()),
+        };
+        if created.and_then(|_| fs::write(&path, contents)).is_err() {
+            return Err(CheckError::IOInteraction);
+        }
+        paths.push(path);
+    }
+
+    Ok(paths)
+}
+
+/// Mounts a tmpfs of the configured size at the workspace directory, unless it is already a tmpfs.
+///
+/// Mounting requires `CAP_SYS_ADMIN`, so an unprivileged mozart has to be given a tmpfs as its `workspace_dir` instead.
+#[cfg(target_os = "linux")]
+pub fn mount_tmpfs(config: &Config) -> io::Result<()> {
+    let dir = config.workspace_dir();
+    fs::create_dir_all(&dir)?;
+    if config.workspace_tmpfs == 0 || is_tmpfs(&dir)? {
+        return Ok(());
+    }
+
+    mount(&dir, config.workspace_tmpfs)
+}
+
+/// Mounts a tmpfs of the size in mebibytes at the directory.
+#[cfg(target_os = "linux")]
+fn mount(dir: &Path, size: u64) -> io::Result<()> {
+    let target = CString::new(dir.as_os_str().as_bytes())?;
+    let options = CString::new(format!("size={size}m,mode=0755"))?;
+    // SAFETY: every pointer is a valid nul terminated string, which outlives the call.
+    let mounted = unsafe {
+        libc::mount(
+            c"tmpfs".as_ptr(),
+            target.as_ptr(),
+            c"tmpfs".as_ptr(),
+            libc::MS_NOSUID | libc::MS_NODEV,
+            options.as_ptr().cast(),
+        )
+    };
+    if mounted != 0 {
+        return Err(io::Error::last_os_error());
+    }
+
+    Ok(())
+}
+
+/// Removes a workspace which was left behind, unmounting it first if it is a tmpfs of its own.
+pub fn remove_left_behind(dir: &Path) -> io::Result<()> {
+    let device = |path: &Path| fs::symlink_metadata(path).map(|metadata| metadata.dev());
+    if let Some(parent) = dir.parent() {
+        if device(dir)? != device(parent)? {
+            unmount(dir)?;
+        }
+    }
+
+    fs::remove_dir_all(dir)
+}
+
+/// Unmounts the tmpfs at the directory, even if a process still uses it, as it is only ever used by the judgment.
+#[cfg(target_os = "linux")]
+fn unmount(dir: &Path) -> io::Result<()> {
+    let target = CString::new(dir.as_os_str().as_bytes())?;
+    // SAFETY: the target is a valid nul terminated string, which outlives the call.
+    if unsafe { libc::umount2(target.as_ptr(), libc::MNT_DETACH) } != 0 {
+        return Err(io::Error::last_os_error());
+    }
+
+    Ok(())
+}
+
+#[cfg(not(target_os = "linux"))]
+fn mount(_dir: &Path, _size: u64) -> io::Result<()> {
+    Err(io::ErrorKind::Unsupported.into())
+}
+
+#[cfg(not(target_os = "linux"))]
+fn unmount(_dir: &Path) -> io::Result<()> {
+    Err(io::ErrorKind::Unsupported.into())
+}
+
+/// Creates the workspace directory, which cannot have a tmpfs mounted at it, as only linux is able to mount one.
+#[cfg(not(target_os = "linux"))]
+pub fn mount_tmpfs(config: &Config) -> io::Result<()> {
+    fs::create_dir_all(config.workspace_dir())?;
+    match config.workspace_tmpfs {
+        0 => Ok(()),
+        _ => Err(io::ErrorKind::Unsupported.into()),
+    }
+}
+
+/// Whether the directory is on a tmpfs.
+#[cfg(target_os = "linux")]
+fn is_tmpfs(dir: &Path) -> io::Result<bool> {
+    let path = CString::new(dir.as_os_str().as_bytes())?;
+    // SAFETY: statfs is a plain C struct, for which all zeroes is a valid value.
+    let mut stat = unsafe { std::mem::zeroed::<libc::statfs>() };
+
+    // SAFETY: the path is a valid nul terminated string, and the struct outlives the call.
+    if unsafe { libc::statfs(path.as_ptr(), &mut stat) } != 0 {
+        return Err(io::Error::last_os_error());
+    }
+
+    #[allow(clippy::unnecessary_cast)]
+    Ok(stat.f_type as i64 == libc::TMPFS_MAGIC as i64)
+}
+
+fn copy_tree(from: &Path, to: &Path) -> io::Result<()> {
+    fs::create_dir_all(to)?;
+    for entry in fs::read_dir(from)? {
+        let entry = entry?;
+        let file_type = entry.file_type()?;
+        let destination = to.join(entry.file_name());
+        if file_type.is_dir() {
+            copy_tree(&entry.path(), &destination)?;
+        } else if file_type.is_file() {
+            fs::copy(entry.path(), destination)?;
+        }
+    }
+
+    Ok(())
+}
+
+#[cfg(test)]
+mod workspaces {
+    use super::{populate, Workspace};
+    use crate::config::Config;
+    use std::{collections::BTreeMap, env, fs, os::unix::fs::symlink, path::PathBuf};
+    use uuid::Uuid;
+
+    fn config(retention: u64, separate: bool) -> Config {
+        let work_dir = env::temp_dir().join(format!("mozart-work-{}", Uuid::new_v4()));
+        Config {
+            workspace_dir: separate.then(|| work_dir.join("workspaces")),
+            work_dir,
+            workspace_retention: retention,
+            ..Config::default()
+        }
+    }
+
+    fn workspace(config: &Config) -> (Workspace, PathBuf) {
+        let task = Uuid::new_v4();
+        let workspace = Workspace::create(config, task).expect("failed to create workspace");
+        fs::write(workspace.dir().join("test.c"), "int main() {}").expect("failed to write file");
+
+        (workspace, config.work_dir.join(task.to_string()))
+    }
+
+    #[test]
+    fn removed_when_dropped() {
+        let config = config(0, false);
+        let (workspace, _) = workspace(&config);
+        let dir = workspace.dir().to_path_buf();
+
+        drop(workspace);
+        let exists = dir.exists();
+        let _ = fs::remove_dir_all(&config.work_dir);
+
+        assert!(!exists);
+    }
+
+    #[test]
+    fn retained_in_place() {
+        let config = config(60, false);
+        let (workspace, retained) = workspace(&config);
+
+        let actual = workspace.destroy();
+        let exists = retained.join("test.c").exists();
+        let _ = fs::remove_dir_all(&config.work_dir);
+
+        assert!(actual.is_ok());
+        assert!(exists);
+    }
+
+    #[test]
+    fn retained_as_snapshot() {
+        let config = config(60, true);
+        let (workspace, retained) = workspace(&config);
+        let dir = workspace.dir().to_path_buf();
+        fs::create_dir(dir.join("output")).expect("failed to create directory");
+        fs::write(dir.join("output/0"), "p").expect("failed to write file");
+        symlink("/etc/passwd", dir.join("passwd")).expect("failed to create link");
+
+        let actual = workspace.destroy();
+        let snapshot = (
+            dir.exists(),
+            fs::read_to_string(retained.join("output/0")),
+            retained.join("passwd").exists(),
+        );
+        let _ = fs::remove_dir_all(&config.work_dir);
+
+        assert!(actual.is_ok());
+        assert!(!snapshot.0);
+        assert_eq!(snapshot.1.unwrap(), "p");
+        assert!(!snapshot.2);
+    }
+
+    #[test]
+    fn populates_nested_files() {
+        let dir = env::temp_dir().join(format!("mozart-files-{}", Uuid::new_v4()));
+        fs::create_dir(&dir).unwrap();
+        let files = BTreeMap::from([
+            (String::from("go.mod"), String::from("bW9kdWxlIHRlc3QK")),
+            (
+                String::from("util/util.go"),
+                String::from("cGFja2FnZSB1dGlsCg=="),
+            ),
+        ]);
+
+        let actual = populate(&dir, &files);
+        let nested = fs::read_to_string(dir.join("util/util.go"));
+        let _ = fs::remove_dir_all(&dir);
+
+        assert_eq!(actual.unwrap().len(), 2);
+        assert_eq!(nested.unwrap(), "package util\n");
+    }
+}